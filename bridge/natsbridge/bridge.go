@@ -0,0 +1,427 @@
+// Package natsbridge implements ws.DirectBridge on NATS, so the hubs of
+// several nodes sharing a NATS cluster reach each other's clients:
+//
+//	nc, err := nats.Connect(nats.DefaultURL, nats.MaxReconnects(-1))
+//	bridge, err := natsbridge.New(nc)
+//	defer bridge.Close()
+//	handler := ws.NewWebSocketHandler(validator, messages, persister, ws.WithBridge(bridge))
+//
+// Broadcasts go to a subject of their own and room broadcasts to one subject
+// per room, which only nodes with members of the room subscribe to. Nodes
+// announce the identities connected to them on a presence subject, so a
+// directed send goes straight to the inbox subject of the nodes its
+// recipient is connected to, and SendTo reports ws.ErrClientNotConnected
+// when no node has it. Every message is tagged with the bridge's instance ID
+// in a header and a bridge drops the messages it published itself.
+//
+// The bridge survives reconnections: subscriptions are restored by the NATS
+// client, and presence is announced again once it reconnects. With
+// WithJetStream, inboxes are kept in a stream, so directed sends to a node
+// that is temporarily down are delivered when it comes back under the same
+// instance ID.
+package natsbridge
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// InstanceHeader is the header every message is tagged with the publishing
+// node's instance ID in.
+const InstanceHeader = "Ws-Instance"
+
+// requestTimeout bounds the JetStream requests the bridge makes.
+const requestTimeout = 5 * time.Second
+
+// Subjects names the subjects a bridge uses, so they can fit an existing
+// account and permission scheme.
+type Subjects struct {
+	// Topic maps a hub topic, ws.BridgeTopic or a ws.RoomTopic, to the
+	// subject it is published on. Room names must then make valid subject
+	// tokens, or Topic must escape them.
+	Topic func(topic string) string
+
+	// Inbox is the prefix of the node inboxes: a node receives directed
+	// sends on Inbox + "." + its instance ID.
+	Inbox string
+
+	// Presence is the subject nodes announce their identities on.
+	Presence string
+}
+
+// DefaultSubjects returns subjects under prefix: prefix.hub for broadcasts,
+// prefix.room.<name> for rooms, prefix.inbox.<instance> and prefix.presence.
+// New uses DefaultSubjects("ws").
+func DefaultSubjects(prefix string) Subjects {
+	return Subjects{
+		Topic:    func(topic string) string { return prefix + "." + topic },
+		Inbox:    prefix + ".inbox",
+		Presence: prefix + ".presence",
+	}
+}
+
+// Option configures a Bridge.
+type Option func(*Bridge)
+
+// WithSubjects replaces DefaultSubjects("ws").
+func WithSubjects(subjects Subjects) Option {
+	return func(b *Bridge) {
+		b.subjects = subjects
+	}
+}
+
+// WithInstanceID sets the node's instance ID, which must be unique among
+// the nodes and a valid subject token. The default is a random UUID; set a
+// stable one with WithJetStream so a restarted node gets its inbox back.
+func WithInstanceID(id string) Option {
+	return func(b *Bridge) {
+		b.instance = id
+	}
+}
+
+// WithJetStream delivers directed sends through the named JetStream stream,
+// created on the inbox subjects if it does not exist, instead of core NATS.
+// Each node consumes its inbox through a durable consumer named after its
+// instance ID, so sends to a node that is down wait in the stream for up to
+// maxAge, or indefinitely when maxAge is zero.
+func WithJetStream(stream string, maxAge time.Duration) Option {
+	return func(b *Bridge) {
+		b.stream = stream
+		b.maxAge = maxAge
+	}
+}
+
+// Bridge implements ws.DirectBridge. It is safe for concurrent use.
+type Bridge struct {
+	nc       *nats.Conn
+	subjects Subjects
+	instance string
+	stream   string
+	maxAge   time.Duration
+
+	js        jetstream.JetStream
+	consuming jetstream.ConsumeContext
+
+	mu          sync.Mutex
+	subs        []*nats.Subscription
+	local       map[ws.Identity]struct{}
+	remote      map[ws.Identity]map[string]struct{}
+	reconnected nats.ConnHandler
+	closed      bool
+}
+
+// presence is a node's announcement on the presence subject.
+type presence struct {
+	Event    string        `json:"event"`
+	Instance string        `json:"instance"`
+	IDs      []ws.Identity `json:"ids,omitempty"`
+}
+
+const (
+	// eventOnline and eventOffline report identities gaining their first
+	// and losing their last connection to a node.
+	eventOnline  = "online"
+	eventOffline = "offline"
+
+	// eventHello is sent by a node joining or rejoining the cluster. The
+	// others forget what they knew of it and announce their identities.
+	eventHello = "hello"
+
+	// eventBye is sent by a node leaving the cluster.
+	eventBye = "bye"
+)
+
+// New returns a bridge publishing and subscribing through nc, and announces
+// the node on the presence subject. Close the bridge before nc.
+func New(nc *nats.Conn, opts ...Option) (*Bridge, error) {
+	b := &Bridge{
+		nc:       nc,
+		subjects: DefaultSubjects("ws"),
+		instance: uuid.NewString(),
+		local:    make(map[ws.Identity]struct{}),
+		remote:   make(map[ws.Identity]map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.stream != "" {
+		js, err := jetstream.New(nc)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:      b.stream,
+			Subjects:  []string{b.subjects.Inbox + ".*"},
+			Retention: jetstream.WorkQueuePolicy,
+			MaxAge:    b.maxAge,
+		})
+		if err != nil {
+			return nil, err
+		}
+		b.js = js
+	}
+
+	sub, err := nc.Subscribe(b.subjects.Presence, b.receivePresence)
+	if err != nil {
+		return nil, err
+	}
+	b.subs = append(b.subs, sub)
+
+	b.reconnected = nc.Opts.ReconnectedCB
+	nc.SetReconnectHandler(func(conn *nats.Conn) {
+		if b.reconnected != nil {
+			b.reconnected(conn)
+		}
+		b.announce()
+	})
+
+	b.announce()
+	if err := nc.Flush(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// InstanceID returns the node's instance ID.
+func (b *Bridge) InstanceID() string {
+	return b.instance
+}
+
+// Publish sends data to the subscribers of topic on every other node.
+func (b *Bridge) Publish(topic string, data []byte) error {
+	return b.nc.PublishMsg(b.message(b.subjects.Topic(topic), data))
+}
+
+// Subscribe calls fn with every message another node publishes on topic. It
+// returns once the server has the subscription, so nothing published
+// afterwards is missed.
+func (b *Bridge) Subscribe(topic string, fn func(data []byte)) error {
+	sub, err := b.nc.Subscribe(b.subjects.Topic(topic), func(msg *nats.Msg) {
+		if msg.Header.Get(InstanceHeader) != b.instance {
+			fn(msg.Data)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return b.nc.Flush()
+}
+
+// SubscribeDirect calls fn with every directed send to this node's inbox.
+func (b *Bridge) SubscribeDirect(fn func(data []byte)) error {
+	inbox := b.inbox(b.instance)
+	if b.js == nil {
+		sub, err := b.nc.Subscribe(inbox, func(msg *nats.Msg) { fn(msg.Data) })
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.subs = append(b.subs, sub)
+		b.mu.Unlock()
+		return b.nc.Flush()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, b.stream, jetstream.ConsumerConfig{
+		Durable:       b.instance,
+		FilterSubject: inbox,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return err
+	}
+	consuming, err := consumer.Consume(func(msg jetstream.Msg) {
+		fn(msg.Data())
+		msg.Ack()
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.consuming = consuming
+	b.mu.Unlock()
+	return nil
+}
+
+// PublishTo sends data to the inboxes of the other nodes id is connected
+// to, as last announced on the presence subject. It returns
+// ws.ErrClientNotConnected when there are none.
+func (b *Bridge) PublishTo(id ws.Identity, data []byte) error {
+	b.mu.Lock()
+	instances := make([]string, 0, len(b.remote[id]))
+	for instance := range b.remote[id] {
+		instances = append(instances, instance)
+	}
+	b.mu.Unlock()
+	if len(instances) == 0 {
+		return ws.ErrClientNotConnected
+	}
+
+	var lastErr error
+	delivered := false
+	for _, instance := range instances {
+		if err := b.publishDirect(b.inbox(instance), data); err != nil {
+			lastErr = err
+		} else {
+			delivered = true
+		}
+	}
+	if delivered {
+		return nil
+	}
+	return lastErr
+}
+
+func (b *Bridge) publishDirect(subject string, data []byte) error {
+	msg := b.message(subject, data)
+	if b.js == nil {
+		return b.nc.PublishMsg(msg)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := b.js.PublishMsg(ctx, msg)
+	return err
+}
+
+// Track announces that id has its first connection to this node.
+func (b *Bridge) Track(id ws.Identity) {
+	b.mu.Lock()
+	b.local[id] = struct{}{}
+	b.mu.Unlock()
+	b.publishPresence(presence{Event: eventOnline, IDs: []ws.Identity{id}})
+}
+
+// Untrack announces that id has lost its last connection to this node.
+func (b *Bridge) Untrack(id ws.Identity) {
+	b.mu.Lock()
+	delete(b.local, id)
+	b.mu.Unlock()
+	b.publishPresence(presence{Event: eventOffline, IDs: []ws.Identity{id}})
+}
+
+// Close unsubscribes, stops consuming the inbox and tells the other nodes
+// this one is leaving. A JetStream inbox is kept, but nothing is sent to it
+// until the node announces itself again. While disconnected, the goodbye is
+// left to the NATS client to send on reconnection. Close does not close the
+// NATS connection.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	subs, consuming := b.subs, b.consuming
+	b.subs, b.consuming = nil, nil
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	if consuming != nil {
+		consuming.Stop()
+	}
+	b.nc.SetReconnectHandler(b.reconnected)
+	b.publishPresence(presence{Event: eventBye})
+	if !b.nc.IsConnected() {
+		return nil
+	}
+	return b.nc.FlushTimeout(requestTimeout)
+}
+
+// announce says hello and lists the node's identities, on startup and after
+// each reconnection, since announcements may have been missed meanwhile.
+func (b *Bridge) announce() {
+	b.publishPresence(presence{Event: eventHello})
+	b.announceLocal()
+}
+
+func (b *Bridge) announceLocal() {
+	b.mu.Lock()
+	ids := make([]ws.Identity, 0, len(b.local))
+	for id := range b.local {
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+	if len(ids) > 0 {
+		b.publishPresence(presence{Event: eventOnline, IDs: ids})
+	}
+}
+
+func (b *Bridge) publishPresence(p presence) {
+	p.Instance = b.instance
+	data, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b.nc.Publish(b.subjects.Presence, data)
+}
+
+func (b *Bridge) receivePresence(msg *nats.Msg) {
+	var p presence
+	if json.Unmarshal(msg.Data, &p) != nil || p.Instance == b.instance {
+		return
+	}
+
+	switch p.Event {
+	case eventOnline:
+		b.mu.Lock()
+		for _, id := range p.IDs {
+			if b.remote[id] == nil {
+				b.remote[id] = make(map[string]struct{})
+			}
+			b.remote[id][p.Instance] = struct{}{}
+		}
+		b.mu.Unlock()
+	case eventOffline:
+		b.mu.Lock()
+		for _, id := range p.IDs {
+			b.forgetLocked(id, p.Instance)
+		}
+		b.mu.Unlock()
+	case eventHello, eventBye:
+		b.mu.Lock()
+		for id := range b.remote {
+			b.forgetLocked(id, p.Instance)
+		}
+		b.mu.Unlock()
+		if p.Event == eventHello {
+			b.announceLocal()
+		}
+	}
+}
+
+// forgetLocked removes that id is connected to instance. The lock must be
+// held.
+func (b *Bridge) forgetLocked(id ws.Identity, instance string) {
+	delete(b.remote[id], instance)
+	if len(b.remote[id]) == 0 {
+		delete(b.remote, id)
+	}
+}
+
+func (b *Bridge) inbox(instance string) string {
+	return b.subjects.Inbox + "." + instance
+}
+
+func (b *Bridge) message(subject string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(InstanceHeader, b.instance)
+	msg.Data = data
+	return msg
+}
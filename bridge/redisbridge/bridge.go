@@ -0,0 +1,208 @@
+// Package redisbridge implements ws.Bridge on Redis pub/sub, so the hubs of
+// several nodes sharing a Redis server reach each other's clients:
+//
+//	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	bridge := redisbridge.New(rdb)
+//	defer bridge.Close()
+//	handler := ws.NewWebSocketHandler(validator, messages, persister, ws.WithBridge(bridge))
+//
+// Unlike redispersister, the bridge needs a connection held open for its
+// subscriptions, which a one-shot command interface cannot express, so it
+// takes a go-redis client directly.
+//
+// Every message is tagged with the bridge's instance ID and a bridge drops
+// the messages it published itself, so a node never receives its own
+// broadcasts back. Redis pub/sub is fire and forget: messages published
+// while a node is down or reconnecting are lost to it.
+package redisbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrClosed is returned by Subscribe after Close.
+var ErrClosed = errors.New("redisbridge: bridge closed")
+
+// subscribeTimeout bounds how long Subscribe waits for Redis to confirm a
+// subscription.
+const subscribeTimeout = 5 * time.Second
+
+// Bridge implements ws.Bridge. It is safe for concurrent use.
+type Bridge struct {
+	client   redis.UniversalClient
+	prefix   string
+	instance string
+
+	mu            sync.Mutex
+	pubsub        *redis.PubSub
+	handlers      map[string][]func([]byte)
+	confirmations map[string]chan struct{}
+	closed        bool
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// Option configures a Bridge.
+type Option func(*Bridge)
+
+// WithPrefix sets the prefix of every channel the bridge uses, so several
+// applications can share a Redis server. The default is "ws:bridge:".
+func WithPrefix(prefix string) Option {
+	return func(b *Bridge) {
+		b.prefix = prefix
+	}
+}
+
+// WithInstanceID sets the ID the bridge tags its messages with, which must
+// be unique among the nodes and must not contain a newline. The default is
+// a random UUID.
+func WithInstanceID(id string) Option {
+	return func(b *Bridge) {
+		b.instance = id
+	}
+}
+
+// New returns a bridge publishing and subscribing through client.
+func New(client redis.UniversalClient, opts ...Option) *Bridge {
+	b := &Bridge{
+		client:        client,
+		prefix:        "ws:bridge:",
+		instance:      uuid.NewString(),
+		handlers:      make(map[string][]func([]byte)),
+		confirmations: make(map[string]chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// InstanceID returns the ID the bridge tags its messages with.
+func (b *Bridge) InstanceID() string {
+	return b.instance
+}
+
+// Publish sends data to the subscribers of topic on every other node.
+func (b *Bridge) Publish(topic string, data []byte) error {
+	message := make([]byte, 0, len(b.instance)+1+len(data))
+	message = append(message, b.instance...)
+	message = append(message, '\n')
+	message = append(message, data...)
+	return b.client.Publish(context.Background(), b.prefix+topic, message).Err()
+}
+
+// Subscribe calls fn with every message another node publishes on topic.
+// Each topic is its own Redis channel. Subscribe returns once Redis has
+// confirmed the subscription, so nothing published afterwards is missed.
+// Messages are delivered one at a time on the bridge's receive goroutine, so
+// fn should not block.
+func (b *Bridge) Subscribe(topic string, fn func(data []byte)) error {
+	channel := b.prefix + topic
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	if _, ok := b.handlers[channel]; ok {
+		b.handlers[channel] = append(b.handlers[channel], fn)
+		b.mu.Unlock()
+		return nil
+	}
+	b.handlers[channel] = []func([]byte){fn}
+	if b.pubsub == nil {
+		b.pubsub = b.client.Subscribe(context.Background())
+		b.wg.Add(1)
+		go b.receive(b.pubsub.ChannelWithSubscriptions())
+	}
+	confirmed := make(chan struct{})
+	b.confirmations[channel] = confirmed
+	pubsub := b.pubsub
+	b.mu.Unlock()
+
+	err := pubsub.Subscribe(context.Background(), channel)
+	if err == nil {
+		select {
+		case <-confirmed:
+			return nil
+		case <-b.done:
+			err = ErrClosed
+		case <-time.After(subscribeTimeout):
+			err = fmt.Errorf("redisbridge: subscription to %s not confirmed", channel)
+		}
+	}
+
+	b.mu.Lock()
+	delete(b.handlers, channel)
+	delete(b.confirmations, channel)
+	b.mu.Unlock()
+	return err
+}
+
+// Close unsubscribes from every topic and waits for the receive goroutine
+// to finish delivering. It does not close the Redis client.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	pubsub := b.pubsub
+	b.mu.Unlock()
+
+	var err error
+	if pubsub != nil {
+		err = pubsub.Close()
+	}
+	b.wg.Wait()
+	return err
+}
+
+func (b *Bridge) receive(messages <-chan any) {
+	defer b.wg.Done()
+	for message := range messages {
+		switch msg := message.(type) {
+		case *redis.Subscription:
+			b.confirm(msg)
+		case *redis.Message:
+			b.deliver(msg)
+		}
+	}
+}
+
+func (b *Bridge) confirm(sub *redis.Subscription) {
+	if sub.Kind != "subscribe" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if confirmed, ok := b.confirmations[sub.Channel]; ok {
+		close(confirmed)
+		delete(b.confirmations, sub.Channel)
+	}
+}
+
+func (b *Bridge) deliver(msg *redis.Message) {
+	from, data, ok := strings.Cut(msg.Payload, "\n")
+	if !ok || from == b.instance {
+		return
+	}
+
+	b.mu.Lock()
+	handlers := b.handlers[msg.Channel]
+	b.mu.Unlock()
+	for _, fn := range handlers {
+		fn([]byte(data))
+	}
+}
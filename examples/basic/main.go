@@ -8,33 +8,21 @@ import (
 	"github.com/oduortoni/websocket/ws"
 )
 
-type SessionValidator struct{}
-
-func (n *SessionValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
-	return ws.SessionInfo{}, nil
-}
-
-type MessageHandler struct{}
-
-func (n *MessageHandler) Handle(client *ws.Client, data []byte) error {
-	return nil
-}
-
-type EnvelopePersister struct{}
-
-func (n *EnvelopePersister) SaveEnvelope(e ws.Envelope) error {
-	return nil
-}
-
-func (n *EnvelopePersister) ConfirmDelivery(envelopeID ws.Identity, clientID ws.Identity) error {
-	return nil
+// validate accepts every connection. Clients naming a user with ?user= are
+// given that user's stable Identity, so they reconnect as the same client.
+func validate(r *http.Request) (ws.SessionInfo, error) {
+	user := r.URL.Query().Get("user")
+	if user == "" {
+		return ws.SessionInfo{}, nil
+	}
+	return ws.SessionInfo{ClientID: ws.IdentityFromString(ws.DefaultIdentityNamespace, user)}, nil
 }
 
 func main() {
-	sessValidator := &SessionValidator{}
-	messageHandler := &MessageHandler{}
-	envelopePersister := &EnvelopePersister{}
-	ws := ws.NewWebSocketHandler(sessValidator, messageHandler, envelopePersister)
+	messageHandler := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+		return nil
+	})
+	ws := ws.NewWebSocketHandler(ws.ValidatorFunc(validate), messageHandler, ws.NopPersister())
 	http.Handle("/ws", ws)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		page := `<!DOCTYPE html>
@@ -0,0 +1,335 @@
+// Package redispersister implements ws.EnvelopePersister on Redis. Each
+// client's pending envelopes are kept in a sorted set scored by timestamp,
+// with the envelopes themselves stored under their own keys.
+//
+// The persister talks to Redis through the small Commander interface, so any
+// client library can be plugged in. With go-redis:
+//
+//	persister := redispersister.New(redispersister.CommanderFunc(
+//		func(ctx context.Context, args ...any) (any, error) {
+//			return rdb.Do(ctx, args...).Result()
+//		}))
+//
+// With rueidis, build the command with client.B().Arbitrary and return
+// ToAny() of the result.
+package redispersister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// Commander runs a single Redis command and returns its decoded reply:
+// nil, int64, string, []byte or a slice of those.
+type Commander interface {
+	Do(ctx context.Context, args ...any) (any, error)
+}
+
+// CommanderFunc adapts a function to the Commander interface.
+type CommanderFunc func(ctx context.Context, args ...any) (any, error)
+
+func (f CommanderFunc) Do(ctx context.Context, args ...any) (any, error) {
+	return f(ctx, args...)
+}
+
+// Persister implements ws.EnvelopePersister, ws.UndeliveredFetcher and
+// ws.EnvelopeChecker. Expired envelopes are removed by Redis itself, so it
+// does not need ws.ExpiredPurger.
+type Persister struct {
+	redis    Commander
+	prefix   string
+	ttl      time.Duration
+	pageSize int
+}
+
+// Option configures a Persister.
+type Option func(*Persister)
+
+// WithPrefix sets the prefix of every key the persister writes. The default
+// is "ws:".
+func WithPrefix(prefix string) Option {
+	return func(p *Persister) {
+		p.prefix = prefix
+	}
+}
+
+// WithTTL expires envelopes and mailboxes that have not been written for d,
+// so abandoned mailboxes do not accumulate. Zero, the default, keeps them
+// until they are confirmed or reach their own ExpiresAt.
+func WithTTL(d time.Duration) Option {
+	return func(p *Persister) {
+		p.ttl = d
+	}
+}
+
+// WithPageSize sets how many envelopes FetchUndelivered reads per round trip.
+// The default is 100.
+func WithPageSize(n int) Option {
+	return func(p *Persister) {
+		p.pageSize = n
+	}
+}
+
+func New(redis Commander, opts ...Option) *Persister {
+	p := &Persister{redis: redis, prefix: "ws:", pageSize: 100}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// record is the stored form of an envelope.
+type record struct {
+	ID        string          `json:"id"`
+	ClientID  string          `json:"client_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Inbound   bool            `json:"inbound,omitempty"`
+}
+
+func (p *Persister) envelopeKey(id string) string { return p.prefix + "envelope:" + id }
+func (p *Persister) mailboxKey(id string) string  { return p.prefix + "mailbox:" + id }
+
+func (p *Persister) SaveEnvelope(e ws.Envelope) error {
+	return p.SaveEnvelopeContext(context.Background(), e)
+}
+
+// SaveEnvelopeContext stores e. Outbound envelopes are added to the
+// recipient's mailbox; inbound ones are kept only so Exists can find them.
+func (p *Persister) SaveEnvelopeContext(ctx context.Context, e ws.Envelope) error {
+	r := record{
+		ID:        e.ID.String(),
+		ClientID:  e.ClientID.String(),
+		Type:      e.Type,
+		Payload:   e.Payload,
+		Timestamp: e.Timestamp,
+		ExpiresAt: e.ExpiresAt,
+		Inbound:   e.Inbound,
+	}
+	if e.ReplyTo != nil {
+		r.ReplyTo = e.ReplyTo.String()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	key := p.envelopeKey(r.ID)
+	set := []any{"SET", key, data}
+	if expiry, ok := p.expiry(e); ok {
+		set = append(set, "PXAT", expiry.UnixMilli())
+	}
+	if _, err := p.redis.Do(ctx, set...); err != nil {
+		return err
+	}
+	if e.Inbound || e.Delivered != nil {
+		return nil
+	}
+
+	mailbox := p.mailboxKey(r.ClientID)
+	score := strconv.FormatInt(e.Timestamp.UnixMicro(), 10)
+	if _, err := p.redis.Do(ctx, "ZADD", mailbox, score, r.ID); err != nil {
+		return err
+	}
+	if p.ttl > 0 {
+		_, err = p.redis.Do(ctx, "PEXPIRE", mailbox, p.ttl.Milliseconds())
+	}
+	return err
+}
+
+// expiry returns when the stored envelope should expire: the earlier of its
+// ExpiresAt and the persister's TTL.
+func (p *Persister) expiry(e ws.Envelope) (time.Time, bool) {
+	var at time.Time
+	if p.ttl > 0 {
+		at = time.Now().Add(p.ttl)
+	}
+	if e.ExpiresAt != nil && (at.IsZero() || e.ExpiresAt.Before(at)) {
+		at = *e.ExpiresAt
+	}
+	return at, !at.IsZero()
+}
+
+func (p *Persister) ConfirmDelivery(envelopeID ws.Identity, clientID ws.Identity) error {
+	return p.ConfirmDeliveryContext(context.Background(), envelopeID, clientID)
+}
+
+// ConfirmDeliveryContext removes the envelope from the client's mailbox and
+// deletes it. It returns ws.ErrUnknownEnvelope when the envelope is not in
+// the mailbox.
+func (p *Persister) ConfirmDeliveryContext(ctx context.Context, envelopeID ws.Identity, clientID ws.Identity) error {
+	id := envelopeID.String()
+	removed, err := p.redis.Do(ctx, "ZREM", p.mailboxKey(clientID.String()), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := toInt(removed); n == 0 {
+		return ws.ErrUnknownEnvelope
+	}
+	_, err = p.redis.Do(ctx, "DEL", p.envelopeKey(id))
+	return err
+}
+
+func (p *Persister) FetchUndelivered(clientID ws.Identity) ([]ws.Envelope, error) {
+	ctx := context.Background()
+	var envelopes []ws.Envelope
+	for offset := 0; ; {
+		page, more, err := p.FetchPage(ctx, clientID, offset, p.pageSize)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, page...)
+		if !more {
+			return envelopes, nil
+		}
+		// Expired entries found on this page were removed from the mailbox,
+		// so the next page starts after the envelopes actually returned.
+		offset += len(page)
+	}
+}
+
+// FetchPage returns up to limit pending envelopes for clientID, oldest first,
+// starting at offset in the mailbox. more reports whether the mailbox holds
+// entries past this page. Envelopes that have expired are dropped from the
+// mailbox as they are found.
+func (p *Persister) FetchPage(ctx context.Context, clientID ws.Identity, offset, limit int) (envelopes []ws.Envelope, more bool, err error) {
+	mailbox := p.mailboxKey(clientID.String())
+	reply, err := p.redis.Do(ctx, "ZRANGE", mailbox, offset, offset+limit)
+	if err != nil {
+		return nil, false, err
+	}
+	ids, err := toStrings(reply)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(ids) > limit {
+		ids, more = ids[:limit], true
+	}
+	if len(ids) == 0 {
+		return nil, more, nil
+	}
+
+	args := []any{"MGET"}
+	for _, id := range ids {
+		args = append(args, p.envelopeKey(id))
+	}
+	reply, err = p.redis.Do(ctx, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	values, err := toStrings(reply)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var stale []any
+	for i, value := range values {
+		if value == "" {
+			stale = append(stale, ids[i])
+			continue
+		}
+		e, err := decode(value)
+		if err != nil {
+			return nil, false, err
+		}
+		envelopes = append(envelopes, e)
+	}
+	if len(stale) > 0 {
+		p.redis.Do(ctx, append([]any{"ZREM", mailbox}, stale...)...)
+	}
+	return envelopes, more, nil
+}
+
+func (p *Persister) Exists(id ws.Identity) (bool, error) {
+	return p.ExistsContext(context.Background(), id)
+}
+
+// ExistsContext reports whether an envelope with id is stored.
+func (p *Persister) ExistsContext(ctx context.Context, id ws.Identity) (bool, error) {
+	reply, err := p.redis.Do(ctx, "EXISTS", p.envelopeKey(id.String()))
+	if err != nil {
+		return false, err
+	}
+	n, err := toInt(reply)
+	return n > 0, err
+}
+
+func decode(value string) (ws.Envelope, error) {
+	var r record
+	if err := json.Unmarshal([]byte(value), &r); err != nil {
+		return ws.Envelope{}, fmt.Errorf("redispersister: decode envelope: %w", err)
+	}
+	id, err := uuid.Parse(r.ID)
+	if err != nil {
+		return ws.Envelope{}, fmt.Errorf("redispersister: envelope id: %w", err)
+	}
+	clientID, err := uuid.Parse(r.ClientID)
+	if err != nil {
+		return ws.Envelope{}, fmt.Errorf("redispersister: client id: %w", err)
+	}
+
+	e := ws.Envelope{
+		ID:        ws.Identity(id),
+		ClientID:  ws.Identity(clientID),
+		Type:      r.Type,
+		Payload:   r.Payload,
+		Timestamp: r.Timestamp,
+		ExpiresAt: r.ExpiresAt,
+		Inbound:   r.Inbound,
+	}
+	if replyTo, err := uuid.Parse(r.ReplyTo); err == nil {
+		ref := ws.Identity(replyTo)
+		e.ReplyTo = &ref
+	}
+	return e, nil
+}
+
+func toInt(reply any) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("redispersister: unexpected integer reply %T", reply)
+	}
+}
+
+// toStrings converts an array reply, mapping nil elements to "".
+func toStrings(reply any) ([]string, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	var items []any
+	switch v := reply.(type) {
+	case []any:
+		items = v
+	case []string:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("redispersister: unexpected array reply %T", reply)
+	}
+
+	out := make([]string, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case nil:
+		case string:
+			out[i] = v
+		case []byte:
+			out[i] = string(v)
+		default:
+			return nil, fmt.Errorf("redispersister: unexpected array element %T", item)
+		}
+	}
+	return out, nil
+}
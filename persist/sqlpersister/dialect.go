@@ -0,0 +1,93 @@
+package sqlpersister
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Dialect adapts the persister's schema and queries to a database.
+type Dialect struct {
+	name        string
+	payloadType string
+	timeType    string
+	encodeTime  func(time.Time) any
+}
+
+// sortableTime is a fixed-width UTC layout, so text timestamps compare and
+// sort chronologically.
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+var (
+	// Postgres stores payloads as JSONB and timestamps as TIMESTAMPTZ.
+	Postgres = Dialect{
+		name:        "postgres",
+		payloadType: "JSONB",
+		timeType:    "TIMESTAMPTZ",
+		encodeTime:  func(t time.Time) any { return t.UTC() },
+	}
+
+	// SQLite stores payloads as BLOBs and timestamps as sortable UTC text.
+	SQLite = Dialect{
+		name:        "sqlite",
+		payloadType: "BLOB",
+		timeType:    "TEXT",
+		encodeTime:  func(t time.Time) any { return t.UTC().Format(sortableTime) },
+	}
+)
+
+func (d Dialect) String() string {
+	return d.name
+}
+
+// placeholder returns the nth bind parameter. Both Postgres and SQLite accept
+// the $n form.
+func (d Dialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d Dialect) timeArg(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return d.encodeTime(*t)
+}
+
+// nullTime scans a timestamp stored natively or as text by either dialect.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n *nullTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		n.Time, n.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		n.Time, n.Valid = v, true
+		return nil
+	case string:
+		return n.parse(v)
+	case []byte:
+		return n.parse(string(v))
+	default:
+		return fmt.Errorf("sqlpersister: cannot scan %T into a timestamp", src)
+	}
+}
+
+func (n *nullTime) parse(s string) error {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	n.Time, n.Valid = t, true
+	return nil
+}
+
+func (n nullTime) ptr() *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Time
+}
@@ -0,0 +1,611 @@
+// Package sqlpersister implements ws.EnvelopePersister on database/sql.
+//
+//	db, _ := sql.Open("pgx", dsn)
+//	persister := sqlpersister.New(db)
+//	if err := persister.Migrate(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	handler := ws.NewWebSocketHandler(validator, messages, persister)
+//
+// Queries use $n placeholders, which both Postgres and SQLite accept.
+package sqlpersister
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// Persister stores envelopes in a single table, and scheduled envelopes in
+// a second one named after it with a "_scheduled" suffix. Besides
+// ws.EnvelopePersister it implements ws.UndeliveredFetcher,
+// ws.UndeliveredCounter, ws.EnvelopeHistoryFetcher, ws.RoomHistoryFetcher,
+// ws.ExpiredPurger, ws.DeliveredPurger, ws.EnvelopeChecker,
+// ws.EnvelopeScheduler, ws.ReadMarker and ws.BatchSaver, and each method
+// has a context-aware variant.
+type Persister struct {
+	db         *sql.DB
+	dialect    Dialect
+	table      string
+	fetchLimit int
+}
+
+// Option configures a Persister.
+type Option func(*Persister)
+
+// WithDialect selects the database dialect. The default is Postgres.
+func WithDialect(d Dialect) Option {
+	return func(p *Persister) {
+		p.dialect = d
+	}
+}
+
+// WithTable sets the table name. The default is "envelopes". The name is
+// interpolated into queries and must be trusted.
+func WithTable(name string) Option {
+	return func(p *Persister) {
+		p.table = name
+	}
+}
+
+// WithFetchLimit caps how many envelopes FetchUndelivered returns per call.
+// Zero, the default, means no limit.
+func WithFetchLimit(n int) Option {
+	return func(p *Persister) {
+		p.fetchLimit = n
+	}
+}
+
+func New(db *sql.DB, opts ...Option) *Persister {
+	p := &Persister{db: db, dialect: Postgres, table: "envelopes"}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Migrate creates the envelopes and scheduled envelopes tables and their
+// indexes if they do not exist, and adds the room and read_at columns to
+// tables created by earlier versions.
+func (p *Persister) Migrate(ctx context.Context) error {
+	if _, err := p.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	client_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	payload %s,
+	timestamp %s NOT NULL,
+	delivered %s,
+	reply_to TEXT,
+	expires_at %s,
+	inbound BOOLEAN NOT NULL DEFAULT FALSE,
+	room TEXT,
+	read_at %s
+)`, p.table, p.dialect.payloadType, p.dialect.timeType, p.dialect.timeType, p.dialect.timeType, p.dialect.timeType)); err != nil {
+		return fmt.Errorf("sqlpersister: migrate: %w", err)
+	}
+	if err := p.addColumn(ctx, "room", "TEXT"); err != nil {
+		return fmt.Errorf("sqlpersister: migrate: %w", err)
+	}
+	if err := p.addColumn(ctx, "read_at", p.dialect.timeType); err != nil {
+		return fmt.Errorf("sqlpersister: migrate: %w", err)
+	}
+	if _, err := p.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	envelope %s NOT NULL,
+	send_at %s NOT NULL,
+	claimed_until %s
+)`, p.scheduled(), p.dialect.payloadType, p.dialect.timeType, p.dialect.timeType)); err != nil {
+		return fmt.Errorf("sqlpersister: migrate: %w", err)
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_undelivered ON %s (client_id, timestamp) WHERE delivered IS NULL`,
+			p.table, p.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_delivered ON %s (delivered) WHERE delivered IS NOT NULL`,
+			p.table, p.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_room ON %s (room, timestamp) WHERE room IS NOT NULL`,
+			p.table, p.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_history ON %s (client_id, timestamp, id) WHERE room IS NULL`,
+			p.table, p.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_send_at ON %s (send_at)`,
+			p.scheduled(), p.scheduled()),
+	}
+	for _, stmt := range statements {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("sqlpersister: migrate: %w", err)
+		}
+	}
+	return nil
+}
+
+// addColumn adds the named column when the table predates it. SQLite has
+// no ADD COLUMN IF NOT EXISTS, so the column is probed for first.
+func (p *Persister) addColumn(ctx context.Context, name, columnType string) error {
+	probe := fmt.Sprintf(`SELECT %s FROM %s WHERE 1 = 0`, name, p.table)
+	rows, err := p.db.QueryContext(ctx, probe)
+	if err == nil {
+		return rows.Close()
+	}
+	_, err = p.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, p.table, name, columnType))
+	return err
+}
+
+func (p *Persister) SaveEnvelope(e ws.Envelope) error {
+	return p.SaveEnvelopeContext(context.Background(), e)
+}
+
+// SaveEnvelopeContext stores e, replacing any envelope with the same ID.
+func (p *Persister) SaveEnvelopeContext(ctx context.Context, e ws.Envelope) error {
+	return p.save(ctx, p.db, e)
+}
+
+// SaveEnvelopes stores envelopes in a single transaction, implementing
+// ws.BatchSaver.
+func (p *Persister) SaveEnvelopes(envelopes []ws.Envelope) error {
+	return p.SaveEnvelopesContext(context.Background(), envelopes)
+}
+
+// SaveEnvelopesContext stores envelopes in a single transaction.
+func (p *Persister) SaveEnvelopesContext(ctx context.Context, envelopes []ws.Envelope) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range envelopes {
+		if err := p.save(ctx, tx, e); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (p *Persister) save(ctx context.Context, db execer, e ws.Envelope) error {
+	var replyTo any
+	if e.ReplyTo != nil {
+		replyTo = e.ReplyTo.String()
+	}
+	var payload any
+	if e.Payload != nil {
+		payload = string(e.Payload)
+	}
+	var room any
+	if e.Room != "" {
+		room = e.Room
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (id, client_id, type, payload, timestamp, delivered, reply_to, expires_at, inbound, room, read_at)
+VALUES (%s)
+ON CONFLICT (id) DO UPDATE SET
+	client_id = excluded.client_id,
+	type = excluded.type,
+	payload = excluded.payload,
+	timestamp = excluded.timestamp,
+	delivered = excluded.delivered,
+	reply_to = excluded.reply_to,
+	expires_at = excluded.expires_at,
+	inbound = excluded.inbound,
+	room = excluded.room,
+	read_at = excluded.read_at`, p.table, p.placeholders(11))
+
+	_, err := db.ExecContext(ctx, query,
+		e.ID.String(), e.ClientID.String(), e.Type, payload,
+		p.dialect.encodeTime(e.Timestamp), p.dialect.timeArg(e.Delivered),
+		replyTo, p.dialect.timeArg(e.ExpiresAt), e.Inbound, room, p.dialect.timeArg(e.ReadAt))
+	return err
+}
+
+func (p *Persister) ConfirmDelivery(envelopeID ws.Identity, clientID ws.Identity) error {
+	return p.ConfirmDeliveryContext(context.Background(), envelopeID, clientID)
+}
+
+// ConfirmDeliveryContext marks the envelope delivered, keeping the first
+// confirmation time. It returns ws.ErrUnknownEnvelope when no envelope with
+// that ID is stored for clientID.
+func (p *Persister) ConfirmDeliveryContext(ctx context.Context, envelopeID ws.Identity, clientID ws.Identity) error {
+	query := fmt.Sprintf(`UPDATE %s SET delivered = COALESCE(delivered, %s) WHERE id = %s AND client_id = %s`,
+		p.table, p.dialect.placeholder(1), p.dialect.placeholder(2), p.dialect.placeholder(3))
+
+	result, err := p.db.ExecContext(ctx, query, p.dialect.encodeTime(time.Now()), envelopeID.String(), clientID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ws.ErrUnknownEnvelope
+	}
+	return nil
+}
+
+func (p *Persister) MarkRead(envelopeID, clientID ws.Identity, at time.Time) error {
+	return p.MarkReadContext(context.Background(), envelopeID, clientID, at)
+}
+
+// MarkReadContext records that clientID read the envelope at at, keeping the
+// first time. It returns ws.ErrUnknownEnvelope when no envelope with that ID
+// is stored for clientID.
+func (p *Persister) MarkReadContext(ctx context.Context, envelopeID, clientID ws.Identity, at time.Time) error {
+	query := fmt.Sprintf(`UPDATE %s SET read_at = COALESCE(read_at, %s) WHERE id = %s AND client_id = %s`,
+		p.table, p.dialect.placeholder(1), p.dialect.placeholder(2), p.dialect.placeholder(3))
+
+	result, err := p.db.ExecContext(ctx, query, p.dialect.encodeTime(at), envelopeID.String(), clientID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ws.ErrUnknownEnvelope
+	}
+	return nil
+}
+
+func (p *Persister) FetchUndelivered(clientID ws.Identity) ([]ws.Envelope, error) {
+	return p.FetchUndeliveredContext(context.Background(), clientID, p.fetchLimit)
+}
+
+// FetchUndeliveredContext returns up to limit outbound envelopes for clientID
+// that have not been confirmed, oldest first. A limit of zero returns all.
+func (p *Persister) FetchUndeliveredContext(ctx context.Context, clientID ws.Identity, limit int) ([]ws.Envelope, error) {
+	query := fmt.Sprintf(`SELECT id, client_id, type, payload, timestamp, delivered, reply_to, expires_at, inbound, room, read_at
+FROM %s
+WHERE client_id = %s AND delivered IS NULL AND inbound = %s
+ORDER BY timestamp, id`, p.table, p.dialect.placeholder(1), p.dialect.placeholder(2))
+	args := []any{clientID.String(), false}
+	if limit > 0 {
+		query += " LIMIT " + p.dialect.placeholder(3)
+		args = append(args, limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envelopes []ws.Envelope
+	for rows.Next() {
+		e, err := scanEnvelope(rows)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, e)
+	}
+	return envelopes, rows.Err()
+}
+
+func (p *Persister) CountUndelivered(clientID ws.Identity) (int, error) {
+	return p.CountUndeliveredContext(context.Background(), clientID)
+}
+
+// CountUndeliveredContext returns how many outbound envelopes for clientID,
+// other than room envelopes, have not been confirmed.
+func (p *Persister) CountUndeliveredContext(ctx context.Context, clientID ws.Identity) (int, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s
+WHERE client_id = %s AND delivered IS NULL AND inbound = %s AND room IS NULL`,
+		p.table, p.dialect.placeholder(1), p.dialect.placeholder(2))
+
+	var n int
+	err := p.db.QueryRowContext(ctx, query, clientID.String(), false).Scan(&n)
+	return n, err
+}
+
+func (p *Persister) FetchEnvelopes(clientID ws.Identity, before ws.Identity, limit int) ([]ws.Envelope, error) {
+	return p.FetchEnvelopesContext(context.Background(), clientID, before, limit)
+}
+
+// FetchEnvelopesContext returns the last limit envelopes to or from
+// clientID, other than room envelopes, that sort before the envelope before
+// by timestamp and then ID, oldest first. A zero before starts from the
+// newest envelope, and a limit of zero returns all of them. It returns
+// ws.ErrUnknownEnvelope when before names no envelope of clientID's.
+func (p *Persister) FetchEnvelopesContext(ctx context.Context, clientID ws.Identity, before ws.Identity, limit int) ([]ws.Envelope, error) {
+	query := fmt.Sprintf(`SELECT id, client_id, type, payload, timestamp, delivered, reply_to, expires_at, inbound, room, read_at
+FROM %s
+WHERE client_id = %s AND room IS NULL`, p.table, p.dialect.placeholder(1))
+	args := []any{clientID.String()}
+
+	if !before.IsZero() {
+		cursor := fmt.Sprintf(`SELECT timestamp FROM %s WHERE id = %s AND client_id = %s AND room IS NULL`,
+			p.table, p.dialect.placeholder(1), p.dialect.placeholder(2))
+		var at nullTime
+		err := p.db.QueryRowContext(ctx, cursor, before.String(), clientID.String()).Scan(&at)
+		if err == sql.ErrNoRows {
+			return nil, ws.ErrUnknownEnvelope
+		}
+		if err != nil {
+			return nil, err
+		}
+		query += fmt.Sprintf(` AND (timestamp < %s OR (timestamp = %s AND id < %s))`,
+			p.dialect.placeholder(2), p.dialect.placeholder(2), p.dialect.placeholder(3))
+		args = append(args, p.dialect.encodeTime(at.Time), before.String())
+	}
+	query += "\nORDER BY timestamp DESC, id DESC"
+	if limit > 0 {
+		query += " LIMIT " + p.dialect.placeholder(len(args)+1)
+		args = append(args, limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envelopes []ws.Envelope
+	for rows.Next() {
+		e, err := scanEnvelope(rows)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, e)
+	}
+	// Newest first is what the limit needs; pages are returned oldest first.
+	for i, j := 0, len(envelopes)-1; i < j; i, j = i+1, j-1 {
+		envelopes[i], envelopes[j] = envelopes[j], envelopes[i]
+	}
+	return envelopes, rows.Err()
+}
+
+func (p *Persister) FetchRoomHistory(room string, limit int, before time.Time) ([]ws.Envelope, error) {
+	return p.FetchRoomHistoryContext(context.Background(), room, limit, before)
+}
+
+// FetchRoomHistoryContext returns the most recent limit envelopes broadcast
+// to room before before, oldest first. A limit of zero returns all of them.
+func (p *Persister) FetchRoomHistoryContext(ctx context.Context, room string, limit int, before time.Time) ([]ws.Envelope, error) {
+	query := fmt.Sprintf(`SELECT id, client_id, type, payload, timestamp, delivered, reply_to, expires_at, inbound, room, read_at
+FROM %s
+WHERE room = %s AND inbound = %s AND timestamp < %s
+ORDER BY timestamp DESC, id DESC`, p.table, p.dialect.placeholder(1), p.dialect.placeholder(2), p.dialect.placeholder(3))
+	args := []any{room, false, p.dialect.encodeTime(before)}
+	if limit > 0 {
+		query += " LIMIT " + p.dialect.placeholder(4)
+		args = append(args, limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envelopes []ws.Envelope
+	for rows.Next() {
+		e, err := scanEnvelope(rows)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, e)
+	}
+	// Newest first is what the limit needs; history is returned oldest first.
+	for i, j := 0, len(envelopes)-1; i < j; i, j = i+1, j-1 {
+		envelopes[i], envelopes[j] = envelopes[j], envelopes[i]
+	}
+	return envelopes, rows.Err()
+}
+
+func (p *Persister) PurgeExpired(before time.Time) (int, error) {
+	return p.PurgeExpiredContext(context.Background(), before)
+}
+
+// PurgeExpiredContext deletes undelivered envelopes whose expiry is before
+// before, returning how many were removed.
+func (p *Persister) PurgeExpiredContext(ctx context.Context, before time.Time) (int, error) {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE delivered IS NULL AND expires_at IS NOT NULL AND expires_at < %s`,
+		p.table, p.dialect.placeholder(1))
+
+	result, err := p.db.ExecContext(ctx, query, p.dialect.encodeTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (p *Persister) PurgeDelivered(before time.Time) (int, error) {
+	return p.PurgeDeliveredContext(context.Background(), before)
+}
+
+// PurgeDeliveredContext deletes envelopes confirmed as delivered before
+// before, returning how many were removed.
+func (p *Persister) PurgeDeliveredContext(ctx context.Context, before time.Time) (int, error) {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE delivered IS NOT NULL AND delivered < %s`,
+		p.table, p.dialect.placeholder(1))
+
+	result, err := p.db.ExecContext(ctx, query, p.dialect.encodeTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (p *Persister) Exists(id ws.Identity) (bool, error) {
+	return p.ExistsContext(context.Background(), id)
+}
+
+// ExistsContext reports whether an envelope with id is stored.
+func (p *Persister) ExistsContext(ctx context.Context, id ws.Identity) (bool, error) {
+	query := fmt.Sprintf(`SELECT 1 FROM %s WHERE id = %s`, p.table, p.dialect.placeholder(1))
+
+	var one int
+	err := p.db.QueryRowContext(ctx, query, id.String()).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *Persister) SaveScheduled(e ws.Envelope, at time.Time) error {
+	return p.SaveScheduledContext(context.Background(), e, at)
+}
+
+// SaveScheduledContext stores e to be sent at at, replacing any scheduled
+// envelope with the same ID and dropping its claim.
+func (p *Persister) SaveScheduledContext(ctx context.Context, e ws.Envelope, at time.Time) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (id, envelope, send_at, claimed_until)
+VALUES (%s, NULL)
+ON CONFLICT (id) DO UPDATE SET
+	envelope = excluded.envelope,
+	send_at = excluded.send_at,
+	claimed_until = NULL`, p.scheduled(), p.placeholders(3))
+
+	_, err = p.db.ExecContext(ctx, query, e.ID.String(), string(data), p.dialect.encodeTime(at))
+	return err
+}
+
+func (p *Persister) FetchDue(now time.Time, lease time.Duration) ([]ws.Envelope, error) {
+	return p.FetchDueContext(context.Background(), now, lease)
+}
+
+// FetchDueContext claims for lease and returns the scheduled envelopes due
+// at or before now that are not claimed, earliest first. Each envelope is
+// claimed with a conditional update, so of several persisters sharing the
+// table only one hands it out.
+func (p *Persister) FetchDueContext(ctx context.Context, now time.Time, lease time.Duration) ([]ws.Envelope, error) {
+	unclaimed := fmt.Sprintf(`(claimed_until IS NULL OR claimed_until <= %s)`, p.dialect.placeholder(1))
+	query := fmt.Sprintf(`SELECT id, envelope FROM %s
+WHERE send_at <= %s AND %s
+ORDER BY send_at, id`, p.scheduled(), p.dialect.placeholder(1), unclaimed)
+
+	rows, err := p.db.QueryContext(ctx, query, p.dialect.encodeTime(now))
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		id   string
+		data []byte
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claim := fmt.Sprintf(`UPDATE %s SET claimed_until = %s WHERE id = %s AND %s`,
+		p.scheduled(), p.dialect.placeholder(2), p.dialect.placeholder(3), unclaimed)
+	var due []ws.Envelope
+	for _, c := range candidates {
+		result, err := p.db.ExecContext(ctx, claim, p.dialect.encodeTime(now), p.dialect.encodeTime(now.Add(lease)), c.id)
+		if err != nil {
+			return due, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		var e ws.Envelope
+		if err := json.Unmarshal(c.data, &e); err != nil {
+			return due, fmt.Errorf("sqlpersister: scheduled envelope %s: %w", c.id, err)
+		}
+		due = append(due, e)
+	}
+	return due, nil
+}
+
+func (p *Persister) ConfirmScheduled(envelopeID ws.Identity) error {
+	return p.ConfirmScheduledContext(context.Background(), envelopeID)
+}
+
+// ConfirmScheduledContext removes the claimed envelope with envelopeID once
+// it has been sent. It returns ws.ErrUnknownEnvelope when none is claimed.
+func (p *Persister) ConfirmScheduledContext(ctx context.Context, envelopeID ws.Identity) error {
+	return p.deleteScheduled(ctx, envelopeID, "claimed_until IS NOT NULL")
+}
+
+func (p *Persister) CancelScheduled(envelopeID ws.Identity) error {
+	return p.CancelScheduledContext(context.Background(), envelopeID)
+}
+
+// CancelScheduledContext removes the scheduled envelope with envelopeID. It
+// returns ws.ErrUnknownEnvelope when none is waiting unclaimed.
+func (p *Persister) CancelScheduledContext(ctx context.Context, envelopeID ws.Identity) error {
+	return p.deleteScheduled(ctx, envelopeID, "claimed_until IS NULL")
+}
+
+func (p *Persister) deleteScheduled(ctx context.Context, envelopeID ws.Identity, condition string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = %s AND %s`, p.scheduled(), p.dialect.placeholder(1), condition)
+
+	result, err := p.db.ExecContext(ctx, query, envelopeID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ws.ErrUnknownEnvelope
+	}
+	return nil
+}
+
+// scheduled returns the name of the scheduled envelopes table.
+func (p *Persister) scheduled() string {
+	return p.table + "_scheduled"
+}
+
+func (p *Persister) placeholders(n int) string {
+	s := ""
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			s += ", "
+		}
+		s += p.dialect.placeholder(i)
+	}
+	return s
+}
+
+func scanEnvelope(rows *sql.Rows) (ws.Envelope, error) {
+	var (
+		e                   ws.Envelope
+		id, clientID        string
+		payload             []byte
+		replyTo, room       sql.NullString
+		timestamp           nullTime
+		delivered, expireAt nullTime
+		readAt              nullTime
+	)
+	if err := rows.Scan(&id, &clientID, &e.Type, &payload, &timestamp, &delivered, &replyTo, &expireAt, &e.Inbound, &room, &readAt); err != nil {
+		return ws.Envelope{}, err
+	}
+
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return ws.Envelope{}, fmt.Errorf("sqlpersister: envelope id: %w", err)
+	}
+	parsedClient, err := uuid.Parse(clientID)
+	if err != nil {
+		return ws.Envelope{}, fmt.Errorf("sqlpersister: client id: %w", err)
+	}
+	e.ID, e.ClientID = ws.Identity(parsedID), ws.Identity(parsedClient)
+	if payload != nil {
+		e.Payload = payload
+	}
+	e.Timestamp = timestamp.Time
+	e.Delivered = delivered.ptr()
+	e.ExpiresAt = expireAt.ptr()
+	e.ReadAt = readAt.ptr()
+	e.Room = room.String
+	if replyTo.Valid {
+		if parsed, err := uuid.Parse(replyTo.String); err == nil {
+			r := ws.Identity(parsed)
+			e.ReplyTo = &r
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type ackFixture struct {
+	handler   *ws.WebsocketHandler
+	persister *mockEnvelopePersister
+	messages  *mockMessageHandler
+}
+
+func newAckFixture(t *testing.T) (*ackFixture, func(ws.Identity) *websocket.Conn) {
+	t.Helper()
+	f := &ackFixture{persister: &mockEnvelopePersister{}, messages: &mockMessageHandler{}}
+	validator := &headerSessionValidator{}
+	f.handler = ws.NewWebSocketHandler(validator, f.messages, f.persister)
+	server := newTestServer(t, f.handler)
+
+	connect := func(id ws.Identity) *websocket.Conn {
+		conn, _ := dialWithResponse(t, server, identityHeader(id))
+		if !waitFor(t, 2*time.Second, func() bool { return f.handler.IsOnline(id) }) {
+			t.Fatal("Expected client to be registered")
+		}
+		return conn
+	}
+	return f, connect
+}
+
+func sendAck(conn *websocket.Conn, id string) {
+	data, _ := json.Marshal(map[string]string{"type": ws.AckMessageType, "id": id})
+	conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func readErrorCode(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected an error frame, got %v", err)
+		}
+		var frame struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		}
+		json.Unmarshal(data, &frame)
+		if frame.Type == ws.ErrorMessageType {
+			return frame.Code
+		}
+	}
+}
+
+func TestAckConfirmsDelivery(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+	conn := connect(clientID)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	if err := f.handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected envelope frame, got %v", err)
+	}
+	var received struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(data, &received)
+
+	time.Sleep(50 * time.Millisecond)
+	if len(f.persister.confirmedIDs()) != 0 {
+		t.Fatal("Expected envelope to stay pending until acked")
+	}
+
+	sendAck(conn, received.ID)
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.persister.confirmedIDs()) == 1 }) {
+		t.Fatal("Expected ack to confirm delivery")
+	}
+	if f.persister.confirmedIDs()[0] != envelope.ID {
+		t.Errorf("Expected confirmation for %s, got %s", envelope.ID, f.persister.confirmedIDs()[0])
+	}
+	if len(f.messages.received()) != 0 {
+		t.Error("Expected ack frames not to reach the message handler")
+	}
+	for _, saved := range f.persister.saved() {
+		if saved.Inbound {
+			t.Error("Expected ack frames not to be persisted")
+		}
+	}
+
+	sendAck(conn, received.ID)
+	if code := readErrorCode(t, conn); code != "unknown_envelope" {
+		t.Errorf("Expected duplicate ack to be rejected, got %q", code)
+	}
+}
+
+func TestAckForUnknownEnvelope(t *testing.T) {
+	f, connect := newAckFixture(t)
+	conn := connect(ws.NewIdentity())
+
+	sendAck(conn, ws.NewIdentity().String())
+	if code := readErrorCode(t, conn); code != "unknown_envelope" {
+		t.Errorf("Expected unknown_envelope, got %q", code)
+	}
+
+	sendAck(conn, "not-a-uuid")
+	if code := readErrorCode(t, conn); code != "invalid_ack" {
+		t.Errorf("Expected invalid_ack, got %q", code)
+	}
+
+	if len(f.persister.confirmedIDs()) != 0 {
+		t.Error("Expected no deliveries to be confirmed")
+	}
+}
+
+func TestAckFromWrongClient(t *testing.T) {
+	f, connect := newAckFixture(t)
+	recipientID := ws.NewIdentity()
+	recipient := connect(recipientID)
+	other := connect(ws.NewIdentity())
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: recipientID, Type: "notification"}
+	f.handler.SendEnvelope(envelope)
+	recipient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := recipient.ReadMessage(); err != nil {
+		t.Fatalf("Expected envelope frame, got %v", err)
+	}
+
+	sendAck(other, envelope.ID.String())
+	if code := readErrorCode(t, other); code != "unknown_envelope" {
+		t.Errorf("Expected ack from the wrong client to be rejected, got %q", code)
+	}
+	if len(f.persister.confirmedIDs()) != 0 {
+		t.Error("Expected envelope to stay pending")
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var (
+	_ ws.MessageHandler    = ws.MessageHandlerFunc(nil)
+	_ ws.SessionValidator  = ws.SessionValidatorFunc(nil)
+	_ ws.SessionValidator  = ws.ValidatorFunc(nil)
+	_ ws.EnvelopePersister = ws.EnvelopePersisterFuncs{}
+)
+
+func TestEnvelopePersisterFuncs(t *testing.T) {
+	errSave := errors.New("save failed")
+	var saved ws.Envelope
+	var confirmed [2]ws.Identity
+	persister := ws.EnvelopePersisterFuncs{
+		Save: func(e ws.Envelope) error {
+			saved = e
+			return errSave
+		},
+		Confirm: func(envelopeID, clientID ws.Identity) error {
+			confirmed = [2]ws.Identity{envelopeID, clientID}
+			return nil
+		},
+	}
+
+	env := ws.Envelope{ID: ws.NewIdentity()}
+	if err := persister.SaveEnvelope(env); !errors.Is(err, errSave) || saved.ID != env.ID {
+		t.Errorf("Expected Save to receive the envelope and return its error, got %v", err)
+	}
+	envelopeID, clientID := ws.NewIdentity(), ws.NewIdentity()
+	if err := persister.ConfirmDelivery(envelopeID, clientID); err != nil || confirmed != [2]ws.Identity{envelopeID, clientID} {
+		t.Errorf("Expected Confirm to receive both identities, got %v (%v)", confirmed, err)
+	}
+
+	var empty ws.EnvelopePersisterFuncs
+	if empty.SaveEnvelope(env) != nil || empty.ConfirmDelivery(envelopeID, clientID) != nil {
+		t.Error("Expected nil functions to succeed")
+	}
+}
+
+func TestAllowAllValidator(t *testing.T) {
+	session, err := ws.AllowAllValidator().Validate(validateRequest())
+	if err != nil || !session.ClientID.IsZero() {
+		t.Errorf("Expected an anonymous session, got %+v (%v)", session, err)
+	}
+}
+
+func TestAdaptersServeConnections(t *testing.T) {
+	received := make(chan string, 1)
+	saved := make(chan ws.Envelope, 1)
+	handler := ws.NewWebSocketHandler(
+		ws.AllowAllValidator(),
+		ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+			received <- string(data)
+			return nil
+		}),
+		ws.EnvelopePersisterFuncs{Save: func(e ws.Envelope) error {
+			saved <- e
+			return nil
+		}},
+	)
+	server := newTestServer(t, handler)
+	first, second := dial(t, server), dial(t, server)
+
+	var ids []ws.Identity
+	if !waitFor(t, 2*time.Second, func() bool {
+		ids = ids[:0]
+		handler.Range(func(c *ws.Client) bool { ids = append(ids, c.ID); return true })
+		return len(ids) == 2
+	}) || ids[0] == ids[1] {
+		t.Fatalf("Expected two connections with distinct identities, got %v", ids)
+	}
+	second.Close()
+
+	first.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","payload":{}}`))
+	select {
+	case data := <-received:
+		if data != `{"type":"chat","payload":{}}` {
+			t.Errorf("Expected the frame in the handler func, got %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the handler func to receive the frame")
+	}
+	select {
+	case e := <-saved:
+		if e.Type != "chat" {
+			t.Errorf("Expected the chat envelope saved, got %q", e.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the persister func to save the envelope")
+	}
+}
+
+func TestNopPersister(t *testing.T) {
+	handler := ws.NewWebSocketHandler(ws.AllowAllValidator(), &mockMessageHandler{}, ws.NopPersister())
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	if err := handler.SendEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: client.ID, Type: "note"}); err != nil {
+		t.Fatalf("Expected sending with the nop persister to succeed, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || !strings.Contains(string(data), `"note"`) {
+		t.Errorf("Expected the envelope delivered, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func adminRequest(t *testing.T, server *httptest.Server, method, path, body string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON response to %s %s, got %q", method, path, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminListsConnectionsAndRooms(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, aliceClient := connectAs(t, handler, server, alice)
+	_, bobClient := connectAs(t, handler, server, bob)
+	_, bobPhone := connectAs(t, handler, server, bob)
+	handler.Join("lobby", aliceClient)
+	handler.Join("lobby", bobClient)
+	handler.Join("lobby", bobPhone)
+	handler.Join("ops", bobClient)
+
+	aliceConn.MustSend([]byte("hello"))
+	if !waitFor(t, 2*time.Second, func() bool { return aliceClient.Stats().MessagesIn == 1 }) {
+		t.Fatal("Expected the message to be read")
+	}
+
+	admin := httptest.NewServer(handler.AdminHandler())
+	t.Cleanup(admin.Close)
+
+	var connections []ws.AdminConnection
+	if status := adminRequest(t, admin, http.MethodGet, "/connections", "", &connections); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if len(connections) != 3 || connections[0].ClientID != alice {
+		t.Fatalf("Expected three connections, alice's first, got %+v", connections)
+	}
+	first := connections[0]
+	if first.BytesIn != 5 || first.MessagesIn != 1 || !first.RemoteIP.IsLoopback() || len(first.Rooms) != 1 || first.Rooms[0] != "lobby" {
+		t.Errorf("Expected alice's connection details, got %+v", first)
+	}
+
+	var client ws.AdminClient
+	if status := adminRequest(t, admin, http.MethodGet, "/connections/"+bob.String(), "", &client); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if client.Stats.ClientID != bob || client.Stats.Connections != 2 || len(client.Connections) != 2 {
+		t.Errorf("Expected bob's two connections, got %+v", client)
+	}
+
+	var rooms []ws.AdminRoom
+	adminRequest(t, admin, http.MethodGet, "/rooms", "", &rooms)
+	want := []ws.AdminRoom{{Name: "lobby", Members: 2, Connections: 3}, {Name: "ops", Members: 1, Connections: 1}}
+	if len(rooms) != 2 || rooms[0] != want[0] || rooms[1] != want[1] {
+		t.Errorf("Expected rooms %+v, got %+v", want, rooms)
+	}
+}
+
+func TestAdminErrors(t *testing.T) {
+	handler, _ := newPresenceHandler(t)
+	admin := httptest.NewServer(handler.AdminHandler())
+	t.Cleanup(admin.Close)
+
+	var body map[string]string
+	if status := adminRequest(t, admin, http.MethodGet, "/connections/"+ws.NewIdentity().String(), "", &body); status != http.StatusNotFound || body["error"] == "" {
+		t.Errorf("Expected 404 with an error for an unknown identity, got %d %v", status, body)
+	}
+	if status := adminRequest(t, admin, http.MethodGet, "/connections/nope", "", nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed identity, got %d", status)
+	}
+	if status := adminRequest(t, admin, http.MethodPost, "/connections/"+ws.NewIdentity().String()+"/disconnect", `{"code":1006}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a reserved close code, got %d", status)
+	}
+	if status := adminRequest(t, admin, http.MethodPost, "/connections/"+ws.NewIdentity().String()+"/disconnect", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 disconnecting an unknown identity, got %d", status)
+	}
+}
+
+func TestAdminDisconnectClosesSocket(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	id := ws.NewIdentity()
+	conn, _ := connectAs(t, handler, server, id)
+	other, _ := connectAs(t, handler, server, ws.NewIdentity())
+
+	admin := httptest.NewServer(handler.AdminHandler())
+	t.Cleanup(admin.Close)
+
+	var result map[string]int
+	status := adminRequest(t, admin, http.MethodPost, "/connections/"+id.String()+"/disconnect", `{"code":4001,"reason":"kicked by operator"}`, &result)
+	if status != http.StatusOK || result["disconnected"] != 1 {
+		t.Fatalf("Expected one connection to be disconnected, got %d %v", status, result)
+	}
+	if reason := conn.ExpectClose(4001); reason != "kicked by operator" {
+		t.Errorf("Expected the close reason to be sent, got %q", reason)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return !handler.IsOnline(id) }) {
+		t.Error("Expected the client to be unregistered")
+	}
+	other.ExpectNoMessage(50 * time.Millisecond)
+	if handler.Len() != 1 {
+		t.Errorf("Expected the other connection to stay open, got %d connections", handler.Len())
+	}
+}
+
+func TestAdminAuthorizer(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	id := ws.NewIdentity()
+	connectAs(t, handler, server, id)
+
+	admin := httptest.NewServer(handler.AdminHandler(ws.WithAdminAuthorizer(func(r *http.Request) bool {
+		return r.Header.Get("X-Operator") == "yes"
+	})))
+	t.Cleanup(admin.Close)
+
+	if status := adminRequest(t, admin, http.MethodPost, "/connections/"+id.String()+"/disconnect", "", nil); status != http.StatusForbidden {
+		t.Errorf("Expected 403 without the operator header, got %d", status)
+	}
+	if !handler.IsOnline(id) {
+		t.Error("Expected a refused request not to disconnect anyone")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, admin.URL+"/connections", nil)
+	req.Header.Set("X-Operator", "yes")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /connections failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for an operator, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminMountedUnderPrefix(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	connectAs(t, handler, server, ws.NewIdentity())
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/ws/", http.StripPrefix("/admin/ws", handler.AdminHandler()))
+	admin := httptest.NewServer(mux)
+	t.Cleanup(admin.Close)
+
+	var connections []ws.AdminConnection
+	if status := adminRequest(t, admin, http.MethodGet, "/admin/ws/connections", "", &connections); status != http.StatusOK || len(connections) != 1 {
+		t.Errorf("Expected the mounted handler to list one connection, got %d %+v", status, connections)
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var errNotAdmin = errors.New("admin role required")
+
+// adminOnly denies admin.* messages unless the client's "role" metadata is
+// "admin", so tests can change permissions mid-connection.
+var adminOnly = ws.MessageAuthorizerFunc(func(client *ws.Client, msgType string, env ws.Envelope) error {
+	if !strings.HasPrefix(msgType, "admin.") {
+		return nil
+	}
+	if role, _ := client.Get("role"); role != "admin" {
+		return errNotAdmin
+	}
+	return nil
+})
+
+func TestAuthorizerDeniesMessage(t *testing.T) {
+	messageHandler := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister,
+		ws.WithMessageAuthorizer(adminOnly),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	id := ws.NewIdentity()
+	writeFrame(t, conn, `{"id":"`+id.String()+`","type":"admin.ban","payload":{}}`)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected an error frame, got %v", err)
+	}
+	var frame struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+		Ref  string `json:"ref"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil || frame.Type != ws.ErrorMessageType || frame.Code != ws.ErrorCodeUnauthorized {
+		t.Fatalf("Expected unauthorized error frame, got %q (%v)", data, err)
+	}
+	if frame.Ref != id.String() {
+		t.Errorf("Expected error frame to reference %s, got %q", id, frame.Ref)
+	}
+
+	writeFrame(t, conn, `{"type":"chat","payload":{}}`)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 1 }) {
+		t.Fatal("Expected the permitted message to be handled")
+	}
+	if got := messageHandler.received(); !strings.Contains(string(got[0]), `"chat"`) {
+		t.Errorf("Expected only the chat message to be handled, got %q", got)
+	}
+	if saved := persister.saved(); len(saved) != 1 || saved[0].Type != "chat" {
+		t.Errorf("Expected the denied message not to be persisted, got %+v", saved)
+	}
+}
+
+func TestAuthorizerSeesPermissionChanges(t *testing.T) {
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, messageHandler, &mockEnvelopePersister{},
+		ws.WithMessageAuthorizer(adminOnly),
+	)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+	client, _ := handler.Get(clientID)
+
+	client.Set("role", "admin")
+	writeFrame(t, conn, `{"type":"admin.ban","payload":{}}`)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 1 }) {
+		t.Fatal("Expected the admin message to be handled")
+	}
+
+	client.Set("role", "member")
+	writeFrame(t, conn, `{"type":"admin.ban","payload":{}}`)
+	if code := readErrorCode(t, conn); code != ws.ErrorCodeUnauthorized {
+		t.Errorf("Expected unauthorized after demotion, got %q", code)
+	}
+	if n := len(messageHandler.received()); n != 1 {
+		t.Errorf("Expected the demoted client's message not to be handled, got %d messages", n)
+	}
+}
+
+func TestAuthorizerClosesAfterMaxDenials(t *testing.T) {
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, &mockEnvelopePersister{},
+		ws.WithMessageAuthorizer(adminOnly),
+		ws.WithMaxDenials(2),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	for i := 0; i < 3; i++ {
+		writeFrame(t, conn, `{"type":"admin.ban","payload":{}}`)
+	}
+	for i := 0; i < 3; i++ {
+		if code := readErrorCode(t, conn); code != ws.ErrorCodeUnauthorized {
+			t.Fatalf("Expected unauthorized error frame %d, got %q", i+1, code)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected close code 1008 after too many denials, got %v", err)
+	}
+	if len(messageHandler.received()) != 0 {
+		t.Error("Expected no denied message to be handled")
+	}
+}
+
+func TestRouterAuthorizer(t *testing.T) {
+	router := ws.NewRouter()
+	router.UseAuthorizer(adminOnly)
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	handled := 0
+	router.HandleFunc("admin.ban", func(c *ws.Client, env ws.Envelope) error {
+		handled++
+		return nil
+	})
+
+	err := router.Handle(client, []byte(`{"type":"admin.ban","payload":{}}`))
+	if !errors.Is(err, ws.ErrMessageDenied) || !errors.Is(err, errNotAdmin) {
+		t.Errorf("Expected ErrMessageDenied wrapping the authorizer's error, got %v", err)
+	}
+	if handled != 0 {
+		t.Fatal("Expected the denied envelope not to reach its handler")
+	}
+
+	client.Set("role", "admin")
+	if err := router.Handle(client, []byte(`{"type":"admin.ban","payload":{}}`)); err != nil || handled != 1 {
+		t.Errorf("Expected the authorized envelope to be handled, got %v (%d calls)", err, handled)
+	}
+}
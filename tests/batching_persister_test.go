@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+// batchRecorder is a persister with a batch save method that records each
+// batch and can be made to fail.
+type batchRecorder struct {
+	mockEnvelopePersister
+	mu      sync.Mutex
+	batches [][]ws.Envelope
+	fail    error
+}
+
+func (b *batchRecorder) SaveEnvelopes(envelopes []ws.Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail != nil {
+		return b.fail
+	}
+	b.batches = append(b.batches, append([]ws.Envelope(nil), envelopes...))
+	return nil
+}
+
+func (b *batchRecorder) setFail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail = err
+}
+
+func (b *batchRecorder) batchSizes() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sizes := make([]int, len(b.batches))
+	for i, batch := range b.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func newEnvelopes(n int) []ws.Envelope {
+	clientID := ws.NewIdentity()
+	envelopes := make([]ws.Envelope, n)
+	for i := range envelopes {
+		envelopes[i] = ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "n"}
+	}
+	return envelopes
+}
+
+func TestBatchingPersisterFlushesOnSize(t *testing.T) {
+	inner := &batchRecorder{}
+	clock := newFakeClock()
+	p := ws.NewBatchingPersister(inner, ws.WithBatchSize(3), ws.WithBatchClock(clock))
+
+	for _, e := range newEnvelopes(7) {
+		if err := p.SaveEnvelope(e); err != nil {
+			t.Fatalf("SaveEnvelope failed: %v", err)
+		}
+	}
+
+	if sizes := inner.batchSizes(); len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 3 {
+		t.Errorf("Expected two batches of 3, got %v", sizes)
+	}
+	if p.Buffered() != 1 {
+		t.Errorf("Expected 1 envelope still buffered, got %d", p.Buffered())
+	}
+}
+
+func TestBatchingPersisterFlushesOnInterval(t *testing.T) {
+	inner := &batchRecorder{}
+	clock := newFakeClock()
+	p := ws.NewBatchingPersister(inner, ws.WithBatchSize(100), ws.WithBatchFlushInterval(time.Second), ws.WithBatchClock(clock))
+
+	for _, e := range newEnvelopes(2) {
+		p.SaveEnvelope(e)
+	}
+	clock.Advance(999 * time.Millisecond)
+	if len(inner.batchSizes()) != 0 {
+		t.Fatal("Expected no flush before the interval elapses")
+	}
+
+	clock.Advance(time.Millisecond)
+	if sizes := inner.batchSizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("Expected one batch of 2 after the interval, got %v", sizes)
+	}
+}
+
+func TestBatchingPersisterCloseFlushes(t *testing.T) {
+	inner := &mockEnvelopePersister{}
+	p := ws.NewBatchingPersister(inner, ws.WithBatchClock(newFakeClock()))
+
+	for _, e := range newEnvelopes(5) {
+		p.SaveEnvelope(e)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if n := len(inner.saved()); n != 5 {
+		t.Errorf("Expected Close to save all 5 envelopes one by one, got %d", n)
+	}
+	if err := p.SaveEnvelope(newEnvelopes(1)[0]); !errors.Is(err, ws.ErrPersisterClosed) {
+		t.Errorf("Expected ErrPersisterClosed after Close, got %v", err)
+	}
+}
+
+func TestBatchingPersisterRejectsWhileFailing(t *testing.T) {
+	inner := &batchRecorder{}
+	inner.setFail(errors.New("database down"))
+	clock := newFakeClock()
+	p := ws.NewBatchingPersister(inner,
+		ws.WithBatchSize(2),
+		ws.WithBatchClock(clock),
+		ws.WithBatchFailurePolicy(ws.BatchRejectOnFailure))
+
+	envelopes := newEnvelopes(3)
+	p.SaveEnvelope(envelopes[0])
+	p.SaveEnvelope(envelopes[1])
+	if err := p.SaveEnvelope(envelopes[2]); !errors.Is(err, ws.ErrPersisterUnavailable) {
+		t.Fatalf("Expected ErrPersisterUnavailable while failing, got %v", err)
+	}
+	if p.Buffered() != 2 {
+		t.Errorf("Expected the failed batch to stay buffered, got %d", p.Buffered())
+	}
+
+	inner.setFail(nil)
+	clock.Advance(time.Second)
+	if sizes := inner.batchSizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("Expected the retried batch to be saved, got %v", sizes)
+	}
+	if err := p.SaveEnvelope(envelopes[2]); err != nil {
+		t.Errorf("Expected saves to resume after recovery, got %v", err)
+	}
+}
+
+func TestBatchingPersisterBuffersWhileFailing(t *testing.T) {
+	inner := &batchRecorder{}
+	inner.setFail(errors.New("database down"))
+	p := ws.NewBatchingPersister(inner,
+		ws.WithBatchSize(2),
+		ws.WithBatchMaxBuffered(4),
+		ws.WithBatchClock(newFakeClock()))
+
+	envelopes := newEnvelopes(5)
+	for _, e := range envelopes[:4] {
+		if err := p.SaveEnvelope(e); err != nil {
+			t.Fatalf("Expected envelopes to be buffered while failing, got %v", err)
+		}
+	}
+	if err := p.SaveEnvelope(envelopes[4]); !errors.Is(err, ws.ErrPersisterUnavailable) {
+		t.Errorf("Expected ErrPersisterUnavailable once the buffer is full, got %v", err)
+	}
+
+	inner.setFail(nil)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Expected the shutdown flush to succeed, got %v", err)
+	}
+	if sizes := inner.batchSizes(); len(sizes) != 1 || sizes[0] != 4 {
+		t.Errorf("Expected all 4 buffered envelopes in the shutdown flush, got %v", sizes)
+	}
+}
+
+func TestBatchingPersisterConfirmFlushesPending(t *testing.T) {
+	inner := &mockEnvelopePersister{}
+	p := ws.NewBatchingPersister(inner, ws.WithBatchClock(newFakeClock()))
+
+	e := newEnvelopes(1)[0]
+	p.SaveEnvelope(e)
+	if ok, _ := p.Exists(e.ID); !ok {
+		t.Error("Expected a buffered envelope to exist")
+	}
+	if err := p.ConfirmDelivery(e.ID, e.ClientID); err != nil {
+		t.Fatalf("ConfirmDelivery failed: %v", err)
+	}
+	if len(inner.saved()) != 1 || len(inner.confirmedIDs()) != 1 {
+		t.Errorf("Expected the envelope to be saved before it was confirmed")
+	}
+}
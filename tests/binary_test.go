@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// echoHandler writes every frame back to the client using the frame type it
+// arrived with.
+type echoHandler struct {
+	mu     sync.Mutex
+	text   [][]byte
+	binary [][]byte
+}
+
+func (e *echoHandler) Handle(client *ws.Client, data []byte) error {
+	data = client.Retain(data)
+	e.mu.Lock()
+	e.text = append(e.text, data)
+	e.mu.Unlock()
+	client.Send <- data
+	return nil
+}
+
+func (e *echoHandler) HandleBinary(client *ws.Client, data []byte) error {
+	data = client.Retain(data)
+	e.mu.Lock()
+	e.binary = append(e.binary, data)
+	e.mu.Unlock()
+	return client.SendBinary(data)
+}
+
+func (e *echoHandler) counts() (text, binary int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.text), len(e.binary)
+}
+
+func roundTrip(t *testing.T, conn *wstest.Conn, messageType int, data []byte) {
+	t.Helper()
+	if err := conn.WriteMessage(messageType, data); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	gotType, got, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected echoed frame, got %v", err)
+	}
+	if gotType != messageType {
+		t.Errorf("Expected frame type %d, got %d", messageType, gotType)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+}
+
+func TestBinaryAndTextFramesRoundTrip(t *testing.T) {
+	echo := &echoHandler{}
+	conn := wstest.ConnectPipe(t, ws.NewWebSocketHandler(&mockSessionValidator{}, echo, nil))
+
+	roundTrip(t, conn, websocket.BinaryMessage, []byte{0x08, 0x96, 0x01, 0x00, 0xff})
+	roundTrip(t, conn, websocket.TextMessage, []byte("hello"))
+
+	if text, binary := echo.counts(); text != 1 || binary != 1 {
+		t.Errorf("Expected one text and one binary frame, got %d and %d", text, binary)
+	}
+}
+
+func TestBinaryFramesFallBackToHandle(t *testing.T) {
+	messages := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	conn := wstest.ConnectPipe(t, ws.NewWebSocketHandler(&mockSessionValidator{}, messages, persister))
+
+	data := []byte(`{"type":"chat"}`)
+	conn.WriteMessage(websocket.BinaryMessage, data)
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Fatal("Expected binary frame to reach Handle")
+	}
+	if !bytes.Equal(messages.received()[0], data) {
+		t.Errorf("Expected %q, got %q", data, messages.received()[0])
+	}
+	if n := len(persister.saved()); n != 0 {
+		t.Errorf("Expected binary frames not to be persisted, got %d", n)
+	}
+}
+
+func TestSendBinaryOnClosedClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	conn := wstest.ConnectPipe(t, handler)
+
+	client := registeredClient(t, handler)
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected client to be unregistered")
+	}
+
+	if err := client.SendBinary([]byte{1}); err != ws.ErrClientNotConnected {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+func TestBroadcastReachesAllClients(t *testing.T) {
+	const count = 12
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	conns := make([]*wstest.Conn, count)
+	for i := range conns {
+		conns[i] = connect(t, server)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == count }) {
+		t.Fatalf("Expected %d clients, got %d", count, handler.Len())
+	}
+
+	result, err := handler.BroadcastJSON(map[string]string{"type": "announcement"})
+	if err != nil {
+		t.Fatalf("Expected broadcast to succeed, got %v", err)
+	}
+	if result.Delivered != count || result.Dropped != 0 {
+		t.Errorf("Expected %d delivered and 0 dropped, got %+v", count, result)
+	}
+
+	for i, conn := range conns {
+		var msg map[string]string
+		if conn.ExpectJSON(&msg, 2*time.Second); msg["type"] != "announcement" {
+			t.Errorf("Client %d received unexpected message %v", i, msg)
+		}
+	}
+}
+
+func TestBroadcastSkipsStalledClient(t *testing.T) {
+	const count = 12
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	// A registered client without running pumps never drains its queue.
+	stalled := ws.NewClient(ws.NewIdentity(), nil)
+	handler.Register(stalled)
+	for i := 0; i < 256; i++ {
+		handler.Broadcast([]byte("filler"))
+	}
+
+	conns := make([]*wstest.Conn, count)
+	for i := range conns {
+		conns[i] = connect(t, server)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == count+1 }) {
+		t.Fatalf("Expected %d clients, got %d", count+1, handler.Len())
+	}
+
+	start := time.Now()
+	result := handler.Broadcast([]byte("live"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected broadcast not to block on the stalled client, took %v", elapsed)
+	}
+	if result.Delivered != count || result.Dropped != 1 {
+		t.Errorf("Expected %d delivered and 1 dropped, got %+v", count, result)
+	}
+
+	for i, conn := range conns {
+		if data := conn.ExpectMessage(2 * time.Second); string(data) != "live" {
+			t.Errorf("Client %d expected live broadcast, got %q", i, data)
+		}
+	}
+}
+
+func TestBroadcastJSONMarshalError(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+
+	if _, err := handler.BroadcastJSON(make(chan int)); err == nil {
+		t.Error("Expected marshal error to be returned")
+	}
+}
+
+func TestBroadcastFunc(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Registered clients without running pumps keep what is queued for
+	// them, so QueuedBytes shows who was sent the frame.
+	newClient := func(locale string, connected time.Time) *ws.Client {
+		client := ws.NewClient(ws.NewIdentity(), nil)
+		client.Set("locale", locale)
+		client.Connected = connected
+		handler.Register(client)
+		return client
+	}
+	earlyDE := newClient("de", noon.Add(-time.Hour))
+	lateDE := newClient("de", noon.Add(time.Hour))
+	earlyFR := newClient("fr", noon.Add(-2*time.Hour))
+	fullDE := newClient("de", noon.Add(-time.Minute))
+	onlyFull := func(c *ws.Client) bool { return c == fullDE }
+	for handler.BroadcastFunc(onlyFull, []byte("filler")).Dropped == 0 {
+	}
+	before := fullDE.QueuedBytes()
+
+	result := handler.BroadcastFunc(func(c *ws.Client) bool {
+		locale, _ := c.Get("locale")
+		return locale == "de" && c.Connected.Before(noon)
+	}, []byte("hallo"))
+
+	if result.Matched != 2 || result.Delivered != 1 || result.Dropped != 1 {
+		t.Errorf("Expected 2 matched, 1 delivered and 1 dropped, got %+v", result)
+	}
+	if earlyDE.QueuedBytes() != int64(len("hallo")) {
+		t.Errorf("Expected the early German client to get the frame, got %d bytes queued", earlyDE.QueuedBytes())
+	}
+	if lateDE.QueuedBytes() != 0 || earlyFR.QueuedBytes() != 0 {
+		t.Errorf("Expected unmatched clients to get nothing, got %d and %d bytes queued", lateDE.QueuedBytes(), earlyFR.QueuedBytes())
+	}
+	if fullDE.QueuedBytes() != before {
+		t.Errorf("Expected the full client's queue to be unchanged, got %d bytes instead of %d", fullDE.QueuedBytes(), before)
+	}
+}
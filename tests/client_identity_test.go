@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestIdentityIsZero(t *testing.T) {
+	if !(ws.Identity{}).IsZero() {
+		t.Error("Expected zero value identity to be zero")
+	}
+	if ws.NewIdentity().IsZero() {
+		t.Error("Expected generated identity not to be zero")
+	}
+}
+
+func TestExplicitClientIDIsKept(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	_, resp := dialWithResponse(t, server, nil)
+
+	if got := resp.Header.Get(ws.ClientIDHeader); got != clientID.String() {
+		t.Errorf("Expected %s header %s, got %q", ws.ClientIDHeader, clientID, got)
+	}
+}
+
+func TestZeroClientIDIsGenerated(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	_, first := dialWithResponse(t, server, nil)
+	_, second := dialWithResponse(t, server, nil)
+
+	firstID, err := uuid.Parse(first.Header.Get(ws.ClientIDHeader))
+	if err != nil {
+		t.Fatalf("Expected a generated client ID header, got %q", first.Header.Get(ws.ClientIDHeader))
+	}
+	secondID, err := uuid.Parse(second.Header.Get(ws.ClientIDHeader))
+	if err != nil {
+		t.Fatalf("Expected a generated client ID header, got %q", second.Header.Get(ws.ClientIDHeader))
+	}
+
+	if firstID == uuid.Nil || secondID == uuid.Nil {
+		t.Error("Expected generated client IDs not to be zero")
+	}
+	if firstID == secondID {
+		t.Error("Expected each connection to receive a unique client ID")
+	}
+}
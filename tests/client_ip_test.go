@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestTrustedProxyResolver(t *testing.T) {
+	resolver := ws.TrustedProxyResolver{Trusted: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{"no headers", "10.0.0.5:4000", nil, "10.0.0.5"},
+		{"direct client", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"single forwarded entry", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"multiple entries skip trusted hops", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.9, 10.1.1.1"}}, "203.0.113.9"},
+		{"repeated headers", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1", "203.0.113.9"}}, "203.0.113.9"},
+		{"all hops trusted", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"10.2.2.2, 10.3.3.3"}}, "10.2.2.2"},
+		{"x-real-ip fallback", "10.0.0.5:4000",
+			map[string][]string{"X-Real-IP": {"198.51.100.4"}}, "198.51.100.4"},
+		{"forwarded-for preferred", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-IP": {"198.51.100.4"}}, "198.51.100.1"},
+		{"untrusted peer spoofing", "203.0.113.7:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-IP": {"198.51.100.4"}}, "203.0.113.7"},
+		{"malformed entries ignored", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"not-an-ip"}}, "10.0.0.5"},
+		{"malformed hop hides those left of it", "10.0.0.5:4000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage, 203.0.113.9"}}, "203.0.113.9"},
+		{"ipv6 proxy", "[fd00::1]:4000",
+			map[string][]string{"X-Forwarded-For": {"2001:db8::42"}}, "2001:db8::42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+			if got := resolver.ClientIP(r); got != netip.MustParseAddr(tt.want) {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolvedIPOnClientAndPresence(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	header := identityHeader(clientID)
+	header.Set("X-Forwarded-For", "198.51.100.23")
+	dialWithResponse(t, server, header)
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	want := netip.MustParseAddr("198.51.100.23")
+	client, _ := handler.Get(clientID)
+	if got := client.RemoteIP(); got != want {
+		t.Errorf("Expected RemoteIP %s, got %s", want, got)
+	}
+	presence := handler.Presence()
+	if len(presence) != 1 || presence[0].RemoteIP != want {
+		t.Errorf("Expected presence to report %s, got %+v", want, presence)
+	}
+}
+
+func TestDefaultResolverIgnoresForwardingHeaders(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	header := identityHeader(clientID)
+	header.Set("X-Forwarded-For", "198.51.100.23")
+	dialWithResponse(t, server, header)
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	client, _ := handler.Get(clientID)
+	if got := client.RemoteIP(); !got.IsLoopback() {
+		t.Errorf("Expected the peer address without trusted proxies, got %s", got)
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestClientMetadataSetAndGet(t *testing.T) {
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	if _, ok := client.Get("room"); ok {
+		t.Error("Expected missing key to report false")
+	}
+
+	client.Set("room", "lobby")
+	if v, ok := client.Get("room"); !ok || v != "lobby" {
+		t.Errorf("Expected lobby, got %v", v)
+	}
+
+	snapshot := client.MetadataSnapshot()
+	snapshot["room"] = "changed"
+	if v, _ := client.Get("room"); v != "lobby" {
+		t.Error("Expected snapshot to be a copy")
+	}
+}
+
+func TestSessionMetadataCopiedOnConnect(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{
+		ClientID: clientID,
+		Metadata: map[string]string{"role": "admin", "locale": "de"},
+	}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+	client, _ := handler.Get(clientID)
+
+	if v, _ := client.Get("role"); v != "admin" {
+		t.Errorf("Expected role admin, got %v", v)
+	}
+	if v, _ := client.Get("locale"); v != "de" {
+		t.Errorf("Expected locale de, got %v", v)
+	}
+}
+
+type metadataMessageHandler struct{}
+
+func (m *metadataMessageHandler) Handle(client *ws.Client, data []byte) error {
+	count, _ := client.Get("count")
+	n, _ := count.(int)
+	client.Set("count", n+1)
+	client.Set("last", string(data))
+	client.Send <- client.Retain(data)
+	return nil
+}
+
+func TestClientMetadataConcurrentAccess(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &metadataMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+	client, _ := handler.Get(clientID)
+
+	const messages = 200
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				client.Set(fmt.Sprintf("reader-%d", i), j)
+				client.MetadataSnapshot()
+			}
+		}(i)
+	}
+
+	for i := 0; i < messages; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%d", i)))
+	}
+	for i := 0; i < messages; i++ {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("Failed to read echo %d: %v", i, err)
+		}
+	}
+	wg.Wait()
+
+	if v, _ := client.Get("count"); v != messages {
+		t.Errorf("Expected count %d, got %v", messages, v)
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestClientTags(t *testing.T) {
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	client.AddTag("admin")
+	if !client.HasTag("admin") {
+		t.Error("Expected client to have admin tag")
+	}
+
+	client.RemoveTag("admin")
+	if client.HasTag("admin") {
+		t.Error("Expected admin tag to be removed")
+	}
+}
+
+func TestClientsWithOverlappingTags(t *testing.T) {
+	hub := ws.NewHub()
+	admin := ws.NewClient(ws.NewIdentity(), nil)
+	beta := ws.NewClient(ws.NewIdentity(), nil)
+
+	// Tags added before registration are indexed on Register.
+	admin.AddTag("admin")
+	hub.Register(admin)
+	hub.Register(beta)
+
+	admin.AddTag("region:eu")
+	beta.AddTag("beta")
+	beta.AddTag("region:eu")
+
+	if clients := hub.ClientsWithTag("admin"); len(clients) != 1 || clients[0] != admin {
+		t.Errorf("Expected only admin to be tagged admin, got %d clients", len(clients))
+	}
+	if clients := hub.ClientsWithTag("region:eu"); len(clients) != 2 {
+		t.Errorf("Expected both clients tagged region:eu, got %d", len(clients))
+	}
+
+	if result := hub.BroadcastToTagged("region:eu", []byte("eu")); result.Delivered != 2 {
+		t.Errorf("Expected 2 deliveries, got %+v", result)
+	}
+	if result := hub.BroadcastToTagged("beta", []byte("beta")); result.Delivered != 1 {
+		t.Errorf("Expected 1 delivery, got %+v", result)
+	}
+	if result := hub.BroadcastToTagged("nobody", []byte("x")); result.Delivered != 0 {
+		t.Errorf("Expected no deliveries, got %+v", result)
+	}
+
+	beta.RemoveTag("region:eu")
+	if clients := hub.ClientsWithTag("region:eu"); len(clients) != 1 {
+		t.Errorf("Expected removed tag to leave the index, got %d clients", len(clients))
+	}
+}
+
+func TestTagIndexCleanedOnUnregister(t *testing.T) {
+	hub := ws.NewHub()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+	hub.Register(client)
+	client.AddTag("admin")
+	client.AddTag("beta")
+
+	hub.Unregister(client)
+
+	if clients := hub.ClientsWithTag("admin"); len(clients) != 0 {
+		t.Errorf("Expected admin index to be empty, got %d", len(clients))
+	}
+	if clients := hub.ClientsWithTag("beta"); len(clients) != 0 {
+		t.Errorf("Expected beta index to be empty, got %d", len(clients))
+	}
+
+	client.AddTag("late")
+	if clients := hub.ClientsWithTag("late"); len(clients) != 0 {
+		t.Error("Expected tags added after unregister not to be indexed")
+	}
+	if !client.HasTag("admin") {
+		t.Error("Expected client to keep its own tags after unregister")
+	}
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/msgpack"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+type codecCase struct {
+	name        string
+	subprotocol string
+	codec       ws.Codec
+	frameType   int
+}
+
+var codecCases = []codecCase{
+	{name: "json", codec: ws.JSONCodec{}, frameType: websocket.TextMessage},
+	{name: "msgpack", subprotocol: msgpack.Subprotocol, codec: msgpack.Codec{}, frameType: websocket.BinaryMessage},
+}
+
+func writeEnvelope(t *testing.T, conn *websocket.Conn, codec ws.Codec, envelope ws.Envelope) {
+	t.Helper()
+	data, messageType, err := codec.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to encode envelope: %v", err)
+	}
+	if err := conn.WriteMessage(messageType, data); err != nil {
+		t.Fatalf("Failed to send envelope: %v", err)
+	}
+}
+
+func readCodecEnvelope(t *testing.T, conn *websocket.Conn, tc codecCase) ws.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected an envelope frame, got %v", err)
+	}
+	if messageType != tc.frameType {
+		t.Fatalf("Expected frame type %d, got %d", tc.frameType, messageType)
+	}
+	envelope, err := tc.codec.Unmarshal(data, messageType)
+	if err != nil {
+		t.Fatalf("Failed to decode envelope: %v", err)
+	}
+	return envelope
+}
+
+func TestCodecPipeline(t *testing.T) {
+	const large int64 = 9007199254740993
+
+	for _, tc := range codecCases {
+		t.Run(tc.name, func(t *testing.T) {
+			persister := &mockEnvelopePersister{}
+			messages := &mockMessageHandler{}
+			handler := ws.NewWebSocketHandler(&headerSessionValidator{}, messages, persister,
+				ws.WithSubprotocolCodec(msgpack.Subprotocol, msgpack.Codec{}))
+
+			clientID := ws.NewIdentity()
+			opts := []wstest.Option{wstest.WithHeader("X-Test-Client-ID", clientID.String())}
+			if tc.subprotocol != "" {
+				opts = append(opts, wstest.WithSubprotocols(tc.subprotocol))
+			}
+			conn := wstest.ConnectPipe(t, handler, opts...).Conn
+			if conn.Subprotocol() != tc.subprotocol {
+				t.Fatalf("Expected subprotocol %q, got %q", tc.subprotocol, conn.Subprotocol())
+			}
+
+			// Inbound: the envelope is persisted, handled and acked.
+			inbound, _ := ws.NewEnvelope(clientID, "order.placed", orderPlaced{OrderID: large, Items: []string{"a"}})
+			writeEnvelope(t, conn, tc.codec, inbound)
+
+			ack := readCodecEnvelope(t, conn, tc)
+			if ack.Type != ws.AckMessageType || ack.ID != inbound.ID {
+				t.Errorf("Expected ack for %s, got %+v", inbound.ID, ack)
+			}
+			if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+				t.Fatal("Expected the message handler to receive the envelope")
+			}
+			var saved orderPlaced
+			persister.saved()[0].DecodePayload(&saved)
+			if saved.OrderID != large {
+				t.Errorf("Expected persisted order ID %d, got %d", large, saved.OrderID)
+			}
+
+			// Outbound: the envelope arrives in the negotiated encoding and
+			// an ack encoded the same way confirms it.
+			outbound, _ := ws.NewEnvelope(clientID, "order.shipped", orderPlaced{OrderID: large})
+			if err := handler.SendEnvelope(outbound); err != nil {
+				t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+			}
+			received := readCodecEnvelope(t, conn, tc)
+			if received.ID != outbound.ID || received.Type != "order.shipped" {
+				t.Errorf("Expected envelope %s, got %+v", outbound.ID, received)
+			}
+			var shipped orderPlaced
+			received.DecodePayload(&shipped)
+			if shipped.OrderID != large {
+				t.Errorf("Expected outbound order ID %d, got %d", large, shipped.OrderID)
+			}
+
+			writeEnvelope(t, conn, tc.codec, ws.Envelope{ID: received.ID, Type: ws.AckMessageType})
+			if !waitFor(t, 2*time.Second, func() bool { return len(persister.confirmedIDs()) == 1 }) {
+				t.Fatal("Expected the ack to confirm delivery")
+			}
+		})
+	}
+}
+
+func TestMsgpackCodecRejectsTextFrames(t *testing.T) {
+	if _, err := (msgpack.Codec{}).Unmarshal([]byte(`{"type":"chat"}`), websocket.TextMessage); err == nil {
+		t.Error("Expected the msgpack codec to reject text frames")
+	}
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// rawFrame is a server frame read off the wire without gorilla, so the
+// RSV1 bit marking a compressed message is visible.
+type rawFrame struct {
+	compressed bool
+	opcode     byte
+	payload    []byte
+}
+
+// dialRaw performs the opening handshake by hand, offering
+// permessage-deflate.
+func dialRaw(t *testing.T, server *httptest.Server, header http.Header) (*bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	return reader, resp
+}
+
+func readRawFrame(t *testing.T, r *bufio.Reader) rawFrame {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatalf("Failed to read frame header: %v", err)
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("Failed to read frame payload: %v", err)
+	}
+	return rawFrame{compressed: head[0]&0x40 != 0, opcode: head[0] & 0x0f, payload: payload}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []ws.Option
+		offer  bool
+		expect bool
+	}{
+		{"enabled and offered", []ws.Option{ws.WithCompression(true, 0)}, true, true},
+		{"enabled but not offered", []ws.Option{ws.WithCompression(true, 0)}, false, false},
+		{"disabled", nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, tt.opts...)
+			server := newTestServer(t, handler)
+
+			clientID := ws.NewIdentity()
+			dialer := websocket.Dialer{EnableCompression: tt.offer}
+			conn, resp, err := dialer.Dial(wsURL(server), identityHeader(clientID))
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			negotiated := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+			if negotiated != tt.expect {
+				t.Errorf("Expected negotiation %v, got header %q", tt.expect, resp.Header.Get("Sec-WebSocket-Extensions"))
+			}
+			if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+				t.Fatal("Expected client to be registered")
+			}
+			client, _ := handler.Get(clientID)
+			if client.Compressed() != tt.expect {
+				t.Errorf("Expected Compressed() %v, got %v", tt.expect, client.Compressed())
+			}
+
+			// gorilla inflates transparently either way.
+			payload := bytes.Repeat([]byte(`{"k":"v"}`), 100)
+			if err := handler.SendTo(clientID, payload); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, data, err := conn.ReadMessage(); err != nil || !bytes.Equal(data, payload) {
+				t.Errorf("Expected the payload back intact, got %d bytes (%v)", len(data), err)
+			}
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithCompression(true, 256),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	reader, _ := dialRaw(t, server, identityHeader(clientID))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	small := []byte(`{"type":"tick"}`)
+	large := bytes.Repeat([]byte(`{"type":"tick"}`), 100)
+	for _, payload := range [][]byte{small, large} {
+		if err := handler.SendTo(clientID, payload); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+
+	frame := readRawFrame(t, reader)
+	if frame.compressed || !bytes.Equal(frame.payload, small) {
+		t.Errorf("Expected the small frame uncompressed, got compressed=%v %q", frame.compressed, frame.payload)
+	}
+	frame = readRawFrame(t, reader)
+	if !frame.compressed || len(frame.payload) >= len(large) {
+		t.Errorf("Expected the large frame compressed, got compressed=%v with %d bytes", frame.compressed, len(frame.payload))
+	}
+
+	client, _ := handler.Get(clientID)
+	stats := client.CompressionStats()
+	if stats.UncompressedBytes != uint64(len(small)) || stats.CompressedBytes != uint64(len(large)) {
+		t.Errorf("Expected %d uncompressed and %d compressed bytes, got %+v", len(small), len(large), stats)
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+type slowValidator struct {
+	delay time.Duration
+}
+
+func (v slowValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	if v.delay > 0 {
+		time.Sleep(v.delay)
+		return ws.SessionInfo{}, nil
+	}
+	<-r.Context().Done()
+	return ws.SessionInfo{}, r.Context().Err()
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := ws.DefaultConfig()
+	if cfg.ReadBufferSize != 1024 || cfg.WriteBufferSize != 1024 {
+		t.Errorf("Expected 1024-byte buffers, got %d and %d", cfg.ReadBufferSize, cfg.WriteBufferSize)
+	}
+	if cfg.SendQueueSize != 256 {
+		t.Errorf("Expected a send queue of 256, got %d", cfg.SendQueueSize)
+	}
+	if cfg.HandshakeTimeout != 10*time.Second {
+		t.Errorf("Expected a 10s handshake timeout, got %v", cfg.HandshakeTimeout)
+	}
+	if cfg.PingInterval >= cfg.PongWait {
+		t.Errorf("Expected the ping interval %v to be shorter than the pong wait %v", cfg.PingInterval, cfg.PongWait)
+	}
+}
+
+func TestSendQueueSize(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithSendQueueSize(8),
+	)
+	server := newTestServer(t, handler)
+	dial(t, server)
+
+	client := registeredClient(t, handler)
+	if got := cap(client.Send); got != 8 {
+		t.Errorf("Expected a send queue of 8, got %d", got)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator slowValidator
+	}{
+		{"validator honors context", slowValidator{}},
+		{"validator ignores context", slowValidator{delay: 200 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var rejected error
+			handler := ws.NewWebSocketHandler(tt.validator, &mockMessageHandler{}, &mockEnvelopePersister{},
+				ws.WithHandshakeTimeout(50*time.Millisecond),
+				ws.WithOnReject(func(r *http.Request, status int, err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					rejected = err
+				}),
+			)
+			server := newTestServer(t, handler)
+
+			start := time.Now()
+			if got := dialStatus(wsURL(server), nil); got != http.StatusServiceUnavailable {
+				t.Errorf("Expected 503 Service Unavailable, got %d", got)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected the handshake to be cut short, took %v", elapsed)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !errors.Is(rejected, ws.ErrHandshakeTimeout) {
+				t.Errorf("Expected ErrHandshakeTimeout, got %v", rejected)
+			}
+		})
+	}
+
+	handler := ws.NewWebSocketHandler(slowValidator{delay: 50 * time.Millisecond}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithHandshakeTimeout(time.Second),
+	)
+	server := newTestServer(t, handler)
+	if got := dialStatus(wsURL(server), nil); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected a handshake within the timeout to succeed, got %d", got)
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	cfg := ws.DefaultConfig()
+	cfg.SendQueueSize = 4
+	cfg.MaxConnections = 1
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithConfig(cfg),
+	)
+	server := newTestServer(t, handler)
+	dial(t, server)
+
+	client := registeredClient(t, handler)
+	if got := cap(client.Send); got != 4 {
+		t.Errorf("Expected a send queue of 4, got %d", got)
+	}
+	if got := dialStatus(wsURL(server), nil); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected MaxConnections from the Config to apply, got %d", got)
+	}
+}
+
+func TestWithConfigFillsZeroSizes(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithConfig(ws.Config{}),
+	)
+	server := newTestServer(t, handler)
+	dial(t, server)
+
+	client := registeredClient(t, handler)
+	if got := cap(client.Send); got != ws.DefaultConfig().SendQueueSize {
+		t.Errorf("Expected the default send queue, got %d", got)
+	}
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type echoMessageHandler struct{}
+
+func (e *echoMessageHandler) Handle(client *ws.Client, data []byte) error {
+	client.Send <- client.Retain(data)
+	return nil
+}
+
+func TestConnectionStaysOpenAcrossMessages(t *testing.T) {
+	validator := &mockSessionValidator{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(validator, &echoMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(400 * time.Millisecond)
+
+		want := fmt.Sprintf("message %d", i)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(want)); err != nil {
+			t.Fatalf("Failed to write message %d: %v", i, err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Connection dropped before message %d was echoed: %v", i, err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
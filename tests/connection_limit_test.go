@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestMaxConnectionsRejectsExcessUpgrades(t *testing.T) {
+	const limit = 5
+	var rejected atomic.Int32
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithMaxConnections(limit),
+		ws.WithOnReject(func(r *http.Request, status int, err error) {
+			if status == http.StatusServiceUnavailable && errors.Is(err, ws.ErrTooManyConnections) {
+				rejected.Add(1)
+			}
+		}),
+	)
+	server := newTestServer(t, handler)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		refused   []*http.Response
+	)
+	for i := 0; i < limit+5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				refused = append(refused, resp)
+				return
+			}
+			succeeded++
+			t.Cleanup(func() { conn.Close() })
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != limit {
+		t.Errorf("Expected exactly %d connections, got %d", limit, succeeded)
+	}
+	if len(refused) != 5 {
+		t.Fatalf("Expected 5 refused connections, got %d", len(refused))
+	}
+	for _, resp := range refused {
+		if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %v", resp)
+			continue
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header on rejection")
+		}
+	}
+	if rejected.Load() != 5 {
+		t.Errorf("Expected reject hook to fire 5 times, got %d", rejected.Load())
+	}
+}
+
+func TestMaxConnectionsFreesSlotsOnDisconnect(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithMaxConnections(1),
+	)
+	server := newTestServer(t, handler)
+
+	first := dial(t, server)
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil); err == nil {
+		t.Fatal("Expected second connection to be refused")
+	}
+
+	first.Close()
+	reconnected := waitFor(t, 2*time.Second, func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+	if !reconnected {
+		t.Error("Expected freed slot to accept a new connection")
+	}
+}
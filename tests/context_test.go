@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+type tenantKey struct{}
+type baseKey struct{}
+
+// withTenant stands in for HTTP middleware storing a value in the request's
+// context before the upgrade.
+func withTenant(tenant string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+func TestConnectionContextCarriesRequestValues(t *testing.T) {
+	handled := make(chan context.Context, 1)
+	messages := ws.ContextMessageHandlerFunc(func(ctx context.Context, client *ws.Client, data []byte) error {
+		handled <- ctx
+		return nil
+	})
+	base := context.WithValue(context.Background(), baseKey{}, "base")
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithBaseContext(base),
+		ws.WithContextExtractor(ws.CopyRequestValues(tenantKey{})),
+	)
+	conn := wstest.NewServer(t, withTenant("acme", handler)).Connect(t)
+
+	conn.MustSendEnvelope("hello", nil)
+	var ctx context.Context
+	select {
+	case ctx = <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the message to be handled")
+	}
+	if ctx.Value(tenantKey{}) != "acme" || ctx.Value(baseKey{}) != "base" {
+		t.Errorf("Expected the tenant and base values, got %v and %v", ctx.Value(tenantKey{}), ctx.Value(baseKey{}))
+	}
+	client := registeredClient(t, handler)
+	if client.Context().Value(tenantKey{}) != "acme" || client.Context().Err() != nil {
+		t.Error("Expected the client's context to carry the tenant while connected")
+	}
+}
+
+func TestConnectionContextCancelledOnDisconnect(t *testing.T) {
+	connected := make(chan context.Context, 1)
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithOnConnect(func(client *ws.Client, _ ws.SessionInfo) { connected <- client.Context() }),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	ctx := <-connected
+	if ctx.Err() != nil {
+		t.Fatalf("Expected a live context in the connect hook, got %v", ctx.Err())
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the context to be cancelled on disconnect")
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(context.Cause(ctx), &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+		t.Errorf("Expected the close as the cause, got %v", context.Cause(ctx))
+	}
+}
+
+func TestConnectionContextCancelledOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	messages := ws.ContextMessageHandlerFunc(func(ctx context.Context, client *ws.Client, data []byte) error {
+		close(started)
+		<-ctx.Done()
+		stopped <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{})
+	conn := connect(t, newTestServer(t, handler))
+	conn.MustSendEnvelope("work", nil)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		shutdown <- handler.Shutdown(ctx)
+	}()
+
+	select {
+	case cause := <-stopped:
+		if !errors.Is(cause, ws.ErrShuttingDown) {
+			t.Errorf("Expected ErrShuttingDown as the cause, got %v", cause)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Shutdown to cancel the handler's context")
+	}
+	conn.ExpectClose(websocket.CloseGoingAway)
+	if err := <-shutdown; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestMessageHandlerWithoutContextStillHandled(t *testing.T) {
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{})
+	conn := connect(t, newTestServer(t, handler))
+
+	conn.MustSendEnvelope("hello", nil)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Error("Expected Handle to receive the message")
+	}
+	if registeredClient(t, handler).TraceContext().Done() == nil {
+		t.Error("Expected TraceContext to fall back to the cancellable connection context")
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// plainPersister hides mockEnvelopePersister's optional interfaces so only
+// the handler's own cache can detect duplicates.
+type plainPersister struct {
+	ws.EnvelopePersister
+}
+
+func readAckID(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected an ack frame, got %v", err)
+	}
+	var frame struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	json.Unmarshal(data, &frame)
+	if frame.Type != ws.AckMessageType {
+		t.Fatalf("Expected an ack frame, got %s", data)
+	}
+	return frame.ID
+}
+
+func sendTwice(t *testing.T, handler *ws.WebsocketHandler) *websocket.Conn {
+	t.Helper()
+	server := newTestServer(t, handler)
+	conn, _ := dialWithResponse(t, server, http.Header{})
+
+	id := ws.NewIdentity().String()
+	frame, _ := json.Marshal(map[string]any{"id": id, "type": "chat", "payload": map[string]any{"text": "hi"}})
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		if got := readAckID(t, conn); got != id {
+			t.Fatalf("Expected ack for %s, got %s", id, got)
+		}
+	}
+	return conn
+}
+
+func TestDuplicateEnvelopeHandledOnce(t *testing.T) {
+	messages := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, plainPersister{persister})
+	sendTwice(t, handler)
+
+	if n := len(messages.received()); n != 1 {
+		t.Errorf("Expected the handler to see the message once, got %d", n)
+	}
+	if n := len(persister.saved()); n != 1 {
+		t.Errorf("Expected the envelope to be persisted once, got %d", n)
+	}
+}
+
+func TestDuplicateDetectedByPersister(t *testing.T) {
+	messages := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, persister, ws.WithDedupeCacheSize(0))
+	sendTwice(t, handler)
+
+	if n := len(messages.received()); n != 1 {
+		t.Errorf("Expected the handler to see the message once, got %d", n)
+	}
+}
+
+func TestEnvelopesWithoutIDAreNotDeduplicated(t *testing.T) {
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	frame := []byte(`{"type":"chat","payload":{"text":"hi"}}`)
+	conn.WriteMessage(websocket.TextMessage, frame)
+	conn.WriteMessage(websocket.TextMessage, frame)
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 2 }) {
+		t.Errorf("Expected both messages to be handled, got %d", len(messages.received()))
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func dialStatus(server string, header http.Header) int {
+	conn, resp, err := websocket.DefaultDialer.Dial(server, header)
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, code) {
+				t.Errorf("Expected close %d, got %v", code, err)
+			}
+			return
+		}
+	}
+}
+
+func TestBanDisconnectsAndRefusesReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var rejections []error
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithOnReject(func(r *http.Request, status int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if status == http.StatusForbidden {
+				rejections = append(rejections, err)
+			}
+		}),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	handler.DenyList.Ban(clientID, time.Time{})
+	expectClose(t, conn, websocket.ClosePolicyViolation)
+
+	if got := dialStatus(wsURL(server), identityHeader(clientID)); got != http.StatusForbidden {
+		t.Errorf("Expected a banned identity to be refused with 403, got %d", got)
+	}
+	mu.Lock()
+	if len(rejections) != 1 || !errors.Is(rejections[0], ws.ErrBanned) {
+		t.Errorf("Expected one ErrBanned rejection, got %v", rejections)
+	}
+	mu.Unlock()
+
+	if got := dialStatus(wsURL(server), identityHeader(ws.NewIdentity())); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected other identities to connect, got %d", got)
+	}
+
+	handler.DenyList.Unban(clientID)
+	if got := dialStatus(wsURL(server), identityHeader(clientID)); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected the identity to connect after Unban, got %d", got)
+	}
+}
+
+func TestBanIPRange(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithRealIPHeader("X-Real-IP"),
+	)
+	server := newTestServer(t, handler)
+
+	inside, _, err := dialFrom(wsURL(server), "10.0.0.9")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer inside.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 1 }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	handler.DenyList.BanIP(netip.MustParsePrefix("10.0.0.0/24"), time.Time{})
+	expectClose(t, inside, websocket.ClosePolicyViolation)
+
+	for ip, want := range map[string]int{
+		"10.0.0.7":   http.StatusForbidden,
+		"10.0.0.255": http.StatusForbidden,
+		"10.0.1.1":   http.StatusSwitchingProtocols,
+	} {
+		header := http.Header{"X-Real-IP": {ip}}
+		if got := dialStatus(wsURL(server), header); got != want {
+			t.Errorf("Expected status %d for %s, got %d", want, ip, got)
+		}
+	}
+
+	handler.DenyList.UnbanIP(netip.MustParsePrefix("10.0.0.1/24"))
+	if handler.DenyList.BannedIP(netip.MustParseAddr("10.0.0.7")) {
+		t.Error("Expected UnbanIP to lift the range ban")
+	}
+}
+
+func TestBanExpires(t *testing.T) {
+	clock := newFakeClock()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithClock(clock),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	handler.DenyList.Ban(clientID, clock.Now().Add(time.Minute))
+	handler.DenyList.BanIP(netip.MustParsePrefix("192.0.2.0/24"), clock.Now().Add(time.Hour))
+
+	if got := dialStatus(wsURL(server), identityHeader(clientID)); got != http.StatusForbidden {
+		t.Fatalf("Expected the ban to apply, got %d", got)
+	}
+
+	clock.Advance(time.Minute)
+	if got := dialStatus(wsURL(server), identityHeader(clientID)); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected the identity to connect once the ban lapsed, got %d", got)
+	}
+	if !handler.DenyList.BannedIP(netip.MustParseAddr("192.0.2.10")) {
+		t.Error("Expected the longer IP ban to still apply")
+	}
+	clock.Advance(time.Hour)
+	if handler.DenyList.BannedIP(netip.MustParseAddr("192.0.2.10")) {
+		t.Error("Expected the IP ban to lapse")
+	}
+}
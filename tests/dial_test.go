@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wsauth"
+)
+
+// receiveType reads envelopes from conn until one of type msgType arrives.
+func receiveType(t *testing.T, conn *ws.ClientConn, msgType string) ws.Envelope {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case env, ok := <-conn.Receive():
+			if !ok {
+				t.Fatalf("Connection ended waiting for %s: %v", msgType, conn.Err())
+			}
+			if env.Type == msgType {
+				return env
+			}
+		case <-timeout:
+			t.Fatalf("Expected a %s envelope", msgType)
+		}
+	}
+}
+
+func dialClient(t *testing.T, url string, opts ...ws.DialOption) *ws.ClientConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := ws.Dial(ctx, url, opts...)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(websocket.CloseNormalClosure, "") })
+	return conn
+}
+
+func TestDialExchangesEnvelopes(t *testing.T) {
+	type greeting struct {
+		Text string `json:"text"`
+	}
+	router := ws.NewRouter()
+	ws.Handle(router, "greet", func(client *ws.Client, msg greeting) error {
+		reply, _ := ws.NewEnvelope(client.ID, "greet.reply", greeting{Text: "hello, " + msg.Text})
+		return client.SendEnvelope(reply)
+	})
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, router, persister)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn := dialClient(t, wsURL(server), ws.WithDialHeader("X-Test-Client-ID", clientID.String()))
+	if conn.ID != clientID {
+		t.Errorf("Expected the server-assigned ID %s, got %s", clientID, conn.ID)
+	}
+
+	// Client to server: the envelope is handled and acked.
+	sent, err := conn.Send("greet", greeting{Text: "gopher"})
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if ack := receiveType(t, conn, ws.AckMessageType); ack.ID != sent {
+		t.Errorf("Expected an ack for %s, got %s", sent, ack.ID)
+	}
+	reply := receiveType(t, conn, "greet.reply")
+	var got greeting
+	if err := reply.DecodePayload(&got); err != nil || got.Text != "hello, gopher" {
+		t.Errorf("Expected the handler's reply, got %q (%v)", got.Text, err)
+	}
+	if reply.ClientID != clientID || reply.ID.IsZero() {
+		t.Errorf("Expected the reply addressed to %s with an ID, got %+v", clientID, reply)
+	}
+
+	// Server to client: the envelope is confirmed once the client acks it.
+	env, _ := ws.NewEnvelope(clientID, "notice", map[string]string{"text": "maintenance at noon"})
+	if err := handler.SendEnvelope(env); err != nil {
+		t.Fatalf("Failed to send to the client: %v", err)
+	}
+	notice := receiveType(t, conn, "notice")
+	if notice.ID != env.ID {
+		t.Errorf("Expected envelope %s, got %s", env.ID, notice.ID)
+	}
+	if err := conn.Ack(notice.ID); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool {
+		for _, id := range persister.confirmedIDs() {
+			if id == env.ID {
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Error("Expected the ack to confirm delivery")
+	}
+}
+
+func TestDialAutoAck(t *testing.T) {
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn := dialClient(t, wsURL(server), ws.WithDialHeader("X-Test-Client-ID", clientID.String()), ws.WithAutoAck())
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	env, _ := ws.NewEnvelope(clientID, "notice", nil)
+	handler.SendEnvelope(env)
+	receiveType(t, conn, "notice")
+	if !waitFor(t, 2*time.Second, func() bool { return len(persister.confirmedIDs()) == 1 }) {
+		t.Error("Expected the envelope acked automatically")
+	}
+}
+
+func TestDialErrorFrames(t *testing.T) {
+	router := ws.NewRouter()
+	router.HandleFunc("fail", func(*ws.Client, ws.Envelope) error {
+		return &ws.ErrorFrame{Code: "out_of_stock", Message: "item is out of stock"}
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithErrorHandler(func(*ws.Client, []byte, error) ws.ErrorAction { return ws.ErrorReply }),
+	)
+	conn := dialClient(t, wsURL(newTestServer(t, handler)))
+
+	sent, _ := conn.Send("fail", nil)
+	var frame ws.ErrorFrame
+	if err := receiveType(t, conn, ws.ErrorMessageType).DecodePayload(&frame); err != nil {
+		t.Fatalf("Expected the error frame in the payload, got %v", err)
+	}
+	if frame.Code != "out_of_stock" || frame.Ref != sent.String() {
+		t.Errorf("Expected out_of_stock referencing %s, got %+v", sent, frame)
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	secret := []byte("dial-secret")
+	handler := ws.NewWebSocketHandler(wsauth.NewJWTValidator(wsauth.StaticKey(secret)), &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithSubprotocols("chat.v2", "chat.v1"),
+	)
+	server := newTestServer(t, handler)
+
+	token := signJWT(t, secret, map[string]any{"sub": "worker-7"})
+	conn := dialClient(t, wsURL(server), ws.WithBearerToken(token), ws.WithDialSubprotocols("chat.v1"))
+	if want := ws.IdentityFromString(ws.DefaultIdentityNamespace, "worker-7"); conn.ID != want {
+		t.Errorf("Expected the token's identity, got %s", conn.ID)
+	}
+	if conn.Subprotocol() != "chat.v1" {
+		t.Errorf("Expected the offered subprotocol, got %q", conn.Subprotocol())
+	}
+
+	_, err := ws.Dial(context.Background(), wsURL(server))
+	var dialErr *ws.DialError
+	if !errors.As(err, &dialErr) || dialErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a DialError with status 401, got %v", err)
+	}
+}
+
+func TestDialClose(t *testing.T) {
+	disconnected := make(chan error, 1)
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithOnDisconnect(func(_ *ws.Client, err error) { disconnected <- err }),
+	)
+	conn := dialClient(t, wsURL(newTestServer(t, handler)))
+
+	if err := conn.Close(websocket.CloseGoingAway, "worker stopping"); err != nil {
+		t.Fatalf("Expected a clean close, got %v", err)
+	}
+	select {
+	case err := <-disconnected:
+		if !isCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("Expected the server to see the close code, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the server to see the disconnect")
+	}
+	if _, ok := <-conn.Receive(); ok {
+		t.Error("Expected Receive to be closed")
+	}
+	if !websocket.IsCloseError(conn.Err(), websocket.CloseGoingAway) {
+		t.Errorf("Expected the close handshake as the reason, got %v", conn.Err())
+	}
+	if _, err := conn.Send("late", nil); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected after Close, got %v", err)
+	}
+}
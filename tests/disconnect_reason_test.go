@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// disconnectReasons returns a handler passing the reason of every
+// disconnect to the returned channel.
+func disconnectReasons(t *testing.T, opts ...ws.Option) (*ws.WebsocketHandler, chan *ws.DisconnectReason) {
+	t.Helper()
+	reasons := make(chan *ws.DisconnectReason, 1)
+	opts = append(opts, ws.WithOnDisconnect(func(client *ws.Client, err error) {
+		var reason *ws.DisconnectReason
+		if !errors.As(err, &reason) {
+			t.Errorf("Expected a *ws.DisconnectReason, got %T", err)
+		}
+		if client.Wait() != reason {
+			t.Error("Expected Wait to return the hook's reason")
+		}
+		reasons <- reason
+	}))
+	return ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, opts...), reasons
+}
+
+func expectReason(t *testing.T, reasons chan *ws.DisconnectReason, kind ws.DisconnectKind, code int) *ws.DisconnectReason {
+	t.Helper()
+	select {
+	case reason := <-reasons:
+		if reason.Kind != kind || reason.Code != code {
+			t.Errorf("Expected %v with code %d, got %v", kind, code, reason)
+		}
+		return reason
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected a %v disconnect", kind)
+		return nil
+	}
+}
+
+func TestDisconnectReasonClientClose(t *testing.T) {
+	handler, reasons := disconnectReasons(t)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+
+	reason := expectReason(t, reasons, ws.DisconnectClientClose, websocket.CloseNormalClosure)
+	if reason.Text != "bye" || !reason.IsNormal() || reason.IsServerInitiated() {
+		t.Errorf("Expected a normal client close saying bye, got %v", reason)
+	}
+	if !isCloseError(reason, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the reason to wrap the close error, got %v", reason.Err)
+	}
+	if context.Cause(client.Context()) != reason {
+		t.Errorf("Expected the reason as the context's cause, got %v", context.Cause(client.Context()))
+	}
+}
+
+func TestDisconnectReasonServerClose(t *testing.T) {
+	handler, reasons := disconnectReasons(t, ws.WithMaxMessageSize(8))
+	conn := connect(t, newTestServer(t, handler))
+
+	conn.MustSend([]byte(`{"type":"too big"}`))
+
+	reason := expectReason(t, reasons, ws.DisconnectServerClose, websocket.CloseMessageTooBig)
+	if reason.IsNormal() || !reason.IsServerInitiated() || !errors.Is(reason, ws.ErrMessageTooLarge) {
+		t.Errorf("Expected an abnormal server close wrapping ErrMessageTooLarge, got %v", reason)
+	}
+}
+
+func TestDisconnectReasonReadError(t *testing.T) {
+	handler, reasons := disconnectReasons(t)
+	conn := dial(t, newTestServer(t, handler))
+	registeredClient(t, handler)
+
+	tcp := conn.UnderlyingConn().(*net.TCPConn)
+	tcp.SetLinger(0)
+	tcp.Close()
+
+	reason := expectReason(t, reasons, ws.DisconnectReadError, 0)
+	if reason.IsNormal() || reason.Err == nil {
+		t.Errorf("Expected an abnormal read error, got %v", reason)
+	}
+}
+
+var errBrokenWrite = errors.New("broken write")
+
+// breakableConn fails every write once broken is set.
+type breakableConn struct {
+	net.Conn
+	broken *atomic.Bool
+}
+
+func (c breakableConn) Write(p []byte) (int, error) {
+	if c.broken.Load() {
+		return 0, errBrokenWrite
+	}
+	return c.Conn.Write(p)
+}
+
+// breakableWriter hands the upgrader a breakableConn when it hijacks the
+// connection.
+type breakableWriter struct {
+	http.ResponseWriter
+	broken *atomic.Bool
+}
+
+func (w breakableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	return breakableConn{Conn: conn, broken: w.broken}, brw, err
+}
+
+func TestDisconnectReasonWriteError(t *testing.T) {
+	var broken atomic.Bool
+	handler, reasons := disconnectReasons(t)
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(breakableWriter{ResponseWriter: w, broken: &broken}, r)
+	}))
+	connect(t, server)
+	client := registeredClient(t, handler)
+
+	broken.Store(true)
+	client.SendJSON(map[string]string{"type": "hello"})
+
+	reason := expectReason(t, reasons, ws.DisconnectWriteError, 0)
+	if !errors.Is(reason, errBrokenWrite) {
+		t.Errorf("Expected the write error, got %v", reason.Err)
+	}
+}
+
+func TestDisconnectReasonTimeout(t *testing.T) {
+	handler, reasons := disconnectReasons(t,
+		ws.WithPingInterval(time.Hour),
+		ws.WithPongWait(100*time.Millisecond),
+	)
+	connect(t, newTestServer(t, handler))
+
+	reason := expectReason(t, reasons, ws.DisconnectTimeout, 0)
+	if !isTimeout(errors.Unwrap(reason)) {
+		t.Errorf("Expected the read deadline error, got %v", reason.Err)
+	}
+}
+
+func TestDisconnectReasonKicked(t *testing.T) {
+	handler, reasons := disconnectReasons(t)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	if err := handler.DisconnectClient(client, 4000, "kicked"); err != nil {
+		t.Fatalf("Expected the client to be disconnected, got %v", err)
+	}
+	conn.ExpectClose(4000)
+
+	reason := expectReason(t, reasons, ws.DisconnectKicked, 4000)
+	if reason.Text != "kicked" || reason.IsNormal() || !reason.IsServerInitiated() {
+		t.Errorf("Expected a server-initiated kick, got %v", reason)
+	}
+}
+
+func TestDisconnectReasonShutdown(t *testing.T) {
+	handler, reasons := disconnectReasons(t)
+	conn := connect(t, newTestServer(t, handler))
+	registeredClient(t, handler)
+
+	go handler.Shutdown(context.Background())
+	conn.ExpectClose(websocket.CloseGoingAway)
+
+	reason := expectReason(t, reasons, ws.DisconnectShutdown, websocket.CloseGoingAway)
+	if !reason.IsNormal() || !reason.IsServerInitiated() {
+		t.Errorf("Expected a normal, server-initiated shutdown, got %v", reason)
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+func TestDisconnectSendsCloseCodeAndReason(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	hooks := newHookRecorder()
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, hooks.options()...)
+	server := newTestServer(t, handler)
+	conn := connect(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 1 }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	if err := handler.Disconnect(clientID, websocket.ClosePolicyViolation, "kicked by moderator"); err != nil {
+		t.Fatalf("Expected Disconnect to succeed, got %v", err)
+	}
+	if reason := conn.ExpectClose(websocket.ClosePolicyViolation); reason != "kicked by moderator" {
+		t.Errorf("Expected the moderator's reason, got %q", reason)
+	}
+
+	if !waitFor(t, 2*time.Second, func() bool { return hooks.disconnectCount() == 1 }) {
+		t.Error("Expected disconnect hook to fire")
+	}
+	if handler.Len() != 0 {
+		t.Errorf("Expected client to be unregistered, %d remain", handler.Len())
+	}
+}
+
+func TestDisconnectClosesEveryConnectionForIdentity(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	conns := []*wstest.Conn{connect(t, server), connect(t, server)}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 2 }) {
+		t.Fatal("Expected both connections to be registered")
+	}
+
+	if err := handler.Disconnect(clientID, websocket.CloseNormalClosure, "bye"); err != nil {
+		t.Fatalf("Expected Disconnect to succeed, got %v", err)
+	}
+	for _, conn := range conns {
+		conn.ExpectClose(websocket.CloseNormalClosure)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Errorf("Expected all connections to close, %d remain", handler.Len())
+	}
+}
+
+func TestDisconnectUnknownClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+
+	if err := handler.Disconnect(ws.NewIdentity(), websocket.CloseNormalClosure, ""); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type orderPlaced struct {
+	OrderID  int64    `json:"order_id"`
+	Customer string   `json:"customer"`
+	Items    []string `json:"items"`
+}
+
+func TestNewEnvelopeEncodesPayload(t *testing.T) {
+	clientID := ws.NewIdentity()
+	envelope, err := ws.NewEnvelope(clientID, "order.placed", orderPlaced{OrderID: 42, Customer: "ada"})
+	if err != nil {
+		t.Fatalf("Expected NewEnvelope to succeed, got %v", err)
+	}
+	if envelope.ClientID != clientID || envelope.Type != "order.placed" {
+		t.Errorf("Expected envelope for %s of type order.placed, got %+v", clientID, envelope)
+	}
+	if envelope.ID.IsZero() || envelope.Timestamp.IsZero() {
+		t.Error("Expected NewEnvelope to assign an ID and timestamp")
+	}
+
+	var decoded orderPlaced
+	if err := envelope.DecodePayload(&decoded); err != nil {
+		t.Fatalf("Expected DecodePayload to succeed, got %v", err)
+	}
+	if decoded.OrderID != 42 || decoded.Customer != "ada" {
+		t.Errorf("Expected decoded payload to match, got %+v", decoded)
+	}
+}
+
+func TestNewEnvelopeUsesClock(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(90 * time.Second)
+	clientID := ws.NewIdentity()
+
+	first, err := ws.NewEnvelope(clientID, "tick", nil, ws.WithEnvelopeClock(clock))
+	if err != nil {
+		t.Fatalf("Expected NewEnvelope to succeed, got %v", err)
+	}
+	second, _ := ws.NewEnvelope(clientID, "tick", nil, ws.WithEnvelopeClock(clock))
+
+	if want := time.Unix(90, 0); !first.Timestamp.Equal(want) || !second.Timestamp.Equal(want) {
+		t.Errorf("Expected both envelopes stamped %v, got %v and %v", want, first.Timestamp, second.Timestamp)
+	}
+	if first.ID == second.ID || first.ID.IsZero() {
+		t.Errorf("Expected distinct fresh IDs, got %s and %s", first.ID, second.ID)
+	}
+	if first.Delivered != nil {
+		t.Errorf("Expected a new envelope to be undelivered, got %v", first.Delivered)
+	}
+
+	first.MarkDelivered(clock.Now().Add(time.Second))
+	if first.Delivered == nil || !first.Delivered.Equal(time.Unix(91, 0)) {
+		t.Errorf("Expected MarkDelivered to stamp %v, got %v", time.Unix(91, 0), first.Delivered)
+	}
+}
+
+func TestNewEnvelopeReturnsMarshalError(t *testing.T) {
+	if _, err := ws.NewEnvelope(ws.NewIdentity(), "bad", make(chan int)); err == nil {
+		t.Error("Expected NewEnvelope to fail for an unencodable payload")
+	}
+}
+
+func TestLargeIntegersSurviveRoundTrip(t *testing.T) {
+	const large int64 = 9007199254740993 // 2^53 + 1 is not representable as float64
+
+	persister := &mockEnvelopePersister{}
+	clientID := ws.NewIdentity()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"order.placed","payload":{"order_id":9007199254740993}}`))
+	if !waitFor(t, 2*time.Second, func() bool { return len(persister.saved()) == 1 }) {
+		t.Fatal("Expected inbound envelope to be persisted")
+	}
+	var inbound orderPlaced
+	persister.saved()[0].DecodePayload(&inbound)
+	if inbound.OrderID != large {
+		t.Errorf("Expected inbound order ID %d, got %d", large, inbound.OrderID)
+	}
+
+	envelope, _ := ws.NewEnvelope(clientID, "order.shipped", orderPlaced{OrderID: large})
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame struct {
+		Payload orderPlaced `json:"payload"`
+	}
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("Expected envelope frame, got %v", err)
+	}
+	if frame.Payload.OrderID != large {
+		t.Errorf("Expected outbound order ID %d, got %d", large, frame.Payload.OrderID)
+	}
+}
+
+var benchmarkFrame = []byte(`{"id":"6f1c2b9e-4a43-4c1e-9d0a-2f6f6a1f6d11","client_id":"0b3c4d5e-1111-4222-8333-944455556666","type":"order.placed","payload":{"order_id":123456789,"customer":"ada","items":["a","b","c","d"]}}`)
+
+// BenchmarkDecodePayload measures decoding a frame into an Envelope and then
+// into the application's payload type.
+func BenchmarkDecodePayload(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var envelope ws.Envelope
+		var payload orderPlaced
+		json.Unmarshal(benchmarkFrame, &envelope)
+		envelope.DecodePayload(&payload)
+	}
+}
+
+// BenchmarkDecodePayloadViaMap is the decode path required when Payload was a
+// map[string]interface{}, kept for comparison with BenchmarkDecodePayload.
+func BenchmarkDecodePayloadViaMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var envelope struct {
+			Payload map[string]interface{} `json:"payload"`
+		}
+		var payload orderPlaced
+		json.Unmarshal(benchmarkFrame, &envelope)
+		data, _ := json.Marshal(envelope.Payload)
+		json.Unmarshal(data, &payload)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestInboundMessagesArePersistedAsEnvelopes(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	messageHandler := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(validator, messageHandler, persister)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	before := time.Now()
+	frames := []string{
+		`{"type":"chat","payload":{"text":"hello"}}`,
+		`{"type":"chat","payload":{"text":"world"}}`,
+		`{"type":"typing","payload":{}}`,
+	}
+	for _, frame := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == len(frames) }) {
+		t.Fatalf("Expected %d handled messages, got %d", len(frames), len(messageHandler.received()))
+	}
+
+	envelopes := persister.saved()
+	if len(envelopes) != len(frames) {
+		t.Fatalf("Expected %d envelopes, got %d", len(frames), len(envelopes))
+	}
+
+	seen := map[ws.Identity]bool{}
+	for i, envelope := range envelopes {
+		var frame struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		json.Unmarshal([]byte(frames[i]), &frame)
+
+		if envelope.ID.UUID() == uuid.Nil {
+			t.Errorf("Expected envelope %d to have an ID", i)
+		}
+		if seen[envelope.ID] {
+			t.Errorf("Expected envelope %d to have a unique ID", i)
+		}
+		seen[envelope.ID] = true
+		if envelope.ClientID != clientID {
+			t.Errorf("Expected client ID %s, got %s", clientID, envelope.ClientID)
+		}
+		if envelope.Type != frame.Type {
+			t.Errorf("Expected type %q, got %q", frame.Type, envelope.Type)
+		}
+		if string(envelope.Payload) != string(frame.Payload) {
+			t.Errorf("Expected payload %v, got %v", frame.Payload, envelope.Payload)
+		}
+		if envelope.Timestamp.Before(before) {
+			t.Errorf("Expected timestamp after %v, got %v", before, envelope.Timestamp)
+		}
+		if envelope.Delivered != nil {
+			t.Errorf("Expected inbound envelope to be undelivered")
+		}
+	}
+}
+
+func TestPersistFailureRejectsMessage(t *testing.T) {
+	messageHandler := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{shouldFail: true}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","payload":{}}`)); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected an error reply, got %v", err)
+	}
+
+	var reply struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+		Ref  string `json:"ref"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		t.Fatalf("Expected JSON error reply, got %q", data)
+	}
+	if reply.Type != ws.ErrorMessageType || reply.Code != "persist_failed" {
+		t.Errorf("Expected persist_failed error, got %+v", reply)
+	}
+	if _, err := uuid.Parse(reply.Ref); err != nil {
+		t.Errorf("Expected reply to reference the envelope ID, got %q", reply.Ref)
+	}
+	if len(messageHandler.received()) != 0 {
+		t.Error("Expected message handler not to run when persistence fails")
+	}
+}
+
+func TestNonJSONFramesSkipPersistence(t *testing.T) {
+	messageHandler := &mockMessageHandler{}
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("plain text"))
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 1 }) {
+		t.Fatal("Expected plain text frame to reach the message handler")
+	}
+	if len(persister.saved()) != 0 {
+		t.Error("Expected plain text frame not to be persisted")
+	}
+}
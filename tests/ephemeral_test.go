@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestInboundEphemeralSkipsPersistence(t *testing.T) {
+	persister := &mockEnvelopePersister{}
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, persister)
+	var seen atomic.Int32
+	handler.Use(func(next ws.MessageHandler) ws.MessageHandler {
+		return ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+			seen.Add(1)
+			return next.Handle(client, data)
+		})
+	})
+	conn := dial(t, newTestServer(t, handler))
+
+	writeFrame(t, conn, `{"type":"typing","ephemeral":true,"payload":{"on":true}}`)
+	writeFrame(t, conn, `{"type":"chat","payload":{"text":"hi"}}`)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 2 }) {
+		t.Fatal("Expected both messages to reach the handler")
+	}
+	if seen.Load() != 2 {
+		t.Errorf("Expected both messages to pass through middleware, got %d", seen.Load())
+	}
+
+	saved := persister.saved()
+	if len(saved) != 1 || saved[0].Type != "chat" {
+		t.Errorf("Expected only the chat message to be saved, got %+v", saved)
+	}
+}
+
+func TestOutboundEphemeralSkipsPersistenceAndAcks(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+	conn := connect(clientID)
+
+	if err := f.handler.SendEphemeral(clientID, "cursor", map[string]int{"x": 3}); err != nil {
+		t.Fatalf("Expected SendEphemeral to succeed, got %v", err)
+	}
+	var frame struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"`
+		Ephemeral bool   `json:"ephemeral"`
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected the ephemeral envelope, got %v", err)
+	}
+	json.Unmarshal(data, &frame)
+	if frame.Type != "cursor" || !frame.Ephemeral {
+		t.Fatalf("Expected an ephemeral cursor envelope, got %s", data)
+	}
+
+	sendAck(conn, frame.ID)
+	if code := readErrorCode(t, conn); code != "unknown_envelope" {
+		t.Errorf("Expected an ephemeral envelope not to await an ack, got %q", code)
+	}
+	if saved, confirmed := f.persister.saved(), f.persister.confirmedIDs(); len(saved) != 0 || len(confirmed) != 0 {
+		t.Errorf("Expected the persister never to see ephemeral traffic, got %d saved and %d confirmed", len(saved), len(confirmed))
+	}
+}
+
+func TestEphemeralToOfflineClientIsNotStored(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+
+	envelope, _ := ws.NewEnvelope(clientID, "typing", nil)
+	envelope.Ephemeral = true
+	if err := f.handler.SendEnvelope(envelope); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Fatalf("Expected ErrClientNotConnected for an offline client, got %v", err)
+	}
+	if len(f.persister.saved()) != 0 {
+		t.Fatal("Expected the ephemeral envelope not to be saved")
+	}
+
+	conn := connect(clientID)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Errorf("Expected nothing to be replayed, got %s", data)
+	}
+}
+
+func TestEphemeralKeepsOrderWithPersisted(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+	conn := connect(clientID)
+
+	var want []string
+	for i, ephemeral := range []bool{false, true, true, false, true, false} {
+		envelope, _ := ws.NewEnvelope(clientID, "step", i)
+		envelope.Ephemeral = ephemeral
+		if err := f.handler.SendEnvelope(envelope); err != nil {
+			t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+		}
+		want = append(want, envelope.ID.String())
+	}
+
+	for i, id := range want {
+		if got := readEnvelopeID(t, conn); got != id {
+			t.Fatalf("Expected envelope %d to be %s, got %s", i, id, got)
+		}
+	}
+	if saved := f.persister.saved(); len(saved) != 3 {
+		t.Errorf("Expected only the 3 persisted envelopes to be saved, got %d", len(saved))
+	}
+}
+
+func TestBroadcastEphemeral(t *testing.T) {
+	f, connect := newAckFixture(t)
+	ids := []ws.Identity{ws.NewIdentity(), ws.NewIdentity()}
+	var conns []*websocket.Conn
+	for _, id := range ids {
+		conns = append(conns, connect(id))
+	}
+
+	result, err := f.handler.BroadcastEphemeral("presence", map[string]string{"status": "away"})
+	if err != nil || result.Delivered != 2 {
+		t.Fatalf("Expected the broadcast to reach both clients, got %+v and %v", result, err)
+	}
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected client %d to receive the broadcast, got %v", i, err)
+		}
+		var frame struct {
+			ClientID  string `json:"client_id"`
+			Ephemeral bool   `json:"ephemeral"`
+		}
+		json.Unmarshal(data, &frame)
+		if frame.ClientID != ids[i].String() || !frame.Ephemeral {
+			t.Errorf("Expected an ephemeral envelope addressed to %s, got %s", ids[i], data)
+		}
+	}
+	if len(f.persister.saved()) != 0 {
+		t.Error("Expected the persister never to see ephemeral broadcasts")
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// sendIdentified writes an envelope of msgType with a fresh ID and returns
+// the ID.
+func sendIdentified(t *testing.T, conn *websocket.Conn, msgType string, payload string) ws.Identity {
+	t.Helper()
+	id := ws.NewIdentity()
+	writeFrame(t, conn, `{"id":"`+id.String()+`","type":"`+msgType+`","payload":`+payload+`}`)
+	return id
+}
+
+func expectErrorCode(t *testing.T, conn *websocket.Conn, code string, ref ws.Identity) ws.ErrorFrame {
+	t.Helper()
+	frame := readErrorFrame(t, conn)
+	if frame.Code != code || frame.Ref != ref.String() || frame.Message == "" {
+		t.Errorf("Expected a %s error referencing %s, got %+v", code, ref, frame)
+	}
+	return frame
+}
+
+func TestSendError(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	ref := ws.NewIdentity()
+	if err := client.SendError(ws.ErrorCodeTooLarge, "upload exceeds 10 MiB", ref.String()); err != nil {
+		t.Fatalf("SendError failed: %v", err)
+	}
+	frame := expectErrorCode(t, conn, ws.ErrorCodeTooLarge, ref)
+	if frame.Type != "_error" || frame.Message != "upload exceeds 10 MiB" {
+		t.Errorf("Expected the message to be sent as given, got %+v", frame)
+	}
+}
+
+func TestErrorCodesFromAuthorizer(t *testing.T) {
+	authorizer := ws.MessageAuthorizerFunc(func(client *ws.Client, msgType string, env ws.Envelope) error {
+		switch msgType {
+		case "admin":
+			return errors.New("not an admin")
+		case "billing":
+			return &ws.ErrorFrame{Code: "payment_required", Message: "renew your plan"}
+		}
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithMessageAuthorizer(authorizer))
+	conn := dial(t, newTestServer(t, handler))
+
+	expectErrorCode(t, conn, ws.ErrorCodeUnauthorized, sendIdentified(t, conn, "admin", `{}`))
+	frame := expectErrorCode(t, conn, "payment_required", sendIdentified(t, conn, "billing", `{}`))
+	if frame.Message != "renew your plan" {
+		t.Errorf("Expected the authorizer's message, got %q", frame.Message)
+	}
+}
+
+func TestErrorCodesFromRateLimiter(t *testing.T) {
+	f := newRateLimitFixture(t, ws.WithRateLimit(1, 1))
+	writeFrame(t, f.conn, "ping")
+
+	// The refused frame's own ID is echoed when it has one.
+	expectErrorCode(t, f.conn, ws.ErrorCodeRateLimited, sendIdentified(t, f.conn, "chat", `{}`))
+	writeFrame(t, f.conn, "ping")
+	if frame := readErrorFrame(t, f.conn); frame.Code != ws.ErrorCodeRateLimited || frame.Ref != "" {
+		t.Errorf("Expected a rate_limited error without a ref for a raw frame, got %+v", frame)
+	}
+}
+
+func TestErrorCodesFromSchemaValidator(t *testing.T) {
+	router := ws.NewRouter()
+	router.HandleFunc("order.place", func(c *ws.Client, env ws.Envelope) error { return nil })
+	if err := router.SetSchema("order.place", []byte(orderSchema)); err != nil {
+		t.Fatal(err)
+	}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{})
+	conn := dial(t, newTestServer(t, handler))
+
+	expectErrorCode(t, conn, ws.ErrorCodeValidationFailed, sendIdentified(t, conn, "order.place", `{"sku":7}`))
+}
+
+func TestErrorCodesFromPayloadSizeLimit(t *testing.T) {
+	handled := make(chan string, 4)
+	router := ws.NewRouter()
+	router.HandleFunc("upload", func(c *ws.Client, env ws.Envelope) error {
+		handled <- string(env.Payload)
+		return nil
+	})
+	router.SetMaxPayloadSize("upload", 16)
+	errs := make(chan error, 4)
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction {
+			errs <- err
+			return ws.ErrorIgnore
+		}))
+	conn := dial(t, newTestServer(t, handler))
+
+	expectErrorCode(t, conn, ws.ErrorCodeTooLarge, sendIdentified(t, conn, "upload", `{"data":"0123456789abcdef"}`))
+	if err := <-errs; !errors.Is(err, ws.ErrPayloadTooLarge) {
+		t.Errorf("Expected ErrPayloadTooLarge, got %v", err)
+	}
+	sendIdentified(t, conn, "upload", `{"data":"ok"}`)
+	if got := <-handled; got != `{"data":"ok"}` {
+		t.Errorf("Expected only the small payload handled, got %s", got)
+	}
+
+	router.SetMaxPayloadSize("upload", 0)
+	sendIdentified(t, conn, "upload", `{"data":"0123456789abcdef"}`)
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the large payload handled once the limit is removed")
+	}
+}
+
+func TestErrorCodesFromErrorHandler(t *testing.T) {
+	router := ws.NewRouter()
+	router.HandleFunc("fail", func(c *ws.Client, env ws.Envelope) error { return errors.New("boom") })
+	router.HandleFunc("panic", func(c *ws.Client, env ws.Envelope) error { panic("boom") })
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction { return ws.ErrorReply }))
+	conn := dial(t, newTestServer(t, handler))
+
+	expectErrorCode(t, conn, ws.ErrorCodeHandlerError, sendIdentified(t, conn, "fail", `{}`))
+	expectErrorCode(t, conn, ws.ErrorCodeInternal, sendIdentified(t, conn, "panic", `{}`))
+}
+
+func TestErrorCodesFromPanicAction(t *testing.T) {
+	router := ws.NewRouter()
+	router.HandleFunc("panic", func(c *ws.Client, env ws.Envelope) error { panic("boom") })
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithPanicAction(ws.ErrorReply))
+	conn := dial(t, newTestServer(t, handler))
+
+	expectErrorCode(t, conn, ws.ErrorCodeInternal, sendIdentified(t, conn, "panic", `{}`))
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var errOrderRejected = errors.New("order rejected")
+
+type errorHandlerFixture struct {
+	mu      sync.Mutex
+	handled [][]byte
+	errs    []error
+}
+
+// connect serves a router whose "fail" handler returns failure, with action
+// chosen for every handler error.
+func (f *errorHandlerFixture) connect(t *testing.T, action ws.ErrorAction, failure error) *websocket.Conn {
+	t.Helper()
+	router := ws.NewRouter()
+	router.HandleFunc("fail", func(*ws.Client, ws.Envelope) error { return failure })
+	router.HandleFunc("ok", func(*ws.Client, ws.Envelope) error { return nil })
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.handled = append(f.handled, client.Retain(msg))
+			f.errs = append(f.errs, err)
+			return action
+		}),
+	)
+	return dial(t, newTestServer(t, handler))
+}
+
+func readErrorFrame(t *testing.T, conn *websocket.Conn) ws.ErrorFrame {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected an error frame, got %v", err)
+		}
+		var frame ws.ErrorFrame
+		json.Unmarshal(data, &frame)
+		if frame.Type == ws.ErrorMessageType {
+			return frame
+		}
+	}
+}
+
+func TestErrorHandlerIgnore(t *testing.T) {
+	f := &errorHandlerFixture{}
+	conn := f.connect(t, ws.ErrorIgnore, errOrderRejected)
+
+	msg := []byte(`{"type":"fail","payload":{}}`)
+	conn.WriteMessage(websocket.TextMessage, msg)
+	if !waitFor(t, 2*time.Second, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.errs) == 1
+	}) {
+		t.Fatal("Expected the error handler to run")
+	}
+	f.mu.Lock()
+	if string(f.handled[0]) != string(msg) || !errors.Is(f.errs[0], errOrderRejected) {
+		t.Errorf("Expected the failed message and its error, got %q and %v", f.handled[0], f.errs[0])
+	}
+	f.mu.Unlock()
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); !isTimeout(err) {
+		t.Errorf("Expected nothing sent to the client, got %q (%v)", data, err)
+	}
+}
+
+func TestErrorHandlerReply(t *testing.T) {
+	f := &errorHandlerFixture{}
+	conn := f.connect(t, ws.ErrorReply, errOrderRejected)
+
+	id := ws.NewIdentity()
+	conn.WriteJSON(map[string]any{"type": "fail", "id": id.String(), "payload": map[string]any{}})
+	frame := readErrorFrame(t, conn)
+	if frame.Code != "handler_error" || frame.Ref != id.String() {
+		t.Errorf("Expected a handler_error frame referencing %s, got %+v", id, frame)
+	}
+	if frame.Message == errOrderRejected.Error() {
+		t.Error("Expected the internal error not to be sent to the client")
+	}
+
+	// Still connected.
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ok","payload":{}}`))
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); !isTimeout(err) {
+		t.Errorf("Expected the connection to stay open, got %q (%v)", data, err)
+	}
+}
+
+func TestErrorHandlerReplyWithErrorFrame(t *testing.T) {
+	f := &errorHandlerFixture{}
+	conn := f.connect(t, ws.ErrorReply, &ws.ErrorFrame{Code: "out_of_stock", Message: "item is out of stock"})
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"fail","payload":{}}`))
+	frame := readErrorFrame(t, conn)
+	if frame.Code != "out_of_stock" || frame.Message != "item is out of stock" {
+		t.Errorf("Expected the handler's error frame, got %+v", frame)
+	}
+}
+
+func TestErrorHandlerClose(t *testing.T) {
+	f := &errorHandlerFixture{}
+	conn := f.connect(t, ws.ErrorClose, errOrderRejected)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"fail","payload":{}}`))
+	expectClose(t, conn, websocket.CloseInternalServerErr)
+}
+
+func TestErrorHandlerNotCalledOnSuccess(t *testing.T) {
+	f := &errorHandlerFixture{}
+	conn := f.connect(t, ws.ErrorClose, errOrderRejected)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ok","payload":{}}`))
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := conn.ReadMessage(); !isTimeout(err) {
+		t.Errorf("Expected the connection to stay open, got %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errs) != 0 {
+		t.Errorf("Expected no error handler calls, got %v", f.errs)
+	}
+}
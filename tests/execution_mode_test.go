@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// blockingHandler signals started for every message and holds it until
+// release is closed or the connection ends.
+type blockingHandler struct {
+	started chan []byte
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan []byte, 16), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) HandleContext(ctx context.Context, client *ws.Client, data []byte) error {
+	h.started <- client.Retain(data)
+	select {
+	case <-h.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (h *blockingHandler) Handle(client *ws.Client, data []byte) error {
+	return h.HandleContext(client.Context(), client, data)
+}
+
+func (h *blockingHandler) expectStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-h.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the handler to start")
+	}
+}
+
+func TestWorkerPoolPreservesPerConnectionOrder(t *testing.T) {
+	const conns, perConn = 4, 50
+
+	var mu sync.Mutex
+	seen := make(map[ws.Identity][]int)
+	messages := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+		var env struct{ Payload int }
+		json.Unmarshal(data, &env)
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		mu.Lock()
+		seen[client.ID] = append(seen[client.ID], env.Payload)
+		mu.Unlock()
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithExecutionMode(ws.WorkerPool(3)),
+	)
+	server := wstest.NewServer(t, handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		conn := server.Connect(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < perConn; seq++ {
+				data, _ := json.Marshal(map[string]any{"type": "seq", "payload": seq})
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					t.Errorf("Failed to write frame: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !waitFor(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, s := range seen {
+			total += len(s)
+		}
+		return total == conns*perConn
+	}) {
+		t.Fatal("Expected every message to be handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for id, s := range seen {
+		for i, seq := range s {
+			if seq != i {
+				t.Fatalf("Expected client %s's messages in order, got %v", id, s)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolKeepsReadLoopResponsive(t *testing.T) {
+	messages := newBlockingHandler()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithExecutionMode(ws.WorkerPool(1)),
+	)
+	conn := connect(t, newTestServer(t, handler))
+	pong := make(chan struct{}, 1)
+	conn.SetPongHandler(func(string) error {
+		pong <- struct{}{}
+		return nil
+	})
+
+	conn.MustSendEnvelope("slow", nil)
+	messages.expectStarted(t)
+	conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+	conn.ExpectNoMessage(200 * time.Millisecond)
+
+	select {
+	case <-pong:
+	default:
+		t.Fatal("Expected a pong while the handler is still running")
+	}
+	close(messages.release)
+}
+
+func TestWorkerPoolQueueOverflowDropsNewest(t *testing.T) {
+	var mu sync.Mutex
+	var errs []error
+	messages := newBlockingHandler()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithExecutionMode(ws.WorkerPool(1)),
+		ws.WithHandlerQueueSize(1),
+		ws.WithObserver(ws.Observer{OnError: func(_ *ws.Client, err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}}),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"first"}`))
+	messages.expectStarted(t)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"queued"}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"0190c5c8-7c2c-7d3e-8000-000000000001","type":"refused"}`))
+
+	frame := readErrorFrame(t, conn)
+	if frame.Code != "overloaded" || frame.Ref != "0190c5c8-7c2c-7d3e-8000-000000000001" {
+		t.Errorf("Expected an overloaded error for the refused envelope, got %+v", frame)
+	}
+	mu.Lock()
+	if len(errs) != 1 || !errors.Is(errs[0], ws.ErrHandlerQueueFull) {
+		t.Errorf("Expected ErrHandlerQueueFull to be observed once, got %v", errs)
+	}
+	mu.Unlock()
+
+	close(messages.release)
+	messages.expectStarted(t)
+	select {
+	case data := <-messages.started:
+		t.Errorf("Expected the refused message not to be handled, got %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWorkerPoolQueueOverflowClosePolicy(t *testing.T) {
+	messages := newBlockingHandler()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithExecutionMode(ws.WorkerPool(1)),
+		ws.WithHandlerQueueSize(1),
+		ws.WithSlowConsumerPolicy(ws.SlowConsumerClose),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	conn.MustSendEnvelope("first", nil)
+	messages.expectStarted(t)
+	conn.MustSendEnvelope("queued", nil)
+	conn.MustSendEnvelope("refused", nil)
+
+	conn.ExpectClose(websocket.ClosePolicyViolation)
+}
+
+func TestUnorderedHandlesConcurrently(t *testing.T) {
+	const n = 3
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	done := make(chan struct{}, n)
+	messages := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+		arrived.Done()
+		arrived.Wait()
+		done <- struct{}{}
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithExecutionMode(ws.Unordered),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	for i := 0; i < n; i++ {
+		conn.MustSendEnvelope("work", i)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected one connection's messages to be handled concurrently")
+		}
+	}
+}
+
+// BenchmarkReadLoopLatency measures a ping's round trip while every message
+// sent before it takes 500ms to handle.
+func BenchmarkReadLoopLatency(b *testing.B) {
+	for _, mode := range []ws.ExecutionMode{ws.SerialPerConnection, ws.WorkerPool(4), ws.Unordered} {
+		b.Run(mode.String(), func(b *testing.B) {
+			messages := ws.ContextMessageHandlerFunc(func(ctx context.Context, client *ws.Client, data []byte) error {
+				select {
+				case <-time.After(500 * time.Millisecond):
+				case <-ctx.Done():
+				}
+				return nil
+			})
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+				ws.WithExecutionMode(mode),
+			)
+			conn := wstest.NewServer(b, handler).Connect(b)
+			pong := make(chan struct{}, 1)
+			conn.SetPongHandler(func(string) error {
+				pong <- struct{}{}
+				return nil
+			})
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"slow"}`))
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+				<-pong
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// debugVars reads /debug/vars and returns the variables under prefix, keyed
+// without it.
+func debugVars(t *testing.T, debug *httptest.Server, prefix string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(debug.URL + "/debug/vars")
+	if err != nil {
+		t.Fatalf("Failed to read /debug/vars: %v", err)
+	}
+	defer resp.Body.Close()
+	var all map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		t.Fatalf("Failed to decode /debug/vars: %v", err)
+	}
+
+	vars := make(map[string]float64)
+	for _, key := range []string{"connections", "total_connections", "messages_in", "messages_out", "bytes_in", "bytes_out", "dropped", "rejected"} {
+		raw, ok := all[prefix+"."+key]
+		if !ok {
+			t.Fatalf("Expected %s.%s on /debug/vars", prefix, key)
+		}
+		var v float64
+		json.Unmarshal(raw, &v)
+		vars[key] = v
+	}
+	return vars
+}
+
+func TestExpvarPublishesCounters(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithExpvar("tests.expvar"))
+	server := newTestServer(t, handler)
+	debug := httptest.NewServer(expvar.Handler())
+	t.Cleanup(debug.Close)
+
+	prefix := handler.ExpvarPrefix()
+	if before := debugVars(t, debug, prefix); before["connections"] != 0 || before["messages_in"] != 0 {
+		t.Fatalf("Expected idle counters, got %v", before)
+	}
+
+	id := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(id))
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().MessagesIn == 1 }) {
+		t.Fatal("Expected the message to be read")
+	}
+	handler.SendTo(id, []byte("welcome"))
+	conn.ReadMessage()
+	waitFor(t, 2*time.Second, func() bool { return handler.Stats().MessagesOut == 1 })
+	// A plain GET is refused by the upgrader.
+	if resp, err := http.Get(server.URL); err == nil {
+		resp.Body.Close()
+	}
+
+	vars := debugVars(t, debug, prefix)
+	stats := handler.Stats()
+	want := map[string]float64{
+		"connections":       float64(stats.Connections),
+		"total_connections": float64(stats.TotalConnections),
+		"messages_in":       float64(stats.MessagesIn),
+		"messages_out":      float64(stats.MessagesOut),
+		"bytes_in":          float64(stats.BytesIn),
+		"bytes_out":         float64(stats.BytesOut),
+		"dropped":           float64(stats.Dropped),
+		"rejected":          float64(stats.Rejected),
+	}
+	for key, v := range want {
+		if vars[key] != v {
+			t.Errorf("Expected %s to agree with Stats at %v, got %v", key, v, vars[key])
+		}
+	}
+	if vars["connections"] != 1 || vars["messages_in"] != 1 || vars["bytes_in"] != 5 || vars["messages_out"] != 1 || vars["rejected"] != 1 {
+		t.Errorf("Expected the counters to move, got %v", vars)
+	}
+
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return debugVars(t, debug, prefix)["connections"] == 0 }) {
+		t.Error("Expected the connection count to drop on disconnect")
+	}
+}
+
+func TestExpvarPrefixCollision(t *testing.T) {
+	first := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithExpvar("tests.collision"))
+	second := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithExpvar("tests.collision"))
+	if first.ExpvarPrefix() == second.ExpvarPrefix() {
+		t.Fatalf("Expected distinct prefixes, both got %q", first.ExpvarPrefix())
+	}
+	if second.ExpvarPrefix() != first.ExpvarPrefix()+".2" && first.ExpvarPrefix() == "tests.collision" {
+		t.Errorf("Expected the second handler under tests.collision.2, got %q", second.ExpvarPrefix())
+	}
+
+	server := newTestServer(t, second)
+	debug := httptest.NewServer(expvar.Handler())
+	t.Cleanup(debug.Close)
+	dialWithResponse(t, server, identityHeader(ws.NewIdentity()))
+	if !waitFor(t, 2*time.Second, func() bool { return second.Stats().Connections == 1 }) {
+		t.Fatal("Expected the connection to register")
+	}
+	if got := debugVars(t, debug, first.ExpvarPrefix())["connections"]; got != 0 {
+		t.Errorf("Expected the first handler's counters to stay apart, got %v connections", got)
+	}
+	if got := debugVars(t, debug, second.ExpvarPrefix())["connections"]; got != 1 {
+		t.Errorf("Expected the second handler's connection, got %v", got)
+	}
+
+	if none := ws.NewWebSocketHandler(nil, &mockMessageHandler{}, nil); none.ExpvarPrefix() != "" {
+		t.Errorf("Expected no prefix without WithExpvar, got %q", none.ExpvarPrefix())
+	}
+}
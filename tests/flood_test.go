@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+const (
+	floodLimit  = 100
+	floodWindow = 200 * time.Millisecond
+)
+
+type floodRecorder struct {
+	mu         sync.Mutex
+	rates      []float64
+	disconnect error
+}
+
+func (f *floodRecorder) options() []ws.Option {
+	return []ws.Option{
+		ws.WithFloodProtection(floodLimit, floodWindow),
+		ws.WithOnFlood(func(client *ws.Client, rate float64) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.rates = append(f.rates, rate)
+		}),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.disconnect = err
+		}),
+	}
+}
+
+// blast writes frames with send until it fails or stop is closed.
+func blast(stop <-chan struct{}, send func() error) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err := send(); err != nil {
+			return
+		}
+	}
+}
+
+func TestFloodProtectionClosesFloodingConnections(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(conn *websocket.Conn) error
+	}{
+		{"empty messages", func(conn *websocket.Conn) error {
+			return conn.WriteMessage(websocket.TextMessage, nil)
+		}},
+		{"pings", func(conn *websocket.Conn) error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &floodRecorder{}
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+				recorder.options()...)
+			server := newTestServer(t, handler)
+			conn := dial(t, server)
+
+			stop := make(chan struct{})
+			defer close(stop)
+			start := time.Now()
+			go blast(stop, func() error { return tt.send(conn) })
+
+			// The client is still flooding when the server hangs up, so
+			// the close frame can be lost to a connection reset; the
+			// disconnect reason below shows which code the server sent.
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				_, _, err := conn.ReadMessage()
+				if err == nil {
+					continue
+				}
+				var closeErr *websocket.CloseError
+				if isTimeout(err) || errors.As(err, &closeErr) && closeErr.Code != websocket.ClosePolicyViolation && closeErr.Code != websocket.CloseAbnormalClosure {
+					t.Errorf("Expected close 1008, got %v", err)
+				}
+				break
+			}
+			if elapsed := time.Since(start); elapsed > 2*floodWindow {
+				t.Errorf("Expected the close within the window, took %v", elapsed)
+			}
+
+			if !waitFor(t, 2*time.Second, func() bool {
+				recorder.mu.Lock()
+				defer recorder.mu.Unlock()
+				return recorder.disconnect != nil
+			}) {
+				t.Fatal("Expected the disconnect hook to run")
+			}
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			if !errors.Is(recorder.disconnect, ws.ErrFloodDetected) {
+				t.Errorf("Expected ErrFloodDetected, got %v", recorder.disconnect)
+			}
+			var reason *ws.DisconnectReason
+			if !errors.As(recorder.disconnect, &reason) || reason.Code != websocket.ClosePolicyViolation {
+				t.Errorf("Expected the server to close with 1008, got %v", recorder.disconnect)
+			}
+			if len(recorder.rates) != 1 || recorder.rates[0] <= floodLimit {
+				t.Errorf("Expected one abuse report above %d frames/s, got %v", floodLimit, recorder.rates)
+			}
+		})
+	}
+}
+
+func TestFloodProtectionToleratesBurstsAndSteadyTraffic(t *testing.T) {
+	recorder := &floodRecorder{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		recorder.options()...)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	// A burst well over the ceiling but shorter than the window.
+	for i := 0; i < floodLimit/2; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, nil); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	time.Sleep(floodWindow)
+
+	// Steady traffic under the ceiling for several windows.
+	deadline := time.Now().Add(3 * floodWindow)
+	for time.Now().Before(deadline) {
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("Failed to ping: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := conn.ReadMessage(); !isTimeout(err) {
+		t.Errorf("Expected the connection to stay open, got %v", err)
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.rates) != 0 {
+		t.Errorf("Expected no abuse reports, got %v", recorder.rates)
+	}
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// dialMany opens n connections to handler and waits until all are
+// registered.
+func dialMany(t *testing.T, handler *ws.WebsocketHandler, n int) []*websocket.Conn {
+	t.Helper()
+	server := newTestServer(t, handler)
+	conns := make([]*websocket.Conn, n)
+	for i := range conns {
+		conns[i] = dial(t, server)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == n }) {
+		t.Fatalf("Expected %d registered clients, got %d", n, handler.Len())
+	}
+	return conns
+}
+
+// flood writes frames from every connection concurrently.
+func flood(t *testing.T, conns []*websocket.Conn, frames int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			for i := 0; i < frames; i++ {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte("load")); err != nil {
+					t.Errorf("Failed to write frame: %v", err)
+					return
+				}
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
+
+func TestGlobalRateLimitCapsAggregateRate(t *testing.T) {
+	clock := newFakeClock()
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithClock(clock),
+		ws.WithGlobalRateLimit(10),
+	)
+	conns := dialMany(t, handler, 20)
+
+	flood(t, conns, 3)
+	processed := func(want int) func() bool {
+		return func() bool { return len(messages.received())+int(handler.Stats().Shed) == want }
+	}
+	if !waitFor(t, 2*time.Second, processed(60)) {
+		t.Fatalf("Expected 60 frames to be processed, got %d handled and %d shed", len(messages.received()), handler.Stats().Shed)
+	}
+	if got := len(messages.received()); got != 10 {
+		t.Errorf("Expected the handler to run 10 times within the first second, got %d", got)
+	}
+
+	clock.Advance(time.Second)
+	if got := handler.Stats().ShedRate; got != 50 {
+		t.Errorf("Expected a shed rate of 50/s for the last second, got %v", got)
+	}
+
+	flood(t, conns, 1)
+	if !waitFor(t, 2*time.Second, processed(80)) {
+		t.Fatal("Expected another 20 frames to be processed")
+	}
+	if got := len(messages.received()); got != 20 {
+		t.Errorf("Expected 10 more handler calls in the next second, got %d in total", got-10)
+	}
+}
+
+func TestGlobalRateLimitWaitBudget(t *testing.T) {
+	clock := newFakeClock()
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithClock(clock),
+		ws.WithGlobalRateLimit(10),
+		ws.WithGlobalRateLimitWait(time.Second),
+	)
+	conns := dialMany(t, handler, 30)
+
+	flood(t, conns, 1)
+	if !waitFor(t, 2*time.Second, func() bool {
+		return len(messages.received()) == 10 && clock.pending() == 10 && handler.Stats().Shed == 10
+	}) {
+		t.Fatalf("Expected 10 handled, 10 waiting and 10 shed, got %d handled, %d waiting and %d shed",
+			len(messages.received()), clock.pending(), handler.Stats().Shed)
+	}
+
+	clock.Advance(time.Second)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 20 }) {
+		t.Errorf("Expected the waiting frames to be handled within the budget, got %d handled", len(messages.received()))
+	}
+}
+
+func TestGlobalRateLimitUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for a second of wall time")
+	}
+	const rps = 50
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithGlobalRateLimit(rps),
+	)
+	conns := dialMany(t, handler, 25)
+
+	start := time.Now()
+	deadline := start.Add(time.Second)
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if conn.WriteMessage(websocket.TextMessage, []byte("load")) != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(conn)
+	}
+	wg.Wait()
+	time.Sleep(50 * time.Millisecond)
+
+	elapsed := time.Since(start).Seconds()
+	ceiling := rps + int(rps*elapsed) + 1
+	if got := len(messages.received()); got > ceiling {
+		t.Errorf("Expected at most %d handler calls in %.2fs, got %d", ceiling, elapsed, got)
+	}
+	if handler.Stats().Shed == 0 {
+		t.Error("Expected excess frames to be shed under load")
+	}
+}
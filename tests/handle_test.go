@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type placeOrder struct {
+	SKU   string `json:"sku"`
+	Qty   int    `json:"qty"`
+	Items []struct {
+		Price float64 `json:"price"`
+	} `json:"items"`
+}
+
+// typedFixture serves a router with Handle registrations, recording the
+// decoded messages and the router's errors.
+type typedFixture struct {
+	values   chan placeOrder
+	pointers chan *placeOrder
+	errs     chan error
+}
+
+func (f *typedFixture) connect(t *testing.T) *websocket.Conn {
+	t.Helper()
+	f.values = make(chan placeOrder, 1)
+	f.pointers = make(chan *placeOrder, 1)
+	f.errs = make(chan error, 1)
+
+	router := ws.NewRouter()
+	ws.Handle(router, "order.value", func(client *ws.Client, msg placeOrder) error {
+		f.values <- msg
+		return nil
+	})
+	ws.Handle(router, "order.pointer", func(client *ws.Client, msg *placeOrder) error {
+		f.pointers <- msg
+		return nil
+	})
+	ws.Handle(router, "order.strict", func(client *ws.Client, msg placeOrder) error {
+		f.values <- msg
+		return nil
+	}, ws.WithStrictPayload())
+
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction {
+			f.errs <- err
+			return ws.ErrorIgnore
+		}),
+	)
+	return dial(t, newTestServer(t, handler))
+}
+
+func TestHandleDecodesValues(t *testing.T) {
+	f := &typedFixture{}
+	conn := f.connect(t)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"order.value","payload":{"sku":"A-1","qty":3,"extra":true}}`))
+	select {
+	case msg := <-f.values:
+		if msg.SKU != "A-1" || msg.Qty != 3 {
+			t.Errorf("Expected the decoded order, got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the typed handler to run")
+	}
+}
+
+func TestHandleDecodesPointers(t *testing.T) {
+	f := &typedFixture{}
+	conn := f.connect(t)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"order.pointer","payload":{"sku":"B-2","qty":1}}`))
+	select {
+	case msg := <-f.pointers:
+		if msg == nil || msg.SKU != "B-2" || msg.Qty != 1 {
+			t.Errorf("Expected the decoded order, got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the typed handler to run")
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"order.pointer"}`))
+	select {
+	case msg := <-f.pointers:
+		if msg != nil {
+			t.Errorf("Expected a nil pointer for a missing payload, got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the typed handler to run without a payload")
+	}
+}
+
+func TestHandleRejectsInvalidPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		field   string
+		message string
+	}{
+		{"type mismatch", `{"type":"order.value","payload":{"sku":"A-1","qty":"three"}}`, "qty", "expected number, got string"},
+		{"nested mismatch", `{"type":"order.value","payload":{"items":[{"price":"free"}]}}`, "items.0.price", "expected number, got string"},
+		{"payload of the wrong kind", `{"type":"order.value","payload":[1,2]}`, "", "payload: expected object, got array"},
+		{"strict unknown field", `{"type":"order.strict","payload":{"sku":"A-1","extra":true}}`, "extra", "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &typedFixture{}
+			conn := f.connect(t)
+
+			id := ws.NewIdentity()
+			frame := tt.frame[:len(tt.frame)-1] + `,"id":"` + id.String() + `"}`
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+
+			got := readErrorFrame(t, conn)
+			if got.Code != "invalid_payload" || got.Field != tt.field || got.Message != tt.message || got.Ref != id.String() {
+				t.Errorf("Expected invalid_payload on %q (%q) referencing %s, got %+v", tt.field, tt.message, id, got)
+			}
+			select {
+			case err := <-f.errs:
+				if !errors.Is(err, ws.ErrInvalidPayload) {
+					t.Errorf("Expected ErrInvalidPayload, got %v", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Expected the error handler to see the decode failure")
+			}
+			select {
+			case msg := <-f.values:
+				t.Errorf("Expected the handler not to run, got %+v", msg)
+			default:
+			}
+		})
+	}
+}
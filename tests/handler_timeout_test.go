@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// sleepyHandler ignores its context and sleeps past the deadline for
+// "slow" envelopes, recording the cause its context ended with.
+type sleepyHandler struct {
+	mu      sync.Mutex
+	handled []string
+	causes  []error
+}
+
+func (h *sleepyHandler) HandleContext(ctx context.Context, client *ws.Client, data []byte) error {
+	slow := string(data) == `{"type":"slow"}`
+	if slow {
+		time.Sleep(300 * time.Millisecond)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handled = append(h.handled, string(data))
+	if slow {
+		h.causes = append(h.causes, context.Cause(ctx))
+	}
+	return nil
+}
+
+func (h *sleepyHandler) Handle(client *ws.Client, data []byte) error {
+	return h.HandleContext(client.Context(), client, data)
+}
+
+func (h *sleepyHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.handled)
+}
+
+func TestHandlerTimeoutReportsAndContinues(t *testing.T) {
+	var mu sync.Mutex
+	var errs []error
+	logs := &captureHandler{}
+	messages := &sleepyHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithHandlerTimeout(50*time.Millisecond),
+		ws.WithLogger(slog.New(logs)),
+		ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			return ws.ErrorReply
+		}),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"slow"}`))
+	if frame := readErrorFrame(t, conn); frame.Code != "handler_timeout" {
+		t.Errorf("Expected a handler_timeout error frame, got %+v", frame)
+	}
+	mu.Lock()
+	if len(errs) != 1 || !errors.Is(errs[0], ws.ErrHandlerTimeout) {
+		t.Errorf("Expected the error handler to get ErrHandlerTimeout, got %v", errs)
+	}
+	mu.Unlock()
+	if stats := handler.Stats(); stats.HandlerTimeouts != 1 || stats.LateHandlers != 1 {
+		t.Errorf("Expected one timeout with its handler still running, got %d and %d", stats.HandlerTimeouts, stats.LateHandlers)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"fast"}`))
+	if !waitFor(t, 100*time.Millisecond, func() bool { return messages.count() == 1 }) {
+		t.Fatal("Expected the next message to be handled while the late handler runs")
+	}
+
+	level, attrs := logs.waitFor(t, ws.LogHandlerLate)
+	if level != slog.LevelWarn || attrs["duration"].Duration() < 300*time.Millisecond {
+		t.Errorf("Expected a warning with the late handler's duration, got %v %v", level, attrs)
+	}
+	if got := handler.Stats().LateHandlers; got != 0 {
+		t.Errorf("Expected no late handlers once it returned, got %d", got)
+	}
+	messages.mu.Lock()
+	defer messages.mu.Unlock()
+	if len(messages.causes) != 1 || !errors.Is(messages.causes[0], ws.ErrHandlerTimeout) {
+		t.Errorf("Expected the handler's context to end with ErrHandlerTimeout, got %v", messages.causes)
+	}
+}
+
+func TestHandlerTimeoutActionClose(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &sleepyHandler{}, &mockEnvelopePersister{},
+		ws.WithHandlerTimeout(50*time.Millisecond),
+		ws.WithHandlerTimeoutAction(ws.ErrorClose),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	conn.MustSend([]byte(`{"type":"slow"}`))
+	conn.ExpectClose(websocket.CloseInternalServerErr)
+}
+
+func TestHandlerWithinTimeoutUnaffected(t *testing.T) {
+	messages := &sleepyHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithHandlerTimeout(time.Second),
+		ws.WithHandlerTimeoutAction(ws.ErrorReply),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	conn.MustSend([]byte(`{"type":"slow"}`))
+	conn.ExpectNoMessage(500 * time.Millisecond)
+	if messages.count() != 1 || handler.Stats().HandlerTimeouts != 0 {
+		t.Error("Expected the message to be handled without timing out")
+	}
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+type heartbeat struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+	TS   int64  `json:"ts"`
+}
+
+// expectHeartbeat reads the next frame and fails unless it is heartbeat
+// seq, returning it as sent.
+func expectHeartbeat(t *testing.T, conn *wstest.Conn, seq uint64) []byte {
+	t.Helper()
+	data := conn.ExpectMessage(2 * time.Second)
+	var hb heartbeat
+	if err := json.Unmarshal(data, &hb); err != nil || hb.Type != ws.HeartbeatMessageType || hb.Seq != seq {
+		t.Fatalf("Expected heartbeat %d, got %s", seq, data)
+	}
+	return data
+}
+
+func TestHeartbeatEchoesMeasureRTT(t *testing.T) {
+	clock := newFakeClock()
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithClock(clock),
+		ws.WithHeartbeat(30*time.Second, 2),
+	)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected a heartbeat to be scheduled")
+	}
+
+	// More heartbeats than the allowed misses, each echoed, keep the
+	// connection open.
+	for seq := uint64(1); seq <= 3; seq++ {
+		clock.Advance(30 * time.Second)
+		echo := expectHeartbeat(t, conn, seq)
+		rtt := time.Duration(seq) * 10 * time.Millisecond
+		clock.Advance(rtt)
+		conn.MustSend(echo)
+
+		if !waitFor(t, 2*time.Second, func() bool { return client.RTT() == rtt }) {
+			t.Fatalf("Expected an RTT of %v, got %v", rtt, client.RTT())
+		}
+		if !client.LastHeartbeat().Equal(clock.Now()) {
+			t.Errorf("Expected the last heartbeat at %v, got %v", clock.Now(), client.LastHeartbeat())
+		}
+	}
+
+	if !handler.IsOnline(client.ID) {
+		t.Fatal("Expected an echoing client to stay connected")
+	}
+	if got := messages.received(); len(got) != 0 {
+		t.Errorf("Expected echoes not to be handled, got %q", got)
+	}
+	if presence := handler.Hub.Presence(); len(presence) != 1 || presence[0].RTT != 30*time.Millisecond {
+		t.Errorf("Expected the RTT in presence, got %+v", presence)
+	}
+	if stats, _ := handler.ClientStats(client.ID); stats.RTT != 30*time.Millisecond {
+		t.Errorf("Expected the RTT in client stats, got %v", stats.RTT)
+	}
+}
+
+func TestHeartbeatClosesSilentClient(t *testing.T) {
+	clock := newFakeClock()
+	handler, reasons := disconnectReasons(t,
+		ws.WithClock(clock),
+		ws.WithHeartbeat(30*time.Second, 2),
+	)
+	conn := connect(t, newTestServer(t, handler))
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected a heartbeat to be scheduled")
+	}
+
+	clock.Advance(30 * time.Second)
+	expectHeartbeat(t, conn, 1)
+	clock.Advance(30 * time.Second)
+	expectHeartbeat(t, conn, 2)
+	go clock.Advance(30 * time.Second)
+
+	if text := conn.ExpectClose(websocket.CloseNormalClosure); text != "heartbeat timeout" {
+		t.Errorf("Expected the close reason \"heartbeat timeout\", got %q", text)
+	}
+	if reason := expectReason(t, reasons, ws.DisconnectTimeout, websocket.CloseNormalClosure); reason.Text != "heartbeat timeout" {
+		t.Errorf("Expected the reason \"heartbeat timeout\", got %q", reason.Text)
+	}
+}
+
+func TestDialEchoesHeartbeats(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithHeartbeat(20*time.Millisecond, 2),
+	)
+	conn := dialClient(t, wsURL(newTestServer(t, handler)))
+	client := registeredClient(t, handler)
+
+	if !waitFor(t, 2*time.Second, func() bool { return !client.LastHeartbeat().IsZero() }) {
+		t.Fatal("Expected the client to echo heartbeats")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !handler.IsOnline(client.ID) {
+		t.Fatal("Expected the client to stay connected")
+	}
+	select {
+	case env := <-conn.Receive():
+		t.Errorf("Expected heartbeats not to be delivered, got %+v", env)
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+func newTestServer(t testing.TB, handler http.Handler) *httptest.Server {
+	t.Helper()
+	return wstest.NewServer(t, handler).Server
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connect dials server with wstest, for tests written against its helpers.
+func connect(t *testing.T, server *httptest.Server, opts ...wstest.Option) *wstest.Conn {
+	t.Helper()
+	return wstest.Connect(t, wsURL(server), opts...)
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	return connect(t, server).Conn
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return condition()
+}
+
+func dialWithResponse(t *testing.T, server *httptest.Server, header http.Header) (*websocket.Conn, *http.Response) {
+	t.Helper()
+	var opts []wstest.Option
+	for key, values := range header {
+		for _, value := range values {
+			opts = append(opts, wstest.WithHeader(key, value))
+		}
+	}
+	conn := connect(t, server, opts...)
+	return conn.Conn, conn.Response
+}
+
+// isCloseError is websocket.IsCloseError for errors wrapping a close, such
+// as the *ws.DisconnectReason passed to disconnect hooks.
+func isCloseError(err error, codes ...int) bool {
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr) && websocket.IsCloseError(closeErr, codes...)
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// registeredClient waits for handler to register a connection and returns
+// the first one it finds.
+func registeredClient(t *testing.T, handler *ws.WebsocketHandler) *ws.Client {
+	t.Helper()
+	var client *ws.Client
+	if !waitFor(t, 2*time.Second, func() bool {
+		handler.Range(func(c *ws.Client) bool { client = c; return false })
+		return client != nil
+	}) {
+		t.Fatal("Expected client to be registered")
+	}
+	return client
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func requestHistory(t *testing.T, conn *websocket.Conn, payload any) ws.HistoryPage {
+	t.Helper()
+	ref := ws.NewIdentity()
+	conn.WriteJSON(map[string]any{"type": ws.HistoryMessageType, "id": ref.String(), "payload": payload})
+	var ack struct {
+		ID      ws.Identity    `json:"id"`
+		Payload ws.HistoryPage `json:"payload"`
+	}
+	if err := json.Unmarshal(readFrameOfType(t, conn, ws.AckMessageType), &ack); err != nil {
+		t.Fatalf("Expected a history page, got %v", err)
+	}
+	if ack.ID != ref {
+		t.Errorf("Expected the page to ack %s, got %s", ref, ack.ID)
+	}
+	return ack.Payload
+}
+
+func pageTypes(page ws.HistoryPage) []string {
+	types := make([]string, len(page.Envelopes))
+	for i, e := range page.Envelopes {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestNewIdentitySortsInCreationOrder(t *testing.T) {
+	previous := ws.NewIdentity()
+	for range 1000 {
+		next := ws.NewIdentity()
+		if previous.Compare(next) >= 0 || previous.String() >= next.String() {
+			t.Fatalf("Expected %s to sort before %s", previous, next)
+		}
+		previous = next
+	}
+}
+
+func TestUnread(t *testing.T) {
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	clientID := ws.NewIdentity()
+
+	var sent []ws.Identity
+	for range 3 {
+		envelope, _ := ws.NewEnvelope(clientID, "chat", "hi")
+		handler.SendEnvelope(envelope)
+		sent = append(sent, envelope.ID)
+	}
+	persister.ConfirmDelivery(sent[0], clientID)
+
+	if n, err := handler.Unread(clientID); err != nil || n != 2 {
+		t.Errorf("Expected 2 unread, got %d, %v", n, err)
+	}
+	if n, err := handler.Unread(ws.NewIdentity()); err != nil || n != 0 {
+		t.Errorf("Expected 0 unread for another client, got %d, %v", n, err)
+	}
+
+	unsupported := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	if _, err := unsupported.Unread(clientID); err != ws.ErrPersisterUnsupported {
+		t.Errorf("Expected ErrPersisterUnsupported, got %v", err)
+	}
+}
+
+func TestHistoryPagesBack(t *testing.T) {
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	base := time.Now()
+	for n, msgType := range []string{"a", "b", "c", "d", "e"} {
+		persister.SaveEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: msgType, Timestamp: base.Add(time.Duration(n) * time.Second)})
+	}
+	persister.SaveEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: ws.NewIdentity(), Type: "other", Timestamp: base})
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+
+	newest := requestHistory(t, conn, map[string]any{"limit": 2})
+	if got := pageTypes(newest); len(got) != 2 || got[0] != "d" || got[1] != "e" {
+		t.Fatalf("Expected the newest page [d e], got %v", got)
+	}
+	if newest.Unread != 5 {
+		t.Errorf("Expected 5 unread, got %d", newest.Unread)
+	}
+	older := requestHistory(t, conn, map[string]any{"before": newest.Envelopes[0].ID, "limit": 2})
+	if got := pageTypes(older); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("Expected the next page [b c], got %v", got)
+	}
+	oldest := requestHistory(t, conn, map[string]any{"before": older.Envelopes[0].ID, "limit": 2})
+	if got := pageTypes(oldest); len(got) != 1 || got[0] != "a" {
+		t.Errorf("Expected a short last page [a], got %v", got)
+	}
+	end := requestHistory(t, conn, map[string]any{"before": oldest.Envelopes[0].ID})
+	if end.Envelopes == nil || len(end.Envelopes) != 0 {
+		t.Errorf("Expected an empty page past the oldest envelope, got %v", pageTypes(end))
+	}
+	if all := requestHistory(t, conn, nil); len(all.Envelopes) != 5 {
+		t.Errorf("Expected the default limit to cover all 5 envelopes, got %v", pageTypes(all))
+	}
+}
+
+func TestHistoryErrors(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, ws.NewMemoryPersister())
+	conn, _ := dialWithResponse(t, newTestServer(t, handler), identityHeader(ws.NewIdentity()))
+
+	for name, tc := range map[string]struct {
+		payload any
+		code    string
+	}{
+		"unknown cursor": {map[string]any{"before": ws.NewIdentity()}, ws.ErrorCodeUnknownEnvelope},
+		"bad cursor":     {map[string]any{"before": "not-an-id"}, ws.ErrorCodeInvalidHistory},
+		"negative limit": {map[string]any{"limit": -1}, ws.ErrorCodeInvalidHistory},
+	} {
+		conn.WriteJSON(map[string]any{"type": ws.HistoryMessageType, "id": ws.NewIdentity().String(), "payload": tc.payload})
+		if frame := readErrorFrame(t, conn); frame.Code != tc.code {
+			t.Errorf("%s: expected %s, got %+v", name, tc.code, frame)
+		}
+	}
+
+	unsupported := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	conn, _ = dialWithResponse(t, newTestServer(t, unsupported), identityHeader(ws.NewIdentity()))
+	conn.WriteJSON(map[string]any{"type": ws.HistoryMessageType, "id": ws.NewIdentity().String()})
+	if frame := readErrorFrame(t, conn); frame.Code != ws.ErrorCodeHistoryFailed {
+		t.Errorf("Expected history_failed without a history fetcher, got %+v", frame)
+	}
+}
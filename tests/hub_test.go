@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestHubRegisterAndLookup(t *testing.T) {
+	hub := ws.NewHub()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	hub.Register(client)
+	if got, ok := hub.Get(client.ID); !ok || got != client {
+		t.Error("Expected registered client to be found by identity")
+	}
+	if hub.Len() != 1 {
+		t.Errorf("Expected 1 client, got %d", hub.Len())
+	}
+
+	hub.Unregister(client)
+	if _, ok := hub.Get(client.ID); ok {
+		t.Error("Expected unregistered client not to be found")
+	}
+	if hub.Len() != 0 {
+		t.Errorf("Expected 0 clients, got %d", hub.Len())
+	}
+}
+
+func TestHubRangeStopsEarly(t *testing.T) {
+	hub := ws.NewHub()
+	for i := 0; i < 5; i++ {
+		hub.Register(ws.NewClient(ws.NewIdentity(), nil))
+	}
+
+	visited := 0
+	hub.Range(func(client *ws.Client) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("Expected Range to stop after 3 clients, visited %d", visited)
+	}
+}
+
+func TestHandlerRegistersClientsUnderLoad(t *testing.T) {
+	const count = 300
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	var wg sync.WaitGroup
+	conns := make(chan *websocket.Conn, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+			if err != nil {
+				t.Errorf("Failed to dial: %v", err)
+				return
+			}
+			conns <- conn
+		}()
+	}
+	wg.Wait()
+	close(conns)
+
+	if !waitFor(t, 5*time.Second, func() bool { return handler.Len() == count }) {
+		t.Fatalf("Expected %d registered clients, got %d", count, handler.Len())
+	}
+
+	for conn := range conns {
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			conn.Close()
+		}(conn)
+	}
+	wg.Wait()
+
+	if !waitFor(t, 5*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Errorf("Expected all clients to unregister, %d remain", handler.Len())
+	}
+}
+
+func TestShardedHubAggregates(t *testing.T) {
+	const count = 2000
+	hub := ws.NewHub(ws.WithShards(8))
+	ids := make(map[ws.Identity]int)
+	for i := 0; i < count; i++ {
+		id := ws.NewIdentity()
+		if i%4 == 0 {
+			// Every fourth client is a second device of the previous
+			// identity.
+			for prev := range ids {
+				id = prev
+				break
+			}
+		}
+		hub.Register(ws.NewClient(id, nil))
+		ids[id]++
+	}
+
+	if hub.Len() != count {
+		t.Fatalf("Expected %d clients, got %d", count, hub.Len())
+	}
+	visited := 0
+	hub.Range(func(*ws.Client) bool { visited++; return true })
+	if visited != count || len(hub.Presence()) != count {
+		t.Errorf("Expected Range and Presence to cover %d clients, got %d and %d", count, visited, len(hub.Presence()))
+	}
+	for id, n := range ids {
+		if got := len(hub.Connections(id)); got != n {
+			t.Fatalf("Expected %d connections for %s, got %d", n, id, got)
+		}
+	}
+
+	result := hub.Broadcast([]byte("x"))
+	if result.Delivered != count || result.Dropped != 0 {
+		t.Errorf("Expected the broadcast to reach all %d clients, got %+v", count, result)
+	}
+	hub.Range(func(c *ws.Client) bool {
+		if c.QueuedBytes() != 1 {
+			t.Fatalf("Expected every client to get the broadcast once, got %d bytes", c.QueuedBytes())
+		}
+		return true
+	})
+}
+
+func TestShardedHubConcurrentRegisterBroadcastUnregister(t *testing.T) {
+	hub := ws.NewHub(ws.WithShards(4))
+	stable := make([]*ws.Client, 1500)
+	for i := range stable {
+		stable[i] = ws.NewClient(ws.NewIdentity(), nil)
+		hub.Register(stable[i])
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				client := ws.NewClient(ws.NewIdentity(), nil)
+				hub.Register(client)
+				hub.Join("lobby", client)
+				hub.Unregister(client)
+			}
+		}()
+	}
+	const broadcasts = 50
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < broadcasts; i++ {
+				if result := hub.Broadcast([]byte("x")); result.Delivered < len(stable) {
+					t.Errorf("Expected at least the %d stable clients to be reached, got %+v", len(stable), result)
+				}
+				hub.Len()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if hub.Len() != len(stable) {
+		t.Errorf("Expected the %d stable clients to remain, got %d", len(stable), hub.Len())
+	}
+	if members := hub.RoomMembers("lobby"); len(members) != 0 {
+		t.Errorf("Expected unregistered clients to have left the room, got %d members", len(members))
+	}
+	for _, client := range stable {
+		if client.QueuedBytes()+int64(client.Dropped()) != 2*broadcasts {
+			t.Fatalf("Expected every broadcast to be queued or dropped once per client, got %d and %d",
+				client.QueuedBytes(), client.Dropped())
+		}
+	}
+}
+
+func TestShardedHubNestedBroadcast(t *testing.T) {
+	const count = 2000
+	hub := ws.NewHub(ws.WithShards(4))
+	target := ws.NewClient(ws.NewIdentity(), nil)
+	hub.Register(target)
+	for i := 1; i < count; i++ {
+		hub.Register(ws.NewClient(ws.NewIdentity(), nil))
+	}
+
+	// A predicate that broadcasts in turn finds every shard's worker busy
+	// with the outer broadcast, and must walk the shards itself.
+	done := make(chan ws.BroadcastResult, 1)
+	go func() {
+		var nested ws.BroadcastResult
+		var once sync.Once
+		hub.BroadcastFunc(func(c *ws.Client) bool {
+			once.Do(func() {
+				nested = hub.BroadcastFunc(func(c *ws.Client) bool { return c == target }, []byte("nested"))
+			})
+			return false
+		}, []byte("outer"))
+		done <- nested
+	}()
+
+	select {
+	case nested := <-done:
+		if nested.Delivered != 1 {
+			t.Errorf("Expected the nested broadcast to reach its one client, got %+v", nested)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a broadcast from inside a broadcast to complete")
+	}
+
+	for i := 0; i < 20; i++ {
+		if result := hub.Broadcast([]byte("x")); result.Delivered+result.Dropped != count {
+			t.Fatalf("Expected broadcast %d to cover %d clients, got %+v", i, count, result)
+		}
+	}
+}
+
+// BenchmarkHubBroadcast compares one shard, the hub before sharding, with
+// the default of one per CPU. The clients have no connection, so once
+// their queues fill every delivery is a drop.
+func BenchmarkHubBroadcast(b *testing.B) {
+	for _, clients := range []int{10_000, 50_000} {
+		for _, shards := range []struct {
+			name string
+			n    int
+		}{{"single", 1}, {"sharded", 0}} {
+			b.Run(fmt.Sprintf("clients=%d/%s", clients, shards.name), func(b *testing.B) {
+				hub := ws.NewHub(ws.WithShards(shards.n))
+				for i := 0; i < clients; i++ {
+					hub.Register(ws.NewClient(ws.NewIdentity(), nil))
+				}
+				data := []byte(`{"type":"tick"}`)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					hub.Broadcast(data)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestIdentityFromStringIsDeterministic(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		externalID := fmt.Sprintf("auth0|user-%d", i)
+		a := ws.IdentityFromString(ws.DefaultIdentityNamespace, externalID)
+		b := ws.IdentityFromString(ws.DefaultIdentityNamespace, externalID)
+		if a != b {
+			t.Fatalf("Expected %q to map to one identity, got %s and %s", externalID, a, b)
+		}
+		if a.IsZero() || a.UUID().Version() != 5 {
+			t.Fatalf("Expected a version 5 UUID for %q, got %s", externalID, a)
+		}
+	}
+}
+
+func TestIdentityFromStringIsStable(t *testing.T) {
+	// Derived identities must not change between releases.
+	got := ws.IdentityFromString(ws.DefaultIdentityNamespace, "user-42")
+	want := ws.Identity(uuid.NewSHA1(uuid.MustParse("f988c574-1143-471e-ae8d-f473df0d1811"), []byte("user-42")))
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestIdentityFromStringCollisions(t *testing.T) {
+	other := uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://other-idp.example"))
+	seen := make(map[ws.Identity]string)
+	for i := 0; i < 1000; i++ {
+		externalID := fmt.Sprintf("user-%d", i)
+		for _, ns := range []uuid.UUID{ws.DefaultIdentityNamespace, other} {
+			id := ws.IdentityFromString(ns, externalID)
+			key := ns.String() + "/" + externalID
+			if prev, ok := seen[id]; ok {
+				t.Fatalf("Expected distinct inputs to diverge, %s and %s both gave %s", prev, key, id)
+			}
+			seen[id] = key
+		}
+	}
+}
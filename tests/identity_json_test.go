@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestParseIdentity(t *testing.T) {
+	id := ws.NewIdentity()
+	parsed, err := ws.ParseIdentity(id.String())
+	if err != nil || parsed != id {
+		t.Errorf("Expected %s, got %s (%v)", id, parsed, err)
+	}
+	if _, err := ws.ParseIdentity("not-a-uuid"); err == nil {
+		t.Error("Expected an error for a malformed identity")
+	}
+}
+
+func TestIdentityJSON(t *testing.T) {
+	id := ws.NewIdentity()
+	data, err := json.Marshal(id)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if string(data) != `"`+id.String()+`"` {
+		t.Errorf("Expected the canonical string form, got %s", data)
+	}
+
+	var decoded ws.Identity
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != id {
+		t.Errorf("Expected %s back, got %s (%v)", id, decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`"garbage"`), &decoded); err == nil {
+		t.Error("Expected an error for a malformed identity")
+	}
+}
+
+func TestIdentityJSONAcceptsLegacyArrayForm(t *testing.T) {
+	id := ws.NewIdentity()
+	legacy, _ := json.Marshal([16]byte(id))
+	if !strings.HasPrefix(string(legacy), "[") {
+		t.Fatalf("Expected the legacy array form, got %s", legacy)
+	}
+
+	var decoded ws.Identity
+	if err := json.Unmarshal(legacy, &decoded); err != nil || decoded != id {
+		t.Errorf("Expected %s from the legacy form, got %s (%v)", id, decoded, err)
+	}
+}
+
+func TestIdentityAsMapKey(t *testing.T) {
+	a, b := ws.NewIdentity(), ws.NewIdentity()
+	in := map[ws.Identity]int{a: 1, b: 2}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"`+a.String()+`":1`) {
+		t.Errorf("Expected string keys, got %s", data)
+	}
+
+	var out map[ws.Identity]int
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if len(out) != 2 || out[a] != 1 || out[b] != 2 {
+		t.Errorf("Expected the map to survive, got %v", out)
+	}
+}
+
+func TestEnvelopeJSONRoundTrip(t *testing.T) {
+	replyTo := ws.NewIdentity()
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	envelope, err := ws.NewEnvelope(ws.NewIdentity(), "chat.message", map[string]string{"text": "hi"})
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+	envelope.ReplyTo = &replyTo
+	envelope.ExpiresAt = &expires
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"id":"`+envelope.ID.String()+`"`) ||
+		!strings.Contains(string(data), `"reply_to":"`+replyTo.String()+`"`) {
+		t.Errorf("Expected string identities, got %s", data)
+	}
+
+	var decoded ws.Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if decoded.ID != envelope.ID || decoded.ClientID != envelope.ClientID || decoded.Type != envelope.Type {
+		t.Errorf("Expected %+v, got %+v", envelope, decoded)
+	}
+	if decoded.ReplyTo == nil || *decoded.ReplyTo != replyTo {
+		t.Errorf("Expected reply_to %s, got %v", replyTo, decoded.ReplyTo)
+	}
+	if string(decoded.Payload) != string(envelope.Payload) {
+		t.Errorf("Expected payload %s, got %s", envelope.Payload, decoded.Payload)
+	}
+	if !decoded.Timestamp.Equal(envelope.Timestamp) || !decoded.ExpiresAt.Equal(expires) {
+		t.Errorf("Expected times to survive, got %v and %v", decoded.Timestamp, decoded.ExpiresAt)
+	}
+}
+
+func TestEnvelopeFromClientWithStringIDs(t *testing.T) {
+	id, clientID := ws.NewIdentity(), ws.NewIdentity()
+	data := `{"id":"` + id.String() + `","client_id":"` + clientID.String() + `","type":"ping","payload":{}}`
+
+	var envelope ws.Envelope
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		t.Fatalf("Expected a client envelope with string IDs to decode, got %v", err)
+	}
+	if envelope.ID != id || envelope.ClientID != clientID {
+		t.Errorf("Expected %s and %s, got %s and %s", id, clientID, envelope.ID, envelope.ClientID)
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/oduortoni/websocket/ws"
+	_ "modernc.org/sqlite"
+)
+
+func openIdentityDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE ids (text_id TEXT, blob_id BLOB)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return db
+}
+
+func TestIdentitySQLRoundTrip(t *testing.T) {
+	db := openIdentityDB(t)
+	id := ws.NewIdentity()
+	if _, err := db.Exec(`INSERT INTO ids (text_id, blob_id) VALUES (?, ?)`, id, ws.BinaryIdentity(id)); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	var text string
+	var blob []byte
+	if err := db.QueryRow(`SELECT text_id, blob_id FROM ids`).Scan(&text, &blob); err != nil {
+		t.Fatalf("Failed to read raw columns: %v", err)
+	}
+	if text != id.String() {
+		t.Errorf("Expected the string form %s, got %q", id, text)
+	}
+	if len(blob) != 16 {
+		t.Errorf("Expected the 16-byte form, got %d bytes", len(blob))
+	}
+
+	var fromText, fromBlob ws.Identity
+	if err := db.QueryRow(`SELECT text_id, blob_id FROM ids`).Scan(&fromText, &fromBlob); err != nil {
+		t.Fatalf("Failed to scan identities: %v", err)
+	}
+	if fromText != id || fromBlob != id {
+		t.Errorf("Expected %s from both columns, got %s and %s", id, fromText, fromBlob)
+	}
+}
+
+func TestIdentityScanInputs(t *testing.T) {
+	id := ws.NewIdentity()
+	raw := [16]byte(id)
+	for name, src := range map[string]any{
+		"string":        id.String(),
+		"36-byte slice": []byte(id.String()),
+		"16-byte slice": raw[:],
+	} {
+		var got ws.Identity
+		if err := got.Scan(src); err != nil || got != id {
+			t.Errorf("%s: expected %s, got %s (%v)", name, id, got, err)
+		}
+	}
+
+	var bad ws.Identity
+	if err := bad.Scan([]byte("short")); err == nil {
+		t.Error("Expected an error for a malformed identity")
+	}
+	if err := bad.Scan(42); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+}
+
+func TestIdentityScanNull(t *testing.T) {
+	db := openIdentityDB(t)
+	if _, err := db.Exec(`INSERT INTO ids (text_id, blob_id) VALUES (NULL, NULL)`); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	id := ws.NewIdentity()
+	if err := db.QueryRow(`SELECT text_id FROM ids`).Scan(&id); err != nil {
+		t.Fatalf("Expected NULL to scan, got %v", err)
+	}
+	if !id.IsZero() {
+		t.Errorf("Expected NULL to scan as the zero identity, got %s", id)
+	}
+
+	err := db.QueryRow(`SELECT blob_id FROM ids`).Scan(ws.RequireIdentity(&id))
+	if !errors.Is(err, ws.ErrNullIdentity) {
+		t.Errorf("Expected ErrNullIdentity, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// idleFixture dials a handler with a one-minute idle timeout on a fake
+// clock and waits for the idle check to be scheduled.
+func idleFixture(t *testing.T, opts ...ws.Option) (*fakeClock, *mockMessageHandler, *ws.WebsocketHandler, chan *ws.DisconnectReason) {
+	t.Helper()
+	clock := newFakeClock()
+	messages := &mockMessageHandler{}
+	reasons := make(chan *ws.DisconnectReason, 1)
+	opts = append(opts,
+		ws.WithClock(clock),
+		ws.WithIdleTimeout(time.Minute),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) { reasons <- client.Wait() }),
+	)
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{}, opts...)
+	return clock, messages, handler, reasons
+}
+
+func waitForIdleCheck(t *testing.T, clock *fakeClock) {
+	t.Helper()
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected an idle check to be scheduled")
+	}
+}
+
+func expectIdleClose(t *testing.T, reasons chan *ws.DisconnectReason) {
+	t.Helper()
+	reason := expectReason(t, reasons, ws.DisconnectTimeout, websocket.CloseNormalClosure)
+	if reason.Text != "idle timeout" {
+		t.Errorf("Expected the reason \"idle timeout\", got %q", reason.Text)
+	}
+}
+
+func TestIdleTimeoutClosesQuietConnection(t *testing.T) {
+	clock, _, handler, reasons := idleFixture(t)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	waitForIdleCheck(t, clock)
+
+	clock.Advance(59 * time.Second)
+	if !handler.IsOnline(client.ID) {
+		t.Fatal("Expected the connection to stay open before the timeout")
+	}
+	go clock.Advance(time.Second)
+
+	if text := conn.ExpectClose(websocket.CloseNormalClosure); text != "idle timeout" {
+		t.Errorf("Expected the close reason \"idle timeout\", got %q", text)
+	}
+	expectIdleClose(t, reasons)
+}
+
+func TestIdleTimeoutResetsOnMessage(t *testing.T) {
+	clock, messages, handler, reasons := idleFixture(t)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	waitForIdleCheck(t, clock)
+
+	clock.Advance(40 * time.Second)
+	conn.MustSend([]byte(`{"type":"ping"}`))
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Fatal("Expected the message to be handled")
+	}
+
+	// The check due at one minute finds only 20s of idleness and
+	// reschedules for 40s later.
+	clock.Advance(20 * time.Second)
+	if !handler.IsOnline(client.ID) || clock.pending() != 1 {
+		t.Fatal("Expected the message to keep the connection open")
+	}
+	go clock.Advance(40 * time.Second)
+
+	conn.ExpectClose(websocket.CloseNormalClosure)
+	expectIdleClose(t, reasons)
+}
+
+func TestIdleTimeoutIgnoresSendsByDefault(t *testing.T) {
+	clock, _, handler, reasons := idleFixture(t)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	waitForIdleCheck(t, clock)
+
+	clock.Advance(40 * time.Second)
+	client.SendJSON(map[string]string{"type": "news"})
+	conn.ExpectMessage(2 * time.Second)
+	go clock.Advance(20 * time.Second)
+
+	conn.ExpectClose(websocket.CloseNormalClosure)
+	expectIdleClose(t, reasons)
+}
+
+func TestIdleTimeoutIncludesSends(t *testing.T) {
+	clock, _, handler, reasons := idleFixture(t, ws.WithIdleIncludesSends())
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	waitForIdleCheck(t, clock)
+
+	clock.Advance(40 * time.Second)
+	client.SendJSON(map[string]string{"type": "news"})
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().MessagesOut == 1 }) {
+		t.Fatal("Expected the send to be written")
+	}
+
+	clock.Advance(20 * time.Second)
+	if !handler.IsOnline(client.ID) {
+		t.Fatal("Expected the send to keep the connection open")
+	}
+	go clock.Advance(40 * time.Second)
+
+	conn.ExpectClose(websocket.CloseNormalClosure)
+	expectIdleClose(t, reasons)
+}
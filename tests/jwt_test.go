@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wsauth"
+)
+
+var jwtSecret = []byte("test-secret")
+
+// signJWT encodes claims as a token signed with HS256 (given a []byte
+// secret) or RS256 (given an *rsa.PrivateKey).
+func signJWT(t *testing.T, key any, claims map[string]any) string {
+	t.Helper()
+	alg := "HS256"
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+	return signJWTWith(t, alg, key, claims)
+}
+
+func signJWTWith(t *testing.T, alg string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(wsauth.Header{Alg: alg, Typ: "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestJWTValidatorSession(t *testing.T) {
+	validator := wsauth.NewJWTValidator(wsauth.StaticKey(jwtSecret),
+		wsauth.WithRolesClaim("roles"),
+		wsauth.WithClaims("tenant", "missing"),
+	)
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := signJWT(t, jwtSecret, map[string]any{
+		"sub":    "user-42",
+		"exp":    exp.Unix(),
+		"roles":  []string{"admin", "support"},
+		"tenant": "acme",
+	})
+	dialWithResponse(t, server, bearer(token))
+
+	session := registeredClient(t, handler).Session()
+	if want := ws.IdentityFromString(ws.DefaultIdentityNamespace, "user-42"); session.ClientID != want {
+		t.Errorf("Expected the identity derived from sub, got %s", session.ClientID)
+	}
+	if !session.ExpiresAt.Equal(exp) {
+		t.Errorf("Expected ExpiresAt %v from exp, got %v", exp, session.ExpiresAt)
+	}
+	if !session.HasRole("admin") || !session.HasRole("support") {
+		t.Errorf("Expected the roles claim, got %v", session.Roles)
+	}
+	if tenant, _ := session.ClaimString("tenant"); tenant != "acme" {
+		t.Errorf("Expected the tenant claim, got %v", session.Claims)
+	}
+	if _, ok := session.Claims["missing"]; ok || len(session.Claims) != 1 {
+		t.Errorf("Expected only the selected claims present in the token, got %v", session.Claims)
+	}
+	if session.Metadata["sub"] != "user-42" {
+		t.Errorf("Expected the subject in metadata, got %v", session.Metadata)
+	}
+}
+
+func TestJWTValidatorUUIDSubject(t *testing.T) {
+	id := ws.NewIdentity()
+	validator := wsauth.NewJWTValidator(wsauth.StaticKey(jwtSecret))
+
+	session, err := validator.ValidateToken(signJWT(t, jwtSecret, map[string]any{"sub": id.String()}))
+	if err != nil {
+		t.Fatalf("Expected the token to validate, got %v", err)
+	}
+	if session.ClientID != id {
+		t.Errorf("Expected the UUID subject used as is, got %s", session.ClientID)
+	}
+	if !session.ExpiresAt.IsZero() {
+		t.Errorf("Expected a token without exp never to expire, got %v", session.ExpiresAt)
+	}
+}
+
+func TestJWTValidatorTokenSources(t *testing.T) {
+	token := signJWT(t, jwtSecret, map[string]any{"sub": "user-1"})
+	tests := []struct {
+		name    string
+		sources []wsauth.TokenSource
+		query   string
+		header  http.Header
+		status  int
+	}{
+		{"default header", nil, "", bearer(token), http.StatusSwitchingProtocols},
+		{"default query", nil, "token=" + url.QueryEscape(token), nil, http.StatusSwitchingProtocols},
+		{"non-bearer header", nil, "", http.Header{"Authorization": {"Basic " + token}}, http.StatusUnauthorized},
+		{"cookie", []wsauth.TokenSource{wsauth.FromCookie("session")}, "", http.Header{"Cookie": {"session=" + token}}, http.StatusSwitchingProtocols},
+		{"cookie source ignores header", []wsauth.TokenSource{wsauth.FromCookie("session")}, "", bearer(token), http.StatusUnauthorized},
+		{"custom query", []wsauth.TokenSource{wsauth.FromQuery("access_token")}, "access_token=" + token, nil, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []wsauth.Option
+			if tt.sources != nil {
+				opts = append(opts, wsauth.WithTokenSources(tt.sources...))
+			}
+			handler := ws.NewWebSocketHandler(wsauth.NewJWTValidator(wsauth.StaticKey(jwtSecret), opts...),
+				&mockMessageHandler{}, &mockEnvelopePersister{})
+			server := newTestServer(t, handler)
+
+			target := wsURL(server)
+			if tt.query != "" {
+				target += "?" + tt.query
+			}
+			if got := dialStatus(target, tt.header); got != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, got)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorRSA(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	validator := wsauth.NewJWTValidator(wsauth.StaticKey(&private.PublicKey))
+
+	if _, err := validator.ValidateToken(signJWT(t, private, map[string]any{"sub": "user-1"})); err != nil {
+		t.Errorf("Expected an RS256 token to validate, got %v", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := validator.ValidateToken(signJWT(t, other, map[string]any{"sub": "user-1"})); !errors.Is(err, wsauth.ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid for another key, got %v", err)
+	}
+
+	// A token must not be able to switch to HMAC keyed with the public key.
+	public, _ := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	forged := signJWT(t, public, map[string]any{"sub": "user-1"})
+	if _, err := validator.ValidateToken(forged); !errors.Is(err, wsauth.ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm for an HS256 token, got %v", err)
+	}
+}
+
+func TestJWTValidatorRejections(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	validator := wsauth.NewJWTValidator(wsauth.StaticKey(jwtSecret),
+		wsauth.WithClock(clock),
+		wsauth.WithLeeway(5*time.Second),
+		wsauth.WithIssuer("https://auth.example"),
+		wsauth.WithAudience("chat"),
+	)
+	valid := func(extra map[string]any) map[string]any {
+		claims := map[string]any{"sub": "user-1", "iss": "https://auth.example", "aud": []string{"web", "chat"}}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		err    error
+		status int
+	}{
+		{"missing", "", wsauth.ErrTokenMissing, http.StatusUnauthorized},
+		{"malformed", "not-a-token", wsauth.ErrTokenMalformed, http.StatusBadRequest},
+		{"bad claims encoding", "e30.!!!.sig", wsauth.ErrTokenMalformed, http.StatusBadRequest},
+		{"wrong secret", signJWT(t, []byte("other"), valid(nil)), wsauth.ErrSignatureInvalid, http.StatusUnauthorized},
+		{"alg none", signJWTWith(t, "none", nil, valid(nil)), wsauth.ErrUnsupportedAlgorithm, http.StatusUnauthorized},
+		{"expired", signJWT(t, jwtSecret, valid(map[string]any{"exp": now.Add(-time.Minute).Unix()})), wsauth.ErrTokenExpired, http.StatusUnauthorized},
+		{"not valid yet", signJWT(t, jwtSecret, valid(map[string]any{"nbf": now.Add(time.Minute).Unix()})), wsauth.ErrTokenNotValidYet, http.StatusUnauthorized},
+		{"exp not a number", signJWT(t, jwtSecret, valid(map[string]any{"exp": "tomorrow"})), wsauth.ErrTokenMalformed, http.StatusUnauthorized},
+		{"wrong issuer", signJWT(t, jwtSecret, valid(map[string]any{"iss": "https://evil.example"})), wsauth.ErrInvalidIssuer, http.StatusUnauthorized},
+		{"wrong audience", signJWT(t, jwtSecret, valid(map[string]any{"aud": "admin"})), wsauth.ErrInvalidAudience, http.StatusUnauthorized},
+		{"no subject", signJWT(t, jwtSecret, valid(map[string]any{"sub": ""})), wsauth.ErrInvalidSubject, http.StatusUnauthorized},
+		{"within leeway", signJWT(t, jwtSecret, valid(map[string]any{"exp": now.Add(-2 * time.Second).Unix()})), nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.ValidateToken(tt.token)
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("Expected the token to validate, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+			var verr *ws.ValidationError
+			if !errors.As(err, &verr) || verr.Status != tt.status {
+				t.Errorf("Expected a ValidationError with status %d, got %#v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorRejectedUpgrade(t *testing.T) {
+	handler := ws.NewWebSocketHandler(wsauth.NewJWTValidator(wsauth.StaticKey(jwtSecret)),
+		&mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	expired := signJWT(t, jwtSecret, map[string]any{"sub": "user-1", "exp": time.Now().Add(-time.Hour).Unix()})
+	tests := []struct {
+		name      string
+		header    http.Header
+		status    int
+		challenge string
+	}{
+		{"missing", nil, http.StatusUnauthorized, "Bearer"},
+		{"expired", bearer(expired), http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"malformed", bearer("garbage"), http.StatusBadRequest, `Bearer error="invalid_request"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("Expected challenge %q, got %q", tt.challenge, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestUnresponsiveClientIsDisconnected(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithPingInterval(50*time.Millisecond),
+		ws.WithPongWait(200*time.Millisecond),
+	)
+	server := newTestServer(t, handler)
+	// The server arms its pong deadline before dial returns.
+	start := time.Now()
+	conn := dial(t, server)
+	conn.SetPingHandler(func(string) error { return nil })
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("Expected connection to survive the pong wait, closed after %v", elapsed)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected connection to close shortly after the pong wait, took %v", elapsed)
+	}
+}
+
+func TestResponsiveClientStaysConnected(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithPingInterval(50*time.Millisecond),
+		ws.WithPongWait(200*time.Millisecond),
+	)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	conn.SetReadDeadline(time.Now().Add(800 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	if !isTimeout(err) {
+		t.Fatalf("Expected connection to stay open until the read deadline, got %v", err)
+	}
+	if pings < 5 {
+		t.Errorf("Expected regular pings, got %d", pings)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type hookRecorder struct {
+	mu          sync.Mutex
+	events      []string
+	connects    map[*ws.Client]int
+	disconnects map[*ws.Client]int
+	errs        []error
+}
+
+func newHookRecorder() *hookRecorder {
+	return &hookRecorder{
+		connects:    make(map[*ws.Client]int),
+		disconnects: make(map[*ws.Client]int),
+	}
+}
+
+func (h *hookRecorder) options() []ws.Option {
+	return []ws.Option{
+		ws.WithOnConnect(func(client *ws.Client, session ws.SessionInfo) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.connects[client]++
+			h.events = append(h.events, "connect")
+		}),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.disconnects[client]++
+			h.events = append(h.events, "disconnect")
+			h.errs = append(h.errs, err)
+		}),
+	}
+}
+
+func (h *hookRecorder) disconnectCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.errs)
+}
+
+func TestLifecycleHooksOnCleanClose(t *testing.T) {
+	hooks := newHookRecorder()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, hooks.options()...)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+
+	if !waitFor(t, 2*time.Second, func() bool { return hooks.disconnectCount() == 1 }) {
+		t.Fatal("Expected disconnect hook to fire")
+	}
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if len(hooks.events) != 2 || hooks.events[0] != "connect" || hooks.events[1] != "disconnect" {
+		t.Errorf("Expected connect then disconnect, got %v", hooks.events)
+	}
+	if !isCloseError(hooks.errs[0], websocket.CloseNormalClosure) {
+		t.Errorf("Expected normal closure error, got %v", hooks.errs[0])
+	}
+}
+
+func TestLifecycleHooksFireExactlyOnceOnReset(t *testing.T) {
+	const count = 20
+	hooks := newHookRecorder()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, hooks.options()...)
+	server := newTestServer(t, handler)
+
+	for i := 0; i < count; i++ {
+		conn := dial(t, server)
+		tcp := conn.UnderlyingConn().(*net.TCPConn)
+		tcp.SetLinger(0)
+		tcp.Close()
+	}
+
+	if !waitFor(t, 5*time.Second, func() bool { return hooks.disconnectCount() == count }) {
+		t.Fatalf("Expected %d disconnects, got %d", count, hooks.disconnectCount())
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if len(hooks.connects) != count || len(hooks.disconnects) != count {
+		t.Fatalf("Expected %d distinct clients, got %d connects and %d disconnects", count, len(hooks.connects), len(hooks.disconnects))
+	}
+	for client, n := range hooks.connects {
+		if n != 1 || hooks.disconnects[client] != 1 {
+			t.Errorf("Expected exactly one connect and disconnect, got %d and %d", n, hooks.disconnects[client])
+		}
+	}
+	for _, err := range hooks.errs {
+		if err == nil || isCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("Expected abrupt reset to surface a read error, got %v", err)
+		}
+	}
+}
+
+func TestDisconnectHookRunsAfterUnregister(t *testing.T) {
+	var registered bool
+	done := make(chan struct{})
+	var handler *ws.WebsocketHandler
+	handler = ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			_, registered = handler.Get(client.ID)
+			close(done)
+		}),
+	)
+	server := newTestServer(t, handler)
+	dial(t, server).Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected disconnect hook to fire")
+	}
+	if registered {
+		t.Error("Expected client to be unregistered before the disconnect hook runs")
+	}
+}
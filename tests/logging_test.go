@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// captureHandler is a slog.Handler that records every record it is given.
+type captureHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler      { return h }
+
+// find returns the attributes of the first record with msg.
+func (h *captureHandler) find(msg string) (slog.Level, map[string]slog.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return r.Level, attrs, true
+	}
+	return 0, nil, false
+}
+
+func (h *captureHandler) waitFor(t *testing.T, msg string) (slog.Level, map[string]slog.Value) {
+	t.Helper()
+	var level slog.Level
+	var attrs map[string]slog.Value
+	if !waitFor(t, 2*time.Second, func() bool {
+		var ok bool
+		level, attrs, ok = h.find(msg)
+		return ok
+	}) {
+		t.Fatalf("Expected a %s record", msg)
+	}
+	return level, attrs
+}
+
+func TestLoggerConnectionLifecycle(t *testing.T) {
+	capture := &captureHandler{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithLogger(slog.New(capture)),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+
+	level, attrs := capture.waitFor(t, ws.LogConnectionOpened)
+	if level != slog.LevelInfo {
+		t.Errorf("Expected connection_opened at Info, got %v", level)
+	}
+	if attrs["client_id"].String() != clientID.String() {
+		t.Errorf("Expected client_id %s, got %v", clientID, attrs["client_id"])
+	}
+	if attrs["remote_ip"].String() != "127.0.0.1" {
+		t.Errorf("Expected remote_ip 127.0.0.1, got %v", attrs["remote_ip"])
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	level, attrs = capture.waitFor(t, ws.LogConnectionClosed)
+	if level != slog.LevelInfo {
+		t.Errorf("Expected a clean close at Info, got %v", level)
+	}
+	if attrs["client_id"].String() != clientID.String() {
+		t.Errorf("Expected client_id %s, got %v", clientID, attrs["client_id"])
+	}
+	if reason, _ := attrs["reason"].Any().(error); !isCloseError(reason, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the close as the reason, got %v", attrs["reason"])
+	}
+	if attrs["duration"].Kind() != slog.KindDuration || attrs["duration"].Duration() <= 0 {
+		t.Errorf("Expected a positive duration, got %v", attrs["duration"])
+	}
+}
+
+func TestLoggerHandlerError(t *testing.T) {
+	capture := &captureHandler{}
+	errBoom := errors.New("boom")
+	router := ws.NewRouter()
+	router.HandleFunc("orders.place", func(client *ws.Client, env ws.Envelope) error {
+		return errBoom
+	})
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, router, &mockEnvelopePersister{},
+		ws.WithLogger(slog.New(capture)),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"orders.place","payload":{}}`))
+
+	level, attrs := capture.waitFor(t, ws.LogHandlerError)
+	if level != slog.LevelError {
+		t.Errorf("Expected handler_error at Error, got %v", level)
+	}
+	if attrs["msg_type"].String() != "orders.place" {
+		t.Errorf("Expected msg_type orders.place, got %v", attrs["msg_type"])
+	}
+	if attrs["client_id"].String() != clientID.String() {
+		t.Errorf("Expected client_id %s, got %v", clientID, attrs["client_id"])
+	}
+	if err, _ := attrs["error"].Any().(error); !errors.Is(err, errBoom) {
+		t.Errorf("Expected the handler's error, got %v", attrs["error"])
+	}
+}
+
+func TestLoggerUpgradeRejected(t *testing.T) {
+	capture := &captureHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithLogger(slog.New(capture)),
+		ws.WithAllowedOrigins([]string{"app.example"}),
+	)
+	server := newTestServer(t, handler)
+
+	dialStatus(wsURL(server), http.Header{"Origin": {"https://evil.example"}})
+
+	level, attrs := capture.waitFor(t, ws.LogUpgradeRejected)
+	if level != slog.LevelWarn {
+		t.Errorf("Expected upgrade_rejected at Warn, got %v", level)
+	}
+	if attrs["status"].Int64() != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %v", attrs["status"])
+	}
+	if err, _ := attrs["cause"].Any().(error); !errors.Is(err, ws.ErrOriginNotAllowed) {
+		t.Errorf("Expected ErrOriginNotAllowed as the cause, got %v", attrs["cause"])
+	}
+	if attrs["remote_ip"].String() != "127.0.0.1" {
+		t.Errorf("Expected remote_ip 127.0.0.1, got %v", attrs["remote_ip"])
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+func TestOversizedMessageClosesConnection(t *testing.T) {
+	disconnectErr := make(chan error, 1)
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, &mockEnvelopePersister{},
+		ws.WithMaxMessageSize(1024),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			disconnectErr <- err
+		}),
+	)
+	if handler.MaxMessageSize() != 1024 {
+		t.Errorf("Expected max message size 1024, got %d", handler.MaxMessageSize())
+	}
+
+	conn := wstest.ConnectPipe(t, handler)
+
+	conn.MustSend([]byte("small"))
+	// The server stops reading partway through the oversized frame, and a
+	// pipe has no buffer to absorb the rest, so the write never completes.
+	go conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 4096)))
+	conn.ExpectClose(websocket.CloseMessageTooBig)
+
+	select {
+	case err := <-disconnectErr:
+		if !errors.Is(err, ws.ErrMessageTooLarge) {
+			t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected disconnect hook to fire")
+	}
+
+	if received := messageHandler.received(); len(received) != 1 || string(received[0]) != "small" {
+		t.Errorf("Expected only the small message to be handled, got %d messages", len(received))
+	}
+}
+
+func TestMaxMessageSizeDefaultsToUnlimited(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	if handler.MaxMessageSize() != 0 {
+		t.Errorf("Expected no default limit, got %d", handler.MaxMessageSize())
+	}
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/persistertest"
+)
+
+func TestMemoryPersisterConformance(t *testing.T) {
+	persistertest.Run(t, func(t *testing.T) ws.EnvelopePersister {
+		return ws.NewMemoryPersister()
+	})
+}
+
+func TestMemoryPersisterEvictsLeastRecentlyUsed(t *testing.T) {
+	p := ws.NewMemoryPersister(ws.WithMemoryCapacity(2))
+	clientA, clientB := ws.NewIdentity(), ws.NewIdentity()
+
+	first := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientA, Timestamp: time.Now()}
+	second := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientB, Timestamp: time.Now()}
+	third := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientB, Timestamp: time.Now()}
+
+	p.SaveEnvelope(first)
+	p.SaveEnvelope(second)
+	// Fetching first makes second the least recently used.
+	p.FetchUndelivered(clientA)
+	p.SaveEnvelope(third)
+
+	if p.Len() != 2 {
+		t.Fatalf("Expected capacity to hold 2 envelopes, got %d", p.Len())
+	}
+	if ok, _ := p.Exists(second.ID); ok {
+		t.Error("Expected the least recently used envelope to be evicted")
+	}
+	if ok, _ := p.Exists(first.ID); !ok {
+		t.Error("Expected the recently fetched envelope to be kept")
+	}
+}
+
+func TestMemoryPersisterConfirmUnknown(t *testing.T) {
+	p := ws.NewMemoryPersister()
+	if err := p.ConfirmDelivery(ws.NewIdentity(), ws.NewIdentity()); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope, got %v", err)
+	}
+
+	e := ws.Envelope{ID: ws.NewIdentity(), ClientID: ws.NewIdentity()}
+	p.SaveEnvelope(e)
+	if err := p.ConfirmDelivery(e.ID, ws.NewIdentity()); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected confirming another client's envelope to fail, got %v", err)
+	}
+}
+
+func TestMemoryPersisterWithHandler(t *testing.T) {
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	envelope, _ := ws.NewEnvelope(clientID, "notification", map[string]string{"text": "offline"})
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	sendAck(conn, readEnvelopeID(t, conn))
+	if !waitFor(t, 2*time.Second, func() bool {
+		pending, _ := persister.FetchUndelivered(clientID)
+		return len(pending) == 0
+	}) {
+		t.Error("Expected the replayed envelope to be confirmed")
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","payload":{}}`))
+	if !waitFor(t, 2*time.Second, func() bool { return persister.Len() == 2 }) {
+		t.Errorf("Expected the inbound envelope to be stored, got %d envelopes", persister.Len())
+	}
+}
+
+func TestAckStampsDeliveredWithHandlerClock(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(time.Hour)
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister, ws.WithClock(clock))
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	envelope, _ := ws.NewEnvelope(clientID, "notification", nil, ws.WithEnvelopeClock(clock))
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+	clock.Advance(time.Minute)
+
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	sendAck(conn, readEnvelopeID(t, conn))
+	if !waitFor(t, 2*time.Second, func() bool {
+		pending, _ := persister.FetchUndelivered(clientID)
+		return len(pending) == 0
+	}) {
+		t.Fatal("Expected the envelope to be confirmed")
+	}
+
+	// Purging what was delivered before the clock's time, rather than the
+	// wall clock's, finds the envelope only if Delivered came from the clock.
+	if n, _ := persister.PurgeDelivered(time.Unix(0, 0).Add(time.Hour + time.Minute)); n != 0 {
+		t.Errorf("Expected nothing delivered before the ack, purged %d", n)
+	}
+	if n, _ := persister.PurgeDelivered(time.Unix(0, 0).Add(2 * time.Hour)); n != 1 {
+		t.Errorf("Expected the envelope delivered at the clock's time, purged %d", n)
+	}
+}
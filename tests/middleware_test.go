@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+	errs  []error
+}
+
+func (l *callLog) add(call string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) addErr(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+func (l *callLog) snapshot() ([]string, []error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.calls...), append([]error(nil), l.errs...)
+}
+
+func tracing(calls *callLog, name string) ws.Middleware {
+	return func(next ws.MessageHandler) ws.MessageHandler {
+		return ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+			calls.add(name + ":before")
+			err := next.Handle(client, data)
+			calls.add(name + ":after")
+			return err
+		})
+	}
+}
+
+func capturing(calls *callLog) ws.Middleware {
+	return func(next ws.MessageHandler) ws.MessageHandler {
+		return ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+			err := next.Handle(client, data)
+			calls.addErr(err)
+			return err
+		})
+	}
+}
+
+func TestMiddlewareRunsInRegistrationOrder(t *testing.T) {
+	calls := &callLog{}
+	messages := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+		calls.add("handler")
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, nil)
+	handler.Use(tracing(calls, "first"), tracing(calls, "second"))
+	handler.Use(tracing(calls, "third"))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+
+	want := []string{"first:before", "second:before", "third:before", "handler", "third:after", "second:after", "first:after"}
+	if !waitFor(t, 2*time.Second, func() bool { got, _ := calls.snapshot(); return len(got) == len(want) }) {
+		t.Fatal("Expected the middleware chain to complete")
+	}
+	got, _ := calls.snapshot()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected order %v, got %v", want, got)
+	}
+}
+
+func TestMiddlewareCanShortCircuit(t *testing.T) {
+	messages := &mockMessageHandler{}
+	errBlocked := errors.New("blocked")
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, nil)
+	handler.Use(func(next ws.MessageHandler) ws.MessageHandler {
+		return ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+			if bytes.HasPrefix(data, []byte("blocked")) {
+				return errBlocked
+			}
+			return next.Handle(client, data)
+		})
+	})
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("blocked message"))
+	conn.WriteMessage(websocket.TextMessage, []byte("allowed message"))
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Fatal("Expected the allowed message to be handled")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := messages.received(); len(got) != 1 || string(got[0]) != "allowed message" {
+		t.Errorf("Expected only the allowed message, got %q", got)
+	}
+}
+
+func TestMiddlewareSeesEnvelopeFrames(t *testing.T) {
+	calls := &callLog{}
+	router := ws.NewRouter()
+	router.HandleFunc("chat", func(client *ws.Client, envelope ws.Envelope) error {
+		calls.add("route")
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{})
+	handler.Use(tracing(calls, "mw"))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","payload":{}}`))
+
+	if !waitFor(t, 2*time.Second, func() bool { got, _ := calls.snapshot(); return len(got) == 3 }) {
+		t.Fatal("Expected the routed envelope to pass through middleware")
+	}
+	got, _ := calls.snapshot()
+	if strings.Join(got, ",") != "mw:before,route,mw:after" {
+		t.Errorf("Expected middleware around the route, got %v", got)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	calls := &callLog{}
+	messages := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+		if string(data) == "panic" {
+			panic("boom")
+		}
+		calls.add(string(data))
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, nil)
+	handler.Use(capturing(calls), ws.Recovery())
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("panic"))
+	conn.WriteMessage(websocket.TextMessage, []byte("after"))
+
+	if !waitFor(t, 2*time.Second, func() bool { got, _ := calls.snapshot(); return len(got) == 1 }) {
+		t.Fatal("Expected the connection to survive the panic")
+	}
+	_, errs := calls.snapshot()
+	if len(errs) != 2 || !errors.Is(errs[0], ws.ErrHandlerPanic) || errs[1] != nil {
+		t.Errorf("Expected a recovered panic followed by success, got %v", errs)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf syncBuffer
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	handler.Use(ws.Logging(log.New(&buf, "", 0)))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+
+	if !waitFor(t, 2*time.Second, func() bool { return strings.Contains(buf.String(), "bytes=5") }) {
+		t.Errorf("Expected a log line for the frame, got %q", buf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
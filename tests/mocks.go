@@ -3,7 +3,10 @@ package tests
 import (
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/oduortoni/websocket/ws"
 )
 
@@ -20,6 +23,7 @@ func (m *mockSessionValidator) Validate(r *http.Request) (ws.SessionInfo, error)
 }
 
 type mockMessageHandler struct {
+	mu         sync.Mutex
 	shouldFail bool
 	messages   [][]byte
 }
@@ -28,26 +32,202 @@ func (m *mockMessageHandler) Handle(client *ws.Client, data []byte) error {
 	if m.shouldFail {
 		return errors.New("message handling failed")
 	}
-	m.messages = append(m.messages, data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, client.Retain(data))
 	return nil
 }
 
+func (m *mockMessageHandler) received() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.messages...)
+}
+
 type mockEnvelopePersister struct {
+	mu         sync.Mutex
 	shouldFail bool
 	envelopes  []ws.Envelope
+	confirmed  []ws.Identity
+	purged     int
 }
 
 func (m *mockEnvelopePersister) SaveEnvelope(e ws.Envelope) error {
 	if m.shouldFail {
 		return errors.New("save failed")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.envelopes = append(m.envelopes, e)
 	return nil
 }
 
+func (m *mockEnvelopePersister) Exists(id ws.Identity) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.envelopes {
+		if e.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (m *mockEnvelopePersister) ConfirmDelivery(envelopeID ws.Identity, clientID ws.Identity) error {
 	if m.shouldFail {
 		return errors.New("confirm delivery failed")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.confirmed = append(m.confirmed, envelopeID)
 	return nil
 }
+
+func (m *mockEnvelopePersister) FetchUndelivered(clientID ws.Identity) ([]ws.Envelope, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	confirmed := make(map[ws.Identity]bool, len(m.confirmed))
+	for _, id := range m.confirmed {
+		confirmed[id] = true
+	}
+
+	var pending []ws.Envelope
+	for _, e := range m.envelopes {
+		if e.ClientID == clientID && !e.Inbound && !confirmed[e.ID] {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (m *mockEnvelopePersister) PurgeExpired(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.envelopes[:0]
+	purged := 0
+	for _, e := range m.envelopes {
+		if e.Delivered == nil && e.ExpiresAt != nil && e.ExpiresAt.Before(before) {
+			purged++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	m.envelopes = kept
+	m.purged += purged
+	return purged, nil
+}
+
+func (m *mockEnvelopePersister) purgedCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purged
+}
+
+func (m *mockEnvelopePersister) saved() []ws.Envelope {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ws.Envelope(nil), m.envelopes...)
+}
+
+func (m *mockEnvelopePersister) confirmedIDs() []ws.Identity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ws.Identity(nil), m.confirmed...)
+}
+
+// headerSessionValidator takes the client identity from the X-Test-Client-ID
+// request header so a single server can host several distinct identities.
+type headerSessionValidator struct{}
+
+func (h *headerSessionValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	id, err := uuid.Parse(r.Header.Get("X-Test-Client-ID"))
+	if err != nil {
+		return ws.SessionInfo{}, nil
+	}
+	return ws.SessionInfo{ClientID: ws.Identity(id)}, nil
+}
+
+func identityHeader(id ws.Identity) http.Header {
+	header := http.Header{}
+	header.Set("X-Test-Client-ID", id.String())
+	return header
+}
+
+// fakeClock is a ws.Clock whose timers only fire when the test advances it.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	fn      func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) ws.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), fn: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and runs every timer that has come
+// due, in the calling goroutine.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, waiting []*fakeTimer
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.stopped = true
+			due = append(due, t)
+		default:
+			waiting = append(waiting, t)
+		}
+	}
+	c.timers = waiting
+	c.mu.Unlock()
+
+	for _, t := range due {
+		t.fn()
+	}
+}
+
+// pending reports how many timers are scheduled and not yet stopped.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestMultipleConnectionsPerIdentity(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	phone := dial(t, server)
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(clientID)) == 1 }) {
+		t.Fatal("Expected first connection to register")
+	}
+	laptop := dial(t, server)
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(clientID)) == 2 }) {
+		t.Fatal("Expected both connections to register")
+	}
+
+	if err := handler.SendTo(clientID, []byte("fan out")); err != nil {
+		t.Fatalf("Expected SendTo to succeed, got %v", err)
+	}
+	for name, conn := range map[string]*websocket.Conn{"phone": phone, "laptop": laptop} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "fan out" {
+			t.Errorf("Expected %s to receive the message, got %q (%v)", name, data, err)
+		}
+	}
+}
+
+func TestPartialDisconnectKeepsIdentityOnline(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	phone := dial(t, server)
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(clientID)) == 1 }) {
+		t.Fatal("Expected first connection to register")
+	}
+	first := handler.Connections(clientID)[0]
+	laptop := dial(t, server)
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(clientID)) == 2 }) {
+		t.Fatal("Expected both connections to register")
+	}
+
+	go func() {
+		for {
+			if _, _, err := phone.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := handler.DisconnectClient(first, websocket.CloseNormalClosure, "logged out"); err != nil {
+		t.Fatalf("Expected DisconnectClient to succeed, got %v", err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(clientID)) == 1 }) {
+		t.Fatalf("Expected one connection to remain, got %d", len(handler.Connections(clientID)))
+	}
+	if _, ok := handler.Get(clientID); !ok {
+		t.Error("Expected identity to stay online while a connection remains")
+	}
+
+	if err := handler.SendTo(clientID, []byte("still here")); err != nil {
+		t.Fatalf("Expected SendTo to reach the remaining connection, got %v", err)
+	}
+	laptop.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, data, err := laptop.ReadMessage(); err != nil || string(data) != "still here" {
+		t.Errorf("Expected remaining connection to receive the message, got %q (%v)", data, err)
+	}
+
+	laptop.Close()
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return !ok }) {
+		t.Error("Expected identity to go offline after its last connection closes")
+	}
+}
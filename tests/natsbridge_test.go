@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/oduortoni/websocket/bridge/natsbridge"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// runNATS starts an embedded NATS server with JetStream on port, or a free
+// port when it is -1.
+func runNATS(t *testing.T, port int) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connectNATS(t *testing.T, url string) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(url, nats.MaxReconnects(-1), nats.ReconnectWait(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newNATSBridge(t *testing.T, nc *nats.Conn, opts ...natsbridge.Option) *natsbridge.Bridge {
+	t.Helper()
+	bridge, err := natsbridge.New(nc, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	t.Cleanup(func() { bridge.Close() })
+	return bridge
+}
+
+func newNATSNode(t *testing.T, url string, opts ...natsbridge.Option) *bridgedNode {
+	t.Helper()
+	return newBridgedNode(t, newNATSBridge(t, connectNATS(t, url), opts...))
+}
+
+// sendWhenKnown retries SendTo until node has learned which node id is on.
+func sendWhenKnown(t *testing.T, node *bridgedNode, id ws.Identity, data []byte) {
+	t.Helper()
+	var err error
+	if !waitFor(t, 2*time.Second, func() bool {
+		err = node.handler.SendTo(id, data)
+		return err == nil
+	}) {
+		t.Fatalf("Expected SendTo to reach the other node, got %v", err)
+	}
+}
+
+func TestNATSBridgeSendToReachesOtherNode(t *testing.T) {
+	url := runNATS(t, -1).ClientURL()
+	a, b := newNATSNode(t, url), newNATSNode(t, url)
+
+	id := ws.NewIdentity()
+	if err := a.handler.SendTo(id, []byte(`{"type":"direct"}`)); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected for an identity no node has, got %v", err)
+	}
+
+	conn := b.connect(t, id)
+	sendWhenKnown(t, a, id, []byte(`{"type":"direct"}`))
+	receiveType(t, conn, "direct")
+
+	conn.Close(1000, "")
+	if !waitFor(t, 2*time.Second, func() bool {
+		return errors.Is(a.handler.SendTo(id, []byte(`{"type":"late"}`)), ws.ErrClientNotConnected)
+	}) {
+		t.Error("Expected the other node to announce the disconnect")
+	}
+}
+
+func TestNATSBridgeLearnsExistingPresence(t *testing.T) {
+	url := runNATS(t, -1).ClientURL()
+	a := newNATSNode(t, url)
+	id := ws.NewIdentity()
+	conn := a.connect(t, id)
+
+	// b joins after the client connected and learns of it from a's answer
+	// to its hello.
+	b := newNATSNode(t, url)
+	sendWhenKnown(t, b, id, []byte(`{"type":"direct"}`))
+	receiveType(t, conn, "direct")
+}
+
+func TestNATSBridgeRoomSubjects(t *testing.T) {
+	url := runNATS(t, -1).ClientURL()
+	subjects := natsbridge.WithSubjects(natsbridge.DefaultSubjects("tenant1"))
+	a, b := newNATSNode(t, url, subjects), newNATSNode(t, url, subjects)
+
+	watcher := connectNATS(t, url)
+	seen, _ := watcher.SubscribeSync("tenant1.room.lobby")
+	watcher.Flush()
+
+	memberID := ws.NewIdentity()
+	member := b.connect(t, memberID)
+	client, _ := b.handler.Get(memberID)
+	if err := b.handler.Join("lobby", client); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	local := a.connect(t, ws.NewIdentity())
+
+	a.handler.BroadcastToRoom("lobby", []byte(`{"type":"lobby.news"}`))
+	receiveType(t, member, "lobby.news")
+	if _, err := seen.NextMsg(2 * time.Second); err != nil {
+		t.Errorf("Expected the room broadcast on its configured subject, got %v", err)
+	}
+
+	a.handler.Broadcast([]byte(`{"type":"everyone"}`))
+	receiveType(t, member, "everyone")
+	receiveType(t, local, "everyone")
+	select {
+	case env := <-local.Receive():
+		t.Errorf("Expected no echoed broadcast, got %+v", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNATSBridgeReannouncesAfterReconnect(t *testing.T) {
+	s := runNATS(t, -1)
+	a := newNATSNode(t, s.ClientURL())
+	id := ws.NewIdentity()
+	conn := a.connect(t, id)
+
+	// b joins while a is cut off, so a never hears b's hello and only
+	// announces id again once it reconnects.
+	port := s.Addr().(*net.TCPAddr).Port
+	s.Shutdown()
+	restarted := runNATS(t, port)
+	b := newNATSNode(t, restarted.ClientURL())
+	sendWhenKnown(t, b, id, []byte(`{"type":"direct"}`))
+	receiveType(t, conn, "direct")
+}
+
+func TestNATSBridgeJetStreamInboxSurvivesDowntime(t *testing.T) {
+	url := runNATS(t, -1).ClientURL()
+	stream := natsbridge.WithJetStream("WS_INBOX", time.Minute)
+	a := newNATSNode(t, url, stream)
+
+	nodeB := connectNATS(t, url)
+	b := newBridgedNode(t, newNATSBridge(t, nodeB, stream, natsbridge.WithInstanceID("node-b")))
+	id := ws.NewIdentity()
+	conn := b.connect(t, id)
+	sendWhenKnown(t, a, id, []byte(`{"type":"before"}`))
+	receiveType(t, conn, "before")
+
+	// node-b goes down without saying goodbye; a keeps sending to its inbox.
+	nodeB.Close()
+	env, _ := ws.NewEnvelope(id, "during", nil)
+	if err := a.handler.SendEnvelope(env); err != nil {
+		t.Fatalf("Expected the envelope to be stored for node-b, got %v", err)
+	}
+
+	received := make(chan []byte, 2)
+	restarted := newNATSBridge(t, connectNATS(t, url), stream, natsbridge.WithInstanceID("node-b"))
+	if err := restarted.SubscribeDirect(func(data []byte) { received <- data }); err != nil {
+		t.Fatalf("Failed to consume the inbox: %v", err)
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-received:
+			if strings.Contains(string(data), env.ID.String()) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected the restarted node to receive the envelope sent while it was down")
+		}
+	}
+}
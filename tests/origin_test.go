@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// dialOrigin attempts an upgrade with the given Origin header, or none when
+// origin is empty, and returns the handshake response status.
+func dialOrigin(t *testing.T, handler *ws.WebsocketHandler, origin string) int {
+	t.Helper()
+	server := newTestServer(t, handler)
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(server), header)
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("Expected a handshake response, got %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{"exact host", "https://app.example.com", http.StatusSwitchingProtocols},
+		{"case insensitive", "https://APP.example.com", http.StatusSwitchingProtocols},
+		{"host with port", "http://localhost:3000", http.StatusSwitchingProtocols},
+		{"wrong port", "http://localhost:4000", http.StatusForbidden},
+		{"wildcard subdomain", "https://eu.tenants.example.org", http.StatusSwitchingProtocols},
+		{"nested wildcard subdomain", "https://a.b.tenants.example.org", http.StatusSwitchingProtocols},
+		{"wildcard apex", "https://tenants.example.org", http.StatusForbidden},
+		{"suffix lookalike", "https://eviltenants.example.org", http.StatusForbidden},
+		{"denied", "https://evil.test", http.StatusForbidden},
+		{"malformed", "://", http.StatusForbidden},
+		{"missing origin", "", http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+				ws.WithAllowedOrigins([]string{"app.example.com", "localhost:3000", "*.tenants.example.org"}),
+			)
+			if got := dialOrigin(t, handler, tt.origin); got != tt.want {
+				t.Errorf("Expected status %d for origin %q, got %d", tt.want, tt.origin, got)
+			}
+		})
+	}
+}
+
+func TestDefaultOriginCheckIsSameOrigin(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	header := http.Header{"Origin": {server.URL}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), header)
+	if err != nil {
+		t.Fatalf("Expected same-origin upgrade to succeed, got %v", err)
+	}
+	conn.Close()
+
+	if got := dialOrigin(t, handler, "https://other.test"); got != http.StatusForbidden {
+		t.Errorf("Expected cross-origin upgrade to be refused with 403, got %d", got)
+	}
+}
+
+func TestCheckOriginRejectionIsAudited(t *testing.T) {
+	var mu sync.Mutex
+	var audited []string
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithCheckOrigin(func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://trusted.test"
+		}),
+		ws.WithOnReject(func(r *http.Request, status int, err error) {
+			if status == http.StatusForbidden && errors.Is(err, ws.ErrOriginNotAllowed) {
+				mu.Lock()
+				defer mu.Unlock()
+				audited = append(audited, r.Header.Get("Origin"))
+			}
+		}),
+	)
+
+	if got := dialOrigin(t, handler, "https://trusted.test"); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected trusted origin to upgrade, got %d", got)
+	}
+	if got := dialOrigin(t, handler, "https://untrusted.test"); got != http.StatusForbidden {
+		t.Errorf("Expected untrusted origin to be refused with 403, got %d", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(audited) != 1 || audited[0] != "https://untrusted.test" {
+		t.Errorf("Expected one audited rejection for the untrusted origin, got %v", audited)
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func suffix(s string) ws.OutboundInterceptor {
+	return func(client *ws.Client, data []byte) ([]byte, error) {
+		return append(append([]byte(nil), data...), s...), nil
+	}
+}
+
+func readText(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected a frame, got %v", err)
+	}
+	return string(data)
+}
+
+func TestOutboundInterceptorsRunInOrder(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	handler.UseOutbound(suffix("-first"), suffix("-second"))
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	client.Send <- []byte("msg")
+	if got := readText(t, conn); got != "msg-first-second" {
+		t.Errorf("Expected interceptors applied in order, got %q", got)
+	}
+
+	client.SendJSON("queued")
+	if got := readText(t, conn); got != `"queued"-first-second` {
+		t.Errorf("Expected interceptors on queued frames, got %q", got)
+	}
+}
+
+func TestOutboundInterceptorSkipsClients(t *testing.T) {
+	redactGuests := func(client *ws.Client, data []byte) ([]byte, error) {
+		if !client.HasTag("guest") {
+			return data, nil
+		}
+		return bytes.ReplaceAll(data, []byte("secret"), []byte("******")), nil
+	}
+
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, nil)
+	handler.UseOutbound(redactGuests)
+	server := newTestServer(t, handler)
+
+	memberID, guestID := ws.NewIdentity(), ws.NewIdentity()
+	member, _ := dialWithResponse(t, server, identityHeader(memberID))
+	guest, _ := dialWithResponse(t, server, identityHeader(guestID))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 2 }) {
+		t.Fatal("Expected both clients to be registered")
+	}
+	guestClient, _ := handler.Get(guestID)
+	guestClient.AddTag("guest")
+
+	handler.Broadcast([]byte("the secret word"))
+
+	if got := readText(t, member); got != "the secret word" {
+		t.Errorf("Expected member to receive the frame unchanged, got %q", got)
+	}
+	if got := readText(t, guest); got != "the ****** word" {
+		t.Errorf("Expected guest to receive a redacted frame, got %q", got)
+	}
+}
+
+func TestOutboundInterceptorDropsOnError(t *testing.T) {
+	errRejected := errors.New("rejected")
+	var mu sync.Mutex
+	var dropped []string
+
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil,
+		ws.WithOnOutboundError(func(client *ws.Client, data []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, errRejected) {
+				dropped = append(dropped, string(data))
+			}
+		}))
+	handler.UseOutbound(func(client *ws.Client, data []byte) ([]byte, error) {
+		if bytes.Equal(data, []byte("drop me")) {
+			return nil, errRejected
+		}
+		return data, nil
+	})
+	handler.UseOutbound(suffix("!"))
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	client.Send <- []byte("drop me")
+	client.Send <- []byte("keep me")
+
+	if got := readText(t, conn); got != "keep me!" {
+		t.Errorf("Expected only the kept frame, got %q", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dropped) != 1 || dropped[0] != "drop me" {
+		t.Errorf("Expected the error hook to report the dropped frame, got %q", dropped)
+	}
+}
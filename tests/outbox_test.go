@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func readEnvelopeTypes(conn *websocket.Conn) []string {
+	var types []string
+	for {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return types
+		}
+		var frame struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(data, &frame)
+		types = append(types, frame.Type)
+	}
+}
+
+func TestSendEnvelopeToOfflineClientIsReplayed(t *testing.T) {
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "reminder", Timestamp: time.Now()}
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected offline SendEnvelope to be persisted, got %v", err)
+	}
+	if saved := persister.saved(); len(saved) != 1 || saved[0].ExpiresAt != nil {
+		t.Fatalf("Expected one unlimited-TTL envelope in the outbox, got %+v", saved)
+	}
+
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "reminder" {
+		t.Errorf("Expected the reminder to be replayed on connect, got %v", types)
+	}
+}
+
+func TestExpiredEnvelopesAreSkippedOnReplay(t *testing.T) {
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithDefaultEnvelopeTTL(50*time.Millisecond),
+	)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	longLived := time.Now().Add(time.Hour)
+	handler.SendEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "stale"})
+	handler.SendEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "fresh", ExpiresAt: &longLived})
+
+	saved := persister.saved()
+	if len(saved) != 2 || saved[0].ExpiresAt == nil {
+		t.Fatalf("Expected the default TTL to be applied, got %+v", saved)
+	}
+	if !saved[1].ExpiresAt.Equal(longLived) {
+		t.Error("Expected an explicit expiry to override the default TTL")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "fresh" {
+		t.Errorf("Expected only the fresh envelope to be replayed, got %v", types)
+	}
+	if persister.purgedCount() != 1 {
+		t.Errorf("Expected the expired envelope to be purged, purged %d", persister.purgedCount())
+	}
+}
+
+func TestAckedEnvelopesAreNotReplayed(t *testing.T) {
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "reminder"}
+	handler.SendEnvelope(envelope)
+
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(conn); len(types) != 1 {
+		t.Fatalf("Expected the envelope to be replayed, got %v", types)
+	}
+	sendAck(conn, envelope.ID.String())
+	if !waitFor(t, 2*time.Second, func() bool { return len(persister.confirmedIDs()) == 1 }) {
+		t.Fatal("Expected the ack to confirm delivery")
+	}
+	conn.Close()
+
+	again, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(again); len(types) != 0 {
+		t.Errorf("Expected no replay after ack, got %v", types)
+	}
+}
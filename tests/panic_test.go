@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// panickingHandler panics on the magic payload and records everything else.
+type panickingHandler struct {
+	mockMessageHandler
+}
+
+func (p *panickingHandler) Handle(client *ws.Client, data []byte) error {
+	if bytes.Equal(data, []byte("boom")) {
+		explode()
+	}
+	return p.mockMessageHandler.Handle(client, data)
+}
+
+func explode() {
+	panic("kaboom")
+}
+
+type panicRecorder struct {
+	mu   sync.Mutex
+	msgs [][]byte
+	errs []error
+}
+
+func (r *panicRecorder) record(action ws.ErrorAction) ws.Option {
+	return ws.WithErrorHandler(func(client *ws.Client, msg []byte, err error) ws.ErrorAction {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.msgs = append(r.msgs, client.Retain(msg))
+		r.errs = append(r.errs, err)
+		return action
+	})
+}
+
+func (r *panicRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	if !waitFor(t, 2*time.Second, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.errs) >= n
+	}) {
+		t.Fatalf("Expected %d recovered panics", n)
+	}
+}
+
+func TestHandlerPanicIsRecovered(t *testing.T) {
+	messages := &panickingHandler{}
+	recorder := &panicRecorder{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		recorder.record(ws.ErrorIgnore))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("boom"))
+	conn.WriteMessage(websocket.TextMessage, []byte("after"))
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Fatal("Expected the connection to survive the panic and handle the next message")
+	}
+
+	recorder.wait(t, 1)
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if string(recorder.msgs[0]) != "boom" {
+		t.Errorf("Expected the message that caused the panic, got %q", recorder.msgs[0])
+	}
+	var panicked *ws.PanicError
+	if !errors.As(recorder.errs[0], &panicked) || !errors.Is(recorder.errs[0], ws.ErrHandlerPanic) {
+		t.Fatalf("Expected a *PanicError matching ErrHandlerPanic, got %v", recorder.errs[0])
+	}
+	if panicked.Value != "kaboom" {
+		t.Errorf("Expected the panic value, got %v", panicked.Value)
+	}
+	if !bytes.Contains(panicked.Stack, []byte("tests.explode")) {
+		t.Errorf("Expected the stack to show where the panic happened, got:\n%s", panicked.Stack)
+	}
+}
+
+func TestPanicActionClose(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &panickingHandler{}, &mockEnvelopePersister{},
+		ws.WithPanicAction(ws.ErrorClose))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("boom"))
+	expectClose(t, conn, websocket.CloseInternalServerErr)
+}
+
+func TestHookPanicsAreRecovered(t *testing.T) {
+	recorder := &panicRecorder{}
+	messages := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		recorder.record(ws.ErrorIgnore),
+		ws.WithOnConnect(func(*ws.Client, ws.SessionInfo) { panic("connect hook") }),
+		ws.WithOnDisconnect(func(*ws.Client, error) { panic("disconnect hook") }),
+	)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 1 }) {
+		t.Fatal("Expected the connection to be served despite the connect hook panic")
+	}
+	conn.Close()
+	recorder.wait(t, 2)
+
+	// The handler keeps serving new connections.
+	dial(t, server)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for i, want := range []string{"connect hook", "disconnect hook"} {
+		var panicked *ws.PanicError
+		if !errors.As(recorder.errs[i], &panicked) || panicked.Value != want {
+			t.Errorf("Expected the %s panic, got %v", want, recorder.errs[i])
+		}
+		if recorder.msgs[i] != nil {
+			t.Errorf("Expected no message for hook panics, got %q", recorder.msgs[i])
+		}
+	}
+}
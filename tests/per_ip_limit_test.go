@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func dialFrom(server string, ip string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	header.Set("X-Real-IP", ip)
+	return websocket.DefaultDialer.Dial(server, header)
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	const limit = 3
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithMaxConnectionsPerIP(limit),
+		ws.WithRealIPHeader("X-Real-IP"),
+	)
+	server := newTestServer(t, handler)
+
+	for i := 0; i < limit; i++ {
+		conn, _, err := dialFrom(wsURL(server), "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Expected connection %d to succeed, got %v", i, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+
+	_, resp, err := dialFrom(wsURL(server), "10.0.0.1")
+	if err == nil {
+		t.Fatal("Expected connection beyond the per-IP limit to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %v", resp)
+	}
+
+	conn, _, err := dialFrom(wsURL(server), "10.0.0.2")
+	if err != nil {
+		t.Fatalf("Expected a different address to connect, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestPerIPCountReleasedOnReset(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithMaxConnectionsPerIP(1),
+	)
+	server := newTestServer(t, handler)
+
+	conn := dial(t, server)
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil); err == nil {
+		t.Fatal("Expected second connection from the same address to be refused")
+	}
+
+	tcp := conn.UnderlyingConn().(*net.TCPConn)
+	tcp.SetLinger(0)
+	tcp.Close()
+
+	reconnected := waitFor(t, 2*time.Second, func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+	if !reconnected {
+		t.Error("Expected the per-IP slot to be released after a reset")
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var errSaveUnavailable = errors.New("save unavailable")
+
+// flakyPersister fails the first failures saves and then succeeds. A
+// negative failures fails every save.
+type flakyPersister struct {
+	mockEnvelopePersister
+
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (p *flakyPersister) SaveEnvelope(e ws.Envelope) error {
+	p.mu.Lock()
+	p.attempts++
+	fail := p.failures < 0 || p.attempts <= p.failures
+	p.mu.Unlock()
+	if fail {
+		return errSaveUnavailable
+	}
+	return p.mockEnvelopePersister.SaveEnvelope(e)
+}
+
+func (p *flakyPersister) saveAttempts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts
+}
+
+var retryPolicy = ws.PersistRetryPolicy{
+	InitialInterval: time.Second,
+	MaxInterval:     4 * time.Second,
+	MaxRetries:      3,
+}
+
+func TestPersistRetrySucceedsAfterFailures(t *testing.T) {
+	clock := newFakeClock()
+	persister := &flakyPersister{failures: 2}
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister,
+		ws.WithClock(clock),
+		ws.WithPersistRetry(retryPolicy),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	id := ws.NewIdentity()
+	writeFrame(t, conn, `{"id":"`+id.String()+`","type":"chat","payload":{}}`)
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected a retry to be scheduled after the first failure")
+	}
+	clock.Advance(time.Second)
+	if persister.saveAttempts() != 2 || clock.pending() != 1 {
+		t.Fatalf("Expected a second attempt and another retry, got %d attempts and %d timers", persister.saveAttempts(), clock.pending())
+	}
+	if len(messageHandler.received()) != 0 {
+		t.Fatal("Expected message handler not to run before the envelope is saved")
+	}
+	clock.Advance(2 * time.Second)
+
+	if got := readAckID(t, conn); got != id.String() {
+		t.Errorf("Expected ack for %s, got %q", id, got)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 1 }) {
+		t.Fatal("Expected message to be handled once saved")
+	}
+	if saved := persister.saved(); len(saved) != 1 || saved[0].ID != id {
+		t.Errorf("Expected envelope %s to be saved, got %+v", id, saved)
+	}
+}
+
+func TestPersistRetryDeadLetters(t *testing.T) {
+	clock := newFakeClock()
+	persister := &flakyPersister{failures: -1}
+	messageHandler := &mockMessageHandler{}
+
+	var mu sync.Mutex
+	var dead []ws.Envelope
+	var deadErr error
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister,
+		ws.WithClock(clock),
+		ws.WithPersistRetry(retryPolicy),
+		ws.WithOnPersistFailure(func(envelope ws.Envelope, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dead = append(dead, envelope)
+			deadErr = err
+		}),
+	)
+	conn := dial(t, newTestServer(t, handler))
+	writeFrame(t, conn, `{"type":"chat","payload":{"text":"hi"}}`)
+
+	for i := 0; i < retryPolicy.MaxRetries; i++ {
+		if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+			t.Fatalf("Expected retry %d to be scheduled", i+1)
+		}
+		clock.Advance(retryPolicy.MaxInterval)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) != 1 || dead[0].Type != "chat" || string(dead[0].Payload) != `{"text":"hi"}` {
+		t.Fatalf("Expected the chat envelope to be dead lettered, got %+v", dead)
+	}
+	if !errors.Is(deadErr, errSaveUnavailable) {
+		t.Errorf("Expected the last save error, got %v", deadErr)
+	}
+	if got := persister.saveAttempts(); got != retryPolicy.MaxRetries+1 {
+		t.Errorf("Expected %d save attempts, got %d", retryPolicy.MaxRetries+1, got)
+	}
+	if clock.pending() != 0 {
+		t.Errorf("Expected no retries after giving up, got %d", clock.pending())
+	}
+	if len(messageHandler.received()) != 0 {
+		t.Error("Expected a dead-lettered message not to be handled")
+	}
+}
+
+func TestPersistRetryRejectsWithoutHook(t *testing.T) {
+	clock := newFakeClock()
+	persister := &flakyPersister{failures: -1}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithClock(clock),
+		ws.WithPersistRetry(ws.PersistRetryPolicy{InitialInterval: time.Second, MaxRetries: 1}),
+	)
+	conn := dial(t, newTestServer(t, handler))
+	writeFrame(t, conn, `{"type":"chat","payload":{}}`)
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected a retry to be scheduled")
+	}
+	clock.Advance(time.Second)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected an error reply, got %v", err)
+	}
+	var reply struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil || reply.Type != ws.ErrorMessageType || reply.Code != "persist_failed" {
+		t.Errorf("Expected persist_failed error, got %q (%v)", data, err)
+	}
+}
+
+func TestPersistRetryDoesNotBlockReadLoop(t *testing.T) {
+	clock := newFakeClock()
+	persister := &flakyPersister{failures: 1}
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messageHandler, persister,
+		ws.WithClock(clock),
+		ws.WithPersistRetry(retryPolicy),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	writeFrame(t, conn, `{"type":"first","payload":{}}`)
+	writeFrame(t, conn, "plain text")
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 1 }) {
+		t.Fatal("Expected later frames to be handled while a save is being retried")
+	}
+	clock.Advance(time.Second)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messageHandler.received()) == 2 }) {
+		t.Fatal("Expected the retried message to be handled once saved")
+	}
+}
+
+func writeFrame(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestBroadcastPreparedReachesEveryClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, nil)
+	server := newTestServer(t, handler)
+
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, _ := dialWithResponse(t, server, identityHeader(ws.NewIdentity()))
+		conns = append(conns, conn)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 3 }) {
+		t.Fatal("Expected all clients to be registered")
+	}
+
+	pm, err := handler.NewPreparedMessage(websocket.BinaryMessage, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("Expected NewPreparedMessage to succeed, got %v", err)
+	}
+	if result := handler.BroadcastPrepared(pm); result.Delivered != 3 || result.Dropped != 0 {
+		t.Errorf("Expected 3 deliveries, got %+v", result)
+	}
+
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Client %d failed to read: %v", i, err)
+		}
+		if messageType != websocket.BinaryMessage || string(data) != "\x01\x02\x03" {
+			t.Errorf("Client %d received type %d %v", i, messageType, data)
+		}
+	}
+}
+
+func TestPreparedAndRegularMessagesKeepOrder(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	pm, _ := handler.NewPreparedMessage(websocket.TextMessage, []byte("prepared"))
+	for i := 0; i < 10; i++ {
+		client.SendJSON(i)
+		handler.BroadcastPrepared(pm)
+	}
+
+	for i := 0; i < 10; i++ {
+		if got, want := readText(t, conn), string(rune('0'+i)); got != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+		if got := readText(t, conn); got != "prepared" {
+			t.Fatalf("Expected prepared message, got %q", got)
+		}
+	}
+}
+
+func TestPreparedMessagePassesThroughInterceptors(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	handler.UseOutbound(suffix("!"))
+	conn := dial(t, newTestServer(t, handler))
+	registeredClient(t, handler)
+
+	pm, _ := handler.NewPreparedMessage(websocket.TextMessage, []byte("prepared"))
+	handler.BroadcastPrepared(pm)
+
+	if got := readText(t, conn); got != "prepared!" {
+		t.Errorf("Expected the interceptor to rewrite the frame, got %q", got)
+	}
+}
+
+const benchmarkClients = 5000
+
+// broadcastBench connects benchmarkClients clients and returns a function
+// that blocks until every client has read n more frames.
+func broadcastBench(b *testing.B) (*ws.WebsocketHandler, func(n int)) {
+	b.Helper()
+	if testing.Short() {
+		b.Skip("skipping broadcast benchmark in short mode")
+	}
+
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, nil)
+	server := newTestServer(b, handler)
+
+	var received atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < benchmarkClients; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), identityHeader(ws.NewIdentity()))
+		if err != nil {
+			b.Fatalf("Failed to connect client %d: %v", i, err)
+		}
+		b.Cleanup(func() { conn.Close() })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				received.Add(1)
+			}
+		}()
+	}
+	for handler.Len() < benchmarkClients {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var target int64
+	wait := func(n int) {
+		target += int64(n * benchmarkClients)
+		for received.Load() < target {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return handler, wait
+}
+
+var benchmarkPayload = make([]byte, 4096)
+
+func BenchmarkBroadcast(b *testing.B) {
+	handler, wait := broadcastBench(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.Broadcast(benchmarkPayload)
+		wait(1)
+	}
+}
+
+func BenchmarkBroadcastPrepared(b *testing.B) {
+	handler, wait := broadcastBench(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pm, _ := handler.NewPreparedMessage(websocket.BinaryMessage, benchmarkPayload)
+		handler.BroadcastPrepared(pm)
+		wait(1)
+	}
+}
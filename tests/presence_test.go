@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type sequentialSessionValidator struct {
+	ids  []ws.Identity
+	next atomic.Int32
+}
+
+func (s *sequentialSessionValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	id := s.ids[int(s.next.Add(1)-1)%len(s.ids)]
+	return ws.SessionInfo{
+		ClientID: id,
+		Metadata: map[string]string{"name": id.String()[:8]},
+	}, nil
+}
+
+func TestPresenceListsConnections(t *testing.T) {
+	ids := []ws.Identity{ws.NewIdentity(), ws.NewIdentity(), ws.NewIdentity()}
+	validator := &sequentialSessionValidator{ids: ids}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	before := time.Now()
+	conns := make([]*websocket.Conn, len(ids))
+	for i := range ids {
+		conns[i] = dial(t, server)
+		id := ids[i]
+		if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(id) }) {
+			t.Fatalf("Expected client %d to be online", i)
+		}
+	}
+
+	presence := handler.Presence()
+	if len(presence) != len(ids) {
+		t.Fatalf("Expected %d presence entries, got %d", len(ids), len(presence))
+	}
+	for _, info := range presence {
+		if info.Connected.Before(before) {
+			t.Errorf("Expected connect time after %v, got %v", before, info.Connected)
+		}
+		if info.RemoteAddr == "" {
+			t.Error("Expected remote address to be recorded")
+		}
+		if info.Metadata["name"] != info.Identity.String()[:8] {
+			t.Errorf("Expected session metadata to be captured, got %v", info.Metadata)
+		}
+	}
+
+	conns[0].Close()
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Presence()) == len(ids)-1 }) {
+		t.Fatalf("Expected presence to shrink, got %d entries", len(handler.Presence()))
+	}
+	if handler.IsOnline(ids[0]) {
+		t.Error("Expected disconnected identity to be offline")
+	}
+	for _, info := range handler.Presence() {
+		if info.Identity == ids[0] {
+			t.Error("Expected disconnected identity to be absent from presence")
+		}
+	}
+}
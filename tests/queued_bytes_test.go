@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// pluggedClients connects n clients over pipes that are never read, and
+// sends each a frame that wedges its write pump, so everything sent after
+// it stays queued.
+func pluggedClients(t *testing.T, n int, opts ...ws.Option) (*ws.WebsocketHandler, []*ws.Client) {
+	t.Helper()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, opts...)
+	server := wstest.NewPipeServer(t, handler)
+	for i := 0; i < n; i++ {
+		server.Connect(t)
+	}
+
+	var clients []*ws.Client
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == n }) {
+		t.Fatalf("Expected %d clients to be registered", n)
+	}
+	handler.Range(func(c *ws.Client) bool { clients = append(clients, c); return true })
+	for _, client := range clients {
+		client.SendBinary([]byte("plug"))
+		if !waitFor(t, 2*time.Second, func() bool { return client.QueuedBytes() == 0 }) {
+			t.Fatal("Expected the write pump to take the plug")
+		}
+	}
+	return handler, clients
+}
+
+func payload(size int) []byte {
+	return bytes.Repeat([]byte("x"), size)
+}
+
+func TestMaxQueuedBytesPerClientDropsNewest(t *testing.T) {
+	handler, clients := pluggedClients(t, 1, ws.WithMaxQueuedBytesPerClient(1000))
+	client := clients[0]
+
+	for i := 0; i < 2; i++ {
+		if err := client.SendBinary(payload(400)); err != nil {
+			t.Fatalf("Expected 400 bytes to fit, got %v", err)
+		}
+	}
+	if err := client.SendBinary(payload(400)); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Fatalf("Expected a send past 1000 bytes to fail with ErrSendQueueFull, got %v", err)
+	}
+	if err := client.SendBinary(payload(200)); err != nil {
+		t.Fatalf("Expected a send reaching exactly 1000 bytes to fit, got %v", err)
+	}
+
+	if client.QueuedBytes() != 1000 || client.Dropped() != 1 {
+		t.Errorf("Expected 1000 bytes queued and one drop, got %d and %d", client.QueuedBytes(), client.Dropped())
+	}
+	if stats := handler.Stats(); stats.QueuedBytes != 1000 {
+		t.Errorf("Expected the handler to count 1000 queued bytes, got %d", stats.QueuedBytes)
+	}
+}
+
+func TestMaxQueuedBytesPerClientDropsOldest(t *testing.T) {
+	handler, clients := pluggedClients(t, 1,
+		ws.WithMaxQueuedBytesPerClient(1000),
+		ws.WithSlowConsumerPolicy(ws.SlowConsumerDropOldest),
+	)
+	client := clients[0]
+
+	client.SendBinary(payload(400))
+	client.SendBinary(payload(400))
+	if err := client.SendBinary(payload(500)); err != nil {
+		t.Fatalf("Expected the oldest frame to make room, got %v", err)
+	}
+	if client.QueuedBytes() != 900 || client.Dropped() != 1 {
+		t.Errorf("Expected 900 bytes queued after one drop, got %d and %d", client.QueuedBytes(), client.Dropped())
+	}
+
+	if err := client.SendBinary(payload(1001)); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Fatalf("Expected a frame over the cap to be refused, got %v", err)
+	}
+	if client.QueuedBytes() != 900 || handler.Stats().QueuedBytes != 900 {
+		t.Errorf("Expected a frame that can never fit not to evict others, got %d queued", client.QueuedBytes())
+	}
+}
+
+func TestMaxQueuedBytesPerClientCloses(t *testing.T) {
+	_, clients := pluggedClients(t, 1,
+		ws.WithMaxQueuedBytesPerClient(1000),
+		ws.WithSlowConsumerPolicy(ws.SlowConsumerClose),
+	)
+	client := clients[0]
+
+	client.SendBinary(payload(1000))
+	if err := client.SendBinary(payload(1)); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+
+	done := make(chan *ws.DisconnectReason)
+	go func() { done <- client.Wait() }()
+	select {
+	case reason := <-done:
+		if reason.Kind != ws.DisconnectServerClose || reason.Code != websocket.ClosePolicyViolation {
+			t.Errorf("Expected a slow-consumer close, got %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to be disconnected")
+	}
+}
+
+func TestMaxQueuedBytesTotalShedsBroadcasts(t *testing.T) {
+	handler, clients := pluggedClients(t, 2, ws.WithMaxQueuedBytesTotal(1000))
+
+	if result := handler.Broadcast(payload(300)); result.Delivered != 2 {
+		t.Fatalf("Expected the first broadcast to reach both clients, got %+v", result)
+	}
+	if result := handler.Broadcast(payload(300)); result.Delivered != 1 || result.Dropped != 1 {
+		t.Fatalf("Expected the second broadcast to be shed for one client, got %+v", result)
+	}
+
+	// Direct sends are never shed, but count toward the total.
+	if err := clients[0].SendBinary(payload(500)); err != nil {
+		t.Fatalf("Expected a direct send past the total cap to be queued, got %v", err)
+	}
+	if result := handler.Broadcast(payload(10)); result.Dropped != 2 {
+		t.Fatalf("Expected a broadcast over the total to be shed, got %+v", result)
+	}
+
+	stats := handler.Stats()
+	if stats.QueuedBytes != 1400 || stats.BroadcastsShed != 3 || stats.BroadcastBytesShed != 320 {
+		t.Errorf("Expected 1400 bytes queued and 3 deliveries of 320 bytes shed, got %d, %d and %d",
+			stats.QueuedBytes, stats.BroadcastsShed, stats.BroadcastBytesShed)
+	}
+	if dropped := clients[0].Dropped() + clients[1].Dropped(); dropped != 0 {
+		t.Errorf("Expected shed broadcasts not to count as slow-consumer drops, got %d", dropped)
+	}
+	if sum := clients[0].QueuedBytes() + clients[1].QueuedBytes(); sum != stats.QueuedBytes {
+		t.Errorf("Expected the clients' queued bytes to add up to %d, got %d", stats.QueuedBytes, sum)
+	}
+
+	for _, client := range clients {
+		client.Conn.Close()
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected the clients to disconnect")
+	}
+	if stats := handler.Stats(); stats.QueuedBytes != 0 {
+		t.Errorf("Expected frames left queued at close to be released, got %d bytes", stats.QueuedBytes)
+	}
+}
+
+func TestQueuedBytesCountFramesWrittenToSend(t *testing.T) {
+	handler, clients := pluggedClients(t, 1, ws.WithMaxQueuedBytesPerClient(1000))
+	client := clients[0]
+
+	// Frames on Send count toward the cap but are never refused by it.
+	client.Send <- payload(600)
+	client.Send <- payload(600)
+	if !waitFor(t, 2*time.Second, func() bool { return client.QueuedBytes() == 1200 }) {
+		t.Fatalf("Expected 1200 bytes queued from Send, got %d", client.QueuedBytes())
+	}
+	if stats := handler.Stats(); stats.QueuedBytes != 1200 {
+		t.Errorf("Expected the handler to count 1200 queued bytes, got %d", stats.QueuedBytes)
+	}
+	if err := client.SendBinary(payload(1)); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Errorf("Expected bytes from Send to leave no room under the cap, got %v", err)
+	}
+	if client.Dropped() != 1 {
+		t.Errorf("Expected only the refused direct send to count as dropped, got %d", client.Dropped())
+	}
+
+	client.Conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected the client to disconnect")
+	}
+	if stats := handler.Stats(); stats.QueuedBytes != 0 {
+		t.Errorf("Expected frames from Send left queued at close to be released, got %d bytes", stats.QueuedBytes)
+	}
+}
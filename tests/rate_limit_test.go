@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type rateLimitFixture struct {
+	handler  *ws.WebsocketHandler
+	messages *mockMessageHandler
+	clock    *fakeClock
+	conn     *websocket.Conn
+	client   *ws.Client
+}
+
+func newRateLimitFixture(t *testing.T, opts ...ws.Option) *rateLimitFixture {
+	t.Helper()
+	f := &rateLimitFixture{messages: &mockMessageHandler{}, clock: newFakeClock()}
+	f.handler = ws.NewWebSocketHandler(&headerSessionValidator{}, f.messages, &mockEnvelopePersister{},
+		append([]ws.Option{ws.WithClock(f.clock)}, opts...)...)
+	clientID := ws.NewIdentity()
+	f.conn, _ = dialWithResponse(t, newTestServer(t, f.handler), identityHeader(clientID))
+	if !waitFor(t, 2*time.Second, func() bool { return f.handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+	f.client, _ = f.handler.Get(clientID)
+	return f
+}
+
+// send writes n plain frames and waits until the read loop has either
+// handled or throttled each of them.
+func (f *rateLimitFixture) send(t *testing.T, n int) {
+	t.Helper()
+	want := len(f.messages.received()) + int(f.client.Throttled()) + n
+	for i := 0; i < n; i++ {
+		writeFrame(t, f.conn, "ping")
+	}
+	if !waitFor(t, 2*time.Second, func() bool {
+		return len(f.messages.received())+int(f.client.Throttled()) == want
+	}) {
+		t.Fatalf("Expected %d frames to be processed", want)
+	}
+}
+
+func TestRateLimitAbsorbsBurst(t *testing.T) {
+	f := newRateLimitFixture(t, ws.WithRateLimit(1, 5))
+
+	f.send(t, 5)
+	if got := len(f.messages.received()); got != 5 {
+		t.Fatalf("Expected the burst of 5 to be handled, got %d", got)
+	}
+
+	f.send(t, 1)
+	if f.client.Throttled() != 1 || len(f.messages.received()) != 5 {
+		t.Fatalf("Expected the 6th frame to be throttled, got %d handled and %d throttled", len(f.messages.received()), f.client.Throttled())
+	}
+	if code := readErrorCode(t, f.conn); code != "rate_limited" {
+		t.Errorf("Expected a rate_limited error frame, got %q", code)
+	}
+
+	f.clock.Advance(time.Second)
+	f.send(t, 1)
+	if got := len(f.messages.received()); got != 6 {
+		t.Errorf("Expected a refilled token to admit one more frame, got %d handled", got)
+	}
+}
+
+func TestRateLimitEnforcesSustainedRate(t *testing.T) {
+	f := newRateLimitFixture(t, ws.WithRateLimit(2, 2))
+
+	// Spend the initial burst, then offer four frames a second for ten
+	// seconds: only the refill rate of two a second gets through.
+	f.send(t, 2)
+	for i := 0; i < 20; i++ {
+		f.clock.Advance(500 * time.Millisecond)
+		f.send(t, 2)
+	}
+
+	if got := len(f.messages.received()); got != 2+20 {
+		t.Errorf("Expected %d handled frames at the sustained rate, got %d", 2+20, got)
+	}
+	if got := f.client.Throttled(); got != 20 {
+		t.Errorf("Expected 20 throttled frames, got %d", got)
+	}
+}
+
+func TestRateLimitDelayPolicy(t *testing.T) {
+	f := newRateLimitFixture(t, ws.WithRateLimit(1, 1), ws.WithRateLimitPolicy(ws.RateLimitDelay))
+
+	writeFrame(t, f.conn, "first")
+	writeFrame(t, f.conn, "second")
+	if !waitFor(t, 2*time.Second, func() bool { return f.client.Throttled() == 1 && f.clock.pending() == 1 }) {
+		t.Fatal("Expected the second frame to wait for a token")
+	}
+	if got := len(f.messages.received()); got != 1 {
+		t.Fatalf("Expected only the first frame to be handled while the second waits, got %d", got)
+	}
+
+	f.clock.Advance(time.Second)
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.messages.received()) == 2 }) {
+		t.Fatal("Expected the delayed frame to be handled once a token is available")
+	}
+}
+
+func TestRateLimitClosePolicy(t *testing.T) {
+	var disconnectErr error
+	disconnected := make(chan struct{})
+	f := newRateLimitFixture(t,
+		ws.WithRateLimit(1, 1),
+		ws.WithRateLimitPolicy(ws.RateLimitClose),
+		ws.WithRateLimitCloseAfter(3),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			disconnectErr = err
+			close(disconnected)
+		}),
+	)
+
+	for i := 0; i < 4; i++ {
+		writeFrame(t, f.conn, "flood")
+	}
+	for i := 0; i < 2; i++ {
+		if code := readErrorCode(t, f.conn); code != "rate_limited" {
+			t.Fatalf("Expected rate_limited error frame %d, got %q", i+1, code)
+		}
+	}
+	f.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := f.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected close 1008 after sustained abuse, got %v", err)
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the disconnect hook to run")
+	}
+	if !errors.Is(disconnectErr, ws.ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", disconnectErr)
+	}
+	if got := len(f.messages.received()); got != 1 {
+		t.Errorf("Expected only the first frame to be handled, got %d", got)
+	}
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// frameRecorder records the frames it handles, as they read while being
+// handled and, through kept, as they read later.
+type frameRecorder struct {
+	retain  bool
+	block   string
+	unblock chan struct{}
+
+	mu      sync.Mutex
+	handled []string
+	kept    [][]byte
+}
+
+func (r *frameRecorder) Handle(client *ws.Client, data []byte) error {
+	if r.block != "" && strings.Contains(string(data), r.block) {
+		<-r.unblock
+	}
+	keep := data
+	if r.retain {
+		keep = client.Retain(data)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handled = append(r.handled, string(data))
+	r.kept = append(r.kept, keep)
+	return nil
+}
+
+func (r *frameRecorder) frames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.handled...)
+}
+
+func (r *frameRecorder) keptFrames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []string
+	for _, data := range r.kept {
+		kept = append(kept, string(data))
+	}
+	return kept
+}
+
+func TestReadBufferHeldWhilePersistRetries(t *testing.T) {
+	clock := newFakeClock()
+	messages := &frameRecorder{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &flakyPersister{failures: 1},
+		ws.WithClock(clock),
+		ws.WithPersistRetry(retryPolicy),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	id := ws.NewIdentity()
+	first := `{"id":"` + id.String() + `","type":"first","payload":{}}`
+	writeFrame(t, conn, first)
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected a retry to be scheduled")
+	}
+
+	// Later frames are read while the first waits for its retry, and must
+	// not be read into its buffer.
+	for i := 0; i < 10; i++ {
+		writeFrame(t, conn, fmt.Sprintf(`{"type":"later","payload":{"n":%d}}`, i))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == 10 }) {
+		t.Fatal("Expected the later frames to be handled")
+	}
+
+	clock.Advance(time.Second)
+	if got := readAckID(t, conn); got != id.String() {
+		t.Errorf("Expected ack for %s, got %q", id, got)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == 11 }) {
+		t.Fatal("Expected the first frame to be handled once saved")
+	}
+	if got := messages.frames()[10]; got != first {
+		t.Errorf("Expected the retried frame to read %s, got %s", first, got)
+	}
+}
+
+func TestReadBufferHeldByLateHandler(t *testing.T) {
+	messages := &frameRecorder{block: "first", unblock: make(chan struct{})}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithHandlerTimeout(20*time.Millisecond),
+	)
+	conn := dial(t, newTestServer(t, handler))
+
+	first := `{"type":"first","payload":{}}`
+	writeFrame(t, conn, first)
+	for i := 0; i < 10; i++ {
+		writeFrame(t, conn, fmt.Sprintf(`{"type":"later","payload":{"n":%d}}`, i))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == 10 }) {
+		t.Fatal("Expected the later frames to be handled after the first timed out")
+	}
+
+	close(messages.unblock)
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == 11 }) {
+		t.Fatal("Expected the late handler to finish")
+	}
+	if got := messages.frames()[10]; got != first {
+		t.Errorf("Expected the late handler to read %s, got %s", first, got)
+	}
+}
+
+func TestReadBufferReleasedWhenHandlerQueueOverflows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ws.SlowConsumerPolicy
+	}{
+		{name: "Refused", policy: ws.SlowConsumerDropNewest},
+		{name: "DropOldest", policy: ws.SlowConsumerDropOldest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var overflows atomic.Int64
+			messages := newBlockingHandler()
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+				ws.WithExecutionMode(ws.WorkerPool(1)),
+				ws.WithHandlerQueueSize(1),
+				ws.WithSlowConsumerPolicy(tt.policy),
+				ws.WithObserver(ws.Observer{OnError: func(_ *ws.Client, err error) {
+					if errors.Is(err, ws.ErrHandlerQueueFull) {
+						overflows.Add(1)
+					}
+				}}),
+			)
+			conn := dial(t, newTestServer(t, handler))
+
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"first"}`))
+			messages.expectStarted(t)
+			// Envelopes and raw frames overflow the queue in turn.
+			conn.WriteMessage(websocket.BinaryMessage, []byte("queued"))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"overflow"}`))
+			conn.WriteMessage(websocket.BinaryMessage, []byte("overflow"))
+			if !waitFor(t, 2*time.Second, func() bool { return overflows.Load() == 2 }) {
+				t.Fatalf("Expected the queue to overflow twice, got %d", overflows.Load())
+			}
+			if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().ReadBuffers == 2 }) {
+				t.Errorf("Expected only the running and queued frames to hold buffers, got %d", handler.Stats().ReadBuffers)
+			}
+
+			close(messages.release)
+			messages.expectStarted(t)
+			if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().ReadBuffers == 0 }) {
+				t.Errorf("Expected every read buffer released, %d held", handler.Stats().ReadBuffers)
+			}
+		})
+	}
+}
+
+func TestReadBufferKeptData(t *testing.T) {
+	tests := []struct {
+		name   string
+		retain bool
+		opts   []ws.Option
+	}{
+		{name: "Retain", retain: true},
+		{name: "PoolingDisabled", opts: []ws.Option{ws.WithReadBufferPooling(false)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := &frameRecorder{retain: tt.retain}
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{}, tt.opts...)
+			conn := dial(t, newTestServer(t, handler))
+
+			var sent []string
+			for i := 0; i < 20; i++ {
+				frame := fmt.Sprintf("frame-%d-%s", i, strings.Repeat("x", i*100))
+				sent = append(sent, frame)
+				conn.WriteMessage(websocket.BinaryMessage, []byte(frame))
+			}
+			if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == len(sent) }) {
+				t.Fatal("Expected every frame to be handled")
+			}
+			for i, got := range messages.keptFrames() {
+				if got != sent[i] {
+					t.Fatalf("Expected kept frame %d to read %.20s..., got %.20s...", i, sent[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestReadBufferLargeFrames(t *testing.T) {
+	messages := &frameRecorder{retain: true}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{})
+	conn := dial(t, newTestServer(t, handler))
+
+	sizes := []int{0, 1, 1024, 1025, 4096, 70000, 200000}
+	for _, size := range sizes {
+		conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte("y"), size))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.frames()) == len(sizes) }) {
+		t.Fatal("Expected every frame to be handled")
+	}
+	for i, got := range messages.keptFrames() {
+		if len(got) != sizes[i] || strings.Trim(got, "y") != "" {
+			t.Errorf("Expected frame %d to hold %d bytes, got %d", i, sizes[i], len(got))
+		}
+	}
+}
+
+func BenchmarkReadBuffers(b *testing.B) {
+	for _, pooled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("pooled=%t", pooled), func(b *testing.B) {
+			var handled atomic.Int64
+			done := make(chan struct{})
+			messages := ws.MessageHandlerFunc(func(client *ws.Client, data []byte) error {
+				if handled.Add(1) == int64(b.N) {
+					close(done)
+				}
+				return nil
+			})
+			handler := ws.NewWebSocketHandler(&mockSessionValidator{}, messages, &mockEnvelopePersister{},
+				ws.WithReadBufferPooling(pooled),
+			)
+			server := newTestServer(b, handler)
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+			if err != nil {
+				b.Fatalf("Failed to dial: %v", err)
+			}
+			defer conn.Close()
+
+			frame := bytes.Repeat([]byte("z"), 8<<10)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+					b.Fatalf("Failed to write frame: %v", err)
+				}
+			}
+			<-done
+		})
+	}
+}
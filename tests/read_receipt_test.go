@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// readFixture serves a handler with read receipts whose envelopes record
+// their sender in sentBy.
+type readFixture struct {
+	persister *ws.MemoryPersister
+	handler   *ws.WebsocketHandler
+	server    *httptest.Server
+	sentBy    sync.Map
+}
+
+func newReadFixture(t *testing.T) *readFixture {
+	t.Helper()
+	f := &readFixture{persister: ws.NewMemoryPersister()}
+	f.handler = ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, f.persister,
+		ws.WithReadReceipts(func(envelopeID, reader ws.Identity) ws.Identity {
+			sender, _ := f.sentBy.Load(envelopeID)
+			id, _ := sender.(ws.Identity)
+			return id
+		}),
+	)
+	f.server = newTestServer(t, f.handler)
+	return f
+}
+
+func (f *readFixture) connect(t *testing.T, id ws.Identity) *websocket.Conn {
+	t.Helper()
+	conn, _ := dialWithResponse(t, f.server, identityHeader(id))
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := f.handler.Get(id); return ok }) {
+		t.Fatal("Expected the client to be registered")
+	}
+	return conn
+}
+
+// send sends to an envelope recorded as coming from from, returning its ID.
+func (f *readFixture) send(t *testing.T, from, to ws.Identity) ws.Identity {
+	t.Helper()
+	envelope, _ := ws.NewEnvelope(to, "chat", "hi")
+	f.sentBy.Store(envelope.ID, from)
+	if err := f.handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	return envelope.ID
+}
+
+func (f *readFixture) readAt(clientID, id ws.Identity) *time.Time {
+	pending, _ := f.persister.FetchUndelivered(clientID)
+	for _, e := range pending {
+		if e.ID == id {
+			return e.ReadAt
+		}
+	}
+	return nil
+}
+
+func writeRead(conn *websocket.Conn, ref ws.Identity, ids ...ws.Identity) {
+	conn.WriteJSON(map[string]any{"type": ws.ReadMessageType, "id": ref.String(), "payload": map[string]any{"ids": ids}})
+}
+
+// readFrameOfType reads until a frame of msgType arrives.
+func readFrameOfType(t *testing.T, conn *websocket.Conn, msgType string) json.RawMessage {
+	t.Helper()
+	for {
+		data := readText(t, conn)
+		var frame struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(data), &frame)
+		if frame.Type == msgType {
+			return json.RawMessage(data)
+		}
+	}
+}
+
+func readReceipt(t *testing.T, conn *websocket.Conn) ws.ReadReceipt {
+	t.Helper()
+	var envelope struct {
+		Payload ws.ReadReceipt `json:"payload"`
+	}
+	if err := json.Unmarshal(readFrameOfType(t, conn, ws.ReceiptMessageType), &envelope); err != nil {
+		t.Fatalf("Expected a receipt, got %v", err)
+	}
+	return envelope.Payload
+}
+
+func TestReadMarksEnvelopeAndNotifiesSender(t *testing.T) {
+	f := newReadFixture(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, bobConn := f.connect(t, alice), f.connect(t, bob)
+	id := f.send(t, alice, bob)
+
+	ref := ws.NewIdentity()
+	writeRead(bobConn, ref, id)
+	var ack struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(readFrameOfType(t, bobConn, ws.AckMessageType), &ack)
+	if ack.ID != ref.String() {
+		t.Errorf("Expected the read frame acked, got %q", ack.ID)
+	}
+
+	receipt := readReceipt(t, aliceConn)
+	if receipt.Reader != bob || len(receipt.IDs) != 1 || receipt.IDs[0] != id || receipt.ReadAt.IsZero() {
+		t.Errorf("Expected a receipt for %s read by %s, got %+v", id, bob, receipt)
+	}
+	if readAt := f.readAt(bob, id); readAt == nil || !readAt.Equal(receipt.ReadAt) {
+		t.Errorf("Expected the read time persisted, got %v", readAt)
+	}
+}
+
+func TestBatchedReadSendsOneReceiptPerSender(t *testing.T) {
+	f := newReadFixture(t)
+	alice, bob, carol := ws.NewIdentity(), ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, bobConn := f.connect(t, alice), f.connect(t, bob)
+	first, second := f.send(t, alice, bob), f.send(t, alice, bob)
+	fromCarol := f.send(t, carol, bob)
+
+	writeRead(bobConn, ws.NewIdentity(), first, fromCarol, second)
+	readFrameOfType(t, bobConn, ws.AckMessageType)
+
+	receipt := readReceipt(t, aliceConn)
+	if len(receipt.IDs) != 2 || receipt.IDs[0] != first || receipt.IDs[1] != second {
+		t.Errorf("Expected one receipt for both of alice's envelopes, got %+v", receipt)
+	}
+	for _, id := range []ws.Identity{first, second, fromCarol} {
+		if f.readAt(bob, id) == nil {
+			t.Errorf("Expected %s marked read", id)
+		}
+	}
+
+	pending, _ := f.persister.FetchUndelivered(carol)
+	if len(pending) != 1 || pending[0].Type != ws.ReceiptMessageType {
+		t.Fatalf("Expected carol's receipt kept for her next connection, got %+v", pending)
+	}
+	var offline ws.ReadReceipt
+	pending[0].DecodePayload(&offline)
+	if len(offline.IDs) != 1 || offline.IDs[0] != fromCarol {
+		t.Errorf("Expected carol's receipt to list her envelope, got %+v", offline)
+	}
+}
+
+func TestReadRejectsOtherClientsEnvelopes(t *testing.T) {
+	f := newReadFixture(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, bobConn := f.connect(t, alice), f.connect(t, bob)
+	toAlice := f.send(t, bob, alice)
+	toBob := f.send(t, alice, bob)
+
+	writeRead(bobConn, ws.NewIdentity(), toAlice, toBob)
+	frame := readErrorFrame(t, bobConn)
+	if frame.Code != ws.ErrorCodeUnknownEnvelope || frame.Ref != toAlice.String() {
+		t.Errorf("Expected unknown_envelope for alice's envelope, got %+v", frame)
+	}
+	if f.readAt(alice, toAlice) != nil {
+		t.Error("Expected alice's envelope not marked read")
+	}
+	if receipt := readReceipt(t, aliceConn); len(receipt.IDs) != 1 || receipt.IDs[0] != toBob {
+		t.Errorf("Expected a receipt for bob's envelope only, got %+v", receipt)
+	}
+
+	writeRead(bobConn, ws.NewIdentity())
+	if frame := readErrorFrame(t, bobConn); frame.Code != ws.ErrorCodeInvalidRead {
+		t.Errorf("Expected invalid_read for a frame listing no IDs, got %+v", frame)
+	}
+}
+
+func TestClientConnMarkRead(t *testing.T) {
+	f := newReadFixture(t)
+	alice := ws.NewIdentity()
+	aliceConn := f.connect(t, alice)
+
+	bob, err := ws.Dial(context.Background(), wsURL(f.server), ws.WithDialHeader("X-Test-Client-ID", ws.NewIdentity().String()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer bob.Close(websocket.CloseNormalClosure, "")
+	first, second := f.send(t, alice, bob.ID), f.send(t, alice, bob.ID)
+
+	if err := bob.MarkRead(first, second); err != nil {
+		t.Fatalf("Failed to mark read: %v", err)
+	}
+	if receipt := readReceipt(t, aliceConn); len(receipt.IDs) != 2 || receipt.Reader != bob.ID {
+		t.Errorf("Expected one receipt for both envelopes, got %+v", receipt)
+	}
+}
@@ -0,0 +1,280 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+// restartableServer is a websocket server that can be killed and brought
+// back on the same address, each time with a fresh handler, like a process
+// restart.
+type restartableServer struct {
+	t        *testing.T
+	addr     string
+	messages func() ws.MessageHandler
+	server   *httptest.Server
+	handler  *ws.WebsocketHandler
+}
+
+func newRestartableServer(t *testing.T, messages func() ws.MessageHandler) *restartableServer {
+	s := &restartableServer{t: t, messages: messages}
+	s.start()
+	s.addr = s.server.Listener.Addr().String()
+	t.Cleanup(s.kill)
+	return s
+}
+
+func (s *restartableServer) url() string {
+	return "ws://" + s.server.Listener.Addr().String()
+}
+
+func (s *restartableServer) start() {
+	s.t.Helper()
+	s.handler = ws.NewWebSocketHandler(ws.AllowAllValidator(), s.messages(), ws.NopPersister())
+	s.server = httptest.NewUnstartedServer(s.handler)
+	if s.addr != "" {
+		listener, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			s.t.Fatalf("Failed to listen on %s again: %v", s.addr, err)
+		}
+		s.server.Listener.Close()
+		s.server.Listener = listener
+	}
+	s.server.Start()
+}
+
+func (s *restartableServer) kill() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.handler.Shutdown(ctx)
+	s.server.Close()
+}
+
+// typeRecorder records the types of the envelopes a server handles.
+type typeRecorder struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (r *typeRecorder) Handle(client *ws.Client, data []byte) error {
+	var frame struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &frame)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = append(r.types, frame.Type)
+	return nil
+}
+
+func (r *typeRecorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.types...)
+}
+
+// stateRecorder collects a ReconnectingClient's state changes.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ws.ConnState
+	errs   []error
+}
+
+func (r *stateRecorder) option() ws.ReconnectOption {
+	return ws.WithOnStateChange(func(state ws.ConnState, err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.states = append(r.states, state)
+		r.errs = append(r.errs, err)
+	})
+}
+
+func (r *stateRecorder) waitFor(t *testing.T, state ws.ConnState, n int) {
+	t.Helper()
+	if !waitFor(t, 3*time.Second, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		count := 0
+		for _, s := range r.states {
+			if s == state {
+				count++
+			}
+		}
+		return count >= n
+	}) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		t.Fatalf("Expected %s %d times, got %v", state, n, r.states)
+	}
+}
+
+// drainReceive discards what client receives, as a send-only worker would.
+func drainReceive(client *ws.ReconnectingClient) {
+	go func() {
+		for range client.Receive() {
+		}
+	}()
+}
+
+func fastReconnect() ws.ReconnectPolicy {
+	return ws.ReconnectPolicy{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond, Jitter: 0.5}
+}
+
+func TestReconnectingClientFlushesAfterRestart(t *testing.T) {
+	recorders := make(chan *typeRecorder, 2)
+	server := newRestartableServer(t, func() ws.MessageHandler {
+		r := &typeRecorder{}
+		recorders <- r
+		return r
+	})
+	first := <-recorders
+
+	states := &stateRecorder{}
+	reconnects := 0
+	client := ws.NewReconnectingClient(server.url(),
+		ws.WithReconnectPolicy(fastReconnect()),
+		states.option(),
+		ws.WithOnReconnect(func(conn *ws.ClientConn) error {
+			reconnects++
+			_, err := conn.Send("subscribe", nil)
+			return err
+		}),
+	)
+	t.Cleanup(func() { client.Close() })
+	drainReceive(client)
+
+	states.waitFor(t, ws.StateConnected, 1)
+	client.Send("before", nil)
+	if !waitFor(t, 2*time.Second, func() bool { return len(first.received()) == 1 }) {
+		t.Fatal("Expected the first server to receive the message")
+	}
+
+	server.kill()
+	states.waitFor(t, ws.StateReconnecting, 1)
+	for _, msgType := range []string{"during.1", "during.2", "during.3"} {
+		if _, err := client.Send(msgType, nil); err != nil {
+			t.Fatalf("Expected %s to be buffered, got %v", msgType, err)
+		}
+	}
+
+	server.start()
+	second := <-recorders
+	states.waitFor(t, ws.StateConnected, 2)
+
+	want := []string{"subscribe", "during.1", "during.2", "during.3"}
+	if !waitFor(t, 2*time.Second, func() bool { return len(second.received()) == len(want) }) {
+		t.Fatalf("Expected %v after the restart, got %v", want, second.received())
+	}
+	for i, got := range second.received() {
+		if got != want[i] {
+			t.Errorf("Expected %v in order, got %v", want, second.received())
+			break
+		}
+	}
+	if reconnects != 1 {
+		t.Errorf("Expected the reconnect hook to run once, got %d", reconnects)
+	}
+
+	client.Send("after", nil)
+	if !waitFor(t, 2*time.Second, func() bool { return len(second.received()) == len(want)+1 }) {
+		t.Error("Expected sends to go straight through once reconnected")
+	}
+}
+
+func TestReconnectingClientBufferBound(t *testing.T) {
+	server := newRestartableServer(t, func() ws.MessageHandler { return &typeRecorder{} })
+	states := &stateRecorder{}
+	client := ws.NewReconnectingClient(server.url(),
+		ws.WithReconnectPolicy(fastReconnect()),
+		ws.WithOutboundBuffer(2),
+		states.option(),
+	)
+	t.Cleanup(func() { client.Close() })
+	drainReceive(client)
+	states.waitFor(t, ws.StateConnected, 1)
+
+	server.kill()
+	states.waitFor(t, ws.StateReconnecting, 1)
+	client.Send("one", nil)
+	client.Send("two", nil)
+	if _, err := client.Send("three", nil); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Errorf("Expected ErrSendQueueFull once the buffer is full, got %v", err)
+	}
+}
+
+func TestReconnectingClientGivesUp(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ws.ReconnectPolicy
+	}{
+		{"max attempts", ws.ReconnectPolicy{InitialInterval: 10 * time.Millisecond, MaxAttempts: 3}},
+		{"max elapsed time", ws.ReconnectPolicy{InitialInterval: 10 * time.Millisecond, MaxElapsedTime: 100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRestartableServer(t, func() ws.MessageHandler { return &typeRecorder{} })
+			states := &stateRecorder{}
+			client := ws.NewReconnectingClient(server.url(), ws.WithReconnectPolicy(tt.policy), states.option())
+			t.Cleanup(func() { client.Close() })
+			states.waitFor(t, ws.StateConnected, 1)
+
+			server.kill()
+			states.waitFor(t, ws.StateGaveUp, 1)
+			select {
+			case <-client.Done():
+			case <-time.After(2 * time.Second):
+				t.Fatal("Expected Done to be closed")
+			}
+			if _, ok := <-client.Receive(); ok {
+				t.Error("Expected Receive to be closed")
+			}
+			if _, err := client.Send("late", nil); !errors.Is(err, ws.ErrClientNotConnected) {
+				t.Errorf("Expected ErrClientNotConnected after giving up, got %v", err)
+			}
+
+			states.mu.Lock()
+			defer states.mu.Unlock()
+			if last := states.errs[len(states.errs)-1]; last == nil {
+				t.Error("Expected the last dial error with gave_up")
+			}
+		})
+	}
+}
+
+func TestReconnectingClientReceivesAcrossConnections(t *testing.T) {
+	server := newRestartableServer(t, func() ws.MessageHandler { return &typeRecorder{} })
+	states := &stateRecorder{}
+	client := ws.NewReconnectingClient(server.url(), ws.WithReconnectPolicy(fastReconnect()), states.option())
+	t.Cleanup(func() { client.Close() })
+
+	for i := 1; i <= 2; i++ {
+		states.waitFor(t, ws.StateConnected, i)
+		c := registeredClient(t, server.handler)
+		c.SendJSON(map[string]any{"type": "tick", "id": ws.NewIdentity().String()})
+		select {
+		case env := <-client.Receive():
+			if env.Type != "tick" {
+				t.Errorf("Expected a tick, got %+v", env)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected a tick on connection %d", i)
+		}
+		if i == 1 {
+			server.kill()
+			server.start()
+		}
+	}
+
+	client.Close()
+	if client.State() != ws.StateClosed {
+		t.Errorf("Expected closed, got %s", client.State())
+	}
+}
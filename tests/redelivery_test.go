@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type redeliveryFixture struct {
+	handler *ws.WebsocketHandler
+	clock   *fakeClock
+
+	mu     sync.Mutex
+	failed []ws.Envelope
+	errs   []error
+}
+
+func newRedeliveryFixture(t *testing.T, policy ws.RedeliveryPolicy) (*redeliveryFixture, *websocket.Conn, ws.Identity) {
+	t.Helper()
+	f := &redeliveryFixture{clock: newFakeClock()}
+	f.handler = ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithClock(f.clock),
+		ws.WithRedelivery(policy),
+		ws.WithOnDeliveryFailed(func(envelope ws.Envelope, err error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.failed = append(f.failed, envelope)
+			f.errs = append(f.errs, err)
+		}),
+	)
+	server := newTestServer(t, f.handler)
+
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if !waitFor(t, 2*time.Second, func() bool { return f.handler.IsOnline(clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+	return f, conn, clientID
+}
+
+func (f *redeliveryFixture) failures() ([]ws.Envelope, []error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ws.Envelope(nil), f.failed...), append([]error(nil), f.errs...)
+}
+
+func readEnvelopeID(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected envelope frame, got %v", err)
+	}
+	var frame struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(data, &frame)
+	return frame.ID
+}
+
+// expectNoFrame must be the last read on conn: a timed-out read leaves the
+// connection unusable.
+func expectNoFrame(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("Expected no frame, got %s", data)
+	}
+}
+
+func TestRedeliveryBacksOffExponentially(t *testing.T) {
+	policy := ws.RedeliveryPolicy{InitialInterval: time.Second, MaxInterval: 3 * time.Second, MaxAttempts: 5}
+	f, conn, clientID := newRedeliveryFixture(t, policy)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	if err := f.handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+	if id := readEnvelopeID(t, conn); id != envelope.ID.String() {
+		t.Fatalf("Expected envelope %s, got %s", envelope.ID, id)
+	}
+
+	// Waits after each send: 1s, 2s, then capped at 3s.
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if !waitFor(t, 2*time.Second, func() bool { return f.clock.pending() == 1 }) {
+			t.Fatal("Expected a redelivery to be scheduled")
+		}
+		f.clock.Advance(wait - time.Millisecond)
+		if f.clock.pending() != 1 {
+			t.Fatalf("Expected no redelivery before %v", wait)
+		}
+
+		f.clock.Advance(time.Millisecond)
+		if id := readEnvelopeID(t, conn); id != envelope.ID.String() {
+			t.Fatalf("Expected redelivery of %s, got %s", envelope.ID, id)
+		}
+	}
+}
+
+func TestRedeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	policy := ws.RedeliveryPolicy{InitialInterval: time.Second, MaxAttempts: 2}
+	f, conn, clientID := newRedeliveryFixture(t, policy)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	f.handler.SendEnvelope(envelope)
+	readEnvelopeID(t, conn)
+
+	for i := 0; i < 2; i++ {
+		if !waitFor(t, 2*time.Second, func() bool { return f.clock.pending() == 1 }) {
+			t.Fatal("Expected a redelivery to be scheduled")
+		}
+		f.clock.Advance(time.Minute)
+	}
+	readEnvelopeID(t, conn)
+
+	failed, errs := f.failures()
+	if len(failed) != 1 {
+		t.Fatalf("Expected one delivery failure, got %d", len(failed))
+	}
+	if failed[0].ID != envelope.ID {
+		t.Errorf("Expected failure for %s, got %s", envelope.ID, failed[0].ID)
+	}
+	if !errors.Is(errs[0], ws.ErrDeliveryFailed) {
+		t.Errorf("Expected ErrDeliveryFailed, got %v", errs[0])
+	}
+	if f.clock.pending() != 0 {
+		t.Errorf("Expected no further redelivery, got %d pending", f.clock.pending())
+	}
+	expectNoFrame(t, conn)
+}
+
+func TestAckStopsRedelivery(t *testing.T) {
+	policy := ws.RedeliveryPolicy{InitialInterval: time.Second, MaxAttempts: 3}
+	f, conn, clientID := newRedeliveryFixture(t, policy)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	f.handler.SendEnvelope(envelope)
+	sendAck(conn, readEnvelopeID(t, conn))
+
+	if !waitFor(t, 2*time.Second, func() bool { return f.clock.pending() == 0 }) {
+		t.Fatal("Expected ack to cancel the scheduled redelivery")
+	}
+	f.clock.Advance(time.Minute)
+	expectNoFrame(t, conn)
+
+	if failed, _ := f.failures(); len(failed) != 0 {
+		t.Errorf("Expected no delivery failures, got %d", len(failed))
+	}
+}
+
+func TestDisconnectCancelsRedelivery(t *testing.T) {
+	policy := ws.RedeliveryPolicy{InitialInterval: time.Second, MaxAttempts: 1}
+	f, conn, clientID := newRedeliveryFixture(t, policy)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	f.handler.SendEnvelope(envelope)
+	readEnvelopeID(t, conn)
+	conn.Close()
+
+	if !waitFor(t, 2*time.Second, func() bool { return f.clock.pending() == 0 }) {
+		t.Fatal("Expected disconnect to cancel the scheduled redelivery")
+	}
+	f.clock.Advance(time.Minute)
+	if failed, _ := f.failures(); len(failed) != 0 {
+		t.Errorf("Expected no delivery failure after disconnect, got %d", len(failed))
+	}
+}
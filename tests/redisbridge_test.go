@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/oduortoni/websocket/bridge/redisbridge"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/redis/go-redis/v9"
+)
+
+// bridgedNode is one of several handlers sharing a Redis server, as the
+// instances of an app behind a load balancer would.
+type bridgedNode struct {
+	handler   *ws.WebsocketHandler
+	persister *mockEnvelopePersister
+	url       string
+}
+
+func newBridgedNodes(t *testing.T, n int) []*bridgedNode {
+	t.Helper()
+	server := miniredis.RunT(t)
+
+	nodes := make([]*bridgedNode, n)
+	for i := range nodes {
+		rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		bridge := redisbridge.New(rdb)
+		t.Cleanup(func() { bridge.Close() })
+		nodes[i] = newBridgedNode(t, bridge)
+	}
+	return nodes
+}
+
+func newBridgedNode(t *testing.T, bridge ws.Bridge) *bridgedNode {
+	t.Helper()
+	node := &bridgedNode{persister: &mockEnvelopePersister{}}
+	handler, err := ws.NewHandler(&headerSessionValidator{}, &mockMessageHandler{}, node.persister, ws.WithBridge(bridge))
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	node.handler = handler
+	node.url = wsURL(newTestServer(t, handler))
+	return node
+}
+
+// connect dials node as id and waits for it to be registered.
+func (n *bridgedNode) connect(t *testing.T, id ws.Identity) *ws.ClientConn {
+	t.Helper()
+	conn := dialClient(t, n.url, ws.WithDialHeader("X-Test-Client-ID", id.String()))
+	if !waitFor(t, 2*time.Second, func() bool { return n.handler.IsOnline(id) }) {
+		t.Fatal("Expected client to be registered")
+	}
+	return conn
+}
+
+func TestBridgeSendToReachesOtherNode(t *testing.T) {
+	nodes := newBridgedNodes(t, 2)
+	id := ws.NewIdentity()
+	conn := nodes[1].connect(t, id)
+
+	if err := nodes[0].handler.SendTo(id, []byte(`{"type":"direct"}`)); err != nil {
+		t.Fatalf("Expected SendTo to forward to the other node, got %v", err)
+	}
+	receiveType(t, conn, "direct")
+}
+
+func TestBridgeSendEnvelopeConfirmedByOtherNode(t *testing.T) {
+	nodes := newBridgedNodes(t, 2)
+	id := ws.NewIdentity()
+	conn := nodes[1].connect(t, id)
+
+	env, _ := ws.NewEnvelope(id, "notice", map[string]string{"text": "hello from node 0"})
+	if err := nodes[0].handler.SendEnvelope(env); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	got := receiveType(t, conn, "notice")
+	if got.ID != env.ID {
+		t.Errorf("Expected envelope %s, got %s", env.ID, got.ID)
+	}
+	conn.Ack(got.ID)
+	if !waitFor(t, 2*time.Second, func() bool { return len(nodes[1].persister.confirmedIDs()) == 1 }) {
+		t.Error("Expected the receiving node to confirm delivery")
+	}
+}
+
+func TestBridgeBroadcastsReachEveryNodeOnce(t *testing.T) {
+	nodes := newBridgedNodes(t, 2)
+	local := nodes[0].connect(t, ws.NewIdentity())
+	remote := nodes[1].connect(t, ws.NewIdentity())
+
+	result := nodes[0].handler.Broadcast([]byte(`{"type":"everyone"}`))
+	if result.Delivered != 1 {
+		t.Errorf("Expected the result to count the local client, got %+v", result)
+	}
+	receiveType(t, local, "everyone")
+	receiveType(t, remote, "everyone")
+
+	select {
+	case env := <-local.Receive():
+		t.Errorf("Expected no echoed broadcast, got %+v", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBridgeRoomBroadcast(t *testing.T) {
+	nodes := newBridgedNodes(t, 2)
+	memberID, outsiderID := ws.NewIdentity(), ws.NewIdentity()
+	member := nodes[1].connect(t, memberID)
+	outsider := nodes[1].connect(t, outsiderID)
+	client, _ := nodes[1].handler.Get(memberID)
+	nodes[1].handler.Join("lobby", client)
+
+	nodes[0].handler.BroadcastToRoom("lobby", []byte(`{"type":"lobby.news"}`))
+	receiveType(t, member, "lobby.news")
+	select {
+	case env := <-outsider.Receive():
+		t.Errorf("Expected only room members to receive it, got %+v", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedisBridgeSubscribeAfterClose(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	bridge := redisbridge.New(rdb, redisbridge.WithInstanceID("node-a"))
+	if bridge.InstanceID() != "node-a" {
+		t.Errorf("Expected the configured instance ID, got %q", bridge.InstanceID())
+	}
+
+	bridge.Close()
+	if err := bridge.Subscribe("topic", func([]byte) {}); !errors.Is(err, redisbridge.ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
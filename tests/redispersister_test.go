@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/oduortoni/websocket/persist/redispersister"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/persistertest"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisPersister(t *testing.T, opts ...redispersister.Option) (*redispersister.Persister, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	commander := redispersister.CommanderFunc(func(ctx context.Context, args ...any) (any, error) {
+		reply, err := rdb.Do(ctx, args...).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return reply, err
+	})
+	return redispersister.New(commander, opts...), server
+}
+
+func TestRedisPersisterConformance(t *testing.T) {
+	persistertest.Run(t, func(t *testing.T) ws.EnvelopePersister {
+		p, _ := newRedisPersister(t)
+		return p
+	})
+}
+
+func TestRedisPersisterConfirmRemovesEnvelope(t *testing.T) {
+	p, _ := newRedisPersister(t)
+	e, _ := ws.NewEnvelope(ws.NewIdentity(), "notification", nil)
+	p.SaveEnvelope(e)
+
+	if err := p.ConfirmDelivery(e.ID, e.ClientID); err != nil {
+		t.Fatalf("ConfirmDelivery failed: %v", err)
+	}
+	if ok, _ := p.Exists(e.ID); ok {
+		t.Error("Expected the confirmed envelope to be deleted")
+	}
+	if err := p.ConfirmDelivery(e.ID, e.ClientID); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected a second confirmation to fail with ErrUnknownEnvelope, got %v", err)
+	}
+}
+
+func TestRedisPersisterPaginatesInOrder(t *testing.T) {
+	p, _ := newRedisPersister(t, redispersister.WithPageSize(3))
+	clientID := ws.NewIdentity()
+	base := time.Now()
+
+	var want []ws.Identity
+	for i := 0; i < 10; i++ {
+		e := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "n", Timestamp: base.Add(time.Duration(9-i) * time.Second)}
+		p.SaveEnvelope(e)
+		want = append([]ws.Identity{e.ID}, want...)
+	}
+
+	page, more, err := p.FetchPage(context.Background(), clientID, 0, 4)
+	if err != nil {
+		t.Fatalf("FetchPage failed: %v", err)
+	}
+	if len(page) != 4 || !more || page[0].ID != want[0] {
+		t.Errorf("Expected the first 4 of 10 envelopes, got %d (more=%v)", len(page), more)
+	}
+
+	all, err := p.FetchUndelivered(clientID)
+	if err != nil {
+		t.Fatalf("FetchUndelivered failed: %v", err)
+	}
+	if len(all) != len(want) {
+		t.Fatalf("Expected %d envelopes, got %d", len(want), len(all))
+	}
+	for i := range want {
+		if all[i].ID != want[i] {
+			t.Fatalf("Expected envelope %d to be %s, got %s", i, want[i], all[i].ID)
+		}
+	}
+}
+
+func TestRedisPersisterExpiresAbandonedMailboxes(t *testing.T) {
+	p, server := newRedisPersister(t, redispersister.WithTTL(time.Hour))
+	clientID := ws.NewIdentity()
+	e, _ := ws.NewEnvelope(clientID, "notification", nil)
+	p.SaveEnvelope(e)
+
+	server.FastForward(30 * time.Minute)
+	if got, _ := p.FetchUndelivered(clientID); len(got) != 1 {
+		t.Fatalf("Expected the envelope to survive within the TTL, got %d", len(got))
+	}
+
+	server.FastForward(time.Hour)
+	if got, _ := p.FetchUndelivered(clientID); len(got) != 0 {
+		t.Errorf("Expected the mailbox to expire, got %d envelopes", len(got))
+	}
+	if server.Exists("ws:mailbox:" + clientID.String()) {
+		t.Error("Expected the mailbox key to expire")
+	}
+}
+
+func TestRedisPersisterHonoursEnvelopeExpiry(t *testing.T) {
+	p, server := newRedisPersister(t)
+	clientID := ws.NewIdentity()
+
+	short, _ := ws.NewEnvelope(clientID, "short", nil)
+	expiresAt := time.Now().Add(time.Minute)
+	short.ExpiresAt = &expiresAt
+	long, _ := ws.NewEnvelope(clientID, "long", nil)
+	p.SaveEnvelope(short)
+	p.SaveEnvelope(long)
+
+	server.SetTime(time.Now().Add(2 * time.Minute))
+	server.FastForward(2 * time.Minute)
+
+	got, _ := p.FetchUndelivered(clientID)
+	if len(got) != 1 || got[0].ID != long.ID {
+		t.Errorf("Expected only the unexpired envelope, got %v", got)
+	}
+}
@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type requestFixture struct {
+	handler        *ws.WebsocketHandler
+	messageHandler *mockMessageHandler
+	conn           *websocket.Conn
+	client         *ws.Client
+}
+
+func newRequestFixture(t *testing.T) *requestFixture {
+	t.Helper()
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	messageHandler := &mockMessageHandler{}
+	handler := ws.NewWebSocketHandler(validator, messageHandler, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+	client, _ := handler.Get(clientID)
+	return &requestFixture{handler: handler, messageHandler: messageHandler, conn: conn, client: client}
+}
+
+type wireRequest struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Payload map[string]any `json:"payload"`
+}
+
+func readRequest(conn *websocket.Conn) (wireRequest, error) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return wireRequest{}, err
+	}
+	var req wireRequest
+	err = json.Unmarshal(data, &req)
+	return req, err
+}
+
+func reply(conn *websocket.Conn, req wireRequest, payload map[string]any) error {
+	data, _ := json.Marshal(map[string]any{
+		"type":     req.Type + ".reply",
+		"reply_to": req.ID,
+		"payload":  payload,
+	})
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func TestRequestReceivesReply(t *testing.T) {
+	f := newRequestFixture(t)
+
+	go func() {
+		if req, err := readRequest(f.conn); err == nil {
+			reply(f.conn, req, map[string]any{"version": req.Payload["doc"]})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	env, err := f.client.Request(ctx, "doc.version", map[string]string{"doc": "readme"})
+	if err != nil {
+		t.Fatalf("Expected a reply, got %v", err)
+	}
+	var payload map[string]string
+	env.DecodePayload(&payload)
+	if env.Type != "doc.version.reply" || payload["version"] != "readme" {
+		t.Errorf("Expected matching reply envelope, got %+v", env)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(f.messageHandler.received()) != 0 {
+		t.Error("Expected replies not to reach the message handler")
+	}
+}
+
+func TestRequestTimesOut(t *testing.T) {
+	f := newRequestFixture(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := f.client.Request(ctx, "doc.version", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	// A late reply no longer has a waiter and flows to the message handler.
+	req, err := readRequest(f.conn)
+	if err != nil {
+		t.Fatalf("Expected a request frame, got %v", err)
+	}
+	reply(f.conn, req, nil)
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.messageHandler.received()) == 1 }) {
+		t.Error("Expected late reply to be handled as a normal message")
+	}
+}
+
+func TestRequestFailsWhenClientDisconnects(t *testing.T) {
+	f := newRequestFixture(t)
+
+	go func() {
+		readRequest(f.conn)
+		f.conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := f.client.Request(ctx, "doc.version", nil)
+	if !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	const count = 10
+	f := newRequestFixture(t)
+
+	go func() {
+		reqs := make([]wireRequest, count)
+		for i := range reqs {
+			req, err := readRequest(f.conn)
+			if err != nil {
+				return
+			}
+			reqs[i] = req
+		}
+		for i := len(reqs) - 1; i >= 0; i-- {
+			reply(f.conn, reqs[i], map[string]any{"n": reqs[i].Payload["n"]})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			env, err := f.client.Request(ctx, "count", map[string]string{"n": fmt.Sprint(i)})
+			if err != nil {
+				t.Errorf("Request %d failed: %v", i, err)
+				return
+			}
+			var payload map[string]string
+			env.DecodePayload(&payload)
+			if payload["n"] != fmt.Sprint(i) {
+				t.Errorf("Request %d received reply for %v", i, payload["n"])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var resumeSecret = []byte("resume-secret")
+
+type resumeFixture struct {
+	clock       *fakeClock
+	handler     *ws.WebsocketHandler
+	server      *httptest.Server
+	connects    atomic.Int32
+	resumes     atomic.Int32
+	disconnects chan *ws.Client
+}
+
+func newResumeFixture(t *testing.T, opts ...ws.Option) *resumeFixture {
+	t.Helper()
+	f := &resumeFixture{clock: newFakeClock(), disconnects: make(chan *ws.Client, 4)}
+	opts = append(opts,
+		ws.WithClock(f.clock),
+		ws.WithSessionResumption(resumeSecret, time.Minute),
+		ws.WithOnConnect(func(*ws.Client, ws.SessionInfo) { f.connects.Add(1) }),
+		ws.WithOnResume(func(*ws.Client, ws.SessionInfo) { f.resumes.Add(1) }),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) { f.disconnects <- client }),
+	)
+	f.handler = ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, nil, opts...)
+	f.server = newTestServer(t, f.handler)
+	return f
+}
+
+// dial connects, presenting token when it is not empty, and returns the
+// connection, its token and its registered client.
+func (f *resumeFixture) dial(t *testing.T, token string, header http.Header) (*websocket.Conn, string, *ws.Client) {
+	t.Helper()
+	if header == nil {
+		header = http.Header{}
+	}
+	if token != "" {
+		header.Set(ws.ResumeTokenHeader, token)
+	}
+	conn, resp := dialWithResponse(t, f.server, header)
+	id, err := ws.ParseIdentity(resp.Header.Get(ws.ClientIDHeader))
+	if err != nil {
+		t.Fatalf("Expected an identity in the response, got %v", err)
+	}
+	var client *ws.Client
+	if !waitFor(t, 2*time.Second, func() bool {
+		for _, c := range f.handler.Connections(id) {
+			if !c.Suspended() {
+				client = c
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatal("Expected the connection to be registered")
+	}
+	return conn, resp.Header.Get(ws.ResumeTokenHeader), client
+}
+
+// drop closes conn without a closing handshake, as a lost network would,
+// and waits for the server to park the session.
+func (f *resumeFixture) drop(t *testing.T, conn *websocket.Conn, client *ws.Client) {
+	t.Helper()
+	conn.UnderlyingConn().Close()
+	if !waitFor(t, 2*time.Second, client.Suspended) {
+		t.Fatal("Expected the session to be parked")
+	}
+}
+
+func (f *resumeFixture) expectNoDisconnect(t *testing.T) {
+	t.Helper()
+	select {
+	case client := <-f.disconnects:
+		t.Fatalf("Expected the session to live on, got a disconnect for %s", client.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	f := newResumeFixture(t, ws.WithSequencing(8))
+	conn, token, client := f.dial(t, "", nil)
+	if token == "" || client.ResumeToken() != token {
+		t.Fatalf("Expected a resumption token, got %q", token)
+	}
+	f.handler.Join("lobby", client)
+	f.handler.Subscribe(client, "prices.*")
+	client.Set("plan", "pro")
+	client.AddTag("mobile")
+	f.handler.BroadcastToRoom("lobby", []byte(`{"n":1}`))
+	readSequenced(t, conn)
+
+	f.drop(t, conn, client)
+	f.handler.BroadcastToRoom("lobby", []byte(`{"n":2}`))
+	f.handler.BroadcastToRoom("lobby", []byte(`{"n":3}`))
+	f.clock.Advance(59 * time.Second)
+	f.expectNoDisconnect(t)
+
+	resumed, resumedToken, next := f.dial(t, token, nil)
+	if resumedToken != token {
+		t.Errorf("Expected the same token back, got %q", resumedToken)
+	}
+	if next.ID != client.ID || next == client {
+		t.Fatalf("Expected a new connection for %s, got %s", client.ID, next.ID)
+	}
+	for want := 2; want <= 3; want++ {
+		if n, seq := readSequenced(t, resumed); n != want || seq != uint64(want) {
+			t.Errorf("Expected queued frame %d numbered %d, got frame %d numbered %d", want, want, n, seq)
+		}
+	}
+
+	if members := f.handler.RoomMembers("lobby"); len(members) != 1 || members[0] != client.ID {
+		t.Errorf("Expected the room to keep its member, got %v", members)
+	}
+	if subs := f.handler.Subscriptions(next); len(subs) != 1 || subs[0] != "prices.*" {
+		t.Errorf("Expected the subscription to carry over, got %v", subs)
+	}
+	if plan, _ := next.Get("plan"); plan != "pro" || !next.HasTag("mobile") {
+		t.Errorf("Expected metadata and tags to carry over, got %v, %v", plan, next.HasTag("mobile"))
+	}
+	if f.handler.Len() != 1 {
+		t.Errorf("Expected one registered connection, got %d", f.handler.Len())
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return f.resumes.Load() == 1 }) || f.connects.Load() != 1 {
+		t.Errorf("Expected one connect and one resume, got %d and %d", f.connects.Load(), f.resumes.Load())
+	}
+
+	f.handler.BroadcastToRoom("lobby", []byte(`{"n":4}`))
+	if n, seq := readSequenced(t, resumed); n != 4 || seq != 4 {
+		t.Errorf("Expected room broadcasts to reach the new connection, got frame %d numbered %d", n, seq)
+	}
+	client.SendJSON(map[string]int{"n": 5})
+	if n, _ := readSequenced(t, resumed); n != 5 {
+		t.Errorf("Expected sends through the old client to reach the new connection, got frame %d", n)
+	}
+	f.expectNoDisconnect(t)
+}
+
+func TestSessionResumptionExpires(t *testing.T) {
+	f := newResumeFixture(t)
+	conn, token, client := f.dial(t, "", nil)
+	f.handler.Join("lobby", client)
+
+	f.drop(t, conn, client)
+	f.clock.Advance(time.Minute)
+	select {
+	case ended := <-f.disconnects:
+		if ended != client {
+			t.Errorf("Expected the parked client to disconnect, got %s", ended.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected OnDisconnect once the grace period passed")
+	}
+	if f.handler.Len() != 0 || len(f.handler.RoomMembers("lobby")) != 0 {
+		t.Errorf("Expected the session discarded, got %d connections", f.handler.Len())
+	}
+
+	_, fresh, next := f.dial(t, token, nil)
+	if fresh == token || next.ID == client.ID {
+		t.Errorf("Expected an expired token to start a new session, got token %q for %s", fresh, next.ID)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return f.connects.Load() == 2 }) || f.resumes.Load() != 0 {
+		t.Errorf("Expected two connects and no resume, got %d and %d", f.connects.Load(), f.resumes.Load())
+	}
+}
+
+func TestSessionResumptionRejectsWrongToken(t *testing.T) {
+	f := newResumeFixture(t)
+	conn, token, client := f.dial(t, "", nil)
+	f.drop(t, conn, client)
+
+	tampered := []byte(token)
+	if tampered[5] == 'A' {
+		tampered[5] = 'B'
+	} else {
+		tampered[5] = 'A'
+	}
+	other := ws.NewIdentity()
+	for name, attempt := range map[string]struct {
+		token  string
+		header http.Header
+	}{
+		"forged":         {token: string(tampered)},
+		"malformed":      {token: "not-a-token"},
+		"other identity": {token: token, header: identityHeader(other)},
+	} {
+		_, fresh, next := f.dial(t, attempt.token, attempt.header)
+		if fresh == token || next.ID == client.ID {
+			t.Errorf("%s: expected a new session, got token %q for %s", name, fresh, next.ID)
+		}
+	}
+	if !client.Suspended() {
+		t.Fatal("Expected the session to stay parked")
+	}
+
+	query := wsURL(f.server) + "?resume_token=" + token
+	resumed, resp, err := websocket.DefaultDialer.Dial(query, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer resumed.Close()
+	if resp.Header.Get(ws.ResumeTokenHeader) != token || resp.Header.Get(ws.ClientIDHeader) != client.ID.String() {
+		t.Errorf("Expected the right token in the query to resume the session, got %q", resp.Header.Get(ws.ResumeTokenHeader))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return f.resumes.Load() == 1 }) {
+		t.Errorf("Expected one resume, got %d", f.resumes.Load())
+	}
+}
+
+func TestSessionResumptionEndsOnNormalClose(t *testing.T) {
+	f := newResumeFixture(t)
+	conn, _, client := f.dial(t, "", nil)
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	select {
+	case ended := <-f.disconnects:
+		if ended != client {
+			t.Errorf("Expected the client to disconnect, got %s", ended.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a normal close to end the session at once")
+	}
+	if client.Suspended() {
+		t.Error("Expected the session not to be parked")
+	}
+}
+
+func TestSessionResumptionKickEndsParkedSession(t *testing.T) {
+	f := newResumeFixture(t)
+	conn, _, client := f.dial(t, "", nil)
+	f.drop(t, conn, client)
+
+	if err := f.handler.Disconnect(client.ID, websocket.ClosePolicyViolation, "banned"); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	select {
+	case <-f.disconnects:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a kick to end the parked session")
+	}
+	if f.handler.Len() != 0 {
+		t.Errorf("Expected no connections, got %d", f.handler.Len())
+	}
+}
+
+func TestSessionResumptionTakesOverLiveConnection(t *testing.T) {
+	f := newResumeFixture(t)
+	old, token, client := f.dial(t, "", nil)
+	f.handler.Join("lobby", client)
+
+	_, resumedToken, next := f.dial(t, token, nil)
+	if resumedToken != token || next.ID != client.ID {
+		t.Fatalf("Expected the live session to be taken over, got token %q for %s", resumedToken, next.ID)
+	}
+	old.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := old.ReadMessage(); err == nil {
+		t.Error("Expected the old connection to be closed")
+	}
+	if members := f.handler.RoomMembers("lobby"); len(members) != 1 {
+		t.Errorf("Expected the room to keep its member, got %v", members)
+	}
+	if f.handler.Len() != 1 {
+		t.Errorf("Expected one registered connection, got %d", f.handler.Len())
+	}
+	f.expectNoDisconnect(t)
+}
+
+func TestDialResumesSession(t *testing.T) {
+	f := newResumeFixture(t)
+	ctx := context.Background()
+
+	first, err := ws.Dial(ctx, wsURL(f.server))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	if first.ResumeToken == "" || first.Resumed {
+		t.Fatalf("Expected a token for a new session, got %q, resumed %v", first.ResumeToken, first.Resumed)
+	}
+	client := registeredClient(t, f.handler)
+	first.Close(websocket.CloseGoingAway, "")
+	if !waitFor(t, 2*time.Second, client.Suspended) {
+		t.Fatal("Expected the session to be parked")
+	}
+
+	second, err := ws.Dial(ctx, wsURL(f.server), ws.WithResumeToken(first.ResumeToken))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer second.Close(websocket.CloseNormalClosure, "")
+	if !second.Resumed || second.ID != first.ID {
+		t.Errorf("Expected the session resumed, got resumed %v for %s", second.Resumed, second.ID)
+	}
+}
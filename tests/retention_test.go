@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+// purgeLog records the results reported by a retention hook.
+type purgeLog struct {
+	mu      sync.Mutex
+	results []ws.RetentionResult
+}
+
+func (l *purgeLog) record(result ws.RetentionResult, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results = append(l.results, result)
+}
+
+func (l *purgeLog) passes() []ws.RetentionResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ws.RetentionResult(nil), l.results...)
+}
+
+// deliveredAt returns an outbound envelope confirmed at the given time.
+func deliveredAt(clientID ws.Identity, at time.Time) ws.Envelope {
+	return ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "n", Timestamp: at, Delivered: &at}
+}
+
+func TestRetentionJanitorPurgesOnlyOldDelivered(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	log := &purgeLog{}
+	janitor := ws.NewRetentionJanitor(persister, time.Minute, time.Hour,
+		ws.WithRetentionClock(clock),
+		ws.WithRetentionHook(log.record),
+	)
+
+	clientID := ws.NewIdentity()
+	start := clock.Now()
+	old := deliveredAt(clientID, start)
+	recent := deliveredAt(clientID, start.Add(30*time.Minute))
+	pending := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "n", Timestamp: start}
+	for _, e := range []ws.Envelope{old, recent, pending} {
+		persister.SaveEnvelope(e)
+	}
+
+	janitor.Start()
+	defer janitor.Stop()
+
+	clock.Advance(time.Minute)
+	if got := log.passes(); len(got) != 1 || got[0].Delivered != 0 {
+		t.Fatalf("Expected a first pass inside the retention window to purge nothing, got %+v", got)
+	}
+
+	clock.Advance(time.Hour)
+	got := log.passes()
+	if len(got) < 2 || got[len(got)-1].Delivered != 1 {
+		t.Fatalf("Expected the envelope delivered over an hour ago to be purged, got %+v", got)
+	}
+	for _, tc := range []struct {
+		name string
+		e    ws.Envelope
+		want bool
+	}{{"old", old, false}, {"recent", recent, true}, {"pending", pending, true}} {
+		if exists, _ := persister.Exists(tc.e.ID); exists != tc.want {
+			t.Errorf("Expected %s envelope exists=%v, got %v", tc.name, tc.want, exists)
+		}
+	}
+}
+
+func TestRetentionJanitorPurgesExpired(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	janitor := ws.NewRetentionJanitor(persister, time.Minute, time.Hour, ws.WithRetentionClock(clock))
+
+	expiresAt := clock.Now().Add(30 * time.Second)
+	persister.SaveEnvelope(ws.Envelope{ID: ws.NewIdentity(), ClientID: ws.NewIdentity(), Type: "n", ExpiresAt: &expiresAt})
+
+	clock.Advance(time.Minute)
+	result, err := janitor.RunOnce()
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if result != (ws.RetentionResult{Expired: 1}) {
+		t.Errorf("Expected one expired envelope purged, got %+v", result)
+	}
+}
+
+func TestRetentionJanitorStop(t *testing.T) {
+	clock := newFakeClock()
+	log := &purgeLog{}
+	janitor := ws.NewRetentionJanitor(ws.NewMemoryPersister(), time.Minute, time.Hour,
+		ws.WithRetentionClock(clock),
+		ws.WithRetentionHook(log.record),
+	)
+
+	janitor.Start()
+	janitor.Start()
+	if clock.pending() != 1 {
+		t.Fatalf("Expected one scheduled pass, got %d", clock.pending())
+	}
+	janitor.Stop()
+	clock.Advance(time.Hour)
+	if len(log.passes()) != 0 {
+		t.Errorf("Expected no passes after Stop, got %d", len(log.passes()))
+	}
+}
+
+func TestHandlerRetentionFollowsLifecycle(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	log := &purgeLog{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithClock(clock),
+		ws.WithRetention(time.Minute, time.Hour),
+		ws.WithOnRetentionPurge(log.record),
+	)
+
+	persister.SaveEnvelope(deliveredAt(ws.NewIdentity(), clock.Now()))
+	clock.Advance(2 * time.Hour)
+	if got := log.passes(); len(got) != 1 || got[0].Delivered != 1 {
+		t.Fatalf("Expected the handler's janitor to purge the delivered envelope, got %+v", got)
+	}
+
+	if err := handler.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if clock.pending() != 0 {
+		t.Errorf("Expected shutdown to stop the janitor, got %d pending passes", clock.pending())
+	}
+}
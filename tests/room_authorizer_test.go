@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// sendRoomRequest sends a room join or leave frame for room and returns its
+// ID.
+func sendRoomRequest(conn *wstest.Conn, msgType, room string) ws.Identity {
+	id := ws.NewIdentity()
+	conn.MustSendJSON(map[string]any{"type": msgType, "id": id.String(), "payload": map[string]string{"room": room}})
+	return id
+}
+
+func expectRoomAck(t *testing.T, conn *wstest.Conn, id ws.Identity) ws.RoomAck {
+	t.Helper()
+	ack := conn.ExpectEnvelope(ws.AckMessageType, 2*time.Second)
+	if ack.ID != id {
+		t.Fatalf("Expected an ack for %s, got %+v", id, ack)
+	}
+	var payload ws.RoomAck
+	if err := ack.DecodePayload(&payload); err != nil {
+		t.Fatalf("Expected the ack to carry a RoomAck, got %v", err)
+	}
+	return payload
+}
+
+func expectRoomError(t *testing.T, conn *wstest.Conn, id ws.Identity) ws.ErrorFrame {
+	t.Helper()
+	var frame ws.ErrorFrame
+	conn.ExpectJSON(&frame, 2*time.Second)
+	if frame.Type != ws.ErrorMessageType || frame.Ref != id.String() {
+		t.Fatalf("Expected an error frame for %s, got %+v", id, frame)
+	}
+	return frame
+}
+
+func TestRoomJoinAuthorized(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	authorizer := ws.RoomAuthorizerFunc(func(client *ws.Client, room string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		asked = append(asked, room)
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithRoomAuthorizer(authorizer),
+	)
+	server := newTestServer(t, handler)
+	first, second := connect(t, server), connect(t, server)
+
+	id := sendRoomRequest(first, ws.RoomJoinMessageType, "lobby")
+	if ack := expectRoomAck(t, first, id); ack.Room != "lobby" || ack.Members != 1 {
+		t.Errorf("Expected the first join to report 1 member, got %+v", ack)
+	}
+	id = sendRoomRequest(second, ws.RoomJoinMessageType, "lobby")
+	if ack := expectRoomAck(t, second, id); ack.Members != 2 {
+		t.Errorf("Expected the second join to report 2 members, got %+v", ack)
+	}
+
+	mu.Lock()
+	if len(asked) != 2 || asked[0] != "lobby" {
+		t.Errorf("Expected the authorizer to be asked about both joins, got %v", asked)
+	}
+	mu.Unlock()
+
+	handler.BroadcastToRoom("lobby", []byte(`{"type":"hello"}`))
+	first.ExpectMessage(2 * time.Second)
+	second.ExpectMessage(2 * time.Second)
+}
+
+func TestRoomJoinDenied(t *testing.T) {
+	var observed []error
+	var mu sync.Mutex
+	authorizer := ws.RoomAuthorizerFunc(func(client *ws.Client, room string) error {
+		switch room {
+		case "lobby":
+			return nil
+		case "full":
+			return &ws.ErrorFrame{Code: "room_full", Message: "room is full"}
+		}
+		return errors.New("not a member")
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithRoomAuthorizer(authorizer),
+		ws.WithObserver(ws.Observer{OnError: func(_ *ws.Client, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			observed = append(observed, err)
+		}}),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	id := sendRoomRequest(conn, ws.RoomJoinMessageType, "admins")
+	if frame := expectRoomError(t, conn, id); frame.Code != ws.ErrorCodeUnauthorized {
+		t.Errorf("Expected an unauthorized error frame, got %+v", frame)
+	}
+	id = sendRoomRequest(conn, ws.RoomJoinMessageType, "full")
+	if frame := expectRoomError(t, conn, id); frame.Code != "room_full" || frame.Message != "room is full" {
+		t.Errorf("Expected the authorizer's error frame, got %+v", frame)
+	}
+	if len(handler.RoomMembers("admins")) != 0 || len(handler.RoomMembers("full")) != 0 {
+		t.Error("Expected denied joins to leave the rooms empty")
+	}
+
+	conn.MustSendJSON(map[string]any{"type": ws.RoomJoinMessageType, "id": ws.NewIdentity().String(), "payload": map[string]string{}})
+	var frame ws.ErrorFrame
+	if conn.ExpectJSON(&frame, 2*time.Second); frame.Code != "invalid_room" {
+		t.Errorf("Expected an invalid_room error frame, got %+v", frame)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(observed) != 2 || !errors.Is(observed[0], ws.ErrJoinDenied) || !errors.Is(observed[1], ws.ErrJoinDenied) {
+		t.Errorf("Expected both denials to be observed as ErrJoinDenied, got %v", observed)
+	}
+}
+
+func TestRoomJoinWithoutAuthorizer(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	id := sendRoomRequest(conn, ws.RoomJoinMessageType, "lobby")
+	if frame := expectRoomError(t, conn, id); frame.Code != ws.ErrorCodeUnauthorized {
+		t.Errorf("Expected client joins to be denied without an authorizer, got %+v", frame)
+	}
+
+	// Joins made on the server are not checked.
+	if err := handler.Join("lobby", client); err != nil {
+		t.Fatalf("Expected a server-side join to succeed, got %v", err)
+	}
+	if members := handler.RoomMembers("lobby"); len(members) != 1 || members[0] != client.ID {
+		t.Errorf("Expected the client to be in the room, got %v", members)
+	}
+
+	id = sendRoomRequest(conn, ws.RoomLeaveMessageType, "lobby")
+	if ack := expectRoomAck(t, conn, id); ack.Members != 0 {
+		t.Errorf("Expected the leave to report an empty room, got %+v", ack)
+	}
+}
+
+func TestRoomLeaveNeverJoined(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithRoomAuthorizer(ws.RoomAuthorizerFunc(func(*ws.Client, string) error { return nil })),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	id := sendRoomRequest(conn, ws.RoomLeaveMessageType, "nowhere")
+	if ack := expectRoomAck(t, conn, id); ack.Room != "nowhere" || ack.Members != 0 {
+		t.Errorf("Expected leaving a room never joined to be acked, got %+v", ack)
+	}
+	if members := handler.RoomMembers("nowhere"); members != nil {
+		t.Errorf("Expected leaving not to create the room, got %v", members)
+	}
+}
+
+func TestRoomMembershipEndsOnDisconnect(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithRoomAuthorizer(ws.RoomAuthorizerFunc(func(*ws.Client, string) error { return nil })),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	id := sendRoomRequest(conn, ws.RoomJoinMessageType, "lobby")
+	expectRoomAck(t, conn, id)
+
+	conn.Conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected the closed client to be unregistered")
+	}
+	if members := handler.RoomMembers("lobby"); len(members) != 0 {
+		t.Errorf("Expected the room to be emptied on disconnect, got %v", members)
+	}
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// broadcastChat broadcasts n chat envelopes to room, numbered from 0, a
+// second apart on clock.
+func broadcastChat(t *testing.T, handler *ws.WebsocketHandler, clock *fakeClock, room string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		clock.Advance(time.Second)
+		envelope, _ := ws.NewEnvelope(ws.Identity{}, "chat", map[string]int{"n": i}, ws.WithEnvelopeClock(clock))
+		if _, err := handler.BroadcastEnvelopeToRoom(room, envelope); err != nil {
+			t.Fatalf("Expected the room broadcast to succeed, got %v", err)
+		}
+	}
+	clock.Advance(time.Second)
+}
+
+func expectChat(t *testing.T, conn *wstest.Conn) (ws.Envelope, int) {
+	t.Helper()
+	envelope := conn.ExpectEnvelope("chat", 2*time.Second)
+	var payload map[string]int
+	envelope.DecodePayload(&payload)
+	return envelope, payload["n"]
+}
+
+func TestRoomHistoryReplayedOnJoin(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithClock(clock),
+		ws.WithRoomHistory(3),
+	)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	broadcastChat(t, handler, clock, "lobby", 5)
+	broadcastChat(t, handler, clock, "ops", 2)
+	if err := handler.Join("lobby", client); err != nil {
+		t.Fatalf("Expected Join to succeed, got %v", err)
+	}
+
+	for _, want := range []int{2, 3, 4} {
+		envelope, n := expectChat(t, conn)
+		if n != want || !envelope.History || envelope.Room != "lobby" {
+			t.Fatalf("Expected history message %d of the lobby, got %d in %+v", want, n, envelope)
+		}
+		if envelope.ClientID != client.ID {
+			t.Errorf("Expected history to be addressed to the joining client, got %s", envelope.ClientID)
+		}
+	}
+
+	envelope, _ := ws.NewEnvelope(ws.Identity{}, "chat", map[string]int{"n": 5})
+	if result, err := handler.BroadcastEnvelopeToRoom("lobby", envelope); err != nil || result.Delivered != 1 {
+		t.Fatalf("Expected the live broadcast to reach the member, got %+v and %v", result, err)
+	}
+	if live, n := expectChat(t, conn); n != 5 || live.History || live.Room != "lobby" {
+		t.Errorf("Expected a live lobby message, got %+v", live)
+	}
+
+	// Joining a room the client is already in sends no history again.
+	handler.Join("lobby", client)
+	conn.ExpectNoMessage(100 * time.Millisecond)
+}
+
+func TestRoomHistoryOnClientJoin(t *testing.T) {
+	clock := newFakeClock()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, ws.NewMemoryPersister(),
+		ws.WithClock(clock),
+		ws.WithRoomHistory(10),
+		ws.WithRoomAuthorizer(ws.RoomAuthorizerFunc(func(*ws.Client, string) error { return nil })),
+	)
+	conn := connect(t, newTestServer(t, handler))
+
+	broadcastChat(t, handler, clock, "lobby", 3)
+	id := sendRoomRequest(conn, ws.RoomJoinMessageType, "lobby")
+	for want := 0; want < 3; want++ {
+		if envelope, n := expectChat(t, conn); n != want || !envelope.History {
+			t.Fatalf("Expected history message %d, got %d in %+v", want, n, envelope)
+		}
+	}
+	expectRoomAck(t, conn, id)
+}
+
+func TestRoomHistoryDisabledByDefault(t *testing.T) {
+	clock := newFakeClock()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, ws.NewMemoryPersister(),
+		ws.WithClock(clock),
+	)
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	broadcastChat(t, handler, clock, "lobby", 3)
+	handler.Join("lobby", client)
+	conn.ExpectNoMessage(100 * time.Millisecond)
+}
+
+func TestRoomEnvelopesAreNotReplayedOrAcked(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+	conn := connect(clientID)
+	client, _ := f.handler.Get(clientID)
+	f.handler.Join("lobby", client)
+
+	envelope, _ := ws.NewEnvelope(ws.Identity{}, "chat", nil)
+	f.handler.BroadcastEnvelopeToRoom("lobby", envelope)
+	if got := readEnvelopeID(t, conn); got != envelope.ID.String() {
+		t.Fatalf("Expected the room envelope, got %s", got)
+	}
+	sendAck(conn, envelope.ID.String())
+	if code := readErrorCode(t, conn); code != "unknown_envelope" {
+		t.Errorf("Expected a room envelope not to await an ack, got %q", code)
+	}
+	if saved := f.persister.saved(); len(saved) != 1 || saved[0].Room != "lobby" {
+		t.Errorf("Expected the envelope to be saved once for the room, got %+v", saved)
+	}
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// connectAs opens a connection for id and returns it with its client.
+func connectAs(t *testing.T, handler *ws.WebsocketHandler, server *httptest.Server, id ws.Identity) (*wstest.Conn, *ws.Client) {
+	t.Helper()
+	before := len(handler.Connections(id))
+	conn := connect(t, server, wstest.WithHeader("X-Test-Client-ID", id.String()))
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.Connections(id)) == before+1 }) {
+		t.Fatal("Expected the connection to be registered")
+	}
+	// Connections lists an identity's connections in the order they
+	// registered.
+	return conn, handler.Connections(id)[before]
+}
+
+func expectPresence(t *testing.T, conn *wstest.Conn) ws.PresenceEvent {
+	t.Helper()
+	envelope := conn.ExpectEnvelope(ws.PresenceMessageType, 2*time.Second)
+	var event ws.PresenceEvent
+	if err := envelope.DecodePayload(&event); err != nil {
+		t.Fatalf("Expected a presence payload, got %v", err)
+	}
+	if !envelope.Ephemeral || envelope.Room != event.Room {
+		t.Errorf("Expected an ephemeral room envelope, got %+v", envelope)
+	}
+	return event
+}
+
+func newPresenceHandler(t *testing.T, opts ...ws.Option) (*ws.WebsocketHandler, *httptest.Server) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, opts...)
+	return handler, newTestServer(t, handler)
+}
+
+func TestPresenceJoinAndLeave(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, aliceClient := connectAs(t, handler, server, alice)
+	bobConn, bobClient := connectAs(t, handler, server, bob)
+
+	handler.Join("lobby", aliceClient)
+	handler.Join("lobby", bobClient)
+	want := ws.PresenceEvent{Room: "lobby", Identity: bob, Action: ws.PresenceJoin, Members: 2}
+	if got := expectPresence(t, aliceConn); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	bobConn.ExpectNoMessage(100 * time.Millisecond)
+
+	handler.Leave("lobby", bobClient)
+	want = ws.PresenceEvent{Room: "lobby", Identity: bob, Action: ws.PresenceLeave, Members: 1}
+	if got := expectPresence(t, aliceConn); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// Leaving a room never joined announces nothing.
+	handler.Leave("lobby", bobClient)
+	aliceConn.ExpectNoMessage(100 * time.Millisecond)
+}
+
+func TestPresenceLeaveOnDisconnect(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, aliceClient := connectAs(t, handler, server, alice)
+	bobConn, bobClient := connectAs(t, handler, server, bob)
+	handler.Join("lobby", aliceClient)
+	handler.Join("lobby", bobClient)
+	expectPresence(t, aliceConn)
+
+	bobConn.Conn.Close()
+	want := ws.PresenceEvent{Room: "lobby", Identity: bob, Action: ws.PresenceLeave, Members: 1}
+	if got := expectPresence(t, aliceConn); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestPresenceMultiDevice(t *testing.T) {
+	handler, server := newPresenceHandler(t)
+	alice, bob := ws.NewIdentity(), ws.NewIdentity()
+	aliceConn, aliceClient := connectAs(t, handler, server, alice)
+	laptopConn, laptop := connectAs(t, handler, server, bob)
+	phoneConn, phone := connectAs(t, handler, server, bob)
+	handler.Join("lobby", aliceClient)
+
+	handler.Join("lobby", laptop)
+	if got := expectPresence(t, aliceConn); got.Action != ws.PresenceJoin || got.Members != 2 {
+		t.Errorf("Expected bob's first connection to be announced, got %+v", got)
+	}
+	handler.Join("lobby", phone)
+	aliceConn.ExpectNoMessage(100 * time.Millisecond)
+	laptopConn.ExpectNoMessage(50 * time.Millisecond)
+
+	handler.Leave("lobby", phone)
+	aliceConn.ExpectNoMessage(100 * time.Millisecond)
+
+	laptopConn.Conn.Close()
+	want := ws.PresenceEvent{Room: "lobby", Identity: bob, Action: ws.PresenceLeave, Members: 1}
+	if got := expectPresence(t, aliceConn); got != want {
+		t.Errorf("Expected bob's last connection leaving to be announced, got %+v", got)
+	}
+	phoneConn.ExpectNoMessage(50 * time.Millisecond)
+}
+
+func TestPresenceSuppressed(t *testing.T) {
+	t.Run("Room", func(t *testing.T) {
+		handler, server := newPresenceHandler(t)
+		aliceConn, aliceClient := connectAs(t, handler, server, ws.NewIdentity())
+		_, bobClient := connectAs(t, handler, server, ws.NewIdentity())
+
+		handler.SetRoomPresenceEvents("busy", false)
+		handler.Join("busy", aliceClient)
+		handler.Join("busy", bobClient)
+		handler.Leave("busy", bobClient)
+		aliceConn.ExpectNoMessage(100 * time.Millisecond)
+
+		handler.Join("lobby", aliceClient)
+		handler.Join("lobby", bobClient)
+		if got := expectPresence(t, aliceConn); got.Room != "lobby" {
+			t.Errorf("Expected other rooms to keep announcing, got %+v", got)
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		handler, server := newPresenceHandler(t, ws.WithHubPresenceEvents(false))
+		aliceConn, aliceClient := connectAs(t, handler, server, ws.NewIdentity())
+		_, bobClient := connectAs(t, handler, server, ws.NewIdentity())
+
+		handler.Join("lobby", aliceClient)
+		handler.Join("lobby", bobClient)
+		aliceConn.ExpectNoMessage(100 * time.Millisecond)
+
+		handler.SetRoomPresenceEvents("stage", true)
+		handler.Join("stage", aliceClient)
+		handler.Join("stage", bobClient)
+		if got := expectPresence(t, aliceConn); got.Room != "stage" {
+			t.Errorf("Expected the room override to announce joins, got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+type joinRoomMessageHandler struct {
+	hub *ws.Hub
+}
+
+func (j *joinRoomMessageHandler) Handle(client *ws.Client, data []byte) error {
+	return j.hub.Join(string(data), client)
+}
+
+func TestRoomBroadcastReachesMembersOnly(t *testing.T) {
+	router := &joinRoomMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{})
+	router.hub = handler.Hub
+	server := newTestServer(t, handler)
+
+	member := connect(t, server)
+	outsider := connect(t, server)
+
+	member.MustSend([]byte("lobby"))
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.RoomMembers("lobby")) == 1 }) {
+		t.Fatal("Expected client to join the lobby")
+	}
+
+	result := handler.BroadcastToRoom("lobby", []byte("room message"))
+	if result.Delivered != 1 {
+		t.Errorf("Expected 1 delivery, got %+v", result)
+	}
+
+	if data := member.ExpectMessage(2 * time.Second); string(data) != "room message" {
+		t.Errorf("Expected member to receive room message, got %q", data)
+	}
+	outsider.ExpectNoMessage(200 * time.Millisecond)
+}
+
+func TestRoomMembershipClearedOnDisconnect(t *testing.T) {
+	router := &joinRoomMessageHandler{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{})
+	router.hub = handler.Hub
+	server := newTestServer(t, handler)
+
+	conn := connect(t, server)
+	conn.MustSend([]byte("lobby"))
+	conn.MustSend([]byte("support"))
+	if !waitFor(t, 2*time.Second, func() bool { return len(handler.RoomMembers("support")) == 1 }) {
+		t.Fatal("Expected client to join both rooms")
+	}
+
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected client to unregister")
+	}
+
+	if members := handler.RoomMembers("lobby"); len(members) != 0 {
+		t.Errorf("Expected lobby to be empty, got %v", members)
+	}
+	if members := handler.RoomMembers("support"); len(members) != 0 {
+		t.Errorf("Expected support to be empty, got %v", members)
+	}
+}
+
+func TestRoomJoinRequiresRegisteredClient(t *testing.T) {
+	hub := ws.NewHub()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	if err := hub.Join("lobby", client); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
+
+func TestRoomConcurrentJoinAndLeave(t *testing.T) {
+	hub := ws.NewHub()
+	clients := make([]*ws.Client, 100)
+	for i := range clients {
+		clients[i] = ws.NewClient(ws.NewIdentity(), nil)
+		hub.Register(clients[i])
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *ws.Client) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				hub.Join("lobby", client)
+				hub.BroadcastToRoom("lobby", []byte("x"))
+				hub.Leave("lobby", client)
+			}
+			hub.Join("final", client)
+		}(client)
+	}
+	wg.Wait()
+
+	if members := hub.RoomMembers("lobby"); len(members) != 0 {
+		t.Errorf("Expected lobby to be garbage collected, got %d members", len(members))
+	}
+	if members := hub.RoomMembers("final"); len(members) != len(clients) {
+		t.Errorf("Expected %d members in final, got %d", len(clients), len(members))
+	}
+
+	for _, client := range clients {
+		hub.Unregister(client)
+	}
+	if members := hub.RoomMembers("final"); len(members) != 0 {
+		t.Errorf("Expected final to be empty after unregister, got %d members", len(members))
+	}
+}
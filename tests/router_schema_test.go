@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["sku", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"sku": {"type": "string", "pattern": "^[A-Z]-[0-9]+$"},
+		"note": {"type": "string", "maxLength": 10},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {"$ref": "#/$defs/item"}
+		}
+	},
+	"$defs": {
+		"item": {
+			"type": "object",
+			"required": ["qty"],
+			"properties": {"qty": {"type": "integer", "minimum": 1}}
+		}
+	}
+}`
+
+func TestRouterSchemaValidation(t *testing.T) {
+	router := ws.NewRouter()
+	var mu sync.Mutex
+	var handled []string
+	record := func(c *ws.Client, env ws.Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, env.Type)
+		return nil
+	}
+	router.HandleFunc("order.place", record)
+	router.HandleFunc("chat.send", record)
+	if err := router.SetSchema("order.place", []byte(orderSchema)); err != nil {
+		t.Fatalf("Expected the schema to compile, got %v", err)
+	}
+
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{})
+	conn, _ := dialWithResponse(t, newTestServer(t, handler), nil)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"order.place","payload":{"sku":"A-1","items":[{"qty":2}]}}`))
+	// A type without a schema is dispatched as it is.
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat.send","payload":"anything"}`))
+	if !waitFor(t, 2*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 2
+	}) {
+		t.Fatalf("Expected the conforming and unchecked envelopes to be handled, got %v", handled)
+	}
+
+	id := ws.NewIdentity()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"`+id.String()+`","type":"order.place","payload":{"sku":"nope","items":[{"qty":0},{}],"extra":1}}`))
+	frame := readErrorFrame(t, conn)
+	if frame.Code != "validation_failed" || frame.Ref != id.String() {
+		t.Fatalf("Expected a validation_failed error for %s, got %+v", id, frame)
+	}
+	want := []ws.FieldError{
+		{Field: "extra", Message: "unknown field"},
+		{Field: "items.0.qty", Message: "must be at least 1"},
+		{Field: "items.1.qty", Message: "is required"},
+		{Field: "sku", Message: `must match the pattern "^[A-Z]-[0-9]+$"`},
+	}
+	if len(frame.Errors) != len(want) {
+		t.Fatalf("Expected %d failing fields, got %+v", len(want), frame.Errors)
+	}
+	for i := range want {
+		if frame.Errors[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], frame.Errors[i])
+		}
+	}
+	if frame.Field != "extra" {
+		t.Errorf("Expected Field to name the first failure, got %q", frame.Field)
+	}
+	mu.Lock()
+	if len(handled) != 2 {
+		t.Errorf("Expected the failing envelope not to be handled, got %v", handled)
+	}
+	mu.Unlock()
+
+	stats := router.SchemaStats()["order.place"]
+	if stats.Validated != 2 || stats.Failed != 1 || stats.Duration <= 0 {
+		t.Errorf("Expected two validations, one failed, with time spent, got %+v", stats)
+	}
+	if _, ok := router.SchemaStats()["chat.send"]; ok {
+		t.Error("Expected no stats for a type without a schema")
+	}
+}
+
+func TestRouterSchemaSkipAndRemove(t *testing.T) {
+	router := ws.NewRouter()
+	router.HandleFunc("order.place", func(c *ws.Client, env ws.Envelope) error { return nil })
+	router.SetSchema("order.place", []byte(orderSchema))
+	client := ws.NewClient(ws.NewIdentity(), nil)
+	bad := []byte(`{"type":"order.place","payload":{"sku":1}}`)
+
+	if err := router.Handle(client, bad); !errors.Is(err, ws.ErrInvalidPayload) {
+		t.Fatalf("Expected ErrInvalidPayload, got %v", err)
+	}
+
+	router.SkipValidation("order.place", true)
+	if err := router.Handle(client, bad); err != nil {
+		t.Errorf("Expected a skipped type not to be validated, got %v", err)
+	}
+	if stats := router.SchemaStats()["order.place"]; stats.Validated != 1 {
+		t.Errorf("Expected skipped envelopes not to be counted, got %+v", stats)
+	}
+	router.SkipValidation("order.place", false)
+	if err := router.Handle(client, bad); !errors.Is(err, ws.ErrInvalidPayload) {
+		t.Errorf("Expected validation to resume, got %v", err)
+	}
+
+	router.SetSchema("order.place", nil)
+	if err := router.Handle(client, bad); err != nil {
+		t.Errorf("Expected a removed schema not to be checked, got %v", err)
+	}
+}
+
+func TestRouterSchemaCompileErrors(t *testing.T) {
+	router := ws.NewRouter()
+	for name, schema := range map[string]string{
+		"NotJSON":        `{"type":`,
+		"NotAnObject":    `[1, 2]`,
+		"UnknownType":    `{"type": "date"}`,
+		"BadPattern":     `{"pattern": "("}`,
+		"NegativeLength": `{"minLength": -1}`,
+		"RemoteRef":      `{"$ref": "https://example.com/schema.json"}`,
+		"MissingDef":     `{"$ref": "#/$defs/missing"}`,
+		"RefCycle":       `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+		"Unsupported":    `{"unevaluatedProperties": false}`,
+		"TupleItems":     `{"items": [{"type": "string"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := router.SetSchema("order.place", []byte(schema))
+			if err == nil || !strings.Contains(err.Error(), `"order.place"`) {
+				t.Errorf("Expected a compile error naming the type, got %v", err)
+			}
+		})
+	}
+	if len(router.SchemaStats()) != 0 {
+		t.Error("Expected no schema to be registered")
+	}
+}
+
+func TestRouterSchemaKeywords(t *testing.T) {
+	router := ws.NewRouter()
+	router.Fallback(func(c *ws.Client, env ws.Envelope) error { return nil })
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	tests := []struct {
+		name    string
+		schema  string
+		payload string
+		valid   bool
+	}{
+		{"IntegerAcceptsWholeFloat", `{"type": "integer"}`, `2.0`, true},
+		{"IntegerRejectsFraction", `{"type": "integer"}`, `2.5`, false},
+		{"TypeList", `{"type": ["string", "null"]}`, `null`, true},
+		{"MissingPayloadIsNull", `{"type": "object"}`, ``, false},
+		{"Enum", `{"enum": ["red", 1]}`, `1.0`, true},
+		{"EnumMiss", `{"enum": ["red", 1]}`, `"blue"`, false},
+		{"Const", `{"const": {"a": [1]}}`, `{"a": [1]}`, true},
+		{"ExclusiveMaximum", `{"exclusiveMaximum": 10}`, `10`, false},
+		{"MultipleOf", `{"multipleOf": 0.1}`, `0.3`, true},
+		{"MaxLengthCountsRunes", `{"maxLength": 2}`, `"éé"`, true},
+		{"UniqueItems", `{"uniqueItems": true}`, `[1, 1.0]`, false},
+		{"PrefixItems", `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, `["a", 1, 2]`, true},
+		{"PrefixItemsMiss", `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, `["a", "b"]`, false},
+		{"PatternProperties", `{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`, `{"x-a": "1"}`, true},
+		{"PropertyNames", `{"propertyNames": {"maxLength": 3}}`, `{"long": 1}`, false},
+		{"DependentRequired", `{"dependentRequired": {"card": ["cvv"]}}`, `{"card": "4111"}`, false},
+		{"AnyOf", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, false},
+		{"OneOfBoth", `{"oneOf": [{"minimum": 0}, {"maximum": 10}]}`, `5`, false},
+		{"Not", `{"not": {"type": "null"}}`, `null`, false},
+		{"IfThenElse", `{"if": {"properties": {"kind": {"const": "card"}}}, "then": {"required": ["number"]}, "else": {"required": ["iban"]}}`, `{"kind": "bank", "iban": "DE00"}`, true},
+		{"RecursiveRef", `{"type": "object", "properties": {"child": {"$ref": "#"}}, "required": ["id"]}`, `{"id": 1, "child": {"child": {}}}`, false},
+		{"FalseSchema", `false`, `{}`, false},
+		{"IgnoresAnnotations", `{"title": "Order", "format": "email", "type": "string"}`, `"not an email"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := router.SetSchema("msg", []byte(tt.schema)); err != nil {
+				t.Fatalf("Expected the schema to compile, got %v", err)
+			}
+			frame := `{"type":"msg"}`
+			if tt.payload != "" {
+				frame = `{"type":"msg","payload":` + tt.payload + `}`
+			}
+			err := router.Handle(client, []byte(frame))
+			if tt.valid && err != nil {
+				t.Errorf("Expected %s to be valid, got %v", tt.payload, err)
+			}
+			if !tt.valid && !errors.Is(err, ws.ErrInvalidPayload) {
+				t.Errorf("Expected %s to be invalid, got %v", tt.payload, err)
+			}
+		})
+	}
+}
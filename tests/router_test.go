@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestRouterDispatchesByType(t *testing.T) {
+	router := ws.NewRouter()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	var got ws.Envelope
+	router.HandleFunc("chat.send", func(c *ws.Client, env ws.Envelope) error {
+		got = env
+		return nil
+	})
+	router.HandleFunc("chat.typing", func(c *ws.Client, env ws.Envelope) error {
+		t.Error("Expected chat.typing handler not to run")
+		return nil
+	})
+
+	if err := router.Handle(client, []byte(`{"type":"chat.send","payload":{"text":"hi"}}`)); err != nil {
+		t.Fatalf("Expected dispatch to succeed, got %v", err)
+	}
+	if got.Type != "chat.send" || string(got.Payload) != `{"text":"hi"}` {
+		t.Errorf("Expected chat.send envelope, got %+v", got)
+	}
+	if got.ClientID != client.ID {
+		t.Errorf("Expected envelope client ID %s, got %s", client.ID, got.ClientID)
+	}
+}
+
+func TestRouterUnknownType(t *testing.T) {
+	router := ws.NewRouter()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	err := router.Handle(client, []byte(`{"type":"nope"}`))
+	if !errors.Is(err, ws.ErrUnknownMessageType) {
+		t.Errorf("Expected ErrUnknownMessageType, got %v", err)
+	}
+
+	var fallbackType string
+	router.Fallback(func(c *ws.Client, env ws.Envelope) error {
+		fallbackType = env.Type
+		return nil
+	})
+	if err := router.Handle(client, []byte(`{"type":"nope"}`)); err != nil {
+		t.Errorf("Expected fallback to handle unknown type, got %v", err)
+	}
+	if fallbackType != "nope" {
+		t.Errorf("Expected fallback to receive the envelope, got %q", fallbackType)
+	}
+}
+
+func TestRouterMalformedFrame(t *testing.T) {
+	router := ws.NewRouter()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+	router.Fallback(func(c *ws.Client, env ws.Envelope) error {
+		t.Error("Expected malformed frame not to reach the fallback")
+		return nil
+	})
+
+	for _, frame := range []string{"not json", `{"type":`, `["chat.send"]`} {
+		if err := router.Handle(client, []byte(frame)); !errors.Is(err, ws.ErrMalformedMessage) {
+			t.Errorf("Expected ErrMalformedMessage for %q, got %v", frame, err)
+		}
+	}
+}
+
+func TestRouterReceivesPersistedEnvelope(t *testing.T) {
+	router := ws.NewRouter()
+	persister := &mockEnvelopePersister{}
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, persister)
+	server := newTestServer(t, handler)
+
+	var mu sync.Mutex
+	var routed []ws.Envelope
+	router.HandleFunc("chat.send", func(c *ws.Client, env ws.Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+		routed = append(routed, env)
+		return nil
+	})
+
+	conn := dial(t, server)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat.send","payload":{}}`))
+
+	if !waitFor(t, 2*time.Second, func() bool { mu.Lock(); defer mu.Unlock(); return len(routed) == 1 }) {
+		t.Fatal("Expected envelope to be routed")
+	}
+	if saved := persister.saved(); len(saved) != 1 || saved[0].ID != routed[0].ID {
+		t.Error("Expected routed envelope to carry the persisted envelope ID")
+	}
+}
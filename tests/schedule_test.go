@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func newScheduleHandler(persister ws.EnvelopePersister, clock *fakeClock) *ws.WebsocketHandler {
+	return ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithClock(clock),
+		ws.WithScheduledDelivery(time.Second),
+	)
+}
+
+func TestSendAfterDeliversWhenDue(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	handler := newScheduleHandler(persister, clock)
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, newTestServer(t, handler), identityHeader(clientID))
+	registeredClient(t, handler)
+
+	id, err := handler.SendAfter(10*time.Second, clientID, ws.Envelope{Type: "reminder"})
+	if err != nil || id.IsZero() {
+		t.Fatalf("Expected an envelope ID, got %s, %v", id, err)
+	}
+
+	clock.Advance(9 * time.Second)
+	if ok, _ := persister.Exists(id); ok {
+		t.Fatal("Expected nothing sent before the envelope is due")
+	}
+	clock.Advance(time.Second)
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "reminder" {
+		t.Errorf("Expected the reminder once due, got %v", types)
+	}
+	if err := handler.CancelScheduled(id); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope cancelling a sent envelope, got %v", err)
+	}
+}
+
+func TestSendAtOfflineClientGoesToOutbox(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	handler := newScheduleHandler(persister, clock)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	id, err := handler.SendAt(clientID, ws.Envelope{Type: "reminder"}, clock.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	clock.Advance(time.Minute)
+
+	pending, _ := persister.FetchUndelivered(clientID)
+	if len(pending) != 1 || pending[0].ID != id || !pending[0].Timestamp.Equal(clock.Now()) {
+		t.Fatalf("Expected the envelope in the outbox stamped when it was sent, got %+v", pending)
+	}
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "reminder" {
+		t.Errorf("Expected the reminder to be replayed on connect, got %v", types)
+	}
+}
+
+func TestScheduledDeliveryDefaultTTLUsesHandlerClock(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, persister,
+		ws.WithClock(clock),
+		ws.WithScheduledDelivery(time.Second),
+		ws.WithDefaultEnvelopeTTL(time.Hour),
+	)
+	server := newTestServer(t, handler)
+	clientID := ws.NewIdentity()
+
+	handler.SendAfter(time.Second, clientID, ws.Envelope{Type: "reminder"})
+	clock.Advance(time.Second)
+	pending, _ := persister.FetchUndelivered(clientID)
+	if len(pending) != 1 || pending[0].ExpiresAt == nil || !pending[0].ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Expected the envelope to expire an hour after it was sent, got %+v", pending)
+	}
+
+	// Replay judges expiry by the handler's clock too.
+	conn, _ := dialWithResponse(t, server, identityHeader(clientID))
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "reminder" {
+		t.Errorf("Expected the unexpired reminder to be replayed, got %v", types)
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	handler := newScheduleHandler(persister, clock)
+	clientID := ws.NewIdentity()
+	conn, _ := dialWithResponse(t, newTestServer(t, handler), identityHeader(clientID))
+	registeredClient(t, handler)
+
+	cancelled, _ := handler.SendAfter(5*time.Second, clientID, ws.Envelope{Type: "cancelled"})
+	handler.SendAfter(5*time.Second, clientID, ws.Envelope{Type: "kept"})
+	if err := handler.CancelScheduled(cancelled); err != nil {
+		t.Fatalf("Failed to cancel: %v", err)
+	}
+	if err := handler.CancelScheduled(cancelled); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope cancelling twice, got %v", err)
+	}
+
+	clock.Advance(5 * time.Second)
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "kept" {
+		t.Errorf("Expected only the kept envelope, got %v", types)
+	}
+	if ok, _ := persister.Exists(cancelled); ok {
+		t.Error("Expected the cancelled envelope not to reach the outbox")
+	}
+}
+
+func TestScheduledDeliverySurvivesRestart(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	clientID := ws.NewIdentity()
+
+	first := newScheduleHandler(persister, clock)
+	if _, err := first.SendAfter(time.Hour, clientID, ws.Envelope{Type: "reminder"}); err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	if err := first.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	if clock.pending() != 0 {
+		t.Fatalf("Expected Shutdown to stop promotion, %d timers pending", clock.pending())
+	}
+
+	second := newScheduleHandler(persister, clock)
+	conn, _ := dialWithResponse(t, newTestServer(t, second), identityHeader(clientID))
+	registeredClient(t, second)
+
+	clock.Advance(time.Hour)
+	if types := readEnvelopeTypes(conn); len(types) != 1 || types[0] != "reminder" {
+		t.Errorf("Expected the restarted handler to send the reminder, got %v", types)
+	}
+}
+
+func TestScheduledDeliveryRecoversClaimsOfStoppedHandler(t *testing.T) {
+	clock := newFakeClock()
+	persister := ws.NewMemoryPersister()
+	clientID := ws.NewIdentity()
+
+	first := newScheduleHandler(persister, clock)
+	id, _ := first.SendAfter(time.Second, clientID, ws.Envelope{Type: "reminder"})
+	first.Shutdown(context.Background())
+	// A handler claims the envelope and stops before saving it.
+	clock.Advance(time.Second)
+	if due, _ := persister.FetchDue(clock.Now(), time.Minute); len(due) != 1 {
+		t.Fatalf("Expected the envelope claimed, got %+v", due)
+	}
+
+	second := newScheduleHandler(persister, clock)
+	defer second.Shutdown(context.Background())
+	clock.Advance(time.Second)
+	if ok, _ := persister.Exists(id); ok {
+		t.Fatal("Expected the claimed envelope left alone while the claim holds")
+	}
+	if err := second.CancelScheduled(id); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope cancelling a claimed envelope, got %v", err)
+	}
+	clock.Advance(time.Minute)
+	if ok, _ := persister.Exists(id); !ok {
+		t.Error("Expected the envelope sent once the claim lapsed")
+	}
+	if err := second.CancelScheduled(id); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected the sent envelope gone from the schedule, got %v", err)
+	}
+}
+
+func TestScheduledDeliveryRetriesFailedSave(t *testing.T) {
+	clock := newFakeClock()
+	failing := &failingSavePersister{MemoryPersister: ws.NewMemoryPersister(), failures: 1}
+	handler := newScheduleHandler(failing, clock)
+	clientID := ws.NewIdentity()
+
+	id, _ := handler.SendAfter(time.Second, clientID, ws.Envelope{Type: "reminder"})
+	clock.Advance(time.Second)
+	if ok, _ := failing.Exists(id); ok {
+		t.Fatal("Expected the failed save to leave the outbox empty")
+	}
+	clock.Advance(time.Second)
+	if ok, _ := failing.Exists(id); !ok {
+		t.Error("Expected the envelope saved on the next promotion")
+	}
+}
+
+func TestScheduledDeliveryThroughBatchingPersister(t *testing.T) {
+	clock := newFakeClock()
+	inner := ws.NewMemoryPersister()
+	batching := ws.NewBatchingPersister(inner, ws.WithBatchFlushInterval(time.Hour), ws.WithBatchClock(clock))
+	handler := newScheduleHandler(batching, clock)
+	clientID := ws.NewIdentity()
+
+	id, err := handler.SendAfter(time.Second, clientID, ws.Envelope{Type: "reminder"})
+	if err != nil {
+		t.Fatalf("Expected the batching persister to accept the schedule, got %v", err)
+	}
+	cancelled, _ := handler.SendAfter(time.Second, clientID, ws.Envelope{Type: "cancelled"})
+	if err := handler.CancelScheduled(cancelled); err != nil {
+		t.Fatalf("Failed to cancel: %v", err)
+	}
+	clock.Advance(time.Second)
+	batching.Flush()
+
+	pending, _ := inner.FetchUndelivered(clientID)
+	if len(pending) != 1 || pending[0].ID != id {
+		t.Errorf("Expected the reminder promoted through the batching persister, got %+v", pending)
+	}
+
+	unsupported := newScheduleHandler(ws.NewBatchingPersister(&mockEnvelopePersister{}), clock)
+	if _, err := unsupported.SendAt(clientID, ws.Envelope{Type: "reminder"}, clock.Now()); err != ws.ErrPersisterUnsupported {
+		t.Errorf("Expected ErrPersisterUnsupported without a scheduler underneath, got %v", err)
+	}
+}
+
+func TestSendAtRequiresScheduledDelivery(t *testing.T) {
+	for name, handler := range map[string]*ws.WebsocketHandler{
+		"no option":    ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, ws.NewMemoryPersister()),
+		"no scheduler": ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, ws.WithScheduledDelivery(time.Second)),
+	} {
+		if _, err := handler.SendAt(ws.NewIdentity(), ws.Envelope{Type: "reminder"}, time.Now()); err != ws.ErrSchedulingDisabled {
+			t.Errorf("%s: expected ErrSchedulingDisabled, got %v", name, err)
+		}
+		if err := handler.CancelScheduled(ws.NewIdentity()); err != ws.ErrSchedulingDisabled {
+			t.Errorf("%s: expected ErrSchedulingDisabled cancelling, got %v", name, err)
+		}
+	}
+}
+
+// failingSavePersister fails the first failures outbox saves.
+type failingSavePersister struct {
+	*ws.MemoryPersister
+	failures int
+}
+
+func (p *failingSavePersister) SaveEnvelope(e ws.Envelope) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("save failed")
+	}
+	return p.MemoryPersister.SaveEnvelope(e)
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestSendJSONDeliversMarshalledValue(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	server := newTestServer(t, handler)
+	conn := connect(t, server)
+	client := registeredClient(t, handler)
+
+	if err := client.SendJSON(map[string]string{"type": "greeting"}); err != nil {
+		t.Fatalf("Expected SendJSON to succeed, got %v", err)
+	}
+
+	var got map[string]string
+	if conn.ExpectJSON(&got, 2*time.Second); got["type"] != "greeting" {
+		t.Errorf("Expected greeting, got %v", got)
+	}
+}
+
+func TestSendJSONReturnsMarshalError(t *testing.T) {
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	err := client.SendJSON(make(chan int))
+	var unsupported *json.UnsupportedTypeError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("Expected a marshal error, got %v", err)
+	}
+}
+
+func TestSendHelpersOnFullQueue(t *testing.T) {
+	client := ws.NewClient(ws.NewIdentity(), nil)
+
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = client.SendJSON(i)
+	}
+	if !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: client.ID, Type: "notification"}
+	if err := client.SendEnvelope(envelope); !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Errorf("Expected ErrSendQueueFull from SendEnvelope, got %v", err)
+	}
+}
+
+func TestSendHelpersOnClosedClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+	client := registeredClient(t, handler)
+
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected client to be unregistered")
+	}
+
+	if err := client.SendJSON("late"); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected from SendJSON, got %v", err)
+	}
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: client.ID}
+	if err := client.SendEnvelope(envelope); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected from SendEnvelope, got %v", err)
+	}
+}
+
+func TestClientSendEnvelopeAwaitsAck(t *testing.T) {
+	f, connect := newAckFixture(t)
+	clientID := ws.NewIdentity()
+	conn := connect(clientID)
+	client, _ := f.handler.Get(clientID)
+
+	envelope := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "notification"}
+	if err := client.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+	sendAck(conn, readEnvelopeID(t, conn))
+
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.persister.confirmedIDs()) == 1 }) {
+		t.Fatal("Expected ack to confirm delivery")
+	}
+}
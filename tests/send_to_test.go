@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestSendToConnectedClient(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	if err := handler.SendTo(clientID, []byte("notification")); err != nil {
+		t.Fatalf("Expected SendTo to succeed, got %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "notification" {
+		t.Errorf("Expected notification, got %q (%v)", data, err)
+	}
+}
+
+func TestSendToDisconnectedClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+
+	if err := handler.SendTo(ws.NewIdentity(), []byte("notification")); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+	if err := handler.Hub.SendEnvelope(ws.Envelope{ClientID: ws.NewIdentity()}); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
+
+func TestSendToFullQueue(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	stalled := ws.NewClient(ws.NewIdentity(), nil)
+	handler.Register(stalled)
+
+	var err error
+	for i := 0; i < 257 && err == nil; i++ {
+		err = handler.SendTo(stalled.ID, []byte("filler"))
+	}
+	if !errors.Is(err, ws.ErrSendQueueFull) {
+		t.Errorf("Expected ErrSendQueueFull, got %v", err)
+	}
+}
+
+func TestSendEnvelopeIncludesEnvelopeID(t *testing.T) {
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+
+	envelope := ws.Envelope{
+		ID:        ws.NewIdentity(),
+		ClientID:  clientID,
+		Type:      "notification",
+		Payload:   json.RawMessage(`{"text":"hello"}`),
+		Timestamp: time.Now(),
+	}
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("Expected SendEnvelope to succeed, got %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected envelope frame, got %v", err)
+	}
+	var received struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &received); err != nil || received.Type != "notification" {
+		t.Errorf("Expected notification envelope, got %q (%v)", data, err)
+	}
+	if received.ID != envelope.ID.String() {
+		t.Errorf("Expected envelope ID %s on the wire, got %q", envelope.ID, received.ID)
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// readSequenced reads a JSON frame and returns its n and seq members.
+func readSequenced(t *testing.T, conn *websocket.Conn) (n int, seq uint64) {
+	t.Helper()
+	return parseSequenced(t, readText(t, conn))
+}
+
+func parseSequenced(t *testing.T, data string) (n int, seq uint64) {
+	t.Helper()
+	var frame struct {
+		N   int    `json:"n"`
+		Seq uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal([]byte(data), &frame); err != nil {
+		t.Fatalf("Expected a JSON frame, got %v", err)
+	}
+	return frame.N, frame.Seq
+}
+
+// dropOnce returns an interceptor dropping the first copy of the frames
+// numbered seqs.
+func dropOnce(seqs ...uint64) ws.OutboundInterceptor {
+	var mu sync.Mutex
+	pending := make(map[uint64]bool)
+	for _, seq := range seqs {
+		pending[seq] = true
+	}
+	return func(client *ws.Client, data []byte) ([]byte, error) {
+		var frame struct {
+			Seq uint64 `json:"seq"`
+		}
+		json.Unmarshal(data, &frame)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if pending[frame.Seq] {
+			delete(pending, frame.Seq)
+			return nil, fmt.Errorf("dropped frame %d", frame.Seq)
+		}
+		return data, nil
+	}
+}
+
+func broadcastNumbered(handler *ws.WebsocketHandler, from, to int) {
+	for n := from; n <= to; n++ {
+		handler.Broadcast([]byte(fmt.Sprintf(`{"n":%d}`, n)))
+	}
+}
+
+func TestSequencingNumbersFrames(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil, ws.WithSequencing(8))
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	handler.Broadcast([]byte(`{"n":1}`))
+	client.SendBinary([]byte("binary"))
+	client.SendJSON(map[string]int{"n": 2})
+	client.SendJSON("not an object")
+	client.SendError(ws.ErrorCodeUnauthorized, "no", "")
+
+	if n, seq := readSequenced(t, conn); n != 1 || seq != 1 {
+		t.Errorf("Expected frame 1 numbered 1, got frame %d numbered %d", n, seq)
+	}
+	if messageType, data, err := conn.ReadMessage(); err != nil || messageType != websocket.BinaryMessage || string(data) != "binary" {
+		t.Errorf("Expected the binary frame unchanged, got %q, %v", data, err)
+	}
+	if n, seq := readSequenced(t, conn); n != 2 || seq != 2 {
+		t.Errorf("Expected frame 2 numbered 2, got frame %d numbered %d", n, seq)
+	}
+	if got := readText(t, conn); got != `"not an object"` {
+		t.Errorf("Expected a frame that is not an object unchanged, got %s", got)
+	}
+	data := readText(t, conn)
+	var frame ws.ErrorFrame
+	json.Unmarshal([]byte(data), &frame)
+	if _, seq := parseSequenced(t, data); frame.Code != ws.ErrorCodeUnauthorized || seq != 3 {
+		t.Errorf("Expected the error frame numbered 3, got %s", data)
+	}
+	if client.Seq() != 3 {
+		t.Errorf("Expected Seq 3, got %d", client.Seq())
+	}
+}
+
+func TestSequencingSkipsAcks(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil, ws.WithSequencing(8))
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteJSON(map[string]any{"id": ws.NewIdentity().String(), "type": "chat", "payload": "hi"})
+	var ack map[string]any
+	json.Unmarshal([]byte(readText(t, conn)), &ack)
+	if ack["type"] != ws.AckMessageType {
+		t.Fatalf("Expected an ack, got %v", ack)
+	}
+	if _, ok := ack["seq"]; ok {
+		t.Errorf("Expected the ack not to be numbered, got %v", ack)
+	}
+}
+
+func TestResyncFillsGap(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil, ws.WithSequencing(8))
+	handler.UseOutbound(dropOnce(2, 3))
+	conn := dial(t, newTestServer(t, handler))
+	registeredClient(t, handler)
+
+	broadcastNumbered(handler, 1, 5)
+
+	var seen []uint64
+	for range 3 {
+		_, seq := readSequenced(t, conn)
+		seen = append(seen, seq)
+	}
+	if fmt.Sprint(seen) != "[1 4 5]" {
+		t.Fatalf("Expected frames 2 and 3 dropped, got %v", seen)
+	}
+
+	conn.WriteJSON(map[string]any{"type": ws.ResyncMessageType, "from": 2})
+	for want := uint64(2); want <= 5; want++ {
+		if n, seq := readSequenced(t, conn); seq != want || n != int(want) {
+			t.Errorf("Expected frame %d replayed, got frame %d numbered %d", want, n, seq)
+		}
+	}
+
+	broadcastNumbered(handler, 6, 6)
+	if _, seq := readSequenced(t, conn); seq != 6 {
+		t.Errorf("Expected numbering to carry on at 6, got %d", seq)
+	}
+}
+
+func TestResyncBeyondReplayBuffer(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil, ws.WithSequencing(2))
+	conn := dial(t, newTestServer(t, handler))
+	registeredClient(t, handler)
+
+	broadcastNumbered(handler, 1, 5)
+	for range 5 {
+		readSequenced(t, conn)
+	}
+
+	conn.WriteJSON(map[string]any{"type": ws.ResyncMessageType, "from": 3})
+	frame := readErrorFrame(t, conn)
+	if frame.Code != ws.ErrorCodeSnapshotRequired {
+		t.Errorf("Expected %s, got %+v", ws.ErrorCodeSnapshotRequired, frame)
+	}
+
+	conn.WriteJSON(map[string]any{"type": ws.ResyncMessageType, "from": 4})
+	for want := uint64(4); want <= 5; want++ {
+		if _, seq := readSequenced(t, conn); seq != want {
+			t.Errorf("Expected frame %d replayed from the buffer, got %d", want, seq)
+		}
+	}
+}
+
+func TestSequencingRestartsOnReconnect(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, nil, ws.WithSequencing(8))
+	server := newTestServer(t, handler)
+	id := ws.NewIdentity()
+
+	first, _ := dialWithResponse(t, server, identityHeader(id))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 1 }) {
+		t.Fatal("Expected the client to be registered")
+	}
+	broadcastNumbered(handler, 1, 2)
+	for range 2 {
+		readSequenced(t, first)
+	}
+	first.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected the client to be unregistered")
+	}
+
+	second, _ := dialWithResponse(t, server, identityHeader(id))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 1 }) {
+		t.Fatal("Expected the client to be registered again")
+	}
+	broadcastNumbered(handler, 3, 3)
+	if n, seq := readSequenced(t, second); n != 3 || seq != 1 {
+		t.Errorf("Expected the new connection numbered from 1, got frame %d numbered %d", n, seq)
+	}
+
+	second.WriteJSON(map[string]any{"type": ws.ResyncMessageType, "from": 1})
+	if _, seq := readSequenced(t, second); seq != 1 {
+		t.Errorf("Expected only the new connection's frame replayed, got %d", seq)
+	}
+}
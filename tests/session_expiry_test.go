@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// refreshingValidator is a session validator that can also refresh expired
+// sessions, recording each refresh.
+type refreshingValidator struct {
+	mockSessionValidator
+
+	mu        sync.Mutex
+	refreshed int
+	refresh   func(session ws.SessionInfo) (ws.SessionInfo, error)
+}
+
+func (v *refreshingValidator) RefreshSession(client *ws.Client, session ws.SessionInfo) (ws.SessionInfo, error) {
+	v.mu.Lock()
+	v.refreshed++
+	v.mu.Unlock()
+	return v.refresh(session)
+}
+
+func (v *refreshingValidator) refreshes() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.refreshed
+}
+
+func expiringSession(clock *fakeClock, ttl time.Duration) ws.SessionInfo {
+	return ws.SessionInfo{ClientID: ws.NewIdentity(), ExpiresAt: clock.Now().Add(ttl)}
+}
+
+func TestSessionExpiryClosesConnection(t *testing.T) {
+	clock := newFakeClock()
+	validator := &mockSessionValidator{session: expiringSession(clock, time.Minute)}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, ws.WithClock(clock))
+	conn := dial(t, newTestServer(t, handler))
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected an expiry check to be scheduled")
+	}
+	clock.Advance(59 * time.Second)
+	if !handler.IsOnline(validator.session.ClientID) {
+		t.Fatal("Expected the connection to stay open before the session expires")
+	}
+	go clock.Advance(time.Second)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ws.CloseSessionExpired || closeErr.Text != "session expired" {
+		t.Errorf("Expected close %d \"session expired\", got %v", ws.CloseSessionExpired, err)
+	}
+}
+
+func TestSessionExpiryRefreshes(t *testing.T) {
+	clock := newFakeClock()
+	validator := &refreshingValidator{}
+	validator.session = expiringSession(clock, time.Minute)
+	validator.refresh = func(session ws.SessionInfo) (ws.SessionInfo, error) {
+		session.ExpiresAt = clock.Now().Add(time.Hour)
+		session.Metadata = map[string]string{"role": "member"}
+		return session, nil
+	}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, ws.WithClock(clock))
+	dial(t, newTestServer(t, handler))
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected an expiry check to be scheduled")
+	}
+	clock.Advance(time.Minute)
+
+	if validator.refreshes() != 1 {
+		t.Fatalf("Expected one refresh, got %d", validator.refreshes())
+	}
+	client, ok := handler.Get(validator.session.ClientID)
+	if !ok {
+		t.Fatal("Expected the refreshed connection to stay open")
+	}
+	if got := client.Session().ExpiresAt; !got.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("Expected the session to be extended by an hour, got %v", got)
+	}
+	if role, _ := client.Get("role"); role != "member" {
+		t.Errorf("Expected refreshed metadata on the client, got %v", role)
+	}
+	if clock.pending() != 1 {
+		t.Errorf("Expected the next expiry check to be scheduled, got %d", clock.pending())
+	}
+}
+
+func TestSessionExpiryRefreshFailureCloses(t *testing.T) {
+	clock := newFakeClock()
+	validator := &refreshingValidator{}
+	validator.session = expiringSession(clock, time.Minute)
+	validator.refresh = func(session ws.SessionInfo) (ws.SessionInfo, error) {
+		return ws.SessionInfo{}, errors.New("session revoked")
+	}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, ws.WithClock(clock))
+	conn := dial(t, newTestServer(t, handler))
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected an expiry check to be scheduled")
+	}
+	go clock.Advance(time.Minute)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, ws.CloseSessionExpired) {
+		t.Errorf("Expected close %d after a failed refresh, got %v", ws.CloseSessionExpired, err)
+	}
+}
+
+func TestSessionExpiryTimerStopsOnDisconnect(t *testing.T) {
+	clock := newFakeClock()
+	validator := &mockSessionValidator{session: expiringSession(clock, time.Hour)}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, ws.WithClock(clock))
+	conn := dial(t, newTestServer(t, handler))
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 1 }) {
+		t.Fatal("Expected an expiry check to be scheduled")
+	}
+	conn.Close()
+
+	if !waitFor(t, 2*time.Second, func() bool { return clock.pending() == 0 }) {
+		t.Error("Expected the expiry check to be cancelled when the client disconnects")
+	}
+}
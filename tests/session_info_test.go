@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestSessionInfoZeroValue(t *testing.T) {
+	var session ws.SessionInfo
+	if session.HasRole("") || session.HasRole("admin") {
+		t.Error("Expected the zero session to have no roles")
+	}
+	if _, ok := session.ClaimString("sub"); ok {
+		t.Error("Expected the zero session to have no claims")
+	}
+	if _, ok := session.ClaimInt("exp"); ok {
+		t.Error("Expected the zero session to have no claims")
+	}
+	if !session.ExpiresAt.IsZero() {
+		t.Error("Expected the zero session never to expire")
+	}
+}
+
+func TestSessionInfoHasRole(t *testing.T) {
+	session := ws.SessionInfo{Roles: []string{"reader", "editor"}}
+	for role, want := range map[string]bool{"reader": true, "editor": true, "admin": false, "Editor": false} {
+		if got := session.HasRole(role); got != want {
+			t.Errorf("HasRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestSessionInfoClaimString(t *testing.T) {
+	session := ws.SessionInfo{Claims: map[string]any{
+		"sub":   "user-1",
+		"raw":   []byte("bytes"),
+		"named": stringer("stringer"),
+		"count": 3,
+	}}
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"sub", "user-1", true},
+		{"raw", "bytes", true},
+		{"named", "stringer", true},
+		{"count", "", false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := session.ClaimString(tt.key); got != tt.want || ok != tt.ok {
+			t.Errorf("ClaimString(%q) = %q, %v; want %q, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSessionInfoClaimInt(t *testing.T) {
+	var decoded map[string]any
+	json.Unmarshal([]byte(`{"exp": 1700000000, "ratio": 0.5}`), &decoded)
+
+	tests := []struct {
+		name  string
+		claim any
+		want  int64
+		ok    bool
+	}{
+		{"int", 42, 42, true},
+		{"int32", int32(-7), -7, true},
+		{"uint8", uint8(200), 200, true},
+		{"uint64 in range", uint64(1 << 40), 1 << 40, true},
+		{"uint64 overflow", uint64(math.MaxUint64), 0, false},
+		{"whole float from JSON", decoded["exp"], 1700000000, true},
+		{"fractional float from JSON", decoded["ratio"], 0, false},
+		{"float overflow", math.MaxFloat64, 0, false},
+		{"json.Number", json.Number("12"), 12, true},
+		{"fractional json.Number", json.Number("1.5"), 0, false},
+		{"numeric string", "99", 99, true},
+		{"non-numeric string", "ninety", 0, false},
+		{"bool", true, 0, false},
+		{"nil", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := ws.SessionInfo{Claims: map[string]any{"k": tt.claim}}
+			got, ok := session.ClaimInt("k")
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("ClaimInt = %d, %v; want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSessionInfoReachesClient(t *testing.T) {
+	session := ws.SessionInfo{
+		ClientID:  ws.NewIdentity(),
+		Roles:     []string{"admin"},
+		Claims:    map[string]any{"tenant": "acme", "level": 3.0},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	seen := make(chan ws.SessionInfo, 1)
+	router := ws.NewRouter()
+	router.HandleFunc("whoami", func(client *ws.Client, env ws.Envelope) error {
+		if client.HasRole("admin") {
+			seen <- client.Session()
+		}
+		return nil
+	})
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{session: session}, router, &mockEnvelopePersister{})
+	conn := dial(t, newTestServer(t, handler))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"whoami","payload":{}}`))
+	select {
+	case got := <-seen:
+		if tenant, _ := got.ClaimString("tenant"); tenant != "acme" {
+			t.Errorf("Expected the tenant claim, got %q", tenant)
+		}
+		if level, _ := got.ClaimInt("level"); level != 3 {
+			t.Errorf("Expected the level claim, got %d", level)
+		}
+		if !got.ExpiresAt.Equal(session.ExpiresAt) || got.ClientID != session.ClientID {
+			t.Errorf("Expected the validated session, got %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the handler to see the admin role")
+	}
+}
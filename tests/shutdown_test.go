@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestShutdownSendsGoingAwayToClients(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	conns := []*websocket.Conn{dial(t, server), dial(t, server), dial(t, server)}
+
+	closeErrs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn *websocket.Conn) {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := conn.ReadMessage()
+			closeErrs <- err
+		}(conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := handler.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+
+	for range conns {
+		err := <-closeErrs
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("Expected going away close frame, got %v", err)
+		}
+	}
+}
+
+func TestShutdownRejectsNewConnections(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	if err := handler.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+	if err == nil {
+		t.Fatal("Expected dial to fail after shutdown")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %v", http.StatusServiceUnavailable, resp)
+	}
+}
+
+func TestShutdownForceClosesStragglers(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	// The client never reads, so it never answers the close frame.
+	dial(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := handler.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected shutdown to respect the context deadline, took %v", elapsed)
+	}
+
+	if err := handler.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected all client goroutines to have exited, got %v", err)
+	}
+}
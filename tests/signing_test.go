@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/msgpack"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+var (
+	signingKey = []byte("signing-key-one")
+	rotatedKey = []byte("signing-key-two")
+)
+
+// signedFrame is the wire form of an envelope a client signed.
+func signedFrame(e ws.Envelope) map[string]any {
+	return map[string]any{
+		"id":        e.ID.String(),
+		"type":      e.Type,
+		"payload":   e.Payload,
+		"timestamp": e.Timestamp,
+		"kid":       e.KeyID,
+		"signature": e.Signature,
+	}
+}
+
+func TestEnvelopeSignAndVerify(t *testing.T) {
+	envelope, _ := ws.NewEnvelope(ws.NewIdentity(), "order.place", map[string]any{"sku": "A-1", "qty": 2})
+	envelope.Sign(signingKey)
+	if envelope.Signature == "" || !envelope.Verify(signingKey) {
+		t.Fatalf("Expected the envelope to verify, got %+v", envelope)
+	}
+	if envelope.Verify(rotatedKey) {
+		t.Error("Expected a wrong key not to verify")
+	}
+
+	// Whitespace and key order are not part of the signature.
+	reordered := envelope
+	reordered.Payload = json.RawMessage(`{ "qty": 2, "sku": "A-1" }`)
+	if !reordered.Verify(signingKey) {
+		t.Error("Expected an equivalent payload to verify")
+	}
+	// Fields outside the signature may change.
+	envelope.Room = "lobby"
+	if !envelope.Verify(signingKey) {
+		t.Error("Expected unsigned fields not to matter")
+	}
+
+	tampered := map[string]func(e *ws.Envelope){
+		"Payload":   func(e *ws.Envelope) { e.Payload = json.RawMessage(`{"qty":200,"sku":"A-1"}`) },
+		"Type":      func(e *ws.Envelope) { e.Type = "order.cancel" },
+		"ID":        func(e *ws.Envelope) { e.ID = ws.NewIdentity() },
+		"ClientID":  func(e *ws.Envelope) { e.ClientID = ws.NewIdentity() },
+		"Timestamp": func(e *ws.Envelope) { e.Timestamp = e.Timestamp.Add(time.Second) },
+		"Signature": func(e *ws.Envelope) { e.Signature = "bm90IGEgc2lnbmF0dXJl" },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			changed := envelope
+			tamper(&changed)
+			if changed.Verify(signingKey) {
+				t.Errorf("Expected a tampered %s not to verify", name)
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	ring := ws.NewKeyRing("k1", signingKey)
+	old, _ := ws.NewEnvelope(ws.NewIdentity(), "chat", "before rotation")
+	ring.Sign(&old)
+	if old.KeyID != "k1" {
+		t.Fatalf("Expected the current key ID, got %q", old.KeyID)
+	}
+
+	ring.Rotate("k2", rotatedKey)
+	current, _ := ws.NewEnvelope(ws.NewIdentity(), "chat", "after rotation")
+	ring.Sign(&current)
+	if current.KeyID != "k2" || !current.Verify(rotatedKey) {
+		t.Fatalf("Expected the rotated key to sign, got %+v", current)
+	}
+	if err := ring.Verify(old); err != nil {
+		t.Errorf("Expected the previous key to keep verifying, got %v", err)
+	}
+	if err := ring.Verify(current); err != nil {
+		t.Errorf("Expected the current key to verify, got %v", err)
+	}
+
+	ring.Remove("k1")
+	if err := ring.Verify(old); !errors.Is(err, ws.ErrInvalidSignature) {
+		t.Errorf("Expected a removed key not to verify, got %v", err)
+	}
+	ring.Remove("k2")
+	if err := ring.Verify(current); err != nil {
+		t.Errorf("Expected the current key not to be removable, got %v", err)
+	}
+
+	forged := current
+	forged.KeyID = "k3"
+	if err := ring.Verify(forged); !errors.Is(err, ws.ErrInvalidSignature) {
+		t.Errorf("Expected an unknown key ID to fail, got %v", err)
+	}
+	unsigned, _ := ws.NewEnvelope(ws.NewIdentity(), "chat", nil)
+	if err := ring.Verify(unsigned); !errors.Is(err, ws.ErrInvalidSignature) {
+		t.Errorf("Expected an unsigned envelope to fail, got %v", err)
+	}
+}
+
+func TestOutboundEnvelopesAreSigned(t *testing.T) {
+	ring := ws.NewKeyRing("k1", signingKey)
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithEnvelopeSigning(ring))
+	server := newTestServer(t, handler)
+	id := ws.NewIdentity()
+	conn, _ := connectAs(t, handler, server, id)
+
+	envelope, _ := ws.NewEnvelope(id, "notice", map[string]string{"text": "hello"})
+	if err := handler.SendEnvelope(envelope); err != nil {
+		t.Fatalf("SendEnvelope failed: %v", err)
+	}
+	got := conn.ExpectEnvelope("notice", 2*time.Second)
+	if got.KeyID != "k1" || !got.Verify(signingKey) {
+		t.Fatalf("Expected a signature the client can verify, got %+v", got)
+	}
+	if got.Verify(rotatedKey) {
+		t.Error("Expected the signature not to verify under another key")
+	}
+	got.Payload = json.RawMessage(`{"text":"goodbye"}`)
+	if got.Verify(signingKey) {
+		t.Error("Expected a tampered payload not to verify")
+	}
+
+	// Broadcast copies are signed as addressed to each member.
+	ring.Rotate("k2", rotatedKey)
+	client, _ := handler.Get(id)
+	handler.Join("lobby", client)
+	broadcast, _ := ws.NewEnvelope(ws.Identity{}, "chat", "hi all")
+	handler.BroadcastEnvelopeToRoom("lobby", broadcast)
+	got = conn.ExpectEnvelope("chat", 2*time.Second)
+	if got.ClientID != id || got.KeyID != "k2" || !got.Verify(rotatedKey) {
+		t.Errorf("Expected the member's copy to be signed with the rotated key, got %+v", got)
+	}
+}
+
+func TestSignedEnvelopesOverMessagePack(t *testing.T) {
+	ring := ws.NewKeyRing("k1", signingKey)
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithCodec(msgpack.Codec{}),
+		ws.WithEnvelopeSigning(ring))
+	server := newTestServer(t, handler)
+	id := ws.NewIdentity()
+	conn, _ := connectAs(t, handler, server, id)
+
+	envelope, _ := ws.NewEnvelope(id, "notice", map[string]any{"b": 1, "a": []int{1, 2}})
+	handler.SendEnvelope(envelope)
+	_, data, err := conn.Conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	got, err := msgpack.Codec{}.Unmarshal(data, websocket.BinaryMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if err := ring.Verify(got); err != nil {
+		t.Errorf("Expected the re-encoded payload to verify, got %v", err)
+	}
+}
+
+type signatureFailures struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (f *signatureFailures) record(client *ws.Client, envelope ws.Envelope, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, err)
+}
+
+func (f *signatureFailures) list() []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]error(nil), f.errors...)
+}
+
+func TestInboundSignatureVerification(t *testing.T) {
+	ring := ws.NewKeyRing("k1", signingKey)
+	messages := &mockMessageHandler{}
+	failures := &signatureFailures{}
+	var observed []error
+	var mu sync.Mutex
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, messages, &mockEnvelopePersister{},
+		ws.WithEnvelopeVerification(ring),
+		ws.WithOnSignatureFailure(failures.record),
+		ws.WithObserver(ws.Observer{OnError: func(client *ws.Client, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			observed = append(observed, err)
+		}}),
+	)
+	server := newTestServer(t, handler)
+	id := ws.NewIdentity()
+	conn, _ := connectAs(t, handler, server, id)
+
+	sign := func(key []byte, kid string) ws.Envelope {
+		envelope, _ := ws.NewEnvelope(id, "chat", map[string]string{"text": "hello"})
+		envelope.KeyID = kid
+		envelope.Sign(key)
+		return envelope
+	}
+	expectRejected := func(t *testing.T, conn *wstest.Conn, envelope ws.Envelope) {
+		t.Helper()
+		conn.MustSendJSON(signedFrame(envelope))
+		if frame := expectRoomError(t, conn, envelope.ID); frame.Code != "invalid_signature" {
+			t.Errorf("Expected an invalid_signature error frame, got %+v", frame)
+		}
+	}
+
+	accepted := sign(signingKey, "k1")
+	conn.MustSendJSON(signedFrame(accepted))
+	if ack := conn.ExpectEnvelope(ws.AckMessageType, 2*time.Second); ack.ID != accepted.ID {
+		t.Fatalf("Expected the signed envelope to be acked, got %+v", ack)
+	}
+
+	tampered := sign(signingKey, "k1")
+	tampered.Payload = json.RawMessage(`{"text":"hijacked"}`)
+	expectRejected(t, conn, tampered)
+	expectRejected(t, conn, sign(rotatedKey, "k1"))
+	expectRejected(t, conn, sign(signingKey, "unknown"))
+	unsigned, _ := ws.NewEnvelope(id, "chat", nil)
+	expectRejected(t, conn, unsigned)
+
+	// Another identity's signed envelope does not verify for this one.
+	other, _ := ws.NewEnvelope(ws.NewIdentity(), "chat", nil)
+	other.KeyID = "k1"
+	other.Sign(signingKey)
+	expectRejected(t, conn, other)
+
+	// Envelopes signed with the previous key still verify after rotation.
+	previous := sign(signingKey, "k1")
+	ring.Rotate("k2", rotatedKey)
+	conn.MustSendJSON(signedFrame(previous))
+	conn.MustSendJSON(signedFrame(sign(rotatedKey, "k2")))
+	if !waitFor(t, 2*time.Second, func() bool { return len(messages.received()) == 3 }) {
+		t.Fatalf("Expected both keys to verify after rotation, got %d messages", len(messages.received()))
+	}
+
+	if got := failures.list(); len(got) != 5 {
+		t.Fatalf("Expected five audited failures, got %v", got)
+	}
+	for _, err := range failures.list() {
+		if !errors.Is(err, ws.ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(observed) != 5 {
+		t.Errorf("Expected the failures to reach observers, got %v", observed)
+	}
+}
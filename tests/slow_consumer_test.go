@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// stallClient connects a client that does not read and queues enough data to
+// wedge its write pump, then queues count small numbered messages on top.
+func stallClient(t *testing.T, policy ws.SlowConsumerPolicy, override bool, count int) (*websocket.Conn, *ws.Client) {
+	t.Helper()
+
+	clientID := ws.NewIdentity()
+	validator := &mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}}
+	opts := []ws.Option{ws.WithSlowConsumerPolicy(policy)}
+	if override {
+		opts = []ws.Option{ws.WithSlowConsumerPolicy(ws.SlowConsumerDropNewest)}
+	}
+	handler := ws.NewWebSocketHandler(validator, &mockMessageHandler{}, &mockEnvelopePersister{}, opts...)
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(clientID); return ok }) {
+		t.Fatal("Expected client to be registered")
+	}
+	client, _ := handler.Get(clientID)
+	if override {
+		client.SetSlowConsumerPolicy(policy)
+	}
+
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	for i := 0; i < 20; i++ {
+		handler.SendTo(clientID, big)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < count; i++ {
+		handler.SendTo(clientID, []byte(fmt.Sprintf("%d", i)))
+	}
+	return conn, client
+}
+
+// drain reads until the connection goes quiet or closes and returns the small
+// messages received along with the terminal error.
+func drain(conn *websocket.Conn) ([]string, error) {
+	var small []string
+	for {
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return small, err
+		}
+		if len(data) < 1024 {
+			small = append(small, string(data))
+		}
+	}
+}
+
+func TestSlowConsumerDropNewest(t *testing.T) {
+	conn, client := stallClient(t, ws.SlowConsumerDropNewest, false, 400)
+
+	small, err := drain(conn)
+	if !isTimeout(err) {
+		t.Fatalf("Expected connection to stay open, got %v", err)
+	}
+	if len(small) == 0 || small[0] != "0" {
+		t.Fatalf("Expected the oldest messages to be kept, got %v", small)
+	}
+	if small[len(small)-1] == "399" {
+		t.Error("Expected the newest messages to be dropped")
+	}
+	if client.Dropped() == 0 {
+		t.Error("Expected dropped counter to move")
+	}
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	conn, client := stallClient(t, ws.SlowConsumerDropOldest, false, 400)
+
+	small, err := drain(conn)
+	if !isTimeout(err) {
+		t.Fatalf("Expected connection to stay open, got %v", err)
+	}
+	if len(small) == 0 || small[len(small)-1] != "399" {
+		t.Fatalf("Expected the newest message to be kept, got %v", small)
+	}
+	if small[0] == "0" {
+		t.Error("Expected the oldest messages to be dropped")
+	}
+	if client.Dropped() == 0 {
+		t.Error("Expected dropped counter to move")
+	}
+}
+
+func TestSlowConsumerClose(t *testing.T) {
+	conn, client := stallClient(t, ws.SlowConsumerClose, false, 400)
+
+	_, err := drain(conn)
+	if err == nil || isTimeout(err) {
+		t.Fatalf("Expected slow consumer to be disconnected, got %v", err)
+	}
+	if client.Dropped() == 0 {
+		t.Error("Expected dropped counter to move")
+	}
+}
+
+func TestSlowConsumerPolicyOverride(t *testing.T) {
+	conn, client := stallClient(t, ws.SlowConsumerDropOldest, true, 400)
+
+	if client.SlowConsumerPolicy() != ws.SlowConsumerDropOldest {
+		t.Errorf("Expected client override, got %s", client.SlowConsumerPolicy())
+	}
+
+	small, _ := drain(conn)
+	if len(small) == 0 || small[len(small)-1] != "399" {
+		t.Errorf("Expected the client override to keep the newest message, got %v", small)
+	}
+}
@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/persist/sqlpersister"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/persistertest"
+	_ "modernc.org/sqlite"
+)
+
+func newSQLitePersister(t *testing.T, opts ...sqlpersister.Option) *sqlpersister.Persister {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	// Every connection to :memory: is a separate database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	p := sqlpersister.New(db, append([]sqlpersister.Option{sqlpersister.WithDialect(sqlpersister.SQLite)}, opts...)...)
+	if err := p.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	return p
+}
+
+func TestSQLPersisterConformance(t *testing.T) {
+	persistertest.Run(t, func(t *testing.T) ws.EnvelopePersister {
+		return newSQLitePersister(t)
+	})
+}
+
+func TestSQLPersisterMigrateIsIdempotent(t *testing.T) {
+	p := newSQLitePersister(t)
+	if err := p.Migrate(context.Background()); err != nil {
+		t.Errorf("Expected a second Migrate to succeed, got %v", err)
+	}
+}
+
+func TestSQLPersisterFetchLimit(t *testing.T) {
+	p := newSQLitePersister(t, sqlpersister.WithFetchLimit(2))
+	clientID := ws.NewIdentity()
+	base := time.Now()
+
+	var saved []ws.Identity
+	for i := 0; i < 5; i++ {
+		e := ws.Envelope{ID: ws.NewIdentity(), ClientID: clientID, Type: "n", Timestamp: base.Add(time.Duration(i) * time.Second)}
+		p.SaveEnvelope(e)
+		saved = append(saved, e.ID)
+	}
+
+	got, err := p.FetchUndelivered(clientID)
+	if err != nil {
+		t.Fatalf("FetchUndelivered failed: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != saved[0] || got[1].ID != saved[1] {
+		t.Errorf("Expected the two oldest envelopes, got %v", got)
+	}
+
+	all, _ := p.FetchUndeliveredContext(context.Background(), clientID, 0)
+	if len(all) != 5 {
+		t.Errorf("Expected no limit to return all 5 envelopes, got %d", len(all))
+	}
+}
+
+func TestSQLPersisterStoresEnvelopeFields(t *testing.T) {
+	p := newSQLitePersister(t)
+	replyTo := ws.NewIdentity()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	e, _ := ws.NewEnvelope(ws.NewIdentity(), "answer", map[string]int{"n": 1})
+	e.ReplyTo = &replyTo
+	e.ExpiresAt = &expiresAt
+	p.SaveEnvelope(e)
+
+	got, _ := p.FetchUndelivered(e.ClientID)
+	if len(got) != 1 {
+		t.Fatalf("Expected one envelope, got %d", len(got))
+	}
+	if got[0].ReplyTo == nil || *got[0].ReplyTo != replyTo {
+		t.Errorf("Expected reply_to %s, got %v", replyTo, got[0].ReplyTo)
+	}
+	if got[0].ExpiresAt == nil || !got[0].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expires_at %v, got %v", expiresAt, got[0].ExpiresAt)
+	}
+	if !got[0].Timestamp.Equal(e.Timestamp) {
+		t.Errorf("Expected timestamp %v, got %v", e.Timestamp, got[0].Timestamp)
+	}
+}
+
+func TestSQLPersisterConfirmUnknown(t *testing.T) {
+	p := newSQLitePersister(t)
+	if err := p.ConfirmDelivery(ws.NewIdentity(), ws.NewIdentity()); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope, got %v", err)
+	}
+}
+
+func TestSQLPersisterHonoursContext(t *testing.T) {
+	p := newSQLitePersister(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := p.SaveEnvelopeContext(ctx, ws.Envelope{ID: ws.NewIdentity(), ClientID: ws.NewIdentity()}); err == nil {
+		t.Error("Expected a cancelled context to abort SaveEnvelopeContext")
+	}
+}
+
+func TestSQLPersisterSavesBatches(t *testing.T) {
+	p := newSQLitePersister(t)
+	batching := ws.NewBatchingPersister(p, ws.WithBatchSize(10), ws.WithBatchClock(newFakeClock()))
+	envelopes := newEnvelopes(25)
+	for _, e := range envelopes {
+		batching.SaveEnvelope(e)
+	}
+	if err := batching.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, _ := p.FetchUndelivered(envelopes[0].ClientID)
+	if len(got) != len(envelopes) {
+		t.Errorf("Expected %d envelopes saved in batches, got %d", len(envelopes), len(got))
+	}
+}
+
+func TestSQLPersisterMigrateAddsColumns(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	// The table as earlier versions created it, without the room and
+	// read_at columns.
+	_, err = db.Exec(`CREATE TABLE envelopes (
+	id TEXT PRIMARY KEY,
+	client_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	payload BLOB,
+	timestamp TEXT NOT NULL,
+	delivered TEXT,
+	reply_to TEXT,
+	expires_at TEXT,
+	inbound BOOLEAN NOT NULL DEFAULT FALSE
+)`)
+	if err != nil {
+		t.Fatalf("Failed to create the old table: %v", err)
+	}
+
+	p := sqlpersister.New(db, sqlpersister.WithDialect(sqlpersister.SQLite))
+	if err := p.Migrate(context.Background()); err != nil {
+		t.Fatalf("Expected Migrate to upgrade the old table, got %v", err)
+	}
+	e, _ := ws.NewEnvelope(ws.Identity{}, "chat", nil)
+	e.Room = "lobby"
+	if err := p.SaveEnvelope(e); err != nil {
+		t.Fatalf("Expected saving a room envelope to succeed, got %v", err)
+	}
+	read := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := p.MarkRead(e.ID, e.ClientID, read); err != nil {
+		t.Fatalf("Expected marking the envelope read to succeed, got %v", err)
+	}
+	got, err := p.FetchRoomHistory("lobby", 10, time.Now().Add(time.Second))
+	if err != nil || len(got) != 1 || got[0].ID != e.ID {
+		t.Fatalf("Expected the room envelope back, got %v (%v)", got, err)
+	}
+	if got[0].ReadAt == nil || !got[0].ReadAt.Equal(read) {
+		t.Errorf("Expected the read time back, got %v", got[0].ReadAt)
+	}
+}
+
+func TestSQLPersisterScheduleSurvivesRestart(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	open := func() *sqlpersister.Persister {
+		p := sqlpersister.New(db, sqlpersister.WithDialect(sqlpersister.SQLite))
+		if err := p.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate failed: %v", err)
+		}
+		return p
+	}
+	clock := newFakeClock()
+	clientID := ws.NewIdentity()
+
+	first := newScheduleHandler(open(), clock)
+	expiresAt := clock.Now().Add(24 * time.Hour)
+	id, err := first.SendAfter(time.Hour, clientID, ws.Envelope{Type: "reminder", Payload: []byte(`{"n":1}`), ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	first.Shutdown(context.Background())
+
+	persister := open()
+	second := newScheduleHandler(persister, clock)
+	defer second.Shutdown(context.Background())
+	clock.Advance(time.Hour)
+
+	pending, _ := persister.FetchUndelivered(clientID)
+	if len(pending) != 1 || pending[0].ID != id || string(pending[0].Payload) != `{"n":1}` {
+		t.Fatalf("Expected the reminder sent after the restart, got %+v", pending)
+	}
+	if pending[0].ExpiresAt == nil || !pending[0].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected the scheduled expiry kept, got %v", pending[0].ExpiresAt)
+	}
+	if err := second.CancelScheduled(id); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected the sent envelope gone from the schedule, got %v", err)
+	}
+}
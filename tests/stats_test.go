@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestStatsFollowConnectSendDisconnect(t *testing.T) {
+	clock := newFakeClock()
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithClock(clock))
+	server := newTestServer(t, handler)
+
+	aliceID, bobID := ws.NewIdentity(), ws.NewIdentity()
+	alice, _ := dialWithResponse(t, server, identityHeader(aliceID))
+	bob, _ := dialWithResponse(t, server, identityHeader(bobID))
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().Connections == 2 }) {
+		t.Fatalf("Expected two open connections, got %+v", handler.Stats())
+	}
+
+	hello, ping := []byte("hello"), []byte("ping!!")
+	alice.WriteMessage(websocket.TextMessage, hello)
+	alice.WriteMessage(websocket.TextMessage, hello)
+	bob.WriteMessage(websocket.TextMessage, ping)
+	aliceClient, _ := handler.Get(aliceID)
+	handler.Join("lobby", aliceClient)
+	handler.SendTo(aliceID, []byte("welcome"))
+	if _, _, err := alice.ReadMessage(); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool {
+		s := handler.Stats()
+		return s.MessagesIn == 3 && s.MessagesOut == 1
+	}) {
+		t.Fatalf("Expected 3 messages in and 1 out, got %+v", handler.Stats())
+	}
+	clock.Advance(time.Minute)
+
+	stats := handler.Stats()
+	if stats.BytesIn != uint64(2*len(hello)+len(ping)) || stats.BytesOut != uint64(len("welcome")) {
+		t.Errorf("Expected %d bytes in and %d out, got %d and %d",
+			2*len(hello)+len(ping), len("welcome"), stats.BytesIn, stats.BytesOut)
+	}
+	if stats.TotalConnections != 2 || stats.Rooms["lobby"] != 1 || stats.Uptime != time.Minute {
+		t.Errorf("Expected 2 connections, one lobby member and a minute up, got %+v", stats)
+	}
+
+	aliceStats, ok := handler.ClientStats(aliceID)
+	if !ok {
+		t.Fatal("Expected stats for alice")
+	}
+	if aliceStats.MessagesIn != 2 || aliceStats.BytesIn != uint64(2*len(hello)) ||
+		aliceStats.MessagesOut != 1 || aliceStats.Connections != 1 || aliceStats.ConnectedFor <= 0 {
+		t.Errorf("Expected alice's own counters, got %+v", aliceStats)
+	}
+
+	alice.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Stats().Connections == 1 }) {
+		t.Fatalf("Expected one open connection after alice left, got %+v", handler.Stats())
+	}
+	stats = handler.Stats()
+	if stats.TotalConnections != 2 || len(stats.Rooms) != 0 || stats.MessagesIn != 3 {
+		t.Errorf("Expected totals to survive the disconnect and the room to go, got %+v", stats)
+	}
+	if _, ok := handler.ClientStats(aliceID); ok {
+		t.Error("Expected no stats for a disconnected identity")
+	}
+	if bobStats, _ := handler.ClientStats(bobID); bobStats.MessagesIn != 1 || bobStats.BytesIn != uint64(len(ping)) {
+		t.Errorf("Expected bob's counters, got %+v", bobStats)
+	}
+}
+
+func TestClientStatsSumConnections(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	id := ws.NewIdentity()
+	phone, _ := dialWithResponse(t, server, identityHeader(id))
+	laptop, _ := dialWithResponse(t, server, identityHeader(id))
+	phone.WriteMessage(websocket.TextMessage, []byte("a"))
+	laptop.WriteMessage(websocket.TextMessage, []byte("bb"))
+
+	var stats ws.ClientStats
+	if !waitFor(t, 2*time.Second, func() bool {
+		stats, _ = handler.ClientStats(id)
+		return stats.MessagesIn == 2
+	}) {
+		t.Fatalf("Expected both connections' messages, got %+v", stats)
+	}
+	if stats.Connections != 2 || stats.BytesIn != 3 {
+		t.Errorf("Expected two connections and 3 bytes, got %+v", stats)
+	}
+}
+
+func TestStatsMarshalJSON(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+	handler.Join("lobby", client)
+
+	data, err := json.Marshal(handler.Stats())
+	if err != nil {
+		t.Fatalf("Failed to marshal stats: %v", err)
+	}
+	var decoded map[string]any
+	json.Unmarshal(data, &decoded)
+	for _, key := range []string{"connections", "total_connections", "messages_in", "messages_out",
+		"bytes_in", "bytes_out", "dropped", "rooms", "started", "uptime_ns", "shed"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("Expected %q in %s", key, data)
+		}
+	}
+	if rooms, _ := decoded["rooms"].(map[string]any); rooms["lobby"] != float64(1) {
+		t.Errorf("Expected the lobby's size in %s", data)
+	}
+
+	clientStats, _ := handler.ClientStats(client.ID)
+	data, _ = json.Marshal(clientStats)
+	var back ws.ClientStats
+	if err := json.Unmarshal(data, &back); err != nil || back.ClientID != client.ID {
+		t.Errorf("Expected client stats to round-trip through JSON, got %s (%v)", data, err)
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func TestNextWriterStreamsLargeMessage(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	export := make([]byte, 8<<20)
+	rand.Read(export)
+
+	done := make(chan error, 1)
+	go func() {
+		w, err := client.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			done <- err
+			return
+		}
+		for chunk := export; len(chunk) > 0; chunk = chunk[min(len(chunk), 64<<10):] {
+			if _, err := w.Write(chunk[:min(len(chunk), 64<<10)]); err != nil {
+				w.Close()
+				done <- err
+				return
+			}
+			// Queue small messages mid-stream; they must not interleave.
+			client.SendJSON("small")
+		}
+		done <- w.Close()
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected the streamed message, got %v", err)
+	}
+	if messageType != websocket.BinaryMessage {
+		t.Errorf("Expected a binary message, got type %d", messageType)
+	}
+	if !bytes.Equal(data, export) {
+		t.Errorf("Expected %d streamed bytes to match, got %d bytes", len(export), len(data))
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the stream to succeed, got %v", err)
+	}
+
+	if got := readText(t, conn); got != `"small"` {
+		t.Errorf("Expected queued messages after the stream, got %q", got)
+	}
+}
+
+func TestNextWriterRejectsControlTypes(t *testing.T) {
+	client := ws.NewClient(ws.NewIdentity(), nil)
+	if _, err := client.NextWriter(websocket.PingMessage); !errors.Is(err, ws.ErrInvalidMessageType) {
+		t.Errorf("Expected ErrInvalidMessageType, got %v", err)
+	}
+}
+
+func TestNextWriterOnClosedClient(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, nil)
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Fatal("Expected client to be unregistered")
+	}
+	if _, err := client.NextWriter(websocket.TextMessage); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected ErrClientNotConnected, got %v", err)
+	}
+}
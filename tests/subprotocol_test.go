@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+func dialSubprotocols(t *testing.T, server *httptest.Server, clientID ws.Identity, protocols ...string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: protocols}
+	conn, resp, err := dialer.Dial(wsURL(server), identityHeader(clientID))
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      string
+	}{
+		{"server preference wins", []string{"chat.v2", "chat.v1"}, "chat.v1"},
+		{"only mutual protocol", []string{"legacy", "chat.v2"}, "chat.v2"},
+		{"no mutual protocol", []string{"legacy"}, ""},
+		{"none requested", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+				ws.WithSubprotocols("chat.v1", "chat.v2"),
+			)
+			server := newTestServer(t, handler)
+
+			clientID := ws.NewIdentity()
+			conn, resp, err := dialSubprotocols(t, server, clientID, tt.requested...)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != tt.want {
+				t.Errorf("Expected response header %q, got %q", tt.want, got)
+			}
+			if got := conn.Subprotocol(); got != tt.want {
+				t.Errorf("Expected dialer to see %q, got %q", tt.want, got)
+			}
+			if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+				t.Fatal("Expected client to be registered")
+			}
+			client, _ := handler.Get(clientID)
+			if got := client.Subprotocol(); got != tt.want {
+				t.Errorf("Expected client.Subprotocol() %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStrictSubprotocols(t *testing.T) {
+	var mu sync.Mutex
+	var rejections []error
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithSubprotocols("chat.v1", "chat.v2"),
+		ws.WithStrictSubprotocols(),
+		ws.WithOnReject(func(r *http.Request, status int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			rejections = append(rejections, err)
+		}),
+	)
+	server := newTestServer(t, handler)
+
+	_, resp, err := dialSubprotocols(t, server, ws.NewIdentity(), "legacy", "chat.v0")
+	if err == nil {
+		t.Fatal("Expected the upgrade to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", resp)
+	}
+	mu.Lock()
+	if len(rejections) != 1 || !errors.Is(rejections[0], ws.ErrUnsupportedSubprotocol) {
+		t.Errorf("Expected one ErrUnsupportedSubprotocol rejection, got %v", rejections)
+	}
+	mu.Unlock()
+
+	conn, _, err := dialSubprotocols(t, server, ws.NewIdentity(), "legacy", "chat.v2")
+	if err != nil {
+		t.Fatalf("Expected an overlapping offer to connect: %v", err)
+	}
+	if got := conn.Subprotocol(); got != "chat.v2" {
+		t.Errorf("Expected chat.v2, got %q", got)
+	}
+
+	conn, _, err = dialSubprotocols(t, server, ws.NewIdentity())
+	if err != nil {
+		t.Fatalf("Expected clients requesting no subprotocol to connect: %v", err)
+	}
+	if got := conn.Subprotocol(); got != "" {
+		t.Errorf("Expected no subprotocol, got %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var errTokenRejected = errors.New("token rejected")
+
+// tokenValidator accepts every upgrade and refreshes sessions from a fixed
+// token table.
+type tokenValidator struct {
+	mockSessionValidator
+	tokens map[string]ws.SessionInfo
+}
+
+func (v *tokenValidator) Refresh(client *ws.Client, token string) (ws.SessionInfo, error) {
+	session, ok := v.tokens[token]
+	if !ok {
+		return ws.SessionInfo{}, errTokenRejected
+	}
+	return session, nil
+}
+
+type tokenRefreshFixture struct {
+	handler  *ws.WebsocketHandler
+	messages *mockMessageHandler
+	conn     *websocket.Conn
+	clientID ws.Identity
+
+	mu            sync.Mutex
+	disconnectErr error
+	disconnected  bool
+}
+
+func newTokenRefreshFixture(t *testing.T, tokens map[string]ws.SessionInfo) *tokenRefreshFixture {
+	t.Helper()
+	f := &tokenRefreshFixture{messages: &mockMessageHandler{}, clientID: ws.NewIdentity()}
+	validator := &tokenValidator{tokens: tokens}
+	validator.session = ws.SessionInfo{ClientID: f.clientID}
+	f.handler = ws.NewWebSocketHandler(validator, f.messages, &mockEnvelopePersister{},
+		ws.WithMessageAuthorizer(adminOnly),
+		ws.WithOnDisconnect(func(client *ws.Client, err error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.disconnectErr = err
+			f.disconnected = true
+		}),
+	)
+	f.conn = dial(t, newTestServer(t, f.handler))
+	if !waitFor(t, 2*time.Second, func() bool { return f.handler.IsOnline(f.clientID) }) {
+		t.Fatal("Expected client to be registered")
+	}
+	return f
+}
+
+func (f *tokenRefreshFixture) disconnect() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.disconnected, f.disconnectErr
+}
+
+func TestTokenRefreshAppliesToLaterFrames(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	f := newTokenRefreshFixture(t, map[string]ws.SessionInfo{
+		"fresh": {Metadata: map[string]string{"role": "admin"}, ExpiresAt: expiresAt},
+	})
+
+	id := ws.NewIdentity()
+	writeFrame(t, f.conn, `{"id":"`+id.String()+`","type":"auth.refresh","payload":{"token":"fresh"}}`)
+	writeFrame(t, f.conn, `{"type":"admin.ban","payload":{}}`)
+
+	if got := readAckID(t, f.conn); got != id.String() {
+		t.Fatalf("Expected the refresh to be acked with %s, got %q", id, got)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.messages.received()) == 1 }) {
+		t.Fatal("Expected the admin message sent after the refresh to be authorized")
+	}
+
+	client, _ := f.handler.Get(f.clientID)
+	if session := client.Session(); !session.ExpiresAt.Equal(expiresAt) || session.ClientID != f.clientID {
+		t.Errorf("Expected the refreshed session, got %+v", session)
+	}
+}
+
+func TestTokenRefreshFailureCloses(t *testing.T) {
+	f := newTokenRefreshFixture(t, nil)
+
+	writeFrame(t, f.conn, `{"type":"auth.refresh","payload":{"token":"stale"}}`)
+	writeFrame(t, f.conn, `{"type":"chat","payload":{}}`)
+
+	f.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := f.conn.ReadMessage()
+	if !websocket.IsCloseError(err, ws.CloseSessionExpired) {
+		t.Errorf("Expected close %d after a refused token, got %v", ws.CloseSessionExpired, err)
+	}
+
+	if !waitFor(t, 2*time.Second, func() bool { ok, _ := f.disconnect(); return ok }) {
+		t.Fatal("Expected the disconnect hook to run")
+	}
+	if _, err := f.disconnect(); !errors.Is(err, ws.ErrTokenRefreshFailed) || !errors.Is(err, errTokenRejected) {
+		t.Errorf("Expected ErrTokenRefreshFailed wrapping the refresher's error, got %v", err)
+	}
+	if len(f.messages.received()) != 0 {
+		t.Error("Expected frames after a failed refresh not to be handled")
+	}
+}
+
+func TestTokenRefreshRejectsOtherIdentity(t *testing.T) {
+	f := newTokenRefreshFixture(t, map[string]ws.SessionInfo{
+		"someone-else": {ClientID: ws.NewIdentity()},
+	})
+
+	writeFrame(t, f.conn, `{"type":"auth.refresh","payload":{"token":"someone-else"}}`)
+
+	f.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := f.conn.ReadMessage()
+	if !websocket.IsCloseError(err, ws.CloseSessionExpired) {
+		t.Errorf("Expected close %d for a token issued to another identity, got %v", ws.CloseSessionExpired, err)
+	}
+}
+
+func TestTokenRefreshMalformedPayload(t *testing.T) {
+	f := newTokenRefreshFixture(t, nil)
+
+	for _, frame := range []string{
+		`{"type":"auth.refresh","payload":{}}`,
+		`{"type":"auth.refresh","payload":{"token":42}}`,
+		`{"type":"auth.refresh"}`,
+	} {
+		writeFrame(t, f.conn, frame)
+		if code := readErrorCode(t, f.conn); code != "invalid_refresh" {
+			t.Errorf("Expected invalid_refresh for %s, got %q", frame, code)
+		}
+	}
+
+	writeFrame(t, f.conn, `{"type":"chat","payload":{}}`)
+	if !waitFor(t, 2*time.Second, func() bool { return len(f.messages.received()) == 1 }) {
+		t.Error("Expected the connection to stay usable after malformed refreshes")
+	}
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+func TestTopicWildcardMatching(t *testing.T) {
+	hub := ws.NewHub()
+	// Registered clients without running pumps keep what is queued for
+	// them, so QueuedBytes shows who was sent each publish.
+	subscribe := func(patterns ...string) *ws.Client {
+		client := ws.NewClient(ws.NewIdentity(), nil)
+		hub.Register(client)
+		for _, pattern := range patterns {
+			if err := hub.Subscribe(client, pattern); err != nil {
+				t.Fatalf("Expected %q to be a valid pattern, got %v", pattern, err)
+			}
+		}
+		return client
+	}
+	overlapping := subscribe("orders.eu.*", "orders.#", "orders.eu.created")
+	created := subscribe("orders.*.created")
+	metrics := subscribe("metrics.>")
+	cpu := subscribe("metrics.host42.cpu")
+	clients := map[string]*ws.Client{"overlapping": overlapping, "created": created, "metrics": metrics, "cpu": cpu}
+
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"orders.eu.created", []string{"overlapping", "created"}},
+		{"orders.us.created", []string{"overlapping", "created"}},
+		{"orders.eu.created.late", []string{"overlapping"}},
+		{"orders", nil},
+		{"metrics.host42.cpu", []string{"metrics", "cpu"}},
+		{"metrics.host42", []string{"metrics"}},
+		{"metrics", nil},
+		{"other.eu.created", nil},
+	}
+	for _, tt := range tests {
+		before := make(map[string]int64)
+		for name, client := range clients {
+			before[name] = client.QueuedBytes()
+		}
+
+		result, err := hub.Publish(tt.topic, map[string]string{"topic": tt.topic})
+		if err != nil {
+			t.Fatalf("Expected publishing to %q to succeed, got %v", tt.topic, err)
+		}
+		if result.Delivered != len(tt.want) || result.Dropped != 0 {
+			t.Errorf("Expected %q to reach %d clients once each, got %+v", tt.topic, len(tt.want), result)
+		}
+		for _, name := range tt.want {
+			if clients[name].QueuedBytes() == before[name] {
+				t.Errorf("Expected %q to reach the %s subscriber", tt.topic, name)
+			}
+		}
+	}
+}
+
+func TestTopicSubscribeValidation(t *testing.T) {
+	hub := ws.NewHub()
+	client := ws.NewClient(ws.NewIdentity(), nil)
+	if err := hub.Subscribe(client, "orders.*"); !errors.Is(err, ws.ErrClientNotConnected) {
+		t.Errorf("Expected an unregistered client not to subscribe, got %v", err)
+	}
+	hub.Register(client)
+
+	for _, pattern := range []string{"", "orders.", "orders..eu", "orders.#.eu", "orders.e*", "orders.>.#"} {
+		if err := hub.Subscribe(client, pattern); !errors.Is(err, ws.ErrInvalidTopic) {
+			t.Errorf("Expected pattern %q to be invalid, got %v", pattern, err)
+		}
+	}
+	for _, topic := range []string{"orders.*", "orders.#", "orders.>", ""} {
+		if _, err := hub.Publish(topic, nil); !errors.Is(err, ws.ErrInvalidTopic) {
+			t.Errorf("Expected topic %q to be invalid for publishing, got %v", topic, err)
+		}
+	}
+
+	hub.Subscribe(client, "orders.>")
+	hub.Subscribe(client, "orders.#")
+	hub.Subscribe(client, "metrics.*")
+	if got := hub.Subscriptions(client); len(got) != 2 || got[0] != "metrics.*" || got[1] != "orders.#" {
+		t.Errorf("Expected orders.> and orders.# to be one subscription, got %v", got)
+	}
+
+	hub.Unsubscribe(client, "orders.#")
+	if result, _ := hub.Publish("orders.eu", nil); result.Delivered != 0 {
+		t.Errorf("Expected no delivery after unsubscribing, got %+v", result)
+	}
+	if result, _ := hub.Publish("metrics.cpu", nil); result.Delivered != 1 {
+		t.Errorf("Expected the remaining subscription to keep working, got %+v", result)
+	}
+}
+
+func TestTopicSubscribeFrames(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	conn := connect(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	id := ws.NewIdentity()
+	conn.MustSendJSON(map[string]string{"type": ws.SubscribeMessageType, "topic": "orders.eu.*", "id": id.String()})
+	if ack := conn.ExpectEnvelope(ws.AckMessageType, 2*time.Second); ack.ID != id {
+		t.Fatalf("Expected the subscription to be acked, got %+v", ack)
+	}
+
+	if _, err := handler.Publish("orders.eu.created", map[string]int{"order": 7}); err != nil {
+		t.Fatalf("Expected Publish to succeed, got %v", err)
+	}
+	published := conn.ExpectEnvelope(ws.PublishMessageType, 2*time.Second)
+	var payload map[string]int
+	published.DecodePayload(&payload)
+	if published.Topic != "orders.eu.created" || payload["order"] != 7 || !published.Ephemeral {
+		t.Errorf("Expected an ephemeral publish to orders.eu.created, got %+v", published)
+	}
+
+	conn.MustSendJSON(map[string]string{"type": ws.SubscribeMessageType, "topic": "orders.#.eu"})
+	var frame ws.ErrorFrame
+	if conn.ExpectJSON(&frame, 2*time.Second); frame.Code != "invalid_topic" {
+		t.Errorf("Expected an invalid_topic error frame, got %+v", frame)
+	}
+
+	id = ws.NewIdentity()
+	conn.MustSendJSON(map[string]string{"type": ws.UnsubscribeMessageType, "topic": "orders.eu.*", "id": id.String()})
+	conn.ExpectEnvelope(ws.AckMessageType, 2*time.Second)
+	if got := handler.Subscriptions(client); len(got) != 0 {
+		t.Errorf("Expected no subscriptions left, got %v", got)
+	}
+	handler.Publish("orders.eu.created", nil)
+	conn.ExpectNoMessage(100 * time.Millisecond)
+}
+
+func TestTopicSubscriptionsEndOnDisconnect(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conns := []*wstest.Conn{connect(t, server), connect(t, server)}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 2 }) {
+		t.Fatal("Expected both clients to be registered")
+	}
+	var clients []*ws.Client
+	handler.Range(func(c *ws.Client) bool { clients = append(clients, c); return true })
+	for _, client := range clients {
+		handler.Subscribe(client, "metrics.#")
+	}
+
+	conns[0].Conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 1 }) {
+		t.Fatal("Expected the closed client to be unregistered")
+	}
+	var remaining *ws.Client
+	handler.Range(func(c *ws.Client) bool { remaining = c; return false })
+	for _, client := range clients {
+		if client != remaining && len(handler.Subscriptions(client)) != 0 {
+			t.Errorf("Expected the disconnected client's subscriptions to be dropped, got %v", handler.Subscriptions(client))
+		}
+	}
+	if result, _ := handler.Publish("metrics.cpu", nil); result.Delivered != 1 {
+		t.Errorf("Expected only the connected subscriber to get the publish, got %+v", result)
+	}
+	conns[1].ExpectEnvelope(ws.PublishMessageType, 2*time.Second)
+}
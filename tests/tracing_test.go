@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	browserTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	browserSpanID  = "00f067aa0ba902b7"
+)
+
+// tracedFixture serves a router whose "order" handler starts a span of its
+// own and replies, recording every span in exporter.
+type tracedFixture struct {
+	exporter *tracetest.InMemoryExporter
+	provider *sdktrace.TracerProvider
+	handler  *ws.WebsocketHandler
+	clientID ws.Identity
+}
+
+func newTracedFixture(t *testing.T) (*tracedFixture, *websocket.Conn) {
+	t.Helper()
+	f := &tracedFixture{exporter: tracetest.NewInMemoryExporter(), clientID: ws.NewIdentity()}
+	f.provider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(f.exporter))
+	t.Cleanup(func() { f.provider.Shutdown(context.Background()) })
+
+	router := ws.NewRouter()
+	ws.Handle(router, "order", func(client *ws.Client, msg placeOrder) error {
+		_, span := f.provider.Tracer("app").Start(client.TraceContext(), "db.query")
+		span.End()
+		reply, _ := ws.NewEnvelope(client.ID, "order.ok", nil)
+		return client.SendEnvelope(reply)
+	})
+	ws.Handle(router, "cancel", func(client *ws.Client, msg placeOrder) error {
+		return errors.New("order already shipped")
+	})
+	f.handler = ws.NewWebSocketHandler(&mockSessionValidator{session: ws.SessionInfo{ClientID: f.clientID}},
+		router, &mockEnvelopePersister{}, ws.WithTracerProvider(f.provider))
+	return f, dial(t, newTestServer(t, f.handler))
+}
+
+// span waits for the span called name to end and returns it.
+func (f *tracedFixture) span(t *testing.T, name string) tracetest.SpanStub {
+	t.Helper()
+	var found tracetest.SpanStub
+	if !waitFor(t, 2*time.Second, func() bool {
+		for _, s := range f.exporter.GetSpans() {
+			if s.Name == name {
+				found = s
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatalf("Expected a %s span", name)
+	}
+	return found
+}
+
+func spanAttr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingContinuesClientTrace(t *testing.T) {
+	f, conn := newTracedFixture(t)
+	if err := f.handler.Join("lobby", registeredClient(t, f.handler)); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+
+	frame := fmt.Sprintf(`{"type":"order","payload":{"sku":"A-1"},"traceparent":"00-%s-%s-01"}`, browserTraceID, browserSpanID)
+	conn.WriteMessage(websocket.TextMessage, []byte(frame))
+
+	handle := f.span(t, ws.MessageHandleSpan)
+	if got := handle.SpanContext.TraceID().String(); got != browserTraceID {
+		t.Errorf("Expected the span to continue trace %s, got %s", browserTraceID, got)
+	}
+	if got := handle.Parent.SpanID().String(); got != browserSpanID || !handle.Parent.IsRemote() {
+		t.Errorf("Expected the span's remote parent to be %s, got %s", browserSpanID, got)
+	}
+	if handle.SpanKind != trace.SpanKindServer {
+		t.Errorf("Expected a server span, got %v", handle.SpanKind)
+	}
+	for key, want := range map[attribute.Key]attribute.Value{
+		ws.AttrClientID:    attribute.StringValue(f.clientID.String()),
+		ws.AttrMessageType: attribute.StringValue("order"),
+		ws.AttrMessageSize: attribute.IntValue(len(frame)),
+		ws.AttrRooms:       attribute.StringSliceValue([]string{"lobby"}),
+	} {
+		if got := spanAttr(handle, key); got != want {
+			t.Errorf("Expected %s to be %v, got %v", key, want.Emit(), got.Emit())
+		}
+	}
+
+	query := f.span(t, "db.query")
+	if query.Parent.SpanID() != handle.SpanContext.SpanID() {
+		t.Errorf("Expected the handler's span to be a child of %s, got parent %s",
+			handle.SpanContext.SpanID(), query.Parent.SpanID())
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read the reply: %v", err)
+	}
+	var reply ws.Envelope
+	json.Unmarshal(data, &reply)
+	want := fmt.Sprintf("00-%s-%s-01", browserTraceID, handle.SpanContext.SpanID())
+	if reply.Type != "order.ok" || reply.TraceParent != want {
+		t.Errorf("Expected the reply to carry traceparent %s, got %+v", want, reply)
+	}
+}
+
+func TestTracingStartsTraceWithoutTraceparent(t *testing.T) {
+	f, conn := newTracedFixture(t)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"cancel","payload":{}}`))
+
+	handle := f.span(t, ws.MessageHandleSpan)
+	if handle.Parent.IsValid() {
+		t.Errorf("Expected a root span, got parent %s", handle.Parent.SpanID())
+	}
+	if _, ok := spanAttr(handle, ws.AttrRooms).AsInterface().([]string); ok {
+		t.Error("Expected no rooms attribute for a client in no rooms")
+	}
+	if handle.Status.Code != codes.Error || handle.Status.Description != "order already shipped" {
+		t.Errorf("Expected the handler's error on the span, got %+v", handle.Status)
+	}
+}
+
+func TestSendEnvelopeContextInjectsTrace(t *testing.T) {
+	f, conn := newTracedFixture(t)
+	registeredClient(t, f.handler)
+
+	ctx, span := f.provider.Tracer("app").Start(context.Background(), "billing.run")
+	env, _ := ws.NewEnvelope(f.clientID, "invoice", nil)
+	if err := f.handler.SendEnvelopeContext(ctx, env); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	span.End()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	var pushed ws.Envelope
+	json.Unmarshal(data, &pushed)
+	sc := span.SpanContext()
+	if want := fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID()); pushed.TraceParent != want {
+		t.Errorf("Expected the push to carry traceparent %s, got %q", want, pushed.TraceParent)
+	}
+}
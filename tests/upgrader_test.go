@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/msgpack"
+)
+
+func customUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		Subprotocols: []string{"custom.v1", msgpack.Subprotocol},
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") != "https://evil.example"
+		},
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			w.WriteHeader(http.StatusTeapot)
+		},
+	}
+}
+
+func TestInjectedUpgraderRunsUpgrade(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithUpgrader(customUpgrader()),
+	)
+	server := newTestServer(t, handler)
+
+	clientID := ws.NewIdentity()
+	conn, resp, err := dialSubprotocols(t, server, clientID, "custom.v1")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if conn.Subprotocol() != "custom.v1" {
+		t.Errorf("Expected the upgrader's subprotocol, got %q", conn.Subprotocol())
+	}
+	if resp.Header.Get(ws.ClientIDHeader) != clientID.String() {
+		t.Errorf("Expected the handler's headers on the upgrade, got %q", resp.Header.Get(ws.ClientIDHeader))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.IsOnline(clientID) }) {
+		t.Fatal("Expected the handler to register the client")
+	}
+	client, _ := handler.Get(clientID)
+	if client.Subprotocol() != "custom.v1" {
+		t.Errorf("Expected client.Subprotocol() custom.v1, got %q", client.Subprotocol())
+	}
+
+	header := identityHeader(ws.NewIdentity())
+	header.Set("Origin", "https://evil.example")
+	if got := dialStatus(wsURL(server), header); got != http.StatusTeapot {
+		t.Errorf("Expected the upgrader's CheckOrigin and Error to apply, got %d", got)
+	}
+}
+
+func TestInjectedUpgraderPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ws.Option
+		conflict bool
+	}{
+		{"upgrader alone", nil, false},
+		{"allowed origins", []ws.Option{ws.WithAllowedOrigins([]string{"app.example"})}, true},
+		{"origin check", []ws.Option{ws.WithCheckOrigin(func(*http.Request) bool { return true })}, true},
+		{"subprotocol offered by upgrader", []ws.Option{ws.WithSubprotocols("custom.v1")}, false},
+		{"subprotocol missing from upgrader", []ws.Option{ws.WithSubprotocols("custom.v2")}, true},
+		{"codec subprotocol offered by upgrader", []ws.Option{ws.WithSubprotocolCodec(msgpack.Subprotocol, msgpack.Codec{})}, false},
+		{"strict subprotocols", []ws.Option{ws.WithStrictSubprotocols()}, true},
+		{"options before the upgrader", []ws.Option{ws.WithAllowedOrigins([]string{"app.example"}), ws.WithReadBufferSize(4096)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ws.Option{ws.WithUpgrader(customUpgrader())}, tt.opts...)
+			handler, err := ws.NewHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{}, opts...)
+			if tt.conflict {
+				if !errors.Is(err, ws.ErrUpgraderConflict) || handler != nil {
+					t.Errorf("Expected ErrUpgraderConflict, got %v", err)
+				}
+				return
+			}
+			if err != nil || handler == nil {
+				t.Errorf("Expected the options to combine, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewWebSocketHandlerPanicsOnUpgraderConflict(t *testing.T) {
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ws.ErrUpgraderConflict) {
+			t.Errorf("Expected a panic with ErrUpgraderConflict, got %v", err)
+		}
+	}()
+	ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithCheckOrigin(func(*http.Request) bool { return true }),
+		ws.WithUpgrader(customUpgrader()),
+	)
+}
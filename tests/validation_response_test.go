@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+type cookieValidator struct{}
+
+func (cookieValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	panic("Validate called on a ResponseValidator")
+}
+
+func (cookieValidator) ValidateWithResponse(r *http.Request) (ws.SessionInfo, http.Header, error) {
+	header := http.Header{}
+	switch r.Header.Get("X-Scenario") {
+	case "forbidden":
+		header.Set("Content-Type", "application/json")
+		return ws.SessionInfo{}, header, &ws.ValidationError{
+			Status: http.StatusForbidden,
+			Body:   []byte(`{"error":"account suspended"}`),
+		}
+	case "throttled":
+		header.Set("Retry-After", "30")
+		return ws.SessionInfo{}, header, &ws.ValidationError{Status: http.StatusTooManyRequests, Err: errQuotaExceeded}
+	case "plain":
+		return ws.SessionInfo{}, nil, errors.New("bad token")
+	}
+	header.Add("Set-Cookie", (&http.Cookie{Name: "session", Value: "abc123", HttpOnly: true}).String())
+	return ws.SessionInfo{ClientID: ws.NewIdentity()}, header, nil
+}
+
+func TestValidatorHeadersSurviveUpgrade(t *testing.T) {
+	handler := ws.NewWebSocketHandler(cookieValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+
+	_, resp := dialWithResponse(t, server, nil)
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "abc123" {
+		t.Errorf("Expected the session cookie on the upgrade response, got %v", resp.Header.Values("Set-Cookie"))
+	}
+	if resp.Header.Get(ws.ClientIDHeader) == "" {
+		t.Error("Expected the client ID header alongside the validator's headers")
+	}
+}
+
+func TestValidationErrorStatusAndBody(t *testing.T) {
+	var mu sync.Mutex
+	var rejections []error
+	handler := ws.NewWebSocketHandler(cookieValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		ws.WithOnReject(func(r *http.Request, status int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			rejections = append(rejections, err)
+		}),
+	)
+	server := newTestServer(t, handler)
+
+	tests := []struct {
+		scenario string
+		status   int
+		header   string
+		value    string
+		body     string
+	}{
+		{"forbidden", http.StatusForbidden, "Content-Type", "application/json", `{"error":"account suspended"}`},
+		{"throttled", http.StatusTooManyRequests, "Retry-After", "30", "Too Many Requests\n"},
+		{"plain", http.StatusUnauthorized, "Content-Type", "text/plain; charset=utf-8", "Unauthorized\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			_, resp, err := websocket.DefaultDialer.Dial(wsURL(server), http.Header{"X-Scenario": {tt.scenario}})
+			if err == nil {
+				t.Fatal("Expected the upgrade to be refused")
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get(tt.header); got != tt.value {
+				t.Errorf("Expected %s %q, got %q", tt.header, tt.value, got)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, body)
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rejections) != len(tests) {
+		t.Fatalf("Expected %d rejections, got %v", len(tests), rejections)
+	}
+	if !errors.Is(rejections[1], errQuotaExceeded) {
+		t.Errorf("Expected the rejection hook to see the underlying reason, got %v", rejections[1])
+	}
+}
@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+var (
+	errNoCookie = errors.New("no session cookie")
+	errNoAPIKey = errors.New("no api key")
+)
+
+// chainCalls records which validators of a chain ran.
+type chainCalls struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (c *chainCalls) validator(name string, session ws.SessionInfo, err error) ws.SessionValidator {
+	return ws.ValidatorFunc(func(*http.Request) (ws.SessionInfo, error) {
+		c.mu.Lock()
+		c.names = append(c.names, name)
+		c.mu.Unlock()
+		return session, err
+	})
+}
+
+func (c *chainCalls) ran() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.names...)
+}
+
+func validateRequest() *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/ws", nil)
+}
+
+func TestValidatorFunc(t *testing.T) {
+	id := ws.NewIdentity()
+	v := ws.ValidatorFunc(func(r *http.Request) (ws.SessionInfo, error) {
+		return ws.SessionInfo{ClientID: id}, nil
+	})
+	if session, err := v.Validate(validateRequest()); err != nil || session.ClientID != id {
+		t.Errorf("Expected the function's session, got %+v, %v", session, err)
+	}
+}
+
+func TestValidatorChainFirstSuccessWins(t *testing.T) {
+	cookieUser, keyUser := ws.NewIdentity(), ws.NewIdentity()
+	tests := []struct {
+		name   string
+		cookie error
+		key    error
+		want   ws.Identity
+		ran    []string
+	}{
+		{"first accepts", nil, nil, cookieUser, []string{"cookie"}},
+		{"falls through to second", errNoCookie, nil, keyUser, []string{"cookie", "key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &chainCalls{}
+			chain := ws.ValidatorChain(
+				calls.validator("cookie", ws.SessionInfo{ClientID: cookieUser}, tt.cookie),
+				calls.validator("key", ws.SessionInfo{ClientID: keyUser}, tt.key),
+			)
+			session, err := chain.Validate(validateRequest())
+			if err != nil || session.ClientID != tt.want {
+				t.Errorf("Expected session %s, got %s (%v)", tt.want, session.ClientID, err)
+			}
+			if got := calls.ran(); fmt.Sprint(got) != fmt.Sprint(tt.ran) {
+				t.Errorf("Expected validators %v to run, got %v", tt.ran, got)
+			}
+		})
+	}
+}
+
+func TestValidatorChainAggregatesFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		errs   []error
+		status int
+	}{
+		{"plain errors", []error{errNoCookie, errNoAPIKey}, http.StatusUnauthorized},
+		{"last validation error", []error{
+			&ws.ValidationError{Status: http.StatusPaymentRequired, Err: errNoCookie},
+			&ws.ValidationError{Status: http.StatusTooManyRequests, Err: errNoAPIKey},
+		}, http.StatusTooManyRequests},
+		{"specific status beats a later 401", []error{
+			&ws.ValidationError{Status: http.StatusForbidden, Err: errNoCookie},
+			&ws.ValidationError{Err: errNoAPIKey},
+		}, http.StatusForbidden},
+		{"validation error beats a later plain error", []error{
+			&ws.ValidationError{Status: http.StatusForbidden, Err: errNoCookie},
+			errNoAPIKey,
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &chainCalls{}
+			chain := ws.ValidatorChain(
+				calls.validator("cookie", ws.SessionInfo{}, tt.errs[0]),
+				calls.validator("key", ws.SessionInfo{}, tt.errs[1]),
+			)
+			_, err := chain.Validate(validateRequest())
+			if !errors.Is(err, errNoCookie) || !errors.Is(err, errNoAPIKey) {
+				t.Errorf("Expected both failures in the error, got %v", err)
+			}
+			var verr *ws.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			status := verr.Status
+			if status == 0 {
+				status = http.StatusUnauthorized
+			}
+			if status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+		})
+	}
+}
+
+func TestValidatorChainHalts(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"banned", fmt.Errorf("account 7: %w", ws.ErrBanned), http.StatusForbidden},
+		{"halt chain", ws.HaltChain(errNoCookie), http.StatusForbidden},
+		{"halt with status", ws.HaltChain(&ws.ValidationError{Status: http.StatusLocked, Err: errNoCookie}), http.StatusLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &chainCalls{}
+			chain := ws.ValidatorChain(
+				calls.validator("cookie", ws.SessionInfo{}, tt.err),
+				calls.validator("key", ws.SessionInfo{ClientID: ws.NewIdentity()}, nil),
+			)
+			server := newTestServer(t, ws.NewWebSocketHandler(chain, &mockMessageHandler{}, &mockEnvelopePersister{}))
+
+			if got := dialStatus(wsURL(server), nil); got != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, got)
+			}
+			if got := calls.ran(); len(got) != 1 {
+				t.Errorf("Expected the chain to stop after the first validator, got %v", got)
+			}
+		})
+	}
+}
+
+// challengeValidator rejects with a WWW-Authenticate challenge.
+type challengeValidator struct {
+	scheme string
+}
+
+func (v challengeValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	session, _, err := v.ValidateWithResponse(r)
+	return session, err
+}
+
+func (v challengeValidator) ValidateWithResponse(*http.Request) (ws.SessionInfo, http.Header, error) {
+	return ws.SessionInfo{}, http.Header{"Www-Authenticate": {v.scheme}},
+		&ws.ValidationError{Body: []byte(v.scheme + " required")}
+}
+
+func TestValidatorChainResponseHeaders(t *testing.T) {
+	chain := ws.ValidatorChain(challengeValidator{"Cookie"}, challengeValidator{"Bearer"})
+	server := newTestServer(t, ws.NewWebSocketHandler(chain, &mockMessageHandler{}, &mockEnvelopePersister{}))
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Values("WWW-Authenticate"); fmt.Sprint(got) != "[Cookie Bearer]" {
+		t.Errorf("Expected a challenge from each validator, got %v", got)
+	}
+	if string(body) != "Bearer required" {
+		t.Errorf("Expected the last validator's body, got %q", body)
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+type burstMessageHandler struct {
+	count int
+}
+
+func (b *burstMessageHandler) Handle(client *ws.Client, data []byte) error {
+	for i := 0; i < b.count; i++ {
+		client.Send <- []byte(fmt.Sprintf("%d", i))
+	}
+	return nil
+}
+
+func TestWritePumpDeliversQueuedMessagesInOrder(t *testing.T) {
+	const count = 500
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &burstMessageHandler{count: count}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("go")); err != nil {
+		t.Fatalf("Failed to write trigger message: %v", err)
+	}
+
+	for i := 0; i < count; i++ {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message %d: %v", i, err)
+		}
+		if messageType != websocket.TextMessage {
+			t.Errorf("Expected text frame, got %d", messageType)
+		}
+		if want := fmt.Sprintf("%d", i); string(data) != want {
+			t.Fatalf("Expected message %q, got %q", want, data)
+		}
+	}
+}
+
+type closeSendMessageHandler struct{}
+
+func (c *closeSendMessageHandler) Handle(client *ws.Client, data []byte) error {
+	client.Send <- []byte("bye")
+	close(client.Send)
+	return nil
+}
+
+func TestWritePumpClosesConnectionWhenSendIsClosed(t *testing.T) {
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, &closeSendMessageHandler{}, &mockEnvelopePersister{})
+	server := newTestServer(t, handler)
+	conn := dial(t, server)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("close")); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "bye" {
+		t.Fatalf("Expected final message before close, got %q (%v)", data, err)
+	}
+
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected normal closure, got %v", err)
+	}
+}
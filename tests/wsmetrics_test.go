@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wsmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrape returns the value of the metric name with labels, or -1 when the
+// registry has no such series. Histograms report their sample count.
+func scrape(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if want, ok := labels[pair.GetName()]; !ok || want != pair.GetValue() {
+					continue metrics
+				}
+			}
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				return m.Counter.GetValue()
+			case m.Gauge != nil:
+				return m.Gauge.GetValue()
+			case m.Histogram != nil:
+				return float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetricsCountTraffic(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	router := ws.NewRouter()
+	ws.Handle(router, "order", func(client *ws.Client, msg placeOrder) error { return nil })
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{}, router, &mockEnvelopePersister{},
+		wsmetrics.WithMetrics(registry),
+		ws.WithErrorHandler(func(*ws.Client, []byte, error) ws.ErrorAction { return ws.ErrorIgnore }),
+	)
+	conn := dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	frames := []string{
+		`{"type":"order","payload":{"sku":"A-1"}}`,
+		`{"type":"order","payload":{"sku":"B-2"}}`,
+		`{"type":"order","payload":"not an order"}`,
+		`{"type":"refund","payload":{}}`,
+	}
+	size := 0
+	for _, frame := range frames {
+		conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		size += len(frame)
+	}
+	if !waitFor(t, 2*time.Second, func() bool {
+		return scrape(t, registry, "ws_handler_duration_seconds", map[string]string{"type": "unknown"}) == 1
+	}) {
+		t.Fatal("Expected every frame to be handled")
+	}
+
+	if err := handler.Join("lobby", client); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	// The invalid payload was answered with an error frame before the reply.
+	handler.SendTo(client.ID, []byte(`{"type":"receipt"}`))
+	sent := 0
+	for i := 0; i < 2; i++ {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		sent += len(data)
+	}
+	waitFor(t, time.Second, func() bool {
+		return scrape(t, registry, "ws_sent_bytes_total", nil) == float64(sent)
+	})
+
+	for _, c := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ws_connections", nil, 1},
+		{"ws_room_connections", map[string]string{"room": "lobby"}, 1},
+		{"ws_messages_received_total", nil, 4},
+		{"ws_received_bytes_total", nil, float64(size)},
+		{"ws_messages_sent_total", nil, 2},
+		{"ws_sent_bytes_total", nil, float64(sent)},
+		{"ws_handler_duration_seconds", map[string]string{"type": "order"}, 3},
+		{"ws_handler_duration_seconds", map[string]string{"type": "unknown"}, 1},
+		{"ws_errors_total", map[string]string{"category": wsmetrics.CategoryInvalidPayload}, 1},
+		{"ws_errors_total", map[string]string{"category": wsmetrics.CategoryUnknownType}, 1},
+	} {
+		if got := scrape(t, registry, c.name, c.labels); got != c.want {
+			t.Errorf("Expected %s%v to be %v, got %v", c.name, c.labels, c.want, got)
+		}
+	}
+
+	conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool {
+		return scrape(t, registry, "ws_connections", nil) == 0
+	}) {
+		t.Error("Expected the connection gauge to drop on disconnect")
+	}
+	if got := scrape(t, registry, "ws_room_connections", map[string]string{"room": "lobby"}); got != -1 {
+		t.Errorf("Expected an emptied room to be unreported, got %v", got)
+	}
+}
+
+func TestMetricsCountRejections(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	refusing := ws.NewWebSocketHandler(&mockSessionValidator{shouldFail: true}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		wsmetrics.WithMetrics(registry, wsmetrics.WithConstLabels(prometheus.Labels{"handler": "refusing"})))
+	accepting := ws.NewWebSocketHandler(&mockSessionValidator{}, &mockMessageHandler{}, &mockEnvelopePersister{},
+		wsmetrics.WithMetrics(registry, wsmetrics.WithConstLabels(prometheus.Labels{"handler": "accepting"})))
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL(newTestServer(t, refusing)), nil); err == nil {
+		t.Fatal("Expected the upgrade to be refused")
+	} else if resp != nil {
+		resp.Body.Close()
+	}
+	// A plain request passes validation and fails the handshake itself.
+	resp, err := http.Get(newTestServer(t, accepting).URL)
+	if err != nil {
+		t.Fatalf("Failed to send a plain request: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, c := range []struct {
+		handler, reason string
+	}{
+		{"refusing", wsmetrics.ReasonUnauthorized},
+		{"accepting", wsmetrics.ReasonHandshake},
+	} {
+		labels := map[string]string{"handler": c.handler, "reason": c.reason}
+		if got := scrape(t, registry, "ws_upgrade_rejections_total", labels); got != 1 {
+			t.Errorf("Expected one %s rejection by %s, got %v", c.reason, c.handler, got)
+		}
+	}
+	if got := scrape(t, registry, "ws_connections", map[string]string{"handler": "accepting"}); got != 0 {
+		t.Errorf("Expected no open connections, got %v", got)
+	}
+}
+
+func TestMetricsCountDrops(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	clientID := ws.NewIdentity()
+	handler := ws.NewWebSocketHandler(&mockSessionValidator{session: ws.SessionInfo{ClientID: clientID}},
+		&mockMessageHandler{}, &mockEnvelopePersister{},
+		wsmetrics.WithMetrics(registry, wsmetrics.WithNamespace("chat")),
+		ws.WithSlowConsumerPolicy(ws.SlowConsumerDropNewest))
+	dial(t, newTestServer(t, handler))
+	client := registeredClient(t, handler)
+
+	big := make([]byte, 1<<20)
+	for i := 0; i < 20; i++ {
+		handler.SendTo(clientID, big)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 300; i++ {
+		handler.SendTo(clientID, []byte(`{"type":"tick"}`))
+	}
+
+	dropped := float64(client.Dropped())
+	if dropped == 0 {
+		t.Fatal("Expected the stalled client to drop messages")
+	}
+	if got := scrape(t, registry, "chat_dropped_messages_total", nil); got != dropped {
+		t.Errorf("Expected %v dropped messages, got %v", dropped, got)
+	}
+}
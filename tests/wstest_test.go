@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/oduortoni/websocket/ws/wstest"
+)
+
+// recordingT captures the failure of a wstest helper instead of failing the
+// test, so the diagnostics themselves can be checked.
+type recordingT struct {
+	testing.TB
+	failure string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Fatal(args ...any) {
+	r.failure = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (r *recordingT) Fatalf(format string, args ...any) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// failure runs fn with a recordingT and returns what it failed with.
+func failure(t *testing.T, fn func(t testing.TB)) string {
+	t.Helper()
+	r := &recordingT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+	if r.failure == "" {
+		t.Fatal("Expected the helper to fail")
+	}
+	return r.failure
+}
+
+func newPingServer(t *testing.T) (*wstest.Server, *ws.WebsocketHandler) {
+	t.Helper()
+	router := ws.NewRouter()
+	ws.Handle(router, "ping", func(client *ws.Client, msg struct{ N int }) error {
+		reply, _ := ws.NewEnvelope(client.ID, "pong", map[string]int{"n": msg.N + 1})
+		return client.SendEnvelope(reply)
+	})
+	handler := ws.NewWebSocketHandler(&headerSessionValidator{}, router, nil)
+	return wstest.NewServer(t, handler), handler
+}
+
+func TestWSTestRoundTrip(t *testing.T) {
+	server, _ := newPingServer(t)
+	conn := server.Connect(t)
+
+	conn.MustSendEnvelope("ping", map[string]int{"n": 1})
+	env := conn.ExpectEnvelope("pong", time.Second)
+	var pong struct{ N int }
+	if err := env.DecodePayload(&pong); err != nil || pong.N != 2 {
+		t.Errorf("Expected pong 2, got %s (%v)", env.Payload, err)
+	}
+
+	conn.MustSendJSON(map[string]any{"type": "ping", "payload": map[string]int{"n": 5}})
+	var frame struct {
+		Type    string
+		Payload struct{ N int }
+	}
+	if conn.ExpectJSON(&frame, time.Second); frame.Type != "pong" || frame.Payload.N != 6 {
+		t.Errorf("Expected pong 6, got %+v", frame)
+	}
+	conn.ExpectNoMessage(50 * time.Millisecond)
+}
+
+func TestWSTestConnectOptions(t *testing.T) {
+	server, handler := newPingServer(t)
+	id := ws.NewIdentity()
+	conn := server.Connect(t, wstest.WithHeader("X-Test-Client-ID", id.String()))
+
+	if conn.Response.StatusCode != 101 {
+		t.Errorf("Expected the upgrade response, got %d", conn.Response.StatusCode)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(id); return ok }) {
+		t.Error("Expected the header to reach the validator")
+	}
+}
+
+func TestWSTestExpectClose(t *testing.T) {
+	server, handler := newPingServer(t)
+	id := ws.NewIdentity()
+	conn := server.Connect(t, wstest.WithHeader("X-Test-Client-ID", id.String()))
+	waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(id); return ok })
+
+	handler.SendTo(id, []byte("last words"))
+	handler.Disconnect(id, websocket.ClosePolicyViolation, "banned")
+	if reason := conn.ExpectClose(websocket.ClosePolicyViolation); reason != "banned" {
+		t.Errorf("Expected the close reason, got %q", reason)
+	}
+}
+
+func TestWSTestClosesConnectionsOnCleanup(t *testing.T) {
+	server, handler := newPingServer(t)
+	t.Run("connect", func(t *testing.T) {
+		server.Connect(t)
+		registeredClient(t, handler)
+	})
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == 0 }) {
+		t.Error("Expected the connection to close when the subtest ended")
+	}
+}
+
+func TestWSTestFailureDiagnostics(t *testing.T) {
+	server, handler := newPingServer(t)
+
+	msg := failure(t, func(rt testing.TB) {
+		conn := server.Connect(rt)
+		conn.MustSendEnvelope("ping", map[string]int{"n": 1})
+		conn.ExpectEnvelope("pong", time.Second)
+		conn.ExpectMessage(50 * time.Millisecond)
+	})
+	if !strings.Contains(msg, "expected a message within 50ms") || !strings.Contains(msg, `"type\":\"pong\"`) {
+		t.Errorf("Expected the timeout and the last frame received, got %s", msg)
+	}
+
+	id := ws.NewIdentity()
+	msg = failure(t, func(rt testing.TB) {
+		conn := server.Connect(rt, wstest.WithHeader("X-Test-Client-ID", id.String()))
+		waitFor(t, 2*time.Second, func() bool { _, ok := handler.Get(id); return ok })
+		handler.Disconnect(id, websocket.CloseNormalClosure, "done")
+		conn.ExpectClose(websocket.ClosePolicyViolation)
+	})
+	if !strings.Contains(msg, `expected close 1008, got 1000 "done"`) {
+		t.Errorf("Expected the close actually received, got %s", msg)
+	}
+
+	refusing := wstest.NewServer(t, ws.NewWebSocketHandler(&mockSessionValidator{shouldFail: true}, &mockMessageHandler{}, nil))
+	msg = failure(t, func(rt testing.TB) { refusing.Connect(rt) })
+	if !strings.Contains(msg, "401") {
+		t.Errorf("Expected the refused upgrade's status, got %s", msg)
+	}
+}
+
+func TestWSTestConnPair(t *testing.T) {
+	server, client := wstest.NewConnPair(t)
+
+	go server.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3})
+	if messageType, data, err := client.ReadMessage(); err != nil || messageType != websocket.BinaryMessage || len(data) != 3 {
+		t.Fatalf("Expected the server's binary frame, got %d %v (%v)", messageType, data, err)
+	}
+	go client.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, data, err := server.ReadMessage(); err != nil || string(data) != "hi" {
+		t.Fatalf("Expected the client's text frame, got %q (%v)", data, err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := server.ReadMessage(); !isTimeout(err) {
+		t.Errorf("Expected the read deadline to expire, got %v", err)
+	}
+}
+
+func TestWSTestPipeServerConnectsSeveralClients(t *testing.T) {
+	_, handler := newPingServer(t)
+	pipes := wstest.NewPipeServer(t, handler)
+
+	ids := []ws.Identity{ws.NewIdentity(), ws.NewIdentity()}
+	conns := make([]*wstest.Conn, len(ids))
+	for i, id := range ids {
+		conns[i] = pipes.Connect(t, wstest.WithHeader("X-Test-Client-ID", id.String()))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return handler.Len() == len(ids) }) {
+		t.Fatalf("Expected %d clients, got %d", len(ids), handler.Len())
+	}
+
+	a, _ := handler.Get(ids[0])
+	b, _ := handler.Get(ids[1])
+	if a.RemoteIP().String() != "127.0.0.1" || a.RemoteAddr() == b.RemoteAddr() {
+		t.Errorf("Expected distinct loopback addresses, got %s and %s", a.RemoteAddr(), b.RemoteAddr())
+	}
+
+	for i, conn := range conns {
+		conn.MustSendEnvelope("ping", map[string]int{"n": i})
+		var pong struct{ N int }
+		conn.ExpectEnvelope("pong", time.Second).DecodePayload(&pong)
+		if pong.N != i+1 {
+			t.Errorf("Expected pong %d, got %d", i+1, pong.N)
+		}
+	}
+}
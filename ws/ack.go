@@ -0,0 +1,44 @@
+package ws
+
+// Acknowledge confirms delivery of an envelope previously sent to the client.
+// It returns ErrUnknownEnvelope when id is not pending for this connection,
+// which covers acks for envelopes sent to someone else.
+func (c *Client) Acknowledge(id Identity) error {
+	c.mu.Lock()
+	d, ok := c.unacked[id]
+	delete(c.unacked, id)
+	if ok && d.timer != nil {
+		d.timer.Stop()
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return ErrUnknownEnvelope
+	}
+	if c.handler == nil || c.handler.EnvelopePersister == nil {
+		return nil
+	}
+	if confirmer, ok := c.handler.EnvelopePersister.(DeliveryConfirmer); ok {
+		envelope := d.envelope
+		envelope.MarkDelivered(c.handler.config.clock.Now())
+		return confirmer.ConfirmEnvelope(envelope)
+	}
+	return c.handler.EnvelopePersister.ConfirmDelivery(id, c.ID)
+}
+
+// handleAck processes an ack frame, answering with an error frame when the
+// envelope ID is missing, unknown or cannot be confirmed.
+func (c *Client) handleAck(id Identity) {
+	if id.IsZero() {
+		c.SendError(ErrorCodeInvalidAck, "ack id is not a valid envelope ID", "")
+		return
+	}
+
+	rawID := id.String()
+	switch err := c.Acknowledge(id); {
+	case err == ErrUnknownEnvelope:
+		c.SendError(ErrorCodeUnknownEnvelope, "no pending envelope with this ID", rawID)
+	case err != nil:
+		c.SendError(ErrorCodeConfirmFailed, "delivery could not be confirmed", rawID)
+	}
+}
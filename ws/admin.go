@@ -0,0 +1,226 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AdminOption configures the handler returned by AdminHandler.
+type AdminOption func(*adminConfig)
+
+type adminConfig struct {
+	authorize func(r *http.Request) bool
+}
+
+// WithAdminAuthorizer refuses admin requests for which authorize returns
+// false with 403 Forbidden. Without it every request is served, so mount the
+// admin handler where only operators can reach it.
+func WithAdminAuthorizer(authorize func(r *http.Request) bool) AdminOption {
+	return func(c *adminConfig) {
+		c.authorize = authorize
+	}
+}
+
+// AdminConnection describes one live connection on the admin endpoints.
+type AdminConnection struct {
+	ClientID    Identity   `json:"client_id"`
+	RemoteAddr  string     `json:"remote_addr"`
+	RemoteIP    netip.Addr `json:"remote_ip"`
+	Subprotocol string     `json:"subprotocol,omitempty"`
+	Connected   time.Time  `json:"connected"`
+	Rooms       []string   `json:"rooms"`
+
+	// Queued is the number of frames waiting in the send queue and
+	// QueuedBytes their size.
+	Queued      int   `json:"queued"`
+	QueuedBytes int64 `json:"queued_bytes"`
+
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+	Dropped     uint64 `json:"dropped"`
+
+	RTT time.Duration `json:"rtt_ns,omitempty"`
+}
+
+// AdminClient describes an identity and each of its connections.
+type AdminClient struct {
+	Stats       ClientStats       `json:"stats"`
+	Connections []AdminConnection `json:"connections"`
+}
+
+// AdminRoom describes a room on the admin endpoints. Members counts
+// identities and Connections their connections in the room.
+type AdminRoom struct {
+	Name        string `json:"name"`
+	Members     int    `json:"members"`
+	Connections int    `json:"connections"`
+}
+
+// AdminDisconnect is the optional body of a disconnect request. Code
+// defaults to 1000 (normal closure).
+type AdminDisconnect struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// AdminHandler returns an http.Handler serving JSON endpoints to inspect
+// and control the handler's live connections:
+//
+//	GET  /connections                   every connection, oldest first
+//	GET  /connections/{id}              one identity's stats and connections
+//	POST /connections/{id}/disconnect   close an identity's connections
+//	GET  /rooms                         every room with its size
+//
+// The disconnect body is an AdminDisconnect. Errors are answered with
+// {"error": "..."}. Mount the handler on an internal mux, under a prefix
+// with http.StripPrefix:
+//
+//	admin := http.NewServeMux()
+//	admin.Handle("/ws/", http.StripPrefix("/ws", wsHandler.AdminHandler(
+//		ws.WithAdminAuthorizer(isOperator),
+//	)))
+func (h *WebsocketHandler) AdminHandler(opts ...AdminOption) http.Handler {
+	var config adminConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", h.adminConnections)
+	mux.HandleFunc("GET /connections/{id}", h.adminClient)
+	mux.HandleFunc("POST /connections/{id}/disconnect", h.adminDisconnect)
+	mux.HandleFunc("GET /rooms", h.adminRooms)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.authorize != nil && !config.authorize(r) {
+			adminError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (h *WebsocketHandler) adminConnections(w http.ResponseWriter, r *http.Request) {
+	clients := h.Hub.snapshot()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Connected.Before(clients[j].Connected)
+	})
+
+	connections := make([]AdminConnection, 0, len(clients))
+	for _, client := range clients {
+		connections = append(connections, h.adminConnection(client))
+	}
+	adminJSON(w, http.StatusOK, connections)
+}
+
+func (h *WebsocketHandler) adminClient(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminIdentity(w, r)
+	if !ok {
+		return
+	}
+	stats, ok := h.ClientStats(id)
+	if !ok {
+		adminError(w, http.StatusNotFound, ErrClientNotConnected.Error())
+		return
+	}
+
+	client := AdminClient{Stats: stats}
+	for _, c := range h.Hub.Connections(id) {
+		client.Connections = append(client.Connections, h.adminConnection(c))
+	}
+	adminJSON(w, http.StatusOK, client)
+}
+
+func (h *WebsocketHandler) adminDisconnect(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminIdentity(w, r)
+	if !ok {
+		return
+	}
+	req := AdminDisconnect{Code: websocket.CloseNormalClosure}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		adminError(w, http.StatusBadRequest, "body must be a JSON object with code and reason")
+		return
+	}
+	if !validAdminCloseCode(req.Code) {
+		adminError(w, http.StatusBadRequest, "code must be 1000 or between 3000 and 4999")
+		return
+	}
+	if len(req.Reason) > maxCloseReason {
+		adminError(w, http.StatusBadRequest, "reason is too long")
+		return
+	}
+
+	connections := len(h.Hub.Connections(id))
+	if err := h.Disconnect(id, req.Code, req.Reason); err != nil {
+		adminError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	adminJSON(w, http.StatusOK, map[string]int{"disconnected": connections})
+}
+
+func (h *WebsocketHandler) adminRooms(w http.ResponseWriter, r *http.Request) {
+	h.Hub.mu.RLock()
+	rooms := make([]AdminRoom, 0, len(h.Hub.rooms))
+	for name, room := range h.Hub.rooms {
+		rooms = append(rooms, AdminRoom{Name: name, Members: len(room.identities), Connections: len(room.members)})
+	}
+	h.Hub.mu.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	adminJSON(w, http.StatusOK, rooms)
+}
+
+func (h *WebsocketHandler) adminConnection(client *Client) AdminConnection {
+	stats := client.Stats()
+	return AdminConnection{
+		ClientID:    client.ID,
+		RemoteAddr:  client.RemoteAddr(),
+		RemoteIP:    client.RemoteIP(),
+		Subprotocol: client.Subprotocol(),
+		Connected:   client.Connected,
+		Rooms:       h.Hub.roomsOf(client),
+		Queued:      len(client.queue),
+		QueuedBytes: stats.QueuedBytes,
+		MessagesIn:  stats.MessagesIn,
+		MessagesOut: stats.MessagesOut,
+		BytesIn:     stats.BytesIn,
+		BytesOut:    stats.BytesOut,
+		Dropped:     stats.Dropped,
+		RTT:         stats.RTT,
+	}
+}
+
+// maxCloseReason is the longest close reason a close frame can carry.
+const maxCloseReason = 123
+
+func validAdminCloseCode(code int) bool {
+	return code == websocket.CloseNormalClosure || (code >= 3000 && code <= 4999)
+}
+
+func adminIdentity(w http.ResponseWriter, r *http.Request) (Identity, bool) {
+	id, err := ParseIdentity(r.PathValue("id"))
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "id is not a valid identity")
+		return Identity{}, false
+	}
+	return id, true
+}
+
+func adminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, status int, message string) {
+	adminJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,53 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageAuthorizer decides, message by message, whether a client may send
+// an envelope. It complements the SessionValidator, which runs only once at
+// upgrade, so permissions that change mid-connection take effect
+// immediately. Returning a non-nil error denies the message; returning an
+// *ErrorFrame also chooses the code and message the client is sent instead
+// of ErrorCodeUnauthorized.
+type MessageAuthorizer interface {
+	Authorize(client *Client, msgType string, env Envelope) error
+}
+
+// MessageAuthorizerFunc adapts a function to the MessageAuthorizer
+// interface.
+type MessageAuthorizerFunc func(client *Client, msgType string, env Envelope) error
+
+func (f MessageAuthorizerFunc) Authorize(client *Client, msgType string, env Envelope) error {
+	return f(client, msgType, env)
+}
+
+// authorize asks a whether the client may send env. A denied message is
+// rejected with an unauthorized error frame referencing the envelope ID, or the
+// *ErrorFrame the authorizer returned, and the returned error wraps both
+// ErrMessageDenied and the authorizer's error.
+// Once the client exceeds the handler's denial limit the connection is
+// closed with code 1008 (Policy Violation).
+func (c *Client) authorize(a MessageAuthorizer, env Envelope) error {
+	err := a.Authorize(c, env.Type, env)
+	if err == nil {
+		return nil
+	}
+
+	code, message := ErrorCodeUnauthorized, "message not authorized"
+	var frame *ErrorFrame
+	if errors.As(err, &frame) {
+		code, message = frame.Code, frame.Message
+	}
+	c.SendError(code, message, env.ID.String())
+	if c.handler != nil && c.handler.config.maxDenials > 0 && c.denials.Add(1) == uint64(c.handler.config.maxDenials)+1 {
+		c.enqueue(outbound{
+			data:      []byte("too many unauthorized messages"),
+			closeCode: websocket.ClosePolicyViolation,
+		})
+	}
+	return fmt.Errorf("%w: %w", ErrMessageDenied, err)
+}
@@ -0,0 +1,391 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchSaver is implemented by persisters that can store several envelopes
+// in one call. BatchingPersister uses it when available and otherwise saves
+// envelopes one at a time.
+type BatchSaver interface {
+	SaveEnvelopes(envelopes []Envelope) error
+}
+
+// BatchFailurePolicy decides how a BatchingPersister treats new envelopes
+// while flushes to the wrapped persister are failing. Envelopes already
+// buffered are always kept and retried.
+type BatchFailurePolicy int
+
+const (
+	// BatchBufferOnFailure keeps accepting envelopes until the buffer limit
+	// is reached, then rejects them with ErrPersisterUnavailable.
+	BatchBufferOnFailure BatchFailurePolicy = iota
+
+	// BatchRejectOnFailure rejects new envelopes with
+	// ErrPersisterUnavailable until a flush succeeds, so clients learn
+	// immediately that their messages were not stored.
+	BatchRejectOnFailure
+)
+
+// BatchingPersister wraps an EnvelopePersister and saves envelopes in
+// batches, flushing when the batch size is reached or the flush interval
+// elapses. Call Close on shutdown so buffered envelopes are not lost.
+//
+// Confirmations, fetches and purges flush first and then go to the wrapped
+// persister, which should implement the matching optional interface.
+type BatchingPersister struct {
+	inner       EnvelopePersister
+	clock       Clock
+	size        int
+	interval    time.Duration
+	maxBuffered int
+	policy      BatchFailurePolicy
+
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	buffer  []Envelope
+	pending map[Identity]struct{}
+	timer   Timer
+	failing error
+	closed  bool
+}
+
+// BatchingOption configures a BatchingPersister.
+type BatchingOption func(*BatchingPersister)
+
+// WithBatchSize sets how many buffered envelopes trigger a flush. The
+// default is 100.
+func WithBatchSize(n int) BatchingOption {
+	return func(p *BatchingPersister) {
+		p.size = n
+	}
+}
+
+// WithBatchFlushInterval sets the longest an envelope waits in the buffer.
+// The default is one second.
+func WithBatchFlushInterval(d time.Duration) BatchingOption {
+	return func(p *BatchingPersister) {
+		p.interval = d
+	}
+}
+
+// WithBatchMaxBuffered caps the buffer while flushes fail under
+// BatchBufferOnFailure. The default is ten batches.
+func WithBatchMaxBuffered(n int) BatchingOption {
+	return func(p *BatchingPersister) {
+		p.maxBuffered = n
+	}
+}
+
+// WithBatchFailurePolicy sets how new envelopes are treated while flushes
+// fail. The default is BatchBufferOnFailure.
+func WithBatchFailurePolicy(policy BatchFailurePolicy) BatchingOption {
+	return func(p *BatchingPersister) {
+		p.policy = policy
+	}
+}
+
+// WithBatchClock replaces the clock driving the flush interval.
+func WithBatchClock(clock Clock) BatchingOption {
+	return func(p *BatchingPersister) {
+		p.clock = clock
+	}
+}
+
+func NewBatchingPersister(inner EnvelopePersister, opts ...BatchingOption) *BatchingPersister {
+	p := &BatchingPersister{
+		inner:    inner,
+		clock:    systemClock{},
+		size:     100,
+		interval: time.Second,
+		pending:  make(map[Identity]struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.maxBuffered <= 0 {
+		p.maxBuffered = 10 * p.size
+	}
+	return p
+}
+
+// SaveEnvelope buffers e, flushing in the caller when the batch is full.
+func (p *BatchingPersister) SaveEnvelope(e Envelope) error {
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		return ErrPersisterClosed
+	case p.failing != nil && (p.policy == BatchRejectOnFailure || len(p.buffer) >= p.maxBuffered):
+		err := p.failing
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrPersisterUnavailable, err)
+	}
+
+	p.buffer = append(p.buffer, e)
+	p.pending[e.ID] = struct{}{}
+	full := len(p.buffer) >= p.size && p.failing == nil
+	if !full && p.timer == nil {
+		p.timer = p.clock.AfterFunc(p.interval, func() { p.Flush() })
+	}
+	p.mu.Unlock()
+
+	if full {
+		p.Flush()
+	}
+	return nil
+}
+
+// Flush saves every buffered envelope to the wrapped persister. On failure
+// the envelopes stay buffered and are retried after the flush interval.
+func (p *BatchingPersister) Flush() error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	batch := p.buffer
+	p.buffer = nil
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	unsaved, err := p.save(batch)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range batch[:len(batch)-len(unsaved)] {
+		delete(p.pending, e.ID)
+	}
+	if err != nil {
+		p.buffer = append(unsaved, p.buffer...)
+		p.failing = err
+		if !p.closed {
+			p.timer = p.clock.AfterFunc(p.interval, func() { p.Flush() })
+		}
+		return err
+	}
+	p.failing = nil
+	if len(p.buffer) > 0 && p.timer == nil && !p.closed {
+		p.timer = p.clock.AfterFunc(p.interval, func() { p.Flush() })
+	}
+	return nil
+}
+
+// save writes batch to the wrapped persister, returning the tail of batch
+// that was not saved.
+func (p *BatchingPersister) save(batch []Envelope) ([]Envelope, error) {
+	if saver, ok := p.inner.(BatchSaver); ok {
+		if err := saver.SaveEnvelopes(batch); err != nil {
+			return batch, err
+		}
+		return nil, nil
+	}
+	for i, e := range batch {
+		if err := p.inner.SaveEnvelope(e); err != nil {
+			return batch[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// Close flushes the buffer and stops accepting envelopes. It returns the
+// flush error, in which case the unsaved envelopes remain buffered and
+// Flush may be retried.
+func (p *BatchingPersister) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return p.Flush()
+}
+
+// ConfirmDelivery flushes first when the envelope is still buffered.
+func (p *BatchingPersister) ConfirmDelivery(envelopeID Identity, clientID Identity) error {
+	if p.isPending(envelopeID) {
+		if err := p.Flush(); err != nil {
+			return err
+		}
+	}
+	return p.inner.ConfirmDelivery(envelopeID, clientID)
+}
+
+// ConfirmEnvelope flushes first when the envelope is still buffered, and
+// passes e on to the wrapped persister when it is a DeliveryConfirmer.
+func (p *BatchingPersister) ConfirmEnvelope(e Envelope) error {
+	confirmer, ok := p.inner.(DeliveryConfirmer)
+	if !ok {
+		return p.ConfirmDelivery(e.ID, e.ClientID)
+	}
+	if p.isPending(e.ID) {
+		if err := p.Flush(); err != nil {
+			return err
+		}
+	}
+	return confirmer.ConfirmEnvelope(e)
+}
+
+// MarkRead flushes first when the envelope is still buffered, and delegates
+// to the wrapped persister when it is a ReadMarker.
+func (p *BatchingPersister) MarkRead(envelopeID, clientID Identity, at time.Time) error {
+	marker, ok := p.inner.(ReadMarker)
+	if !ok {
+		return nil
+	}
+	if p.isPending(envelopeID) {
+		if err := p.Flush(); err != nil {
+			return err
+		}
+	}
+	return marker.MarkRead(envelopeID, clientID, at)
+}
+
+// FetchUndelivered flushes and then fetches from the wrapped persister. It
+// returns nothing if the wrapped persister is not an UndeliveredFetcher.
+func (p *BatchingPersister) FetchUndelivered(clientID Identity) ([]Envelope, error) {
+	fetcher, ok := p.inner.(UndeliveredFetcher)
+	if !ok {
+		return nil, nil
+	}
+	if err := p.Flush(); err != nil {
+		return nil, err
+	}
+	return fetcher.FetchUndelivered(clientID)
+}
+
+// SaveScheduled flushes and then schedules e in the wrapped persister. It
+// returns ErrPersisterUnsupported if the wrapped persister is not an
+// EnvelopeScheduler.
+func (p *BatchingPersister) SaveScheduled(e Envelope, at time.Time) error {
+	scheduler, ok := p.inner.(EnvelopeScheduler)
+	if !ok {
+		return ErrPersisterUnsupported
+	}
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	return scheduler.SaveScheduled(e, at)
+}
+
+// FetchDue delegates to the wrapped persister when it is an
+// EnvelopeScheduler. Scheduled envelopes are never buffered, so there is
+// nothing to flush first.
+func (p *BatchingPersister) FetchDue(now time.Time, lease time.Duration) ([]Envelope, error) {
+	scheduler, ok := p.inner.(EnvelopeScheduler)
+	if !ok {
+		return nil, ErrPersisterUnsupported
+	}
+	return scheduler.FetchDue(now, lease)
+}
+
+// ConfirmScheduled delegates to the wrapped persister when it is an
+// EnvelopeScheduler.
+func (p *BatchingPersister) ConfirmScheduled(envelopeID Identity) error {
+	scheduler, ok := p.inner.(EnvelopeScheduler)
+	if !ok {
+		return ErrPersisterUnsupported
+	}
+	return scheduler.ConfirmScheduled(envelopeID)
+}
+
+// CancelScheduled delegates to the wrapped persister when it is an
+// EnvelopeScheduler.
+func (p *BatchingPersister) CancelScheduled(envelopeID Identity) error {
+	scheduler, ok := p.inner.(EnvelopeScheduler)
+	if !ok {
+		return ErrPersisterUnsupported
+	}
+	return scheduler.CancelScheduled(envelopeID)
+}
+
+// CountUndelivered flushes and then counts in the wrapped persister. It
+// returns zero if the wrapped persister is not an UndeliveredCounter.
+func (p *BatchingPersister) CountUndelivered(clientID Identity) (int, error) {
+	counter, ok := p.inner.(UndeliveredCounter)
+	if !ok {
+		return 0, nil
+	}
+	if err := p.Flush(); err != nil {
+		return 0, err
+	}
+	return counter.CountUndelivered(clientID)
+}
+
+// FetchEnvelopes flushes and then fetches from the wrapped persister. It
+// returns nothing if the wrapped persister is not an EnvelopeHistoryFetcher.
+func (p *BatchingPersister) FetchEnvelopes(clientID Identity, before Identity, limit int) ([]Envelope, error) {
+	fetcher, ok := p.inner.(EnvelopeHistoryFetcher)
+	if !ok {
+		return nil, nil
+	}
+	if err := p.Flush(); err != nil {
+		return nil, err
+	}
+	return fetcher.FetchEnvelopes(clientID, before, limit)
+}
+
+// FetchRoomHistory flushes and then fetches from the wrapped persister. It
+// returns nothing if the wrapped persister is not a RoomHistoryFetcher.
+func (p *BatchingPersister) FetchRoomHistory(room string, limit int, before time.Time) ([]Envelope, error) {
+	fetcher, ok := p.inner.(RoomHistoryFetcher)
+	if !ok {
+		return nil, nil
+	}
+	if err := p.Flush(); err != nil {
+		return nil, err
+	}
+	return fetcher.FetchRoomHistory(room, limit, before)
+}
+
+// PurgeExpired delegates to the wrapped persister when it is an
+// ExpiredPurger.
+func (p *BatchingPersister) PurgeExpired(before time.Time) (int, error) {
+	purger, ok := p.inner.(ExpiredPurger)
+	if !ok {
+		return 0, nil
+	}
+	return purger.PurgeExpired(before)
+}
+
+// PurgeDelivered delegates to the wrapped persister when it is a
+// DeliveredPurger. Buffered envelopes have not been confirmed, so there is
+// nothing to flush first.
+func (p *BatchingPersister) PurgeDelivered(before time.Time) (int, error) {
+	purger, ok := p.inner.(DeliveredPurger)
+	if !ok {
+		return 0, nil
+	}
+	return purger.PurgeDelivered(before)
+}
+
+// Exists reports whether the envelope is buffered or known to the wrapped
+// persister.
+func (p *BatchingPersister) Exists(id Identity) (bool, error) {
+	if p.isPending(id) {
+		return true, nil
+	}
+	if checker, ok := p.inner.(EnvelopeChecker); ok {
+		return checker.Exists(id)
+	}
+	return false, nil
+}
+
+// Buffered returns the number of envelopes waiting to be flushed.
+func (p *BatchingPersister) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.buffer)
+}
+
+func (p *BatchingPersister) isPending(id Identity) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.pending[id]
+	return ok
+}
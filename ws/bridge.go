@@ -0,0 +1,240 @@
+package ws
+
+import "encoding/json"
+
+// BridgeTopic is the topic a hub publishes broadcasts and directed sends on.
+// Room broadcasts get a topic per room, named by RoomTopic.
+const BridgeTopic = "hub"
+
+// RoomTopic returns the topic broadcasts to the named room are published on.
+// A hub subscribes to it once a local client first joins the room, so nodes
+// with no members do not receive the room's traffic.
+func RoomTopic(roomName string) string {
+	return "room." + roomName
+}
+
+// Bridge carries messages between the hubs of several nodes serving the same
+// clients, such as instances of an app behind a load balancer. Publish must
+// deliver data to the functions every other node subscribed to topic, but
+// not back to the publishing node, or its local clients would get broadcasts
+// twice; implementations usually tag each message with a per-node instance ID
+// and drop their own.
+type Bridge interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string, fn func(data []byte)) error
+}
+
+// DirectBridge is implemented by bridges that know which nodes each identity
+// is connected to, so a directed send goes to those nodes alone instead of
+// being published to every node on BridgeTopic.
+//
+// The hub calls Track when an identity gets its first local connection and
+// Untrack when it loses its last, with part of the hub locked, so neither
+// may block or call back into the hub. PublishTo returns
+// ErrClientNotConnected when no other node has id; what it publishes arrives
+// on the recipient nodes through the function they passed to SubscribeDirect.
+type DirectBridge interface {
+	Bridge
+	Track(id Identity)
+	Untrack(id Identity)
+	PublishTo(id Identity, data []byte) error
+	SubscribeDirect(fn func(data []byte)) error
+}
+
+// WithBridge connects the handler's hub to the other nodes sharing b, as
+// Hub.UseBridge does. NewHandler fails if the subscription does.
+func WithBridge(b Bridge) Option {
+	return func(c *config) {
+		c.bridge = b
+	}
+}
+
+const (
+	bridgeBroadcast    = "broadcast"
+	bridgeRoom         = "room"
+	bridgeSend         = "send"
+	bridgeEnvelope     = "envelope"
+	bridgeEphemeral    = "ephemeral"
+	bridgePublish      = "publish"
+	bridgeRoomEnvelope = "room_envelope"
+)
+
+// bridgeMessage is what a hub publishes on its bridge.
+type bridgeMessage struct {
+	Kind     string    `json:"kind"`
+	Room     string    `json:"room,omitempty"`
+	ClientID Identity  `json:"client_id"`
+	Data     []byte    `json:"data,omitempty"`
+	Envelope *Envelope `json:"envelope,omitempty"`
+}
+
+// UseBridge connects the hub to the other nodes sharing b. From then on
+// Broadcast, BroadcastJSON, BroadcastToRoom and Publish also reach the clients of
+// every other node, and SendTo and SendEnvelope forward messages for
+// identities with no local connection instead of failing with
+// ErrClientNotConnected. Forwarding is best effort: a broadcast that could
+// not be published still reaches local clients, and the counts in its
+// result are local ones.
+func (h *Hub) UseBridge(b Bridge) error {
+	if err := b.Subscribe(BridgeTopic, h.receiveBridged); err != nil {
+		return err
+	}
+	direct, _ := b.(DirectBridge)
+	if direct != nil {
+		if err := direct.SubscribeDirect(h.receiveBridged); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	h.bridge = b
+	h.bridgedRooms = make(map[string]struct{})
+	rooms := make([]string, 0, len(h.rooms))
+	for roomName := range h.rooms {
+		h.bridgedRooms[roomName] = struct{}{}
+		rooms = append(rooms, roomName)
+	}
+	h.mu.Unlock()
+
+	// Identities registered from here on are tracked by Register.
+	if direct != nil {
+		for _, shard := range h.shards {
+			shard.mu.RLock()
+			for id := range shard.byID {
+				direct.Track(id)
+			}
+			shard.mu.RUnlock()
+		}
+	}
+
+	for _, roomName := range rooms {
+		if err := b.Subscribe(RoomTopic(roomName), h.receiveBridged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribeRoom subscribes to the named room's topic the first time a local
+// client joins it. Subscriptions are kept after the room empties; broadcasts
+// to a room without local members are simply not delivered.
+func (h *Hub) subscribeRoom(roomName string) error {
+	h.mu.Lock()
+	b := h.bridge
+	_, subscribed := h.bridgedRooms[roomName]
+	if b == nil || subscribed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.bridgedRooms[roomName] = struct{}{}
+	h.mu.Unlock()
+
+	if err := b.Subscribe(RoomTopic(roomName), h.receiveBridged); err != nil {
+		h.mu.Lock()
+		delete(h.bridgedRooms, roomName)
+		h.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// track and untrack report an identity's first and last local connection
+// to a DirectBridge. The identity's shard must be locked, so that they
+// are reported in order.
+func (h *Hub) track(id Identity) {
+	if direct, ok := h.currentBridge().(DirectBridge); ok {
+		direct.Track(id)
+	}
+}
+
+func (h *Hub) untrack(id Identity) {
+	if direct, ok := h.currentBridge().(DirectBridge); ok {
+		direct.Untrack(id)
+	}
+}
+
+func (h *Hub) currentBridge() Bridge {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.bridge
+}
+
+// forward publishes msg to the other nodes on topic, reporting false when
+// the hub has no bridge.
+func (h *Hub) forward(topic string, msg bridgeMessage) (bool, error) {
+	h.mu.RLock()
+	b := h.bridge
+	h.mu.RUnlock()
+	if b == nil {
+		return false, nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return true, err
+	}
+	return true, b.Publish(topic, data)
+}
+
+// forwardUnreachable forwards msg for id when err reports that id has no
+// local connection, returning the error the caller should see.
+func (h *Hub) forwardUnreachable(id Identity, msg bridgeMessage, err error) error {
+	if err != ErrClientNotConnected {
+		return err
+	}
+
+	h.mu.RLock()
+	direct, ok := h.bridge.(DirectBridge)
+	h.mu.RUnlock()
+	if !ok {
+		if forwarded, fwdErr := h.forward(BridgeTopic, msg); forwarded {
+			return fwdErr
+		}
+		return err
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return direct.PublishTo(id, data)
+}
+
+// receiveBridged delivers a message published by another node to the local
+// clients only, so it is never forwarded again.
+func (h *Hub) receiveBridged(data []byte) {
+	var msg bridgeMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+
+	switch msg.Kind {
+	case bridgeBroadcast:
+		h.broadcastLocal(msg.Data)
+	case bridgeRoom:
+		h.broadcastToRoomLocal(msg.Room, msg.Data)
+	case bridgeSend:
+		h.sendTo(msg.ClientID, func(c *Client) error {
+			return c.enqueue(outbound{data: msg.Data})
+		})
+	case bridgeEnvelope:
+		if msg.Envelope != nil {
+			envelope := *msg.Envelope
+			h.sendTo(envelope.ClientID, func(c *Client) error {
+				return c.SendEnvelope(envelope)
+			})
+		}
+	case bridgeEphemeral:
+		if msg.Envelope != nil {
+			h.broadcastEphemeralLocal(*msg.Envelope)
+		}
+	case bridgePublish:
+		if msg.Envelope != nil {
+			h.publishLocal(*msg.Envelope)
+		}
+	case bridgeRoomEnvelope:
+		if msg.Envelope != nil {
+			h.broadcastEnvelopeToRoomLocal(msg.Room, *msg.Envelope)
+		}
+	}
+}
@@ -1,23 +1,462 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	writeWait = 10 * time.Second
+
+	// closeWait bounds how long a server-initiated close waits for the peer
+	// to complete the closing handshake before the connection is dropped.
+	closeWait = time.Second
+)
+
 type Client struct {
 	ID        Identity
 	Conn      *websocket.Conn
 	Send      chan []byte
 	Connected time.Time
+
+	handler     *WebsocketHandler
+	queue       chan outbound
+	streams     chan streamRequest
+	done        chan struct{}
+	session     SessionInfo
+	remoteAddr  string
+	remoteIP    netip.Addr
+	codec       Codec
+	subprotocol string
+	compressed  bool
+	compression compressionCounters
+
+	mu       sync.RWMutex
+	metadata map[string]any
+	tags     map[string]struct{}
+	hub      *Hub
+	policy   *SlowConsumerPolicy
+	pending  map[Identity]chan Envelope
+	unacked  map[Identity]*delivery
+
+	sessionTimer Timer
+	idleTimer    Timer
+	lastActive   atomic.Int64
+	heartbeat    heartbeatState
+
+	// sequence is shared with the connection that resumes the session.
+	sequence *sequenceState
+
+	// resumeID identifies the client's session under
+	// WithSessionResumption. parked is set when the connection ends but
+	// the session may still be resumed, and the client keeps accepting
+	// frames for its queue; successor is the connection that resumed it,
+	// which its frames go to from then on.
+	resumeID  Identity
+	parked    atomic.Bool
+	successor atomic.Pointer[Client]
+
+	// queuedBytes is the size of the frames in queue.
+	queuedBytes atomic.Int64
+
+	// limiter, throttledRun and flood are only used by the read loop.
+	limiter      *tokenBucket
+	throttledRun int
+	flood        *floodMeter
+
+	// inbox queues messages for the handler outside SerialPerConnection.
+	inbox *inbox
+
+	// ctx is the connection's context and cancel ends it; see Context.
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// trace holds the context of the message being handled, when tracing.
+	trace atomic.Pointer[context.Context]
+
+	// closing is the first close the server started. writeErr is set by
+	// the write pump when a write fails, and read once it has exited.
+	// reason is set when the connection has ended, and ended closed.
+	closing  atomic.Pointer[serverClose]
+	writeErr error
+	reason   *DisconnectReason
+	ended    chan struct{}
+
+	traffic   trafficCounters
+	dropped   atomic.Uint64
+	denials   atomic.Uint64
+	throttled atomic.Uint64
+	closeOnce sync.Once
+}
+
+// outbound is a frame queued by the package itself. messageType defaults to
+// a text frame when zero. When envelope is set the write pump records it as
+// awaiting the client's ack; when prepared is set it holds data already
+// framed for writing. When closeCode is set the pump instead starts the
+// closing handshake with data as the reason, after every frame queued
+// before it, and then closes closed if it is set. broadcast marks the
+// deliveries of a broadcast, which are shed rather than queued past the
+// handler's total byte cap. control marks protocol frames, such as acks and
+// heartbeats, that are never sequenced.
+type outbound struct {
+	data        []byte
+	messageType int
+	envelope    *Envelope
+	prepared    *websocket.PreparedMessage
+	closeCode   int
+	closed      chan struct{}
+	broadcast   bool
+	control     bool
 }
 
 func NewClient(id Identity, conn *websocket.Conn) *Client {
+	return newClient(id, conn, defaultSendQueueSize)
+}
+
+func newClient(id Identity, conn *websocket.Conn, queueSize int) *Client {
+	if queueSize <= 0 {
+		queueSize = defaultSendQueueSize
+	}
 	return &Client{
 		ID:        Identity(id),
 		Conn:      conn,
-		Send:      make(chan []byte, 256),
+		Send:      make(chan []byte, queueSize),
 		Connected: time.Now(),
+		queue:     make(chan outbound, queueSize),
+		streams:   make(chan streamRequest),
+		done:      make(chan struct{}),
+		ended:     make(chan struct{}),
+		metadata:  make(map[string]any),
+		tags:      make(map[string]struct{}),
+		sequence:  &sequenceState{},
+	}
+}
+
+// RemoteAddr returns the network address of the peer as seen by the server.
+func (c *Client) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// Subprotocol returns the websocket subprotocol negotiated during the
+// upgrade, or "" when none was.
+func (c *Client) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Set stores v under key in the client's metadata. It is safe to call from
+// any goroutine for the lifetime of the connection.
+func (c *Client) Set(key string, v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metadata[key] = v
+}
+
+// Get returns the metadata value stored under key.
+func (c *Client) Get(key string) (any, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.metadata[key]
+	return v, ok
+}
+
+// AddTag labels the client with tag. Tags may overlap freely and are indexed
+// by the hub the client is registered with.
+func (c *Client) AddTag(tag string) {
+	hub := c.registeredHub()
+	if hub != nil {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tags[tag] = struct{}{}
+	if hub != nil && c.hub == hub {
+		hub.indexTagLocked(tag, c)
+	}
+}
+
+// RemoveTag removes tag from the client.
+func (c *Client) RemoveTag(tag string) {
+	hub := c.registeredHub()
+	if hub != nil {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.tags, tag)
+	if hub != nil && c.hub == hub {
+		hub.unindexTagLocked(tag, c)
+	}
+}
+
+// HasTag reports whether the client is labelled with tag.
+func (c *Client) HasTag(tag string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.tags[tag]
+	return ok
+}
+
+func (c *Client) registeredHub() *Hub {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hub
+}
+
+// MetadataSnapshot returns a copy of the client's metadata. On connect it
+// holds the metadata returned by the SessionValidator.
+func (c *Client) MetadataSnapshot() map[string]any {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := make(map[string]any, len(c.metadata))
+	for k, v := range c.metadata {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// enqueue queues msg for the write pump without blocking. When the queue is
+// full, or the client's queued bytes are at their cap, the client's
+// slow-consumer policy decides the outcome. Under WithSequencing the frame
+// is numbered first, so a frame the policy drops leaves a gap the client
+// can see. A parked client keeps queueing frames for the connection that
+// resumes its session.
+func (c *Client) enqueue(msg outbound) error {
+	if next := c.successor.Load(); next != nil {
+		return next.enqueue(msg)
+	}
+	select {
+	case <-c.done:
+		if !c.parked.Load() {
+			return ErrClientNotConnected
+		}
+	default:
+	}
+
+	if c.sequenced(msg) {
+		c.sequence.mu.Lock()
+		defer c.sequence.mu.Unlock()
+		msg = c.sequence.next(msg, c.handler.config.replayBuffer)
+	}
+	if err := c.push(msg); err != ErrSendQueueFull {
+		return err
+	}
+	return c.overflow(msg)
+}
+
+// push queues msg if it fits both the queue and the byte caps, accounting
+// for its bytes.
+func (c *Client) push(msg outbound) error {
+	size := msg.size()
+	if err := c.reserve(size, msg.broadcast); err != nil {
+		return err
+	}
+	select {
+	case c.queue <- msg:
+	default:
+		c.release(size)
+		return ErrSendQueueFull
+	}
+
+	select {
+	case <-c.done:
+		// The connection closed while msg was being queued, so the
+		// write pump may never take it, unless the session is parked.
+		if !c.parked.Load() {
+			c.drainQueue()
+		}
+	default:
+	}
+	return nil
+}
+
+// writePump is the only goroutine allowed to write data frames to the
+// connection. It exits and closes the connection when a write fails or the
+// read loop has finished.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.handler.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.queue:
+			c.release(msg.size())
+			if msg.closeCode != 0 {
+				c.closeWith(msg.closeCode, string(msg.data))
+				if msg.closed != nil {
+					close(msg.closed)
+				}
+				continue
+			}
+
+			data, ok := c.intercept(msg.data)
+			if !ok {
+				continue
+			}
+			if msg.envelope != nil {
+				c.awaitAck(*msg.envelope)
+			}
+
+			if err := c.writeOutbound(msg, data); err != nil {
+				c.writeErr = err
+				return
+			}
+		case req := <-c.streams:
+			if !c.stream(req) {
+				return
+			}
+		case <-ticker.C:
+			if err := c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.writeErr = err
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// forwardSend moves the frames written to Send into the send queue, where
+// their bytes are counted with the rest. While the queue is full it waits,
+// so writers to Send wait for room as before instead of meeting the
+// slow-consumer policy. Closing Send queues a normal closure after them. It
+// exits once the read loop has finished, leaving a frame it was holding in
+// the queue only if the session is parked.
+func (c *Client) forwardSend() {
+	for {
+		var msg outbound
+		select {
+		case data, ok := <-c.Send:
+			if !ok {
+				msg = outbound{closeCode: websocket.CloseNormalClosure}
+			} else {
+				msg = outbound{data: data}
+			}
+		case <-c.done:
+			return
+		}
+
+		size := msg.size()
+		c.hold(size)
+		select {
+		case c.queue <- msg:
+		case <-c.done:
+			if c.parked.Load() {
+				select {
+				case c.queue <- msg:
+					return
+				default:
+				}
+			}
+			c.release(size)
+			return
+		}
+		if msg.closeCode != 0 {
+			return
+		}
+	}
+}
+
+func (c *Client) write(messageType int, data []byte) error {
+	c.compress(len(data))
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.Conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	c.observeSend(len(data))
+	return nil
+}
+
+// SendBinary queues data to be written to the client as a binary frame. Like
+// other package sends it never blocks: a full queue is resolved by the
+// client's slow-consumer policy, and ErrClientNotConnected is returned once
+// the connection has closed.
+func (c *Client) SendBinary(data []byte) error {
+	return c.enqueue(outbound{data: data, messageType: websocket.BinaryMessage})
+}
+
+// SendJSON marshals v and queues it as a text frame. Marshalling errors are
+// returned to the caller; otherwise it fails like SendBinary, with
+// ErrSendQueueFull or ErrClientNotConnected, instead of blocking.
+func (c *Client) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(outbound{data: data})
+}
+
+// SendEnvelope queues envelope for this connection only. The envelope stays
+// pending until the client acks it, as with Hub.SendEnvelope, but it is not
+// persisted; use WebsocketHandler.SendEnvelope for that. Envelopes sent while
+// one of the client's messages is being traced carry that trace.
+func (c *Client) SendEnvelope(envelope Envelope) error {
+	injectTrace(c.TraceContext(), &envelope)
+	msg, err := c.envelopeOutbound(envelope)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(msg)
+}
+
+// closeWith starts the closing handshake by sending a close frame with code
+// and reason. The read loop exits once the peer answers or the connection is
+// torn down. Unless another close was recorded first, the connection ends
+// with DisconnectServerClose.
+func (c *Client) closeWith(code int, reason string) error {
+	c.recordClose(DisconnectServerClose, code, reason)
+	return c.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait))
+}
+
+// closeAfterQueued sends a close frame once every frame already queued for
+// the client has been written, waiting up to writeWait for the write pump to
+// get to it. The read loop uses it before returning, so error frames
+// explaining the close reach the client first.
+func (c *Client) closeAfterQueued(code int, reason string) {
+	closed := make(chan struct{})
+	if c.enqueue(outbound{data: []byte(reason), closeCode: code, closed: closed}) != nil {
+		c.closeWith(code, reason)
+		return
+	}
+	select {
+	case <-closed:
+	case <-time.After(writeWait):
+	}
+}
+
+// disconnect sends a close frame and waits up to closeWait for the read loop
+// to observe the peer's reply before closing the connection outright. The
+// connection ends with kind unless another close was recorded first. A
+// parked session ends at once.
+func (c *Client) disconnect(kind DisconnectKind, code int, reason string) {
+	c.recordClose(kind, code, reason)
+	if c.Suspended() {
+		c.handler.expire(c)
+		return
+	}
+	c.closeWith(code, reason)
+
+	select {
+	case <-c.done:
+	case <-time.After(closeWait):
+		c.Conn.Close()
+		<-c.done
 	}
 }
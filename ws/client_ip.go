@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIPResolver determines the address of the client behind an upgrade
+// request. The result is used for per-IP connection limits and is reported
+// by Client.RemoteIP and in PresenceInfo.
+type ClientIPResolver interface {
+	ClientIP(r *http.Request) netip.Addr
+}
+
+// ClientIPResolverFunc adapts a function to the ClientIPResolver interface.
+type ClientIPResolverFunc func(r *http.Request) netip.Addr
+
+func (f ClientIPResolverFunc) ClientIP(r *http.Request) netip.Addr {
+	return f(r)
+}
+
+// TrustedProxyResolver resolves the client IP from forwarding headers, but
+// only when the direct peer is a trusted proxy; headers sent by any other
+// peer are ignored, so clients cannot spoof their address.
+//
+// Headers are consulted in order, X-Forwarded-For and X-Real-IP by default.
+// A list such as X-Forwarded-For is read right to left, skipping trusted
+// proxies, and the first untrusted address is the client. When every entry
+// is trusted, the leftmost one is used.
+type TrustedProxyResolver struct {
+	Trusted []netip.Prefix
+	Headers []string
+}
+
+var defaultForwardingHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+func (p TrustedProxyResolver) ClientIP(r *http.Request) netip.Addr {
+	peer := remoteIP(r.RemoteAddr)
+	if !p.trusted(peer) {
+		return peer
+	}
+
+	headers := p.Headers
+	if headers == nil {
+		headers = defaultForwardingHeaders
+	}
+	for _, name := range headers {
+		if ip, ok := p.fromHeader(r.Header.Values(name)); ok {
+			return ip
+		}
+	}
+	return peer
+}
+
+func (p TrustedProxyResolver) fromHeader(values []string) (netip.Addr, bool) {
+	var hops []netip.Addr
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			ip, err := netip.ParseAddr(strings.TrimSpace(entry))
+			if err != nil {
+				// A malformed hop could have been written by anyone, so
+				// nothing to its left can be trusted.
+				hops = hops[:0]
+				continue
+			}
+			hops = append(hops, ip.Unmap())
+		}
+	}
+	if len(hops) == 0 {
+		return netip.Addr{}, false
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !p.trusted(hops[i]) {
+			return hops[i], true
+		}
+	}
+	return hops[0], true
+}
+
+func (p TrustedProxyResolver) trusted(ip netip.Addr) bool {
+	for _, prefix := range p.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP parses the host part of an http.Request's RemoteAddr, returning
+// the zero Addr when it is not an IP address.
+func remoteIP(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// RemoteIP returns the client's IP address as determined by the handler's
+// ClientIPResolver, which may differ from RemoteAddr behind a proxy.
+func (c *Client) RemoteIP() netip.Addr {
+	return c.remoteIP
+}
+
+// clientIP resolves the address used for per-IP accounting.
+func (h *WebsocketHandler) clientIP(r *http.Request) netip.Addr {
+	if h.config.ipResolver != nil {
+		return h.config.ipResolver.ClientIP(r)
+	}
+	return remoteIP(r.RemoteAddr)
+}
@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec encodes envelopes into websocket frames and decodes inbound frames
+// back into envelopes. The int is the websocket frame type, such as
+// websocket.TextMessage or websocket.BinaryMessage.
+//
+// Unmarshal returns an error for frames that are not envelopes in the
+// codec's encoding; such frames reach the MessageHandler as raw bytes. The
+// decoded envelope's ID is left zero when the frame carries none. The frame
+// is read into a pooled buffer, so the envelope must not alias data.
+type Codec interface {
+	Marshal(e Envelope) ([]byte, int, error)
+	Unmarshal(data []byte, messageType int) (Envelope, error)
+}
+
+// JSONCodec encodes envelopes as JSON text frames. It is the default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(e Envelope) ([]byte, int, error) {
+	data, err := marshalEnvelope(e)
+	return data, websocket.TextMessage, err
+}
+
+func (JSONCodec) Unmarshal(data []byte, messageType int) (Envelope, error) {
+	if messageType != websocket.TextMessage {
+		return Envelope{}, ErrMalformedMessage
+	}
+	frame, ok := parseInboundFrame(data)
+	if !ok {
+		return Envelope{}, ErrMalformedMessage
+	}
+	return frame.decode(), nil
+}
+
+// envelopeFrame is the JSON wire shape of envelopes sent to clients.
+// Identities are encoded as canonical UUID strings so clients can echo the ID
+// back in an ack frame.
+type envelopeFrame struct {
+	ID        string          `json:"id"`
+	ClientID  string          `json:"client_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Topic     string          `json:"topic,omitempty"`
+	Room      string          `json:"room,omitempty"`
+	History   bool            `json:"history,omitempty"`
+	Ephemeral bool            `json:"ephemeral,omitempty"`
+
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+
+	Signature string `json:"signature,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+func marshalEnvelope(e Envelope) ([]byte, error) {
+	frame := envelopeFrame{
+		ID:        e.ID.String(),
+		ClientID:  e.ClientID.String(),
+		Type:      e.Type,
+		Payload:   e.Payload,
+		Timestamp: e.Timestamp,
+		ExpiresAt: e.ExpiresAt,
+		Topic:     e.Topic,
+		Room:      e.Room,
+		History:   e.History,
+		Ephemeral: e.Ephemeral,
+
+		TraceParent: e.TraceParent,
+		TraceState:  e.TraceState,
+
+		Signature: e.Signature,
+		KeyID:     e.KeyID,
+	}
+	if e.ReplyTo != nil {
+		frame.ReplyTo = e.ReplyTo.String()
+	}
+	return json.Marshal(frame)
+}
+
+// inboundFrame is the JSON wire shape clients use for structured messages.
+type inboundFrame struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	ReplyTo   string          `json:"reply_to"`
+	Topic     string          `json:"topic"`
+	Ephemeral bool            `json:"ephemeral"`
+
+	TraceParent string `json:"traceparent"`
+	TraceState  string `json:"tracestate"`
+
+	// Timestamp is only used to verify signatures; it is kept raw so
+	// frames with timestamps in other shapes still parse.
+	Timestamp json.RawMessage `json:"timestamp"`
+	Signature string          `json:"signature"`
+	KeyID     string          `json:"kid"`
+}
+
+func parseInboundFrame(data []byte) (inboundFrame, bool) {
+	var frame inboundFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return inboundFrame{}, false
+	}
+	return frame, true
+}
+
+// decode converts the frame into an envelope, ignoring IDs that are not
+// valid UUIDs.
+func (frame inboundFrame) decode() Envelope {
+	envelope := Envelope{
+		Type:        frame.Type,
+		Payload:     frame.Payload,
+		Topic:       frame.Topic,
+		Ephemeral:   frame.Ephemeral,
+		TraceParent: frame.TraceParent,
+		TraceState:  frame.TraceState,
+		Signature:   frame.Signature,
+		KeyID:       frame.KeyID,
+	}
+	json.Unmarshal(frame.Timestamp, &envelope.Timestamp)
+	if id, err := ParseIdentity(frame.ID); err == nil {
+		envelope.ID = id
+	}
+	if replyTo, err := ParseIdentity(frame.ReplyTo); err == nil {
+		envelope.ReplyTo = &replyTo
+	}
+	return envelope
+}
+
+// envelopeCodec returns the codec negotiated for the connection, falling back
+// to the handler's codec and then to JSON.
+func (c *Client) envelopeCodec() Codec {
+	if c.codec != nil {
+		return c.codec
+	}
+	if c.handler != nil && c.handler.config.codec != nil {
+		return c.handler.config.codec
+	}
+	return JSONCodec{}
+}
+
+// envelopeOutbound encodes envelope with the connection's codec, signing it
+// first under WithEnvelopeSigning. Ephemeral and room envelopes are not
+// awaited for an ack.
+func (c *Client) envelopeOutbound(envelope Envelope) (outbound, error) {
+	if c.handler != nil && c.handler.config.signingKeys != nil {
+		c.handler.config.signingKeys.Sign(&envelope)
+	}
+	data, messageType, err := c.envelopeCodec().Marshal(envelope)
+	if err != nil {
+		return outbound{}, err
+	}
+	msg := outbound{data: data, messageType: messageType}
+	if !envelope.Ephemeral && envelope.Room == "" {
+		msg.envelope = &envelope
+	}
+	return msg, nil
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CompressionStats counts the payload bytes written to a connection, split
+// by whether the frame was compressed. Bytes are counted before
+// compression, so the ratio shows how much traffic the threshold lets
+// through to the compressor rather than the bandwidth saved.
+type CompressionStats struct {
+	CompressedBytes   uint64 `json:"compressed_bytes"`
+	UncompressedBytes uint64 `json:"uncompressed_bytes"`
+}
+
+// compressionCounters are updated by the write pump and read from anywhere.
+type compressionCounters struct {
+	compressed   atomic.Uint64
+	uncompressed atomic.Uint64
+}
+
+// Compressed reports whether the connection negotiated permessage-deflate.
+func (c *Client) Compressed() bool {
+	return c.compressed
+}
+
+// CompressionStats returns the connection's compressed and uncompressed
+// byte counts. Without permessage-deflate every byte is uncompressed.
+func (c *Client) CompressionStats() CompressionStats {
+	return CompressionStats{
+		CompressedBytes:   c.compression.compressed.Load(),
+		UncompressedBytes: c.compression.uncompressed.Load(),
+	}
+}
+
+// compress decides whether the next frame, of n payload bytes, is
+// compressed, and counts it. Frames below the handler's threshold are sent
+// uncompressed since deflating them costs more CPU than it saves bandwidth.
+func (c *Client) compress(n int) {
+	if !c.compressed {
+		c.compression.uncompressed.Add(uint64(n))
+		return
+	}
+	ok := n >= c.handler.config.compressionThreshold
+	c.Conn.EnableWriteCompression(ok)
+	if ok {
+		c.compression.compressed.Add(uint64(n))
+	} else {
+		c.compression.uncompressed.Add(uint64(n))
+	}
+}
+
+// compressStream compresses a streamed message, whose size is unknown up
+// front, when compression was negotiated. It must be called before the
+// message's writer is created, and returns the counter for its bytes.
+func (c *Client) compressStream() *atomic.Uint64 {
+	if !c.compressed {
+		return &c.compression.uncompressed
+	}
+	c.Conn.EnableWriteCompression(true)
+	return &c.compression.compressed
+}
+
+// countingWriter adds the bytes written through it to n, and keeps the
+// message's own total in written.
+type countingWriter struct {
+	io.WriteCloser
+	n       *atomic.Uint64
+	written int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n.Add(uint64(n))
+	w.written += n
+	return n, err
+}
+
+// offersCompression reports whether the upgrade request offers
+// permessage-deflate, which gorilla accepts whenever compression is enabled.
+func offersCompression(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
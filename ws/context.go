@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+)
+
+// ContextExtractor copies what a connection needs from its upgrade request
+// into ctx, the connection's context in the making, and returns the result.
+// The request's own context ends with the upgrade, so only values survive,
+// never its deadline or cancellation.
+type ContextExtractor func(ctx context.Context, r *http.Request) context.Context
+
+// CopyRequestValues returns an extractor that copies the values stored
+// under keys in the request's context, such as a tenant or request ID set
+// by HTTP middleware in front of the handler. Keys the request lacks are
+// skipped.
+func CopyRequestValues(keys ...any) ContextExtractor {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		for _, key := range keys {
+			if value := r.Context().Value(key); value != nil {
+				ctx = context.WithValue(ctx, key, value)
+			}
+		}
+		return ctx
+	}
+}
+
+// WithBaseContext sets the context every connection's context derives
+// from, so its values reach every connection and cancelling it cancels
+// them all. The default is context.Background(). Shutdown cancels the
+// connections' contexts whatever the base.
+func WithBaseContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.baseContext = ctx
+	}
+}
+
+// WithContextExtractor adds fn to the extractors building each connection's
+// context from its upgrade request. It may be given several times; the
+// extractors run in registration order over the base context.
+func WithContextExtractor(fn ContextExtractor) Option {
+	return func(c *config) {
+		c.extractors = append(c.extractors, fn)
+	}
+}
+
+// Context returns the connection's context. It derives from the handler's
+// base context, carries the values the context extractors took from the
+// upgrade request, and is cancelled when the connection ends, with the
+// error that ended it as its cause, or when the handler shuts down, with
+// ErrShuttingDown. It is set once the upgrade completes, in time for the
+// connect hook; before that it is context.Background().
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// connectionContext builds the context of a connection upgraded from r.
+func (h *WebsocketHandler) connectionContext(r *http.Request) context.Context {
+	ctx := h.base
+	for _, extract := range h.config.extractors {
+		ctx = extract(ctx, r)
+	}
+	return ctx
+}
+
+// bindContext gives client a context derived from parent, cancelled by
+// serveClient once the connection ends.
+func (c *Client) bindContext(parent context.Context) {
+	c.ctx, c.cancel = context.WithCancelCause(parent)
+}
@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const defaultDedupeCacheSize = 1024
+
+// EnvelopeChecker is implemented by persisters that can report whether an
+// envelope has already been saved. When the handler's persister implements
+// it, inbound envelopes whose ID it already holds are treated as duplicates.
+type EnvelopeChecker interface {
+	Exists(id Identity) (bool, error)
+}
+
+type dedupeKey struct {
+	clientID Identity
+	id       Identity
+}
+
+// dedupeCache remembers the most recently accepted inbound envelope IDs,
+// evicting the least recently seen once it holds size entries.
+type dedupeCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[dedupeKey]*list.Element
+}
+
+func newDedupeCache(size int) *dedupeCache {
+	return &dedupeCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[dedupeKey]*list.Element),
+	}
+}
+
+func (c *dedupeCache) contains(key dedupeKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+	return ok
+}
+
+func (c *dedupeCache) add(key dedupeKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(key)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(dedupeKey))
+	}
+}
+
+// isDuplicate reports whether an inbound envelope from client has already
+// been accepted, consulting the recent-ID cache and then the persister.
+func (h *WebsocketHandler) isDuplicate(client *Client, id Identity) bool {
+	if h.dedupe != nil && h.dedupe.contains(dedupeKey{client.ID, id}) {
+		return true
+	}
+	if checker, ok := h.EnvelopePersister.(EnvelopeChecker); ok {
+		exists, err := checker.Exists(id)
+		return err == nil && exists
+	}
+	return false
+}
+
+// accepted records an inbound envelope ID so later copies are recognised.
+func (h *WebsocketHandler) accepted(client *Client, id Identity) {
+	if h.dedupe != nil {
+		h.dedupe.add(dedupeKey{client.ID, id})
+	}
+}
+
+// sendAck confirms an inbound envelope the client identified, using the
+// same ack shape clients use, encoded with the connection's codec.
+func (c *Client) sendAck(id Identity) {
+	c.sendAckPayload(id, nil)
+}
+
+// sendAckPayload is sendAck for acks that carry a payload.
+func (c *Client) sendAckPayload(id Identity, payload json.RawMessage) {
+	if msg, err := c.envelopeOutbound(Envelope{
+		ID:        id,
+		ClientID:  c.ID,
+		Type:      AckMessageType,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}); err == nil {
+		msg.envelope = nil
+		msg.control = true
+		c.enqueue(msg)
+	}
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DenyList bans identities and IP ranges from connecting. Every
+// WebsocketHandler has one: banned parties are refused with 403 Forbidden at
+// upgrade, and their existing connections are closed with code 1008 (Policy
+// Violation) as soon as the ban is made. Bans lapse on their own at the
+// given time; a zero time bans until Unban is called. It is safe for
+// concurrent use.
+type DenyList struct {
+	clock Clock
+	hub   *Hub
+
+	mu  sync.Mutex
+	ids map[Identity]time.Time
+	ips map[netip.Prefix]time.Time
+}
+
+func newDenyList(clock Clock, hub *Hub) *DenyList {
+	return &DenyList{
+		clock: clock,
+		hub:   hub,
+		ids:   make(map[Identity]time.Time),
+		ips:   make(map[netip.Prefix]time.Time),
+	}
+}
+
+// Ban refuses connections for id until the given time and disconnects its
+// live connections.
+func (d *DenyList) Ban(id Identity, until time.Time) {
+	d.mu.Lock()
+	d.ids[id] = until
+	d.pruneLocked(d.clock.Now())
+	d.mu.Unlock()
+
+	for _, client := range d.hub.Connections(id) {
+		go client.disconnect(DisconnectKicked, websocket.ClosePolicyViolation, "banned")
+	}
+}
+
+// BanIP refuses connections from addresses within prefix until the given
+// time and disconnects live connections from it.
+func (d *DenyList) BanIP(prefix netip.Prefix, until time.Time) {
+	prefix = prefix.Masked()
+	d.mu.Lock()
+	d.ips[prefix] = until
+	d.pruneLocked(d.clock.Now())
+	d.mu.Unlock()
+
+	d.hub.Range(func(client *Client) bool {
+		if prefix.Contains(client.RemoteIP()) {
+			go client.disconnect(DisconnectKicked, websocket.ClosePolicyViolation, "banned")
+		}
+		return true
+	})
+}
+
+// Unban lifts any ban on id.
+func (d *DenyList) Unban(id Identity) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.ids, id)
+}
+
+// UnbanIP lifts a ban made with BanIP for exactly prefix.
+func (d *DenyList) UnbanIP(prefix netip.Prefix) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.ips, prefix.Masked())
+}
+
+// Banned reports whether id is currently banned.
+func (d *DenyList) Banned(id Identity) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.ids[id]
+	if !ok {
+		return false
+	}
+	if expired(until, d.clock.Now()) {
+		delete(d.ids, id)
+		return false
+	}
+	return true
+}
+
+// BannedIP reports whether ip falls within a currently banned range.
+func (d *DenyList) BannedIP(ip netip.Addr) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	for prefix, until := range d.ips {
+		if expired(until, now) {
+			delete(d.ips, prefix)
+			continue
+		}
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneLocked drops lapsed bans so the list does not grow without bound.
+func (d *DenyList) pruneLocked(now time.Time) {
+	for id, until := range d.ids {
+		if expired(until, now) {
+			delete(d.ids, id)
+		}
+	}
+	for prefix, until := range d.ips {
+		if expired(until, now) {
+			delete(d.ips, prefix)
+		}
+	}
+}
+
+func expired(until, now time.Time) bool {
+	return !until.IsZero() && !now.Before(until)
+}
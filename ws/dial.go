@@ -0,0 +1,355 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeHandshakeTimeout bounds how long ClientConn.Close waits for the
+// server to answer the close frame before dropping the connection.
+const closeHandshakeTimeout = 5 * time.Second
+
+// DialOption configures Dial.
+type DialOption func(*dialConfig)
+
+type dialConfig struct {
+	header        http.Header
+	subprotocols  []string
+	tls           *tls.Config
+	autoAck       bool
+	receiveBuffer int
+}
+
+// WithDialHeader adds a header to the upgrade request, such as a cookie or
+// an API key.
+func WithDialHeader(key, value string) DialOption {
+	return func(c *dialConfig) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithBearerToken sends token in the upgrade request's Authorization
+// header.
+func WithBearerToken(token string) DialOption {
+	return func(c *dialConfig) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithResumeToken presents a token from ClientConn.ResumeToken to resume a
+// session on a server using WithSessionResumption. An empty token is
+// ignored.
+func WithResumeToken(token string) DialOption {
+	return func(c *dialConfig) {
+		if token != "" {
+			c.header.Set(ResumeTokenHeader, token)
+		}
+	}
+}
+
+// WithDialSubprotocols offers protocols to the server, in order of
+// preference. ClientConn.Subprotocol reports the one it chose.
+func WithDialSubprotocols(protocols ...string) DialOption {
+	return func(c *dialConfig) {
+		c.subprotocols = append(c.subprotocols, protocols...)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for wss:// URLs.
+func WithTLSConfig(cfg *tls.Config) DialOption {
+	return func(c *dialConfig) {
+		c.tls = cfg
+	}
+}
+
+// WithAutoAck acknowledges every envelope as soon as it is received, before
+// it is delivered on Receive. Without it, call ClientConn.Ack once an
+// envelope has been processed, or the server redelivers it. Ephemeral and
+// room envelopes are never acked.
+func WithAutoAck() DialOption {
+	return func(c *dialConfig) {
+		c.autoAck = true
+	}
+}
+
+// WithReceiveBuffer sets how many received envelopes are buffered for
+// Receive before reading from the server pauses. The default is 64.
+func WithReceiveBuffer(n int) DialOption {
+	return func(c *dialConfig) {
+		c.receiveBuffer = n
+	}
+}
+
+// DialError is returned by Dial when the server refuses the upgrade.
+type DialError struct {
+	// StatusCode is the HTTP status of the refusal, or zero when no
+	// response was received.
+	StatusCode int
+	Err        error
+}
+
+func (e *DialError) Error() string {
+	msg := "ws: dial failed"
+	if e.StatusCode != 0 {
+		msg += " with status " + strconv.Itoa(e.StatusCode)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// ClientConn is the client side of a connection to a WebsocketHandler,
+// exchanging the same JSON envelopes. Server pings and heartbeats are
+// answered automatically while the connection is open. It is safe for
+// concurrent use.
+type ClientConn struct {
+	// ID is the identity the server assigned to the connection, or zero
+	// when the server did not report one.
+	ID Identity
+
+	// ResumeToken is the token that resumes the connection's session on a
+	// server using WithSessionResumption, and Resumed reports whether the
+	// connection resumed the session of the token it was dialed with.
+	ResumeToken string
+	Resumed     bool
+
+	conn     *websocket.Conn
+	autoAck  bool
+	writeMu  sync.Mutex
+	receive  chan Envelope
+	closing  chan struct{}
+	done     chan struct{}
+	err      error
+	stopOnce sync.Once
+}
+
+// Dial connects to the handler at url, a ws:// or wss:// URL. ctx bounds
+// the opening handshake only.
+func Dial(ctx context.Context, url string, opts ...DialOption) (*ClientConn, error) {
+	config := dialConfig{header: make(http.Header), receiveBuffer: 64}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		Subprotocols:     config.subprotocols,
+		TLSClientConfig:  config.tls,
+	}
+	conn, resp, err := dialer.DialContext(ctx, url, config.header)
+	if err != nil {
+		dialErr := &DialError{Err: err}
+		if resp != nil {
+			dialErr.StatusCode = resp.StatusCode
+		}
+		return nil, dialErr
+	}
+
+	c := &ClientConn{
+		conn:    conn,
+		autoAck: config.autoAck,
+		receive: make(chan Envelope, config.receiveBuffer),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if id, err := ParseIdentity(resp.Header.Get(ClientIDHeader)); err == nil {
+		c.ID = id
+	}
+	c.ResumeToken = resp.Header.Get(ResumeTokenHeader)
+	c.Resumed = c.ResumeToken != "" && c.ResumeToken == config.header.Get(ResumeTokenHeader)
+	go c.readLoop()
+	return c, nil
+}
+
+// Subprotocol returns the subprotocol the server selected, if any.
+func (c *ClientConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// Send sends a new envelope of type msgType with payload encoded as JSON,
+// returning its ID. The server acks envelopes it accepts with an envelope
+// of type AckMessageType carrying the same ID.
+func (c *ClientConn) Send(msgType string, payload any) (Identity, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Identity{}, err
+	}
+	envelope := Envelope{ID: NewIdentity(), Type: msgType, Payload: data}
+	return envelope.ID, c.SendEnvelope(envelope)
+}
+
+// SendEnvelope sends envelope's ID, type, payload and reply-to. Envelopes
+// without an ID are assigned one by the server and are not acked.
+func (c *ClientConn) SendEnvelope(envelope Envelope) error {
+	frame := inboundFrame{Type: envelope.Type, Payload: envelope.Payload}
+	if !envelope.ID.IsZero() {
+		frame.ID = envelope.ID.String()
+	}
+	if envelope.ReplyTo != nil {
+		frame.ReplyTo = envelope.ReplyTo.String()
+	}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// Ack confirms delivery of an envelope received from the server.
+func (c *ClientConn) Ack(id Identity) error {
+	data, _ := json.Marshal(inboundFrame{Type: AckMessageType, ID: id.String()})
+	return c.write(data)
+}
+
+// MarkRead tells the server the user has read the envelopes with ids, in
+// one ReadMessageType frame.
+func (c *ClientConn) MarkRead(ids ...Identity) error {
+	payload, err := json.Marshal(readRequest{IDs: ids})
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(inboundFrame{Type: ReadMessageType, Payload: payload})
+	return c.write(data)
+}
+
+func (c *ClientConn) write(data []byte) error {
+	select {
+	case <-c.closing:
+		return ErrClientNotConnected
+	default:
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Receive returns the channel envelopes from the server are delivered on,
+// including acks and error frames. An error frame arrives as an envelope of
+// type ErrorMessageType whose Payload is the whole frame, so DecodePayload
+// into an ErrorFrame reads it; frames that are not JSON objects arrive with
+// an empty Type and the raw frame as Payload. The channel is closed when
+// the connection ends, after which Err reports why.
+func (c *ClientConn) Receive() <-chan Envelope {
+	return c.receive
+}
+
+// Done is closed once the connection has ended.
+func (c *ClientConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended: a *websocket.CloseError for a close
+// handshake, or the read error. It returns nil while the connection is
+// open.
+func (c *ClientConn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close sends a close frame with code and reason and waits, for a few
+// seconds at most, for the server to answer before closing the connection.
+func (c *ClientConn) Close(code int, reason string) error {
+	c.writeMu.Lock()
+	err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.writeMu.Unlock()
+	c.stop()
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		c.conn.Close()
+		<-c.done
+		return err
+	}
+
+	select {
+	case <-c.done:
+	case <-time.After(closeHandshakeTimeout):
+		c.conn.Close()
+		<-c.done
+	}
+	return nil
+}
+
+// stop marks the connection as closing, so sends fail and a reader blocked
+// delivering to Receive gives up.
+func (c *ClientConn) stop() {
+	c.stopOnce.Do(func() { close(c.closing) })
+}
+
+func (c *ClientConn) readLoop() {
+	defer func() {
+		c.stop()
+		c.conn.Close()
+		close(c.receive)
+		close(c.done)
+	}()
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		envelope := decodeClientFrame(messageType, data)
+		if envelope.Type == HeartbeatMessageType {
+			c.write(data)
+			continue
+		}
+		if c.autoAck && !envelope.ID.IsZero() && !envelope.Ephemeral && envelope.Room == "" && envelope.Type != AckMessageType && envelope.Type != ErrorMessageType {
+			c.Ack(envelope.ID)
+		}
+		select {
+		case c.receive <- envelope:
+		case <-c.closing:
+			// Keep reading so the close handshake completes.
+		}
+	}
+}
+
+// decodeClientFrame decodes a frame written by the server's JSON codec.
+func decodeClientFrame(messageType int, data []byte) Envelope {
+	var frame envelopeFrame
+	if messageType != websocket.TextMessage || json.Unmarshal(data, &frame) != nil {
+		return Envelope{Payload: data}
+	}
+
+	envelope := Envelope{
+		Type:      frame.Type,
+		Payload:   frame.Payload,
+		Timestamp: frame.Timestamp,
+		ExpiresAt: frame.ExpiresAt,
+		Topic:     frame.Topic,
+		Room:      frame.Room,
+		History:   frame.History,
+		Ephemeral: frame.Ephemeral,
+		Signature: frame.Signature,
+		KeyID:     frame.KeyID,
+	}
+	if frame.Type == ErrorMessageType {
+		envelope.Payload = data
+	}
+	if id, err := ParseIdentity(frame.ID); err == nil {
+		envelope.ID = id
+	}
+	if id, err := ParseIdentity(frame.ClientID); err == nil {
+		envelope.ClientID = id
+	}
+	if replyTo, err := ParseIdentity(frame.ReplyTo); err == nil {
+		envelope.ReplyTo = &replyTo
+	}
+	return envelope
+}
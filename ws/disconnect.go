@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// DisconnectKind classifies how a connection ended.
+type DisconnectKind int
+
+const (
+	// DisconnectClientClose is a closing handshake started by the client.
+	DisconnectClientClose DisconnectKind = iota + 1
+
+	// DisconnectServerClose is a close started by the handler itself,
+	// such as for a policy violation, an oversized message or an
+	// ErrorClose action.
+	DisconnectServerClose
+
+	// DisconnectReadError is a connection that failed or was dropped
+	// without a closing handshake, such as io.EOF or a reset.
+	DisconnectReadError
+
+	// DisconnectWriteError is a connection whose write pump failed.
+	DisconnectWriteError
+
+	// DisconnectTimeout is a connection whose pong did not arrive within
+	// the pong wait, that was closed by the idle timeout, or that left
+	// too many heartbeats unechoed.
+	DisconnectTimeout
+
+	// DisconnectKicked is a connection closed through Hub.Disconnect,
+	// Hub.DisconnectClient or a DenyList ban.
+	DisconnectKicked
+
+	// DisconnectShutdown is a connection closed by the handler's
+	// Shutdown.
+	DisconnectShutdown
+)
+
+func (k DisconnectKind) String() string {
+	switch k {
+	case DisconnectClientClose:
+		return "client-close"
+	case DisconnectServerClose:
+		return "server-close"
+	case DisconnectReadError:
+		return "read-error"
+	case DisconnectWriteError:
+		return "write-error"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
+// DisconnectReason is why a connection ended. It is the error passed to
+// the disconnect hook and observers, the cause of the client's cancelled
+// Context, and what Client.Wait returns. It unwraps to Err, so errors.Is
+// and errors.As still find the underlying error, such as a
+// *websocket.CloseError or ErrFloodDetected.
+type DisconnectReason struct {
+	Kind DisconnectKind
+
+	// Code and Text are the close code and reason of the closing
+	// handshake, the client's for DisconnectClientClose and the server's
+	// for the server-initiated kinds. Code is zero when there was none.
+	Code int
+	Text string
+
+	// Err is the error that ended the read loop, or the write error for
+	// DisconnectWriteError.
+	Err error
+}
+
+func (r *DisconnectReason) Error() string {
+	msg := "ws: disconnected: " + r.Kind.String()
+	if r.Code != 0 {
+		msg += fmt.Sprintf(" %d", r.Code)
+		if r.Text != "" {
+			msg += fmt.Sprintf(" %q", r.Text)
+		}
+	}
+	if r.Err != nil {
+		msg += ": " + r.Err.Error()
+	}
+	return msg
+}
+
+func (r *DisconnectReason) Unwrap() error {
+	return r.Err
+}
+
+// IsNormal reports whether the connection ended in an orderly way: a
+// closing handshake from either side with code 1000 (Normal Closure), 1001
+// (Going Away) or no status, or a shutdown.
+func (r *DisconnectReason) IsNormal() bool {
+	switch r.Kind {
+	case DisconnectClientClose, DisconnectServerClose:
+		return r.Code == websocket.CloseNormalClosure || r.Code == websocket.CloseGoingAway ||
+			r.Code == websocket.CloseNoStatusReceived
+	case DisconnectShutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsServerInitiated reports whether the handler chose to end the
+// connection.
+func (r *DisconnectReason) IsServerInitiated() bool {
+	return r.Kind == DisconnectServerClose || r.Kind == DisconnectKicked || r.Kind == DisconnectShutdown
+}
+
+// Wait blocks until the connection has ended and returns why. It returns
+// once the read loop and write pump have exited, before the disconnect
+// hook runs, so the hook may call it too.
+func (c *Client) Wait() *DisconnectReason {
+	<-c.ended
+	return c.reason
+}
+
+// serverClose records the first close the server starts, so the reason
+// reports it whatever error the read loop then sees.
+type serverClose struct {
+	kind DisconnectKind
+	code int
+	text string
+}
+
+func (c *Client) recordClose(kind DisconnectKind, code int, text string) {
+	c.closing.CompareAndSwap(nil, &serverClose{kind: kind, code: code, text: text})
+}
+
+// classify turns the error that ended the read loop into a
+// DisconnectReason. It must run after the write pump has exited.
+func (c *Client) classify(err error) *DisconnectReason {
+	reason := &DisconnectReason{Err: err}
+	if closing := c.closing.Load(); closing != nil {
+		reason.Kind, reason.Code, reason.Text = closing.kind, closing.code, closing.text
+		return reason
+	}
+
+	var closeErr *websocket.CloseError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrMessageTooLarge):
+		// gorilla sent the close frame itself.
+		reason.Kind, reason.Code = DisconnectServerClose, websocket.CloseMessageTooBig
+	case errors.As(err, &closeErr):
+		reason.Kind, reason.Code, reason.Text = DisconnectClientClose, closeErr.Code, closeErr.Text
+	case c.writeErr != nil:
+		reason.Kind, reason.Err = DisconnectWriteError, c.writeErr
+	case errors.As(err, &netErr) && netErr.Timeout():
+		reason.Kind = DisconnectTimeout
+	default:
+		reason.Kind = DisconnectReadError
+	}
+	return reason
+}
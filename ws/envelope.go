@@ -1,19 +1,298 @@
 package ws
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Envelope struct {
-	ID        Identity               `json:"id"`
-	ClientID  Identity               `json:"client_id"`
-	Type      string                 `json:"type"`
-	Payload   map[string]interface{} `json:"payload"`
-	Timestamp time.Time              `json:"timestamp"`
-	Delivered *time.Time             `json:"delivered"`
+	ID        Identity        `json:"id"`
+	ClientID  Identity        `json:"client_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+	Delivered *time.Time      `json:"delivered"`
+	ReplyTo   *Identity       `json:"reply_to,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+
+	// ReadAt is when ClientID marked the envelope read with a
+	// ReadMessageType frame. It is kept apart from Delivered: an envelope
+	// is delivered once its connection acks it, and read once its user
+	// has seen it.
+	ReadAt *time.Time `json:"read_at,omitempty"`
+
+	// Topic is the topic a PublishMessageType envelope was published to,
+	// or the pattern of a subscription frame.
+	Topic string `json:"topic,omitempty"`
+
+	// Room is the room an envelope sent with BroadcastEnvelopeToRoom was
+	// broadcast to. Room envelopes are stored once for the room rather than
+	// per member, so they are never acked or replayed as undelivered.
+	Room string `json:"room,omitempty"`
+
+	// History marks room envelopes pushed to a client from the room's
+	// history when it joins, rather than broadcast live.
+	History bool `json:"history,omitempty"`
+
+	// Inbound marks envelopes received from ClientID rather than addressed
+	// to it. Inbound envelopes are never replayed.
+	Inbound bool `json:"inbound,omitempty"`
+
+	// Ephemeral marks envelopes, such as typing indicators, that are never
+	// persisted, acked or replayed. They still reach the MessageHandler and
+	// middleware, and are queued in order with every other frame.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+
+	// TraceParent and TraceState carry the W3C Trace Context of the span
+	// that produced the envelope, so traces cross the connection.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+
+	// Signature is the envelope's HMAC signature, set by Sign, and KeyID
+	// names the KeyRing key it was made with.
+	Signature string `json:"signature,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// EnvelopeOption configures an envelope built by NewEnvelope.
+type EnvelopeOption func(*envelopeConfig)
+
+type envelopeConfig struct {
+	clock Clock
+}
+
+// WithEnvelopeClock stamps the envelope's Timestamp with clock's time
+// instead of the system clock's, so tests can build envelopes
+// deterministically.
+func WithEnvelopeClock(clock Clock) EnvelopeOption {
+	return func(c *envelopeConfig) {
+		c.clock = clock
+	}
+}
+
+// NewEnvelope creates an envelope addressed to clientID with a fresh ID and
+// the current time, encoding payload as JSON.
+func NewEnvelope(clientID Identity, msgType string, payload any, opts ...EnvelopeOption) (Envelope, error) {
+	config := envelopeConfig{clock: systemClock{}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		ID:        NewIdentity(),
+		ClientID:  clientID,
+		Type:      msgType,
+		Payload:   data,
+		Timestamp: config.clock.Now(),
+	}, nil
+}
+
+// MarkDelivered records that the envelope was delivered at t.
+func (e *Envelope) MarkDelivered(t time.Time) {
+	e.Delivered = &t
+}
+
+// MarkRead records that the envelope was read at t.
+func (e *Envelope) MarkRead(t time.Time) {
+	e.ReadAt = &t
+}
+
+// DecodePayload unmarshals the envelope's JSON payload into v.
+func (e Envelope) DecodePayload(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Expired reports whether the envelope's ExpiresAt has passed at now.
+// Envelopes without an expiry never expire.
+func (e Envelope) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
 }
 
 type EnvelopePersister interface {
 	SaveEnvelope(e Envelope) error
 	ConfirmDelivery(envelopeID Identity, clientID Identity) error
 }
+
+// EnvelopePersisterFuncs adapts a pair of functions to the EnvelopePersister
+// interface. A nil function succeeds without doing anything.
+type EnvelopePersisterFuncs struct {
+	Save    func(e Envelope) error
+	Confirm func(envelopeID Identity, clientID Identity) error
+}
+
+func (p EnvelopePersisterFuncs) SaveEnvelope(e Envelope) error {
+	if p.Save == nil {
+		return nil
+	}
+	return p.Save(e)
+}
+
+func (p EnvelopePersisterFuncs) ConfirmDelivery(envelopeID Identity, clientID Identity) error {
+	if p.Confirm == nil {
+		return nil
+	}
+	return p.Confirm(envelopeID, clientID)
+}
+
+// NopPersister returns a persister that discards every envelope, for
+// prototypes and handlers that need no delivery guarantees.
+func NopPersister() EnvelopePersister {
+	return EnvelopePersisterFuncs{}
+}
+
+// DeliveryConfirmer is implemented by persisters that confirm deliveries
+// from the envelope itself. When the handler's persister implements it,
+// an acked envelope is passed to ConfirmEnvelope, with Delivered stamped by
+// the handler's clock, in place of the IDs passed to ConfirmDelivery.
+type DeliveryConfirmer interface {
+	ConfirmEnvelope(e Envelope) error
+}
+
+// UndeliveredFetcher is implemented by persisters that can return the
+// outbound envelopes still awaiting delivery to a client. When the handler's
+// persister implements it, they are replayed each time the client connects.
+type UndeliveredFetcher interface {
+	FetchUndelivered(clientID Identity) ([]Envelope, error)
+}
+
+// UndeliveredCounter is implemented by persisters that can count the
+// outbound envelopes still awaiting delivery to a client: those
+// FetchUndelivered would return, less room envelopes. WebsocketHandler.Unread
+// uses it.
+type UndeliveredCounter interface {
+	CountUndelivered(clientID Identity) (int, error)
+}
+
+// EnvelopeHistoryFetcher is implemented by persisters that can page through
+// a client's envelopes, those sent to it and those it sent, but not room
+// envelopes. Envelopes are ordered by Timestamp and then ID. FetchEnvelopes
+// returns the last limit envelopes before the envelope before, oldest
+// first, or the last limit of all when before is zero; a limit of zero
+// returns all of them. It returns ErrUnknownEnvelope when before names no
+// envelope of clientID's. When the handler's persister implements it,
+// clients can page with HistoryMessageType frames.
+type EnvelopeHistoryFetcher interface {
+	FetchEnvelopes(clientID Identity, before Identity, limit int) ([]Envelope, error)
+}
+
+// ExpiredPurger is implemented by persisters that can delete undelivered
+// envelopes whose ExpiresAt is before the given time.
+type ExpiredPurger interface {
+	PurgeExpired(before time.Time) (int, error)
+}
+
+// DeliveredPurger is implemented by persisters that can delete envelopes
+// confirmed as delivered before the given time. RetentionJanitor uses it to
+// keep delivered envelopes from accumulating.
+type DeliveredPurger interface {
+	PurgeDelivered(before time.Time) (int, error)
+}
+
+// RoomHistoryFetcher is implemented by persisters that can return the
+// envelopes broadcast to a room. FetchRoomHistory returns the most recent
+// limit envelopes whose Room is room and whose Timestamp is before before,
+// oldest first. When the handler's persister implements it, WithRoomHistory
+// pushes them to clients as they join the room.
+type RoomHistoryFetcher interface {
+	FetchRoomHistory(room string, limit int, before time.Time) ([]Envelope, error)
+}
+
+// EnvelopeScheduler is implemented by persisters that can hold envelopes
+// until they are due. SaveScheduled stores e to be sent at at, replacing any
+// scheduled envelope with the same ID, claimed or not. FetchDue claims and
+// returns the envelopes due at or before now that are not claimed, earliest
+// first. A claimed envelope stays scheduled, but FetchDue skips it until
+// lease has passed, so one whose sender stopped before sending it is handed
+// out again rather than lost. ConfirmScheduled removes a claimed envelope
+// once it has been sent. CancelScheduled removes an unclaimed one. Both
+// return ErrUnknownEnvelope when no envelope with that ID is in the state
+// they expect. When the handler's persister implements it,
+// WithScheduledDelivery enables SendAt.
+type EnvelopeScheduler interface {
+	SaveScheduled(e Envelope, at time.Time) error
+	FetchDue(now time.Time, lease time.Duration) ([]Envelope, error)
+	ConfirmScheduled(envelopeID Identity) error
+	CancelScheduled(envelopeID Identity) error
+}
+
+// ReadMarker is implemented by persisters that can record read receipts.
+// MarkRead records that clientID read the envelope at at, keeping the first
+// time when it is marked again, and returns ErrUnknownEnvelope when no
+// envelope with that ID is stored for clientID. When the handler's persister
+// implements it, ReadMessageType frames are recorded with it.
+type ReadMarker interface {
+	MarkRead(envelopeID, clientID Identity, at time.Time) error
+}
+
+// AckMessageType is the type of the frame clients send to acknowledge an
+// envelope: {"type":"ack","id":"<envelope-id>"}.
+const AckMessageType = "ack"
+
+// newInboundEnvelope wraps a JSON frame received from clientID into an
+// Envelope. It reports false when data is not a JSON object, in which case
+// the frame is not persisted.
+func newInboundEnvelope(clientID Identity, data []byte) (Envelope, bool) {
+	frame, ok := parseInboundFrame(data)
+	if !ok {
+		return Envelope{}, false
+	}
+	return frame.decode().inbound(clientID), true
+}
+
+// inbound stamps a decoded envelope as received from clientID now, assigning
+// an ID when the client did not supply one.
+func (e Envelope) inbound(clientID Identity) Envelope {
+	if e.ID.IsZero() {
+		e.ID = NewIdentity()
+	}
+	e.ClientID = clientID
+	e.Timestamp = time.Now()
+	e.Inbound = true
+	return e
+}
+
+// ErrorMessageType is the type of frames reporting a rejected or failed
+// message back to the client.
+const ErrorMessageType = "_error"
+
+// ErrorFrame is sent to a client when one of its messages is rejected or
+// fails. Code is a stable machine-readable reason, one of the ErrorCode
+// constants for frames the package sends, and Ref, when set, is the ID of
+// the envelope concerned:
+//
+//	{"type": "_error", "code": "unauthorized", "message": "message not authorized", "ref": "<envelope-id>"}
+//
+// ErrorFrame is also an error: a MessageHandler can return one to choose the
+// code and message of the reply sent under the ErrorReply action.
+type ErrorFrame struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Ref     string `json:"ref,omitempty"`
+
+	// Field, when set, is the payload field the error concerns, as a
+	// dotted path such as "items.0.qty".
+	Field string `json:"field,omitempty"`
+
+	// Errors lists every way a payload failed its schema, for
+	// validation_failed errors.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+func (f *ErrorFrame) Error() string {
+	return f.Code + ": " + f.Message
+}
+
+func newErrorFrame(code, message, ref string) []byte {
+	data, _ := json.Marshal(ErrorFrame{
+		Type:    ErrorMessageType,
+		Code:    code,
+		Message: message,
+		Ref:     ref,
+	})
+	return data
+}
@@ -0,0 +1,58 @@
+package ws
+
+// SendEphemeral queues an ephemeral envelope of type msgType, with payload
+// encoded as JSON, for this connection only. See Envelope.Ephemeral.
+func (c *Client) SendEphemeral(msgType string, payload any) error {
+	envelope, err := NewEnvelope(c.ID, msgType, payload)
+	if err != nil {
+		return err
+	}
+	envelope.Ephemeral = true
+	return c.SendEnvelope(envelope)
+}
+
+// SendEphemeral queues an ephemeral envelope of type msgType, with payload
+// encoded as JSON, for every connection of id, forwarding it over the
+// bridge as SendEnvelope does. It is never persisted, so it returns
+// ErrClientNotConnected when id is connected nowhere.
+func (h *Hub) SendEphemeral(id Identity, msgType string, payload any) error {
+	envelope, err := NewEnvelope(id, msgType, payload)
+	if err != nil {
+		return err
+	}
+	envelope.Ephemeral = true
+	return h.SendEnvelope(envelope)
+}
+
+// BroadcastEphemeral queues an ephemeral envelope of type msgType, with
+// payload encoded as JSON, for every registered client, each getting it
+// addressed to its own identity in its connection's codec. With a bridge
+// it also reaches the clients of the other nodes.
+func (h *Hub) BroadcastEphemeral(msgType string, payload any) (BroadcastResult, error) {
+	envelope, err := NewEnvelope(Identity{}, msgType, payload)
+	if err != nil {
+		return BroadcastResult{}, err
+	}
+	envelope.Ephemeral = true
+	result := h.broadcastEphemeralLocal(envelope)
+	h.forward(BridgeTopic, bridgeMessage{Kind: bridgeEphemeral, Envelope: &envelope})
+	return result, nil
+}
+
+func (h *Hub) broadcastEphemeralLocal(envelope Envelope) BroadcastResult {
+	return h.fanOut(func(client *Client) error {
+		return client.enqueueCopy(envelope)
+	})
+}
+
+// enqueueCopy queues a copy of an ephemeral or room envelope fanned out to
+// several clients, addressed to the client's own identity.
+func (c *Client) enqueueCopy(envelope Envelope) error {
+	envelope.ClientID = c.ID
+	msg, err := c.envelopeOutbound(envelope)
+	if err != nil {
+		return err
+	}
+	msg.broadcast = true
+	return c.enqueue(msg)
+}
@@ -0,0 +1,90 @@
+package ws
+
+// The codes of the ErrorFrames the package sends. They are stable: clients
+// may switch on them, and a code's meaning never changes. Applications may
+// send the same codes, or their own, with Client.SendError or by returning
+// an *ErrorFrame from a handler or authorizer.
+const (
+	// ErrorCodeUnauthorized means the MessageAuthorizer or RoomAuthorizer
+	// refused the message.
+	ErrorCodeUnauthorized = "unauthorized"
+
+	// ErrorCodeRateLimited means the connection's rate limit dropped the
+	// frame, and ErrorCodeOverloaded that the handler-wide limit or the
+	// client's handler queue did.
+	ErrorCodeRateLimited = "rate_limited"
+	ErrorCodeOverloaded  = "overloaded"
+
+	// ErrorCodeTooLarge means an envelope's payload was over the Router's
+	// SetMaxPayloadSize limit. Frames over WithMaxMessageSize close the
+	// connection with 1009 instead.
+	ErrorCodeTooLarge = "too_large"
+
+	// ErrorCodeInvalidPayload means a payload did not decode into a
+	// Handle handler's type, and ErrorCodeValidationFailed that it did not
+	// match the Router's schema for its type.
+	ErrorCodeInvalidPayload   = "invalid_payload"
+	ErrorCodeValidationFailed = "validation_failed"
+
+	// ErrorCodeHandlerError and ErrorCodeHandlerTimeout report a message
+	// handler that failed or outlasted WithHandlerTimeout under
+	// ErrorReply, and ErrorCodeInternal one that panicked.
+	ErrorCodeHandlerError   = "handler_error"
+	ErrorCodeHandlerTimeout = "handler_timeout"
+	ErrorCodeInternal       = "internal"
+
+	// ErrorCodePersistFailed means an inbound envelope could not be saved.
+	ErrorCodePersistFailed = "persist_failed"
+
+	// ErrorCodeInvalidAck, ErrorCodeUnknownEnvelope and
+	// ErrorCodeConfirmFailed report acks that are malformed, name no
+	// pending envelope, or could not be confirmed.
+	ErrorCodeInvalidAck      = "invalid_ack"
+	ErrorCodeUnknownEnvelope = "unknown_envelope"
+	ErrorCodeConfirmFailed   = "confirm_failed"
+
+	// ErrorCodeInvalidRead and ErrorCodeReadFailed report read frames that
+	// list no envelope IDs, and IDs that could not be marked read. IDs
+	// naming no envelope of the client's get ErrorCodeUnknownEnvelope.
+	ErrorCodeInvalidRead = "invalid_read"
+	ErrorCodeReadFailed  = "read_failed"
+
+	// ErrorCodeInvalidHistory and ErrorCodeHistoryFailed report history
+	// frames whose payload is malformed, and history that could not be
+	// fetched or that the persister does not keep.
+	ErrorCodeInvalidHistory = "invalid_history"
+	ErrorCodeHistoryFailed  = "history_failed"
+
+	// ErrorCodeInvalidTopic and ErrorCodeSubscribeFailed report refused
+	// subscription frames, and ErrorCodeInvalidRoom and
+	// ErrorCodeJoinFailed refused room requests.
+	ErrorCodeInvalidTopic     = "invalid_topic"
+	ErrorCodeSubscribeFailed  = "subscribe_failed"
+	ErrorCodeInvalidRoom      = "invalid_room"
+	ErrorCodeJoinFailed       = "join_failed"
+	ErrorCodeInvalidRefresh   = "invalid_refresh"
+	ErrorCodeInvalidSignature = "invalid_signature"
+
+	// ErrorCodeSnapshotRequired answers a resync from a sequence number
+	// older than the connection's replay buffer under WithSequencing.
+	ErrorCodeSnapshotRequired = "snapshot_required"
+)
+
+// SendError queues an ErrorFrame for this connection. ref should be the ID
+// of the envelope concerned, when there is one, so the client can correlate
+// the error with its message. It fails like SendBinary, with
+// ErrSendQueueFull or ErrClientNotConnected, instead of blocking.
+func (c *Client) SendError(code, message, ref string) error {
+	return c.enqueue(outbound{data: newErrorFrame(code, message, ref)})
+}
+
+// frameRef returns the ID the client gave the envelope in an inbound frame,
+// or an empty string when the frame is not an envelope or has no ID. It is
+// for frames refused before they are decoded.
+func (c *Client) frameRef(messageType int, data []byte) string {
+	envelope, err := c.envelopeCodec().Unmarshal(data, messageType)
+	if err != nil || envelope.ID.IsZero() {
+		return ""
+	}
+	return envelope.ID.String()
+}
@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrorAction is what an error handler registered with WithErrorHandler
+// chooses to do about a failed message.
+type ErrorAction int
+
+const (
+	// ErrorIgnore drops the error and keeps reading. It is the default.
+	ErrorIgnore ErrorAction = iota
+
+	// ErrorReply sends the client an ErrorFrame with code "handler_error",
+	// "internal" for a panic or "handler_timeout" for ErrHandlerTimeout,
+	// referencing the failed envelope. A handler that returns an *ErrorFrame
+	// chooses the code and message instead.
+	ErrorReply
+
+	// ErrorClose closes the connection with code 1011 (Internal Error)
+	// once frames already queued for the client have been written.
+	ErrorClose
+)
+
+func (a ErrorAction) String() string {
+	switch a {
+	case ErrorIgnore:
+		return "ignore"
+	case ErrorReply:
+		return "reply"
+	case ErrorClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// handlerFailed logs an error returned by the message handler for msg and
+// applies the error handler's action, or when there is no error handler the
+// panic action for a recovered panic and the timeout action for a timeout.
+// ref is the ID of the envelope, or empty for frames that were not envelopes.
+func (h *WebsocketHandler) handlerFailed(client *Client, msg []byte, msgType, ref string, err error) {
+	h.logHandlerError(client, msgType, err)
+
+	action := ErrorIgnore
+	var panicked *PanicError
+	if h.config.onError != nil {
+		action = h.config.onError(client, msg, err)
+	} else if errors.As(err, &panicked) {
+		action = h.config.panicAction
+	} else if errors.Is(err, ErrHandlerTimeout) {
+		action = h.config.timeoutAction
+	}
+
+	switch action {
+	case ErrorReply:
+		code, message := ErrorCodeHandlerError, "message could not be handled"
+		var frame *ErrorFrame
+		if errors.As(err, &frame) {
+			code, message = frame.Code, frame.Message
+		} else if errors.As(err, &panicked) {
+			code, message = ErrorCodeInternal, "internal error"
+		} else if errors.Is(err, ErrHandlerTimeout) {
+			code, message = ErrorCodeHandlerTimeout, "message handling timed out"
+		}
+		client.SendError(code, message, ref)
+	case ErrorClose:
+		// Queued rather than written straight away so that frames
+		// already queued, such as replies, reach the client first. The
+		// read loop keeps going until the client answers the close.
+		if client.enqueue(outbound{data: []byte("handler error"), closeCode: websocket.CloseInternalServerErr}) != nil {
+			client.closeWith(websocket.CloseInternalServerErr, "handler error")
+		}
+	}
+}
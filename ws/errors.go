@@ -0,0 +1,149 @@
+package ws
+
+import "errors"
+
+var (
+	// ErrClientNotConnected is returned when a message is addressed to a
+	// client that is not connected to this handler.
+	ErrClientNotConnected = errors.New("ws: client not connected")
+
+	// ErrSendQueueFull is returned when a client's send queue has no room for
+	// another message.
+	ErrSendQueueFull = errors.New("ws: send queue full")
+
+	// ErrMessageTooLarge is passed to the disconnect hook when a client sent
+	// a message larger than the configured maximum message size.
+	ErrMessageTooLarge = errors.New("ws: message too large")
+
+	// ErrMalformedMessage is returned by the Router for frames that are not
+	// JSON envelopes.
+	ErrMalformedMessage = errors.New("ws: malformed message")
+
+	// ErrUnknownMessageType is returned by the Router for envelope types with
+	// no registered handler and no fallback.
+	ErrUnknownMessageType = errors.New("ws: unknown message type")
+
+	// ErrInvalidPayload is returned by handlers registered with Handle when
+	// an envelope's payload does not decode into the handler's type.
+	ErrInvalidPayload = errors.New("ws: invalid payload")
+
+	// ErrPayloadTooLarge is returned by the Router for envelopes whose
+	// payload exceeds the limit set with SetMaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("ws: payload too large")
+
+	// ErrInvalidMessageType is returned when a data message type other than
+	// websocket.TextMessage or websocket.BinaryMessage is requested.
+	ErrInvalidMessageType = errors.New("ws: message type must be text or binary")
+
+	// ErrHandlerPanic is returned by the Recovery middleware when the
+	// message handler panics.
+	ErrHandlerPanic = errors.New("ws: message handler panicked")
+
+	// ErrMessageDenied is returned when the MessageAuthorizer refuses an
+	// inbound message.
+	ErrMessageDenied = errors.New("ws: message denied")
+
+	// ErrJoinDenied is reported to observers when a client's request to
+	// join a room is refused.
+	ErrJoinDenied = errors.New("ws: room join denied")
+
+	// ErrInvalidSignature is returned when an envelope's signature is
+	// missing, names an unknown key or does not verify.
+	ErrInvalidSignature = errors.New("ws: invalid envelope signature")
+
+	// ErrUnknownEnvelope is returned when a client acknowledges an envelope
+	// that is not pending delivery to it.
+	ErrUnknownEnvelope = errors.New("ws: unknown envelope")
+
+	// ErrDeliveryFailed is passed to the delivery-failed hook when an
+	// envelope was never acknowledged despite redelivery.
+	ErrDeliveryFailed = errors.New("ws: envelope delivery failed")
+
+	// ErrPersisterClosed is returned when saving to a BatchingPersister
+	// after Close.
+	ErrPersisterClosed = errors.New("ws: persister closed")
+
+	// ErrPersisterUnavailable is returned by a BatchingPersister that is
+	// refusing envelopes because flushes are failing.
+	ErrPersisterUnavailable = errors.New("ws: persister unavailable")
+
+	// ErrHandlerTimeout is passed to the error handler when a message was
+	// still being handled once the handler timeout expired.
+	ErrHandlerTimeout = errors.New("ws: message handler timed out")
+
+	// ErrHandlerQueueFull is reported to observers for inbound messages
+	// discarded because the client's handler queue was full.
+	ErrHandlerQueueFull = errors.New("ws: handler queue full")
+
+	// ErrRateLimited is passed to the disconnect hook when a connection was
+	// closed under RateLimitClose for exceeding its rate limit.
+	ErrRateLimited = errors.New("ws: rate limit exceeded")
+
+	// ErrQueuedBytesLimit is returned for broadcast deliveries shed because
+	// the handler's send queues hold as many bytes as WithMaxQueuedBytesTotal
+	// allows.
+	ErrQueuedBytesLimit = errors.New("ws: queued bytes limit reached")
+
+	// ErrInvalidTopic is returned for topics and subscription patterns with
+	// empty segments or misplaced wildcards.
+	ErrInvalidTopic = errors.New("ws: invalid topic")
+
+	// ErrOverloaded is reported to observers for frames shed by the global
+	// rate limit.
+	ErrOverloaded = errors.New("ws: server overloaded")
+
+	// ErrTokenRefreshFailed is passed to the disconnect hook when a client's
+	// auth.refresh token was refused.
+	ErrTokenRefreshFailed = errors.New("ws: token refresh failed")
+
+	// ErrBanned is reported for upgrades refused because the identity or
+	// address is on the handler's DenyList.
+	ErrBanned = errors.New("ws: banned")
+
+	// ErrNullIdentity is returned by a RequireIdentity scanner for NULL
+	// columns.
+	ErrNullIdentity = errors.New("ws: identity is NULL")
+
+	// ErrUpgraderConflict is returned by NewHandler when options contradict
+	// an upgrader injected with WithUpgrader.
+	ErrUpgraderConflict = errors.New("ws: option conflicts with the injected upgrader")
+
+	// ErrHandshakeTimeout is reported for upgrades refused because they did
+	// not complete within the handshake timeout.
+	ErrHandshakeTimeout = errors.New("ws: handshake timed out")
+
+	// ErrFloodDetected is passed to the disconnect hook when flood
+	// protection closed a connection for sending frames too fast.
+	ErrFloodDetected = errors.New("ws: frame flood detected")
+
+	// ErrUnsupportedSubprotocol is reported for upgrades refused in strict
+	// subprotocol mode because the client requested none of the offered
+	// subprotocols.
+	ErrUnsupportedSubprotocol = errors.New("ws: unsupported subprotocol")
+
+	// ErrOriginNotAllowed is reported for upgrades refused because the
+	// request's Origin failed the handler's origin check.
+	ErrOriginNotAllowed = errors.New("ws: origin not allowed")
+
+	// ErrShuttingDown is reported for upgrades refused because Shutdown has
+	// been called.
+	ErrShuttingDown = errors.New("ws: handler shutting down")
+
+	// ErrTooManyConnections is reported for upgrades refused because the
+	// handler is at its connection limit.
+	ErrTooManyConnections = errors.New("ws: too many connections")
+
+	// ErrTooManyConnectionsPerIP is reported for upgrades refused because the
+	// remote IP is at its connection limit.
+	ErrTooManyConnectionsPerIP = errors.New("ws: too many connections from this address")
+
+	// ErrSchedulingDisabled is returned by SendAt and SendAfter when the
+	// handler was built without WithScheduledDelivery or its persister does
+	// not implement EnvelopeScheduler.
+	ErrSchedulingDisabled = errors.New("ws: scheduled delivery not enabled")
+
+	// ErrPersisterUnsupported is returned by handler methods that need an
+	// optional persister interface the handler's persister does not
+	// implement.
+	ErrPersisterUnsupported = errors.New("ws: not supported by the persister")
+)
@@ -0,0 +1,307 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+)
+
+const defaultHandlerQueueSize = 256
+
+// ExecutionMode decides where the message handler runs relative to a
+// connection's read loop. See WithExecutionMode.
+type ExecutionMode struct {
+	workers   int
+	unordered bool
+}
+
+var (
+	// SerialPerConnection handles each message in its connection's read
+	// loop, one at a time. It is the default. While the handler runs the
+	// connection reads nothing, pongs included, so a handler slower than
+	// the pong wait gets the connection closed.
+	SerialPerConnection = ExecutionMode{}
+
+	// Unordered handles every message on a goroutine of its own, so a
+	// connection's messages may be handled concurrently and in any order.
+	// Concurrent messages share the client, so its TraceContext may carry
+	// the span of another of them; HandleContext's ctx is always the
+	// message's own.
+	Unordered = ExecutionMode{unordered: true}
+)
+
+// WorkerPool handles messages on a pool of n goroutines shared by every
+// connection. Each connection's messages wait in a FIFO queue and are
+// handled one at a time, in the order they were read, while the read loop
+// carries on. n below 1 is taken as 1.
+func WorkerPool(n int) ExecutionMode {
+	if n < 1 {
+		n = 1
+	}
+	return ExecutionMode{workers: n}
+}
+
+func (m ExecutionMode) String() string {
+	switch {
+	case m.unordered:
+		return "unordered"
+	case m.workers > 0:
+		return fmt.Sprintf("worker-pool(%d)", m.workers)
+	default:
+		return "serial"
+	}
+}
+
+func (m ExecutionMode) serial() bool {
+	return !m.unordered && m.workers == 0
+}
+
+// WithExecutionMode sets where the message handler runs. Outside
+// SerialPerConnection, messages waiting for the handler queue per
+// connection up to the handler queue size; a message that does not fit is
+// treated according to the client's slow-consumer policy, and is reported
+// to observers with ErrHandlerQueueFull. A connection's read loop finishes
+// handling its queued messages, with its context cancelled, before the
+// disconnect hook runs.
+func WithExecutionMode(mode ExecutionMode) Option {
+	return func(c *config) {
+		c.executionMode = mode
+	}
+}
+
+// WithHandlerQueueSize sets how many messages per connection may wait for
+// the handler under WorkerPool, or be handled at once under Unordered. The
+// default is 256.
+func WithHandlerQueueSize(n int) Option {
+	return func(c *config) {
+		c.handlerQueueSize = n
+	}
+}
+
+// execute hands run, the handling of one message from client, to the
+// handler's execution mode. accepted, when not nil, runs once the message
+// is sure to be handled, before run starts, and discard, when not nil,
+// runs instead of run if the message is refused or dropped from the queue.
+// A message refused because the client's queue is full is reported under
+// ref, its envelope ID if any.
+func (h *WebsocketHandler) execute(client *Client, ref string, accepted, run, discard func()) {
+	if client.inbox == nil {
+		if accepted != nil {
+			accepted()
+		}
+		run()
+		return
+	}
+	if !client.inbox.submit(client, accepted, run, discard) {
+		if discard != nil {
+			discard()
+		}
+		h.handlerQueueFull(client, ref)
+	}
+}
+
+// handlerQueueFull reports a message refused by the client's handler queue.
+// The client is sent an "overloaded" error frame or, under
+// SlowConsumerClose, disconnected.
+func (h *WebsocketHandler) handlerQueueFull(client *Client, ref string) {
+	h.observeError(client, ErrHandlerQueueFull)
+	if client.SlowConsumerPolicy() == SlowConsumerClose {
+		client.closeSlowConsumer()
+		return
+	}
+	client.SendError(ErrorCodeOverloaded, "server is busy, try again later", ref)
+}
+
+// inbox holds a client's messages while they wait for, or run on, the
+// handler's execution mode.
+type inbox struct {
+	pool  *workerPool
+	limit int
+
+	mu        sync.Mutex
+	idle      *sync.Cond
+	jobs      []job
+	scheduled bool
+
+	// pending counts the jobs submitted and not yet finished, and running
+	// those started under Unordered.
+	pending int
+	running int
+}
+
+// job is a queued message: run handles it and discard, when not nil,
+// gives up on it.
+type job struct {
+	run, discard func()
+}
+
+func newInbox(pool *workerPool, limit int) *inbox {
+	if limit <= 0 {
+		limit = defaultHandlerQueueSize
+	}
+	b := &inbox{pool: pool, limit: limit}
+	b.idle = sync.NewCond(&b.mu)
+	return b
+}
+
+// submit queues run, or under Unordered starts it. A full queue makes room
+// by discarding its oldest job under SlowConsumerDropOldest and refuses run
+// otherwise; Unordered jobs already running cannot be discarded. A refused
+// job's discard is left to the caller.
+func (b *inbox) submit(client *Client, accepted, run, discard func()) bool {
+	b.mu.Lock()
+	if b.pool == nil {
+		if b.running >= b.limit {
+			b.mu.Unlock()
+			return false
+		}
+		b.running++
+		b.pending++
+		b.mu.Unlock()
+		if accepted != nil {
+			accepted()
+		}
+		go func() {
+			run()
+			b.mu.Lock()
+			b.running--
+			b.finishLocked()
+			b.mu.Unlock()
+		}()
+		return true
+	}
+
+	var dropped func()
+	if len(b.jobs) >= b.limit {
+		if client.SlowConsumerPolicy() != SlowConsumerDropOldest {
+			b.mu.Unlock()
+			return false
+		}
+		dropped = b.jobs[0].discard
+		b.jobs[0] = job{}
+		b.jobs = b.jobs[1:]
+		b.finishLocked()
+		client.handler.observeError(client, ErrHandlerQueueFull)
+	}
+	// Accepted under the lock so that a worker cannot start run first.
+	if accepted != nil {
+		accepted()
+	}
+	b.jobs = append(b.jobs, job{run: run, discard: discard})
+	b.pending++
+	schedule := !b.scheduled
+	b.scheduled = true
+	b.mu.Unlock()
+
+	if dropped != nil {
+		dropped()
+	}
+	if schedule {
+		b.pool.schedule(b)
+	}
+	return true
+}
+
+// runNext handles the oldest queued job. It reports whether more are
+// waiting, in which case the inbox stays scheduled and the caller must put
+// it back in line.
+func (b *inbox) runNext() bool {
+	b.mu.Lock()
+	next := b.jobs[0]
+	b.jobs[0] = job{}
+	b.jobs = b.jobs[1:]
+	b.mu.Unlock()
+
+	next.run()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.finishLocked()
+	b.scheduled = len(b.jobs) > 0
+	return b.scheduled
+}
+
+func (b *inbox) finishLocked() {
+	if b.pending--; b.pending == 0 {
+		b.idle.Broadcast()
+	}
+}
+
+// wait blocks until every submitted job has been handled or discarded.
+func (b *inbox) wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.pending > 0 {
+		b.idle.Wait()
+	}
+}
+
+// workerPool runs inboxes' jobs on a fixed set of goroutines. An inbox is
+// in the ready list at most once, so a connection's jobs never run
+// concurrently, and goes to the back of it after each job, so a busy
+// connection cannot starve the others.
+type workerPool struct {
+	mu      sync.Mutex
+	ready   []*inbox
+	wake    *sync.Cond
+	stopped bool
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{}
+	p.wake = sync.NewCond(&p.mu)
+	p.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) schedule(b *inbox) {
+	p.mu.Lock()
+	if p.stopped {
+		// The workers are gone; late jobs, such as persist retries,
+		// get a goroutine of their own.
+		p.mu.Unlock()
+		go func() {
+			for b.runNext() {
+			}
+		}()
+		return
+	}
+	p.ready = append(p.ready, b)
+	p.mu.Unlock()
+	p.wake.Signal()
+}
+
+func (p *workerPool) work() {
+	defer p.workers.Done()
+	for {
+		p.mu.Lock()
+		for len(p.ready) == 0 && !p.stopped {
+			p.wake.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		b := p.ready[0]
+		p.ready[0] = nil
+		p.ready = p.ready[1:]
+		p.mu.Unlock()
+
+		if b.runNext() {
+			p.schedule(b)
+		}
+	}
+}
+
+// stop lets the workers finish the jobs already scheduled and waits for
+// them to exit.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.wake.Broadcast()
+	p.workers.Wait()
+}
@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// WithExpvar publishes the handler's core counters with the expvar package,
+// so they show on /debug/vars as prefix.connections, prefix.messages_in and
+// so on. They read the counters behind Stats, so the two always agree.
+//
+// expvar names cannot be unpublished: the variables, and the handler they
+// read, live as long as the process. When a handler has already published
+// under prefix, the next one gets prefix.2, then prefix.3 and so on;
+// ExpvarPrefix reports the prefix a handler was given.
+func WithExpvar(prefix string) Option {
+	return func(c *config) {
+		c.expvarPrefix = prefix
+	}
+}
+
+// ExpvarPrefix returns the prefix the handler's counters are published
+// under, or an empty string without WithExpvar.
+func (h *WebsocketHandler) ExpvarPrefix() string {
+	return h.expvarPrefix
+}
+
+// expvarMu serializes the lookups and publishing of publishExpvar, so two
+// handlers created at once cannot claim the same prefix.
+var expvarMu sync.Mutex
+
+// publishExpvar publishes h's counters under the first free prefix derived
+// from prefix and returns it.
+func publishExpvar(prefix string, h *WebsocketHandler) string {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	name := prefix
+	for n := 2; expvar.Get(name+".connections") != nil; n++ {
+		name = prefix + "." + strconv.Itoa(n)
+	}
+
+	vars := map[string]func() any{
+		"connections":       func() any { return h.open.Load() },
+		"total_connections": func() any { return h.opened.Load() },
+		"messages_in":       func() any { return h.traffic.messagesIn.Load() },
+		"messages_out":      func() any { return h.traffic.messagesOut.Load() },
+		"bytes_in":          func() any { return h.traffic.bytesIn.Load() },
+		"bytes_out":         func() any { return h.traffic.bytesOut.Load() },
+		"dropped":           func() any { return h.dropped.Load() },
+		"rejected":          func() any { return h.rejected.Load() },
+	}
+	for key, f := range vars {
+		expvar.Publish(name+"."+key, expvar.Func(f))
+	}
+	return name
+}
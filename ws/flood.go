@@ -0,0 +1,118 @@
+package ws
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// floodSamples is how many samples the flood window is divided into. The
+// frame rate must exceed the ceiling in every sample of a window for the
+// breaker to trip, so short bursts are tolerated.
+const floodSamples = 4
+
+// floodMeter measures the frame rate of one connection, control frames
+// included. It is only used from the connection's read loop.
+type floodMeter struct {
+	limit    float64
+	window   time.Duration
+	interval time.Duration
+
+	sample    time.Time // start of the current sample
+	count     int       // frames in the current sample
+	over      bool      // whether the current sample is over the limit
+	overSince time.Time // start of the run of samples over the limit
+	overCount int       // frames since overSince
+}
+
+func newFloodMeter(limit float64, window time.Duration, now time.Time) *floodMeter {
+	return &floodMeter{
+		limit:    limit,
+		window:   window,
+		interval: window / floodSamples,
+		sample:   now,
+	}
+}
+
+// frame records a frame read at now. It reports the measured rate, in frames
+// per second, and true once the rate has stayed over the limit for the whole
+// window.
+func (m *floodMeter) frame(now time.Time) (float64, bool) {
+	if elapsed := now.Sub(m.sample); elapsed >= m.interval {
+		// A run of samples over the limit only continues into the very
+		// next sample; an idle gap or a sample under the limit ends it.
+		if !m.over || elapsed >= 2*m.interval {
+			m.overSince = time.Time{}
+			m.overCount = 0
+		}
+		m.sample = m.sample.Add(elapsed / m.interval * m.interval)
+		m.count = 0
+		m.over = false
+	}
+
+	m.count++
+	m.overCount++
+	if !m.over && float64(m.count) > m.limit*m.interval.Seconds() {
+		m.over = true
+		if m.overSince.IsZero() {
+			m.overSince = m.sample
+			m.overCount = m.count
+		}
+	}
+	if !m.over {
+		return 0, false
+	}
+
+	sustained := now.Sub(m.overSince)
+	if sustained < m.window {
+		return 0, false
+	}
+	return float64(m.overCount) / sustained.Seconds(), true
+}
+
+// countFrame feeds a frame to the client's flood meter, if it has one. Once
+// the breaker trips the connection is closed with code 1008 (Policy
+// Violation), the flood hook is told the measured rate and ErrFloodDetected
+// is returned to end the read loop.
+func (h *WebsocketHandler) countFrame(client *Client) error {
+	if client.flood == nil {
+		return nil
+	}
+	rate, tripped := client.flood.frame(h.config.clock.Now())
+	if !tripped {
+		return nil
+	}
+	client.closeWith(websocket.ClosePolicyViolation, "frame flood")
+	if h.config.onFlood != nil {
+		h.config.onFlood(client, rate)
+	}
+	return ErrFloodDetected
+}
+
+// watchFloods starts metering client's frames and hooks the control frame
+// handlers so pings and pongs count too.
+func (h *WebsocketHandler) watchFloods(client *Client) {
+	client.flood = newFloodMeter(h.config.floodLimit, h.config.floodWindow, h.config.clock.Now())
+
+	pong := client.Conn.PongHandler()
+	client.Conn.SetPongHandler(func(data string) error {
+		if err := h.countFrame(client); err != nil {
+			return err
+		}
+		return pong(data)
+	})
+	client.Conn.SetPingHandler(func(data string) error {
+		if err := h.countFrame(client); err != nil {
+			return err
+		}
+		// Mirror gorilla's default ping handler.
+		err := client.Conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if err == websocket.ErrCloseSent {
+			return nil
+		} else if _, ok := err.(net.Error); ok {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// HandleOption configures a handler registered with Handle.
+type HandleOption func(*handleConfig)
+
+type handleConfig struct {
+	strict bool
+}
+
+// WithStrictPayload rejects payloads with fields the handler's type does
+// not declare, instead of ignoring them.
+func WithStrictPayload() HandleOption {
+	return func(c *handleConfig) {
+		c.strict = true
+	}
+}
+
+// Handle registers fn on router for envelopes of type msgType, decoding
+// each payload from JSON into a T first. T may be a value or a pointer
+// type; an envelope without a payload decodes to T's zero value. A payload
+// that does not decode is answered with an "invalid_payload" ErrorFrame
+// naming the offending field, fn is not called, and the returned error
+// wraps ErrInvalidPayload.
+func Handle[T any](router *Router, msgType string, fn func(client *Client, msg T) error, opts ...HandleOption) {
+	var config handleConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	router.HandleFunc(msgType, func(client *Client, env Envelope) error {
+		var msg T
+		if err := decodePayload(env.Payload, &msg, config.strict); err != nil {
+			frame := payloadErrorFrame(err)
+			frame.Ref = env.ID.String()
+			data, _ := json.Marshal(frame)
+			client.enqueue(outbound{data: data})
+			return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+		}
+		return fn(client, msg)
+	})
+}
+
+func decodePayload(payload json.RawMessage, v any, strict bool) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+// payloadErrorFrame describes a payload decoding error for the client,
+// without echoing Go type names.
+func payloadErrorFrame(err error) ErrorFrame {
+	frame := ErrorFrame{Type: ErrorMessageType, Code: ErrorCodeInvalidPayload, Message: "payload is not valid JSON"}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		frame.Field = typeErr.Field
+		frame.Message = "expected " + jsonKind(typeErr.Type.Kind()) + ", got " + typeErr.Value
+		if frame.Field == "" {
+			frame.Message = "payload: " + frame.Message
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields.
+		field, uerr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if uerr == nil {
+			frame.Field = field
+		}
+		frame.Message = "unknown field"
+	}
+	return frame
+}
+
+// jsonKind names a Go kind the way a JSON client would.
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return kind.String()
+	}
+}
@@ -1,60 +1,668 @@
 package ws
 
 import (
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// ClientIDHeader is the upgrade response header carrying the identity assigned
+// to the connection. Validators that return a zero ClientID get a freshly
+// generated identity, which clients can read from this header and persist.
+const ClientIDHeader = "X-Client-ID"
+
+// retryAfterSeconds is the Retry-After hint sent when the handler is full.
+const retryAfterSeconds = "5"
+
 type WebsocketHandler struct {
 	SessionValidator  SessionValidator
 	MessageHandler    MessageHandler
 	EnvelopePersister EnvelopePersister
+
+	*Hub
+
+	// DenyList holds the identities and IP ranges refused at upgrade.
+	DenyList *DenyList
+
+	config config
+
+	mu       sync.Mutex
+	closing  bool
+	active   int
+	perIP    map[netip.Addr]int
+	sessions sync.WaitGroup
+
+	dedupe     *dedupeCache
+	middleware []Middleware
+	outbound   []OutboundInterceptor
+	janitor    *RetentionJanitor
+	workers    *workerPool
+
+	// base is the parent of every connection's context; Shutdown
+	// cancels it.
+	base       context.Context
+	cancelBase context.CancelCauseFunc
+
+	globalLimiter *tokenBucket
+	shed          atomic.Uint64
+	shedRate      rateGauge
+
+	// The counters behind Stats, kept by the observe helpers.
+	started  time.Time
+	open     atomic.Int64
+	opened   atomic.Uint64
+	traffic  trafficCounters
+	dropped  atomic.Uint64
+	rejected atomic.Uint64
+
+	// queuedBytes is the size of the frames in every client's send queue,
+	// and the broadcast counters count deliveries shed for exceeding the
+	// total byte cap.
+	queuedBytes        atomic.Int64
+	broadcastsShed     atomic.Uint64
+	broadcastBytesShed atomic.Uint64
+
+	// timeouts counts messages that timed out and late those whose
+	// handler is still running.
+	timeouts atomic.Uint64
+	late     atomic.Int64
+
+	// readBuffers counts the pooled read buffers still held by a frame.
+	readBuffers atomic.Int64
+
+	// expvarPrefix is the prefix the counters are published under, empty
+	// without WithExpvar.
+	expvarPrefix string
+
+	// resumable holds the sessions that may be resumed under
+	// WithSessionResumption, by session ID.
+	resumeMu  sync.Mutex
+	resumable map[Identity]*resumableSession
+
+	// scheduler is the persister's schedule under WithScheduledDelivery,
+	// and scheduleTimer the next promotion of due envelopes.
+	scheduler     EnvelopeScheduler
+	scheduleMu    sync.Mutex
+	scheduleTimer Timer
+}
+
+// NewWebSocketHandler returns a handler configured by opts. It panics if the
+// options conflict, which can only happen with WithUpgrader; use NewHandler
+// to get the error instead.
+func NewWebSocketHandler(validator SessionValidator, messeger MessageHandler, persister EnvelopePersister, opts ...Option) *WebsocketHandler {
+	h, err := NewHandler(validator, messeger, persister, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return h
 }
 
-func NewWebSocketHandler(validator SessionValidator, messeger MessageHandler, persister EnvelopePersister) *WebsocketHandler {
-	return &WebsocketHandler{
+// NewHandler is like NewWebSocketHandler but returns an error wrapping
+// ErrUpgraderConflict when an option conflicts with WithUpgrader.
+func NewHandler(validator SessionValidator, messeger MessageHandler, persister EnvelopePersister, opts ...Option) (*WebsocketHandler, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.upgraderConflicts(); err != nil {
+		return nil, err
+	}
+
+	h := &WebsocketHandler{
 		SessionValidator:  validator,
 		MessageHandler:    messeger,
 		EnvelopePersister: persister,
+		Hub:               NewHub(WithShards(cfg.hubShards), WithPresenceEvents(!cfg.noPresenceEvents)),
+		config:            cfg,
+		perIP:             make(map[netip.Addr]int),
+		started:           cfg.clock.Now(),
+		resumable:         make(map[Identity]*resumableSession),
+	}
+	h.base, h.cancelBase = context.WithCancelCause(cfg.baseContext)
+	h.Hub.observers = cfg.observers
+	h.DenyList = newDenyList(cfg.clock, h.Hub)
+	if cfg.bridge != nil {
+		if err := h.Hub.UseBridge(cfg.bridge); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.executionMode.workers > 0 {
+		h.workers = newWorkerPool(cfg.executionMode.workers)
+	}
+	if cfg.dedupeCacheSize > 0 {
+		h.dedupe = newDedupeCache(cfg.dedupeCacheSize)
+	}
+	if cfg.globalRate > 0 {
+		h.globalLimiter = newTokenBucket(cfg.globalRate, int(math.Ceil(cfg.globalRate)), cfg.clock.Now())
+	}
+	if cfg.retentionInterval > 0 && persister != nil {
+		h.janitor = NewRetentionJanitor(persister, cfg.retentionInterval, cfg.retentionWindow,
+			WithRetentionClock(cfg.clock),
+			WithRetentionHook(cfg.onRetentionPurge),
+		)
+		h.janitor.Start()
 	}
+	if scheduler, ok := persister.(EnvelopeScheduler); ok && cfg.scheduleInterval > 0 {
+		h.scheduler = scheduler
+		h.startSchedule()
+	}
+	if cfg.expvarPrefix != "" {
+		h.expvarPrefix = publishExpvar(cfg.expvarPrefix, h)
+	}
+	return h, nil
 }
 
+// ServeHTTP upgrades the request and serves the connection until it closes.
+// The hijacked connection is owned by the client's pumps, so ServeHTTP blocks
+// for the lifetime of the client rather than closing the socket on return.
 func (h *WebsocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	session, err := h.SessionValidator.Validate(r)
+	ip := h.clientIP(r)
+	if status, err := h.admit(ip); err != nil {
+		h.reject(w, r, status, err)
+		return
+	}
+	defer h.release(ip)
+
+	if !h.checkOrigin(r) {
+		h.reject(w, r, http.StatusForbidden, ErrOriginNotAllowed)
+		return
+	}
+	if !h.acceptsSubprotocol(r) {
+		h.reject(w, r, http.StatusBadRequest, ErrUnsupportedSubprotocol)
+		return
+	}
+
+	var deadline time.Time
+	if h.config.HandshakeTimeout > 0 {
+		deadline = time.Now().Add(h.config.HandshakeTimeout)
+	}
+	session, header, err := h.validate(r, deadline)
+	if errors.Is(err, ErrHandshakeTimeout) {
+		h.reject(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		h.rejectSession(w, r, header, err)
+		return
+	}
+	if h.DenyList.Banned(session.ClientID) || h.DenyList.BannedIP(ip) {
+		h.reject(w, r, http.StatusForbidden, ErrBanned)
 		return
 	}
 
-	var upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+	var resumed *Client
+	if h.config.resumeSecret != nil {
+		resumed = h.resumeSession(r, &session)
+	}
+	if session.ClientID.IsZero() {
+		session.ClientID = NewIdentity()
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	responseHeader := http.Header{}
+	for key, values := range header {
+		responseHeader[key] = values
+	}
+	responseHeader.Set(ClientIDHeader, session.ClientID.String())
+	resumeID := NewIdentity()
+	if resumed != nil {
+		resumeID = resumed.resumeID
+	}
+	if h.config.resumeSecret != nil {
+		responseHeader.Set(ResumeTokenHeader, h.resumeToken(resumeID, session.ClientID))
+	}
+
+	upgrader := h.upgrader(deadline)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		http.Error(w, "WebSocket upgrade failed", http.StatusInternalServerError)
+		// The upgrader has already replied, through its Error func if
+		// it has one.
+		h.logRejected(r, 0, err)
+		h.observeReject(r, 0, err)
+		if resumed != nil {
+			h.endSession(resumed, resumed.reason)
+		}
 		return
 	}
 
-	client := NewClient(session.ClientID, conn)
-	defer client.Conn.Close()
-	go HandleClient(client, h.MessageHandler, h.EnvelopePersister)
+	client := newClient(session.ClientID, conn, h.config.SendQueueSize)
+	client.handler = h
+	client.session = session
+	client.remoteAddr = r.RemoteAddr
+	client.remoteIP = ip
+	client.bindContext(h.connectionContext(r))
+	client.subprotocol = conn.Subprotocol()
+	client.codec = h.config.codecs[client.subprotocol]
+	client.compressed = upgrader.EnableCompression && offersCompression(r)
+	if client.compressed && h.config.compressionLevel != 0 {
+		conn.SetCompressionLevel(h.config.compressionLevel)
+	}
+	if resumed != nil {
+		client.resume(resumed)
+	} else if h.config.resumeSecret != nil {
+		client.resumeID = resumeID
+	}
+	for key, value := range session.Metadata {
+		client.Set(key, value)
+	}
+	if !h.track(client, resumed) {
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+		conn.Close()
+		client.cancel(ErrShuttingDown)
+		if resumed != nil {
+			h.endSession(resumed, resumed.reason)
+		}
+		return
+	}
+	if !client.resumeID.IsZero() {
+		h.startSession(client)
+	}
+
+	if resumed != nil {
+		if h.config.onResume != nil {
+			h.runHook(client, func() { h.config.onResume(client, session) })
+		}
+	} else {
+		h.logOpened(client)
+		h.observeConnect(client)
+		if h.config.onConnect != nil {
+			h.runHook(client, func() { h.config.onConnect(client, session) })
+		}
+	}
+
+	err = h.serveClient(client)
+	if h.suspend(client) {
+		return
+	}
+	h.endSession(client, err)
+}
+
+// Shutdown stops accepting new connections, cancels every connection's
+// context with ErrShuttingDown, sends a Going Away close frame to every
+// connected client and waits for their pumps to exit. It also ends the
+// sessions parked for resumption and stops the retention janitor, the
+// promotion of scheduled envelopes and the worker pool, if any. If ctx expires
+// first the remaining connections are closed forcibly and ctx's error is
+// returned once they have exited.
+func (h *WebsocketHandler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	h.mu.Unlock()
+	h.cancelBase(ErrShuttingDown)
+	h.expireAll()
+
+	if h.janitor != nil {
+		h.janitor.Stop()
+	}
+	h.stopSchedule()
+
+	h.Range(func(client *Client) bool {
+		client.recordClose(DisconnectShutdown, websocket.CloseGoingAway, "server shutting down")
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		h.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		h.stopWorkers()
+		return nil
+	case <-ctx.Done():
+	}
+
+	h.Range(func(client *Client) bool {
+		client.Conn.Close()
+		return true
+	})
+
+	<-done
+	h.stopWorkers()
+	return ctx.Err()
 }
 
+func (h *WebsocketHandler) stopWorkers() {
+	if h.workers != nil {
+		h.workers.stop()
+	}
+}
+
+// admit reserves a connection slot for ip before the upgrade. The checks
+// against the connection limits and the reservation happen under one lock so
+// concurrent upgrades cannot overshoot them. Every successful admit must be
+// paired with a release once the connection has been torn down.
+func (h *WebsocketHandler) admit(ip netip.Addr) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closing {
+		return http.StatusServiceUnavailable, ErrShuttingDown
+	}
+	if h.config.MaxConnections > 0 && h.active >= h.config.MaxConnections {
+		return http.StatusServiceUnavailable, ErrTooManyConnections
+	}
+	if h.config.MaxConnectionsPerIP > 0 && h.perIP[ip] >= h.config.MaxConnectionsPerIP {
+		return http.StatusTooManyRequests, ErrTooManyConnectionsPerIP
+	}
+
+	h.active++
+	h.perIP[ip]++
+	h.sessions.Add(1)
+	return 0, nil
+}
+
+func (h *WebsocketHandler) release(ip netip.Addr) {
+	h.mu.Lock()
+	h.active--
+	if h.perIP[ip]--; h.perIP[ip] <= 0 {
+		delete(h.perIP, ip)
+	}
+	h.mu.Unlock()
+
+	h.sessions.Done()
+}
+
+// acceptsSubprotocol reports whether the upgrade may proceed given the
+// subprotocols the client requested: always, unless strict mode is on and
+// none of them is offered.
+func (h *WebsocketHandler) acceptsSubprotocol(r *http.Request) bool {
+	requested := websocket.Subprotocols(r)
+	if !h.config.strictSubprotocols || len(requested) == 0 {
+		return true
+	}
+	for _, name := range requested {
+		for _, offered := range h.config.subprotocols {
+			if name == offered {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// track registers an admitted client with the hub, in the place of the
+// client whose session it resumes, if any. It reports false once Shutdown
+// has begun so that no connection outlives the handler.
+func (h *WebsocketHandler) track(client, resumed *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closing {
+		return false
+	}
+
+	if resumed != nil {
+		resumed.successor.Store(client)
+		h.Hub.replace(resumed, client)
+		client.queuedBytes.Add(resumed.queuedBytes.Swap(0))
+		return true
+	}
+	h.Register(client)
+	return true
+}
+
+// reject refuses an upgrade with status and reports err to the rejection
+// hook. Capacity rejections carry a Retry-After header.
+func (h *WebsocketHandler) reject(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if err == ErrTooManyConnections || err == ErrTooManyConnectionsPerIP {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+	http.Error(w, http.StatusText(status), status)
+
+	h.logRejected(r, status, err)
+	h.observeReject(r, status, err)
+	if h.config.onReject != nil {
+		h.config.onReject(r, status, err)
+	}
+}
+
+// MaxMessageSize returns the largest inbound message the handler accepts, or
+// zero when there is no limit. Applications can use it to chunk large
+// payloads exchanged with clients.
+func (h *WebsocketHandler) MaxMessageSize() int64 {
+	return h.config.MaxMessageSize
+}
+
+// HandleClient serves client with the default handler configuration. See
+// WebsocketHandler for the persistence and keepalive behavior.
 func HandleClient(client *Client, messager MessageHandler, persister EnvelopePersister) {
+	NewWebSocketHandler(nil, messager, persister).serveClient(client)
+}
+
+// serveClient runs the read loop for client alongside its write pump and
+// closes the connection once both have exited. Handlers must queue outbound
+// messages on client.Send rather than writing to client.Conn directly.
+//
+// Every inbound JSON object is wrapped in an Envelope and saved before the
+// message handler runs. If SaveEnvelope fails it is retried according to the
+// handler's PersistRetryPolicy; a message that cannot be saved is not handled
+// and is either dead lettered to the persist-failure hook or rejected with an
+// error frame referencing the envelope ID. When a MessageAuthorizer is
+// configured it runs first, and denied envelopes are neither persisted nor
+// handled. Frames that are not JSON objects are handed to the message handler
+// without being persisted. Replies to outstanding Client.Request calls are
+// routed to the waiting caller and ack frames confirm delivery of envelopes
+// sent to the client; neither is persisted nor handled, and nor are
+// auth.refresh frames when the validator is a TokenRefresher, heartbeat
+// echoes when the handler sends heartbeats, or resync requests under
+// WithSequencing.
+//
+// The write pump pings the client every ping interval; if no pong arrives
+// within the pong wait the read deadline expires and the connection is closed.
+// Messages are handled according to the handler's ExecutionMode, and
+// serveClient waits for those still queued before it returns.
+//
+// serveClient returns a *DisconnectReason wrapping the error that
+// terminated the read loop, which also becomes the cause of the client's
+// cancelled context and what Client.Wait returns.
+func (h *WebsocketHandler) serveClient(client *Client) (err error) {
+	if client.handler == nil {
+		client.handler = h
+	}
+	if client.ctx == nil {
+		client.bindContext(h.base)
+	}
+	if !h.config.executionMode.serial() {
+		client.inbox = newInbox(h.workers, h.config.handlerQueueSize)
+	}
+
+	pumpDone := make(chan struct{})
+	go func() {
+		defer close(pumpDone)
+		client.writePump()
+	}()
+	forwardDone := make(chan struct{})
+	go func() {
+		defer close(forwardDone)
+		client.forwardSend()
+	}()
+
+	defer func() {
+		if h.parkable(client, err) {
+			client.parked.Store(true)
+		}
+		close(client.done)
+		<-pumpDone
+		<-forwardDone
+		if !client.parked.Load() {
+			client.drainQueue()
+		}
+		client.stopRedelivery()
+		client.stopSessionWatch()
+		client.stopIdleWatch()
+		client.stopHeartbeat()
+		client.Conn.Close()
+		client.reason = client.classify(err)
+		err = client.reason
+		client.cancel(err)
+		if client.inbox != nil {
+			client.inbox.wait()
+		}
+		close(client.ended)
+	}()
+
+	client.watchSession()
+	client.watchIdle()
+	client.watchHeartbeat()
+	h.replayUndelivered(client)
+
+	if h.config.rateLimit > 0 {
+		client.limiter = newTokenBucket(h.config.rateLimit, h.config.rateBurst, h.config.clock.Now())
+	}
+	if h.config.MaxMessageSize > 0 {
+		client.Conn.SetReadLimit(h.config.MaxMessageSize)
+	}
+	client.Conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
+	})
+	if h.config.floodLimit > 0 && h.config.floodWindow > 0 {
+		h.watchFloods(client)
+	}
+
 	for {
-		_, message, err := client.Conn.ReadMessage()
+		messageType, buf, err := client.readMessage()
 		if err != nil {
-			break
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// gorilla has already sent the 1009 close frame.
+				return ErrMessageTooLarge
+			}
+			return err
 		}
-
-		err = messager.Handle(client, message)
+		err = h.serveFrame(client, messageType, buf)
+		buf.release()
 		if err != nil {
-			continue
+			return err
 		}
 	}
+}
 
-	client.Conn.Close()
+// serveFrame handles one inbound frame. The stages that hand buf on to
+// the execution mode or the persister take references of their own, so
+// the read loop releases its reference once serveFrame returns.
+func (h *WebsocketHandler) serveFrame(client *Client, messageType int, buf *readBuffer) error {
+	message := buf.data
+	h.observeReceive(client, len(message))
+	if err := h.countFrame(client); err != nil {
+		return err
+	}
+	if client.heartbeatEcho(messageType, message) {
+		return nil
+	}
+	if h.config.idleTimeout > 0 {
+		client.touch()
+	}
+	if ok, err := h.limitRate(client, messageType, message); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+	if client.resync(messageType, message) {
+		return nil
+	}
+
+	decoded, err := client.envelopeCodec().Unmarshal(message, messageType)
+	if err != nil {
+		if h.limitGlobal(client, "") {
+			h.handleRaw(client, messageType, buf)
+		}
+		return nil
+	}
+	if decoded.Type == AckMessageType {
+		client.handleAck(decoded.ID)
+		return nil
+	}
+	if h.config.verifyKeys != nil && !h.verifySignature(client, decoded) {
+		return nil
+	}
+	if refresher, ok := h.SessionValidator.(TokenRefresher); ok && decoded.Type == AuthRefreshMessageType {
+		return h.refreshToken(client, refresher, decoded.inbound(client.ID))
+	}
+
+	// Frames carrying their own ID are acked once accepted, and a
+	// retried copy is acked again without being handled twice.
+	identified := !decoded.ID.IsZero()
+	envelope := decoded.inbound(client.ID)
+	if client.resolveReply(envelope) {
+		return nil
+	}
+	if identified && h.isDuplicate(client, envelope.ID) {
+		client.sendAck(envelope.ID)
+		return nil
+	}
+	if h.config.authorizer != nil {
+		if err := client.authorize(h.config.authorizer, envelope); err != nil {
+			h.observeError(client, err)
+			return nil
+		}
+	}
+	switch envelope.Type {
+	case SubscribeMessageType, UnsubscribeMessageType:
+		h.handleSubscription(client, envelope, identified)
+		return nil
+	case RoomJoinMessageType, RoomLeaveMessageType:
+		h.handleRoomRequest(client, envelope)
+		return nil
+	case ReadMessageType:
+		h.handleRead(client, envelope, identified)
+		return nil
+	case HistoryMessageType:
+		h.handleHistory(client, envelope)
+		return nil
+	}
+
+	ref := ""
+	if identified {
+		ref = envelope.ID.String()
+	}
+	if !h.limitGlobal(client, ref) {
+		return nil
+	}
+	h.persist(inboundMessage{
+		client:     client,
+		buf:        buf,
+		envelope:   envelope,
+		identified: identified,
+	})
+	return nil
+}
+
+// handleRaw delivers a frame that is not an envelope in the connection's
+// codec. Binary frames go to HandleBinary when the MessageHandler implements
+// BinaryMessageHandler. The job holds a reference to buf until it is done,
+// and the handler holds another so a handler outliving its timeout never
+// sees the buffer reused.
+func (h *WebsocketHandler) handleRaw(client *Client, messageType int, buf *readBuffer) {
+	buf.retain()
+	h.execute(client, "", nil, func() {
+		defer buf.release()
+		message := buf.data
+		ctx, end := h.startSpan(client, nil, len(message))
+		start := time.Now()
+		buf.retain()
+		err := h.timed(ctx, client, "", func(ctx context.Context) error {
+			defer buf.release()
+			return h.dispatch(client, message, func() error {
+				if bh, ok := h.MessageHandler.(BinaryMessageHandler); ok && messageType == websocket.BinaryMessage {
+					return bh.HandleBinary(client, message)
+				}
+				return h.handleMessage(ctx, client, message)
+			})
+		})
+		h.observeHandled(client, "", start, err)
+		end(err)
+		if err != nil {
+			h.handlerFailed(client, message, "", "", err)
+		}
+	}, buf.release)
 }
@@ -0,0 +1,67 @@
+package ws
+
+import (
+	"context"
+	"time"
+)
+
+// WithHandlerTimeout bounds the handling of each inbound message,
+// middleware included, to d. The ctx passed to a ContextMessageHandler
+// carries the deadline, with ErrHandlerTimeout as its cause; handlers
+// implementing only Handle do not see it. A message still being handled
+// when d expires fails with ErrHandlerTimeout, which goes to the error
+// handler, or to the timeout action when there is none, and the connection
+// moves on to its next message. The late handler keeps running in the
+// background: it is counted by Stats and logged when it returns. Zero, the
+// default, means no limit.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.handlerTimeout = d
+	}
+}
+
+// WithHandlerTimeoutAction sets what happens after a message times out,
+// when no error handler is registered to decide. The default, ErrorIgnore,
+// keeps the connection alive; ErrorReply sends an error frame with code
+// "handler_timeout" and ErrorClose closes the connection.
+func WithHandlerTimeoutAction(action ErrorAction) Option {
+	return func(c *config) {
+		c.timeoutAction = action
+	}
+}
+
+// timed runs the handling of a message of msgType under the handler
+// timeout, if there is one. It returns run's error or, once the timeout
+// expires, ErrHandlerTimeout, leaving run to finish in the background.
+func (h *WebsocketHandler) timed(ctx context.Context, client *Client, msgType string, run func(ctx context.Context) error) error {
+	d := h.config.handlerTimeout
+	if d <= 0 {
+		return run(ctx)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, d, ErrHandlerTimeout)
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- run(ctx)
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		cancel()
+		return err
+	case <-timer.C:
+	}
+
+	h.timeouts.Add(1)
+	h.late.Add(1)
+	go func() {
+		err := <-done
+		cancel()
+		h.late.Add(-1)
+		h.logLateHandler(client, msgType, time.Since(start), err)
+	}()
+	return ErrHandlerTimeout
+}
@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// HeartbeatMessageType is the type of the application-level heartbeat
+// frames sent under WithHeartbeat:
+// {"type":"_hb","seq":<n>,"ts":<unix milliseconds>}. Clients echo each one
+// back unchanged. Heartbeats are JSON text frames whatever the
+// connection's codec, and echoes are neither persisted nor handled.
+const HeartbeatMessageType = "_hb"
+
+const defaultHeartbeatMisses = 3
+
+// WithHeartbeat sends a heartbeat frame to every connection each interval
+// and closes connections that leave misses heartbeats in a row unechoed,
+// with close code 1000 (Normal Closure), reason "heartbeat timeout" and a
+// DisconnectTimeout reason. Unlike protocol pings, heartbeats are visible
+// to browser code, so the client can detect a half-open connection too,
+// and the echoes give each connection's round-trip time in Client.RTT.
+// misses defaults to 3.
+func WithHeartbeat(interval time.Duration, misses int) Option {
+	return func(c *config) {
+		if misses <= 0 {
+			misses = defaultHeartbeatMisses
+		}
+		c.heartbeatInterval = interval
+		c.heartbeatMisses = misses
+	}
+}
+
+type heartbeatFrame struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+	TS   int64  `json:"ts"`
+}
+
+// heartbeatState tracks the heartbeats sent to a connection. sentAt holds
+// the send times of those not yet echoed.
+type heartbeatState struct {
+	mu     sync.Mutex
+	timer  Timer
+	seq    uint64
+	echoed uint64
+	sentAt map[uint64]time.Time
+	last   time.Time
+	rtt    time.Duration
+}
+
+// LastHeartbeat returns when the client last echoed a heartbeat, or the
+// zero time if it has not, or the handler sends none.
+func (c *Client) LastHeartbeat() time.Time {
+	c.heartbeat.mu.Lock()
+	defer c.heartbeat.mu.Unlock()
+	return c.heartbeat.last
+}
+
+// RTT returns the round-trip time measured by the latest heartbeat echo,
+// or zero before the first one.
+func (c *Client) RTT() time.Duration {
+	c.heartbeat.mu.Lock()
+	defer c.heartbeat.mu.Unlock()
+	return c.heartbeat.rtt
+}
+
+// watchHeartbeat schedules the first heartbeat, when the handler sends
+// them.
+func (c *Client) watchHeartbeat() {
+	interval := c.handler.config.heartbeatInterval
+	if interval <= 0 {
+		return
+	}
+
+	c.heartbeat.mu.Lock()
+	defer c.heartbeat.mu.Unlock()
+	c.heartbeat.sentAt = make(map[uint64]time.Time)
+	c.heartbeat.timer = c.handler.config.clock.AfterFunc(interval, c.beat)
+}
+
+// beat sends the next heartbeat and schedules the one after it, or closes
+// the connection when too many have gone unechoed.
+func (c *Client) beat() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	config := c.handler.config
+	hb := &c.heartbeat
+	hb.mu.Lock()
+	if hb.seq-hb.echoed >= uint64(config.heartbeatMisses) {
+		hb.mu.Unlock()
+		c.disconnect(DisconnectTimeout, websocket.CloseNormalClosure, "heartbeat timeout")
+		return
+	}
+	now := config.clock.Now()
+	hb.seq++
+	hb.sentAt[hb.seq] = now
+	frame := heartbeatFrame{Type: HeartbeatMessageType, Seq: hb.seq, TS: now.UnixMilli()}
+	hb.timer = config.clock.AfterFunc(config.heartbeatInterval, c.beat)
+	hb.mu.Unlock()
+
+	data, _ := json.Marshal(frame)
+	c.enqueue(outbound{data: data, control: true})
+}
+
+var heartbeatMarker = []byte(`"` + HeartbeatMessageType + `"`)
+
+// heartbeatEcho records the echo of a heartbeat and reports whether the
+// frame was one, in which case the read loop drops it. Echoes of unknown or
+// already echoed heartbeats are dropped without being recorded.
+func (c *Client) heartbeatEcho(messageType int, message []byte) bool {
+	if c.handler.config.heartbeatInterval <= 0 || messageType != websocket.TextMessage ||
+		!bytes.Contains(message, heartbeatMarker) {
+		return false
+	}
+	var frame heartbeatFrame
+	if err := json.Unmarshal(message, &frame); err != nil || frame.Type != HeartbeatMessageType {
+		return false
+	}
+
+	hb := &c.heartbeat
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	sentAt, ok := hb.sentAt[frame.Seq]
+	if !ok {
+		return true
+	}
+	now := c.handler.config.clock.Now()
+	hb.echoed = frame.Seq
+	hb.last = now
+	hb.rtt = now.Sub(sentAt)
+	for seq := range hb.sentAt {
+		if seq <= frame.Seq {
+			delete(hb.sentAt, seq)
+		}
+	}
+	return true
+}
+
+// stopHeartbeat cancels the pending heartbeat, if any.
+func (c *Client) stopHeartbeat() {
+	c.heartbeat.mu.Lock()
+	defer c.heartbeat.mu.Unlock()
+
+	if c.heartbeat.timer != nil {
+		c.heartbeat.timer.Stop()
+		c.heartbeat.timer = nil
+	}
+}
@@ -0,0 +1,91 @@
+package ws
+
+import "encoding/json"
+
+// HistoryMessageType is the type of the frame clients send to page back
+// through their envelopes, newest page first:
+// {"type":"_history","id":"<uuid>","payload":{"before":"<envelope-id>","limit":50}}.
+// Without before the newest page is returned. The server answers with an
+// ack carrying a HistoryPage.
+const HistoryMessageType = "_history"
+
+// Page sizes for HistoryMessageType frames: the limit when the frame gives
+// none, and the most one page may hold.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// historyRequest is the payload of history frames.
+type historyRequest struct {
+	Before Identity `json:"before"`
+	Limit  int      `json:"limit"`
+}
+
+// HistoryPage is the payload of the ack answering a HistoryMessageType
+// frame. Envelopes are the page, oldest first: the ID of the first is the
+// before of the next older page, and an empty page means there is none.
+// Unread is the client's count of undelivered envelopes, or zero when the
+// persister cannot count them.
+type HistoryPage struct {
+	Envelopes []Envelope `json:"envelopes"`
+	Unread    int        `json:"unread"`
+}
+
+// Unread returns how many envelopes sent to id are awaiting delivery, for
+// badges such as "12 unread". It returns ErrPersisterUnsupported unless the
+// handler's persister implements UndeliveredCounter.
+func (h *WebsocketHandler) Unread(id Identity) (int, error) {
+	counter, ok := h.EnvelopePersister.(UndeliveredCounter)
+	if !ok {
+		return 0, ErrPersisterUnsupported
+	}
+	return counter.CountUndelivered(id)
+}
+
+// handleHistory answers a history frame with a page of the client's
+// envelopes from the handler's EnvelopeHistoryFetcher. A cursor naming no
+// envelope of the client's is answered with an unknown_envelope error frame.
+func (h *WebsocketHandler) handleHistory(client *Client, envelope Envelope) {
+	ref := envelope.ID.String()
+	req := historyRequest{Limit: defaultHistoryLimit}
+	if len(envelope.Payload) > 0 {
+		if err := envelope.DecodePayload(&req); err != nil || req.Limit < 0 {
+			client.SendError(ErrorCodeInvalidHistory, "before must be an envelope ID and limit not negative", ref)
+			return
+		}
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultHistoryLimit
+	}
+	req.Limit = min(req.Limit, maxHistoryLimit)
+
+	fetcher, ok := h.EnvelopePersister.(EnvelopeHistoryFetcher)
+	if !ok {
+		client.SendError(ErrorCodeHistoryFailed, "history is not available", ref)
+		return
+	}
+	envelopes, err := fetcher.FetchEnvelopes(client.ID, req.Before, req.Limit)
+	switch {
+	case err == ErrUnknownEnvelope:
+		client.SendError(ErrorCodeUnknownEnvelope, "before names no envelope of the client's", ref)
+		return
+	case err != nil:
+		client.SendError(ErrorCodeHistoryFailed, "history could not be fetched", ref)
+		return
+	}
+
+	page := HistoryPage{Envelopes: envelopes}
+	if page.Envelopes == nil {
+		page.Envelopes = []Envelope{}
+	}
+	if counter, ok := h.EnvelopePersister.(UndeliveredCounter); ok {
+		page.Unread, _ = counter.CountUndelivered(client.ID)
+	}
+	payload, err := json.Marshal(page)
+	if err != nil {
+		client.SendError(ErrorCodeHistoryFailed, "history could not be encoded", ref)
+		return
+	}
+	client.sendAckPayload(envelope.ID, payload)
+}
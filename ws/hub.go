@@ -0,0 +1,560 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/maphash"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parallelFanOut is the number of registered clients from which Broadcast
+// walks the hub's shards in parallel rather than one after another.
+const parallelFanOut = 1024
+
+// shardWorkerIdle is how long a shard's broadcast worker waits for another
+// broadcast before it exits.
+const shardWorkerIdle = 10 * time.Second
+
+// Hub is a concurrency-safe registry of connected clients. WebsocketHandler
+// embeds a Hub, registering each client on upgrade and unregistering it when
+// the connection closes.
+//
+// An identity may have several live connections at once (one per device), so
+// every *Client is a single connection and lookups by Identity fan out to all
+// of them.
+//
+// Clients are spread over shards by identity, each with its own lock and
+// broadcast worker, so that registrations and broadcasts on a hub with many
+// connections do not contend on a single lock. Rooms, tags, topic
+// subscriptions and the bridge share a lock of their own.
+type Hub struct {
+	shards []*hubShard
+	seed   maphash.Seed
+	size   atomic.Int64
+
+	mu          sync.RWMutex
+	rooms       map[string]*room
+	clientRooms map[*Client]map[string]struct{}
+
+	tags map[string]map[*Client]struct{}
+
+	topics       *topicNode
+	clientTopics map[*Client]map[string]struct{}
+
+	bridge       Bridge
+	bridgedRooms map[string]struct{}
+
+	presence     bool
+	roomPresence map[string]bool
+
+	observers []Observer
+}
+
+// hubShard holds the clients whose identities hash to it.
+type hubShard struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+	byID    map[Identity][]*Client
+
+	// jobs hands broadcasts to the shard's worker, which is started by
+	// the first one and exits after shardWorkerIdle without another.
+	jobs      chan shardJob
+	workerMu  sync.Mutex
+	hasWorker bool
+}
+
+// shardJob is one shard's part of a parallel fan-out.
+type shardJob struct {
+	send   func(*Client) error
+	result *BroadcastResult
+	done   *sync.WaitGroup
+}
+
+// HubOption configures NewHub.
+type HubOption func(*hubConfig)
+
+type hubConfig struct {
+	shards   int
+	presence bool
+}
+
+// WithShards sets how many shards the hub spreads its clients over. The
+// default, used when n is not positive, is runtime.GOMAXPROCS(0).
+func WithShards(n int) HubOption {
+	return func(c *hubConfig) {
+		if n > 0 {
+			c.shards = n
+		}
+	}
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	config := hubConfig{shards: runtime.GOMAXPROCS(0), presence: true}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	h := &Hub{
+		shards:      make([]*hubShard, config.shards),
+		seed:        maphash.MakeSeed(),
+		rooms:       make(map[string]*room),
+		clientRooms: make(map[*Client]map[string]struct{}),
+		tags:        make(map[string]map[*Client]struct{}),
+
+		topics:       &topicNode{},
+		clientTopics: make(map[*Client]map[string]struct{}),
+
+		presence:     config.presence,
+		roomPresence: make(map[string]bool),
+	}
+	for i := range h.shards {
+		h.shards[i] = &hubShard{
+			clients: make(map[*Client]struct{}),
+			byID:    make(map[Identity][]*Client),
+			jobs:    make(chan shardJob),
+		}
+	}
+	return h
+}
+
+func (h *Hub) shard(id Identity) *hubShard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
+	}
+	return h.shards[maphash.Bytes(h.seed, id[:])%uint64(len(h.shards))]
+}
+
+// Register adds client to the hub alongside any other connections sharing
+// its identity. Registering the same client twice is a no-op.
+func (h *Hub) Register(client *Client) {
+	shard := h.shard(client.ID)
+	shard.mu.Lock()
+	if _, ok := shard.clients[client]; ok {
+		shard.mu.Unlock()
+		return
+	}
+	shard.clients[client] = struct{}{}
+	if len(shard.byID[client.ID]) == 0 {
+		h.track(client.ID)
+	}
+	shard.byID[client.ID] = append(shard.byID[client.ID], client)
+	h.size.Add(1)
+	shard.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client.mu.Lock()
+	client.hub = h
+	for tag := range client.tags {
+		h.indexTagLocked(tag, client)
+	}
+	client.mu.Unlock()
+}
+
+// Unregister removes client from the hub, from every room it joined and
+// from every topic it subscribed to. It is a no-op for clients that are not
+// registered.
+func (h *Hub) Unregister(client *Client) {
+	shard := h.shard(client.ID)
+	shard.mu.Lock()
+	if _, ok := shard.clients[client]; !ok {
+		shard.mu.Unlock()
+		return
+	}
+	delete(shard.clients, client)
+	conns := shard.byID[client.ID]
+	for i, c := range conns {
+		if c == client {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(shard.byID, client.ID)
+		h.untrack(client.ID)
+	} else {
+		shard.byID[client.ID] = conns
+	}
+	h.size.Add(-1)
+	shard.mu.Unlock()
+
+	h.mu.Lock()
+	changes := h.leaveAllLocked(client)
+	h.unsubscribeAllLocked(client)
+	client.mu.Lock()
+	for tag := range client.tags {
+		h.unindexTagLocked(tag, client)
+	}
+	client.hub = nil
+	client.mu.Unlock()
+	h.mu.Unlock()
+
+	for _, change := range changes {
+		h.announcePresence(change)
+	}
+}
+
+// replace puts client in the place of old, a connection of the same
+// identity, in the hub, its rooms, its topic subscriptions and the tag
+// index, without presence changes. client already carries old's tags.
+func (h *Hub) replace(old, client *Client) {
+	shard := h.shard(old.ID)
+	shard.mu.Lock()
+	delete(shard.clients, old)
+	shard.clients[client] = struct{}{}
+	for i, c := range shard.byID[old.ID] {
+		if c == old {
+			shard.byID[old.ID][i] = client
+		}
+	}
+	shard.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for roomName := range h.clientRooms[old] {
+		r := h.rooms[roomName]
+		delete(r.members, old)
+		r.members[client] = struct{}{}
+	}
+	if rooms, ok := h.clientRooms[old]; ok {
+		h.clientRooms[client] = rooms
+		delete(h.clientRooms, old)
+	}
+	for pattern := range h.clientTopics[old] {
+		segments := strings.Split(pattern, topicSeparator)
+		h.topics.remove(segments, old)
+		h.topics.add(segments, client)
+	}
+	if patterns, ok := h.clientTopics[old]; ok {
+		h.clientTopics[client] = patterns
+		delete(h.clientTopics, old)
+	}
+
+	old.mu.Lock()
+	for tag := range old.tags {
+		h.unindexTagLocked(tag, old)
+	}
+	old.hub = nil
+	old.mu.Unlock()
+
+	client.mu.Lock()
+	client.hub = h
+	for tag := range client.tags {
+		h.indexTagLocked(tag, client)
+	}
+	client.mu.Unlock()
+}
+
+// registered reports whether client is registered with the hub.
+func (h *Hub) registered(client *Client) bool {
+	shard := h.shard(client.ID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	_, ok := shard.clients[client]
+	return ok
+}
+
+// Get returns the most recently registered connection for id. It reports
+// true for as long as at least one connection for id remains.
+func (h *Hub) Get(id Identity) (*Client, bool) {
+	shard := h.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	conns := shard.byID[id]
+	if len(conns) == 0 {
+		return nil, false
+	}
+	return conns[len(conns)-1], true
+}
+
+// Connections returns every live connection for id in registration order.
+func (h *Hub) Connections(id Identity) []*Client {
+	shard := h.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	return append([]*Client(nil), shard.byID[id]...)
+}
+
+// Len returns the number of registered connections.
+func (h *Hub) Len() int {
+	return int(h.size.Load())
+}
+
+// Range calls fn for each registered client until fn returns false. It
+// iterates over a snapshot, so fn may safely call back into the hub.
+func (h *Hub) Range(fn func(*Client) bool) {
+	for _, client := range h.snapshot() {
+		if !fn(client) {
+			return
+		}
+	}
+}
+
+func (h *Hub) snapshot() []*Client {
+	clients := make([]*Client, 0, h.Len())
+	for _, shard := range h.shards {
+		clients = shard.appendClients(clients)
+	}
+	return clients
+}
+
+func (s *hubShard) appendClients(clients []*Client) []*Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// fanOut calls send for every registered client, counting the clients it
+// succeeded and failed for. From parallelFanOut clients on, each shard is
+// walked by its broadcast worker; a shard whose worker is busy with another
+// broadcast is walked by the caller instead, so concurrent and nested
+// broadcasts never wait on each other. fanOut returns once every shard is
+// done.
+func (h *Hub) fanOut(send func(*Client) error) BroadcastResult {
+	if len(h.shards) == 1 || h.Len() < parallelFanOut {
+		var result BroadcastResult
+		for _, shard := range h.shards {
+			result.add(shard.fanOut(send))
+		}
+		return result
+	}
+
+	results := make([]BroadcastResult, len(h.shards))
+	var done sync.WaitGroup
+	var busy []int
+	for i, shard := range h.shards {
+		done.Add(1)
+		if !shard.offer(shardJob{send: send, result: &results[i], done: &done}) {
+			busy = append(busy, i)
+		}
+	}
+	for _, i := range busy {
+		results[i] = h.shards[i].fanOut(send)
+		done.Done()
+	}
+	done.Wait()
+
+	var result BroadcastResult
+	for _, r := range results {
+		result.add(r)
+	}
+	return result
+}
+
+// offer hands job to the shard's worker, starting one when the shard has
+// none. It reports false, leaving job to the caller, when the worker is busy.
+func (s *hubShard) offer(job shardJob) bool {
+	select {
+	case s.jobs <- job:
+		return true
+	default:
+	}
+
+	s.workerMu.Lock()
+	defer s.workerMu.Unlock()
+	if s.hasWorker {
+		return false
+	}
+	s.hasWorker = true
+	go s.work(job)
+	return true
+}
+
+func (s *hubShard) work(job shardJob) {
+	idle := time.NewTimer(shardWorkerIdle)
+	defer idle.Stop()
+	for {
+		*job.result = s.fanOut(job.send)
+		job.done.Done()
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(shardWorkerIdle)
+		select {
+		case job = <-s.jobs:
+		case <-idle.C:
+			s.workerMu.Lock()
+			s.hasWorker = false
+			s.workerMu.Unlock()
+			return
+		}
+	}
+}
+
+// errNotMatched is returned by a fan-out's send for clients it skips on
+// purpose, which are counted as neither delivered nor dropped.
+var errNotMatched = errors.New("client not matched")
+
+func (s *hubShard) fanOut(send func(*Client) error) BroadcastResult {
+	var result BroadcastResult
+	for _, client := range s.appendClients(nil) {
+		switch send(client) {
+		case nil:
+			result.Delivered++
+		case errNotMatched:
+		default:
+			result.Dropped++
+		}
+	}
+	return result
+}
+
+// BroadcastResult summarises a fan-out to several clients. Delivered counts
+// clients the message was queued for; Dropped counts clients whose send
+// queue was full (or that had already disconnected) and were skipped.
+// Matched, set by BroadcastFunc only, counts the clients its predicate
+// selected.
+type BroadcastResult struct {
+	Matched   int `json:"matched,omitempty"`
+	Delivered int `json:"delivered"`
+	Dropped   int `json:"dropped"`
+}
+
+func (r *BroadcastResult) add(other BroadcastResult) {
+	r.Matched += other.Matched
+	r.Delivered += other.Delivered
+	r.Dropped += other.Dropped
+}
+
+// Broadcast queues data as a text frame for every registered client. It never
+// blocks on a slow client: clients with a full send queue are skipped and
+// counted in the result's Dropped field. With a bridge, the clients of other
+// nodes get it too.
+func (h *Hub) Broadcast(data []byte) BroadcastResult {
+	result := h.broadcastLocal(data)
+	h.forward(BridgeTopic, bridgeMessage{Kind: bridgeBroadcast, Data: data})
+	return result
+}
+
+func (h *Hub) broadcastLocal(data []byte) BroadcastResult {
+	return h.fanOut(func(client *Client) error {
+		return client.enqueue(outbound{data: data, broadcast: true})
+	})
+}
+
+// BroadcastFunc queues data as a text frame for every registered client for
+// which pred returns true, such as those whose metadata or connection time
+// match. pred is evaluated against a snapshot of the clients with no hub
+// lock held, so it may read client state or call back into the hub; on
+// large hubs it is called from several goroutines at once. Unlike
+// Broadcast, BroadcastFunc reaches local clients only, as pred cannot run
+// on other nodes.
+func (h *Hub) BroadcastFunc(pred func(*Client) bool, data []byte) BroadcastResult {
+	result := h.fanOut(func(client *Client) error {
+		if !pred(client) {
+			return errNotMatched
+		}
+		return client.enqueue(outbound{data: data, broadcast: true})
+	})
+	result.Matched = result.Delivered + result.Dropped
+	return result
+}
+
+// BroadcastJSON marshals v once and broadcasts it to every registered client.
+func (h *Hub) BroadcastJSON(v any) (BroadcastResult, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return BroadcastResult{}, err
+	}
+	return h.Broadcast(data), nil
+}
+
+// SendTo queues data as a text frame for every connection registered under
+// id. It returns ErrClientNotConnected when id has no connections, so callers
+// can fall back to persisting an envelope for later delivery. If the message
+// could not be queued for any connection the last enqueue error, such as
+// ErrSendQueueFull, is returned. With a bridge, a message for an identity
+// with no local connection is forwarded to the other nodes instead, and only
+// a failure to forward it is returned: for a DirectBridge, that includes
+// ErrClientNotConnected when no node has the identity.
+func (h *Hub) SendTo(id Identity, data []byte) error {
+	err := h.sendTo(id, func(c *Client) error {
+		return c.enqueue(outbound{data: data})
+	})
+	return h.forwardUnreachable(id, bridgeMessage{Kind: bridgeSend, ClientID: id, Data: data}, err)
+}
+
+// SendEnvelope queues envelope for every connection of envelope.ClientID.
+// The envelope stays pending until a client acknowledges it with an ack
+// frame, at which point the handler's persister confirms delivery; with a
+// bridge, envelopes for identities connected to another node are forwarded
+// to it as SendTo does, and it is that node's persister that confirms them.
+func (h *Hub) SendEnvelope(envelope Envelope) error {
+	err := h.sendTo(envelope.ClientID, func(c *Client) error {
+		return c.SendEnvelope(envelope)
+	})
+	return h.forwardUnreachable(envelope.ClientID, bridgeMessage{Kind: bridgeEnvelope, Envelope: &envelope}, err)
+}
+
+// sendTo calls send for every connection of id. It succeeds when at least
+// one connection accepted the message.
+func (h *Hub) sendTo(id Identity, send func(*Client) error) error {
+	clients := h.Connections(id)
+	if len(clients) == 0 {
+		return ErrClientNotConnected
+	}
+
+	var lastErr error
+	queued := false
+	for _, client := range clients {
+		if err := send(client); err != nil {
+			lastErr = err
+		} else {
+			queued = true
+		}
+	}
+	if queued {
+		return nil
+	}
+	return lastErr
+}
+
+// Disconnect closes every connection registered under id with the given close
+// code and reason, waiting briefly for each closing handshake. The handler's
+// disconnect hook fires for each connection as usual. It returns
+// ErrClientNotConnected when id has no connections.
+func (h *Hub) Disconnect(id Identity, code int, reason string) error {
+	clients := h.Connections(id)
+	if len(clients) == 0 {
+		return ErrClientNotConnected
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.disconnect(DisconnectKicked, code, reason)
+		}(client)
+	}
+	wg.Wait()
+	return nil
+}
+
+// DisconnectClient closes a single connection, leaving any other connections
+// for the same identity open. It returns ErrClientNotConnected when client is
+// not registered.
+func (h *Hub) DisconnectClient(client *Client, code int, reason string) error {
+	if !h.registered(client) {
+		return ErrClientNotConnected
+	}
+
+	client.disconnect(DisconnectKicked, code, reason)
+	return nil
+}
@@ -1,13 +1,46 @@
 package ws
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
 	"github.com/google/uuid"
 )
 
+// Identity identifies a client or an envelope. It is a UUID, encoded in
+// JSON, text and map keys in its canonical string form.
 type Identity uuid.UUID
 
+// NewIdentity returns a new identity. Identities are version 7 UUIDs, which
+// begin with their creation time, so those made by one process sort, with
+// Compare, in the order they were made.
 func NewIdentity() Identity {
-	return Identity(uuid.New())
+	return Identity(uuid.Must(uuid.NewV7()))
+}
+
+// DefaultIdentityNamespace is the namespace for IdentityFromString when
+// external IDs come from a single source. Its value is fixed, so identities
+// derived with it are stable across processes and releases.
+var DefaultIdentityNamespace = uuid.MustParse("f988c574-1143-471e-ae8d-f473df0d1811")
+
+// IdentityFromString derives the identity for externalID, such as a user ID
+// from an auth provider, as a name-based (version 5) UUID in namespace. The
+// same inputs always give the same identity, so no lookup table is needed,
+// and the same externalID in different namespaces gives unrelated
+// identities. Use a namespace per identity provider to keep their IDs apart.
+func IdentityFromString(namespace uuid.UUID, externalID string) Identity {
+	return Identity(uuid.NewSHA1(namespace, []byte(externalID)))
+}
+
+// ParseIdentity parses an identity in any form uuid.Parse accepts, such as
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8".
+func ParseIdentity(s string) (Identity, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return Identity{}, fmt.Errorf("ws: invalid identity %q: %w", s, err)
+	}
+	return Identity(id), nil
 }
 
 func (i Identity) String() string {
@@ -17,3 +50,63 @@ func (i Identity) String() string {
 func (i Identity) UUID() uuid.UUID {
 	return uuid.UUID(i)
 }
+
+// Compare returns -1, 0 or +1 as i sorts before, equal to or after j. The
+// order is that of the canonical string forms.
+func (i Identity) Compare(j Identity) int {
+	return bytes.Compare(i[:], j[:])
+}
+
+// IsZero reports whether i is the zero (nil UUID) identity.
+func (i Identity) IsZero() bool {
+	return uuid.UUID(i) == uuid.Nil
+}
+
+// MarshalText encodes i in its canonical string form, which also makes
+// identities usable as JSON object keys.
+func (i Identity) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText parses an identity as ParseIdentity does. Empty input
+// decodes to the zero identity.
+func (i *Identity) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*i = Identity{}
+		return nil
+	}
+	id, err := ParseIdentity(string(text))
+	if err != nil {
+		return err
+	}
+	*i = id
+	return nil
+}
+
+// MarshalJSON encodes i as a JSON string.
+func (i Identity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.String())
+}
+
+// UnmarshalJSON decodes a JSON string as UnmarshalText does. For data
+// written before identities had a JSON form, it also accepts the array of
+// 16 byte values encoding/json used to produce.
+func (i *Identity) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '[' {
+		var legacy [16]byte
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return fmt.Errorf("ws: invalid identity %s: %w", data, err)
+		}
+		*i = Identity(legacy)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("ws: invalid identity %s: %w", data, err)
+	}
+	return i.UnmarshalText([]byte(s))
+}
@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Value implements driver.Valuer, storing the identity in its canonical
+// string form, which suits text columns and Postgres uuid columns alike.
+// Convert to BinaryIdentity to store the 16 raw bytes instead.
+func (i Identity) Value() (driver.Value, error) {
+	return i.String(), nil
+}
+
+// Scan implements sql.Scanner. It accepts the string form as a string or
+// []byte, and the 16-byte binary form. NULL scans as the zero identity; use
+// RequireIdentity to treat it as an error.
+func (i *Identity) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*i = Identity{}
+		return nil
+	case string:
+		return i.UnmarshalText([]byte(v))
+	case []byte:
+		if len(v) == 16 {
+			copy(i[:], v)
+			return nil
+		}
+		return i.UnmarshalText(v)
+	default:
+		return fmt.Errorf("ws: cannot scan %T into Identity", src)
+	}
+}
+
+// BinaryIdentity stores an Identity as its 16 raw bytes, for BLOB, BYTEA or
+// BINARY(16) columns:
+//
+//	db.Exec("INSERT INTO clients (id) VALUES (?)", ws.BinaryIdentity(id))
+//
+// Identity.Scan reads the binary form back directly.
+type BinaryIdentity Identity
+
+// Value implements driver.Valuer.
+func (b BinaryIdentity) Value() (driver.Value, error) {
+	return b[:], nil
+}
+
+// RequireIdentity returns a sql.Scanner that scans into id like
+// Identity.Scan, but fails with ErrNullIdentity on NULL instead of
+// yielding the zero identity:
+//
+//	rows.Scan(ws.RequireIdentity(&id))
+func RequireIdentity(id *Identity) sql.Scanner {
+	return requiredIdentity{id}
+}
+
+type requiredIdentity struct {
+	id *Identity
+}
+
+func (r requiredIdentity) Scan(src any) error {
+	if src == nil {
+		return ErrNullIdentity
+	}
+	return r.id.Scan(src)
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithIdleTimeout closes connections that send no data frame for d, with
+// close code 1000 (Normal Closure) and reason "idle timeout". The disconnect
+// hook gets a DisconnectReason of kind DisconnectTimeout. Pings, pongs and
+// heartbeat echoes are not activity, so a client that answers them but
+// sends nothing else still goes idle. Zero, the default, means connections
+// may stay idle forever.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithIdleIncludesSends counts data frames written to a client as activity
+// for the idle timeout too, so that connections the server keeps pushing
+// to stay open however quiet the client is.
+func WithIdleIncludesSends() Option {
+	return func(c *config) {
+		c.idleIncludesSends = true
+	}
+}
+
+// touch records activity on the connection for the idle timeout.
+func (c *Client) touch() {
+	c.lastActive.Store(c.handler.config.clock.Now().UnixNano())
+}
+
+// watchIdle starts the idle check, when the handler has an idle timeout.
+// Rather than resetting a timer on every frame, the check reschedules
+// itself for when the connection would go idle given its latest activity.
+func (c *Client) watchIdle() {
+	d := c.handler.config.idleTimeout
+	if d <= 0 {
+		return
+	}
+	c.touch()
+	c.scheduleIdle(d)
+}
+
+func (c *Client) scheduleIdle(after time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.idleTimer = c.handler.config.clock.AfterFunc(after, c.checkIdle)
+}
+
+// checkIdle closes the connection if it has been idle for the whole
+// timeout, and otherwise checks again once it could be.
+func (c *Client) checkIdle() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	d := c.handler.config.idleTimeout
+	idle := c.handler.config.clock.Now().Sub(time.Unix(0, c.lastActive.Load()))
+	if idle < d {
+		c.scheduleIdle(d - idle)
+		return
+	}
+	c.disconnect(DisconnectTimeout, websocket.CloseNormalClosure, "idle timeout")
+}
+
+// stopIdleWatch cancels the pending idle check, if any.
+func (c *Client) stopIdleWatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+}
@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Events logged by a handler configured with WithLogger.
+const (
+	LogConnectionOpened = "connection_opened"
+	LogConnectionClosed = "connection_closed"
+	LogHandlerError     = "handler_error"
+	LogHandlerLate      = "handler_late"
+	LogUpgradeRejected  = "upgrade_rejected"
+)
+
+// Each logging helper checks for a logger before building any attributes,
+// so logging costs nothing when none is configured.
+
+func (h *WebsocketHandler) logOpened(client *Client) {
+	if h.config.logger == nil {
+		return
+	}
+	h.config.logger.LogAttrs(context.Background(), slog.LevelInfo, LogConnectionOpened,
+		slog.String("client_id", client.ID.String()),
+		slog.String("remote_ip", client.RemoteIP().String()),
+		slog.String("subprotocol", client.Subprotocol()),
+	)
+}
+
+// logClosed logs at Info for clean closes and at Warn for connections that
+// dropped or were closed for misbehaving.
+func (h *WebsocketHandler) logClosed(client *Client, err error) {
+	if h.config.logger == nil {
+		return
+	}
+	level := slog.LevelWarn
+	if cleanClose(err) {
+		level = slog.LevelInfo
+	}
+	h.config.logger.LogAttrs(context.Background(), level, LogConnectionClosed,
+		slog.String("client_id", client.ID.String()),
+		slog.String("remote_ip", client.RemoteIP().String()),
+		slog.Any("reason", err),
+		slog.Duration("duration", time.Since(client.Connected)),
+	)
+}
+
+func cleanClose(err error) bool {
+	var reason *DisconnectReason
+	if errors.As(err, &reason) && reason.IsNormal() {
+		return true
+	}
+	return err == nil || errors.Is(err, io.EOF)
+}
+
+// logHandlerError logs an error returned by the message handler, with the
+// stack when it panicked. msgType is empty for frames that were not
+// envelopes.
+func (h *WebsocketHandler) logHandlerError(client *Client, msgType string, err error) {
+	if h.config.logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("client_id", client.ID.String()),
+		slog.String("remote_ip", client.RemoteIP().String()),
+		slog.String("msg_type", msgType),
+		slog.Any("error", err),
+	}
+	var panicked *PanicError
+	if errors.As(err, &panicked) {
+		attrs = append(attrs, slog.String("stack", string(panicked.Stack)))
+	}
+	h.config.logger.LogAttrs(context.Background(), slog.LevelError, LogHandlerError, attrs...)
+}
+
+// logLateHandler logs a handler returning after its message timed out,
+// with how long it took in all and what it returned.
+func (h *WebsocketHandler) logLateHandler(client *Client, msgType string, elapsed time.Duration, err error) {
+	if h.config.logger == nil {
+		return
+	}
+	h.config.logger.LogAttrs(context.Background(), slog.LevelWarn, LogHandlerLate,
+		slog.String("client_id", client.ID.String()),
+		slog.String("remote_ip", client.RemoteIP().String()),
+		slog.String("msg_type", msgType),
+		slog.Duration("duration", elapsed),
+		slog.Any("error", err),
+	)
+}
+
+// logRejected logs a refused upgrade. status is zero when the upgrader
+// chose it.
+func (h *WebsocketHandler) logRejected(r *http.Request, status int, err error) {
+	if h.config.logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("remote_ip", h.clientIP(r).String()),
+		slog.Any("cause", err),
+	}
+	if status != 0 {
+		attrs = append(attrs, slog.Int("status", status))
+	}
+	h.config.logger.LogAttrs(r.Context(), slog.LevelWarn, LogUpgradeRejected, attrs...)
+}
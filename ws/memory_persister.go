@@ -0,0 +1,361 @@
+package ws
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryPersister is an in-memory EnvelopePersister, also implementing
+// UndeliveredFetcher, UndeliveredCounter, EnvelopeHistoryFetcher,
+// RoomHistoryFetcher, ExpiredPurger, DeliveredPurger, EnvelopeScheduler,
+// ReadMarker and EnvelopeChecker. It is safe for concurrent use. Envelopes
+// are lost when the process exits, so it suits development, tests and
+// deployments that can tolerate that.
+type MemoryPersister struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	byID     map[Identity]*list.Element
+	schedule map[Identity]scheduledEnvelope
+}
+
+type scheduledEnvelope struct {
+	envelope Envelope
+	at       time.Time
+
+	// claimed is when the claim FetchDue took lapses, zero when unclaimed.
+	claimed time.Time
+}
+
+// MemoryPersisterOption configures a MemoryPersister.
+type MemoryPersisterOption func(*MemoryPersister)
+
+// WithMemoryCapacity caps the number of retained envelopes. Once the cap is
+// reached, saving evicts the least recently saved or fetched envelope. Zero,
+// the default, means no cap.
+func WithMemoryCapacity(n int) MemoryPersisterOption {
+	return func(p *MemoryPersister) {
+		p.capacity = n
+	}
+}
+
+func NewMemoryPersister(opts ...MemoryPersisterOption) *MemoryPersister {
+	p := &MemoryPersister{
+		order:    list.New(),
+		byID:     make(map[Identity]*list.Element),
+		schedule: make(map[Identity]scheduledEnvelope),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// SaveEnvelope stores e, replacing any envelope with the same ID.
+func (p *MemoryPersister) SaveEnvelope(e Envelope) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if elem, ok := p.byID[e.ID]; ok {
+		elem.Value = e
+		p.order.MoveToFront(elem)
+		return nil
+	}
+	p.byID[e.ID] = p.order.PushFront(e)
+	for p.capacity > 0 && p.order.Len() > p.capacity {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.byID, oldest.Value.(Envelope).ID)
+	}
+	return nil
+}
+
+// ConfirmDelivery marks the envelope as delivered. It returns
+// ErrUnknownEnvelope when no envelope with that ID is stored for clientID.
+func (p *MemoryPersister) ConfirmDelivery(envelopeID Identity, clientID Identity) error {
+	return p.confirm(envelopeID, clientID, time.Now())
+}
+
+// ConfirmEnvelope marks e as delivered at the time in its Delivered field,
+// or now when it has none.
+func (p *MemoryPersister) ConfirmEnvelope(e Envelope) error {
+	delivered := time.Now()
+	if e.Delivered != nil {
+		delivered = *e.Delivered
+	}
+	return p.confirm(e.ID, e.ClientID, delivered)
+}
+
+func (p *MemoryPersister) confirm(envelopeID Identity, clientID Identity, delivered time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.byID[envelopeID]
+	if !ok {
+		return ErrUnknownEnvelope
+	}
+	e := elem.Value.(Envelope)
+	if e.ClientID != clientID {
+		return ErrUnknownEnvelope
+	}
+	if e.Delivered == nil {
+		e.MarkDelivered(delivered)
+		elem.Value = e
+	}
+	return nil
+}
+
+// MarkRead records that clientID read the envelope at at. It returns
+// ErrUnknownEnvelope when no envelope with that ID is stored for clientID.
+func (p *MemoryPersister) MarkRead(envelopeID, clientID Identity, at time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.byID[envelopeID]
+	if !ok {
+		return ErrUnknownEnvelope
+	}
+	e := elem.Value.(Envelope)
+	if e.ClientID != clientID {
+		return ErrUnknownEnvelope
+	}
+	if e.ReadAt == nil {
+		e.MarkRead(at)
+		elem.Value = e
+	}
+	return nil
+}
+
+// FetchUndelivered returns the outbound envelopes for clientID that have not
+// been confirmed, oldest first.
+func (p *MemoryPersister) FetchUndelivered(clientID Identity) ([]Envelope, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var envelopes []Envelope
+	var touched []*list.Element
+	for elem := p.order.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(Envelope)
+		if e.ClientID == clientID && !e.Inbound && e.Delivered == nil {
+			envelopes = append(envelopes, e)
+			touched = append(touched, elem)
+		}
+	}
+	for _, elem := range touched {
+		p.order.MoveToFront(elem)
+	}
+
+	sort.SliceStable(envelopes, func(i, j int) bool {
+		return envelopes[i].Timestamp.Before(envelopes[j].Timestamp)
+	})
+	return envelopes, nil
+}
+
+// CountUndelivered returns how many outbound envelopes for clientID, other
+// than room envelopes, have not been confirmed.
+func (p *MemoryPersister) CountUndelivered(clientID Identity) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for elem := p.order.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(Envelope)
+		if e.ClientID == clientID && !e.Inbound && e.Room == "" && e.Delivered == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// FetchEnvelopes returns the last limit envelopes to or from clientID,
+// other than room envelopes, that sort before the envelope before by
+// Timestamp and then ID, oldest first. A zero before starts from the newest
+// envelope, and a limit of zero returns all of them. It returns
+// ErrUnknownEnvelope when before names no envelope of clientID's.
+func (p *MemoryPersister) FetchEnvelopes(clientID Identity, before Identity, limit int) ([]Envelope, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var elems []*list.Element
+	for elem := p.order.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(Envelope)
+		if e.ClientID == clientID && e.Room == "" {
+			elems = append(elems, elem)
+		}
+	}
+	sort.Slice(elems, func(i, j int) bool {
+		a, b := elems[i].Value.(Envelope), elems[j].Value.(Envelope)
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return a.ID.Compare(b.ID) < 0
+	})
+
+	if !before.IsZero() {
+		end := -1
+		for i, elem := range elems {
+			if elem.Value.(Envelope).ID == before {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, ErrUnknownEnvelope
+		}
+		elems = elems[:end]
+	}
+	if limit > 0 && len(elems) > limit {
+		elems = elems[len(elems)-limit:]
+	}
+
+	envelopes := make([]Envelope, len(elems))
+	for i, elem := range elems {
+		envelopes[i] = elem.Value.(Envelope)
+		p.order.MoveToFront(elem)
+	}
+	return envelopes, nil
+}
+
+// FetchRoomHistory returns the most recent limit envelopes broadcast to
+// room before before, oldest first. A limit of zero returns all of them.
+func (p *MemoryPersister) FetchRoomHistory(room string, limit int, before time.Time) ([]Envelope, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var envelopes []Envelope
+	for elem := p.order.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(Envelope)
+		if e.Room == room && !e.Inbound && e.Timestamp.Before(before) {
+			envelopes = append(envelopes, e)
+		}
+	}
+
+	sort.SliceStable(envelopes, func(i, j int) bool {
+		return envelopes[i].Timestamp.Before(envelopes[j].Timestamp)
+	})
+	if limit > 0 && len(envelopes) > limit {
+		envelopes = envelopes[len(envelopes)-limit:]
+	}
+	return envelopes, nil
+}
+
+// PurgeExpired deletes undelivered envelopes whose ExpiresAt is before
+// before, returning how many were removed.
+func (p *MemoryPersister) PurgeExpired(before time.Time) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	purged := 0
+	for elem := p.order.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(Envelope)
+		if e.Delivered == nil && e.ExpiresAt != nil && e.ExpiresAt.Before(before) {
+			p.order.Remove(elem)
+			delete(p.byID, e.ID)
+			purged++
+		}
+		elem = next
+	}
+	return purged, nil
+}
+
+// PurgeDelivered deletes envelopes confirmed as delivered before before,
+// returning how many were removed.
+func (p *MemoryPersister) PurgeDelivered(before time.Time) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	purged := 0
+	for elem := p.order.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(Envelope)
+		if e.Delivered != nil && e.Delivered.Before(before) {
+			p.order.Remove(elem)
+			delete(p.byID, e.ID)
+			purged++
+		}
+		elem = next
+	}
+	return purged, nil
+}
+
+// Exists reports whether an envelope with id is stored.
+func (p *MemoryPersister) Exists(id Identity) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.byID[id]
+	return ok, nil
+}
+
+// SaveScheduled stores e to be sent at at, replacing any scheduled envelope
+// with the same ID and dropping its claim. Scheduled envelopes do not count
+// towards the capacity.
+func (p *MemoryPersister) SaveScheduled(e Envelope, at time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.schedule[e.ID] = scheduledEnvelope{envelope: e, at: at}
+	return nil
+}
+
+// FetchDue claims for lease and returns the scheduled envelopes due at or
+// before now that are not claimed, earliest first.
+func (p *MemoryPersister) FetchDue(now time.Time, lease time.Duration) ([]Envelope, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var due []scheduledEnvelope
+	for id, s := range p.schedule {
+		if !s.at.After(now) && !s.claimed.After(now) {
+			s.claimed = now.Add(lease)
+			p.schedule[id] = s
+			due = append(due, s)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	envelopes := make([]Envelope, len(due))
+	for i, s := range due {
+		envelopes[i] = s.envelope
+	}
+	return envelopes, nil
+}
+
+// ConfirmScheduled removes the claimed envelope with envelopeID once it has
+// been sent. It returns ErrUnknownEnvelope when none is claimed.
+func (p *MemoryPersister) ConfirmScheduled(envelopeID Identity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s, ok := p.schedule[envelopeID]; !ok || s.claimed.IsZero() {
+		return ErrUnknownEnvelope
+	}
+	delete(p.schedule, envelopeID)
+	return nil
+}
+
+// CancelScheduled removes the scheduled envelope with envelopeID. It returns
+// ErrUnknownEnvelope when none is waiting unclaimed.
+func (p *MemoryPersister) CancelScheduled(envelopeID Identity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s, ok := p.schedule[envelopeID]; !ok || !s.claimed.IsZero() {
+		return ErrUnknownEnvelope
+	}
+	delete(p.schedule, envelopeID)
+	return nil
+}
+
+// Len returns the number of stored envelopes, not counting scheduled ones.
+func (p *MemoryPersister) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.order.Len()
+}
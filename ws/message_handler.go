@@ -1,5 +1,58 @@
 package ws
 
+import "context"
+
+// MessageHandler handles the frames read from clients. Unless the handler
+// was built with WithReadBufferPooling(false), data is only valid until
+// Handle returns; keep a copy made with Client.Retain instead.
 type MessageHandler interface {
 	Handle(client *Client, data []byte) error
 }
+
+// MessageHandlerFunc adapts a function to the MessageHandler interface.
+type MessageHandlerFunc func(client *Client, data []byte) error
+
+func (f MessageHandlerFunc) Handle(client *Client, data []byte) error {
+	return f(client, data)
+}
+
+// BinaryMessageHandler is implemented by message handlers that want binary
+// frames delivered separately. When the handler implements it, binary frames
+// go to HandleBinary; otherwise they are passed to Handle like text frames.
+// Binary frames are never parsed or persisted as envelopes. data is only
+// valid until HandleBinary returns, as with Handle.
+type BinaryMessageHandler interface {
+	HandleBinary(client *Client, data []byte) error
+}
+
+// ContextMessageHandler is implemented by message handlers that take the
+// message's context as an argument. When the handler implements it,
+// frames go to HandleContext instead of Handle, with a context that is the
+// client's Context, extended by the message's span when tracing, so work
+// started for a message is cancelled when its connection ends. data is
+// only valid until HandleContext returns, as with Handle.
+type ContextMessageHandler interface {
+	HandleContext(ctx context.Context, client *Client, data []byte) error
+}
+
+// ContextMessageHandlerFunc adapts a function to both ContextMessageHandler
+// and MessageHandler. Called through Handle, as middleware does, it gets
+// client.TraceContext().
+type ContextMessageHandlerFunc func(ctx context.Context, client *Client, data []byte) error
+
+func (f ContextMessageHandlerFunc) HandleContext(ctx context.Context, client *Client, data []byte) error {
+	return f(ctx, client, data)
+}
+
+func (f ContextMessageHandlerFunc) Handle(client *Client, data []byte) error {
+	return f(client.TraceContext(), client, data)
+}
+
+// handleMessage passes data to the message handler, through HandleContext
+// with ctx when it has one.
+func (h *WebsocketHandler) handleMessage(ctx context.Context, client *Client, data []byte) error {
+	if ch, ok := h.MessageHandler.(ContextMessageHandler); ok {
+		return ch.HandleContext(ctx, client, data)
+	}
+	return h.MessageHandler.Handle(client, data)
+}
@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"log"
+	"time"
+)
+
+// Middleware wraps the handling of inbound frames. It receives each frame's
+// raw bytes after persistence, and may observe it, reject it by returning an
+// error without calling next, or run code around next. The frame that
+// reaches the configured MessageHandler is always the one read from the
+// connection, so middleware must not modify data, nor keep it once next
+// returns without copying it with Client.Retain.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Use appends middleware to the inbound chain. Middleware runs in the order
+// it is registered, the first being outermost. Use must be called before the
+// handler starts serving connections.
+func (h *WebsocketHandler) Use(mw ...Middleware) {
+	h.middleware = append(h.middleware, mw...)
+}
+
+// dispatch runs the middleware chain for a frame, ending in deliver. A
+// panic anywhere in the chain is recovered and returned as a *PanicError,
+// so it never takes down the connection's read loop.
+func (h *WebsocketHandler) dispatch(client *Client, data []byte, deliver func() error) (err error) {
+	defer recoverPanic(&err)
+
+	if len(h.middleware) == 0 {
+		return deliver()
+	}
+
+	var next MessageHandler = MessageHandlerFunc(func(*Client, []byte) error {
+		return deliver()
+	})
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		next = h.middleware[i](next)
+	}
+	return next.Handle(client, data)
+}
+
+// Recovery returns middleware that turns a panic in the rest of the chain
+// into a *PanicError, keeping the connection alive. The handler recovers
+// panics around the whole chain anyway; Recovery lets middleware registered
+// before it see the error.
+func Recovery() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(client *Client, data []byte) (err error) {
+			defer recoverPanic(&err)
+			return next.Handle(client, data)
+		})
+	}
+}
+
+// Logging returns middleware that logs every frame's client, size, handling
+// time and error to logger, or to the standard logger when logger is nil.
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(client *Client, data []byte) error {
+			start := time.Now()
+			err := next.Handle(client, data)
+			logger.Printf("ws: client=%s bytes=%d duration=%s err=%v", client.ID, len(data), time.Since(start), err)
+			return err
+		})
+	}
+}
+
+// OutboundInterceptor transforms or observes a data frame in the write pump
+// just before it is written. Returning data unchanged passes the frame
+// through; returning an error drops it and reports the error to the
+// handler's outbound error hook.
+type OutboundInterceptor func(client *Client, data []byte) ([]byte, error)
+
+// UseOutbound appends interceptors applied, in registration order, to every
+// data frame sent to a client. Control frames are not intercepted.
+// UseOutbound must be called before the handler starts serving connections.
+func (h *WebsocketHandler) UseOutbound(interceptors ...OutboundInterceptor) {
+	h.outbound = append(h.outbound, interceptors...)
+}
+
+// intercept runs the handler's outbound interceptors over data. It reports
+// false when one of them rejected the frame.
+func (c *Client) intercept(data []byte) ([]byte, bool) {
+	if c.handler == nil {
+		return data, true
+	}
+
+	original := data
+	for _, interceptor := range c.handler.outbound {
+		var err error
+		if data, err = interceptor(c, data); err != nil {
+			if fn := c.handler.config.onOutboundError; fn != nil {
+				fn(c, original, err)
+			}
+			return nil, false
+		}
+	}
+	return data, true
+}
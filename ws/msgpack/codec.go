@@ -0,0 +1,174 @@
+// Package msgpack provides a MessagePack ws.Codec for clients that prefer
+// compact binary frames over JSON text.
+//
+//	handler := ws.NewWebSocketHandler(validator, messages, persister,
+//		ws.WithSubprotocolCodec(msgpack.Subprotocol, msgpack.Codec{}))
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Subprotocol is the websocket subprotocol name clients request to use Codec.
+const Subprotocol = "msgpack"
+
+// Codec encodes envelopes as MessagePack maps in binary frames. Identities
+// are encoded as 16-byte binaries and the JSON payload is re-encoded as a
+// native MessagePack value, so integers keep their full precision.
+type Codec struct{}
+
+type frame struct {
+	ID        []byte     `msgpack:"id,omitempty"`
+	ClientID  []byte     `msgpack:"client_id,omitempty"`
+	Type      string     `msgpack:"type"`
+	Payload   any        `msgpack:"payload,omitempty"`
+	Timestamp time.Time  `msgpack:"timestamp,omitempty"`
+	ReplyTo   []byte     `msgpack:"reply_to,omitempty"`
+	ExpiresAt *time.Time `msgpack:"expires_at,omitempty"`
+	Topic     string     `msgpack:"topic,omitempty"`
+	Room      string     `msgpack:"room,omitempty"`
+	History   bool       `msgpack:"history,omitempty"`
+	Ephemeral bool       `msgpack:"ephemeral,omitempty"`
+
+	TraceParent string `msgpack:"traceparent,omitempty"`
+	TraceState  string `msgpack:"tracestate,omitempty"`
+
+	Signature string `msgpack:"signature,omitempty"`
+	KeyID     string `msgpack:"kid,omitempty"`
+}
+
+func (Codec) Marshal(e ws.Envelope) ([]byte, int, error) {
+	payload, err := fromJSON(e.Payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	f := frame{
+		ID:        identityBytes(e.ID),
+		ClientID:  identityBytes(e.ClientID),
+		Type:      e.Type,
+		Payload:   payload,
+		Timestamp: e.Timestamp,
+		ExpiresAt: e.ExpiresAt,
+		Topic:     e.Topic,
+		Room:      e.Room,
+		History:   e.History,
+		Ephemeral: e.Ephemeral,
+
+		TraceParent: e.TraceParent,
+		TraceState:  e.TraceState,
+
+		Signature: e.Signature,
+		KeyID:     e.KeyID,
+	}
+	if e.ReplyTo != nil {
+		f.ReplyTo = identityBytes(*e.ReplyTo)
+	}
+	data, err := msgpack.Marshal(f)
+	return data, websocket.BinaryMessage, err
+}
+
+func (Codec) Unmarshal(data []byte, messageType int) (ws.Envelope, error) {
+	if messageType != websocket.BinaryMessage {
+		return ws.Envelope{}, ws.ErrMalformedMessage
+	}
+	var f frame
+	if err := msgpack.Unmarshal(data, &f); err != nil {
+		return ws.Envelope{}, ws.ErrMalformedMessage
+	}
+
+	envelope := ws.Envelope{
+		ID:        parseIdentity(f.ID),
+		ClientID:  parseIdentity(f.ClientID),
+		Type:      f.Type,
+		Timestamp: f.Timestamp,
+		ExpiresAt: f.ExpiresAt,
+		Topic:     f.Topic,
+		Room:      f.Room,
+		History:   f.History,
+		Ephemeral: f.Ephemeral,
+
+		TraceParent: f.TraceParent,
+		TraceState:  f.TraceState,
+
+		Signature: f.Signature,
+		KeyID:     f.KeyID,
+	}
+	if f.Payload != nil {
+		payload, err := json.Marshal(f.Payload)
+		if err != nil {
+			return ws.Envelope{}, ws.ErrMalformedMessage
+		}
+		envelope.Payload = payload
+	}
+	if f.ReplyTo != nil {
+		replyTo := parseIdentity(f.ReplyTo)
+		envelope.ReplyTo = &replyTo
+	}
+	return envelope, nil
+}
+
+func identityBytes(id ws.Identity) []byte {
+	if id.IsZero() {
+		return nil
+	}
+	b := id.UUID()
+	return b[:]
+}
+
+// parseIdentity returns the zero Identity for missing or malformed IDs.
+func parseIdentity(b []byte) ws.Identity {
+	id, err := uuid.FromBytes(b)
+	if err != nil {
+		return ws.Identity{}
+	}
+	return ws.Identity(id)
+}
+
+// fromJSON decodes a JSON payload into plain Go values, keeping integers as
+// int64 or uint64 rather than float64.
+func fromJSON(data json.RawMessage) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = convertNumbers(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = convertNumbers(value)
+		}
+		return v
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
@@ -0,0 +1,173 @@
+package ws
+
+import (
+	"net/http"
+	"time"
+)
+
+// Observer receives a handler's lifecycle events, for metrics and tracing.
+// Every field is optional. Unlike WithOnConnect and the other single hooks,
+// any number of observers may be registered with WithObserver; each event is
+// passed to them in registration order.
+//
+// The hooks run synchronously on the goroutine where the event happens, such
+// as a client's read loop or write pump, so they must be cheap and must not
+// block. OnJoin and OnLeave run with the hub locked and must not call back
+// into it.
+type Observer struct {
+	// OnConnect runs once a connection has been registered, before the
+	// handler's connect hook, and OnDisconnect once it has been
+	// unregistered, with the error that ended it.
+	OnConnect    func(client *Client)
+	OnDisconnect func(client *Client, err error)
+
+	// OnReject runs for every refused upgrade, with the status sent, or
+	// zero when the upgrader chose it, and the cause.
+	OnReject func(r *http.Request, status int, err error)
+
+	// OnReceive runs for every data frame read from a client and OnSend
+	// for every data frame written to one, with its size in bytes before
+	// compression.
+	OnReceive func(client *Client, size int)
+	OnSend    func(client *Client, size int)
+
+	// OnHandled runs after the message handler returns, with the
+	// envelope's type, empty for frames that are not envelopes, how long
+	// handling took, middleware included, and the error returned.
+	OnHandled func(client *Client, msgType string, elapsed time.Duration, err error)
+
+	// OnDrop runs for every message discarded because a client's send
+	// queue was full.
+	OnDrop func(client *Client)
+
+	// OnError runs when a message fails outside the message handler: a
+	// frame refused with ErrRateLimited, ErrOverloaded or an error
+	// wrapping ErrMessageDenied, an envelope that could not be persisted,
+	// one whose signature failed with ErrInvalidSignature, or one that
+	// failed with ErrDeliveryFailed.
+	OnError func(client *Client, err error)
+
+	// OnJoin and OnLeave run as connections join and leave rooms,
+	// including the rooms a connection leaves by disconnecting.
+	OnJoin  func(roomName string, client *Client)
+	OnLeave func(roomName string, client *Client)
+}
+
+// WithObserver registers o for the handler's lifecycle events. It may be
+// given several times.
+func WithObserver(o Observer) Option {
+	return func(c *config) {
+		c.observers = append(c.observers, o)
+	}
+}
+
+// Besides notifying observers, the helpers keep the counters behind Stats
+// and ClientStats, so they run whether or not any observer is registered.
+
+func (h *WebsocketHandler) observeConnect(client *Client) {
+	h.open.Add(1)
+	h.opened.Add(1)
+	for _, o := range h.config.observers {
+		if o.OnConnect != nil {
+			o.OnConnect(client)
+		}
+	}
+}
+
+func (h *WebsocketHandler) observeDisconnect(client *Client, err error) {
+	h.open.Add(-1)
+	for _, o := range h.config.observers {
+		if o.OnDisconnect != nil {
+			o.OnDisconnect(client, err)
+		}
+	}
+}
+
+func (h *WebsocketHandler) observeReject(r *http.Request, status int, err error) {
+	h.rejected.Add(1)
+	for _, o := range h.config.observers {
+		if o.OnReject != nil {
+			o.OnReject(r, status, err)
+		}
+	}
+}
+
+func (h *WebsocketHandler) observeReceive(client *Client, size int) {
+	h.traffic.received(size)
+	client.traffic.received(size)
+	for _, o := range h.config.observers {
+		if o.OnReceive != nil {
+			o.OnReceive(client, size)
+		}
+	}
+}
+
+func (h *WebsocketHandler) observeHandled(client *Client, msgType string, start time.Time, err error) {
+	if len(h.config.observers) == 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	for _, o := range h.config.observers {
+		if o.OnHandled != nil {
+			o.OnHandled(client, msgType, elapsed, err)
+		}
+	}
+}
+
+func (h *WebsocketHandler) observeError(client *Client, err error) {
+	for _, o := range h.config.observers {
+		if o.OnError != nil {
+			o.OnError(client, err)
+		}
+	}
+}
+
+// The client hooks only count for clients that are not served by a handler,
+// such as those registered directly with a Hub.
+
+func (c *Client) observeSend(size int) {
+	c.traffic.sent(size)
+	if c.handler == nil {
+		return
+	}
+	if c.handler.config.idleIncludesSends {
+		c.touch()
+	}
+	c.handler.traffic.sent(size)
+	for _, o := range c.handler.config.observers {
+		if o.OnSend != nil {
+			o.OnSend(c, size)
+		}
+	}
+}
+
+func (c *Client) observeDrop() {
+	if c.handler == nil {
+		return
+	}
+	c.handler.dropped.Add(1)
+	for _, o := range c.handler.config.observers {
+		if o.OnDrop != nil {
+			o.OnDrop(c)
+		}
+	}
+}
+
+// The room hooks come from the hub, which has its handler's observers when
+// it is embedded in one. The lock must be held.
+
+func (h *Hub) observeJoinLocked(roomName string, client *Client) {
+	for _, o := range h.observers {
+		if o.OnJoin != nil {
+			o.OnJoin(roomName, client)
+		}
+	}
+}
+
+func (h *Hub) observeLeaveLocked(roomName string, client *Client) {
+	for _, o := range h.observers {
+		if o.OnLeave != nil {
+			o.OnLeave(roomName, client)
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultPongWait         = 60 * time.Second
+	defaultPingInterval     = (defaultPongWait * 9) / 10
+	defaultBufferSize       = 1024
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultSendQueueSize    = 256
+)
+
+// Config holds the connection tuning of a WebsocketHandler. Start from
+// DefaultConfig and pass the result with WithConfig to set everything at
+// once, or adjust single fields with the matching WithX options. Options
+// apply in order, so later ones override fields set by WithConfig.
+type Config struct {
+	// ReadBufferSize and WriteBufferSize are the sizes, in bytes, of each
+	// connection's I/O buffers. They do not limit message size. The
+	// defaults are 1024.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// HandshakeTimeout bounds the upgrade, from the start of session
+	// validation until the 101 response has been written. Upgrades taking
+	// longer are refused with 503 Service Unavailable and
+	// ErrHandshakeTimeout. The default is 10 seconds; zero means no limit.
+	HandshakeTimeout time.Duration
+
+	// SendQueueSize is how many outbound messages may be queued for a
+	// client before the slow consumer policy applies. The default is 256.
+	SendQueueSize int
+
+	// PingInterval is how often the server pings each client, and PongWait
+	// how long it waits for the pong. The defaults are 54 and 60 seconds.
+	PingInterval time.Duration
+	PongWait     time.Duration
+
+	// MaxMessageSize, MaxConnections and MaxConnectionsPerIP are described
+	// by the options of the same name. Zero, the default, means no limit.
+	MaxMessageSize      int64
+	MaxConnections      int
+	MaxConnectionsPerIP int
+}
+
+// DefaultConfig returns the configuration used when no options are given.
+func DefaultConfig() Config {
+	return Config{
+		ReadBufferSize:   defaultBufferSize,
+		WriteBufferSize:  defaultBufferSize,
+		HandshakeTimeout: defaultHandshakeTimeout,
+		SendQueueSize:    defaultSendQueueSize,
+		PingInterval:     defaultPingInterval,
+		PongWait:         defaultPongWait,
+	}
+}
+
+type config struct {
+	Config
+
+	clock Clock
+
+	baseContext context.Context
+	extractors  []ContextExtractor
+
+	bridge    Bridge
+	observers []Observer
+
+	upgrader *websocket.Upgrader
+
+	compression          bool
+	compressionThreshold int
+	compressionLevel     int
+
+	codec              Codec
+	codecs             map[string]Codec
+	subprotocols       []string
+	strictSubprotocols bool
+
+	slowConsumerPolicy  SlowConsumerPolicy
+	executionMode       ExecutionMode
+	handlerQueueSize    int
+	handlerTimeout      time.Duration
+	idleTimeout         time.Duration
+	idleIncludesSends   bool
+	heartbeatInterval   time.Duration
+	heartbeatMisses     int
+	maxQueuedBytes      int64
+	maxQueuedBytesTotal int64
+	hubShards           int
+	noPresenceEvents    bool
+	unpooledReads       bool
+	defaultEnvelopeTTL  time.Duration
+	redelivery          RedeliveryPolicy
+	persistRetry        PersistRetryPolicy
+	retentionInterval   time.Duration
+	retentionWindow     time.Duration
+	dedupeCacheSize     int
+	ipResolver          ClientIPResolver
+	checkOrigin         func(r *http.Request) bool
+	authorizer          MessageAuthorizer
+	roomAuthorizer      RoomAuthorizer
+	roomHistory         int
+	signingKeys         *KeyRing
+	verifyKeys          *KeyRing
+	onSignatureFailure  func(client *Client, envelope Envelope, err error)
+	expvarPrefix        string
+	replayBuffer        int
+	resumeSecret        []byte
+	resumeGrace         time.Duration
+	scheduleInterval    time.Duration
+	receiptSender       func(envelopeID, reader Identity) Identity
+	maxDenials          int
+	rateLimit           float64
+	rateBurst           int
+	rateLimitPolicy     RateLimitPolicy
+	rateLimitCloseAfter int
+	globalRate          float64
+	globalRateWait      time.Duration
+	floodLimit          float64
+	floodWindow         time.Duration
+
+	logger *slog.Logger
+	tracer trace.Tracer
+
+	onConnect        func(client *Client, session SessionInfo)
+	onResume         func(client *Client, session SessionInfo)
+	onDisconnect     func(client *Client, err error)
+	onReject         func(r *http.Request, status int, err error)
+	onDeliveryFailed func(envelope Envelope, err error)
+	onOutboundError  func(client *Client, data []byte, err error)
+	onPersistFailure func(envelope Envelope, err error)
+	onRetentionPurge func(result RetentionResult, err error)
+	onFlood          func(client *Client, rate float64)
+	onError          func(client *Client, msg []byte, err error) ErrorAction
+	panicAction      ErrorAction
+	timeoutAction    ErrorAction
+}
+
+func defaultConfig() config {
+	return config{
+		Config:          DefaultConfig(),
+		clock:           systemClock{},
+		baseContext:     context.Background(),
+		dedupeCacheSize: defaultDedupeCacheSize,
+
+		handlerQueueSize: defaultHandlerQueueSize,
+
+		rateLimitCloseAfter: defaultRateLimitCloseAfter,
+	}
+}
+
+// Option configures a WebsocketHandler.
+type Option func(*config)
+
+// WithConfig replaces the handler's whole Config. Buffer and queue sizes
+// and keepalive intervals left zero take their defaults; a zero
+// HandshakeTimeout or limit means none, so start from DefaultConfig to keep
+// those.
+func WithConfig(cfg Config) Option {
+	return func(c *config) {
+		defaults := DefaultConfig()
+		if cfg.ReadBufferSize == 0 {
+			cfg.ReadBufferSize = defaults.ReadBufferSize
+		}
+		if cfg.WriteBufferSize == 0 {
+			cfg.WriteBufferSize = defaults.WriteBufferSize
+		}
+		if cfg.SendQueueSize == 0 {
+			cfg.SendQueueSize = defaults.SendQueueSize
+		}
+		if cfg.PingInterval == 0 {
+			cfg.PingInterval = defaults.PingInterval
+		}
+		if cfg.PongWait == 0 {
+			cfg.PongWait = defaults.PongWait
+		}
+		c.Config = cfg
+	}
+}
+
+// WithReadBufferSize sets the size, in bytes, of each connection's read
+// buffer. The default is 1024.
+func WithReadBufferSize(n int) Option {
+	return func(c *config) {
+		c.ReadBufferSize = n
+	}
+}
+
+// WithWriteBufferSize sets the size, in bytes, of each connection's write
+// buffer. The default is 1024.
+func WithWriteBufferSize(n int) Option {
+	return func(c *config) {
+		c.WriteBufferSize = n
+	}
+}
+
+// WithHandshakeTimeout bounds the upgrade, session validation included.
+// Validators should honor the request's context, which is cancelled when
+// the timeout expires. The default is 10 seconds; zero means no limit.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.HandshakeTimeout = d
+	}
+}
+
+// WithSendQueueSize sets how many outbound messages may be queued for each
+// client before the slow consumer policy applies. The default is 256.
+func WithSendQueueSize(n int) Option {
+	return func(c *config) {
+		c.SendQueueSize = n
+	}
+}
+
+// WithHubShards sets how many shards the handler's hub spreads connections
+// over, as WithShards does for NewHub. The default is
+// runtime.GOMAXPROCS(0).
+func WithHubShards(n int) Option {
+	return func(c *config) {
+		c.hubShards = n
+	}
+}
+
+// WithPingInterval sets how often the server pings each client. It should be
+// shorter than the pong wait so a healthy client always answers in time.
+func WithPingInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.PingInterval = d
+	}
+}
+
+// WithPongWait sets how long the server waits for a pong before treating the
+// connection as dead and closing it.
+func WithPongWait(d time.Duration) Option {
+	return func(c *config) {
+		c.PongWait = d
+	}
+}
+
+// WithLogger logs connection lifecycle events, handler errors and refused
+// upgrades to logger as structured records: see LogConnectionOpened and its
+// siblings for the messages. Records carry the client Identity as
+// "client_id" and its address as "remote_ip". Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithErrorHandler registers fn to decide what happens when the message
+// handler returns an error for msg, which is only valid until fn returns.
+// Without one, or when fn returns
+// ErrorIgnore, the error is dropped and the connection keeps reading. Read
+// errors end the connection and are reported to the disconnect hook
+// instead.
+func WithErrorHandler(fn func(client *Client, msg []byte, err error) ErrorAction) Option {
+	return func(c *config) {
+		c.onError = fn
+	}
+}
+
+// WithPanicAction sets what happens after a panic in the message handler,
+// its middleware or the connect and disconnect hooks has been recovered,
+// when no error handler is registered to decide. The default, ErrorIgnore,
+// keeps the connection alive. Error handlers receive the panic as a
+// *PanicError carrying the stack, with a nil msg for hook panics.
+func WithPanicAction(action ErrorAction) Option {
+	return func(c *config) {
+		c.panicAction = action
+	}
+}
+
+// WithOnConnect registers fn to run after a client has been upgraded and
+// registered, before its first message is read.
+func WithOnConnect(fn func(client *Client, session SessionInfo)) Option {
+	return func(c *config) {
+		c.onConnect = fn
+	}
+}
+
+// WithOnDisconnect registers fn to run once the client's read loop has exited
+// and the client has been removed from the hub. err is a *DisconnectReason
+// classifying how the connection ended and wrapping the terminal read
+// error, e.g. a *websocket.CloseError for a close frame or io.EOF for a
+// dropped connection.
+func WithOnDisconnect(fn func(client *Client, err error)) Option {
+	return func(c *config) {
+		c.onDisconnect = fn
+	}
+}
+
+// WithMaxConnections caps the number of concurrent connections. Upgrades
+// beyond the cap are refused with 503 Service Unavailable and a Retry-After
+// header. Zero, the default, means no limit.
+func WithMaxConnections(n int) Option {
+	return func(c *config) {
+		c.MaxConnections = n
+	}
+}
+
+// WithOnReject registers fn to observe refused upgrades. It receives the HTTP
+// status sent to the client and the reason, e.g. ErrTooManyConnections.
+func WithOnReject(fn func(r *http.Request, status int, err error)) Option {
+	return func(c *config) {
+		c.onReject = fn
+	}
+}
+
+// WithMaxConnectionsPerIP caps the number of concurrent connections from a
+// single remote IP. Upgrades beyond the cap are refused with 429 Too Many
+// Requests. Zero, the default, means no limit.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(c *config) {
+		c.MaxConnectionsPerIP = n
+	}
+}
+
+// WithRealIPHeader names a header set by a trusted reverse proxy, such as
+// X-Forwarded-For or X-Real-IP, whose first entry is used as the client IP.
+// Only set this when every request passes through such a proxy, since
+// clients can otherwise spoof the header; WithTrustedProxies is safer.
+func WithRealIPHeader(name string) Option {
+	return func(c *config) {
+		c.ipResolver = TrustedProxyResolver{
+			Trusted: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+			Headers: []string{name},
+		}
+	}
+}
+
+// WithTrustedProxies resolves client IPs from X-Forwarded-For and X-Real-IP
+// when the direct peer is within one of proxies, ignoring the headers on
+// requests from anywhere else. See TrustedProxyResolver.
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(c *config) {
+		c.ipResolver = TrustedProxyResolver{Trusted: proxies}
+	}
+}
+
+// WithClientIPResolver replaces how the client IP is determined. The
+// default is the peer address of the connection.
+func WithClientIPResolver(resolver ClientIPResolver) Option {
+	return func(c *config) {
+		c.ipResolver = resolver
+	}
+}
+
+// WithAllowedOrigins accepts upgrades only from browsers whose Origin host
+// is in origins, e.g. "app.example.com", "localhost:3000" or "*.example.com"
+// for any subdomain. Requests without an Origin header, such as those from
+// non-browser clients, are accepted. Without this option or WithCheckOrigin
+// only same-origin requests are accepted.
+func WithAllowedOrigins(origins []string) Option {
+	return func(c *config) {
+		c.checkOrigin = allowOrigins(origins)
+	}
+}
+
+// WithCheckOrigin replaces the origin check with fn, which reports whether
+// the upgrade request may proceed. Refused upgrades get 403 Forbidden and
+// are reported to the rejection hook with ErrOriginNotAllowed.
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(c *config) {
+		c.checkOrigin = fn
+	}
+}
+
+// WithMessageAuthorizer checks every inbound envelope with a before it is
+// persisted or handled. Denied messages never reach the MessageHandler; the
+// client is sent an error frame with code "unauthorized" instead.
+func WithMessageAuthorizer(a MessageAuthorizer) Option {
+	return func(c *config) {
+		c.authorizer = a
+	}
+}
+
+// WithMaxDenials closes a connection with code 1008 (Policy Violation) once
+// more than n of its messages have been denied by a MessageAuthorizer. Zero,
+// the default, keeps the connection open however many are denied.
+func WithMaxDenials(n int) Option {
+	return func(c *config) {
+		c.maxDenials = n
+	}
+}
+
+// WithRateLimit limits each connection to rate inbound frames per second,
+// absorbing bursts of up to burst frames. Frames over the limit are handled
+// according to the rate-limit policy. Zero, the default, means no limit.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *config) {
+		c.rateLimit = rate
+		c.rateBurst = burst
+	}
+}
+
+// WithRateLimitPolicy sets what happens to frames over the rate limit. The
+// default is RateLimitDrop.
+func WithRateLimitPolicy(policy RateLimitPolicy) Option {
+	return func(c *config) {
+		c.rateLimitPolicy = policy
+	}
+}
+
+// WithRateLimitCloseAfter sets how many consecutive frames over the limit
+// RateLimitClose tolerates before closing the connection. The default is 10.
+func WithRateLimitCloseAfter(n int) Option {
+	return func(c *config) {
+		c.rateLimitCloseAfter = n
+	}
+}
+
+// WithGlobalRateLimit caps the frames persisted and handled across all
+// connections at rps per second, with bursts of up to one second's worth.
+// It applies after each connection's own rate limit. Frames over the cap
+// wait up to the global wait budget and are then shed with an "overloaded"
+// error frame; the shed count and rate are reported by Stats. Zero, the
+// default, means no cap.
+func WithGlobalRateLimit(rps float64) Option {
+	return func(c *config) {
+		c.globalRate = rps
+	}
+}
+
+// WithGlobalRateLimitWait sets how long a frame over the global rate limit
+// may wait for capacity before it is shed. The wait blocks that
+// connection's read loop. The default is zero: frames are shed straight
+// away.
+func WithGlobalRateLimitWait(d time.Duration) Option {
+	return func(c *config) {
+		c.globalRateWait = d
+	}
+}
+
+// WithFloodProtection closes connections with code 1008 (Policy Violation)
+// whose frame rate stays above maxFramesPerSecond for a whole window,
+// passing ErrFloodDetected to the disconnect hook. Every frame is counted,
+// including pings, pongs and empty messages, before any other processing,
+// so it guards the read loop itself rather than the message handler; use
+// WithRateLimit to throttle handled messages. Disabled by default.
+func WithFloodProtection(maxFramesPerSecond float64, window time.Duration) Option {
+	return func(c *config) {
+		c.floodLimit = maxFramesPerSecond
+		c.floodWindow = window
+	}
+}
+
+// WithOnFlood registers fn to run when flood protection closes a
+// connection. rate is the frame rate, in frames per second, measured over
+// the window that tripped it.
+func WithOnFlood(fn func(client *Client, rate float64)) Option {
+	return func(c *config) {
+		c.onFlood = fn
+	}
+}
+
+// WithMaxMessageSize limits inbound messages to bytes. A client exceeding the
+// limit is sent close code 1009 (Message Too Big) and disconnected with
+// ErrMessageTooLarge. Zero, the default, means no limit.
+func WithMaxMessageSize(bytes int64) Option {
+	return func(c *config) {
+		c.MaxMessageSize = bytes
+	}
+}
+
+// WithSlowConsumerPolicy sets what happens when a message is queued for a
+// client whose send queue is full. Individual clients can override it with
+// Client.SetSlowConsumerPolicy.
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) Option {
+	return func(c *config) {
+		c.slowConsumerPolicy = policy
+	}
+}
+
+// WithDefaultEnvelopeTTL sets the expiry applied by SendEnvelope to envelopes
+// that do not carry their own ExpiresAt. Expired envelopes are skipped when
+// the outbox is replayed. Zero, the default, means envelopes never expire.
+func WithDefaultEnvelopeTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.defaultEnvelopeTTL = d
+	}
+}
+
+// WithClock replaces the clock driving the handler's timers. It exists
+// mainly so tests can advance time deterministically.
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithRedelivery enables at-least-once delivery: envelopes sent with
+// SendEnvelope are re-sent to the connection on the policy's backoff schedule
+// until the client acks them. Redelivery is disabled by default.
+func WithRedelivery(policy RedeliveryPolicy) Option {
+	return func(c *config) {
+		c.redelivery = policy
+	}
+}
+
+// WithOnDeliveryFailed registers fn to run when an envelope exhausts its
+// redelivery attempts without being acknowledged. The envelope remains
+// undelivered in the persister.
+func WithOnDeliveryFailed(fn func(envelope Envelope, err error)) Option {
+	return func(c *config) {
+		c.onDeliveryFailed = fn
+	}
+}
+
+// WithPersistRetry retries inbound envelopes whose SaveEnvelope failed on
+// the policy's backoff schedule instead of rejecting them straight away.
+// Retries are disabled by default.
+func WithPersistRetry(policy PersistRetryPolicy) Option {
+	return func(c *config) {
+		c.persistRetry = policy
+	}
+}
+
+// WithOnPersistFailure registers a dead-letter hook for inbound envelopes
+// that could not be saved, after any retries. When set, fn receives the
+// envelope and the last error instead of the client being sent a
+// persist_failed error frame; the message is not handled either way.
+func WithOnPersistFailure(fn func(envelope Envelope, err error)) Option {
+	return func(c *config) {
+		c.onPersistFailure = fn
+	}
+}
+
+// WithRetention runs a RetentionJanitor against the handler's persister for
+// as long as the handler is serving: every interval it deletes envelopes
+// delivered more than retention ago, along with expired undelivered ones.
+// The janitor stops when the handler is shut down.
+func WithRetention(interval, retention time.Duration) Option {
+	return func(c *config) {
+		c.retentionInterval = interval
+		c.retentionWindow = retention
+	}
+}
+
+// WithOnRetentionPurge registers fn to run after each retention pass with
+// the number of envelopes purged.
+func WithOnRetentionPurge(fn func(result RetentionResult, err error)) Option {
+	return func(c *config) {
+		c.onRetentionPurge = fn
+	}
+}
+
+// WithDedupeCacheSize sets how many recently accepted inbound envelope IDs
+// the handler remembers to detect retried sends. The default is 1024; zero
+// disables the cache, leaving detection to a persister implementing
+// EnvelopeChecker.
+func WithDedupeCacheSize(n int) Option {
+	return func(c *config) {
+		c.dedupeCacheSize = n
+	}
+}
+
+// WithCodec sets the codec used to encode and decode envelopes on
+// connections that did not negotiate another one. The default is JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(c *config) {
+		c.codec = codec
+	}
+}
+
+// WithSubprotocolCodec offers codec under the websocket subprotocol name.
+// Clients that request the subprotocol during the handshake have their
+// envelopes encoded with codec. Subprotocols are preferred in the order they
+// are registered.
+func WithSubprotocolCodec(name string, codec Codec) Option {
+	return func(c *config) {
+		if c.codecs == nil {
+			c.codecs = make(map[string]Codec)
+		}
+		c.codecs[name] = codec
+		c.addSubprotocol(name)
+	}
+}
+
+// WithUpgrader hands the upgrade to u, for upgrader features the handler does
+// not wrap such as custom error responses, buffer pools or compression. The
+// handler still validates the session, checks connection limits and bans,
+// and builds and serves the client around it.
+//
+// The injected upgrader wins over the handler's own settings: its buffer
+// sizes, handshake timeout, CheckOrigin and Subprotocols are used as is.
+// NewHandler fails with ErrUpgraderConflict when it is combined with
+// WithAllowedOrigins, WithCheckOrigin or WithStrictSubprotocols, or with
+// WithSubprotocols or WithSubprotocolCodec naming a subprotocol missing from
+// u.Subprotocols. Codecs registered for subprotocols that u offers are
+// still used.
+func WithUpgrader(u *websocket.Upgrader) Option {
+	return func(c *config) {
+		c.upgrader = u
+	}
+}
+
+// WithCompression enables permessage-deflate for clients that offer it.
+// Frames smaller than threshold bytes are still sent uncompressed, since
+// deflating small frames costs more CPU than it saves bandwidth. Client
+// reports per-connection counts through CompressionStats. Compression is
+// disabled by default.
+func WithCompression(enabled bool, threshold int) Option {
+	return func(c *config) {
+		c.compression = enabled
+		c.compressionThreshold = threshold
+	}
+}
+
+// WithCompressionLevel sets the flate level used on compressed connections,
+// from flate.BestSpeed to flate.BestCompression. The default is gorilla's,
+// flate.BestSpeed.
+func WithCompressionLevel(level int) Option {
+	return func(c *config) {
+		c.compressionLevel = level
+	}
+}
+
+// WithSubprotocols offers protocols during the upgrade, in order of
+// preference. The first one the client also requested is echoed in the
+// Sec-WebSocket-Protocol response header and reported by
+// Client.Subprotocol. Clients that request none of them are still upgraded,
+// without a subprotocol, unless WithStrictSubprotocols is set.
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *config) {
+		for _, name := range protocols {
+			c.addSubprotocol(name)
+		}
+	}
+}
+
+// WithStrictSubprotocols refuses upgrades with 400 Bad Request when the
+// client requests subprotocols but none of them are offered. Clients that
+// request no subprotocol are unaffected.
+func WithStrictSubprotocols() Option {
+	return func(c *config) {
+		c.strictSubprotocols = true
+	}
+}
+
+func (c *config) addSubprotocol(name string) {
+	for _, existing := range c.subprotocols {
+		if existing == name {
+			return
+		}
+	}
+	c.subprotocols = append(c.subprotocols, name)
+}
+
+// WithOnOutboundError registers fn to run when an outbound interceptor
+// rejects a frame. data is the frame as it was before interception.
+func WithOnOutboundError(fn func(client *Client, data []byte, err error)) Option {
+	return func(c *config) {
+		c.onOutboundError = fn
+	}
+}
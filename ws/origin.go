@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkOrigin reports whether the upgrade request's Origin is acceptable.
+// Without a configured policy it applies the same-origin rule gorilla's
+// upgrader uses by default. An upgrader injected with WithUpgrader applies
+// its own CheckOrigin during the upgrade instead.
+func (h *WebsocketHandler) checkOrigin(r *http.Request) bool {
+	if h.config.upgrader != nil {
+		return true
+	}
+	if h.config.checkOrigin != nil {
+		return h.config.checkOrigin(r)
+	}
+	return sameOrigin(r)
+}
+
+// sameOrigin accepts requests without an Origin header, which browsers
+// always send, and requests whose Origin host matches the Host header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// allowOrigins returns an origin check accepting requests without an Origin
+// header and requests whose Origin host matches one of allowed. An entry of
+// the form "*.example.com" matches any subdomain of example.com but not
+// example.com itself, and "*" matches every origin. Entries may include a
+// port, in which case the origin's port must match; hosts are compared
+// case-insensitively.
+func allowOrigins(allowed []string) func(*http.Request) bool {
+	exact := make(map[string]struct{})
+	var suffixes []string
+	allowAll := false
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "*":
+			allowAll = true
+		case strings.HasPrefix(entry, "*."):
+			suffixes = append(suffixes, entry[1:])
+		case entry != "":
+			exact[entry] = struct{}{}
+		}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowAll {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		host := strings.ToLower(u.Host)
+		if _, ok := exact[host]; ok {
+			return true
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+}
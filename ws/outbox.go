@@ -0,0 +1,95 @@
+package ws
+
+import "context"
+
+// SendEnvelope persists envelope as an outbound message and queues it for
+// every connection of envelope.ClientID. When the recipient is offline the
+// envelope stays in the persister's outbox and is replayed the next time the
+// client connects, unless it has expired by then; in that case SendEnvelope
+// returns nil rather than ErrClientNotConnected. Envelopes without an expiry
+// get the handler's default TTL, if one is configured. Ephemeral envelopes
+// are sent as Hub.SendEnvelope sends them, without being persisted.
+func (h *WebsocketHandler) SendEnvelope(envelope Envelope) error {
+	if envelope.Ephemeral {
+		return h.Hub.SendEnvelope(envelope)
+	}
+	if h.EnvelopePersister == nil {
+		return h.Hub.SendEnvelope(h.withDefaultTTL(envelope))
+	}
+
+	envelope, err := h.saveOutbound(envelope)
+	if err != nil {
+		return err
+	}
+
+	err = h.Hub.SendEnvelope(envelope)
+	if err == ErrClientNotConnected {
+		return nil
+	}
+	return err
+}
+
+// withDefaultTTL gives envelope the handler's default TTL when it has no
+// expiry of its own.
+func (h *WebsocketHandler) withDefaultTTL(envelope Envelope) Envelope {
+	if envelope.ExpiresAt == nil && h.config.defaultEnvelopeTTL > 0 {
+		expiresAt := h.config.clock.Now().Add(h.config.defaultEnvelopeTTL)
+		envelope.ExpiresAt = &expiresAt
+	}
+	return envelope
+}
+
+// saveOutbound saves envelope to the persister's outbox, with the default
+// TTL applied, and returns the envelope as saved.
+func (h *WebsocketHandler) saveOutbound(envelope Envelope) (Envelope, error) {
+	envelope = h.withDefaultTTL(envelope)
+	return envelope, h.EnvelopePersister.SaveEnvelope(envelope)
+}
+
+// SendEnvelopeContext is SendEnvelope for an envelope stamped with the trace
+// in ctx, such as another client's TraceContext, so the push continues the
+// trace that caused it. An envelope with a TraceParent of its own keeps it.
+func (h *WebsocketHandler) SendEnvelopeContext(ctx context.Context, envelope Envelope) error {
+	injectTrace(ctx, &envelope)
+	return h.SendEnvelope(envelope)
+}
+
+// replayUndelivered queues the client's pending envelopes, skipping expired
+// ones. Expired envelopes are purged when the persister supports it. Replay
+// stops early if the send queue fills; the remaining envelopes stay pending
+// for the next connection.
+func (h *WebsocketHandler) replayUndelivered(client *Client) {
+	fetcher, ok := h.EnvelopePersister.(UndeliveredFetcher)
+	if !ok {
+		return
+	}
+
+	envelopes, err := fetcher.FetchUndelivered(client.ID)
+	if err != nil {
+		return
+	}
+
+	now := h.config.clock.Now()
+	expired := 0
+	for _, envelope := range envelopes {
+		if envelope.Inbound || envelope.Ephemeral || envelope.Room != "" {
+			continue
+		}
+		if envelope.Expired(now) {
+			expired++
+			continue
+		}
+
+		msg, err := client.envelopeOutbound(envelope)
+		if err != nil {
+			continue
+		}
+		if client.enqueue(msg) != nil {
+			break
+		}
+	}
+
+	if purger, ok := h.EnvelopePersister.(ExpiredPurger); ok && expired > 0 {
+		purger.PurgeExpired(now)
+	}
+}
@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error a recovered panic is turned into. It matches
+// ErrHandlerPanic with errors.Is, and carries the panic value and the stack
+// of the goroutine that panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrHandlerPanic, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	return ErrHandlerPanic
+}
+
+// recoverPanic turns a panic in the calling function into a *PanicError
+// stored in err. It must be deferred directly.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// runHook runs a connect or disconnect hook for client, treating a panic in
+// it like a failed message with no data.
+func (h *WebsocketHandler) runHook(client *Client, hook func()) {
+	err := func() (err error) {
+		defer recoverPanic(&err)
+		hook()
+		return nil
+	}()
+	if err != nil {
+		h.handlerFailed(client, nil, "", "", err)
+	}
+}
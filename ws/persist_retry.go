@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PersistRetryPolicy controls how the handler retries an inbound envelope
+// whose SaveEnvelope failed. Retries run on the handler's clock rather than
+// in the read loop, so a struggling persister does not stall the connection:
+// the wait before each retry starts at InitialInterval and doubles up to
+// MaxInterval. Once MaxRetries retries have failed the envelope is dead
+// lettered to the persist-failure hook, or rejected with a persist_failed
+// error frame when no hook is registered.
+//
+// A message that is persisted on retry is acked and handed to the execution
+// mode from the retry goroutine, so it may be handled after messages the
+// client sent later and, under SerialPerConnection, concurrently with them.
+type PersistRetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxRetries      int
+}
+
+func (p PersistRetryPolicy) enabled() bool {
+	return p.MaxRetries > 0 && p.InitialInterval > 0
+}
+
+// inboundMessage is an inbound frame that still has to be persisted before
+// it can be acked and handled. It holds a reference to the frame's read
+// buffer from persist until its job finishes or it is given up on.
+type inboundMessage struct {
+	client     *Client
+	buf        *readBuffer
+	envelope   Envelope
+	identified bool
+}
+
+// persist saves msg's envelope and, on success, accepts it. A failed save is
+// handed to the retry policy and persist returns without waiting for it.
+// Ephemeral envelopes are accepted without being saved.
+func (h *WebsocketHandler) persist(msg inboundMessage) {
+	msg.buf.retain()
+	if h.EnvelopePersister != nil && !msg.envelope.Ephemeral {
+		if err := h.EnvelopePersister.SaveEnvelope(msg.envelope); err != nil {
+			h.retryPersist(msg, 1, err)
+			return
+		}
+	}
+	h.accept(msg)
+}
+
+// retryPersist schedules the given retry of a failed save, or gives up once
+// the policy's retries are exhausted or the client has disconnected.
+func (h *WebsocketHandler) retryPersist(msg inboundMessage, retry int, err error) {
+	policy := h.config.persistRetry
+	if !policy.enabled() || retry > policy.MaxRetries {
+		h.persistFailed(msg, fmt.Errorf("save envelope after %d attempts: %w", retry, err))
+		return
+	}
+
+	h.config.clock.AfterFunc(exponentialBackoff(policy.InitialInterval, policy.MaxInterval, retry), func() {
+		select {
+		case <-msg.client.done:
+			h.persistFailed(msg, fmt.Errorf("save envelope: client disconnected after %d attempts: %w", retry, err))
+			return
+		default:
+		}
+
+		if err := h.EnvelopePersister.SaveEnvelope(msg.envelope); err != nil {
+			h.retryPersist(msg, retry+1, err)
+			return
+		}
+		h.accept(msg)
+	})
+}
+
+// persistFailed dead letters an envelope that could not be saved, or rejects
+// it back to the client when no persist-failure hook is registered.
+func (h *WebsocketHandler) persistFailed(msg inboundMessage, err error) {
+	msg.buf.release()
+	h.observeError(msg.client, err)
+	if fn := h.config.onPersistFailure; fn != nil {
+		fn(msg.envelope, err)
+		return
+	}
+	msg.client.enqueue(outbound{
+		data: newErrorFrame(ErrorCodePersistFailed, "message could not be persisted", msg.envelope.ID.String()),
+	})
+}
+
+// accept hands a persisted message to the message handler, acking the
+// envelope first if the client identified it. A message refused by a full
+// handler queue is not acked, so the client may send it again.
+func (h *WebsocketHandler) accept(msg inboundMessage) {
+	client, envelope := msg.client, msg.envelope
+	var ack func()
+	if msg.identified {
+		ack = func() {
+			h.accepted(client, envelope.ID)
+			client.sendAck(envelope.ID)
+		}
+	}
+
+	h.execute(client, envelope.ID.String(), ack, func() {
+		defer msg.buf.release()
+		data := msg.buf.data
+		ctx, end := h.startSpan(client, &envelope, len(data))
+		start := time.Now()
+		msg.buf.retain()
+		err := h.timed(ctx, client, envelope.Type, func(ctx context.Context) error {
+			defer msg.buf.release()
+			return h.dispatch(client, data, func() error {
+				if eh, ok := h.MessageHandler.(envelopeHandler); ok {
+					return eh.handleEnvelope(client, envelope)
+				}
+				return h.handleMessage(ctx, client, data)
+			})
+		})
+		h.observeHandled(client, envelope.Type, start, err)
+		end(err)
+		if err != nil {
+			h.handlerFailed(client, data, envelope.Type, envelope.ID.String(), err)
+		}
+	}, msg.buf.release)
+}
@@ -0,0 +1,497 @@
+// Package persistertest is a conformance suite for ws.EnvelopePersister
+// implementations. Call Run from a test in the implementation's package:
+//
+//	func TestConformance(t *testing.T) {
+//		persistertest.Run(t, func(t *testing.T) ws.EnvelopePersister {
+//			return mypersister.New(openTestDB(t))
+//		})
+//	}
+//
+// Optional interfaces such as ws.UndeliveredFetcher are exercised only when
+// the persister implements them.
+package persistertest
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+)
+
+// Run runs the suite, calling newPersister for a fresh, empty persister in
+// every subtest.
+func Run(t *testing.T, newPersister func(t *testing.T) ws.EnvelopePersister) {
+	t.Run("SaveAndConfirm", func(t *testing.T) { testSaveAndConfirm(t, newPersister(t)) })
+	t.Run("FetchUndelivered", func(t *testing.T) { testFetchUndelivered(t, newPersister(t)) })
+	t.Run("CountUndelivered", func(t *testing.T) { testCountUndelivered(t, newPersister(t)) })
+	t.Run("FetchEnvelopes", func(t *testing.T) { testFetchEnvelopes(t, newPersister(t)) })
+	t.Run("PayloadRoundTrip", func(t *testing.T) { testPayloadRoundTrip(t, newPersister(t)) })
+	t.Run("Exists", func(t *testing.T) { testExists(t, newPersister(t)) })
+	t.Run("RoomHistory", func(t *testing.T) { testRoomHistory(t, newPersister(t)) })
+	t.Run("PurgeExpired", func(t *testing.T) { testPurgeExpired(t, newPersister(t)) })
+	t.Run("PurgeDelivered", func(t *testing.T) { testPurgeDelivered(t, newPersister(t)) })
+	t.Run("Schedule", func(t *testing.T) { testSchedule(t, newPersister(t)) })
+	t.Run("MarkRead", func(t *testing.T) { testMarkRead(t, newPersister(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newPersister(t)) })
+}
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func envelope(clientID ws.Identity, offset time.Duration) ws.Envelope {
+	return ws.Envelope{
+		ID:        ws.NewIdentity(),
+		ClientID:  clientID,
+		Type:      "conformance",
+		Payload:   json.RawMessage(`{"n":1}`),
+		Timestamp: base.Add(offset),
+	}
+}
+
+func save(t *testing.T, p ws.EnvelopePersister, envelopes ...ws.Envelope) {
+	t.Helper()
+	for _, e := range envelopes {
+		if err := p.SaveEnvelope(e); err != nil {
+			t.Fatalf("SaveEnvelope(%s) failed: %v", e.ID, err)
+		}
+	}
+}
+
+func fetcher(t *testing.T, p ws.EnvelopePersister) ws.UndeliveredFetcher {
+	t.Helper()
+	f, ok := p.(ws.UndeliveredFetcher)
+	if !ok {
+		t.Skip("persister does not implement ws.UndeliveredFetcher")
+	}
+	return f
+}
+
+func ids(envelopes []ws.Envelope) []ws.Identity {
+	out := make([]ws.Identity, len(envelopes))
+	for i, e := range envelopes {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func testSaveAndConfirm(t *testing.T, p ws.EnvelopePersister) {
+	e := envelope(ws.NewIdentity(), 0)
+	save(t, p, e)
+	if err := p.ConfirmDelivery(e.ID, e.ClientID); err != nil {
+		t.Errorf("ConfirmDelivery failed: %v", err)
+	}
+}
+
+func testFetchUndelivered(t *testing.T, p ws.EnvelopePersister) {
+	f := fetcher(t, p)
+	clientID := ws.NewIdentity()
+
+	third := envelope(clientID, 3*time.Second)
+	first := envelope(clientID, time.Second)
+	confirmed := envelope(clientID, 2*time.Second)
+	other := envelope(ws.NewIdentity(), 0)
+	inbound := envelope(clientID, 0)
+	inbound.Inbound = true
+	save(t, p, third, first, confirmed, other, inbound)
+
+	if err := p.ConfirmDelivery(confirmed.ID, clientID); err != nil {
+		t.Fatalf("ConfirmDelivery failed: %v", err)
+	}
+
+	got, err := f.FetchUndelivered(clientID)
+	if err != nil {
+		t.Fatalf("FetchUndelivered failed: %v", err)
+	}
+	want := []ws.Identity{first.ID, third.ID}
+	if !reflect.DeepEqual(ids(got), want) {
+		t.Errorf("Expected undelivered %v oldest first, got %v", want, ids(got))
+	}
+}
+
+func testCountUndelivered(t *testing.T, p ws.EnvelopePersister) {
+	counter, ok := p.(ws.UndeliveredCounter)
+	if !ok {
+		t.Skip("persister does not implement ws.UndeliveredCounter")
+	}
+	clientID := ws.NewIdentity()
+	delivered := envelope(clientID, 0)
+	inbound := envelope(clientID, time.Second)
+	inbound.Inbound = true
+	room := envelope(clientID, 2*time.Second)
+	room.Room = "lobby"
+	save(t, p, envelope(clientID, 3*time.Second), envelope(clientID, 4*time.Second), delivered, inbound, room,
+		envelope(ws.NewIdentity(), 5*time.Second))
+	if err := p.ConfirmDelivery(delivered.ID, clientID); err != nil {
+		t.Fatalf("ConfirmDelivery failed: %v", err)
+	}
+
+	if n, err := counter.CountUndelivered(clientID); err != nil || n != 2 {
+		t.Errorf("Expected 2 undelivered envelopes, got %d, %v", n, err)
+	}
+	if n, err := counter.CountUndelivered(ws.NewIdentity()); err != nil || n != 0 {
+		t.Errorf("Expected none for an unknown client, got %d, %v", n, err)
+	}
+}
+
+func testFetchEnvelopes(t *testing.T, p ws.EnvelopePersister) {
+	fetcher, ok := p.(ws.EnvelopeHistoryFetcher)
+	if !ok {
+		t.Skip("persister does not implement ws.EnvelopeHistoryFetcher")
+	}
+	clientID, other := ws.NewIdentity(), ws.NewIdentity()
+
+	// Five envelopes, the middle three sharing a timestamp so their IDs
+	// decide the order; saved out of order, with noise around them.
+	tied := []ws.Envelope{envelope(clientID, time.Second), envelope(clientID, time.Second), envelope(clientID, time.Second)}
+	sort.Slice(tied, func(i, j int) bool { return tied[i].ID.Compare(tied[j].ID) < 0 })
+	first, last := envelope(clientID, 0), envelope(clientID, 2*time.Second)
+	tied[1].Inbound = true
+	room := envelope(clientID, 500*time.Millisecond)
+	room.Room = "lobby"
+	save(t, p, last, tied[2], first, tied[0], room, tied[1], envelope(other, time.Second))
+	all := []ws.Identity{first.ID, tied[0].ID, tied[1].ID, tied[2].ID, last.ID}
+
+	page := func(before ws.Identity, limit int) []ws.Identity {
+		t.Helper()
+		got, err := fetcher.FetchEnvelopes(clientID, before, limit)
+		if err != nil {
+			t.Fatalf("FetchEnvelopes(%s, %d) failed: %v", before, limit, err)
+		}
+		return ids(got)
+	}
+	for _, tc := range []struct {
+		name   string
+		before ws.Identity
+		limit  int
+		want   []ws.Identity
+	}{
+		{"all", ws.Identity{}, 0, all},
+		{"newest page", ws.Identity{}, 2, all[3:]},
+		{"limit beyond the end", ws.Identity{}, 10, all},
+		{"before the newest", last.ID, 2, all[2:4]},
+		{"within a tie", tied[2].ID, 2, all[1:3]},
+		{"before the first tied", tied[0].ID, 0, all[:1]},
+		{"short last page", tied[1].ID, 5, all[:2]},
+		{"before the oldest", first.ID, 2, []ws.Identity{}},
+	} {
+		if got := page(tc.before, tc.limit); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	var walked []ws.Identity
+	for before := (ws.Identity{}); ; {
+		got := page(before, 2)
+		if len(got) == 0 {
+			break
+		}
+		walked = append(got, walked...)
+		before = got[0]
+	}
+	if !reflect.DeepEqual(walked, all) {
+		t.Errorf("Expected paging back to visit %v, got %v", all, walked)
+	}
+
+	for name, before := range map[string]ws.Identity{"unknown": ws.NewIdentity(), "room": room.ID} {
+		if _, err := fetcher.FetchEnvelopes(clientID, before, 2); err != ws.ErrUnknownEnvelope {
+			t.Errorf("Expected ErrUnknownEnvelope for a %s cursor, got %v", name, err)
+		}
+	}
+	if _, err := fetcher.FetchEnvelopes(other, last.ID, 2); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope for another client's cursor, got %v", err)
+	}
+}
+
+func testPayloadRoundTrip(t *testing.T, p ws.EnvelopePersister) {
+	f := fetcher(t, p)
+	e := envelope(ws.NewIdentity(), 0)
+	e.Payload = json.RawMessage(`{"order_id":9007199254740993,"items":["a","b"]}`)
+	save(t, p, e)
+
+	got, err := f.FetchUndelivered(e.ClientID)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected one undelivered envelope, got %d (%v)", len(got), err)
+	}
+	var want, have struct {
+		OrderID int64    `json:"order_id"`
+		Items   []string `json:"items"`
+	}
+	json.Unmarshal(e.Payload, &want)
+	if err := got[0].DecodePayload(&have); err != nil {
+		t.Fatalf("Failed to decode fetched payload: %v", err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected payload %+v, got %+v", want, have)
+	}
+	if got[0].Type != e.Type || got[0].ClientID != e.ClientID {
+		t.Errorf("Expected envelope fields to survive, got %+v", got[0])
+	}
+}
+
+func testExists(t *testing.T, p ws.EnvelopePersister) {
+	checker, ok := p.(ws.EnvelopeChecker)
+	if !ok {
+		t.Skip("persister does not implement ws.EnvelopeChecker")
+	}
+	e := envelope(ws.NewIdentity(), 0)
+	save(t, p, e)
+
+	if exists, err := checker.Exists(e.ID); err != nil || !exists {
+		t.Errorf("Expected saved envelope to exist, got %v (%v)", exists, err)
+	}
+	if exists, err := checker.Exists(ws.NewIdentity()); err != nil || exists {
+		t.Errorf("Expected unknown envelope not to exist, got %v (%v)", exists, err)
+	}
+}
+
+func testRoomHistory(t *testing.T, p ws.EnvelopePersister) {
+	f, ok := p.(ws.RoomHistoryFetcher)
+	if !ok {
+		t.Skip("persister does not implement ws.RoomHistoryFetcher")
+	}
+
+	var room []ws.Envelope
+	for _, offset := range []time.Duration{3, 1, 4, 2, 5} {
+		e := envelope(ws.Identity{}, offset*time.Second)
+		e.Room = "lobby"
+		room = append(room, e)
+	}
+	late := envelope(ws.Identity{}, time.Hour)
+	late.Room = "lobby"
+	other := envelope(ws.Identity{}, 0)
+	other.Room = "ops"
+	direct := envelope(ws.NewIdentity(), 0)
+	save(t, p, append(room, late, other, direct)...)
+
+	got, err := f.FetchRoomHistory("lobby", 3, base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("FetchRoomHistory failed: %v", err)
+	}
+	// The three most recent before the cutoff, oldest first.
+	want := []ws.Identity{room[0].ID, room[2].ID, room[4].ID}
+	if !reflect.DeepEqual(ids(got), want) {
+		t.Errorf("Expected room history %v, got %v", want, ids(got))
+	}
+	for _, e := range got {
+		if e.Room != "lobby" {
+			t.Errorf("Expected fetched envelopes to keep their room, got %q", e.Room)
+		}
+	}
+
+	all, err := f.FetchRoomHistory("lobby", 0, base.Add(2*time.Hour))
+	if err != nil || len(all) != 6 {
+		t.Errorf("Expected no limit to return all 6 lobby envelopes, got %d (%v)", len(all), err)
+	}
+	if none, _ := f.FetchRoomHistory("empty", 10, base.Add(time.Hour)); len(none) != 0 {
+		t.Errorf("Expected no history for an unused room, got %v", ids(none))
+	}
+}
+
+func testPurgeExpired(t *testing.T, p ws.EnvelopePersister) {
+	purger, ok := p.(ws.ExpiredPurger)
+	if !ok {
+		t.Skip("persister does not implement ws.ExpiredPurger")
+	}
+	clientID := ws.NewIdentity()
+	past, future := base.Add(-time.Hour), base.Add(time.Hour)
+
+	expired := envelope(clientID, 0)
+	expired.ExpiresAt = &past
+	live := envelope(clientID, time.Second)
+	live.ExpiresAt = &future
+	forever := envelope(clientID, 2*time.Second)
+	save(t, p, expired, live, forever)
+
+	purged, err := purger.PurgeExpired(base)
+	if err != nil {
+		t.Fatalf("PurgeExpired failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged envelope, got %d", purged)
+	}
+
+	if f, ok := p.(ws.UndeliveredFetcher); ok {
+		got, _ := f.FetchUndelivered(clientID)
+		if want := []ws.Identity{live.ID, forever.ID}; !reflect.DeepEqual(ids(got), want) {
+			t.Errorf("Expected %v to remain, got %v", want, ids(got))
+		}
+	}
+}
+
+func testPurgeDelivered(t *testing.T, p ws.EnvelopePersister) {
+	purger, ok := p.(ws.DeliveredPurger)
+	if !ok {
+		t.Skip("persister does not implement ws.DeliveredPurger")
+	}
+	clientID := ws.NewIdentity()
+	old, recent := base.Add(-2*time.Hour), base.Add(-time.Minute)
+
+	stale := envelope(clientID, 0)
+	stale.Delivered = &old
+	fresh := envelope(clientID, time.Second)
+	fresh.Delivered = &recent
+	pending := envelope(clientID, 2*time.Second)
+	save(t, p, stale, fresh, pending)
+
+	purged, err := purger.PurgeDelivered(base.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeDelivered failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged envelope, got %d", purged)
+	}
+
+	if checker, ok := p.(ws.EnvelopeChecker); ok {
+		for _, tc := range []struct {
+			name string
+			e    ws.Envelope
+			want bool
+		}{{"stale", stale, false}, {"fresh", fresh, true}, {"pending", pending, true}} {
+			if got, _ := checker.Exists(tc.e.ID); got != tc.want {
+				t.Errorf("Expected %s envelope exists=%v, got %v", tc.name, tc.want, got)
+			}
+		}
+	}
+}
+
+func testSchedule(t *testing.T, p ws.EnvelopePersister) {
+	scheduler, ok := p.(ws.EnvelopeScheduler)
+	if !ok {
+		t.Skip("persister does not implement ws.EnvelopeScheduler")
+	}
+	clientID := ws.NewIdentity()
+	later, sooner, cancelled, future := envelope(clientID, 0), envelope(clientID, 0), envelope(clientID, 0), envelope(clientID, 0)
+	for _, s := range []struct {
+		e  ws.Envelope
+		at time.Time
+	}{{later, base.Add(2 * time.Minute)}, {sooner, base.Add(time.Minute)}, {cancelled, base}, {future, base.Add(time.Hour)}} {
+		if err := scheduler.SaveScheduled(s.e, s.at); err != nil {
+			t.Fatalf("SaveScheduled(%s) failed: %v", s.e.ID, err)
+		}
+	}
+	if err := scheduler.CancelScheduled(cancelled.ID); err != nil {
+		t.Fatalf("CancelScheduled failed: %v", err)
+	}
+	if err := scheduler.CancelScheduled(cancelled.ID); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope cancelling twice, got %v", err)
+	}
+
+	now := base.Add(2 * time.Minute)
+	due, err := scheduler.FetchDue(now, time.Minute)
+	if err != nil {
+		t.Fatalf("FetchDue failed: %v", err)
+	}
+	if want := []ws.Identity{sooner.ID, later.ID}; !reflect.DeepEqual(ids(due), want) {
+		t.Errorf("Expected %v due, earliest first, got %v", want, ids(due))
+	}
+	if again, _ := scheduler.FetchDue(now, time.Minute); len(again) != 0 {
+		t.Errorf("Expected claimed envelopes to be skipped, got %v", ids(again))
+	}
+	if f, ok := p.(ws.UndeliveredFetcher); ok {
+		if pending, _ := f.FetchUndelivered(clientID); len(pending) != 0 {
+			t.Errorf("Expected scheduled envelopes kept out of the outbox, got %v", ids(pending))
+		}
+	}
+	if err := scheduler.CancelScheduled(sooner.ID); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope cancelling a claimed envelope, got %v", err)
+	}
+	if err := scheduler.ConfirmScheduled(future.ID); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope confirming an unclaimed envelope, got %v", err)
+	}
+
+	// sooner is sent; later's claim lapses, as if its sender had stopped.
+	if err := scheduler.ConfirmScheduled(sooner.ID); err != nil {
+		t.Fatalf("ConfirmScheduled failed: %v", err)
+	}
+	if err := scheduler.ConfirmScheduled(sooner.ID); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope confirming twice, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if again, _ := scheduler.FetchDue(now, time.Minute); !reflect.DeepEqual(ids(again), []ws.Identity{later.ID}) {
+		t.Errorf("Expected only the lapsed claim handed out again, got %v", ids(again))
+	}
+
+	// Saving a claimed envelope again drops its claim.
+	if err := scheduler.SaveScheduled(later, now); err != nil {
+		t.Fatalf("SaveScheduled failed: %v", err)
+	}
+	if again, _ := scheduler.FetchDue(now, time.Minute); !reflect.DeepEqual(ids(again), []ws.Identity{later.ID}) {
+		t.Errorf("Expected the saved envelope due again, got %v", ids(again))
+	}
+	if err := scheduler.CancelScheduled(future.ID); err != nil {
+		t.Errorf("Expected the future envelope still scheduled, got %v", err)
+	}
+}
+
+func testMarkRead(t *testing.T, p ws.EnvelopePersister) {
+	marker, ok := p.(ws.ReadMarker)
+	if !ok {
+		t.Skip("persister does not implement ws.ReadMarker")
+	}
+	clientID := ws.NewIdentity()
+	e := envelope(clientID, 0)
+	save(t, p, e)
+
+	if err := marker.MarkRead(e.ID, clientID, base); err != nil {
+		t.Fatalf("MarkRead failed: %v", err)
+	}
+	if err := marker.MarkRead(e.ID, clientID, base.Add(time.Hour)); err != nil {
+		t.Fatalf("MarkRead failed marking again: %v", err)
+	}
+	if err := marker.MarkRead(e.ID, ws.NewIdentity(), base); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope for another client, got %v", err)
+	}
+	if err := marker.MarkRead(ws.NewIdentity(), clientID, base); err != ws.ErrUnknownEnvelope {
+		t.Errorf("Expected ErrUnknownEnvelope for an unknown envelope, got %v", err)
+	}
+
+	if f, ok := p.(ws.UndeliveredFetcher); ok {
+		got, _ := f.FetchUndelivered(clientID)
+		if len(got) != 1 || got[0].ReadAt == nil || !got[0].ReadAt.Equal(base) {
+			t.Fatalf("Expected the first read time kept, got %+v", got)
+		}
+		if got[0].Delivered != nil {
+			t.Error("Expected reading not to confirm delivery")
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, p ws.EnvelopePersister) {
+	const workers, perWorker = 8, 25
+	clientID := ws.NewIdentity()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				e := envelope(clientID, time.Duration(w*perWorker+i)*time.Millisecond)
+				if err := p.SaveEnvelope(e); err != nil {
+					t.Errorf("SaveEnvelope failed: %v", err)
+					return
+				}
+				if i%2 == 0 {
+					if err := p.ConfirmDelivery(e.ID, clientID); err != nil {
+						t.Errorf("ConfirmDelivery failed: %v", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if f, ok := p.(ws.UndeliveredFetcher); ok {
+		got, err := f.FetchUndelivered(clientID)
+		if err != nil {
+			t.Fatalf("FetchUndelivered failed: %v", err)
+		}
+		if want := workers * (perWorker / 2); len(got) != want {
+			t.Errorf("Expected %d undelivered envelopes, got %d", want, len(got))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PreparedMessage is a frame encoded once and written to many connections,
+// avoiding per-client framing and compression work on large broadcasts.
+type PreparedMessage struct {
+	messageType int
+	data        []byte
+	prepared    *websocket.PreparedMessage
+}
+
+// NewPreparedMessage prepares data for sending as a frame of messageType,
+// websocket.TextMessage or websocket.BinaryMessage.
+func (h *WebsocketHandler) NewPreparedMessage(messageType int, data []byte) (*PreparedMessage, error) {
+	prepared, err := websocket.NewPreparedMessage(messageType, data)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedMessage{messageType: messageType, data: data, prepared: prepared}, nil
+}
+
+// BroadcastPrepared queues pm for every registered client. Prepared messages
+// share each client's queue with other package sends, so their relative
+// order is preserved.
+func (h *Hub) BroadcastPrepared(pm *PreparedMessage) BroadcastResult {
+	return h.fanOut(func(client *Client) error {
+		return client.enqueue(outbound{data: pm.data, messageType: pm.messageType, prepared: pm.prepared, broadcast: true})
+	})
+}
+
+// writeOutbound writes a queued frame after interception. A prepared frame
+// is written as prepared unless an interceptor changed its data.
+func (c *Client) writeOutbound(msg outbound, data []byte) error {
+	messageType := msg.messageType
+	if messageType == 0 {
+		messageType = websocket.TextMessage
+	}
+	if msg.prepared != nil && bytes.Equal(data, msg.data) {
+		c.compress(len(data))
+		c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.Conn.WritePreparedMessage(msg.prepared); err != nil {
+			return err
+		}
+		c.observeSend(len(data))
+		return nil
+	}
+	return c.write(messageType, data)
+}
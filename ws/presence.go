@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"net/netip"
+	"time"
+)
+
+// PresenceInfo describes one live connection.
+type PresenceInfo struct {
+	Identity   Identity          `json:"identity"`
+	Connected  time.Time         `json:"connected"`
+	RemoteAddr string            `json:"remote_addr"`
+	RemoteIP   netip.Addr        `json:"remote_ip"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+
+	// RTT is the connection's latest heartbeat round-trip time, zero
+	// without WithHeartbeat.
+	RTT time.Duration `json:"rtt_ns,omitempty"`
+}
+
+// Presence returns a snapshot of every live connection. An identity with
+// several connections appears once per connection.
+func (h *Hub) Presence() []PresenceInfo {
+	clients := h.snapshot()
+	presence := make([]PresenceInfo, 0, len(clients))
+	for _, client := range clients {
+		presence = append(presence, client.presence())
+	}
+	return presence
+}
+
+// IsOnline reports whether id has at least one live connection.
+func (h *Hub) IsOnline(id Identity) bool {
+	shard := h.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	return len(shard.byID[id]) > 0
+}
+
+func (c *Client) presence() PresenceInfo {
+	session := c.Session()
+
+	var metadata map[string]string
+	if len(session.Metadata) > 0 {
+		metadata = make(map[string]string, len(session.Metadata))
+		for k, v := range session.Metadata {
+			metadata[k] = v
+		}
+	}
+
+	return PresenceInfo{
+		Identity:   c.ID,
+		Connected:  c.Connected,
+		RemoteAddr: c.remoteAddr,
+		RemoteIP:   c.remoteIP,
+		Metadata:   metadata,
+		RTT:        c.RTT(),
+	}
+}
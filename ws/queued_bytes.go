@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"sync/atomic"
+)
+
+// WithMaxQueuedBytesPerClient caps the bytes of the frames waiting in each
+// client's send queue, on top of the send queue's message count. A frame
+// that would take a client past n bytes is resolved by the slow-consumer
+// policy just like one that finds the queue full; a frame larger than n
+// never fits. Frames written to Client.Send count toward it but are never
+// refused; their writers wait for room in the queue instead. Zero, the
+// default, means no cap.
+func WithMaxQueuedBytesPerClient(n int64) Option {
+	return func(c *config) {
+		c.maxQueuedBytes = n
+	}
+}
+
+// WithMaxQueuedBytesTotal caps the bytes queued across every connection of
+// the handler. Broadcast deliveries, from Broadcast, BroadcastToRoom,
+// BroadcastToTagged, BroadcastPrepared or a bridge, that would exceed it
+// are shed: they count as dropped in the BroadcastResult and in the
+// BroadcastsShed and BroadcastBytesShed stats, and the slow-consumer policy
+// is not applied. Direct sends are never shed but count toward the total.
+// Zero, the default, means no cap.
+func WithMaxQueuedBytesTotal(n int64) Option {
+	return func(c *config) {
+		c.maxQueuedBytesTotal = n
+	}
+}
+
+// QueuedBytes returns the bytes of the frames waiting in the client's send
+// queue.
+func (c *Client) QueuedBytes() int64 {
+	return c.queuedBytes.Load()
+}
+
+// size is the number of bytes msg accounts for while queued. Close
+// requests are not counted.
+func (msg outbound) size() int64 {
+	if msg.closeCode != 0 {
+		return 0
+	}
+	return int64(len(msg.data))
+}
+
+// reserve accounts size bytes as queued for the client and its handler.
+// It fails with ErrQueuedBytesLimit, shedding the delivery, when a
+// broadcast would exceed the handler's total, and with ErrSendQueueFull
+// when the bytes would exceed the client's cap.
+func (c *Client) reserve(size int64, broadcast bool) error {
+	h := c.handler
+	if h == nil {
+		c.queuedBytes.Add(size)
+		return nil
+	}
+
+	var total int64
+	if broadcast {
+		total = h.config.maxQueuedBytesTotal
+	}
+	if !reserveBytes(&h.queuedBytes, size, total) {
+		h.broadcastsShed.Add(1)
+		h.broadcastBytesShed.Add(uint64(size))
+		return ErrQueuedBytesLimit
+	}
+	if !reserveBytes(&c.queuedBytes, size, h.config.maxQueuedBytes) {
+		h.queuedBytes.Add(-size)
+		return ErrSendQueueFull
+	}
+	return nil
+}
+
+// hold accounts size bytes as queued for the client and its handler
+// without applying either cap, for frames whose sender waited for room in
+// the queue.
+func (c *Client) hold(size int64) {
+	c.queuedBytes.Add(size)
+	if c.handler != nil {
+		c.handler.queuedBytes.Add(size)
+	}
+}
+
+// release returns the bytes of a frame that left the send queue.
+func (c *Client) release(size int64) {
+	c.queuedBytes.Add(-size)
+	if c.handler != nil {
+		c.handler.queuedBytes.Add(-size)
+	}
+}
+
+// reserveBytes adds size to counter unless that would take it past limit.
+// A limit of zero means no limit.
+func reserveBytes(counter *atomic.Int64, size, limit int64) bool {
+	for {
+		n := counter.Load()
+		if limit > 0 && n+size > limit {
+			return false
+		}
+		if counter.CompareAndSwap(n, n+size) {
+			return true
+		}
+	}
+}
+
+// drainQueue discards the frames left in the send queue once the
+// connection has closed, releasing their bytes. It runs after the write
+// pump has exited, and again from any send that raced with the close.
+func (c *Client) drainQueue() {
+	for {
+		select {
+		case msg := <-c.queue:
+			c.release(msg.size())
+			if msg.closed != nil {
+				close(msg.closed)
+			}
+		default:
+			return
+		}
+	}
+}
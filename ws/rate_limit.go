@@ -0,0 +1,196 @@
+package ws
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultRateLimitCloseAfter is how many consecutive throttled frames
+// RateLimitClose tolerates before closing the connection.
+const defaultRateLimitCloseAfter = 10
+
+// RateLimitPolicy decides what happens to an inbound frame that arrives
+// while its connection is over the rate limit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop discards the frame and sends the client an error frame
+	// with code "rate_limited". It is the default.
+	RateLimitDrop RateLimitPolicy = iota
+
+	// RateLimitDelay stops reading from the connection until the frame is
+	// within the limit, pushing back on the client through TCP flow
+	// control. No frame is discarded.
+	RateLimitDelay
+
+	// RateLimitClose drops frames like RateLimitDrop and, once the client
+	// keeps sending over the limit, closes the connection with code 1008
+	// (Policy Violation).
+	RateLimitClose
+)
+
+func (p RateLimitPolicy) String() string {
+	switch p {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// tokenBucket is a token-bucket rate limiter refilled at rate tokens per
+// second up to burst tokens. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = 1
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// take consumes a token at now if one is available or will be within
+// maxWait, returning how long the caller must wait before proceeding. A
+// token taken in advance is owed, so later callers wait longer and the rate
+// holds however many callers are waiting. When no token is available in
+// time nothing is consumed, and take reports how long until the next token.
+func (b *tokenBucket) take(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// Throttled returns how many inbound frames from this client exceeded the
+// handler's rate limit, whether they were dropped or delayed.
+func (c *Client) Throttled() uint64 {
+	return c.throttled.Load()
+}
+
+// limitRate applies the per-connection rate limit to a frame just read. It
+// reports whether the frame should be processed; a non-nil error means the
+// connection has been closed for abuse and the read loop must stop.
+func (h *WebsocketHandler) limitRate(client *Client, messageType int, message []byte) (bool, error) {
+	if client.limiter == nil {
+		return true, nil
+	}
+
+	clock := h.config.clock
+	if _, ok := client.limiter.take(clock.Now(), 0); ok {
+		client.throttledRun = 0
+		return true, nil
+	}
+	client.throttled.Add(1)
+
+	switch h.config.rateLimitPolicy {
+	case RateLimitDelay:
+		wait, _ := client.limiter.take(clock.Now(), math.MaxInt64)
+		sleep(clock, wait)
+		return true, nil
+	case RateLimitClose:
+		if client.throttledRun++; client.throttledRun >= h.config.rateLimitCloseAfter {
+			client.closeAfterQueued(websocket.ClosePolicyViolation, "rate limit exceeded")
+			return false, ErrRateLimited
+		}
+	}
+
+	h.observeError(client, ErrRateLimited)
+	client.SendError(ErrorCodeRateLimited, "rate limit exceeded", client.frameRef(messageType, message))
+	return false, nil
+}
+
+// sleep blocks for d on clock.
+func sleep(clock Clock, d time.Duration) {
+	done := make(chan struct{})
+	clock.AfterFunc(d, func() { close(done) })
+	<-done
+}
+
+// limitGlobal applies the handler-wide rate limit before a frame is
+// persisted or handled. A frame may wait up to the configured budget for
+// capacity; beyond that it is shed and the client is sent an error frame
+// with code "overloaded". limitGlobal blocks the connection's read loop
+// while it waits.
+func (h *WebsocketHandler) limitGlobal(client *Client, ref string) bool {
+	if h.globalLimiter == nil {
+		return true
+	}
+
+	clock := h.config.clock
+	wait, ok := h.globalLimiter.take(clock.Now(), h.config.globalRateWait)
+	if !ok {
+		h.shed.Add(1)
+		h.shedRate.add(clock.Now())
+		h.observeError(client, ErrOverloaded)
+		client.SendError(ErrorCodeOverloaded, "server is busy, try again later", ref)
+		return false
+	}
+	if wait > 0 {
+		sleep(clock, wait)
+	}
+	return true
+}
+
+// rateGauge reports how many events happened during the last complete
+// second.
+type rateGauge struct {
+	mu    sync.Mutex
+	start time.Time
+	count int
+	last  int
+}
+
+func (g *rateGauge) add(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollLocked(now)
+	g.count++
+}
+
+func (g *rateGauge) rate(now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollLocked(now)
+	return float64(g.last)
+}
+
+func (g *rateGauge) rollLocked(now time.Time) {
+	elapsed := now.Sub(g.start)
+	switch {
+	case elapsed < time.Second:
+		return
+	case elapsed < 2*time.Second:
+		g.last = g.count
+	default:
+		g.last = 0
+	}
+	g.start = now.Truncate(time.Second)
+	g.count = 0
+}
@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// readBufferClasses are the capacities of the pooled read buffers. Frames
+// larger than the largest class are read into buffers that are not pooled.
+var readBufferClasses = [...]int{1 << 10, 4 << 10, 16 << 10, 64 << 10}
+
+var readBufferPools [len(readBufferClasses)]sync.Pool
+
+// WithReadBufferPooling turns the pooling of read buffers on or off. With
+// pooling, the default, each frame is read into a buffer leased from a
+// pool and the data passed to the MessageHandler, HandleBinary, middleware
+// and the error handler is only valid until they return: the buffer is
+// reused for a later frame once the message has been handled, so handlers
+// that keep the bytes, or pass them to another goroutine such as by
+// writing them to Client.Send, must copy them first with Client.Retain.
+// Handlers that cannot follow that contract should turn pooling off, and
+// every frame is then read into a buffer of its own.
+func WithReadBufferPooling(enabled bool) Option {
+	return func(c *config) {
+		c.unpooledReads = !enabled
+	}
+}
+
+// Retain returns a copy of data, a frame passed to a handler, that stays
+// valid after the handler returns. See WithReadBufferPooling.
+func (c *Client) Retain(data []byte) []byte {
+	return bytes.Clone(data)
+}
+
+// readBuffer holds one inbound frame. It is released once the read loop
+// and every stage handling the frame are done with it, and returns to its
+// pool when the last reference is released.
+type readBuffer struct {
+	data  []byte
+	class int
+	refs  atomic.Int32
+
+	// held, when not nil, counts the buffer until it returns to its pool.
+	held *atomic.Int64
+}
+
+func leaseReadBuffer(class int) *readBuffer {
+	buf, _ := readBufferPools[class].Get().(*readBuffer)
+	if buf == nil {
+		buf = &readBuffer{data: make([]byte, 0, readBufferClasses[class]), class: class}
+	}
+	buf.refs.Store(1)
+	return buf
+}
+
+// unpooledReadBuffer wraps data, which is never reused.
+func unpooledReadBuffer(data []byte) *readBuffer {
+	return &readBuffer{data: data, class: -1}
+}
+
+func (b *readBuffer) retain() {
+	b.refs.Add(1)
+}
+
+func (b *readBuffer) release() {
+	if b.refs.Add(-1) != 0 || b.class < 0 {
+		return
+	}
+	if b.held != nil {
+		b.held.Add(-1)
+		b.held = nil
+	}
+	b.data = b.data[:0]
+	readBufferPools[b.class].Put(b)
+}
+
+// grow returns a buffer with room for more of the frame, holding what b
+// holds, and releases b.
+func (b *readBuffer) grow() *readBuffer {
+	var next *readBuffer
+	if b.class >= 0 && b.class+1 < len(readBufferClasses) {
+		next = leaseReadBuffer(b.class + 1)
+	} else {
+		next = unpooledReadBuffer(make([]byte, 0, 2*cap(b.data)))
+	}
+	next.data = append(next.data, b.data...)
+	b.release()
+	return next
+}
+
+// readMessage reads the next data frame, into a pooled buffer unless the
+// handler turned pooling off. The caller owns the returned buffer's one
+// reference.
+func (c *Client) readMessage() (int, *readBuffer, error) {
+	if c.handler.config.unpooledReads {
+		messageType, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return messageType, nil, err
+		}
+		return messageType, unpooledReadBuffer(data), nil
+	}
+
+	messageType, r, err := c.Conn.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+	buf := leaseReadBuffer(0)
+	for {
+		if len(buf.data) == cap(buf.data) {
+			buf = buf.grow()
+		}
+		n, err := r.Read(buf.data[len(buf.data):cap(buf.data)])
+		buf.data = buf.data[:len(buf.data)+n]
+		if err == io.EOF {
+			if buf.class >= 0 {
+				buf.held = &c.handler.readBuffers
+				buf.held.Add(1)
+			}
+			return messageType, buf, nil
+		}
+		if err != nil {
+			buf.release()
+			return messageType, nil, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ws
+
+import "time"
+
+const (
+	// ReadMessageType is the type of the frame clients send to mark
+	// envelopes they were sent as read by their user, one or many at a
+	// time: {"type":"_read","id":"<uuid>","payload":{"ids":["<envelope-id>", ...]}}.
+	// A frame with an ID is acked once every envelope it lists is marked.
+	ReadMessageType = "_read"
+
+	// ReceiptMessageType is the type of the envelopes WithReadReceipts sends
+	// to the senders of envelopes that have been read. Their payload is a
+	// ReadReceipt.
+	ReceiptMessageType = "_receipt"
+)
+
+// ReadReceipt is the payload of a ReceiptMessageType envelope: Reader read
+// the envelopes IDs at ReadAt.
+type ReadReceipt struct {
+	Reader Identity   `json:"reader"`
+	IDs    []Identity `json:"ids"`
+	ReadAt time.Time  `json:"read_at"`
+}
+
+// readRequest is the payload of read frames.
+type readRequest struct {
+	IDs []Identity `json:"ids"`
+}
+
+// WithReadReceipts tells the senders of envelopes when their recipients read
+// them. For each envelope a client marks read with a ReadMessageType frame,
+// sender returns the identity to notify, or a zero identity for none;
+// envelopes do not record who they came from, so only the application
+// knows. Each sender is sent one ReceiptMessageType envelope per read
+// frame, listing its envelopes that frame marked, through SendEnvelope, so
+// an offline sender gets it when it next connects.
+func WithReadReceipts(sender func(envelopeID, reader Identity) Identity) Option {
+	return func(c *config) {
+		c.receiptSender = sender
+	}
+}
+
+// handleRead marks the envelopes a read frame lists as read, with the
+// handler's persister when it is a ReadMarker, and sends the receipts.
+// Envelopes that are not the client's, or cannot be marked, are answered
+// with an error frame each and get no receipt.
+func (h *WebsocketHandler) handleRead(client *Client, envelope Envelope, identified bool) {
+	ref := ""
+	if identified {
+		ref = envelope.ID.String()
+	}
+	var req readRequest
+	if err := envelope.DecodePayload(&req); err != nil || len(req.IDs) == 0 {
+		client.SendError(ErrorCodeInvalidRead, "payload must list envelope IDs", ref)
+		return
+	}
+
+	now := h.config.clock.Now()
+	marker, _ := h.EnvelopePersister.(ReadMarker)
+	var senders []Identity
+	receipts := make(map[Identity][]Identity)
+	failed := false
+	for _, id := range req.IDs {
+		if marker != nil {
+			switch err := marker.MarkRead(id, client.ID, now); {
+			case err == ErrUnknownEnvelope:
+				client.SendError(ErrorCodeUnknownEnvelope, "no envelope with this ID was sent to the client", id.String())
+				failed = true
+				continue
+			case err != nil:
+				client.SendError(ErrorCodeReadFailed, "envelope could not be marked read", id.String())
+				failed = true
+				continue
+			}
+		}
+		if h.config.receiptSender == nil {
+			continue
+		}
+		sender := h.config.receiptSender(id, client.ID)
+		if sender.IsZero() {
+			continue
+		}
+		if _, ok := receipts[sender]; !ok {
+			senders = append(senders, sender)
+		}
+		receipts[sender] = append(receipts[sender], id)
+	}
+
+	for _, sender := range senders {
+		receipt := ReadReceipt{Reader: client.ID, IDs: receipts[sender], ReadAt: now}
+		if e, err := NewEnvelope(sender, ReceiptMessageType, receipt, WithEnvelopeClock(h.config.clock)); err == nil {
+			h.SendEnvelope(e)
+		}
+	}
+	if identified && !failed {
+		client.sendAck(envelope.ID)
+	}
+}
@@ -0,0 +1,399 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReconnectPolicy controls how a ReconnectingClient redials after losing
+// its connection. The first redial is immediate; the wait before each later
+// one starts at InitialInterval, doubles up to MaxInterval and is randomized
+// by up to Jitter of itself in either direction, so clients dropped together
+// do not redial together. The client gives up once MaxAttempts redials have
+// failed or MaxElapsedTime has passed since the connection was lost; zero
+// means no limit.
+type ReconnectPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Jitter          float64
+	MaxAttempts     int
+	MaxElapsedTime  time.Duration
+}
+
+// DefaultReconnectPolicy retries forever, backing off from half a second to
+// thirty seconds with 20% jitter.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     30 * time.Second,
+		Jitter:          0.2,
+	}
+}
+
+// wait returns the randomized wait before the given redial, counting from
+// one.
+func (p ReconnectPolicy) wait(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	d := exponentialBackoff(p.InitialInterval, p.MaxInterval, attempt-1)
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// exhausted reports whether the given redial may not be made.
+func (p ReconnectPolicy) exhausted(attempt int, elapsed time.Duration) bool {
+	return (p.MaxAttempts > 0 && attempt > p.MaxAttempts) ||
+		(p.MaxElapsedTime > 0 && elapsed >= p.MaxElapsedTime)
+}
+
+// ConnState is the state of a ReconnectingClient's connection.
+type ConnState int
+
+const (
+	// StateConnecting is the state until the first dial succeeds.
+	StateConnecting ConnState = iota
+
+	// StateConnected means the client is connected and sends go straight
+	// to the server.
+	StateConnected
+
+	// StateReconnecting means the connection was lost and the client is
+	// redialing; sends are buffered meanwhile.
+	StateReconnecting
+
+	// StateGaveUp means the reconnect policy was exhausted. The client
+	// will not dial again.
+	StateGaveUp
+
+	// StateClosed means Close was called.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateGaveUp:
+		return "gave_up"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ReconnectOption configures a ReconnectingClient.
+type ReconnectOption func(*ReconnectingClient)
+
+// WithReconnectPolicy replaces DefaultReconnectPolicy.
+func WithReconnectPolicy(policy ReconnectPolicy) ReconnectOption {
+	return func(c *ReconnectingClient) {
+		c.policy = policy
+	}
+}
+
+// WithReconnectDialOptions sets the options every dial is made with.
+func WithReconnectDialOptions(opts ...DialOption) ReconnectOption {
+	return func(c *ReconnectingClient) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// WithOnReconnect registers fn to run on each new connection after the
+// first, before messages buffered during the outage are flushed, so it can
+// resubscribe or replay state the server lost. It does not run for
+// connections that resumed their session, as the server kept that state.
+// An error drops the connection and counts as a failed redial.
+func WithOnReconnect(fn func(conn *ClientConn) error) ReconnectOption {
+	return func(c *ReconnectingClient) {
+		c.onReconnect = fn
+	}
+}
+
+// WithOnStateChange registers fn to run whenever the client's state
+// changes, with the error that caused it for StateReconnecting and
+// StateGaveUp. It runs on the client's connection goroutine and should not
+// block.
+func WithOnStateChange(fn func(state ConnState, err error)) ReconnectOption {
+	return func(c *ReconnectingClient) {
+		c.onStateChange = fn
+	}
+}
+
+// WithOutboundBuffer sets how many envelopes are buffered while the client
+// is disconnected. The default is 256.
+func WithOutboundBuffer(n int) ReconnectOption {
+	return func(c *ReconnectingClient) {
+		c.bufferSize = n
+	}
+}
+
+// ReconnectingClient keeps a ClientConn to a server open across restarts
+// and network failures, redialing on its ReconnectPolicy. Envelopes sent
+// while it is disconnected are buffered, up to a bound, and flushed in order
+// once it reconnects. It is safe for concurrent use.
+type ReconnectingClient struct {
+	url           string
+	dialOpts      []DialOption
+	policy        ReconnectPolicy
+	onReconnect   func(*ClientConn) error
+	onStateChange func(ConnState, error)
+	bufferSize    int
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	receive chan Envelope
+	done    chan struct{}
+
+	mu     sync.Mutex
+	state  ConnState
+	conn   *ClientConn
+	buffer []Envelope
+
+	// resumeToken is the token of the last connection, presented on
+	// redial to resume its session. Only the connection goroutine uses it.
+	resumeToken string
+}
+
+// NewReconnectingClient starts connecting to url in the background and
+// returns at once; watch WithOnStateChange or Receive to learn when it is
+// connected. Like later redials, a failing first dial is retried on the
+// reconnect policy.
+func NewReconnectingClient(url string, opts ...ReconnectOption) *ReconnectingClient {
+	c := &ReconnectingClient{
+		url:        url,
+		policy:     DefaultReconnectPolicy(),
+		bufferSize: 256,
+		receive:    make(chan Envelope),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run()
+	return c
+}
+
+// State returns the client's current state.
+func (c *ReconnectingClient) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Receive returns the channel envelopes from every connection are delivered
+// on, as with ClientConn.Receive, including the acks for sent envelopes. It
+// must be drained: the client does not redial until the envelopes the lost
+// connection received have been delivered. It is closed once the client
+// gives up or is closed.
+func (c *ReconnectingClient) Receive() <-chan Envelope {
+	return c.receive
+}
+
+// Done is closed once the client has given up or been closed.
+func (c *ReconnectingClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Send sends a new envelope of type msgType with payload encoded as JSON,
+// returning its ID, like ClientConn.Send.
+func (c *ReconnectingClient) Send(msgType string, payload any) (Identity, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Identity{}, err
+	}
+	envelope := Envelope{ID: NewIdentity(), Type: msgType, Payload: data}
+	return envelope.ID, c.SendEnvelope(envelope)
+}
+
+// SendEnvelope sends envelope when connected and buffers it otherwise. It
+// returns ErrSendQueueFull when the buffer is full and ErrClientNotConnected
+// once the client has given up or been closed.
+func (c *ReconnectingClient) SendEnvelope(envelope Envelope) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case StateGaveUp, StateClosed:
+		return ErrClientNotConnected
+	case StateConnected:
+		if c.conn.SendEnvelope(envelope) == nil {
+			return nil
+		}
+	}
+	if len(c.buffer) >= c.bufferSize {
+		return ErrSendQueueFull
+	}
+	c.buffer = append(c.buffer, envelope)
+	return nil
+}
+
+// Ack confirms delivery of an envelope received from the server on the
+// current connection.
+func (c *ReconnectingClient) Ack(id Identity) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrClientNotConnected
+	}
+	return conn.Ack(id)
+}
+
+// Close stops reconnecting and closes the current connection, discarding
+// any buffered envelopes.
+func (c *ReconnectingClient) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *ReconnectingClient) run() {
+	defer close(c.done)
+	defer close(c.receive)
+
+	reconnect := false
+	attempt := 0
+	lost := time.Now()
+	for {
+		conn, err := c.dial(reconnect)
+		if err == nil {
+			reconnect = true
+			c.serve(conn)
+			if c.ctx.Err() != nil {
+				c.setState(StateClosed, nil)
+				return
+			}
+			attempt, lost, err = 0, time.Now(), conn.Err()
+		}
+		if c.ctx.Err() != nil {
+			c.setState(StateClosed, nil)
+			return
+		}
+
+		attempt++
+		if c.policy.exhausted(attempt, time.Since(lost)) {
+			c.setState(StateGaveUp, err)
+			return
+		}
+		select {
+		case <-time.After(c.policy.wait(attempt)):
+		case <-c.ctx.Done():
+			c.setState(StateClosed, nil)
+			return
+		}
+	}
+}
+
+// dial connects, resuming the last connection's session when the server
+// supports it, and runs the reconnect hook for reconnections that did not.
+func (c *ReconnectingClient) dial(reconnect bool) (*ClientConn, error) {
+	opts := append(c.dialOpts[:len(c.dialOpts):len(c.dialOpts)], WithResumeToken(c.resumeToken))
+	conn, err := Dial(c.ctx, c.url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.resumeToken = conn.ResumeToken
+	if reconnect && !conn.Resumed && c.onReconnect != nil {
+		if err := c.onReconnect(conn); err != nil {
+			conn.Close(websocket.CloseNormalClosure, "")
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// serve makes conn the current connection, flushes the buffer to it and
+// forwards what it receives until it ends or the client is closed. The
+// state changes under the same lock as the connection, so a send never sees
+// one without the other.
+func (c *ReconnectingClient) serve(conn *ClientConn) {
+	c.mu.Lock()
+	flushed := 0
+	for _, envelope := range c.buffer {
+		if conn.SendEnvelope(envelope) != nil {
+			break
+		}
+		flushed++
+	}
+	c.buffer = c.buffer[flushed:]
+	c.conn = conn
+	changed := c.transition(StateConnected)
+	c.mu.Unlock()
+	c.notify(changed, StateConnected, nil)
+
+	// Watch for the connection ending separately, so sends are buffered
+	// from then on even while the loop below waits to deliver envelopes
+	// the connection already received.
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		select {
+		case <-conn.Done():
+		case <-c.ctx.Done():
+			return
+		}
+		c.mu.Lock()
+		changed := false
+		if c.ctx.Err() == nil {
+			c.conn = nil
+			changed = c.transition(StateReconnecting)
+		}
+		c.mu.Unlock()
+		c.notify(changed, StateReconnecting, conn.Err())
+	}()
+	defer func() { <-lost }()
+
+	for {
+		select {
+		case envelope, ok := <-conn.Receive():
+			if !ok {
+				return
+			}
+			select {
+			case c.receive <- envelope:
+			case <-c.ctx.Done():
+			}
+		case <-c.ctx.Done():
+			conn.Close(websocket.CloseNormalClosure, "")
+			return
+		}
+	}
+}
+
+func (c *ReconnectingClient) setState(state ConnState, err error) {
+	c.mu.Lock()
+	changed := c.transition(state)
+	c.mu.Unlock()
+	c.notify(changed, state, err)
+}
+
+// transition moves to state, reporting whether it changed. The lock must be
+// held.
+func (c *ReconnectingClient) transition(state ConnState) bool {
+	changed := c.state != state
+	c.state = state
+	if state == StateGaveUp || state == StateClosed {
+		c.conn = nil
+		c.buffer = nil
+	}
+	return changed
+}
+
+func (c *ReconnectingClient) notify(changed bool, state ConnState, err error) {
+	if changed && c.onStateChange != nil {
+		c.onStateChange(state, err)
+	}
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+)
+
+// RedeliveryPolicy controls how unacknowledged envelopes are re-sent to
+// clients that are still connected. The wait before each re-send starts at
+// InitialInterval and doubles up to MaxInterval. After MaxAttempts sends
+// without an ack the handler gives up and fires its delivery-failed hook.
+type RedeliveryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxAttempts     int
+}
+
+func (p RedeliveryPolicy) enabled() bool {
+	return p.MaxAttempts > 0 && p.InitialInterval > 0
+}
+
+// backoff returns the wait after the given send attempt, counting from one.
+func (p RedeliveryPolicy) backoff(attempt int) time.Duration {
+	return exponentialBackoff(p.InitialInterval, p.MaxInterval, attempt)
+}
+
+// exponentialBackoff doubles initial once per attempt after the first,
+// capping the result at max when max is positive.
+func exponentialBackoff(initial, max time.Duration, attempt int) time.Duration {
+	d := initial
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if max > 0 && d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+// delivery tracks an envelope sent to a connection and awaiting its ack.
+// The state lives with the connection, so a reconnecting client starts over
+// with a fresh schedule when its outbox is replayed.
+type delivery struct {
+	envelope Envelope
+	attempts int
+	timer    Timer
+}
+
+// awaitAck records that envelope is being sent to the client and, when
+// redelivery is enabled, schedules a re-send in case no ack arrives.
+func (c *Client) awaitAck(envelope Envelope) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.unacked == nil {
+		c.unacked = make(map[Identity]*delivery)
+	}
+	d, ok := c.unacked[envelope.ID]
+	if !ok {
+		d = &delivery{envelope: envelope}
+		c.unacked[envelope.ID] = d
+	}
+	d.attempts++
+
+	if c.handler == nil || !c.handler.config.redelivery.enabled() {
+		return
+	}
+	policy := c.handler.config.redelivery
+	d.timer = c.handler.config.clock.AfterFunc(policy.backoff(d.attempts), func() {
+		c.redeliver(envelope.ID)
+	})
+}
+
+// redeliver re-sends an envelope that is still unacked, or gives up once the
+// policy's attempts are exhausted.
+func (c *Client) redeliver(id Identity) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.mu.Lock()
+	d, ok := c.unacked[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	policy := c.handler.config.redelivery
+	if d.attempts >= policy.MaxAttempts {
+		delete(c.unacked, id)
+		c.mu.Unlock()
+
+		err := fmt.Errorf("%w after %d attempts", ErrDeliveryFailed, d.attempts)
+		c.handler.observeError(c, err)
+		if fn := c.handler.config.onDeliveryFailed; fn != nil {
+			fn(d.envelope, err)
+		}
+		return
+	}
+	envelope := d.envelope
+	c.mu.Unlock()
+
+	msg, err := c.envelopeOutbound(envelope)
+	if err != nil {
+		return
+	}
+	if c.enqueue(msg) != nil {
+		// The queue is full; try again after the next interval.
+		c.mu.Lock()
+		d.attempts++
+		d.timer = c.handler.config.clock.AfterFunc(policy.backoff(d.attempts), func() {
+			c.redeliver(id)
+		})
+		c.mu.Unlock()
+	}
+}
+
+// stopRedelivery cancels every pending re-send for the connection.
+func (c *Client) stopRedelivery() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, d := range c.unacked {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+	}
+}
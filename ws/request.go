@@ -0,0 +1,73 @@
+package ws
+
+import "context"
+
+// Request sends a request envelope of type msgType to the client and waits
+// for the reply envelope whose reply_to references the request's id. Replies
+// are routed back to the waiting caller by the read loop and never reach the
+// MessageHandler. It returns ctx's error when ctx expires first, and
+// ErrClientNotConnected if the client disconnects while the request is
+// outstanding.
+func (c *Client) Request(ctx context.Context, msgType string, payload any) (Envelope, error) {
+	var opts []EnvelopeOption
+	if c.handler != nil {
+		opts = append(opts, WithEnvelopeClock(c.handler.config.clock))
+	}
+	request, err := NewEnvelope(c.ID, msgType, payload, opts...)
+	if err != nil {
+		return Envelope{}, err
+	}
+	msg, err := c.envelopeOutbound(request)
+	if err != nil {
+		return Envelope{}, err
+	}
+	// Requests are answered by a reply rather than an ack.
+	msg.envelope = nil
+
+	id := request.ID
+
+	reply := make(chan Envelope, 1)
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = make(map[Identity]chan Envelope)
+	}
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.enqueue(msg); err != nil {
+		return Envelope{}, err
+	}
+
+	select {
+	case env := <-reply:
+		return env, nil
+	case <-ctx.Done():
+		return Envelope{}, ctx.Err()
+	case <-c.done:
+		return Envelope{}, ErrClientNotConnected
+	}
+}
+
+// resolveReply hands env to the Request waiting for it. It reports false when
+// env is not a reply to an outstanding request.
+func (c *Client) resolveReply(env Envelope) bool {
+	if env.ReplyTo == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	reply, ok := c.pending[*env.ReplyTo]
+	delete(c.pending, *env.ReplyTo)
+	c.mu.Unlock()
+
+	if ok {
+		reply <- env
+	}
+	return ok
+}
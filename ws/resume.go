@@ -0,0 +1,280 @@
+package ws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ResumeTokenHeader is the upgrade response header carrying the session's
+// resumption token under WithSessionResumption. Clients resume the session
+// by presenting the token on their next upgrade, in the same header or, for
+// browsers, which cannot set upgrade headers, the resume_token query
+// parameter.
+const ResumeTokenHeader = "X-Resume-Token"
+
+const resumeTokenParam = "resume_token"
+
+// WithSessionResumption lets a client whose connection drops resume its
+// session on a new connection within grace, instead of starting over. Each
+// session gets a resumption token, signed with secret so that it cannot be
+// forged, and sent in the ResumeTokenHeader of the upgrade response.
+//
+// When the connection drops, the client stays registered for grace: it
+// keeps its rooms, topic subscriptions, tags and metadata, and frames sent
+// to it are queued. An upgrade presenting the token within grace, for the
+// same identity, takes the session over: the new connection inherits all of
+// it, with metadata from the SessionValidator taking precedence, and is
+// sent the queued frames first. The response carries the same token, so
+// the client can tell it resumed; WithOnResume runs instead of
+// WithOnConnect. An upgrade presenting a token whose session is still
+// connected closes the old connection and takes the session over once it
+// has dropped. Invalid, expired and already resumed tokens start a new
+// session with a new token.
+//
+// Once grace passes without a resumption the session ends: the client is
+// unregistered and WithOnDisconnect and observers learn of it, with the
+// reason the last connection ended. Connections the server closes, and
+// clients that close with 1000 (Normal Closure), end their session at once.
+func WithSessionResumption(secret []byte, grace time.Duration) Option {
+	return func(c *config) {
+		c.resumeSecret = secret
+		c.resumeGrace = grace
+	}
+}
+
+// WithOnResume registers fn to run after a client has resumed its session
+// on a new connection, before its first message is read.
+func WithOnResume(fn func(client *Client, session SessionInfo)) Option {
+	return func(c *config) {
+		c.onResume = fn
+	}
+}
+
+// resumableSession is a session that may be resumed. claimed is set while
+// an upgrade waits for client, still connected, to park.
+type resumableSession struct {
+	client  *Client
+	parked  bool
+	timer   Timer
+	claimed chan struct{}
+}
+
+// ResumeToken returns the token that resumes the client's session, or an
+// empty string without WithSessionResumption.
+func (c *Client) ResumeToken() string {
+	if c.resumeID.IsZero() {
+		return ""
+	}
+	return c.handler.resumeToken(c.resumeID, c.ID)
+}
+
+// Suspended reports whether the client's connection has dropped and its
+// session is waiting to be resumed.
+func (c *Client) Suspended() bool {
+	return c.parked.Load() && c.successor.Load() == nil
+}
+
+// resumeToken signs the session ID and identity: the token is both,
+// followed by their HMAC-SHA256, base64url-encoded without padding.
+func (h *WebsocketHandler) resumeToken(sid, id Identity) string {
+	token := make([]byte, 0, 2*len(sid)+sha256.Size)
+	token = append(append(token, sid[:]...), id[:]...)
+	token = append(token, h.resumeMAC(token)...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func (h *WebsocketHandler) resumeMAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, h.config.resumeSecret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// parseResumeToken returns the session ID and identity of a token signed
+// with the handler's secret.
+func (h *WebsocketHandler) parseResumeToken(token string) (sid, id Identity, ok bool) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != len(sid)+len(id)+sha256.Size {
+		return Identity{}, Identity{}, false
+	}
+	signed := data[:len(sid)+len(id)]
+	if !hmac.Equal(data[len(signed):], h.resumeMAC(signed)) {
+		return Identity{}, Identity{}, false
+	}
+	copy(sid[:], signed)
+	copy(id[:], signed[len(sid):])
+	return sid, id, true
+}
+
+// resumeSession claims the session whose token r presents, returning its
+// parked client, or nil when r starts a new session. The identity from the
+// token replaces a zero one from the validator, and must otherwise match.
+func (h *WebsocketHandler) resumeSession(r *http.Request, session *SessionInfo) *Client {
+	token := r.Header.Get(ResumeTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get(resumeTokenParam)
+	}
+	if token == "" {
+		return nil
+	}
+	sid, id, ok := h.parseResumeToken(token)
+	if !ok || (!session.ClientID.IsZero() && session.ClientID != id) {
+		return nil
+	}
+	previous := h.claim(sid)
+	if previous != nil {
+		session.ClientID = id
+	}
+	return previous
+}
+
+// claim removes the session sid from the resumable sessions and returns its
+// parked client. A session that is still connected is closed first and
+// claimed once it has parked.
+func (h *WebsocketHandler) claim(sid Identity) *Client {
+	h.resumeMu.Lock()
+	defer h.resumeMu.Unlock()
+
+	s, ok := h.resumable[sid]
+	if !ok || s.claimed != nil {
+		return nil
+	}
+	if !s.parked {
+		claimed := make(chan struct{})
+		s.claimed = claimed
+		h.resumeMu.Unlock()
+		s.client.Conn.Close()
+		select {
+		case <-claimed:
+		case <-time.After(closeWait):
+		}
+		h.resumeMu.Lock()
+		if h.resumable[sid] != s || !s.parked {
+			if s.claimed == claimed {
+				s.claimed = nil
+			}
+			return nil
+		}
+	}
+	s.timer.Stop()
+	delete(h.resumable, sid)
+	return s.client
+}
+
+// startSession makes client's session resumable.
+func (h *WebsocketHandler) startSession(client *Client) {
+	h.resumeMu.Lock()
+	defer h.resumeMu.Unlock()
+
+	h.resumable[client.resumeID] = &resumableSession{client: client}
+}
+
+// parkable reports whether client's session outlives its connection, which
+// ended with err: the server did not close it, and nor did the client with
+// 1000 (Normal Closure).
+func (h *WebsocketHandler) parkable(client *Client, err error) bool {
+	if client.resumeID.IsZero() || client.closing.Load() != nil {
+		return false
+	}
+	var closeErr *websocket.CloseError
+	return !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure
+}
+
+// suspend parks client's session for the grace period once its connection
+// has ended, reporting false when the session ends with the connection.
+func (h *WebsocketHandler) suspend(client *Client) bool {
+	if client.resumeID.IsZero() {
+		return false
+	}
+
+	h.mu.Lock()
+	closing := h.closing
+	h.mu.Unlock()
+
+	h.resumeMu.Lock()
+	defer h.resumeMu.Unlock()
+
+	s, ok := h.resumable[client.resumeID]
+	if !ok || s.client != client {
+		return false
+	}
+	if s.claimed != nil {
+		close(s.claimed)
+	}
+	if closing || !client.parked.Load() {
+		delete(h.resumable, client.resumeID)
+		return false
+	}
+	s.parked = true
+	s.timer = h.config.clock.AfterFunc(h.config.resumeGrace, func() { h.expire(client) })
+	return true
+}
+
+// expire ends client's parked session, if it has not been resumed.
+func (h *WebsocketHandler) expire(client *Client) {
+	h.resumeMu.Lock()
+	s, ok := h.resumable[client.resumeID]
+	if !ok || s.client != client || !s.parked {
+		h.resumeMu.Unlock()
+		return
+	}
+	s.timer.Stop()
+	delete(h.resumable, client.resumeID)
+	h.resumeMu.Unlock()
+
+	h.endSession(client, client.reason)
+}
+
+// expireAll ends every parked session.
+func (h *WebsocketHandler) expireAll() {
+	h.resumeMu.Lock()
+	var parked []*Client
+	for _, s := range h.resumable {
+		if s.parked {
+			parked = append(parked, s.client)
+		}
+	}
+	h.resumeMu.Unlock()
+
+	for _, client := range parked {
+		h.expire(client)
+	}
+}
+
+// resume hands the session of previous, claimed by the upgrade, over to
+// client before it is registered: its send queue, with the frames queued
+// while it was parked, its sequence numbers, metadata, tags and
+// slow-consumer policy. The hub memberships move when it is registered.
+func (c *Client) resume(previous *Client) {
+	c.resumeID = previous.resumeID
+	c.queue, c.Send = previous.queue, previous.Send
+	c.sequence = previous.sequence
+
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+	for key, value := range previous.metadata {
+		c.metadata[key] = value
+	}
+	for tag := range previous.tags {
+		c.tags[tag] = struct{}{}
+	}
+	c.policy = previous.policy
+}
+
+// endSession unregisters client once its session has ended, discarding
+// anything still queued for it, and reports the end to the hooks.
+func (h *WebsocketHandler) endSession(client *Client, err error) {
+	client.parked.Store(false)
+	h.Unregister(client)
+	client.drainQueue()
+	h.logClosed(client, err)
+	h.observeDisconnect(client, err)
+	if h.config.onDisconnect != nil {
+		h.runHook(client, func() { h.config.onDisconnect(client, err) })
+	}
+}
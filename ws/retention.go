@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RetentionResult reports how many envelopes one retention pass removed.
+type RetentionResult struct {
+	Delivered int `json:"delivered"`
+	Expired   int `json:"expired"`
+}
+
+// RetentionJanitor periodically deletes envelopes that no longer need to be
+// kept: those confirmed as delivered longer ago than the retention window,
+// and undelivered ones whose ExpiresAt has passed. Each purge runs only when
+// the persister implements the matching DeliveredPurger or ExpiredPurger
+// interface.
+type RetentionJanitor struct {
+	persister EnvelopePersister
+	interval  time.Duration
+	retention time.Duration
+	clock     Clock
+	onPurge   func(result RetentionResult, err error)
+
+	mu      sync.Mutex
+	timer   Timer
+	running bool
+}
+
+// RetentionOption configures a RetentionJanitor.
+type RetentionOption func(*RetentionJanitor)
+
+// WithRetentionClock replaces the clock that schedules passes and decides
+// which envelopes are old enough to purge.
+func WithRetentionClock(clock Clock) RetentionOption {
+	return func(j *RetentionJanitor) {
+		j.clock = clock
+	}
+}
+
+// WithRetentionHook registers fn to run after every scheduled pass with the
+// number of envelopes purged and any error the persister returned.
+func WithRetentionHook(fn func(result RetentionResult, err error)) RetentionOption {
+	return func(j *RetentionJanitor) {
+		j.onPurge = fn
+	}
+}
+
+// NewRetentionJanitor returns a janitor that purges persister every interval,
+// keeping delivered envelopes for retention. Call Start to begin.
+func NewRetentionJanitor(persister EnvelopePersister, interval, retention time.Duration, opts ...RetentionOption) *RetentionJanitor {
+	j := &RetentionJanitor{
+		persister: persister,
+		interval:  interval,
+		retention: retention,
+		clock:     systemClock{},
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Start schedules a pass every interval. Starting a running janitor is a
+// no-op.
+func (j *RetentionJanitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.running || j.interval <= 0 {
+		return
+	}
+	j.running = true
+	j.scheduleLocked()
+}
+
+// Stop cancels the next scheduled pass. A pass already in progress finishes
+// but is not rescheduled.
+func (j *RetentionJanitor) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.running = false
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+}
+
+func (j *RetentionJanitor) scheduleLocked() {
+	j.timer = j.clock.AfterFunc(j.interval, func() {
+		result, err := j.RunOnce()
+		if j.onPurge != nil {
+			j.onPurge(result, err)
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.running {
+			j.scheduleLocked()
+		}
+	})
+}
+
+// RunOnce purges immediately, deleting envelopes delivered before the
+// retention window and undelivered envelopes that have expired. Both purges
+// are attempted even if the first fails.
+func (j *RetentionJanitor) RunOnce() (RetentionResult, error) {
+	now := j.clock.Now()
+
+	var result RetentionResult
+	var errs []error
+	if purger, ok := j.persister.(DeliveredPurger); ok {
+		n, err := purger.PurgeDelivered(now.Add(-j.retention))
+		result.Delivered = n
+		errs = append(errs, err)
+	}
+	if purger, ok := j.persister.(ExpiredPurger); ok {
+		n, err := purger.PurgeExpired(now)
+		result.Expired = n
+		errs = append(errs, err)
+	}
+	return result, errors.Join(errs...)
+}
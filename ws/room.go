@@ -0,0 +1,156 @@
+package ws
+
+// room is a named group of clients. Rooms are created on first join and
+// removed from the hub as soon as their last member leaves. identities
+// counts the connections each member identity has in the room.
+type room struct {
+	members    map[*Client]struct{}
+	identities map[Identity]int
+}
+
+// Join adds client to the named room, creating the room if needed. Only
+// registered clients can join; others get ErrClientNotConnected. With a
+// bridge, the first local join subscribes to the room's topic; if that
+// fails the client is still a member, but Join returns the error.
+func (h *Hub) Join(roomName string, client *Client) error {
+	change, err := h.join(roomName, client)
+	if err != nil {
+		return err
+	}
+	h.announcePresence(change)
+	return h.subscribeRoom(roomName)
+}
+
+// join adds client to the named room. It returns nil, and no error, when
+// client was already a member.
+func (h *Hub) join(roomName string, client *Client) (*roomChange, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Unregister clears client.hub with the lock held, so a client
+	// cannot join rooms once it has left them all.
+	if client.registeredHub() != h {
+		return nil, ErrClientNotConnected
+	}
+
+	r, ok := h.rooms[roomName]
+	if !ok {
+		r = &room{members: make(map[*Client]struct{}), identities: make(map[Identity]int)}
+		h.rooms[roomName] = r
+	}
+	if h.clientRooms[client] == nil {
+		h.clientRooms[client] = make(map[string]struct{})
+	}
+	h.clientRooms[client][roomName] = struct{}{}
+
+	if _, member := r.members[client]; member {
+		return nil, nil
+	}
+	r.members[client] = struct{}{}
+	r.identities[client.ID]++
+	h.observeJoinLocked(roomName, client)
+	return h.roomChangeLocked(roomName, r, client, PresenceJoin), nil
+}
+
+// Leave removes client from the named room. Leaving a room the client is not
+// in is a no-op.
+func (h *Hub) Leave(roomName string, client *Client) {
+	h.mu.Lock()
+	change := h.leaveLocked(roomName, client)
+	h.mu.Unlock()
+
+	h.announcePresence(change)
+}
+
+// leaveLocked removes client from the named room, returning nil when it was
+// not a member.
+func (h *Hub) leaveLocked(roomName string, client *Client) *roomChange {
+	if rooms, ok := h.clientRooms[client]; ok {
+		delete(rooms, roomName)
+		if len(rooms) == 0 {
+			delete(h.clientRooms, client)
+		}
+	}
+
+	r, ok := h.rooms[roomName]
+	if !ok {
+		return nil
+	}
+	if _, member := r.members[client]; !member {
+		return nil
+	}
+	delete(r.members, client)
+	if r.identities[client.ID]--; r.identities[client.ID] == 0 {
+		delete(r.identities, client.ID)
+	}
+	h.observeLeaveLocked(roomName, client)
+	change := h.roomChangeLocked(roomName, r, client, PresenceLeave)
+	if len(r.members) == 0 {
+		delete(h.rooms, roomName)
+	}
+	return change
+}
+
+// leaveAllLocked removes client from every room it has joined, returning
+// the changes to announce.
+func (h *Hub) leaveAllLocked(client *Client) []*roomChange {
+	var changes []*roomChange
+	for roomName := range h.clientRooms[client] {
+		changes = append(changes, h.leaveLocked(roomName, client))
+	}
+	return changes
+}
+
+// RoomMembers returns the identities of the clients in the named room.
+func (h *Hub) RoomMembers(roomName string) []Identity {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.rooms[roomName]
+	if !ok {
+		return nil
+	}
+
+	members := make([]Identity, 0, len(r.identities))
+	for id := range r.identities {
+		members = append(members, id)
+	}
+	return members
+}
+
+// BroadcastToRoom queues data for every member of the named room, skipping
+// members whose send queue is full just like Broadcast. With a bridge, the
+// room's members on other nodes get it too.
+func (h *Hub) BroadcastToRoom(roomName string, data []byte) BroadcastResult {
+	result := h.broadcastToRoomLocal(roomName, data)
+	h.forward(RoomTopic(roomName), bridgeMessage{Kind: bridgeRoom, Room: roomName, Data: data})
+	return result
+}
+
+func (h *Hub) broadcastToRoomLocal(roomName string, data []byte) BroadcastResult {
+	var result BroadcastResult
+	for _, client := range h.roomSnapshot(roomName) {
+		if client.enqueue(outbound{data: data, broadcast: true}) == nil {
+			result.Delivered++
+		} else {
+			result.Dropped++
+		}
+	}
+	return result
+}
+
+func (h *Hub) roomSnapshot(roomName string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.rooms[roomName]
+	if !ok {
+		return nil
+	}
+
+	clients := make([]*Client, 0, len(r.members))
+	for client := range r.members {
+		clients = append(clients, client)
+	}
+	return clients
+}
@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// RoomJoinMessageType is the type of the frame clients send to ask to
+	// join a room: {"type":"_room.join","id":"<uuid>","payload":{"room":"lobby"}}.
+	RoomJoinMessageType = "_room.join"
+
+	// RoomLeaveMessageType is the type of the frame clients send to leave a
+	// room: {"type":"_room.leave","id":"<uuid>","payload":{"room":"lobby"}}.
+	RoomLeaveMessageType = "_room.leave"
+)
+
+// RoomAuthorizer decides whether a client may join a room it asked to join
+// with a RoomJoinMessageType frame. Returning a non-nil error denies the
+// join; an *ErrorFrame chooses the code and message of the error frame the
+// client is sent. Joins made on the server with Hub.Join are never checked.
+type RoomAuthorizer interface {
+	CanJoin(client *Client, room string) error
+}
+
+// RoomAuthorizerFunc adapts a function to the RoomAuthorizer interface.
+type RoomAuthorizerFunc func(client *Client, room string) error
+
+func (f RoomAuthorizerFunc) CanJoin(client *Client, room string) error {
+	return f(client, room)
+}
+
+// WithRoomAuthorizer lets clients join rooms themselves, subject to a.
+// Without one, every join a client asks for is denied.
+func WithRoomAuthorizer(a RoomAuthorizer) Option {
+	return func(c *config) {
+		c.roomAuthorizer = a
+	}
+}
+
+// roomRequest is the payload of room join and leave frames.
+type roomRequest struct {
+	Room string `json:"room"`
+}
+
+// RoomAck is the payload of the ack answering a room join or leave. Members
+// counts the identities connected to this node that are in the room once
+// the request has been applied.
+type RoomAck struct {
+	Room    string `json:"room"`
+	Members int    `json:"members"`
+}
+
+// errNoRoomAuthorizer denies client joins on handlers without a
+// RoomAuthorizer.
+var errNoRoomAuthorizer = errors.New("no room authorizer configured")
+
+// handleRoomRequest applies a room join or leave frame. Joins are checked
+// with the handler's RoomAuthorizer, and a denied join is answered with a
+// "unauthorized" error frame, or the *ErrorFrame the authorizer returned.
+// Leaving a room the client is not in is acked like any other leave.
+func (h *WebsocketHandler) handleRoomRequest(client *Client, envelope Envelope) {
+	ref := envelope.ID.String()
+	var req roomRequest
+	if err := envelope.DecodePayload(&req); err != nil || req.Room == "" {
+		client.SendError(ErrorCodeInvalidRoom, "payload must name a room", ref)
+		return
+	}
+
+	if envelope.Type == RoomLeaveMessageType {
+		h.Leave(req.Room, client)
+	} else {
+		if err := h.canJoin(client, req.Room); err != nil {
+			h.observeError(client, fmt.Errorf("%w: %q: %w", ErrJoinDenied, req.Room, err))
+			code, message := ErrorCodeUnauthorized, "room join not authorized"
+			var frame *ErrorFrame
+			if errors.As(err, &frame) {
+				code, message = frame.Code, frame.Message
+			}
+			client.SendError(code, message, ref)
+			return
+		}
+		if err := h.Join(req.Room, client); err != nil {
+			h.observeError(client, err)
+			client.SendError(ErrorCodeJoinFailed, "room could not be joined", ref)
+			return
+		}
+	}
+
+	payload, _ := json.Marshal(RoomAck{Room: req.Room, Members: len(h.RoomMembers(req.Room))})
+	client.sendAckPayload(envelope.ID, payload)
+}
+
+func (h *WebsocketHandler) canJoin(client *Client, room string) error {
+	if h.config.roomAuthorizer == nil {
+		return errNoRoomAuthorizer
+	}
+	return h.config.roomAuthorizer.CanJoin(client, room)
+}
@@ -0,0 +1,95 @@
+package ws
+
+// WithRoomHistory pushes the n most recent envelopes broadcast to a room
+// with BroadcastEnvelopeToRoom to each client that joins it, when the
+// handler's persister is a RoomHistoryFetcher. They are sent oldest first,
+// with History set so clients can tell them from live broadcasts. Zero, the
+// default, pushes no history.
+func WithRoomHistory(n int) Option {
+	return func(c *config) {
+		c.roomHistory = n
+	}
+}
+
+// BroadcastEnvelopeToRoom queues a copy of envelope, with Room set to
+// roomName, for every member of the room, each addressed to its own
+// identity in its connection's codec. Members whose send queue is full are
+// skipped just like BroadcastToRoom does. With a bridge, the room's members
+// on other nodes get it too.
+func (h *Hub) BroadcastEnvelopeToRoom(roomName string, envelope Envelope) BroadcastResult {
+	envelope.Room = roomName
+	result := h.broadcastEnvelopeToRoomLocal(roomName, envelope)
+	h.forward(RoomTopic(roomName), bridgeMessage{Kind: bridgeRoomEnvelope, Room: roomName, Envelope: &envelope})
+	return result
+}
+
+func (h *Hub) broadcastEnvelopeToRoomLocal(roomName string, envelope Envelope) BroadcastResult {
+	var result BroadcastResult
+	for _, client := range h.roomSnapshot(roomName) {
+		if client.enqueueCopy(envelope) == nil {
+			result.Delivered++
+		} else {
+			result.Dropped++
+		}
+	}
+	return result
+}
+
+// BroadcastEnvelopeToRoom sets envelope's Room to roomName, persists it once
+// for the room and broadcasts it as Hub.BroadcastEnvelopeToRoom does, so
+// clients joining later can be sent it as history. Ephemeral envelopes are
+// broadcast without being persisted. Nothing is sent if saving fails.
+func (h *WebsocketHandler) BroadcastEnvelopeToRoom(roomName string, envelope Envelope) (BroadcastResult, error) {
+	envelope.Room = roomName
+	if h.EnvelopePersister != nil && !envelope.Ephemeral {
+		if err := h.EnvelopePersister.SaveEnvelope(envelope); err != nil {
+			return BroadcastResult{}, err
+		}
+	}
+	return h.Hub.BroadcastEnvelopeToRoom(roomName, envelope), nil
+}
+
+// Join adds client to the named room as Hub.Join does. With WithRoomHistory,
+// a client that was not already a member is first sent the room's recent
+// history.
+func (h *WebsocketHandler) Join(roomName string, client *Client) error {
+	change, err := h.Hub.join(roomName, client)
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		h.sendRoomHistory(roomName, client)
+	}
+	h.announcePresence(change)
+	return h.subscribeRoom(roomName)
+}
+
+// sendRoomHistory queues the room's recent envelopes for client, skipping
+// expired ones. It stops early if the send queue fills.
+func (h *WebsocketHandler) sendRoomHistory(roomName string, client *Client) {
+	fetcher, ok := h.EnvelopePersister.(RoomHistoryFetcher)
+	if !ok || h.config.roomHistory <= 0 {
+		return
+	}
+
+	now := h.config.clock.Now()
+	envelopes, err := fetcher.FetchRoomHistory(roomName, h.config.roomHistory, now)
+	if err != nil {
+		h.observeError(client, err)
+		return
+	}
+	for _, envelope := range envelopes {
+		if envelope.Expired(now) {
+			continue
+		}
+		envelope.History = true
+		envelope.ClientID = client.ID
+		msg, err := client.envelopeOutbound(envelope)
+		if err != nil {
+			continue
+		}
+		if client.enqueue(msg) != nil {
+			break
+		}
+	}
+}
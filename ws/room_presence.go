@@ -0,0 +1,105 @@
+package ws
+
+// PresenceMessageType is the type of the ephemeral envelopes announcing that
+// someone joined or left a room. Their payload is a PresenceEvent.
+const PresenceMessageType = "_presence"
+
+// Presence actions.
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// PresenceEvent is the payload of a PresenceMessageType envelope. Members
+// counts the identities in the room once Identity has joined or left.
+type PresenceEvent struct {
+	Room     string   `json:"room"`
+	Identity Identity `json:"identity"`
+	Action   string   `json:"action"`
+	Members  int      `json:"members"`
+}
+
+// WithPresenceEvents sets whether the hub announces joins and leaves to the
+// other members of a room. It does by default. An identity with several
+// connections is announced when its first connection joins a room and when
+// its last one leaves it, including by disconnecting. Announcements reach
+// the room's members on this hub only, and SetRoomPresenceEvents overrides
+// this setting for single rooms.
+func WithPresenceEvents(enabled bool) HubOption {
+	return func(c *hubConfig) {
+		c.presence = enabled
+	}
+}
+
+// WithHubPresenceEvents sets whether the handler's hub announces room joins
+// and leaves, as WithPresenceEvents does for NewHub.
+func WithHubPresenceEvents(enabled bool) Option {
+	return func(c *config) {
+		c.noPresenceEvents = !enabled
+	}
+}
+
+// SetRoomPresenceEvents overrides, for the named room only, whether joins
+// and leaves are announced, so busy rooms can go quiet. The setting is kept
+// while the room is empty.
+func (h *Hub) SetRoomPresenceEvents(roomName string, enabled bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.roomPresence[roomName] = enabled
+}
+
+// roomChange records a client joining or leaving a room, so it can be
+// announced once the hub's lock is released. notify holds the members to
+// announce it to, and is empty when there is nothing to announce.
+type roomChange struct {
+	event  PresenceEvent
+	notify []*Client
+}
+
+func (h *Hub) roomChangeLocked(roomName string, r *room, client *Client, action string) *roomChange {
+	change := &roomChange{event: PresenceEvent{
+		Room:     roomName,
+		Identity: client.ID,
+		Action:   action,
+		Members:  len(r.identities),
+	}}
+
+	// Other connections of the identity only matter to the room when
+	// the first joins or the last leaves.
+	connections := r.identities[client.ID]
+	if (action == PresenceJoin && connections != 1) || (action == PresenceLeave && connections != 0) {
+		return change
+	}
+	enabled, ok := h.roomPresence[roomName]
+	if !ok {
+		enabled = h.presence
+	}
+	if !enabled {
+		return change
+	}
+
+	for member := range r.members {
+		if member.ID != client.ID {
+			change.notify = append(change.notify, member)
+		}
+	}
+	return change
+}
+
+// announcePresence sends change to the members it notifies. Members whose
+// send queue is full miss it.
+func (h *Hub) announcePresence(change *roomChange) {
+	if change == nil || len(change.notify) == 0 {
+		return
+	}
+	envelope, err := NewEnvelope(Identity{}, PresenceMessageType, change.event)
+	if err != nil {
+		return
+	}
+	envelope.Room = change.event.Room
+	envelope.Ephemeral = true
+	for _, client := range change.notify {
+		client.enqueueCopy(envelope)
+	}
+}
@@ -0,0 +1,228 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EnvelopeHandlerFunc handles a decoded inbound envelope.
+type EnvelopeHandlerFunc func(client *Client, env Envelope) error
+
+// Router is a MessageHandler that decodes each frame into an Envelope and
+// dispatches it by Envelope.Type to the handler registered for that type.
+// Handlers may be registered at any time, including while serving.
+type Router struct {
+	mu         sync.RWMutex
+	handlers   map[string]EnvelopeHandlerFunc
+	fallback   EnvelopeHandlerFunc
+	authorizer MessageAuthorizer
+
+	// schemas holds the payload schema of each type that has one, and
+	// skipSchemas the types whose validation is switched off.
+	schemas     map[string]*routeSchema
+	skipSchemas map[string]bool
+
+	// maxPayload holds the payload size limit of each type that has one.
+	maxPayload map[string]int
+}
+
+func NewRouter() *Router {
+	return &Router{
+		handlers:    make(map[string]EnvelopeHandlerFunc),
+		schemas:     make(map[string]*routeSchema),
+		skipSchemas: make(map[string]bool),
+		maxPayload:  make(map[string]int),
+	}
+}
+
+// HandleFunc registers fn for envelopes of type msgType, replacing any
+// previous registration.
+func (r *Router) HandleFunc(msgType string, fn EnvelopeHandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = fn
+}
+
+// Fallback registers fn for envelopes whose type has no handler. Without a
+// fallback, unknown types fail with ErrUnknownMessageType.
+func (r *Router) Fallback(fn EnvelopeHandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = fn
+}
+
+// UseAuthorizer checks every envelope with a before it is dispatched.
+// Denied envelopes are rejected with an unauthorized error frame and Handle
+// returns an error wrapping ErrMessageDenied. Use it when the router serves
+// connections without a handler-wide WithMessageAuthorizer, or to apply
+// rules that only concern the routed types.
+func (r *Router) UseAuthorizer(a MessageAuthorizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authorizer = a
+}
+
+// SetSchema validates the payloads of envelopes of type msgType against the
+// JSON Schema in schema before they are dispatched, replacing any previous
+// schema for the type; a nil schema removes it. The schema is compiled
+// straight away, and an error is returned if it is invalid or uses a
+// keyword the router does not support, such as a $ref outside the schema.
+//
+// A payload that fails is answered with a "validation_failed" ErrorFrame
+// whose Errors list each failing field by its dotted path, the handler is
+// not called, and Handle returns an error wrapping ErrInvalidPayload:
+//
+//	{"type": "_error", "code": "validation_failed", "message": "payload does not match the schema", "ref": "<envelope-id>", "field": "items.0.qty", "errors": [{"field": "items.0.qty", "message": "must be at least 1"}]}
+func (r *Router) SetSchema(msgType string, schema []byte) error {
+	if schema == nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.schemas, msgType)
+		return nil
+	}
+
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return fmt.Errorf("ws: schema for %q: %w", msgType, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[msgType] = &routeSchema{schema: compiled}
+	return nil
+}
+
+// SkipValidation turns schema validation off for msgType when skip is true,
+// keeping its schema, and back on when skip is false.
+func (r *Router) SkipValidation(msgType string, skip bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if skip {
+		r.skipSchemas[msgType] = true
+	} else {
+		delete(r.skipSchemas, msgType)
+	}
+}
+
+// SetMaxPayloadSize limits the payloads of envelopes of type msgType to n
+// bytes. A larger payload is answered with a "too_large" ErrorFrame, the
+// handler is not called, and Handle returns an error wrapping
+// ErrPayloadTooLarge. n of zero or less removes the limit.
+func (r *Router) SetMaxPayloadSize(msgType string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > 0 {
+		r.maxPayload[msgType] = n
+	} else {
+		delete(r.maxPayload, msgType)
+	}
+}
+
+// SchemaStats describes the schema validation of one message type.
+// Validated counts the payloads checked, Failed those that did not
+// conform, and Duration is the total time spent validating them.
+type SchemaStats struct {
+	Validated uint64        `json:"validated"`
+	Failed    uint64        `json:"failed"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// SchemaStats returns the validation counters of each type with a schema.
+func (r *Router) SchemaStats() map[string]SchemaStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := make(map[string]SchemaStats, len(r.schemas))
+	for msgType, s := range r.schemas {
+		stats[msgType] = SchemaStats{
+			Validated: s.validated.Load(),
+			Failed:    s.failed.Load(),
+			Duration:  time.Duration(s.nanos.Load()),
+		}
+	}
+	return stats
+}
+
+type routeSchema struct {
+	schema    *jsonSchema
+	validated atomic.Uint64
+	failed    atomic.Uint64
+	nanos     atomic.Int64
+}
+
+// validate checks env's payload, answering the client when it fails.
+func (s *routeSchema) validate(client *Client, env Envelope) error {
+	start := time.Now()
+	errs := s.schema.validate(env.Payload)
+	s.nanos.Add(int64(time.Since(start)))
+	s.validated.Add(1)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	s.failed.Add(1)
+	data, _ := json.Marshal(ErrorFrame{
+		Type:    ErrorMessageType,
+		Code:    ErrorCodeValidationFailed,
+		Message: "payload does not match the schema",
+		Ref:     env.ID.String(),
+		Field:   errs[0].Field,
+		Errors:  errs,
+	})
+	client.enqueue(outbound{data: data})
+	return fmt.Errorf("%w: %s: %s", ErrInvalidPayload, errs[0].Field, errs[0].Message)
+}
+
+// Handle decodes data into an Envelope and dispatches it. Frames that are not
+// JSON objects fail with ErrMalformedMessage.
+func (r *Router) Handle(client *Client, data []byte) error {
+	env, ok := newInboundEnvelope(client.ID, data)
+	if !ok {
+		return ErrMalformedMessage
+	}
+	return r.handleEnvelope(client, env)
+}
+
+// handleEnvelope dispatches an envelope the read loop has already decoded
+// and persisted, so handlers see the same envelope ID that was saved.
+func (r *Router) handleEnvelope(client *Client, env Envelope) error {
+	r.mu.RLock()
+	fn, ok := r.handlers[env.Type]
+	if !ok {
+		fn = r.fallback
+	}
+	authorizer := r.authorizer
+	schema := r.schemas[env.Type]
+	if r.skipSchemas[env.Type] {
+		schema = nil
+	}
+	maxPayload := r.maxPayload[env.Type]
+	r.mu.RUnlock()
+
+	if authorizer != nil {
+		if err := client.authorize(authorizer, env); err != nil {
+			return err
+		}
+	}
+	if fn == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownMessageType, env.Type)
+	}
+	if maxPayload > 0 && len(env.Payload) > maxPayload {
+		client.SendError(ErrorCodeTooLarge, fmt.Sprintf("payload exceeds %d bytes", maxPayload), env.ID.String())
+		return fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(env.Payload))
+	}
+	if schema != nil {
+		if err := schema.validate(client, env); err != nil {
+			return err
+		}
+	}
+	return fn(client, env)
+}
+
+// envelopeHandler is implemented by message handlers that can consume the
+// envelope decoded by the read loop instead of re-parsing the raw frame.
+type envelopeHandler interface {
+	handleEnvelope(client *Client, env Envelope) error
+}
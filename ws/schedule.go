@@ -0,0 +1,122 @@
+package ws
+
+import "time"
+
+// scheduleLease is how long a promotion claims the envelopes it fetches. An
+// envelope left claimed by a handler that stopped before sending it is sent
+// by a later promotion once the claim lapses.
+const scheduleLease = time.Minute
+
+// WithScheduledDelivery enables SendAt and SendAfter when the handler's
+// persister implements EnvelopeScheduler. Scheduled envelopes are kept by
+// the persister, so they survive a restart, and every interval the handler
+// fetches the ones that have come due and sends them as SendEnvelope does:
+// to the recipient's connections, and to the outbox for replay when the
+// recipient is offline. An envelope is therefore sent up to interval after
+// its time. Promotion stops when the handler is shut down; a handler built
+// on the same persister picks up where it left off. An envelope leaves the
+// schedule only once it has been saved to the outbox, so one caught in a
+// crash is sent a minute later, possibly twice, rather than lost.
+func WithScheduledDelivery(interval time.Duration) Option {
+	return func(c *config) {
+		c.scheduleInterval = interval
+	}
+}
+
+// SendAt schedules env to be sent to clientID at at, setting its ClientID
+// and, when it has none, giving it an ID, which it returns for
+// CancelScheduled. An envelope whose time has already passed is sent at the
+// next promotion. It returns ErrSchedulingDisabled unless the handler was
+// built with WithScheduledDelivery and a persister implementing
+// EnvelopeScheduler.
+func (h *WebsocketHandler) SendAt(clientID Identity, env Envelope, at time.Time) (Identity, error) {
+	if h.scheduler == nil {
+		return Identity{}, ErrSchedulingDisabled
+	}
+	env.ClientID = clientID
+	if env.ID.IsZero() {
+		env.ID = NewIdentity()
+	}
+	if err := h.scheduler.SaveScheduled(env, at); err != nil {
+		return Identity{}, err
+	}
+	return env.ID, nil
+}
+
+// SendAfter schedules env to be sent to clientID once d has elapsed on the
+// handler's clock. See SendAt.
+func (h *WebsocketHandler) SendAfter(d time.Duration, clientID Identity, env Envelope) (Identity, error) {
+	return h.SendAt(clientID, env, h.config.clock.Now().Add(d))
+}
+
+// CancelScheduled cancels the scheduled envelope with id, returning
+// ErrUnknownEnvelope when it is being or has been sent or was never
+// scheduled, and ErrSchedulingDisabled as SendAt does.
+func (h *WebsocketHandler) CancelScheduled(id Identity) error {
+	if h.scheduler == nil {
+		return ErrSchedulingDisabled
+	}
+	return h.scheduler.CancelScheduled(id)
+}
+
+func (h *WebsocketHandler) startSchedule() {
+	h.scheduleMu.Lock()
+	defer h.scheduleMu.Unlock()
+
+	h.scheduleLocked()
+}
+
+func (h *WebsocketHandler) scheduleLocked() {
+	h.scheduleTimer = h.config.clock.AfterFunc(h.config.scheduleInterval, func() {
+		h.promoteDue()
+
+		h.scheduleMu.Lock()
+		defer h.scheduleMu.Unlock()
+		if h.scheduleTimer != nil {
+			h.scheduleLocked()
+		}
+	})
+}
+
+// stopSchedule cancels the next promotion. One already in progress finishes
+// but is not rescheduled.
+func (h *WebsocketHandler) stopSchedule() {
+	h.scheduleMu.Lock()
+	defer h.scheduleMu.Unlock()
+
+	if h.scheduleTimer != nil {
+		h.scheduleTimer.Stop()
+		h.scheduleTimer = nil
+	}
+}
+
+// promoteDue sends the scheduled envelopes that have come due. Envelopes
+// without a Timestamp are stamped with the time they are sent. One that
+// cannot be saved to the outbox is scheduled again for the next promotion;
+// once saved, it is confirmed, and if it cannot be queued it stays in the
+// outbox for replay.
+func (h *WebsocketHandler) promoteDue() {
+	now := h.config.clock.Now()
+	due, err := h.scheduler.FetchDue(now, scheduleLease)
+	if err != nil {
+		return
+	}
+
+	for _, envelope := range due {
+		if envelope.Timestamp.IsZero() {
+			envelope.Timestamp = now
+		}
+		if envelope.Ephemeral {
+			h.scheduler.ConfirmScheduled(envelope.ID)
+			h.Hub.SendEnvelope(envelope)
+			continue
+		}
+		saved, err := h.saveOutbound(envelope)
+		if err != nil {
+			h.scheduler.SaveScheduled(envelope, now)
+			continue
+		}
+		h.scheduler.ConfirmScheduled(envelope.ID)
+		h.Hub.SendEnvelope(saved)
+	}
+}
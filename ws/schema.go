@@ -0,0 +1,704 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is one way a payload failed validation. Field is the dotted
+// path of the offending value, such as "items.0.qty", or empty for the
+// payload itself.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// jsonSchema is a compiled JSON Schema. It supports the validation keywords
+// of draft 2020-12 that apply to a single document: type, enum, const, the
+// numeric, string, array and object assertions, the applicators allOf,
+// anyOf, oneOf, not, if/then/else, properties, patternProperties,
+// additionalProperties, propertyNames, prefixItems and items, and $ref to
+// the root or to the schema's own $defs or definitions. Annotations such as
+// title and format are ignored.
+type jsonSchema struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types []string
+	enum  []any
+	konst *any
+
+	minimum, maximum                   *big.Rat
+	exclusiveMinimum, exclusiveMaximum *big.Rat
+	multipleOf                         *big.Rat
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minItems, maxItems *int
+	uniqueItems        bool
+	prefixItems        []*jsonSchema
+	items              *jsonSchema
+
+	minProperties, maxProperties *int
+	required                     []string
+	dependentRequired            map[string][]string
+	properties                   map[string]*jsonSchema
+	patternProperties            []patternSchema
+	additionalProperties         *jsonSchema
+	propertyNames                *jsonSchema
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+	ifSchema            *jsonSchema
+	thenSchema          *jsonSchema
+	elseSchema          *jsonSchema
+
+	ref    string
+	target *jsonSchema
+}
+
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *jsonSchema
+}
+
+// unsupportedKeywords change what a schema accepts but are not implemented,
+// so schemas using them are refused rather than silently validated more
+// loosely than they say.
+var unsupportedKeywords = []string{
+	"$dynamicRef", "$recursiveRef", "additionalItems", "contains",
+	"dependencies", "dependentSchemas", "maxContains", "minContains",
+	"unevaluatedItems", "unevaluatedProperties",
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// compileSchema parses and compiles a JSON Schema document.
+func compileSchema(data []byte) (*jsonSchema, error) {
+	doc, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	c := &schemaCompiler{defs: make(map[string]*jsonSchema)}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	c.defs["#"] = root
+	for _, s := range c.refs {
+		target, ok := c.defs[s.ref]
+		if !ok {
+			return nil, fmt.Errorf("$ref %q: only references to the root and to $defs or definitions are supported", s.ref)
+		}
+		s.target = target
+	}
+	// A chain of references leading back to where it started would never
+	// reach a value to validate.
+	for _, s := range c.refs {
+		for next := s.target; next != nil; next = next.target {
+			if next == s {
+				return nil, fmt.Errorf("$ref %q refers back to itself", s.ref)
+			}
+		}
+	}
+	return root, nil
+}
+
+type schemaCompiler struct {
+	defs map[string]*jsonSchema
+	refs []*jsonSchema
+}
+
+func (c *schemaCompiler) compile(v any, at string) (*jsonSchema, error) {
+	if b, ok := v.(bool); ok {
+		return &jsonSchema{always: &b}, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", at)
+	}
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := obj[keyword]; ok {
+			return nil, fmt.Errorf("%s: keyword %q is not supported", at, keyword)
+		}
+	}
+
+	s := &jsonSchema{}
+	var err error
+	fail := func(keyword, problem string) error {
+		return fmt.Errorf("%s/%s: %s", at, keyword, problem)
+	}
+
+	for _, defsKeyword := range []string{"$defs", "definitions"} {
+		raw, ok := obj[defsKeyword]
+		if !ok {
+			continue
+		}
+		defs, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fail(defsKeyword, "must be an object")
+		}
+		for name, def := range defs {
+			path := at + "/" + defsKeyword + "/" + name
+			compiled, err := c.compile(def, path)
+			if err != nil {
+				return nil, err
+			}
+			c.defs[path] = compiled
+		}
+	}
+
+	if raw, ok := obj["$ref"]; ok {
+		ref, ok := raw.(string)
+		if !ok {
+			return nil, fail("$ref", "must be a string")
+		}
+		s.ref = ref
+		c.refs = append(c.refs, s)
+	}
+
+	if raw, ok := obj["type"]; ok {
+		switch t := raw.(type) {
+		case string:
+			s.types = []string{t}
+		case []any:
+			for _, e := range t {
+				name, ok := e.(string)
+				if !ok {
+					return nil, fail("type", "must be a string or an array of strings")
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return nil, fail("type", "must be a string or an array of strings")
+		}
+		for _, name := range s.types {
+			if !jsonTypes[name] {
+				return nil, fail("type", fmt.Sprintf("unknown type %q", name))
+			}
+		}
+	}
+	if raw, ok := obj["enum"]; ok {
+		if s.enum, ok = raw.([]any); !ok {
+			return nil, fail("enum", "must be an array")
+		}
+	}
+	if raw, ok := obj["const"]; ok {
+		s.konst = &raw
+	}
+
+	for keyword, dst := range map[string]**big.Rat{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+	} {
+		raw, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		n, ok := jsonNumber(raw)
+		if !ok {
+			return nil, fail(keyword, "must be a number")
+		}
+		*dst = n
+	}
+	if s.multipleOf != nil && s.multipleOf.Sign() <= 0 {
+		return nil, fail("multipleOf", "must be greater than zero")
+	}
+
+	for keyword, dst := range map[string]**int{
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+	} {
+		raw, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		n, ok := jsonNumber(raw)
+		if !ok || !n.IsInt() || n.Sign() < 0 || !n.Num().IsInt64() {
+			return nil, fail(keyword, "must be a non-negative integer")
+		}
+		limit := int(n.Num().Int64())
+		*dst = &limit
+	}
+
+	if raw, ok := obj["pattern"]; ok {
+		pattern, ok := raw.(string)
+		if !ok {
+			return nil, fail("pattern", "must be a string")
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fail("pattern", err.Error())
+		}
+	}
+
+	if raw, ok := obj["uniqueItems"]; ok {
+		if s.uniqueItems, ok = raw.(bool); !ok {
+			return nil, fail("uniqueItems", "must be a boolean")
+		}
+	}
+	if raw, ok := obj["prefixItems"]; ok {
+		if s.prefixItems, err = c.compileList(raw, at+"/prefixItems"); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := obj["items"]; ok {
+		if _, ok := raw.([]any); ok {
+			return nil, fail("items", "must be a schema; use prefixItems for tuples")
+		}
+		if s.items, err = c.compile(raw, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	if raw, ok := obj["required"]; ok {
+		if s.required, ok = stringList(raw); !ok {
+			return nil, fail("required", "must be an array of strings")
+		}
+	}
+	if raw, ok := obj["dependentRequired"]; ok {
+		deps, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fail("dependentRequired", "must be an object")
+		}
+		s.dependentRequired = make(map[string][]string, len(deps))
+		for name, list := range deps {
+			if s.dependentRequired[name], ok = stringList(list); !ok {
+				return nil, fail("dependentRequired", "must map names to arrays of strings")
+			}
+		}
+	}
+	if raw, ok := obj["properties"]; ok {
+		props, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fail("properties", "must be an object")
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = c.compile(prop, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if raw, ok := obj["patternProperties"]; ok {
+		props, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fail("patternProperties", "must be an object")
+		}
+		for pattern, prop := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fail("patternProperties", err.Error())
+			}
+			compiled, err := c.compile(prop, at+"/patternProperties/"+pattern)
+			if err != nil {
+				return nil, err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{pattern: re, schema: compiled})
+		}
+	}
+	for keyword, dst := range map[string]**jsonSchema{
+		"additionalProperties": &s.additionalProperties,
+		"propertyNames":        &s.propertyNames,
+		"not":                  &s.not,
+		"if":                   &s.ifSchema,
+		"then":                 &s.thenSchema,
+		"else":                 &s.elseSchema,
+	} {
+		raw, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		if *dst, err = c.compile(raw, at+"/"+keyword); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, dst := range map[string]*[]*jsonSchema{
+		"allOf": &s.allOf,
+		"anyOf": &s.anyOf,
+		"oneOf": &s.oneOf,
+	} {
+		raw, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		if *dst, err = c.compileList(raw, at+"/"+keyword); err != nil {
+			return nil, err
+		}
+		if len(*dst) == 0 {
+			return nil, fail(keyword, "must not be empty")
+		}
+	}
+	return s, nil
+}
+
+func (c *schemaCompiler) compileList(v any, at string) ([]*jsonSchema, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of schemas", at)
+	}
+	schemas := make([]*jsonSchema, len(list))
+	for i, e := range list {
+		var err error
+		if schemas[i], err = c.compile(e, at+"/"+strconv.Itoa(i)); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// validate checks payload against the schema and returns the ways it
+// fails, if any. A payload that is not JSON fails as a whole.
+func (s *jsonSchema) validate(payload json.RawMessage) []FieldError {
+	if len(payload) == 0 {
+		payload = json.RawMessage("null")
+	}
+	v, err := decodeJSONValue(payload)
+	if err != nil {
+		return []FieldError{{Message: "payload is not valid JSON"}}
+	}
+	var errs []FieldError
+	s.check(v, "", &errs)
+	return errs
+}
+
+// matches reports whether v is valid, without collecting errors.
+func (s *jsonSchema) matches(v any) bool {
+	var errs []FieldError
+	s.check(v, "", &errs)
+	return len(errs) == 0
+}
+
+func (s *jsonSchema) check(v any, path string, errs *[]FieldError) {
+	report := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			report("no value is allowed")
+		}
+		return
+	}
+	if s.target != nil {
+		s.target.check(v, path, errs)
+	}
+
+	if len(s.types) > 0 && !hasJSONType(v, s.types) {
+		report("expected %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(v))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if jsonEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("must be one of the allowed values")
+		}
+	}
+	if s.konst != nil && !jsonEqual(v, *s.konst) {
+		report("must equal %s", compactJSON(*s.konst))
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		s.checkNumber(v, report)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			report("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			report("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("must match the pattern %q", s.pattern.String())
+		}
+	case []any:
+		s.checkArray(v, path, errs, report)
+	case map[string]any:
+		s.checkObject(v, path, errs, report)
+	}
+
+	for _, sub := range s.allOf {
+		sub.check(v, path, errs)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.matches(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			report("must match at least one of the allowed schemas")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			report("must match exactly one of the allowed schemas, matched %d", matched)
+		}
+	}
+	if s.not != nil && s.not.matches(v) {
+		report("must not match the excluded schema")
+	}
+	if s.ifSchema != nil {
+		if s.ifSchema.matches(v) {
+			if s.thenSchema != nil {
+				s.thenSchema.check(v, path, errs)
+			}
+		} else if s.elseSchema != nil {
+			s.elseSchema.check(v, path, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) checkNumber(v json.Number, report func(string, ...any)) {
+	n, ok := jsonNumber(v)
+	if !ok {
+		return
+	}
+	if s.minimum != nil && n.Cmp(s.minimum) < 0 {
+		report("must be at least %s", s.minimum.RatString())
+	}
+	if s.maximum != nil && n.Cmp(s.maximum) > 0 {
+		report("must be at most %s", s.maximum.RatString())
+	}
+	if s.exclusiveMinimum != nil && n.Cmp(s.exclusiveMinimum) <= 0 {
+		report("must be greater than %s", s.exclusiveMinimum.RatString())
+	}
+	if s.exclusiveMaximum != nil && n.Cmp(s.exclusiveMaximum) >= 0 {
+		report("must be less than %s", s.exclusiveMaximum.RatString())
+	}
+	if s.multipleOf != nil && !new(big.Rat).Quo(n, s.multipleOf).IsInt() {
+		report("must be a multiple of %s", s.multipleOf.RatString())
+	}
+}
+
+func (s *jsonSchema) checkArray(v []any, path string, errs *[]FieldError, report func(string, ...any)) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		report("must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		report("must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+	unique:
+		for i := range v {
+			for j := 0; j < i; j++ {
+				if jsonEqual(v[i], v[j]) {
+					report("items %d and %d are equal", j, i)
+					break unique
+				}
+			}
+		}
+	}
+	for i, item := range v {
+		itemPath := joinFieldPath(path, strconv.Itoa(i))
+		if i < len(s.prefixItems) {
+			s.prefixItems[i].check(item, itemPath, errs)
+		} else if s.items != nil {
+			s.items.check(item, itemPath, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) checkObject(v map[string]any, path string, errs *[]FieldError, report func(string, ...any)) {
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		report("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		report("must have at most %d properties", *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, FieldError{Field: joinFieldPath(path, name), Message: "is required"})
+		}
+	}
+	for name, deps := range s.dependentRequired {
+		if _, ok := v[name]; !ok {
+			continue
+		}
+		for _, dep := range deps {
+			if _, ok := v[dep]; !ok {
+				*errs = append(*errs, FieldError{Field: joinFieldPath(path, dep), Message: fmt.Sprintf("is required when %q is present", name)})
+			}
+		}
+	}
+
+	// Properties are checked in order so errors come out the same way
+	// each time.
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, fieldPath := v[name], joinFieldPath(path, name)
+		if s.propertyNames != nil && !s.propertyNames.matches(name) {
+			*errs = append(*errs, FieldError{Field: fieldPath, Message: "property name is not allowed"})
+		}
+		matched := false
+		if prop, ok := s.properties[name]; ok {
+			matched = true
+			prop.check(value, fieldPath, errs)
+		}
+		for _, pp := range s.patternProperties {
+			if pp.pattern.MatchString(name) {
+				matched = true
+				pp.schema.check(value, fieldPath, errs)
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				*errs = append(*errs, FieldError{Field: fieldPath, Message: "unknown field"})
+				continue
+			}
+			s.additionalProperties.check(value, fieldPath, errs)
+		}
+	}
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// decodeJSONValue decodes a single JSON value, keeping numbers exact.
+func decodeJSONValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the value")
+	}
+	return v, nil
+}
+
+func jsonNumber(v any) (*big.Rat, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).SetString(n.String())
+}
+
+func stringList(v any) ([]string, bool) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	strs := make([]string, len(list))
+	for i, e := range list {
+		if strs[i], ok = e.(string); !ok {
+			return nil, false
+		}
+	}
+	return strs, true
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func hasJSONType(v any, types []string) bool {
+	actual := jsonTypeOf(v)
+	for _, t := range types {
+		if t == actual {
+			return true
+		}
+		if t == "integer" && actual == "number" {
+			if n, ok := jsonNumber(v); ok && n.IsInt() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonEqual compares decoded JSON values, treating numbers as equal when
+// their values are, so 1 and 1.0 match.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		an, aok := jsonNumber(a)
+		bn, bok := jsonNumber(b)
+		return aok && bok && an.Cmp(bn) == 0
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func compactJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ResyncMessageType is the type of the frames clients send under
+// WithSequencing to have missed frames replayed:
+// {"type":"_resync","from":<n>}. Like heartbeat echoes they are JSON text
+// frames whatever the connection's codec, and are neither persisted nor
+// handled.
+const ResyncMessageType = "_resync"
+
+const defaultReplayBuffer = 256
+
+// WithSequencing numbers the frames sent to each connection so clients can
+// tell when they missed one, whether the slow-consumer policy or an
+// outbound interceptor dropped it. Every JSON object sent as a text frame,
+// envelopes, broadcasts and error frames alike, gets a "seq" member counting
+// from 1 on each connection:
+//
+//	{"id": "...", "type": "price", "payload": {...}, ..., "seq": 42}
+//
+// A client that sees a gap sends {"type":"_resync","from":<first missing>}
+// and the frames from there on are sent again from the connection's replay
+// buffer, which keeps the last replayBuffer numbered frames; replayed frames
+// keep their numbers, so the client may see some twice. When the first
+// missing frame has already left the buffer the server answers with a
+// snapshot_required error frame instead, and the client should reload its
+// state by other means before carrying on from the next frame it gets.
+//
+// Acks, heartbeats, binary frames and frames queued on Client.Send are not
+// numbered. Numbers belong to the connection: a client that reconnects
+// starts again from 1 and must resync from its own state, unless it resumes
+// its session under WithSessionResumption, which carries the numbering and
+// the replay buffer over. replayBuffer defaults to 256 frames. Sequencing
+// copies each frame per connection, so broadcasts no longer share one
+// PreparedMessage.
+func WithSequencing(replayBuffer int) Option {
+	return func(c *config) {
+		if replayBuffer <= 0 {
+			replayBuffer = defaultReplayBuffer
+		}
+		c.replayBuffer = replayBuffer
+	}
+}
+
+// sequenceState numbers a connection's frames. replay is a ring holding the
+// frame numbered n at index n % len(replay). mu is held from numbering a
+// frame until it is queued, so frames are queued in number order.
+type sequenceState struct {
+	mu     sync.Mutex
+	seq    uint64
+	replay [][]byte
+}
+
+// Seq returns the number of the last frame numbered for the client under
+// WithSequencing, or zero before the first one.
+func (c *Client) Seq() uint64 {
+	c.sequence.mu.Lock()
+	defer c.sequence.mu.Unlock()
+	return c.sequence.seq
+}
+
+// sequenced reports whether msg is numbered when queued.
+func (c *Client) sequenced(msg outbound) bool {
+	if c.handler == nil || c.handler.config.replayBuffer <= 0 || msg.control || msg.closeCode != 0 {
+		return false
+	}
+	if msg.messageType != 0 && msg.messageType != websocket.TextMessage {
+		return false
+	}
+	data := bytes.TrimSpace(msg.data)
+	return len(data) >= 2 && data[0] == '{' && data[len(data)-1] == '}'
+}
+
+// next numbers msg and keeps it for replay. The caller holds the mutex.
+func (s *sequenceState) next(msg outbound, size int) outbound {
+	if s.replay == nil {
+		s.replay = make([][]byte, size)
+	}
+	s.seq++
+	msg.data = appendSeq(msg.data, s.seq)
+	msg.prepared = nil
+	s.replay[s.seq%uint64(len(s.replay))] = msg.data
+	return msg
+}
+
+// appendSeq returns a copy of the JSON object data with a seq member added
+// last, so decoders that keep the last of duplicate members read it.
+func appendSeq(data []byte, seq uint64) []byte {
+	data = bytes.TrimSpace(data)
+	body := bytes.TrimSpace(data[1 : len(data)-1])
+
+	framed := make([]byte, 0, len(data)+32)
+	framed = append(framed, data[:len(data)-1]...)
+	if len(body) > 0 {
+		framed = append(framed, ',')
+	}
+	framed = append(framed, `"seq":`...)
+	framed = strconv.AppendUint(framed, seq, 10)
+	return append(framed, '}')
+}
+
+type resyncFrame struct {
+	Type string `json:"type"`
+	From uint64 `json:"from"`
+}
+
+var resyncMarker = []byte(`"` + ResyncMessageType + `"`)
+
+// resync replays the frames a resync request asks for and reports whether
+// the frame was one, in which case the read loop drops it. The replayed
+// frames are queued before any frame numbered after them.
+func (c *Client) resync(messageType int, message []byte) bool {
+	if c.handler.config.replayBuffer <= 0 || messageType != websocket.TextMessage ||
+		!bytes.Contains(message, resyncMarker) {
+		return false
+	}
+	var frame resyncFrame
+	if err := json.Unmarshal(message, &frame); err != nil || frame.Type != ResyncMessageType {
+		return false
+	}
+
+	s := c.sequence
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := max(frame.From, 1)
+	oldest := uint64(1)
+	if size := uint64(c.handler.config.replayBuffer); s.seq > size {
+		oldest = s.seq - size + 1
+	}
+	if from < oldest {
+		c.enqueue(outbound{
+			data:    newErrorFrame(ErrorCodeSnapshotRequired, fmt.Sprintf("frames before %d are no longer buffered", oldest), ""),
+			control: true,
+		})
+		return true
+	}
+	for seq := from; seq <= s.seq; seq++ {
+		data := s.replay[seq%uint64(len(s.replay))]
+		if c.enqueue(outbound{data: data, control: true}) != nil {
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package ws
+
+import "time"
+
+// CloseSessionExpired is the application close code sent when a
+// connection's session expires and cannot be refreshed.
+const CloseSessionExpired = 4401
+
+// SessionRefresher is implemented by session validators that can revalidate
+// a connection whose session has reached its ExpiresAt. Returning a session
+// with a later ExpiresAt keeps the connection open until then, and one with
+// no ExpiresAt keeps it open indefinitely; returning an error, or a session
+// that has already expired, closes it with CloseSessionExpired.
+type SessionRefresher interface {
+	RefreshSession(client *Client, session SessionInfo) (SessionInfo, error)
+}
+
+// Session returns the session the connection was validated with, or the
+// latest one it was refreshed to.
+func (c *Client) Session() SessionInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// HasRole reports whether the connection's current session was granted
+// role.
+func (c *Client) HasRole(role string) bool {
+	return c.Session().HasRole(role)
+}
+
+// setSession replaces the connection's session, copying its metadata into
+// the client's metadata, and reschedules the expiry check.
+func (c *Client) setSession(session SessionInfo) {
+	c.mu.Lock()
+	c.session = session
+	for key, value := range session.Metadata {
+		c.metadata[key] = value
+	}
+	c.mu.Unlock()
+
+	c.watchSession()
+}
+
+// watchSession schedules the expiry check for the current session, replacing
+// any check already scheduled. Sessions without an ExpiresAt never expire.
+func (c *Client) watchSession() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sessionTimer != nil {
+		c.sessionTimer.Stop()
+		c.sessionTimer = nil
+	}
+	if c.handler == nil || c.session.ExpiresAt.IsZero() {
+		return
+	}
+
+	clock := c.handler.config.clock
+	expiresAt := c.session.ExpiresAt
+	c.sessionTimer = clock.AfterFunc(expiresAt.Sub(clock.Now()), func() {
+		c.sessionExpired(expiresAt)
+	})
+}
+
+// sessionExpired refreshes the session through the handler's validator when
+// it is a SessionRefresher, and otherwise closes the connection.
+func (c *Client) sessionExpired(expiresAt time.Time) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	session := c.Session()
+	if !session.ExpiresAt.Equal(expiresAt) {
+		// The session was refreshed after this check was scheduled.
+		return
+	}
+
+	if refresher, ok := c.handler.SessionValidator.(SessionRefresher); ok {
+		refreshed, err := refresher.RefreshSession(c, session)
+		if err == nil && (refreshed.ExpiresAt.IsZero() || refreshed.ExpiresAt.After(c.handler.config.clock.Now())) {
+			refreshed.ClientID = c.ID
+			c.setSession(refreshed)
+			return
+		}
+	}
+	c.disconnect(DisconnectServerClose, CloseSessionExpired, "session expired")
+}
+
+// stopSessionWatch cancels the pending expiry check, if any.
+func (c *Client) stopSessionWatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sessionTimer != nil {
+		c.sessionTimer.Stop()
+		c.sessionTimer = nil
+	}
+}
@@ -1,14 +1,222 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// SessionInfo describes the session a validator accepted. The handler keeps
+// it on the Client, where message handlers and authorizers read it with
+// Client.Session. The zero value is an anonymous session that never
+// expires. Roles and Claims are shared with every reader and must not be
+// modified once returned by the validator.
 type SessionInfo struct {
 	ClientID Identity
 	Metadata map[string]string
+
+	// Roles are the roles granted to the session, checked with HasRole.
+	Roles []string
+
+	// Claims are typed attributes of the session, such as decoded token
+	// claims, read with ClaimString and ClaimInt.
+	Claims map[string]any
+
+	// ExpiresAt, when set, is when the session stops being valid. The
+	// handler closes the connection at that point unless the validator
+	// implements SessionRefresher and extends it.
+	ExpiresAt time.Time
+}
+
+// HasRole reports whether the session was granted role.
+func (s SessionInfo) HasRole(role string) bool {
+	for _, r := range s.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimString returns the claim key when it is a string, or a []byte or
+// fmt.Stringer convertible to one.
+func (s SessionInfo) ClaimString(key string) (string, bool) {
+	switch v := s.Claims[key].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case fmt.Stringer:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// ClaimInt returns the claim key as an integer. Besides Go integer types it
+// accepts whole float64 values and json.Number, as produced by decoding
+// JSON, and strings holding a decimal integer.
+func (s SessionInfo) ClaimInt(key string) (int64, bool) {
+	switch v := s.Claims[key].(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float32:
+		return floatClaim(float64(v))
+	case float64:
+		return floatClaim(v)
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func floatClaim(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
 }
 
 type SessionValidator interface {
 	Validate(r *http.Request) (SessionInfo, error)
 }
+
+// ValidatorFunc adapts a function to the SessionValidator interface.
+type ValidatorFunc func(r *http.Request) (SessionInfo, error)
+
+func (f ValidatorFunc) Validate(r *http.Request) (SessionInfo, error) {
+	return f(r)
+}
+
+// SessionValidatorFunc is ValidatorFunc under the name of the interface it
+// implements, matching MessageHandlerFunc.
+type SessionValidatorFunc = ValidatorFunc
+
+// AllowAllValidator returns a validator accepting every upgrade as an
+// anonymous session, so each connection gets a fresh identity. It is meant
+// for prototypes and tests.
+func AllowAllValidator() SessionValidator {
+	return ValidatorFunc(func(*http.Request) (SessionInfo, error) {
+		return SessionInfo{}, nil
+	})
+}
+
+// ResponseValidator is an optional extension of SessionValidator for
+// validators that need to shape the HTTP response. When the validator
+// implements it, ValidateWithResponse is called instead of Validate. The
+// returned headers, such as Set-Cookie, are sent with the 101 Switching
+// Protocols response, or with the rejection when err is non-nil.
+type ResponseValidator interface {
+	ValidateWithResponse(r *http.Request) (SessionInfo, http.Header, error)
+}
+
+// ValidationError is returned by a validator to refuse the upgrade with a
+// specific status and body instead of the default plain-text 401
+// Unauthorized. A zero Status means 401 and an empty Body the status text;
+// set Content-Type through the validator's response headers.
+type ValidationError struct {
+	Status int
+	Body   []byte
+
+	// Err, if set, is the underlying reason, reported to the rejection
+	// hook but never sent to the client.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	msg := "ws: session validation failed with status " + strconv.Itoa(e.status())
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) status() int {
+	if e.Status == 0 {
+		return http.StatusUnauthorized
+	}
+	return e.Status
+}
+
+// validate runs the session validator, preferring ValidateWithResponse.
+// With a non-zero deadline the validator sees a request whose context
+// expires then, and validation still running at the deadline fails with
+// ErrHandshakeTimeout whatever the validator returned.
+func (h *WebsocketHandler) validate(r *http.Request, deadline time.Time) (SessionInfo, http.Header, error) {
+	if !deadline.IsZero() {
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	var session SessionInfo
+	var header http.Header
+	var err error
+	if v, ok := h.SessionValidator.(ResponseValidator); ok {
+		session, header, err = v.ValidateWithResponse(r)
+	} else {
+		session, err = h.SessionValidator.Validate(r)
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return SessionInfo{}, nil, ErrHandshakeTimeout
+	}
+	return session, header, err
+}
+
+// rejectSession refuses an upgrade the validator did not accept, honoring a
+// ValidationError's status and body.
+func (h *WebsocketHandler) rejectSession(w http.ResponseWriter, r *http.Request, header http.Header, err error) {
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+
+	status, body := http.StatusUnauthorized, []byte(nil)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		status, body = verr.status(), verr.Body
+	}
+	if len(body) == 0 {
+		http.Error(w, http.StatusText(status), status)
+	} else {
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+
+	h.logRejected(r, status, err)
+	h.observeReject(r, status, err)
+	if h.config.onReject != nil {
+		h.config.onReject(r, status, err)
+	}
+}
@@ -0,0 +1,210 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Sign sets e.Signature to the HMAC-SHA256, under key, of e's ID, ClientID,
+// Type, Payload and Timestamp. Other fields, KeyID included, are not
+// covered, so the handler may set them without breaking the signature.
+//
+// The signed bytes are the five fields in that order, each preceded by its
+// length as a 4-byte big-endian integer: the identities as canonical UUID
+// strings, the payload as compact JSON with object keys sorted ("null" when
+// empty), and the timestamp as decimal Unix milliseconds. The signature is
+// base64url-encoded without padding.
+func (e *Envelope) Sign(key []byte) {
+	e.Signature = base64.RawURLEncoding.EncodeToString(e.mac(key))
+}
+
+// Verify reports whether e.Signature is e's signature under key.
+func (e Envelope) Verify(key []byte) bool {
+	signature, err := base64.RawURLEncoding.DecodeString(e.Signature)
+	if err != nil || e.Signature == "" {
+		return false
+	}
+	return hmac.Equal(signature, e.mac(key))
+}
+
+func (e Envelope) mac(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range [][]byte{
+		[]byte(e.ID.String()),
+		[]byte(e.ClientID.String()),
+		[]byte(e.Type),
+		canonicalPayload(e.Payload),
+		[]byte(strconv.FormatInt(e.Timestamp.UnixMilli(), 10)),
+	} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		mac.Write(size[:])
+		mac.Write(field)
+	}
+	return mac.Sum(nil)
+}
+
+// canonicalPayload re-encodes payload with sorted keys and no insignificant
+// whitespace, so a payload that was decoded and re-encoded on its way, as
+// the MessagePack codec does, still verifies. Payloads that are not JSON are
+// signed as they are.
+func canonicalPayload(payload json.RawMessage) []byte {
+	if len(payload) == 0 {
+		return []byte("null")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return payload
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return payload
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// KeyRing holds the keys envelopes are signed and verified with, by key ID.
+// Envelopes are signed with the current key and verified with whichever key
+// their KeyID names, so keys can be rotated without breaking envelopes
+// signed just before: Rotate to the new key, and Remove the old one once
+// nothing signed with it remains in flight. A KeyRing is safe for concurrent
+// use.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing returns a key ring whose current key is key, with ID kid.
+func NewKeyRing(kid string, key []byte) *KeyRing {
+	return &KeyRing{
+		current: kid,
+		keys:    map[string][]byte{kid: key},
+	}
+}
+
+// Add adds a key that envelopes are verified with but not signed with,
+// such as a peer's next key ahead of a rotation.
+func (r *KeyRing) Add(kid string, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys[kid] = key
+}
+
+// Rotate adds key and makes it the current key. The previous keys still
+// verify envelopes until they are removed.
+func (r *KeyRing) Rotate(kid string, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys[kid] = key
+	r.current = kid
+}
+
+// Remove removes a key, so envelopes signed with it no longer verify. The
+// current key cannot be removed.
+func (r *KeyRing) Remove(kid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if kid != r.current {
+		delete(r.keys, kid)
+	}
+}
+
+// Sign signs e with the current key and sets its KeyID.
+func (r *KeyRing) Sign(e *Envelope) {
+	r.mu.RLock()
+	kid, key := r.current, r.keys[r.current]
+	r.mu.RUnlock()
+
+	e.KeyID = kid
+	e.Sign(key)
+}
+
+// Verify checks e's signature with the key its KeyID names. The error wraps
+// ErrInvalidSignature and says whether the envelope was unsigned, named an
+// unknown key or failed verification.
+func (r *KeyRing) Verify(e Envelope) error {
+	if e.Signature == "" {
+		return fmt.Errorf("%w: envelope is not signed", ErrInvalidSignature)
+	}
+	r.mu.RLock()
+	key, ok := r.keys[e.KeyID]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidSignature, e.KeyID)
+	}
+	if !e.Verify(key) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// WithEnvelopeSigning signs every envelope sent to clients with keys'
+// current key. Each connection's copy is signed as addressed to it, after
+// the handler sets its ClientID.
+func WithEnvelopeSigning(keys *KeyRing) Option {
+	return func(c *config) {
+		c.signingKeys = keys
+	}
+}
+
+// WithEnvelopeVerification requires every envelope clients send, other than
+// acks, to carry a signature that keys verifies. Clients sign with their own
+// identity, from the ClientIDHeader, as ClientID, and send the Timestamp
+// they signed along with the signature and its KeyID:
+//
+//	{"id": "...", "type": "chat", "payload": {...}, "timestamp": "2024-05-01T12:00:00.000Z", "kid": "2024-05", "signature": "..."}
+//
+// Envelopes that fail are rejected with an invalid_signature error frame
+// referencing the envelope ID, and the error, wrapping ErrInvalidSignature,
+// is reported to observers and to WithOnSignatureFailure.
+func WithEnvelopeVerification(keys *KeyRing) Option {
+	return func(c *config) {
+		c.verifyKeys = keys
+	}
+}
+
+// WithOnSignatureFailure registers fn to audit inbound envelopes rejected by
+// WithEnvelopeVerification. It receives the envelope as the client signed
+// it and the verification error.
+func WithOnSignatureFailure(fn func(client *Client, envelope Envelope, err error)) Option {
+	return func(c *config) {
+		c.onSignatureFailure = fn
+	}
+}
+
+// verifySignature checks a decoded inbound envelope against the handler's
+// key ring, rejecting it when verification fails.
+func (h *WebsocketHandler) verifySignature(client *Client, decoded Envelope) bool {
+	signed := decoded
+	signed.ClientID = client.ID
+	err := h.config.verifyKeys.Verify(signed)
+	if err == nil {
+		return true
+	}
+
+	var ref string
+	if !signed.ID.IsZero() {
+		ref = signed.ID.String()
+	}
+	client.SendError(ErrorCodeInvalidSignature, "envelope signature is invalid", ref)
+	h.observeError(client, err)
+	if h.config.onSignatureFailure != nil {
+		h.config.onSignatureFailure(client, signed, err)
+	}
+	return false
+}
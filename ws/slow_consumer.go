@@ -0,0 +1,141 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowConsumerPolicy decides what happens when a message is queued for a
+// client whose send queue is already full. It also governs inbound
+// messages that do not fit in the client's handler queue; see
+// WithExecutionMode.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDropNewest discards the message being queued. It is the
+	// default.
+	SlowConsumerDropNewest SlowConsumerPolicy = iota
+
+	// SlowConsumerDropOldest discards the oldest queued message to make room
+	// for the new one.
+	SlowConsumerDropOldest
+
+	// SlowConsumerClose discards the message and disconnects the client with
+	// close code 1008 (Policy Violation).
+	SlowConsumerClose
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerDropNewest:
+		return "drop-newest"
+	case SlowConsumerDropOldest:
+		return "drop-oldest"
+	case SlowConsumerClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// SetSlowConsumerPolicy overrides the handler's slow-consumer policy for this
+// client.
+func (c *Client) SetSlowConsumerPolicy(policy SlowConsumerPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = &policy
+}
+
+// SlowConsumerPolicy returns the policy applied when the client's send queue
+// is full.
+func (c *Client) SlowConsumerPolicy() SlowConsumerPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.policy != nil {
+		return *c.policy
+	}
+	if c.handler != nil {
+		return c.handler.config.slowConsumerPolicy
+	}
+	return SlowConsumerDropNewest
+}
+
+// Dropped returns how many messages have been discarded for this client
+// because its send queue was full.
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// overflow applies the slow-consumer policy to msg, which did not fit in the
+// queue.
+func (c *Client) overflow(msg outbound) error {
+	switch c.SlowConsumerPolicy() {
+	case SlowConsumerDropOldest:
+		if c.handler != nil && c.handler.config.maxQueuedBytes > 0 && msg.size() > c.handler.config.maxQueuedBytes {
+			// No amount of dropping makes room for it.
+			c.drop()
+			return ErrSendQueueFull
+		}
+		for {
+			dropped := c.dropOldest()
+			if err := c.push(msg); err != ErrSendQueueFull {
+				return err
+			}
+			if !dropped {
+				c.drop()
+				return ErrSendQueueFull
+			}
+
+			select {
+			case <-c.done:
+				return ErrClientNotConnected
+			default:
+			}
+		}
+	case SlowConsumerClose:
+		c.drop()
+		c.closeSlowConsumer()
+		return ErrSendQueueFull
+	default:
+		c.drop()
+		return ErrSendQueueFull
+	}
+}
+
+// closeSlowConsumer disconnects the client with close code 1008 (Policy
+// Violation), once.
+func (c *Client) closeSlowConsumer() {
+	c.closeOnce.Do(func() {
+		c.recordClose(DisconnectServerClose, websocket.ClosePolicyViolation, "slow consumer")
+		go func() {
+			c.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer"),
+				time.Now().Add(closeWait))
+			c.Conn.Close()
+		}()
+	})
+}
+
+// dropOldest discards the oldest queued message, reporting false when the
+// queue was empty.
+func (c *Client) dropOldest() bool {
+	select {
+	case msg := <-c.queue:
+		c.release(msg.size())
+		if msg.closed != nil {
+			close(msg.closed)
+		}
+		c.drop()
+		return true
+	default:
+		return false
+	}
+}
+
+// drop counts a message discarded because the queue was full.
+func (c *Client) drop() {
+	c.dropped.Add(1)
+	c.observeDrop()
+}
@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of handler-wide counters. It marshals to JSON for use
+// on debug endpoints.
+type Stats struct {
+	// Connections is the number of open connections and TotalConnections
+	// the number accepted since the handler was created.
+	Connections      int    `json:"connections"`
+	TotalConnections uint64 `json:"total_connections"`
+
+	// MessagesIn and MessagesOut count data frames read from and written
+	// to clients, and BytesIn and BytesOut their sizes before compression.
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+
+	// Dropped counts messages discarded because a send queue was full.
+	Dropped uint64 `json:"dropped"`
+
+	// Rejected counts refused upgrades, whether the handler or the
+	// upgrader refused them.
+	Rejected uint64 `json:"rejected"`
+
+	// Rooms maps each room to its number of connections.
+	Rooms map[string]int `json:"rooms"`
+
+	// Started is when the handler was created.
+	Started time.Time     `json:"started"`
+	Uptime  time.Duration `json:"uptime_ns"`
+
+	// Shed counts inbound frames discarded by the global rate limit.
+	Shed uint64 `json:"shed"`
+
+	// ShedRate is how many frames the global rate limit shed during the
+	// last complete second.
+	ShedRate float64 `json:"shed_rate"`
+
+	// HandlerTimeouts counts messages that outlasted the handler timeout,
+	// and LateHandlers how many of their handlers are still running.
+	HandlerTimeouts uint64 `json:"handler_timeouts"`
+	LateHandlers    int64  `json:"late_handlers"`
+
+	// ReadBuffers is the number of pooled read buffers held by frames
+	// being read, persisted or handled. See WithReadBufferPooling.
+	ReadBuffers int64 `json:"read_buffers"`
+
+	// QueuedBytes is the size of the frames waiting in every send queue.
+	// BroadcastsShed counts broadcast deliveries shed by
+	// WithMaxQueuedBytesTotal and BroadcastBytesShed their size.
+	QueuedBytes        int64  `json:"queued_bytes"`
+	BroadcastsShed     uint64 `json:"broadcasts_shed"`
+	BroadcastBytesShed uint64 `json:"broadcast_bytes_shed"`
+}
+
+// ClientStats is a snapshot of the counters of an identity's connections.
+// It marshals to JSON for use on debug endpoints.
+type ClientStats struct {
+	ClientID Identity `json:"client_id"`
+
+	// Connections is the number of connections the counters cover, and
+	// Connected when the oldest of them was accepted.
+	Connections  int           `json:"connections"`
+	Connected    time.Time     `json:"connected"`
+	ConnectedFor time.Duration `json:"connected_for_ns"`
+
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+	Dropped     uint64 `json:"dropped"`
+	QueuedBytes int64  `json:"queued_bytes"`
+
+	// RTT is the latest heartbeat round-trip time, the highest among the
+	// connections, and zero without WithHeartbeat.
+	RTT time.Duration `json:"rtt_ns,omitempty"`
+}
+
+// trafficCounters count data frames and their bytes in each direction. They
+// are updated by the read loop and write pump and read from anywhere.
+type trafficCounters struct {
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+func (t *trafficCounters) received(size int) {
+	t.messagesIn.Add(1)
+	t.bytesIn.Add(uint64(size))
+}
+
+func (t *trafficCounters) sent(size int) {
+	t.messagesOut.Add(1)
+	t.bytesOut.Add(uint64(size))
+}
+
+// Stats returns a snapshot of the handler's counters.
+func (h *WebsocketHandler) Stats() Stats {
+	now := h.config.clock.Now()
+	return Stats{
+		Connections:      int(h.open.Load()),
+		TotalConnections: h.opened.Load(),
+		MessagesIn:       h.traffic.messagesIn.Load(),
+		MessagesOut:      h.traffic.messagesOut.Load(),
+		BytesIn:          h.traffic.bytesIn.Load(),
+		BytesOut:         h.traffic.bytesOut.Load(),
+		Dropped:          h.dropped.Load(),
+		Rejected:         h.rejected.Load(),
+		Rooms:            h.Hub.roomSizes(),
+		Started:          h.started,
+		Uptime:           now.Sub(h.started),
+		Shed:             h.shed.Load(),
+		ShedRate:         h.shedRate.rate(now),
+		HandlerTimeouts:  h.timeouts.Load(),
+		LateHandlers:     h.late.Load(),
+		ReadBuffers:      h.readBuffers.Load(),
+
+		QueuedBytes:        h.queuedBytes.Load(),
+		BroadcastsShed:     h.broadcastsShed.Load(),
+		BroadcastBytesShed: h.broadcastBytesShed.Load(),
+	}
+}
+
+// ClientStats returns the counters of id's open connections, summed when it
+// has several. It reports false when id has none.
+func (h *WebsocketHandler) ClientStats(id Identity) (ClientStats, bool) {
+	conns := h.Hub.Connections(id)
+	if len(conns) == 0 {
+		return ClientStats{}, false
+	}
+
+	stats := ClientStats{ClientID: id, Connections: len(conns)}
+	for _, c := range conns {
+		one := c.Stats()
+		if stats.Connected.IsZero() || one.Connected.Before(stats.Connected) {
+			stats.Connected = one.Connected
+			stats.ConnectedFor = one.ConnectedFor
+		}
+		stats.MessagesIn += one.MessagesIn
+		stats.MessagesOut += one.MessagesOut
+		stats.BytesIn += one.BytesIn
+		stats.BytesOut += one.BytesOut
+		stats.Dropped += one.Dropped
+		stats.QueuedBytes += one.QueuedBytes
+		stats.RTT = max(stats.RTT, one.RTT)
+	}
+	return stats, true
+}
+
+// Stats returns the connection's counters.
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		ClientID:     c.ID,
+		Connections:  1,
+		Connected:    c.Connected,
+		ConnectedFor: time.Since(c.Connected),
+		MessagesIn:   c.traffic.messagesIn.Load(),
+		MessagesOut:  c.traffic.messagesOut.Load(),
+		BytesIn:      c.traffic.bytesIn.Load(),
+		BytesOut:     c.traffic.bytesOut.Load(),
+		Dropped:      c.dropped.Load(),
+		QueuedBytes:  c.queuedBytes.Load(),
+		RTT:          c.RTT(),
+	}
+}
+
+// roomSizes returns the number of connections in each room.
+func (h *Hub) roomSizes() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sizes := make(map[string]int, len(h.rooms))
+	for name, r := range h.rooms {
+		sizes[name] = len(r.members)
+	}
+	return sizes
+}
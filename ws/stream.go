@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamRequest asks the write pump for exclusive use of the connection's
+// writer.
+type streamRequest struct {
+	messageType int
+	ready       chan streamGrant
+}
+
+type streamGrant struct {
+	writer  io.WriteCloser
+	release chan struct{}
+	err     error
+}
+
+// streamWriter forwards writes to gorilla's message writer and hands the
+// connection back to the write pump on Close.
+type streamWriter struct {
+	io.WriteCloser
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *streamWriter) Close() error {
+	err := s.WriteCloser.Close()
+	s.once.Do(func() { close(s.release) })
+	return err
+}
+
+// NextWriter returns a writer for a single message of messageType that is
+// streamed to the client as fragmented frames, so large payloads need not be
+// held in memory. The write pump is paused until the writer is closed:
+// messages sent meanwhile are delivered after the streamed message, and
+// outbound interceptors do not see it. The write deadline covers the whole
+// message. Callers must always Close the writer.
+func (c *Client) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+		return nil, ErrInvalidMessageType
+	}
+
+	req := streamRequest{messageType: messageType, ready: make(chan streamGrant, 1)}
+	select {
+	case c.streams <- req:
+	case <-c.done:
+		return nil, ErrClientNotConnected
+	}
+
+	grant := <-req.ready
+	if grant.err != nil {
+		return nil, grant.err
+	}
+	return &streamWriter{WriteCloser: grant.writer, release: grant.release}, nil
+}
+
+// stream hands the connection's writer to a NextWriter caller and waits until
+// it is released. It reports false when the write pump should exit.
+func (c *Client) stream(req streamRequest) bool {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	counter := c.compressStream()
+	writer, err := c.Conn.NextWriter(req.messageType)
+	if err != nil {
+		c.writeErr = err
+		req.ready <- streamGrant{err: err}
+		return false
+	}
+
+	release := make(chan struct{})
+	counting := &countingWriter{WriteCloser: writer, n: counter}
+	req.ready <- streamGrant{writer: counting, release: release}
+	select {
+	case <-release:
+		c.observeSend(counting.written)
+		return true
+	case <-c.done:
+		return false
+	}
+}
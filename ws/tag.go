@@ -0,0 +1,46 @@
+package ws
+
+func (h *Hub) indexTagLocked(tag string, client *Client) {
+	tagged, ok := h.tags[tag]
+	if !ok {
+		tagged = make(map[*Client]struct{})
+		h.tags[tag] = tagged
+	}
+	tagged[client] = struct{}{}
+}
+
+func (h *Hub) unindexTagLocked(tag string, client *Client) {
+	if tagged, ok := h.tags[tag]; ok {
+		delete(tagged, client)
+		if len(tagged) == 0 {
+			delete(h.tags, tag)
+		}
+	}
+}
+
+// ClientsWithTag returns the registered clients labelled with tag.
+func (h *Hub) ClientsWithTag(tag string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	tagged := h.tags[tag]
+	clients := make([]*Client, 0, len(tagged))
+	for client := range tagged {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// BroadcastToTagged queues data for every registered client labelled with
+// tag, using the hub's tag index rather than scanning every client.
+func (h *Hub) BroadcastToTagged(tag string, data []byte) BroadcastResult {
+	var result BroadcastResult
+	for _, client := range h.ClientsWithTag(tag) {
+		if client.enqueue(outbound{data: data, broadcast: true}) == nil {
+			result.Delivered++
+		} else {
+			result.Dropped++
+		}
+	}
+	return result
+}
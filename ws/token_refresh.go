@@ -0,0 +1,53 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AuthRefreshMessageType is the type of the frame clients send to replace
+// their session with a fresh token:
+// {"type":"auth.refresh","id":"<uuid>","payload":{"token":"..."}}.
+// It is reserved only when the handler's SessionValidator implements
+// TokenRefresher; otherwise such frames are handled like any other.
+const AuthRefreshMessageType = "auth.refresh"
+
+// TokenRefresher is implemented by session validators that can validate a
+// token pushed over an open connection, for clients such as browsers that
+// cannot update the credentials used at upgrade. The returned session
+// replaces the connection's session, including its metadata and expiry.
+type TokenRefresher interface {
+	Refresh(client *Client, token string) (SessionInfo, error)
+}
+
+type refreshPayload struct {
+	Token string `json:"token"`
+}
+
+// refreshToken handles an auth.refresh envelope in the read loop, so the
+// refreshed session applies to every frame read after it. On success the
+// frame is acked; a payload without a token is rejected with an error frame.
+// A token the refresher refuses, or one issued for another identity, closes
+// the connection with CloseSessionExpired and the returned error ends the
+// read loop.
+func (h *WebsocketHandler) refreshToken(client *Client, refresher TokenRefresher, envelope Envelope) error {
+	var payload refreshPayload
+	if err := json.Unmarshal(envelope.Payload, &payload); err != nil || payload.Token == "" {
+		client.SendError(ErrorCodeInvalidRefresh, "auth.refresh requires a token", envelope.ID.String())
+		return nil
+	}
+
+	session, err := refresher.Refresh(client, payload.Token)
+	if err == nil && !session.ClientID.IsZero() && session.ClientID != client.ID {
+		err = fmt.Errorf("token issued for %s", session.ClientID)
+	}
+	if err != nil {
+		client.closeWith(CloseSessionExpired, "token refresh failed")
+		return fmt.Errorf("%w: %w", ErrTokenRefreshFailed, err)
+	}
+
+	session.ClientID = client.ID
+	client.setSession(session)
+	client.sendAck(envelope.ID)
+	return nil
+}
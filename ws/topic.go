@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// SubscribeMessageType is the type of the frame clients send to
+	// subscribe to a topic pattern: {"type":"_sub","topic":"orders.eu.*"}.
+	SubscribeMessageType = "_sub"
+
+	// UnsubscribeMessageType is the type of the frame clients send to drop
+	// a subscription: {"type":"_unsub","topic":"orders.eu.*"}.
+	UnsubscribeMessageType = "_unsub"
+
+	// PublishMessageType is the type of the envelopes Publish sends to
+	// subscribers. Their Topic is the topic published to.
+	PublishMessageType = "_pub"
+)
+
+// Topics are dot-separated segments such as "metrics.host42.cpu". In a
+// subscription pattern, a "*" segment matches exactly one segment and a
+// final "#" or ">" segment matches one or more, so "orders.#" matches
+// "orders.eu.created" but not "orders".
+const (
+	topicSeparator    = "."
+	topicSingleWild   = "*"
+	topicMultiWild    = "#"
+	topicMultiWildAlt = ">"
+)
+
+// topicNode is a node of the hub's subscription trie. Each pattern is
+// stored along the path of its segments, wildcards included, so matching a
+// topic walks only the branches that can match it.
+type topicNode struct {
+	children    map[string]*topicNode
+	subscribers map[*Client]struct{}
+}
+
+func (n *topicNode) add(segments []string, client *Client) {
+	for _, segment := range segments {
+		if n.children == nil {
+			n.children = make(map[string]*topicNode)
+		}
+		child, ok := n.children[segment]
+		if !ok {
+			child = &topicNode{}
+			n.children[segment] = child
+		}
+		n = child
+	}
+	if n.subscribers == nil {
+		n.subscribers = make(map[*Client]struct{})
+	}
+	n.subscribers[client] = struct{}{}
+}
+
+// remove drops client's subscription to the pattern made of segments,
+// pruning the nodes left without subscribers or children. It reports
+// whether n itself is left empty.
+func (n *topicNode) remove(segments []string, client *Client) bool {
+	if len(segments) == 0 {
+		delete(n.subscribers, client)
+	} else if child, ok := n.children[segments[0]]; ok && child.remove(segments[1:], client) {
+		delete(n.children, segments[0])
+	}
+	return len(n.subscribers) == 0 && len(n.children) == 0
+}
+
+// match adds the subscribers of every pattern matching the topic made of
+// segments to matched.
+func (n *topicNode) match(segments []string, matched map[*Client]struct{}) {
+	if len(segments) == 0 {
+		for client := range n.subscribers {
+			matched[client] = struct{}{}
+		}
+		return
+	}
+	if multi, ok := n.children[topicMultiWild]; ok {
+		for client := range multi.subscribers {
+			matched[client] = struct{}{}
+		}
+	}
+	if child, ok := n.children[segments[0]]; ok {
+		child.match(segments[1:], matched)
+	}
+	if single, ok := n.children[topicSingleWild]; ok {
+		single.match(segments[1:], matched)
+	}
+}
+
+// parseTopic splits topic into its segments, failing with ErrInvalidTopic
+// for empty segments and misplaced wildcards. Wildcards are only allowed
+// when pattern is set, and ">" is normalised to "#".
+func parseTopic(topic string, pattern bool) ([]string, error) {
+	if topic == "" {
+		return nil, ErrInvalidTopic
+	}
+	segments := strings.Split(topic, topicSeparator)
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+			return nil, ErrInvalidTopic
+		case segment == topicMultiWild || segment == topicMultiWildAlt:
+			if !pattern || i != len(segments)-1 {
+				return nil, ErrInvalidTopic
+			}
+			segments[i] = topicMultiWild
+		case segment == topicSingleWild:
+			if !pattern {
+				return nil, ErrInvalidTopic
+			}
+		case strings.ContainsAny(segment, topicSingleWild+topicMultiWild+topicMultiWildAlt):
+			return nil, ErrInvalidTopic
+		}
+	}
+	return segments, nil
+}
+
+// Subscribe subscribes client to the topics matching pattern. Only
+// registered clients can subscribe; others get ErrClientNotConnected.
+// Subscriptions end when the client unregisters. Subscribing twice to the
+// same pattern is a no-op.
+func (h *Hub) Subscribe(client *Client, pattern string) error {
+	segments, err := parseTopic(pattern, true)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client.registeredHub() != h {
+		return ErrClientNotConnected
+	}
+	h.topics.add(segments, client)
+	if h.clientTopics[client] == nil {
+		h.clientTopics[client] = make(map[string]struct{})
+	}
+	h.clientTopics[client][strings.Join(segments, topicSeparator)] = struct{}{}
+	return nil
+}
+
+// Unsubscribe drops client's subscription to pattern. Dropping a
+// subscription the client does not have is a no-op.
+func (h *Hub) Unsubscribe(client *Client, pattern string) error {
+	segments, err := parseTopic(pattern, true)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unsubscribeLocked(client, segments)
+	return nil
+}
+
+func (h *Hub) unsubscribeLocked(client *Client, segments []string) {
+	h.topics.remove(segments, client)
+	if patterns, ok := h.clientTopics[client]; ok {
+		delete(patterns, strings.Join(segments, topicSeparator))
+		if len(patterns) == 0 {
+			delete(h.clientTopics, client)
+		}
+	}
+}
+
+// unsubscribeAllLocked drops every subscription client holds.
+func (h *Hub) unsubscribeAllLocked(client *Client) {
+	for pattern := range h.clientTopics[client] {
+		h.unsubscribeLocked(client, strings.Split(pattern, topicSeparator))
+	}
+}
+
+// Subscriptions returns the patterns client is subscribed to, sorted, with
+// ">" written as "#".
+func (h *Hub) Subscriptions(client *Client) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	patterns := make([]string, 0, len(h.clientTopics[client]))
+	for pattern := range h.clientTopics[client] {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Publish sends an ephemeral envelope of type PublishMessageType, with
+// payload encoded as JSON and Topic set to topic, to every client
+// subscribed to a pattern matching topic. A client whose patterns overlap
+// gets it once. Topics may not contain wildcards. With a bridge, the
+// subscribers on other nodes get it too.
+func (h *Hub) Publish(topic string, payload any) (BroadcastResult, error) {
+	if _, err := parseTopic(topic, false); err != nil {
+		return BroadcastResult{}, err
+	}
+	envelope, err := NewEnvelope(Identity{}, PublishMessageType, payload)
+	if err != nil {
+		return BroadcastResult{}, err
+	}
+	envelope.Topic = topic
+	envelope.Ephemeral = true
+
+	result := h.publishLocal(envelope)
+	h.forward(BridgeTopic, bridgeMessage{Kind: bridgePublish, Envelope: &envelope})
+	return result, nil
+}
+
+func (h *Hub) publishLocal(envelope Envelope) BroadcastResult {
+	segments, err := parseTopic(envelope.Topic, false)
+	if err != nil {
+		return BroadcastResult{}
+	}
+
+	matched := make(map[*Client]struct{})
+	h.mu.RLock()
+	h.topics.match(segments, matched)
+	h.mu.RUnlock()
+
+	var result BroadcastResult
+	for client := range matched {
+		if client.enqueueCopy(envelope) == nil {
+			result.Delivered++
+		} else {
+			result.Dropped++
+		}
+	}
+	return result
+}
+
+// handleSubscription applies a _sub or _unsub frame, answering with an
+// invalid_topic error frame when its pattern is not valid and acking it
+// when the client identified it.
+func (h *WebsocketHandler) handleSubscription(client *Client, envelope Envelope, identified bool) {
+	var err error
+	if envelope.Type == SubscribeMessageType {
+		err = h.Subscribe(client, envelope.Topic)
+	} else {
+		err = h.Unsubscribe(client, envelope.Topic)
+	}
+
+	ref := ""
+	if identified {
+		ref = envelope.ID.String()
+	}
+	switch {
+	case err == ErrInvalidTopic:
+		client.SendError(ErrorCodeInvalidTopic, "topic pattern is not valid", ref)
+	case err != nil:
+		client.SendError(ErrorCodeSubscribeFailed, "subscription could not be changed", ref)
+	case ref != "":
+		client.sendAck(envelope.ID)
+	}
+}
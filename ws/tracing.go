@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageHandleSpan is the name of the span a handler configured with
+// WithTracerProvider starts for every inbound message.
+const MessageHandleSpan = "ws.message.handle"
+
+// Attributes of the message spans.
+const (
+	AttrClientID    = attribute.Key("ws.client_id")
+	AttrMessageType = attribute.Key("ws.message.type")
+	AttrMessageSize = attribute.Key("ws.message.size")
+	AttrRooms       = attribute.Key("ws.rooms")
+)
+
+const tracerName = "github.com/oduortoni/websocket/ws"
+
+// traceContext is the W3C Trace Context propagator. Envelopes carry its
+// traceparent and tracestate headers as fields of their own.
+var traceContext = propagation.TraceContext{}
+
+// WithTracerProvider traces message handling with tracers from tp. Every
+// inbound message gets a ws.message.handle span, continuing the trace named
+// by the envelope's traceparent when it has one, which the message handler
+// can extend through client.TraceContext. Without this option nothing is
+// traced; the global tracer provider is never used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// envelopeCarrier exposes an envelope's trace fields to a propagator.
+type envelopeCarrier struct {
+	envelope *Envelope
+}
+
+func (c envelopeCarrier) Get(key string) string {
+	switch key {
+	case "traceparent":
+		return c.envelope.TraceParent
+	case "tracestate":
+		return c.envelope.TraceState
+	}
+	return ""
+}
+
+func (c envelopeCarrier) Set(key, value string) {
+	switch key {
+	case "traceparent":
+		c.envelope.TraceParent = value
+	case "tracestate":
+		c.envelope.TraceState = value
+	}
+}
+
+func (envelopeCarrier) Keys() []string {
+	return []string{"traceparent", "tracestate"}
+}
+
+// injectTrace stamps envelope with the span in ctx, unless it already
+// names a trace of its own.
+func injectTrace(ctx context.Context, envelope *Envelope) {
+	if envelope.TraceParent != "" || !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	traceContext.Inject(ctx, envelopeCarrier{envelope})
+}
+
+// startSpan starts the span for a message from client, a child of the trace
+// envelope names when it is not nil, and makes it the client's trace
+// context until end is called. It returns ctx, the client's Context with
+// the span, and a no-op end when tracing is off.
+func (h *WebsocketHandler) startSpan(client *Client, envelope *Envelope, size int) (ctx context.Context, end func(error)) {
+	if h.config.tracer == nil {
+		return client.Context(), func(error) {}
+	}
+
+	ctx = client.Context()
+	msgType := ""
+	if envelope != nil {
+		ctx = traceContext.Extract(ctx, envelopeCarrier{envelope})
+		msgType = envelope.Type
+	}
+	attrs := []attribute.KeyValue{
+		AttrClientID.String(client.ID.String()),
+		AttrMessageType.String(msgType),
+		AttrMessageSize.Int(size),
+	}
+	if rooms := h.Hub.roomsOf(client); len(rooms) > 0 {
+		attrs = append(attrs, AttrRooms.StringSlice(rooms))
+	}
+	ctx, span := h.config.tracer.Start(ctx, MessageHandleSpan,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+
+	previous := client.swapTrace(ctx)
+	return ctx, func(err error) {
+		client.swapTrace(previous)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// TraceContext returns the client's Context extended with the span of the
+// message the client's handler is processing, so work done on the message's
+// behalf joins its trace. Outside message handling, or when tracing is off,
+// it returns the client's Context.
+func (c *Client) TraceContext() context.Context {
+	if ctx := c.trace.Load(); ctx != nil {
+		return *ctx
+	}
+	return c.Context()
+}
+
+func (c *Client) swapTrace(ctx context.Context) context.Context {
+	var next *context.Context
+	if ctx != nil {
+		next = &ctx
+	}
+	if previous := c.trace.Swap(next); previous != nil {
+		return *previous
+	}
+	return nil
+}
+
+// roomsOf returns the names of the rooms client is in, sorted.
+func (h *Hub) roomsOf(client *Client) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	rooms := make([]string, 0, len(h.clientRooms[client]))
+	for name := range h.clientRooms[client] {
+		rooms = append(rooms, name)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
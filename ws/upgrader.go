@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgraderConflicts reports the options that contradict an upgrader
+// injected with WithUpgrader.
+func (c *config) upgraderConflicts() error {
+	if c.upgrader == nil {
+		return nil
+	}
+
+	var errs []error
+	if c.checkOrigin != nil {
+		errs = append(errs, fmt.Errorf("%w: origin checks belong in the upgrader's CheckOrigin", ErrUpgraderConflict))
+	}
+	if c.strictSubprotocols {
+		errs = append(errs, fmt.Errorf("%w: strict subprotocols", ErrUpgraderConflict))
+	}
+	if c.compression && !c.upgrader.EnableCompression {
+		errs = append(errs, fmt.Errorf("%w: compression is not enabled on the upgrader", ErrUpgraderConflict))
+	}
+	for _, name := range c.subprotocols {
+		if !offers(c.upgrader, name) {
+			errs = append(errs, fmt.Errorf("%w: subprotocol %q is not offered by the upgrader", ErrUpgraderConflict, name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func offers(u *websocket.Upgrader, name string) bool {
+	for _, offered := range u.Subprotocols {
+		if offered == name {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrader returns the upgrader for a request whose handshake must finish
+// by deadline, or the one injected with WithUpgrader.
+func (h *WebsocketHandler) upgrader(deadline time.Time) *websocket.Upgrader {
+	if h.config.upgrader != nil {
+		return h.config.upgrader
+	}
+
+	u := &websocket.Upgrader{
+		ReadBufferSize:    h.config.ReadBufferSize,
+		WriteBufferSize:   h.config.WriteBufferSize,
+		Subprotocols:      h.config.subprotocols,
+		EnableCompression: h.config.compression,
+		// The origin has already been checked so that refusals reach the
+		// rejection hook.
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	if !deadline.IsZero() {
+		u.HandshakeTimeout = time.Until(deadline)
+	}
+	return u
+}
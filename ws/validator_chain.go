@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+)
+
+// HaltChain marks err as final for a ValidatorChain: the chain rejects the
+// upgrade with it rather than trying the remaining validators. Errors
+// matching ErrBanned halt the chain without being marked.
+func HaltChain(err error) error {
+	return &haltError{err}
+}
+
+type haltError struct {
+	err error
+}
+
+func (e *haltError) Error() string { return e.err.Error() }
+func (e *haltError) Unwrap() error { return e.err }
+
+// ValidatorChain returns a validator trying validators in order, for
+// endpoints accepting several kinds of credentials. The first session a
+// validator accepts is used and later validators are not called. When every
+// validator fails, the rejection joins their errors and takes its status
+// and body from the last ValidationError with a status other than 401,
+// falling back to the last ValidationError; a failure marked with HaltChain,
+// or matching ErrBanned, rejects the upgrade at once with a 403 unless it
+// carries its own status.
+//
+// The chain is a ResponseValidator: the accepted validator's headers are
+// sent with the upgrade, and the headers of every failed validator with a
+// rejection. It does not implement SessionRefresher or TokenRefresher.
+func ValidatorChain(validators ...SessionValidator) SessionValidator {
+	return validatorChain(validators)
+}
+
+type validatorChain []SessionValidator
+
+func (c validatorChain) Validate(r *http.Request) (SessionInfo, error) {
+	session, _, err := c.ValidateWithResponse(r)
+	return session, err
+}
+
+func (c validatorChain) ValidateWithResponse(r *http.Request) (SessionInfo, http.Header, error) {
+	var errs []error
+	var rejection *ValidationError
+	header := make(http.Header)
+	for _, v := range c {
+		var session SessionInfo
+		var h http.Header
+		var err error
+		if rv, ok := v.(ResponseValidator); ok {
+			session, h, err = rv.ValidateWithResponse(r)
+		} else {
+			session, err = v.Validate(r)
+		}
+		if err == nil {
+			return session, h, nil
+		}
+
+		for key, values := range h {
+			header[key] = append(header[key], values...)
+		}
+		var halt *haltError
+		if errors.As(err, &halt) || errors.Is(err, ErrBanned) {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				err = &ValidationError{Status: http.StatusForbidden, Err: err}
+			}
+			return SessionInfo{}, header, err
+		}
+
+		errs = append(errs, err)
+		var verr *ValidationError
+		if errors.As(err, &verr) && (rejection == nil || verr.status() != http.StatusUnauthorized || rejection.status() == http.StatusUnauthorized) {
+			rejection = verr
+		}
+	}
+
+	err := &ValidationError{Err: errors.Join(errs...)}
+	if rejection != nil {
+		err.Status, err.Body = rejection.Status, rejection.Body
+	}
+	return SessionInfo{}, header, err
+}
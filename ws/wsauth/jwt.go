@@ -0,0 +1,426 @@
+// Package wsauth provides ready-made ws.SessionValidator implementations.
+//
+//	validator := wsauth.NewJWTValidator(wsauth.StaticKey(secret),
+//		wsauth.WithIssuer("https://auth.example"),
+//		wsauth.WithRolesClaim("roles"),
+//		wsauth.WithClaims("tenant"))
+//	handler := ws.NewWebSocketHandler(validator, messages, persister)
+package wsauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oduortoni/websocket/ws"
+)
+
+var (
+	// ErrTokenMissing is returned when the request carries no token in any
+	// of the validator's sources.
+	ErrTokenMissing = errors.New("wsauth: token missing")
+
+	// ErrTokenMalformed is returned for tokens that are not three base64url
+	// segments holding a JSON header and claims.
+	ErrTokenMalformed = errors.New("wsauth: token malformed")
+
+	// ErrUnsupportedAlgorithm is returned when the token's alg is unknown,
+	// not allowed, or does not match the type of the key verifying it.
+	ErrUnsupportedAlgorithm = errors.New("wsauth: unsupported signing algorithm")
+
+	// ErrSignatureInvalid is returned when the signature does not verify.
+	ErrSignatureInvalid = errors.New("wsauth: token signature invalid")
+
+	// ErrTokenExpired is returned once the token's exp has passed.
+	ErrTokenExpired = errors.New("wsauth: token expired")
+
+	// ErrTokenNotValidYet is returned before the token's nbf.
+	ErrTokenNotValidYet = errors.New("wsauth: token not valid yet")
+
+	// ErrInvalidIssuer is returned when iss is not the required issuer.
+	ErrInvalidIssuer = errors.New("wsauth: token issuer invalid")
+
+	// ErrInvalidAudience is returned when aud does not name the required
+	// audience.
+	ErrInvalidAudience = errors.New("wsauth: token audience invalid")
+
+	// ErrInvalidSubject is returned when sub is missing or not a string.
+	ErrInvalidSubject = errors.New("wsauth: token subject invalid")
+)
+
+// Header is the JOSE header of a token, passed to the Keyfunc so it can pick
+// the verification key, for example by Kid.
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Keyfunc returns the key that verifies a token with the given header: a
+// []byte secret for HS256, HS384 and HS512, an *rsa.PublicKey for RS* and
+// PS*, an *ecdsa.PublicKey for ES* and an ed25519.PublicKey for EdDSA. A
+// returned *ws.ValidationError sets the status of the rejected upgrade;
+// other errors reject it with 401.
+type Keyfunc func(Header) (any, error)
+
+// StaticKey returns a Keyfunc that verifies every token with key.
+func StaticKey(key any) Keyfunc {
+	return func(Header) (any, error) { return key, nil }
+}
+
+// TokenSource extracts a token from an upgrade request, returning "" when
+// the request carries none.
+type TokenSource func(*http.Request) string
+
+// FromAuthorizationHeader reads a bearer token from the Authorization
+// header.
+func FromAuthorizationHeader() TokenSource {
+	return func(r *http.Request) string {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+}
+
+// FromQuery reads the token from the named query parameter, for browser
+// clients, which cannot set headers on a websocket upgrade.
+func FromQuery(name string) TokenSource {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// FromCookie reads the token from the named cookie.
+func FromCookie(name string) TokenSource {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// JWTValidator is a ws.SessionValidator that accepts upgrades carrying a
+// signed JWT. The token's sub becomes the ClientID, its exp the session's
+// ExpiresAt, and the configured claims are copied into the SessionInfo. It
+// also implements ws.TokenRefresher, so clients can push a fresh token over
+// the open connection before the old one expires.
+type JWTValidator struct {
+	keyfunc    Keyfunc
+	sources    []TokenSource
+	algorithms map[string]bool
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	now        func() time.Time
+	namespace  uuid.UUID
+	rolesClaim string
+	claims     []string
+}
+
+// Option configures a JWTValidator.
+type Option func(*JWTValidator)
+
+// WithTokenSources replaces where tokens are looked for; the first source
+// returning a token wins. The default is the Authorization header, then the
+// "token" query parameter.
+func WithTokenSources(sources ...TokenSource) Option {
+	return func(v *JWTValidator) {
+		v.sources = sources
+	}
+}
+
+// WithAlgorithms restricts the accepted signing algorithms. By default any
+// supported algorithm matching the type of the key is accepted.
+func WithAlgorithms(algs ...string) Option {
+	return func(v *JWTValidator) {
+		v.algorithms = make(map[string]bool, len(algs))
+		for _, alg := range algs {
+			v.algorithms[alg] = true
+		}
+	}
+}
+
+// WithIssuer requires the token's iss to equal issuer.
+func WithIssuer(issuer string) Option {
+	return func(v *JWTValidator) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the token's aud to name audience.
+func WithAudience(audience string) Option {
+	return func(v *JWTValidator) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway allows for clock skew when checking exp and nbf.
+func WithLeeway(d time.Duration) Option {
+	return func(v *JWTValidator) {
+		v.leeway = d
+	}
+}
+
+// WithClock sets the clock exp and nbf are checked against.
+func WithClock(clock ws.Clock) Option {
+	return func(v *JWTValidator) {
+		v.now = clock.Now
+	}
+}
+
+// WithNamespace sets the namespace subjects that are not UUIDs are derived
+// in with ws.IdentityFromString. It defaults to ws.DefaultIdentityNamespace;
+// give each identity provider its own.
+func WithNamespace(namespace uuid.UUID) Option {
+	return func(v *JWTValidator) {
+		v.namespace = namespace
+	}
+}
+
+// WithRolesClaim sets the claim, a string or an array of strings, read into
+// SessionInfo.Roles.
+func WithRolesClaim(name string) Option {
+	return func(v *JWTValidator) {
+		v.rolesClaim = name
+	}
+}
+
+// WithClaims copies the named claims, when present, into
+// SessionInfo.Claims.
+func WithClaims(names ...string) Option {
+	return func(v *JWTValidator) {
+		v.claims = append(v.claims, names...)
+	}
+}
+
+// NewJWTValidator returns a JWTValidator verifying tokens with the keys
+// keyfunc returns.
+func NewJWTValidator(keyfunc Keyfunc, opts ...Option) *JWTValidator {
+	v := &JWTValidator{
+		keyfunc:   keyfunc,
+		sources:   []TokenSource{FromAuthorizationHeader(), FromQuery("token")},
+		now:       time.Now,
+		namespace: ws.DefaultIdentityNamespace,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Validate implements ws.SessionValidator.
+func (v *JWTValidator) Validate(r *http.Request) (ws.SessionInfo, error) {
+	session, _, err := v.ValidateWithResponse(r)
+	return session, err
+}
+
+// ValidateWithResponse implements ws.ResponseValidator, adding the
+// WWW-Authenticate challenge to rejected upgrades. Errors are
+// *ws.ValidationError values wrapping one of the package's errors.
+func (v *JWTValidator) ValidateWithResponse(r *http.Request) (ws.SessionInfo, http.Header, error) {
+	var token string
+	for _, source := range v.sources {
+		if token = source(r); token != "" {
+			break
+		}
+	}
+	session, err := v.ValidateToken(token)
+	if err != nil {
+		return ws.SessionInfo{}, challenge(err), err
+	}
+	return session, nil, nil
+}
+
+// Refresh implements ws.TokenRefresher.
+func (v *JWTValidator) Refresh(_ *ws.Client, token string) (ws.SessionInfo, error) {
+	return v.ValidateToken(token)
+}
+
+// ValidateToken verifies token and returns the session it grants.
+func (v *JWTValidator) ValidateToken(token string) (ws.SessionInfo, error) {
+	if token == "" {
+		return ws.SessionInfo{}, reject(http.StatusUnauthorized, ErrTokenMissing)
+	}
+	claims, err := v.verify(token)
+	if err != nil {
+		return ws.SessionInfo{}, err
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return ws.SessionInfo{}, reject(http.StatusUnauthorized, err)
+	}
+	return v.session(claims)
+}
+
+// verify checks the token's signature and returns its decoded claims.
+func (v *JWTValidator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, reject(http.StatusBadRequest, ErrTokenMalformed)
+	}
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, reject(http.StatusBadRequest, err)
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, reject(http.StatusBadRequest, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, fmt.Errorf("%w: signature: %v", ErrTokenMalformed, err))
+	}
+
+	if v.algorithms != nil && !v.algorithms[header.Alg] {
+		return nil, reject(http.StatusUnauthorized, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, header.Alg))
+	}
+	key, err := v.keyfunc(header)
+	if err != nil {
+		var verr *ws.ValidationError
+		if errors.As(err, &verr) {
+			return nil, err
+		}
+		return nil, reject(http.StatusUnauthorized, err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, reject(http.StatusUnauthorized, err)
+	}
+	return claims, nil
+}
+
+// checkClaims validates the registered claims the validator enforces.
+func (v *JWTValidator) checkClaims(claims map[string]any) error {
+	now := v.now()
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(v.leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("%w: %q", ErrInvalidIssuer, iss)
+		}
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// session builds the SessionInfo a verified token grants.
+func (v *JWTValidator) session(claims map[string]any) (ws.SessionInfo, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return ws.SessionInfo{}, reject(http.StatusUnauthorized, ErrInvalidSubject)
+	}
+	id, err := ws.ParseIdentity(sub)
+	if err != nil {
+		id = ws.IdentityFromString(v.namespace, sub)
+	}
+
+	session := ws.SessionInfo{
+		ClientID: id,
+		Metadata: map[string]string{"sub": sub},
+	}
+	if exp, ok, _ := numericDate(claims, "exp"); ok {
+		session.ExpiresAt = exp
+	}
+	if v.rolesClaim != "" {
+		session.Roles = stringList(claims[v.rolesClaim])
+	}
+	for _, name := range v.claims {
+		value, ok := claims[name]
+		if !ok {
+			continue
+		}
+		if session.Claims == nil {
+			session.Claims = make(map[string]any, len(v.claims))
+		}
+		session.Claims[name] = value
+	}
+	return session, nil
+}
+
+func reject(status int, err error) error {
+	return &ws.ValidationError{Status: status, Err: err}
+}
+
+// challenge returns the RFC 6750 WWW-Authenticate header for err.
+func challenge(err error) http.Header {
+	value := "Bearer"
+	var verr *ws.ValidationError
+	switch {
+	case errors.Is(err, ErrTokenMissing):
+	case errors.As(err, &verr) && verr.Status == http.StatusBadRequest:
+		value += ` error="invalid_request"`
+	default:
+		value += ` error="invalid_token"`
+	}
+	return http.Header{"Www-Authenticate": {value}}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim, reporting whether it is present.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrTokenMalformed, name)
+	}
+	whole := int64(seconds)
+	return time.Unix(whole, int64((seconds-float64(whole))*1e9)), true, nil
+}
+
+func hasAudience(aud any, audience string) bool {
+	for _, a := range stringList(aud) {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList reads a claim that is a string or an array of strings.
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package wsauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+var algorithmHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks signature over signed with key. The algorithm
+// family must match the key's type, so a token cannot pick HMAC to be
+// checked against a public key used as a secret.
+func verifySignature(alg string, key any, signed string, signature []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return keyMismatch(alg, key)
+		}
+		if !ed25519.Verify(pub, []byte(signed), signature) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	}
+
+	hash, ok := algorithmHashes[alg]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return keyMismatch(alg, key)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signed))
+		valid = hmac.Equal(mac.Sum(nil), signature)
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return keyMismatch(alg, key)
+		}
+		valid = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return keyMismatch(alg, key)
+		}
+		valid = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return keyMismatch(alg, key)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrSignatureInvalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	}
+	if !valid {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func keyMismatch(alg string, key any) error {
+	return fmt.Errorf("%w: %s cannot be verified with a %T key", ErrUnsupportedAlgorithm, alg, key)
+}
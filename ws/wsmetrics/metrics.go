@@ -0,0 +1,281 @@
+// Package wsmetrics exports a WebsocketHandler's connection, message and
+// error metrics to Prometheus:
+//
+//	handler := ws.NewWebSocketHandler(validator, messages, persister,
+//		wsmetrics.WithMetrics(prometheus.DefaultRegisterer))
+//
+// The metrics, under the "ws" namespace by default, are:
+//
+//	ws_connections                      open connections
+//	ws_room_connections{room}           connections in each room
+//	ws_messages_received_total          data frames read from clients
+//	ws_messages_sent_total              data frames written to clients
+//	ws_received_bytes_total             bytes read, before decompression
+//	ws_sent_bytes_total                 bytes written, before compression
+//	ws_dropped_messages_total           messages dropped on full send queues
+//	ws_handler_duration_seconds{type}   MessageHandler.Handle duration
+//	ws_errors_total{category}           failed messages, by category
+//	ws_upgrade_rejections_total{reason} refused upgrades, by reason
+//
+// Handler durations are labeled by envelope type, with "unknown" for types
+// the router has no handler for and "" for frames that are not envelopes,
+// so clients cannot grow the label set at will through a Router.
+package wsmetrics
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oduortoni/websocket/ws"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Error categories of ws_errors_total.
+const (
+	CategoryHandler        = "handler"
+	CategoryPanic          = "panic"
+	CategoryInvalidPayload = "invalid_payload"
+	CategoryUnknownType    = "unknown_type"
+	CategoryMalformed      = "malformed"
+	CategoryRateLimited    = "rate_limited"
+	CategoryOverloaded     = "overloaded"
+	CategoryDenied         = "denied"
+	CategoryPersist        = "persist"
+	CategoryDelivery       = "delivery"
+)
+
+// Rejection reasons of ws_upgrade_rejections_total.
+const (
+	ReasonCapacity     = "capacity"
+	ReasonShuttingDown = "shutting_down"
+	ReasonOrigin       = "origin"
+	ReasonSubprotocol  = "subprotocol"
+	ReasonTimeout      = "timeout"
+	ReasonBanned       = "banned"
+	ReasonHandshake    = "handshake"
+	ReasonUnauthorized = "unauthorized"
+)
+
+// Option configures a Collector.
+type Option func(*options)
+
+type options struct {
+	namespace   string
+	constLabels prometheus.Labels
+	buckets     []float64
+}
+
+// WithNamespace replaces the "ws" metric namespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithConstLabels adds labels to every metric, such as the handler's name
+// when several share a registry.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// WithBuckets sets the handler duration histogram's buckets, in seconds.
+// The default is prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithMetrics registers a new Collector with registry and returns the
+// option that feeds it a handler's events. It panics if registration fails,
+// as prometheus.MustRegister does.
+func WithMetrics(registry prometheus.Registerer, opts ...Option) ws.Option {
+	c := NewCollector(opts...)
+	registry.MustRegister(c)
+	return ws.WithObserver(c.Observer())
+}
+
+// Collector is a prometheus.Collector for one handler's metrics, fed by the
+// ws.Observer it returns from Observer.
+type Collector struct {
+	connections   prometheus.Gauge
+	received      prometheus.Counter
+	sent          prometheus.Counter
+	receivedBytes prometheus.Counter
+	sentBytes     prometheus.Counter
+	dropped       prometheus.Counter
+	duration      *prometheus.HistogramVec
+	errors        *prometheus.CounterVec
+	rejections    *prometheus.CounterVec
+
+	roomDesc *prometheus.Desc
+	mu       sync.Mutex
+	rooms    map[string]int
+}
+
+// NewCollector returns a Collector with every metric at zero.
+func NewCollector(opts ...Option) *Collector {
+	o := options{namespace: "ws", buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: o.namespace, Name: name, Help: help, ConstLabels: o.constLabels,
+		})
+	}
+	return &Collector{
+		connections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: o.namespace, Name: "connections", Help: "Open connections.", ConstLabels: o.constLabels,
+		}),
+		received:      counter("messages_received_total", "Data frames read from clients."),
+		sent:          counter("messages_sent_total", "Data frames written to clients."),
+		receivedBytes: counter("received_bytes_total", "Bytes of data frames read from clients."),
+		sentBytes:     counter("sent_bytes_total", "Bytes of data frames written to clients, before compression."),
+		dropped:       counter("dropped_messages_total", "Messages dropped because a client's send queue was full."),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace, Name: "handler_duration_seconds", Help: "Time spent handling messages, by envelope type.",
+			ConstLabels: o.constLabels, Buckets: o.buckets,
+		}, []string{"type"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace, Name: "errors_total", Help: "Messages that failed, by category.", ConstLabels: o.constLabels,
+		}, []string{"category"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace, Name: "upgrade_rejections_total", Help: "Refused upgrades, by reason.", ConstLabels: o.constLabels,
+		}, []string{"reason"}),
+		roomDesc: prometheus.NewDesc(prometheus.BuildFQName(o.namespace, "", "room_connections"),
+			"Connections in each room.", []string{"room"}, o.constLabels),
+		rooms: make(map[string]int),
+	}
+}
+
+// Observer returns the hooks that feed the collector. Register them with
+// ws.WithObserver on exactly one handler.
+func (c *Collector) Observer() ws.Observer {
+	return ws.Observer{
+		OnConnect:    func(*ws.Client) { c.connections.Inc() },
+		OnDisconnect: func(*ws.Client, error) { c.connections.Dec() },
+		OnReject: func(_ *http.Request, status int, err error) {
+			c.rejections.WithLabelValues(rejectionReason(status, err)).Inc()
+		},
+		OnReceive: func(_ *ws.Client, size int) {
+			c.received.Inc()
+			c.receivedBytes.Add(float64(size))
+		},
+		OnSend: func(_ *ws.Client, size int) {
+			c.sent.Inc()
+			c.sentBytes.Add(float64(size))
+		},
+		OnHandled: c.handled,
+		OnDrop:    func(*ws.Client) { c.dropped.Inc() },
+		OnError: func(_ *ws.Client, err error) {
+			c.errors.WithLabelValues(errorCategory(err)).Inc()
+		},
+		OnJoin:  func(room string, _ *ws.Client) { c.moveRoom(room, 1) },
+		OnLeave: func(room string, _ *ws.Client) { c.moveRoom(room, -1) },
+	}
+}
+
+func (c *Collector) handled(_ *ws.Client, msgType string, elapsed time.Duration, err error) {
+	if errors.Is(err, ws.ErrUnknownMessageType) {
+		msgType = "unknown"
+	}
+	c.duration.WithLabelValues(msgType).Observe(elapsed.Seconds())
+	if err != nil {
+		c.errors.WithLabelValues(handlerCategory(err)).Inc()
+	}
+}
+
+func (c *Collector) moveRoom(room string, delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms[room] += delta; c.rooms[room] <= 0 {
+		delete(c.rooms, room)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics() {
+		m.Describe(ch)
+	}
+	ch <- c.roomDesc
+}
+
+// Collect implements prometheus.Collector. Rooms without connections are
+// not reported.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics() {
+		m.Collect(ch)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for room, n := range c.rooms {
+		ch <- prometheus.MustNewConstMetric(c.roomDesc, prometheus.GaugeValue, float64(n), room)
+	}
+}
+
+func (c *Collector) metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.connections, c.received, c.sent, c.receivedBytes, c.sentBytes,
+		c.dropped, c.duration, c.errors, c.rejections,
+	}
+}
+
+func handlerCategory(err error) string {
+	var panicked *ws.PanicError
+	switch {
+	case errors.As(err, &panicked), errors.Is(err, ws.ErrHandlerPanic):
+		return CategoryPanic
+	case errors.Is(err, ws.ErrInvalidPayload):
+		return CategoryInvalidPayload
+	case errors.Is(err, ws.ErrUnknownMessageType):
+		return CategoryUnknownType
+	case errors.Is(err, ws.ErrMalformedMessage):
+		return CategoryMalformed
+	default:
+		return CategoryHandler
+	}
+}
+
+func errorCategory(err error) string {
+	switch {
+	case errors.Is(err, ws.ErrRateLimited):
+		return CategoryRateLimited
+	case errors.Is(err, ws.ErrOverloaded):
+		return CategoryOverloaded
+	case errors.Is(err, ws.ErrMessageDenied):
+		return CategoryDenied
+	case errors.Is(err, ws.ErrDeliveryFailed):
+		return CategoryDelivery
+	default:
+		return CategoryPersist
+	}
+}
+
+func rejectionReason(status int, err error) string {
+	switch {
+	case errors.Is(err, ws.ErrTooManyConnections), errors.Is(err, ws.ErrTooManyConnectionsPerIP):
+		return ReasonCapacity
+	case errors.Is(err, ws.ErrShuttingDown):
+		return ReasonShuttingDown
+	case errors.Is(err, ws.ErrOriginNotAllowed):
+		return ReasonOrigin
+	case errors.Is(err, ws.ErrUnsupportedSubprotocol):
+		return ReasonSubprotocol
+	case errors.Is(err, ws.ErrHandshakeTimeout):
+		return ReasonTimeout
+	case errors.Is(err, ws.ErrBanned):
+		return ReasonBanned
+	case status == 0:
+		return ReasonHandshake
+	default:
+		return ReasonUnauthorized
+	}
+}
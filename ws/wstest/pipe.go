@@ -0,0 +1,159 @@
+package wstest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// pipePorts numbers the fake remote ports of pipe connections.
+var pipePorts atomic.Int32
+
+// pipeListener is a net.Listener whose connections are made by dial.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// dial hands the server end of a new pipe to Accept and returns the client
+// end.
+func (l *pipeListener) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	server, client := net.Pipe()
+	port := int(pipePorts.Add(1))
+	server = &addrConn{
+		Conn:   server,
+		local:  l.Addr(),
+		remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+	}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// addrConn gives a pipe end socket-like addresses.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// servePipe serves handler on a pipe listener until the test ends.
+func servePipe(t testing.TB, handler http.Handler) *pipeListener {
+	listener := newPipeListener()
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener
+}
+
+// pipeDialer returns a copy of d dialing through listener.
+func pipeDialer(d *websocket.Dialer, listener *pipeListener) *websocket.Dialer {
+	dialer := *d
+	dialer.NetDial = nil
+	dialer.NetDialContext = listener.dial
+	dialer.NetDialTLSContext = nil
+	dialer.Proxy = nil
+	return &dialer
+}
+
+// ConnectPipe serves handler over an in-memory net.Pipe and connects to it,
+// so a WebsocketHandler's read loop, write pump and codecs can be tested
+// without a TCP listener, in environments without networking or by tests
+// opening many connections. The upgrade request is for ws://pipe/, and the
+// server sees a loopback remote address with a distinct port per
+// connection; options are those of Connect.
+//
+// A pipe differs from a socket in ways a test can notice:
+//
+//   - It has no buffer. A write returns only once the other end has read
+//     it, so a peer that stops reading stalls the writer at once, where a
+//     socket would absorb some data first; send queues back up sooner.
+//   - Frames arrive in exactly the order written, with no latency, which
+//     makes ordering deterministic but can hide races a network would
+//     expose.
+//   - Deadlines behave as on sockets: once one passes, the pending read or
+//     write fails with an error satisfying os.ErrDeadlineExceeded.
+func ConnectPipe(t testing.TB, handler http.Handler, opts ...Option) *Conn {
+	t.Helper()
+	return NewPipeServer(t, handler).Connect(t, opts...)
+}
+
+// PipeServer serves a handler over in-memory pipes, for tests connecting
+// several clients to it. See ConnectPipe.
+type PipeServer struct {
+	listener *pipeListener
+}
+
+// NewPipeServer starts serving handler and stops when the test ends.
+func NewPipeServer(t testing.TB, handler http.Handler) *PipeServer {
+	return &PipeServer{listener: servePipe(t, handler)}
+}
+
+// Connect opens a connection to the server over a new pipe.
+func (s *PipeServer) Connect(t testing.TB, opts ...Option) *Conn {
+	t.Helper()
+	opts = append(opts, func(o *options) { o.dialer = pipeDialer(o.dialer, s.listener) })
+	return Connect(t, "ws://pipe/", opts...)
+}
+
+// NewConnPair returns the two ends of a websocket connection over an
+// in-memory pipe, with the handshake already done, for testing code that
+// works on a *websocket.Conn directly. The caveats of ConnectPipe apply.
+// Both ends are closed when the test ends.
+func NewConnPair(t testing.TB) (server, client *websocket.Conn) {
+	t.Helper()
+	upgraded := make(chan *websocket.Conn, 1)
+	listener := servePipe(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		upgraded <- conn
+	}))
+
+	client, _, err := pipeDialer(websocket.DefaultDialer, listener).Dial("ws://pipe/", nil)
+	if err != nil {
+		t.Fatalf("wstest: pipe handshake: %v", err)
+	}
+	server = <-upgraded
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
@@ -0,0 +1,330 @@
+// Package wstest provides a test server and client for code built on package
+// ws, in the manner of net/http/httptest:
+//
+//	func TestGreeting(t *testing.T) {
+//		server := wstest.NewServer(t, ws.NewWebSocketHandler(validator, messages, nil))
+//		conn := server.Connect(t)
+//
+//		conn.MustSendJSON(map[string]any{"type": "hello"})
+//		env := conn.ExpectEnvelope("welcome", time.Second)
+//		...
+//	}
+//
+// Every helper fails the test through t with a description of what arrived
+// instead, and everything started is shut down by t.Cleanup.
+package wstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oduortoni/websocket/ws"
+)
+
+// DefaultTimeout bounds writes and ExpectClose unless WithTimeout says
+// otherwise.
+const DefaultTimeout = 2 * time.Second
+
+// historySize is how many recent frames a Conn quotes in its failures.
+const historySize = 5
+
+// Server is an httptest.Server serving a websocket handler.
+type Server struct {
+	*httptest.Server
+}
+
+// NewServer starts a server for handler and closes it when the test ends.
+func NewServer(t testing.TB, handler http.Handler) *Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Server{Server: server}
+}
+
+// WSURL returns the server's URL with the ws scheme.
+func (s *Server) WSURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Connect dials the server; see the package-level Connect.
+func (s *Server) Connect(t testing.TB, opts ...Option) *Conn {
+	t.Helper()
+	return Connect(t, s.WSURL(), opts...)
+}
+
+// Option configures a Conn.
+type Option func(*options)
+
+type options struct {
+	header  http.Header
+	dialer  *websocket.Dialer
+	timeout time.Duration
+}
+
+// WithHeader adds a header to the upgrade request, such as the credentials
+// the handler's SessionValidator expects.
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithSubprotocols requests the given subprotocols, in order of preference.
+func WithSubprotocols(protocols ...string) Option {
+	return func(o *options) {
+		o.header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+// WithDialer dials with d instead of websocket.DefaultDialer.
+func WithDialer(d *websocket.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithTimeout replaces DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Conn is a test client connection. The embedded websocket.Conn may be used
+// directly, but once an Expect method has been called the Conn reads the
+// connection itself, so further frames must be read through Expect methods.
+// Like websocket.Conn, a Conn must be used from one goroutine at a time, and
+// its helpers, which call t.Fatal, from the test's goroutine.
+type Conn struct {
+	*websocket.Conn
+
+	// Response is the server's response to the upgrade request.
+	Response *http.Response
+
+	t       testing.TB
+	timeout time.Duration
+
+	frames  chan frame
+	done    chan struct{}
+	history []string
+
+	mu  sync.Mutex
+	err error
+}
+
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+// Connect dials url and closes the connection when the test ends. It fails
+// the test, quoting the server's response, if the upgrade is refused.
+func Connect(t testing.TB, url string, opts ...Option) *Conn {
+	t.Helper()
+	o := options{header: http.Header{}, dialer: websocket.DefaultDialer, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	conn, resp, err := o.dialer.Dial(url, o.header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			t.Fatalf("wstest: dial %s: %v (%s: %q)", url, err, resp.Status, body)
+		}
+		t.Fatalf("wstest: dial %s: %v", url, err)
+	}
+
+	c := &Conn{Conn: conn, Response: resp, t: t, timeout: o.timeout, done: make(chan struct{})}
+	t.Cleanup(func() {
+		close(c.done)
+		conn.Close()
+	})
+	return c
+}
+
+// MustSend writes data as a text frame.
+func (c *Conn) MustSend(data []byte) {
+	c.t.Helper()
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		c.t.Fatalf("wstest: send %s: %v", quote(data), err)
+	}
+}
+
+// MustSendJSON writes v as a JSON text frame.
+func (c *Conn) MustSendJSON(v any) {
+	c.t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatalf("wstest: marshal %T: %v", v, err)
+	}
+	c.MustSend(data)
+}
+
+// MustSendEnvelope writes an envelope frame of msgType carrying payload as
+// JSON.
+func (c *Conn) MustSendEnvelope(msgType string, payload any) {
+	c.t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatalf("wstest: marshal %T: %v", payload, err)
+	}
+	c.MustSendJSON(map[string]any{"type": msgType, "payload": json.RawMessage(data)})
+}
+
+// ExpectMessage returns the next data frame, failing the test if none
+// arrives within timeout or the connection closes first.
+func (c *Conn) ExpectMessage(timeout time.Duration) []byte {
+	c.t.Helper()
+	f, ok := c.next(timeout)
+	if !ok {
+		c.fail("expected a message within %v", timeout)
+	}
+	return f.data
+}
+
+// ExpectJSON decodes the next data frame into v.
+func (c *Conn) ExpectJSON(v any, timeout time.Duration) {
+	c.t.Helper()
+	data := c.ExpectMessage(timeout)
+	if err := json.Unmarshal(data, v); err != nil {
+		c.t.Fatalf("wstest: decode %s into %T: %v", quote(data), v, err)
+	}
+}
+
+// ExpectEnvelope returns the next envelope of msgType, skipping frames of
+// other types, such as acks, along the way.
+func (c *Conn) ExpectEnvelope(msgType string, timeout time.Duration) ws.Envelope {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		f, ok := c.next(time.Until(deadline))
+		if !ok {
+			c.fail("expected a %q envelope within %v", msgType, timeout)
+		}
+		var env ws.Envelope
+		if json.Unmarshal(f.data, &env) == nil && env.Type == msgType {
+			return env
+		}
+	}
+}
+
+// ExpectNoMessage fails the test if a data frame arrives within d.
+func (c *Conn) ExpectNoMessage(d time.Duration) {
+	c.t.Helper()
+	if f, ok := c.next(d); ok {
+		c.t.Fatalf("wstest: expected no message within %v, got %s", d, quote(f.data))
+	}
+}
+
+// ExpectClose waits for the server to close the connection and fails the
+// test unless it closed with code. It returns the close reason. Data frames
+// arriving first are discarded.
+func (c *Conn) ExpectClose(code int) string {
+	c.t.Helper()
+	deadline := time.Now().Add(c.timeout)
+	for {
+		if _, ok := c.next(time.Until(deadline)); ok {
+			continue
+		}
+		err := c.readErr()
+		var closeErr *websocket.CloseError
+		switch {
+		case err == nil:
+			c.fail("expected close %d within %v", code, c.timeout)
+		case !errors.As(err, &closeErr):
+			c.fail("expected close %d, connection failed", code)
+		case closeErr.Code != code:
+			c.fail("expected close %d, got %d %q", code, closeErr.Code, closeErr.Text)
+		}
+		return closeErr.Text
+	}
+}
+
+// next returns the next data frame, or false on timeout or once the
+// connection has failed, with the failure in readErr.
+func (c *Conn) next(timeout time.Duration) (frame, bool) {
+	if c.frames == nil {
+		c.frames = make(chan frame)
+		go c.read()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case f, ok := <-c.frames:
+		if ok {
+			c.remember(f.data)
+		}
+		return f, ok
+	case <-timer.C:
+		return frame{}, false
+	}
+}
+
+// read feeds frames to next until the connection fails or the test ends.
+func (c *Conn) read() {
+	defer close(c.frames)
+	for {
+		messageType, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		select {
+		case c.frames <- frame{messageType: messageType, data: data}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) readErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Conn) remember(data []byte) {
+	c.history = append(c.history, quote(data))
+	if len(c.history) > historySize {
+		c.history = c.history[1:]
+	}
+}
+
+// fail ends the test with msg, the connection's error and the last frames
+// received.
+func (c *Conn) fail(format string, args ...any) {
+	c.t.Helper()
+	msg := fmt.Sprintf("wstest: "+format, args...)
+	if err := c.readErr(); err != nil {
+		msg += fmt.Sprintf("; connection error: %v", err)
+	}
+	if len(c.history) == 0 {
+		msg += "; nothing received"
+	} else {
+		msg += fmt.Sprintf("; last received: %s", strings.Join(c.history, ", "))
+	}
+	c.t.Fatal(msg)
+}
+
+// quote renders a frame for a failure message, truncating long ones.
+func quote(data []byte) string {
+	const max = 200
+	if len(data) > max {
+		return fmt.Sprintf("%q... (%d bytes)", data[:max], len(data))
+	}
+	return fmt.Sprintf("%q", data)
+}